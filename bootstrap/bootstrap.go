@@ -0,0 +1,181 @@
+// Copyright 2023 The Kubegems Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bootstrap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/spf13/pflag"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+	"kubegems.io/library/config"
+	"kubegems.io/library/log"
+	"kubegems.io/library/pprof"
+	"kubegems.io/library/rest/api"
+	"kubegems.io/library/rest/listen"
+	"kubegems.io/library/rest/prommetrics"
+)
+
+// Options are the common options of a service, the fields without json "-" are registered as flags,
+// which can be set from the config file and environment too, see config.Parse.
+type Options struct {
+	Listen      string     `json:"listen,omitempty" description:"listen address of the api"`
+	TLS         TLSOptions `json:"tls,omitempty"`
+	DebugListen string     `json:"debugListen,omitempty" description:"listen address of pprof, metrics and the reload endpoint, empty disables"`
+	LogLevel    string     `json:"logLevel,omitempty" description:"log level, e.g. debug, info or error"`
+	Metrics     bool       `json:"metrics,omitempty" description:"record the http metrics of routes, served at /metrics of the debug listener"`
+
+	Name           string                    `json:"-"` // service name, used as the logger name
+	Config         any                       `json:"-"` // pointer to the service options, registered as flags along with the common options
+	Filters        api.StandardFilterOptions `json:"-"` // the logger is set by Run
+	Plugins        []api.Plugin              `json:"-"`
+	TracerProvider trace.TracerProvider      `json:"-"` // set as the global tracer provider, nil keeps the global one
+	Registry       *prometheus.Registry      `json:"-"` // registers and serves the metrics, nil uses the prometheus default registry
+}
+
+type TLSOptions struct {
+	CertFile           string `json:"certFile,omitempty" description:"serving certificate, empty serves http"`
+	KeyFile            string `json:"keyFile,omitempty" description:"serving private key"`
+	ClientCAFile       string `json:"clientCAFile,omitempty" description:"CAs to verify client certificates, empty disables mutual tls"`
+	ClientCertOptional bool   `json:"clientCertOptional,omitempty" description:"allow clients without certificates"`
+}
+
+func NewDefaultOptions(name string) *Options {
+	return &Options{
+		Name:     name,
+		Listen:   ":8080",
+		LogLevel: "info",
+		Metrics:  true,
+	}
+}
+
+// Run is the entrypoint of a service:
+//
+//  1. parses the flags, config file and environment into opts and opts.Config
+//  2. sets up logging, metrics and tracing, and the config.Reloader reloading on SIGHUP
+//  3. calls setup to register routes, setup may also complete opts.Filters, e.g. the authenticator depends on the config
+//  4. serves the api with the standard filter chain and the debug endpoints until ctx done, SIGINT or SIGTERM
+func Run(ctx context.Context, opts *Options, setup func(a *api.API) error) error {
+	fs := pflag.NewFlagSet(opts.Name, pflag.ContinueOnError)
+	config.AutoRegisterFlags(fs, "", opts)
+	if opts.Config != nil {
+		config.AutoRegisterFlags(fs, "", opts.Config)
+	}
+	if err := config.Parse(fs); err != nil {
+		return err
+	}
+	if opts.LogLevel != "" {
+		if err := log.AtomicLevel.UnmarshalText([]byte(opts.LogLevel)); err != nil {
+			return fmt.Errorf("invalid log level %s: %w", opts.LogLevel, err)
+		}
+	}
+	logger := log.Logger
+	if opts.Name != "" {
+		logger = logger.WithName(opts.Name)
+	}
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	reloader := config.NewReloader()
+	ctx = config.WithReloader(logr.NewContext(ctx, logger), reloader)
+
+	if opts.TracerProvider != nil {
+		otel.SetTracerProvider(opts.TracerProvider)
+	}
+	var registerer prometheus.Registerer = prometheus.DefaultRegisterer
+	var gatherer prometheus.Gatherer = prometheus.DefaultGatherer
+	if opts.Registry != nil {
+		registerer, gatherer = opts.Registry, opts.Registry
+	}
+	plugins := opts.Plugins
+	if opts.Metrics {
+		metrics, err := prommetrics.NewMetrics(&prommetrics.Options{Registerer: registerer})
+		if err != nil {
+			return fmt.Errorf("register metrics: %w", err)
+		}
+		plugins = append(plugins[:len(plugins):len(plugins)], &prommetrics.Plugin{Metrics: metrics})
+	}
+	// the tracing plugin is installed after the others to trace the filters they add
+	a := api.NewAPI().Plugin(plugins...).
+		Plugin(api.OpenTelemetryPlugin{TraceProvider: opts.TracerProvider, Debug: opts.Filters.DebugTracing})
+	if err := setup(a); err != nil {
+		return err
+	}
+	a.Freeze()
+
+	filters := opts.Filters
+	filters.Logger = logger
+	handler := api.NewStandardFilterChain(filters).Handler(a.Build())
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	tasks := []func(ctx context.Context) error{
+		reloader.Run,
+		func(ctx context.Context) error {
+			return serveAPI(ctx, opts, handler)
+		},
+	}
+	if opts.DebugListen != "" {
+		tasks = append(tasks, func(ctx context.Context) error {
+			return listen.ServeHTTPContext(ctx, opts.DebugListen, debugHandler(reloader, gatherer))
+		})
+	}
+	return runAll(ctx, cancel, tasks)
+}
+
+func serveAPI(ctx context.Context, opts *Options, handler http.Handler) error {
+	if opts.TLS.ClientCAFile != "" {
+		return listen.ServeMutualTLSContext(ctx, opts.Listen, handler,
+			opts.TLS.CertFile, opts.TLS.KeyFile, opts.TLS.ClientCAFile, opts.TLS.ClientCertOptional)
+	}
+	return listen.ServeContext(ctx, opts.Listen, handler, opts.TLS.CertFile, opts.TLS.KeyFile)
+}
+
+// debugHandler serves pprof under /debug/, the metrics at /metrics and the reload endpoint POST /debug/reload.
+func debugHandler(reloader *config.Reloader, gatherer prometheus.Gatherer) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/debug/", pprof.Handler())
+	mux.Handle("/metrics", promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{}))
+	mux.Handle("/debug/reload", reloader)
+	return mux
+}
+
+// runAll runs tasks until all of them returned, the others are canceled once any returned,
+// the first error is returned, servers closed on cancel are not errors.
+func runAll(ctx context.Context, cancel context.CancelFunc, tasks []func(ctx context.Context) error) error {
+	errs := make(chan error, len(tasks))
+	for _, task := range tasks {
+		go func(task func(ctx context.Context) error) {
+			errs <- task(ctx)
+		}(task)
+	}
+	var first error
+	for range tasks {
+		err := <-errs
+		cancel()
+		if first == nil && err != nil && !errors.Is(err, http.ErrServerClosed) {
+			first = err
+		}
+	}
+	return first
+}
@@ -0,0 +1,141 @@
+package bootstrap
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"kubegems.io/library/rest/api"
+)
+
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// get retries until the server started.
+func get(t *testing.T, url string) string {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		resp, err := http.Get(url)
+		if err == nil {
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			return string(body)
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("GET %s: %v", url, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRun(t *testing.T) {
+	listen, debuglisten := freeAddr(t), freeAddr(t)
+	args := os.Args
+	defer func() { os.Args = args }()
+	os.Args = []string{"zoo", "--listen=" + listen, "--debuglisten=" + debuglisten}
+
+	config := &struct {
+		Greeting string `json:"greeting,omitempty"`
+	}{Greeting: "hello"}
+	opts := NewDefaultOptions("zoo")
+	opts.Config = config
+	opts.Registry = prometheus.NewRegistry()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- Run(ctx, opts, func(a *api.API) error {
+			a.Route(api.GET("/greeting").To(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(config.Greeting))
+			}))
+			return nil
+		})
+	}()
+
+	if body := get(t, "http://"+listen+"/greeting"); body != "hello" {
+		t.Errorf("GET /greeting = %q", body)
+	}
+	if metrics := get(t, "http://"+debuglisten+"/metrics"); !strings.Contains(metrics, `http_requests_total{code="200",method="GET",route="/greeting"} 1`) {
+		t.Errorf("route metrics not served:\n%s", metrics)
+	}
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Run() = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run() not returned after ctx done")
+	}
+}
+
+func TestRunSetupError(t *testing.T) {
+	args := os.Args
+	defer func() { os.Args = args }()
+	os.Args = []string{"zoo", "--listen=" + freeAddr(t)}
+
+	opts := NewDefaultOptions("zoo")
+	opts.Registry = prometheus.NewRegistry()
+	errSetup := errors.New("setup failed")
+	if err := Run(context.Background(), opts, func(a *api.API) error { return errSetup }); !errors.Is(err, errSetup) {
+		t.Errorf("Run() = %v, want %v", err, errSetup)
+	}
+}
+
+func TestRunAll(t *testing.T) {
+	errFailed := errors.New("failed")
+	waitCanceled := func(ctx context.Context) error {
+		<-ctx.Done()
+		return http.ErrServerClosed
+	}
+	tests := []struct {
+		name    string
+		tasks   []func(ctx context.Context) error
+		wantErr error
+	}{
+		{
+			name:  "canceled",
+			tasks: []func(ctx context.Context) error{waitCanceled, waitCanceled},
+		},
+		{
+			name:    "one failed cancels the others",
+			tasks:   []func(ctx context.Context) error{waitCanceled, func(ctx context.Context) error { return errFailed }},
+			wantErr: errFailed,
+		},
+		{
+			name: "first error returned",
+			tasks: []func(ctx context.Context) error{
+				func(ctx context.Context) error { return errFailed },
+				func(ctx context.Context) error { <-ctx.Done(); return errors.New("canceled") },
+			},
+			wantErr: errFailed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.wantErr == nil {
+				time.AfterFunc(10*time.Millisecond, cancel)
+			}
+			if err := runAll(ctx, cancel, tt.tasks); !errors.Is(err, tt.wantErr) {
+				t.Errorf("runAll() = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,199 @@
+// Copyright 2023 The Kubegems Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/go-logr/logr"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"sigs.k8s.io/yaml"
+)
+
+// Reloadable is a component reloaded by Reloader, e.g. Value, listen.DynamicCertificate or api.OIDCAuthenticator.
+type Reloadable interface {
+	// PrepareReload loads the new state without applying it,
+	// apply is called only after all reloadables are prepared, so a reload is applied entirely or not at all.
+	PrepareReload(ctx context.Context) (apply func(), err error)
+}
+
+type ReloadFunc func(ctx context.Context) (apply func(), err error)
+
+func (f ReloadFunc) PrepareReload(ctx context.Context) (func(), error) {
+	return f(ctx)
+}
+
+type reloadable struct {
+	name string
+	Reloadable
+}
+
+// Reloader reloads the registered components on SIGHUP or by the admin endpoint.
+type Reloader struct {
+	mu          sync.Mutex // serializes registration and reloads
+	reloadables []reloadable
+}
+
+func NewReloader() *Reloader {
+	return &Reloader{}
+}
+
+func (r *Reloader) Register(name string, item Reloadable) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.reloadables = append(r.reloadables, reloadable{name: name, Reloadable: item})
+}
+
+// Reload prepares all reloadables and applies them if all succeeded,
+// otherwise nothing is changed and the errors are returned.
+func (r *Reloader) Reload(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	log := logr.FromContextOrDiscard(ctx)
+	applies, errs := make([]func(), 0, len(r.reloadables)), []error{}
+	for _, item := range r.reloadables {
+		apply, err := item.PrepareReload(ctx)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("reload %s: %w", item.name, err))
+			continue
+		}
+		applies = append(applies, apply)
+	}
+	if len(errs) > 0 {
+		return utilerrors.NewAggregate(errs)
+	}
+	for _, apply := range applies {
+		if apply != nil {
+			apply()
+		}
+	}
+	log.Info("reloaded", "count", len(applies))
+	return nil
+}
+
+// Run reloads on SIGHUP until ctx done, failed reloads are logged and the previous state is kept.
+func (r *Reloader) Run(ctx context.Context) error {
+	log := logr.FromContextOrDiscard(ctx)
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP)
+	defer signal.Stop(sig)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-sig:
+			log.Info("SIGHUP received, reloading")
+			if err := r.Reload(ctx); err != nil {
+				log.Error(err, "reload failed")
+			}
+		}
+	}
+}
+
+// ServeHTTP is the admin endpoint to trigger a reload by POST.
+func (r *Reloader) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.Reload(req.Context()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type reloaderContextKey struct{}
+
+// WithReloader sets reloader into ctx, components started with ctx register themselves to it,
+// e.g. the certificates of listen.ServeContext.
+func WithReloader(ctx context.Context, reloader *Reloader) context.Context {
+	return context.WithValue(ctx, reloaderContextKey{}, reloader)
+}
+
+func ReloaderFromContext(ctx context.Context) *Reloader {
+	if reloader, ok := ctx.Value(reloaderContextKey{}).(*Reloader); ok {
+		return reloader
+	}
+	return nil
+}
+
+var _ Reloadable = &Value[struct{}]{}
+
+// Value holds a reloadable configuration, readers get a consistent snapshot by Load.
+type Value[T any] struct {
+	load  func(ctx context.Context) (*T, error)
+	value atomic.Pointer[T]
+}
+
+func NewValue[T any](ctx context.Context, load func(ctx context.Context) (*T, error)) (*Value[T], error) {
+	v := &Value[T]{load: load}
+	init, err := load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	v.value.Store(init)
+	return v, nil
+}
+
+// NewFileValue loads a yaml or json file over defaults, the file is read again on every reload.
+func NewFileValue[T any](ctx context.Context, file string, defaults *T) (*Value[T], error) {
+	return NewValue(ctx, func(ctx context.Context) (*T, error) {
+		return LoadFile(file, defaults)
+	})
+}
+
+// LoadFile decodes a yaml or json file into a deep copy of defaults.
+func LoadFile[T any](file string, defaults *T) (*T, error) {
+	into := new(T)
+	if defaults != nil {
+		raw, err := json.Marshal(defaults)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(raw, into); err != nil {
+			return nil, err
+		}
+	}
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(content, into); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", file, err)
+	}
+	return into, nil
+}
+
+func (v *Value[T]) Load() *T {
+	return v.value.Load()
+}
+
+func (v *Value[T]) PrepareReload(ctx context.Context) (func(), error) {
+	val, err := v.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return func() { v.value.Store(val) }, nil
+}
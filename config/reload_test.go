@@ -0,0 +1,65 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type reloadTestConfig struct {
+	Name  string   `json:"name"`
+	Hosts []string `json:"hosts"`
+}
+
+func TestReloader(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(file, []byte("name: foo\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	value, err := NewFileValue(ctx, file, &reloadTestConfig{Hosts: []string{"localhost"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := value.Load(); got.Name != "foo" || len(got.Hosts) != 1 {
+		t.Fatalf("initial config = %+v", got)
+	}
+	failing := false
+	reloader := NewReloader()
+	reloader.Register("config", value)
+	reloader.Register("other", ReloadFunc(func(ctx context.Context) (func(), error) {
+		if failing {
+			return nil, errors.New("invalid")
+		}
+		return func() {}, nil
+	}))
+
+	tests := []struct {
+		name     string
+		content  string
+		failing  bool
+		wantErr  bool
+		wantName string
+	}{
+		{name: "applied", content: "name: bar\n", wantName: "bar"},
+		{name: "invalid file keeps previous", content: "name: [\n", wantErr: true, wantName: "bar"},
+		{name: "other failed keeps previous", content: "name: baz\n", failing: true, wantErr: true, wantName: "bar"},
+		{name: "applied after recovered", content: "name: baz\n", wantName: "baz"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := os.WriteFile(file, []byte(tt.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			failing = tt.failing
+			if err := reloader.Reload(ctx); (err != nil) != tt.wantErr {
+				t.Fatalf("Reload() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := value.Load().Name; got != tt.wantName {
+				t.Errorf("name = %s, want %s", got, tt.wantName)
+			}
+		})
+	}
+}
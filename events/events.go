@@ -0,0 +1,248 @@
+// Copyright 2023 The Kubegems Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package events is an in-process pub/sub bus with typed topics,
+// e.g. to decouple audit publication, webhook dispatch and cache invalidation from request handling.
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/go-logr/logr"
+)
+
+var ErrClosed = errors.New("events: bus closed")
+
+// Policy decides what happens when a subscriber buffer is full.
+type Policy int
+
+const (
+	// Block waits until the subscriber has room or the publish context is done.
+	Block Policy = iota
+	// DropNewest drops the event being published.
+	DropNewest
+	// DropOldest drops the oldest buffered event to make room.
+	DropOldest
+)
+
+func (p Policy) String() string {
+	switch p {
+	case Block:
+		return "block"
+	case DropNewest:
+		return "drop-newest"
+	case DropOldest:
+		return "drop-oldest"
+	default:
+		return fmt.Sprintf("Policy(%d)", int(p))
+	}
+}
+
+// Bus holds topics and tracks subscribers for shutdown.
+type Bus struct {
+	Logger logr.Logger // logs recovered panics of handlers
+
+	mu     sync.Mutex
+	topics map[string]topic
+	closed atomic.Bool
+	wg     sync.WaitGroup
+}
+
+type topic interface {
+	close()
+}
+
+func NewBus() *Bus {
+	return &Bus{Logger: logr.Discard(), topics: map[string]topic{}}
+}
+
+// Shutdown rejects new events and subscriptions, then waits for subscribers to handle buffered events.
+// It returns ctx.Err() if ctx is done before all events are handled.
+func (b *Bus) Shutdown(ctx context.Context) error {
+	b.mu.Lock()
+	if !b.closed.Swap(true) {
+		for _, t := range b.topics {
+			t.close()
+		}
+	}
+	b.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		b.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Topic delivers events of type T to its subscribers.
+type Topic[T any] struct {
+	Name string
+
+	bus  *Bus
+	mu   sync.RWMutex
+	subs []*Subscription[T]
+}
+
+// NewTopic returns the topic of name on bus, topics with the same name share subscribers.
+// It panics if the topic exists with a different event type.
+func NewTopic[T any](bus *Bus, name string) *Topic[T] {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	if exists, ok := bus.topics[name]; ok {
+		t, ok := exists.(*Topic[T])
+		if !ok {
+			panic(fmt.Sprintf("events: topic %s registered with type %T", name, exists))
+		}
+		return t
+	}
+	t := &Topic[T]{Name: name, bus: bus}
+	bus.topics[name] = t
+	return t
+}
+
+type SubscribeOptions struct {
+	Buffer int    // buffered events, default 64
+	Policy Policy // when the buffer is full, default Block
+}
+
+// Subscribe calls handler with events in a dedicated goroutine, in publish order.
+func (t *Topic[T]) Subscribe(handler func(event T), opts SubscribeOptions) (*Subscription[T], error) {
+	if opts.Buffer <= 0 {
+		opts.Buffer = 64
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.bus.closed.Load() {
+		return nil, ErrClosed
+	}
+	sub := &Subscription[T]{topic: t, policy: opts.Policy, ch: make(chan T, opts.Buffer)}
+	t.subs = append(t.subs, sub)
+	t.bus.wg.Add(1)
+	go sub.run(handler, t.bus.Logger.WithValues("topic", t.Name))
+	return sub, nil
+}
+
+// Publish delivers event to all subscribers according to their policies.
+// Only subscribers with Block policy can make it wait, it returns ctx.Err() if ctx is done while waiting.
+func (t *Topic[T]) Publish(ctx context.Context, event T) error {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.bus.closed.Load() {
+		return ErrClosed
+	}
+	for _, sub := range t.subs {
+		if err := sub.send(ctx, event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *Topic[T]) remove(sub *Subscription[T]) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i, s := range t.subs {
+		if s == sub {
+			t.subs = append(t.subs[:i], t.subs[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// close must be called with bus lock held.
+func (t *Topic[T]) close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, sub := range t.subs {
+		close(sub.ch)
+	}
+	t.subs = nil
+}
+
+type Subscription[T any] struct {
+	topic   *Topic[T]
+	policy  Policy
+	ch      chan T
+	dropped atomic.Uint64
+}
+
+// Dropped returns the count of events dropped for this subscriber.
+func (s *Subscription[T]) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Unsubscribe stops receiving events, the buffered events are still handled.
+func (s *Subscription[T]) Unsubscribe() {
+	if s.topic.remove(s) {
+		close(s.ch)
+	}
+}
+
+func (s *Subscription[T]) send(ctx context.Context, event T) error {
+	switch s.policy {
+	case DropNewest:
+		select {
+		case s.ch <- event:
+		default:
+			s.dropped.Add(1)
+		}
+	case DropOldest:
+		for {
+			select {
+			case s.ch <- event:
+				return nil
+			default:
+			}
+			select {
+			case <-s.ch:
+				s.dropped.Add(1)
+			default:
+			}
+		}
+	default:
+		select {
+		case s.ch <- event:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (s *Subscription[T]) run(handler func(event T), log logr.Logger) {
+	defer s.topic.bus.wg.Done()
+	for event := range s.ch {
+		s.handle(handler, event, log)
+	}
+}
+
+func (s *Subscription[T]) handle(handler func(event T), event T, log logr.Logger) {
+	defer func() {
+		if err := recover(); err != nil {
+			log.Error(fmt.Errorf("%v", err), "event handler panic")
+		}
+	}()
+	handler(event)
+}
@@ -0,0 +1,97 @@
+// Copyright 2023 The Kubegems Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPolicies(t *testing.T) {
+	tests := []struct {
+		policy      Policy
+		wantHandled []int
+		wantDropped uint64
+	}{
+		{policy: DropNewest, wantHandled: []int{1, 2}, wantDropped: 2},
+		{policy: DropOldest, wantHandled: []int{3, 4}, wantDropped: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy.String(), func(t *testing.T) {
+			bus := NewBus()
+			topic := NewTopic[int](bus, "numbers")
+			start := make(chan struct{})
+			handled := []int{}
+			sub, err := topic.Subscribe(func(event int) {
+				<-start
+				handled = append(handled, event)
+			}, SubscribeOptions{Buffer: 2, Policy: tt.policy})
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the first event is taken by the handler which waits for start
+			for i := 0; i <= 4; i++ {
+				if err := topic.Publish(context.Background(), i); err != nil {
+					t.Fatal(err)
+				}
+				if i == 0 {
+					time.Sleep(10 * time.Millisecond)
+				}
+			}
+			close(start)
+			if err := bus.Shutdown(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(handled[1:], tt.wantHandled) {
+				t.Errorf("handled = %v, want %v", handled[1:], tt.wantHandled)
+			}
+			if sub.Dropped() != tt.wantDropped {
+				t.Errorf("Dropped() = %v, want %v", sub.Dropped(), tt.wantDropped)
+			}
+		})
+	}
+}
+
+func TestBlockAndShutdown(t *testing.T) {
+	bus := NewBus()
+	topic := NewTopic[string](bus, "messages")
+	mu, handled := sync.Mutex{}, 0
+	if _, err := topic.Subscribe(func(event string) {
+		time.Sleep(time.Millisecond)
+		mu.Lock()
+		handled++
+		mu.Unlock()
+	}, SubscribeOptions{Buffer: 1}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if err := topic.Publish(context.Background(), "hello"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := bus.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if handled != 10 {
+		t.Errorf("handled = %v, want 10", handled)
+	}
+	if err := topic.Publish(context.Background(), "late"); !errors.Is(err, ErrClosed) {
+		t.Errorf("Publish() after Shutdown() error = %v, want %v", err, ErrClosed)
+	}
+}
@@ -0,0 +1,238 @@
+// Copyright 2023 The Kubegems Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package egress builds http clients for server-initiated requests, e.g. webhooks, OIDC discovery and registries,
+// so that proxy, trusted CAs, allowed destinations and metrics of all egress are configured in one place.
+package egress
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var ErrDestinationDenied = errors.New("egress destination denied")
+
+type Options struct {
+	Proxy              string        `json:"proxy,omitempty" description:"proxy url, empty uses HTTP_PROXY/HTTPS_PROXY/NO_PROXY environments"`
+	CAFiles            []string      `json:"caFiles,omitempty" description:"PEM encoded CA bundles trusted in addition to the system roots"`
+	InsecureSkipVerify bool          `json:"insecureSkipVerify,omitempty" description:"skip tls verification"`
+	AllowedHosts       []string      `json:"allowedHosts,omitempty" description:"allowed destination hosts, '*.example.com' matches subdomains, empty allows all"`
+	AllowedCIDRs       []string      `json:"allowedCIDRs,omitempty" description:"allowed destination addresses, empty allows all"`
+	DeniedCIDRs        []string      `json:"deniedCIDRs,omitempty" description:"denied destination addresses, takes precedence over allowed"`
+	Timeout            time.Duration `json:"timeout,omitempty" description:"timeout of a request including reading the body"`
+}
+
+func NewDefaultOptions() *Options {
+	return &Options{
+		// cloud metadata endpoints
+		DeniedCIDRs: []string{"169.254.169.254/32", "fd00:ec2::254/128"},
+		Timeout:     30 * time.Second,
+	}
+}
+
+// Metrics observes outbound requests, err is ErrDestinationDenied if denied by the policy.
+type Metrics interface {
+	ObserveEgress(host string, code int, duration time.Duration, err error)
+}
+
+type MetricsFunc func(host string, code int, duration time.Duration, err error)
+
+func (f MetricsFunc) ObserveEgress(host string, code int, duration time.Duration, err error) {
+	f(host, code, duration, err)
+}
+
+// Factory builds clients sharing one connection pool and policy.
+type Factory struct {
+	Options *Options
+	Metrics Metrics // nil disables metrics
+
+	allowed   []*net.IPNet
+	denied    []*net.IPNet
+	transport *http.Transport
+}
+
+func NewFactory(opts *Options) (*Factory, error) {
+	f := &Factory{Options: opts}
+	var err error
+	if f.allowed, err = parseCIDRs(opts.AllowedCIDRs); err != nil {
+		return nil, err
+	}
+	if f.denied, err = parseCIDRs(opts.DeniedCIDRs); err != nil {
+		return nil, err
+	}
+	tlsconfig := &tls.Config{InsecureSkipVerify: opts.InsecureSkipVerify} //nolint: gosec
+	if len(opts.CAFiles) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		for _, file := range opts.CAFiles {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return nil, err
+			}
+			if !pool.AppendCertsFromPEM(data) {
+				return nil, fmt.Errorf("no certificates found in %s", file)
+			}
+		}
+		tlsconfig.RootCAs = pool
+	}
+	proxy := http.ProxyFromEnvironment
+	if opts.Proxy != "" {
+		proxyurl, err := url.Parse(opts.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy: %w", err)
+		}
+		proxy = http.ProxyURL(proxyurl)
+	}
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		// check the resolved address, so hosts resolved to denied addresses are refused
+		Control: func(network, address string, _ syscall.RawConn) error {
+			return f.checkAddress(address)
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = proxy
+	transport.DialContext = dialer.DialContext
+	transport.TLSClientConfig = tlsconfig
+	f.transport = transport
+	return f, nil
+}
+
+// Transport returns the round tripper applies the policy and metrics.
+// When a proxy is used, the CIDR policy applies to the proxy address and the host policy to the request host.
+func (f *Factory) Transport() http.RoundTripper {
+	return &roundTripper{factory: f}
+}
+
+// Client returns a new client using Transport, clients can be modified independently.
+func (f *Factory) Client() *http.Client {
+	return &http.Client{Transport: f.Transport(), Timeout: f.Options.Timeout}
+}
+
+// CheckURL checks the host and literal ip of u, e.g. to validate a webhook url on creation.
+// Hosts resolved to denied addresses are refused when connecting.
+func (f *Factory) CheckURL(u *url.URL) error {
+	host := u.Hostname()
+	if !f.hostAllowed(host) {
+		return fmt.Errorf("%w: host %s not allowed", ErrDestinationDenied, host)
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return f.checkIP(ip)
+	}
+	return nil
+}
+
+func (f *Factory) hostAllowed(host string) bool {
+	if len(f.Options.AllowedHosts) == 0 {
+		return true
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, pattern := range f.Options.AllowedHosts {
+		pattern = strings.ToLower(pattern)
+		if pattern == host {
+			return true
+		}
+		if suffix, ok := strings.CutPrefix(pattern, "*"); ok && strings.HasSuffix(host, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *Factory) checkAddress(address string) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("%w: invalid address %s", ErrDestinationDenied, address)
+	}
+	return f.checkIP(ip)
+}
+
+func (f *Factory) checkIP(ip net.IP) error {
+	if containsIP(f.denied, ip) || (len(f.allowed) > 0 && !containsIP(f.allowed, ip)) {
+		return fmt.Errorf("%w: address %s not allowed", ErrDestinationDenied, ip)
+	}
+	return nil
+}
+
+type roundTripper struct {
+	factory *Factory
+}
+
+func (t *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	host := req.URL.Hostname()
+	resp, err := t.roundTrip(req)
+	if metrics := t.factory.Metrics; metrics != nil {
+		code := 0
+		if resp != nil {
+			code = resp.StatusCode
+		}
+		metrics.ObserveEgress(host, code, time.Since(start), err)
+	}
+	return resp, err
+}
+
+func (t *roundTripper) roundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.factory.CheckURL(req.URL); err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+	return t.factory.transport.RoundTrip(req)
+}
+
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	ipnets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			if ip := net.ParseIP(cidr); ip != nil && ip.To4() != nil {
+				cidr += "/32"
+			} else {
+				cidr += "/128"
+			}
+		}
+		_, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cidr %s: %w", cidr, err)
+		}
+		ipnets = append(ipnets, ipnet)
+	}
+	return ipnets, nil
+}
+
+func containsIP(ipnets []*net.IPNet, ip net.IP) bool {
+	for _, ipnet := range ipnets {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
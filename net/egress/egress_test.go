@@ -0,0 +1,51 @@
+package egress
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestFactory(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	tests := []struct {
+		name       string
+		options    Options
+		url        string
+		wantDenied bool
+	}{
+		{name: "allow all", url: server.URL},
+		{name: "denied cidr", options: Options{DeniedCIDRs: []string{"127.0.0.0/8"}}, url: server.URL, wantDenied: true},
+		{name: "not in allowed cidr", options: Options{AllowedCIDRs: []string{"10.0.0.0/8"}}, url: server.URL, wantDenied: true},
+		{name: "resolved address denied", options: Options{DeniedCIDRs: []string{"127.0.0.1", "::1"}}, url: "http://localhost:" + server.URL[len("http://127.0.0.1:"):], wantDenied: true},
+		{name: "allowed host", options: Options{AllowedHosts: []string{"127.0.0.1"}}, url: server.URL},
+		{name: "host not allowed", options: Options{AllowedHosts: []string{"*.example.com"}}, url: server.URL, wantDenied: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := tt.options
+			factory, err := NewFactory(&options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var observed error
+			factory.Metrics = MetricsFunc(func(host string, code int, duration time.Duration, err error) {
+				observed = err
+			})
+			resp, err := factory.Client().Get(tt.url)
+			if err == nil {
+				resp.Body.Close()
+			}
+			if denied := errors.Is(err, ErrDestinationDenied); denied != tt.wantDenied {
+				t.Errorf("Get() error = %v, wantDenied %v", err, tt.wantDenied)
+			}
+			if (observed != nil) != tt.wantDenied {
+				t.Errorf("observed error = %v, wantDenied %v", observed, tt.wantDenied)
+			}
+		})
+	}
+}
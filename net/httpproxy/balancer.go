@@ -0,0 +1,207 @@
+package httpproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-logr/logr"
+	"golang.org/x/exp/slices"
+)
+
+// Discoverer returns the current backend addresses in "host:port" form.
+// Implement it to discover backends from other sources, e.g. a kubernetes EndpointSlice informer.
+type Discoverer interface {
+	Discover(ctx context.Context) ([]string, error)
+}
+
+type DiscovererFunc func(ctx context.Context) ([]string, error)
+
+func (f DiscovererFunc) Discover(ctx context.Context) ([]string, error) {
+	return f(ctx)
+}
+
+// StaticDiscoverer returns fixed backends.
+type StaticDiscoverer []string
+
+func (s StaticDiscoverer) Discover(ctx context.Context) ([]string, error) {
+	return s, nil
+}
+
+// DNSDiscoverer resolves A/AAAA records of Host, e.g. a kubernetes headless service.
+type DNSDiscoverer struct {
+	Host     string
+	Port     int
+	Resolver *net.Resolver // default net.DefaultResolver
+}
+
+func (d *DNSDiscoverer) Discover(ctx context.Context) ([]string, error) {
+	addrs, err := resolver(d.Resolver).LookupHost(ctx, d.Host)
+	if err != nil {
+		return nil, err
+	}
+	backends := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		backends = append(backends, net.JoinHostPort(addr, strconv.Itoa(d.Port)))
+	}
+	return backends, nil
+}
+
+// SRVDiscoverer resolves SRV records, e.g. _http._tcp.svc.ns.svc.cluster.local.
+// Service and Proto can be empty to lookup Name directly.
+type SRVDiscoverer struct {
+	Service  string
+	Proto    string
+	Name     string
+	Resolver *net.Resolver // default net.DefaultResolver
+}
+
+func (d *SRVDiscoverer) Discover(ctx context.Context) ([]string, error) {
+	_, srvs, err := resolver(d.Resolver).LookupSRV(ctx, d.Service, d.Proto, d.Name)
+	if err != nil {
+		return nil, err
+	}
+	backends := make([]string, 0, len(srvs))
+	for _, srv := range srvs {
+		backends = append(backends, net.JoinHostPort(strings.TrimSuffix(srv.Target, "."), strconv.Itoa(int(srv.Port))))
+	}
+	return backends, nil
+}
+
+func resolver(r *net.Resolver) *net.Resolver {
+	if r != nil {
+		return r
+	}
+	return net.DefaultResolver
+}
+
+// LoadBalancer proxies requests to the backends from Discoverer in round-robin,
+// Run must be called to keep the backends refreshed.
+// Requests without body fail over to the next backends if a backend is unreachable.
+type LoadBalancer struct {
+	Scheme          string // backend scheme, default http
+	StripPrefix     string // prefix removed from the request path before forwarding
+	Discoverer      Discoverer
+	RefreshInterval time.Duration // default 30s
+	Transport       http.RoundTripper
+
+	mu       sync.RWMutex
+	backends []string
+	next     atomic.Uint64
+}
+
+func NewLoadBalancer(scheme string, discoverer Discoverer) *LoadBalancer {
+	return &LoadBalancer{Scheme: scheme, Discoverer: discoverer, RefreshInterval: 30 * time.Second}
+}
+
+// Refresh updates backends from discoverer, the previous backends are kept on error.
+func (b *LoadBalancer) Refresh(ctx context.Context) error {
+	backends, err := b.Discoverer.Discover(ctx)
+	if err != nil {
+		return fmt.Errorf("discover backends: %w", err)
+	}
+	// sorted in a copy, the discoverer may return the same slice each time, e.g. StaticDiscoverer
+	backends = slices.Clone(backends)
+	slices.Sort(backends)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.backends = backends
+	return nil
+}
+
+func (b *LoadBalancer) Run(ctx context.Context) error {
+	log := logr.FromContextOrDiscard(ctx)
+	interval := b.RefreshInterval
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	if err := b.Refresh(ctx); err != nil {
+		log.Error(err, "refresh backends")
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := b.Refresh(ctx); err != nil {
+				log.Error(err, "refresh backends")
+			}
+		}
+	}
+}
+
+func (b *LoadBalancer) Backends() []string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return slices.Clone(b.backends)
+}
+
+// pick returns the backends and the index of the next one.
+func (b *LoadBalancer) pick() ([]string, int) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if len(b.backends) == 0 {
+		return nil, 0
+	}
+	return b.backends, int(b.next.Add(1) % uint64(len(b.backends)))
+}
+
+func (b *LoadBalancer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	backends, next := b.pick()
+	if len(backends) == 0 {
+		http.Error(w, "no available backend", http.StatusServiceUnavailable)
+		return
+	}
+	scheme := b.Scheme
+	if scheme == "" {
+		scheme = "http"
+	}
+	// a request with body can't be sent again, the body may be consumed
+	retryable := r.Body == nil || r.Body == http.NoBody
+	for i := range backends {
+		backend := backends[(next+i)%len(backends)]
+		target := &url.URL{Scheme: scheme, Host: backend}
+		var proxyerr error
+		rp := httputil.ReverseProxy{
+			Rewrite: func(pr *httputil.ProxyRequest) {
+				if b.StripPrefix != "" {
+					pr.Out.URL.Path = strings.TrimPrefix(pr.Out.URL.Path, b.StripPrefix)
+					pr.Out.URL.RawPath = ""
+				}
+				SetXForwarded(pr)
+				pr.SetURL(target)
+			},
+			Transport: b.Transport,
+			// called before the response is written
+			ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+				proxyerr = err
+			},
+		}
+		rp.ServeHTTP(w, r)
+		if proxyerr == nil {
+			return
+		}
+		logr.FromContextOrDiscard(r.Context()).Error(proxyerr, "proxy to backend", "backend", backend)
+		if !retryable || !isDialError(proxyerr) || i == len(backends)-1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+	}
+}
+
+// isDialError reports whether err is a failure of connecting, the request is not sent.
+func isDialError(err error) bool {
+	operr := &net.OpError{}
+	return errors.As(err, &operr) && operr.Op == "dial"
+}
@@ -0,0 +1,153 @@
+package httpproxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/exp/slices"
+)
+
+func newBackends(t *testing.T, n int) []string {
+	t.Helper()
+	backends := []string{}
+	for i := 0; i < n; i++ {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.Copy(io.Discard, r.Body)
+			w.Write([]byte(r.Host + r.URL.Path))
+		}))
+		t.Cleanup(server.Close)
+		backends = append(backends, server.Listener.Addr().String())
+	}
+	return backends
+}
+
+// deadBackend returns an address refusing connections.
+func deadBackend(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	return addr
+}
+
+func TestLoadBalancerRoundRobin(t *testing.T) {
+	backends := newBackends(t, 3)
+	lb := NewLoadBalancer("http", StaticDiscoverer(backends))
+	lb.StripPrefix = "/api"
+	rec := httptest.NewRecorder()
+	lb.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/zoos", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("code before refresh = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+	if err := lb.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	served := map[string]int{}
+	for i := 0; i < 6; i++ {
+		rec := httptest.NewRecorder()
+		lb.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/zoos", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("code = %d, want %d", rec.Code, http.StatusOK)
+		}
+		host, path, _ := strings.Cut(rec.Body.String(), "/")
+		if path != "zoos" {
+			t.Errorf("path = %q, want prefix stripped", path)
+		}
+		served[host]++
+	}
+	for _, backend := range backends {
+		if served[backend] != 2 {
+			t.Errorf("backend %s served %d requests, want 2", backend, served[backend])
+		}
+	}
+}
+
+func TestLoadBalancerFailover(t *testing.T) {
+	live := newBackends(t, 1)[0]
+	dead := deadBackend(t)
+	tests := []struct {
+		name       string
+		backends   []string
+		body       string
+		requests   int
+		wantFailed int
+	}{
+		{name: "failover", backends: []string{dead, live}, requests: 4},
+		{name: "all dead", backends: []string{dead, deadBackend(t)}, requests: 2, wantFailed: 2},
+		// one of the requests is sent to the dead backend
+		{name: "body not resent", backends: []string{dead, live}, body: "data", requests: 2, wantFailed: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lb := NewLoadBalancer("http", StaticDiscoverer(tt.backends))
+			if err := lb.Refresh(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			failed := 0
+			for i := 0; i < tt.requests; i++ {
+				var body io.Reader
+				if tt.body != "" {
+					body = strings.NewReader(tt.body)
+				}
+				rec := httptest.NewRecorder()
+				lb.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", body))
+				switch rec.Code {
+				case http.StatusOK:
+				case http.StatusBadGateway:
+					failed++
+				default:
+					t.Errorf("request %d: code = %d", i, rec.Code)
+				}
+			}
+			if failed != tt.wantFailed {
+				t.Errorf("failed requests = %d, want %d", failed, tt.wantFailed)
+			}
+		})
+	}
+}
+
+func TestLoadBalancerRefresh(t *testing.T) {
+	backends, failed := []string{"b:80", "a:80"}, false
+	lb := NewLoadBalancer("http", DiscovererFunc(func(ctx context.Context) ([]string, error) {
+		if failed {
+			return nil, errors.New("unavailable")
+		}
+		return backends, nil
+	}))
+	if err := lb.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := lb.Backends(); !slices.Equal(got, []string{"a:80", "b:80"}) {
+		t.Errorf("Backends() = %v", got)
+	}
+	if !slices.Equal(backends, []string{"b:80", "a:80"}) {
+		t.Errorf("discovered backends modified: %v", backends)
+	}
+	failed = true
+	if err := lb.Refresh(context.Background()); err == nil {
+		t.Error("Refresh() expected error")
+	}
+	if got := lb.Backends(); len(got) != 2 {
+		t.Errorf("Backends() after failed refresh = %v, want kept", got)
+	}
+}
+
+func TestDNSDiscoverer(t *testing.T) {
+	backends, err := (&DNSDiscoverer{Host: "localhost", Port: 8080}).Discover(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(backends, "127.0.0.1:8080") {
+		t.Errorf("Discover() = %v, want 127.0.0.1:8080", backends)
+	}
+}
@@ -1,19 +1,50 @@
 package httpproxy
 
 import (
+	"fmt"
 	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"strings"
+	"time"
 )
 
 type Server struct {
-	Prefix string // prefix path
+	Prefix    string            // prefix path
+	Transport http.RoundTripper // default StreamingTransport
+	// TrustedProxies are the CIDRs or IPs of peers allowed to set X-Forwarded-* headers,
+	// the headers from other peers are removed. Nil trusts all peers,
+	// set it when the server is reachable by clients directly.
+	TrustedProxies []string
 }
 
+// forwardedHeaders are the headers used by Server to rebuild the original request.
+var forwardedHeaders = []string{
+	"X-Forwarded-Host", "X-Forwarded-For", "X-Forwarded-Scheme", "X-Forwarded-Proto", "X-Forwarded-Uri", "X-Uri",
+}
+
+// StreamingTransport passes request bodies through without buffering,
+// "Expect: 100-continue" is forwarded so the upstream decides before the body is sent.
+var StreamingTransport http.RoundTripper = func() http.RoundTripper {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ExpectContinueTimeout = 5 * time.Second
+	return transport
+}()
+
+// ServeHTTP proxies the request, the body is streamed as is and the Content-Length is kept,
+// so large uploads are not buffered in memory.
 func (h *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := h.sanitizeForwarded(r); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	transport := h.Transport
+	if transport == nil {
+		transport = StreamingTransport
+	}
 	rp := httputil.ReverseProxy{
+		Transport: transport,
 		Director: func(r *http.Request) {
 			backupRestoreAuthorizationHeader(r)
 			if forwardedHost := r.Header.Get("X-Forwarded-Host"); forwardedHost != "" {
@@ -47,6 +78,60 @@ func (h *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	rp.ServeHTTP(w, r)
 }
 
+// sanitizeForwarded removes X-Forwarded-* headers from untrusted peers,
+// and validates the headers from trusted peers.
+func (h *Server) sanitizeForwarded(r *http.Request) error {
+	if h.TrustedProxies != nil && !isTrustedPeer(r.RemoteAddr, h.TrustedProxies) {
+		for _, header := range forwardedHeaders {
+			r.Header.Del(header)
+		}
+		return nil
+	}
+	if host := r.Header.Get("X-Forwarded-Host"); host != "" && !validForwardedHost(host) {
+		return fmt.Errorf("invalid X-Forwarded-Host %q", host)
+	}
+	if scheme := r.Header.Get("X-Forwarded-Scheme"); scheme != "" && scheme != "http" && scheme != "https" {
+		return fmt.Errorf("invalid X-Forwarded-Scheme %q", scheme)
+	}
+	if forwardedFor := r.Header.Get("X-Forwarded-For"); forwardedFor != "" {
+		for _, ip := range strings.Split(forwardedFor, ",") {
+			if net.ParseIP(strings.TrimSpace(ip)) == nil {
+				return fmt.Errorf("invalid X-Forwarded-For %q", forwardedFor)
+			}
+		}
+	}
+	if uri := getHeader(r, "X-Uri", "X-Forwarded-Uri"); uri != "" && !strings.HasPrefix(uri, "/") {
+		return fmt.Errorf("invalid X-Forwarded-Uri %q", uri)
+	}
+	return nil
+}
+
+func isTrustedPeer(remoteaddr string, trusted []string) bool {
+	peer, _, err := net.SplitHostPort(remoteaddr)
+	if err != nil {
+		peer = remoteaddr
+	}
+	ip := net.ParseIP(peer)
+	if ip == nil {
+		return false
+	}
+	for _, cidr := range trusted {
+		if _, ipnet, err := net.ParseCIDR(cidr); err == nil {
+			if ipnet.Contains(ip) {
+				return true
+			}
+		} else if trustedip := net.ParseIP(cidr); trustedip != nil && trustedip.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func validForwardedHost(host string) bool {
+	u, err := url.Parse("http://" + host)
+	return err == nil && u.Host == host && u.User == nil && u.Path == "" && u.RawQuery == "" && u.Fragment == ""
+}
+
 type Client struct {
 	Server     *url.URL // server address
 	HttpClient *http.Client
@@ -96,8 +181,13 @@ func backupRestoreAuthorizationHeader(r *http.Request) {
 }
 
 // SetXForwarded is a extend version of ProxyRequest{}.SetXForwarded(
+// RemoteAddr without port, e.g. set by a real ip filter, is accepted as well.
 func SetXForwarded(r *httputil.ProxyRequest) {
-	if clientIP, _, err := net.SplitHostPort(r.In.RemoteAddr); err == nil {
+	clientIP := r.In.RemoteAddr
+	if host, _, err := net.SplitHostPort(clientIP); err == nil {
+		clientIP = host
+	}
+	if net.ParseIP(clientIP) != nil {
 		if prior := r.Out.Header["X-Forwarded-For"]; len(prior) > 0 {
 			clientIP = strings.Join(prior, ", ") + ", " + clientIP
 		}
@@ -45,3 +45,94 @@ func TestClient_RoundTrip(t *testing.T) {
 	}
 	t.Logf("dump = %s", dump)
 }
+
+func TestServer_sanitizeForwarded(t *testing.T) {
+	tests := []struct {
+		name       string
+		trusted    []string
+		remoteaddr string
+		header     map[string]string
+		wantHost   string
+		wantErr    bool
+	}{
+		{
+			name:       "trust all by default",
+			remoteaddr: "1.2.3.4:1234",
+			header:     map[string]string{"X-Forwarded-Host": "example.com"},
+			wantHost:   "example.com",
+		},
+		{
+			name:       "strip untrusted",
+			trusted:    []string{"10.0.0.0/8"},
+			remoteaddr: "1.2.3.4:1234",
+			header:     map[string]string{"X-Forwarded-Host": "example.com"},
+			wantHost:   "",
+		},
+		{
+			name:       "keep trusted",
+			trusted:    []string{"10.0.0.0/8"},
+			remoteaddr: "10.1.2.3:1234",
+			header:     map[string]string{"X-Forwarded-Host": "example.com:8080"},
+			wantHost:   "example.com:8080",
+		},
+		{
+			name:       "invalid host",
+			remoteaddr: "10.1.2.3:1234",
+			header:     map[string]string{"X-Forwarded-Host": "example.com/path"},
+			wantErr:    true,
+		},
+		{
+			name:       "invalid scheme",
+			remoteaddr: "10.1.2.3:1234",
+			header:     map[string]string{"X-Forwarded-Scheme": "file"},
+			wantErr:    true,
+		},
+		{
+			name:       "invalid for",
+			remoteaddr: "10.1.2.3:1234",
+			header:     map[string]string{"X-Forwarded-For": "1.2.3.4, evil"},
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteaddr
+			for k, v := range tt.header {
+				r.Header.Set(k, v)
+			}
+			s := &Server{TrustedProxies: tt.trusted}
+			if err := s.sanitizeForwarded(r); (err != nil) != tt.wantErr {
+				t.Errorf("Server.sanitizeForwarded() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && r.Header.Get("X-Forwarded-Host") != tt.wantHost {
+				t.Errorf("X-Forwarded-Host = %v, want %v", r.Header.Get("X-Forwarded-Host"), tt.wantHost)
+			}
+		})
+	}
+}
+
+func TestSetXForwarded(t *testing.T) {
+	tests := []struct {
+		name       string
+		remoteaddr string
+		want       string
+	}{
+		{name: "with port", remoteaddr: "10.1.2.3:1234", want: "10.1.2.3"},
+		{name: "without port", remoteaddr: "10.1.2.3", want: "10.1.2.3"},
+		{name: "ipv6 port 0", remoteaddr: "[::1]:0", want: "::1"},
+		{name: "not an ip", remoteaddr: "evil", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := httptest.NewRequest(http.MethodGet, "/", nil)
+			in.RemoteAddr = tt.remoteaddr
+			pr := &httputil.ProxyRequest{In: in, Out: in.Clone(in.Context())}
+			SetXForwarded(pr)
+			if got := pr.Out.Header.Get("X-Forwarded-For"); got != tt.want {
+				t.Errorf("X-Forwarded-For = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright 2023 The Kubegems Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpproxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+)
+
+// Upstream is a reverse proxy to a fixed backend.
+type Upstream struct {
+	Target      *url.URL // backend address, e.g. http://svc.ns:8080/base
+	StripPrefix string   // prefix removed from the request path before forwarding
+	Transport   http.RoundTripper
+}
+
+func NewUpstream(target string, stripPrefix string) (*Upstream, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, fmt.Errorf("parse upstream %q: %w", target, err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid upstream %q: scheme and host are required", target)
+	}
+	return &Upstream{Target: u, StripPrefix: stripPrefix}, nil
+}
+
+func (u *Upstream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rp := httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			if u.StripPrefix != "" {
+				pr.Out.URL.Path = strings.TrimPrefix(pr.Out.URL.Path, u.StripPrefix)
+				pr.Out.URL.RawPath = ""
+			}
+			SetXForwarded(pr)
+			pr.SetURL(u.Target)
+		},
+		Transport: u.Transport,
+	}
+	rp.ServeHTTP(w, r)
+}
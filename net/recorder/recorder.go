@@ -0,0 +1,270 @@
+// Copyright 2023 The Kubegems Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package recorder records interactive terminal sessions in asciicast v2 format.
+// see: https://docs.asciinema.org/manual/asciicast/v2/
+package recorder
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	EventOutput = "o"
+	EventInput  = "i"
+	EventResize = "r"
+	EventMarker = "m"
+)
+
+// Header is the first line of an asciicast v2 file.
+type Header struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp,omitempty"`
+	Command   string            `json:"command,omitempty"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+type Metadata struct {
+	ID        string    `json:"id"`
+	User      string    `json:"user,omitempty"`
+	SessionID string    `json:"sessionID,omitempty"`
+	StartTime time.Time `json:"startTime"`
+	Header    Header    `json:"header"`
+}
+
+// Sink stores recordings, like AuditSink for audit logs.
+type Sink interface {
+	Create(ctx context.Context, meta Metadata, compressed bool) (io.WriteCloser, error)
+}
+
+type Options struct {
+	MaxSize       int64 // max bytes of recorded data before truncation, 0 means unlimited
+	Compress      bool  // gzip the recording
+	RecordInput   bool  // record input, may contain passwords typed
+	DefaultWidth  int
+	DefaultHeight int
+}
+
+func NewDefaultOptions() *Options {
+	return &Options{MaxSize: 64 << 20, Compress: true, DefaultWidth: 80, DefaultHeight: 24}
+}
+
+type Recorder struct {
+	options   *Options
+	start     time.Time
+	mu        sync.Mutex
+	out       io.WriteCloser
+	gz        *gzip.Writer
+	enc       *json.Encoder
+	written   int64
+	truncated bool
+	closed    bool
+	// incomplete utf-8 sequences at the end of output and input, completed by the next data
+	pendingOutput []byte
+	pendingInput  []byte
+}
+
+// New creates a recorder and writes header into a new record of sink.
+func New(ctx context.Context, sink Sink, meta Metadata, options *Options) (*Recorder, error) {
+	if options == nil {
+		options = NewDefaultOptions()
+	}
+	if meta.StartTime.IsZero() {
+		meta.StartTime = time.Now()
+	}
+	meta.Header.Version = 2
+	meta.Header.Timestamp = meta.StartTime.Unix()
+	if meta.Header.Width == 0 {
+		meta.Header.Width = options.DefaultWidth
+	}
+	if meta.Header.Height == 0 {
+		meta.Header.Height = options.DefaultHeight
+	}
+	out, err := sink.Create(ctx, meta, options.Compress)
+	if err != nil {
+		return nil, err
+	}
+	r := &Recorder{options: options, start: meta.StartTime, out: out}
+	var w io.Writer = out
+	if options.Compress {
+		r.gz = gzip.NewWriter(out)
+		w = r.gz
+	}
+	r.enc = json.NewEncoder(w)
+	if err := r.enc.Encode(meta.Header); err != nil {
+		out.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// Output records p as output, a multi-byte character split across calls is recorded once complete.
+func (r *Recorder) Output(p []byte) {
+	r.stream(EventOutput, &r.pendingOutput, p)
+}
+
+// Input records p as input if Options.RecordInput, see Output.
+func (r *Recorder) Input(p []byte) {
+	if r.options.RecordInput {
+		r.stream(EventInput, &r.pendingInput, p)
+	}
+}
+
+func (r *Recorder) Resize(width, height int) {
+	r.event(EventResize, strconv.Itoa(width)+"x"+strconv.Itoa(height), 0)
+}
+
+func (r *Recorder) Marker(label string) {
+	r.event(EventMarker, label, 0)
+}
+
+func (r *Recorder) event(kind, data string, size int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.record(kind, data, size)
+}
+
+// stream records the complete utf-8 sequences of pending and p, and keeps the incomplete tail in pending.
+func (r *Recorder) stream(kind string, pending *[]byte, p []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	data := append(*pending, p...)
+	i := incompleteRuneStart(data)
+	if i > 0 {
+		r.record(kind, string(data[:i]), i)
+	}
+	*pending = append((*pending)[:0], data[i:]...)
+}
+
+// incompleteRuneStart returns the index of an incomplete utf-8 sequence at the end of p, len(p) if none.
+func incompleteRuneStart(p []byte) int {
+	for i := len(p) - 1; i >= 0 && i >= len(p)-utf8.UTFMax+1; i-- {
+		if utf8.RuneStart(p[i]) {
+			if utf8.FullRune(p[i:]) {
+				return len(p)
+			}
+			return i
+		}
+	}
+	return len(p)
+}
+
+// record writes an event, must be called with lock held.
+func (r *Recorder) record(kind, data string, size int) {
+	if r.closed || r.truncated {
+		return
+	}
+	elapsed := time.Since(r.start).Seconds()
+	if r.options.MaxSize > 0 && r.written+int64(size) > r.options.MaxSize {
+		r.truncated = true
+		_ = r.enc.Encode([]any{elapsed, EventMarker, "truncated: max recording size reached"})
+		return
+	}
+	r.written += int64(size)
+	_ = r.enc.Encode([]any{elapsed, kind, data})
+}
+
+// Truncated reports whether the recording exceeded MaxSize.
+func (r *Recorder) Truncated() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.truncated
+}
+
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil
+	}
+	// incomplete sequences left are recorded as is
+	if len(r.pendingOutput) > 0 {
+		r.record(EventOutput, string(r.pendingOutput), len(r.pendingOutput))
+	}
+	if len(r.pendingInput) > 0 {
+		r.record(EventInput, string(r.pendingInput), len(r.pendingInput))
+	}
+	r.closed = true
+	if r.gz != nil {
+		if err := r.gz.Close(); err != nil {
+			r.out.Close()
+			return err
+		}
+	}
+	return r.out.Close()
+}
+
+// Writer returns a writer which records data written into w as output.
+func (r *Recorder) Writer(w io.Writer) io.Writer {
+	return writerFunc(func(p []byte) (int, error) {
+		n, err := w.Write(p)
+		if n > 0 {
+			r.Output(p[:n])
+		}
+		return n, err
+	})
+}
+
+// Reader returns a reader which records data read from rd as input.
+func (r *Recorder) Reader(rd io.Reader) io.Reader {
+	return readerFunc(func(p []byte) (int, error) {
+		n, err := rd.Read(p)
+		if n > 0 {
+			r.Input(p[:n])
+		}
+		return n, err
+	})
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) { return f(p) }
+
+type readerFunc func(p []byte) (int, error)
+
+func (f readerFunc) Read(p []byte) (int, error) { return f(p) }
+
+var _ Sink = &FileSink{}
+
+// FileSink stores each recording as a file in Dir, named "<id>.cast" or "<id>.cast.gz".
+type FileSink struct {
+	Dir string
+}
+
+func (s *FileSink) Create(ctx context.Context, meta Metadata, compressed bool) (io.WriteCloser, error) {
+	if meta.ID == "" {
+		return nil, fmt.Errorf("recording id is required")
+	}
+	if err := os.MkdirAll(s.Dir, 0o755); err != nil {
+		return nil, err
+	}
+	filename := filepath.Join(s.Dir, filepath.Base(meta.ID)+".cast")
+	if compressed {
+		filename += ".gz"
+	}
+	return os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o640)
+}
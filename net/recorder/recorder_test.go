@@ -0,0 +1,173 @@
+package recorder
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type bufferSink struct {
+	buf    bytes.Buffer
+	meta   Metadata
+	closed bool
+}
+
+func (s *bufferSink) Create(ctx context.Context, meta Metadata, compressed bool) (io.WriteCloser, error) {
+	s.meta = meta
+	return s, nil
+}
+
+func (s *bufferSink) Write(p []byte) (int, error) { return s.buf.Write(p) }
+
+func (s *bufferSink) Close() error {
+	s.closed = true
+	return nil
+}
+
+// readEvents returns the header and the kind and data of events in a recording.
+func readEvents(t *testing.T, r io.Reader) (Header, [][2]string) {
+	t.Helper()
+	scanner := bufio.NewScanner(r)
+	header := Header{}
+	if !scanner.Scan() || json.Unmarshal(scanner.Bytes(), &header) != nil {
+		t.Fatalf("invalid header")
+	}
+	events := [][2]string{}
+	for scanner.Scan() {
+		event := []any{}
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil || len(event) != 3 {
+			t.Fatalf("invalid event %s: %v", scanner.Text(), err)
+		}
+		events = append(events, [2]string{event[1].(string), event[2].(string)})
+	}
+	return header, events
+}
+
+func TestRecorder(t *testing.T) {
+	tests := []struct {
+		name    string
+		options Options
+		record  func(r *Recorder)
+		want    [][2]string
+	}{
+		{
+			name:    "events",
+			options: Options{RecordInput: true},
+			record: func(r *Recorder) {
+				r.Input([]byte("ls\r"))
+				r.Output([]byte("a b\r\n"))
+				r.Resize(120, 40)
+				r.Marker("done")
+			},
+			want: [][2]string{{"i", "ls\r"}, {"o", "a b\r\n"}, {"r", "120x40"}, {"m", "done"}},
+		},
+		{
+			name: "input not recorded",
+			record: func(r *Recorder) {
+				r.Input([]byte("p@ss\r"))
+				r.Output([]byte("ok"))
+			},
+			want: [][2]string{{"o", "ok"}},
+		},
+		{
+			name:    "split characters",
+			options: Options{RecordInput: true},
+			record: func(r *Recorder) {
+				out, in := []byte("héllo 世界"), []byte("你好")
+				r.Output(out[:2])
+				r.Input(in[:1])
+				r.Output(out[2:9])
+				r.Input(in[1:4])
+				r.Output(out[9:10])
+				r.Output(out[10:])
+				r.Input(in[4:])
+			},
+			want: [][2]string{{"o", "h"}, {"o", "éllo "}, {"i", "你"}, {"o", "世"}, {"o", "界"}, {"i", "好"}},
+		},
+		{
+			name: "incomplete at close",
+			record: func(r *Recorder) {
+				r.Output([]byte("ok\xe4\xb8"))
+			},
+			want: [][2]string{{"o", "ok"}, {"o", "\ufffd\ufffd"}}, // invalid bytes are replaced on encoding
+		},
+		{
+			name:    "truncated",
+			options: Options{MaxSize: 5},
+			record: func(r *Recorder) {
+				r.Output([]byte("1234"))
+				r.Output([]byte("5678"))
+				r.Output([]byte("9"))
+			},
+			want: [][2]string{{"o", "1234"}, {"m", "truncated: max recording size reached"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := &bufferSink{}
+			options := tt.options
+			r, err := New(context.Background(), sink, Metadata{ID: "1"}, &options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.record(r)
+			if err := r.Close(); err != nil || !sink.closed {
+				t.Fatalf("Close() = %v, sink closed %v", err, sink.closed)
+			}
+			r.Output([]byte("after close"))
+			_, events := readEvents(t, &sink.buf)
+			if len(events) != len(tt.want) {
+				t.Fatalf("events = %q, want %q", events, tt.want)
+			}
+			for i := range events {
+				if events[i] != tt.want[i] {
+					t.Errorf("event %d = %q, want %q", i, events[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	sink := &FileSink{Dir: t.TempDir()}
+	r, err := New(context.Background(), sink, Metadata{ID: "../session-1", Header: Header{Command: "top"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	io.WriteString(r.Writer(&out), "hello")
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "hello" {
+		t.Errorf("written = %q", out.String())
+	}
+
+	f, err := os.Open(filepath.Join(sink.Dir, "session-1.cast.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, events := readEvents(t, gz)
+	if header.Version != 2 || header.Width != 80 || header.Height != 24 || header.Command != "top" {
+		t.Errorf("header = %+v", header)
+	}
+	if len(events) != 1 || events[0] != [2]string{"o", "hello"} {
+		t.Errorf("events = %q", events)
+	}
+
+	if _, err := New(context.Background(), sink, Metadata{}, nil); err == nil || !strings.Contains(err.Error(), "id") {
+		t.Errorf("New() without id error = %v", err)
+	}
+}
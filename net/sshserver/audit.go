@@ -0,0 +1,151 @@
+// Copyright 2023 The Kubegems Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sshserver
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/exp/slices"
+	"kubegems.io/library/rest/api"
+)
+
+// https://datatracker.ietf.org/doc/html/rfc4254#section-6.4
+type envPayload struct {
+	Name  string
+	Value string
+}
+
+// https://datatracker.ietf.org/doc/html/rfc4254#section-6.5
+type subsystemPayload struct {
+	Name string
+}
+
+// https://datatracker.ietf.org/doc/html/rfc4254#section-6.10
+type exitStatusPayload struct {
+	Status uint32
+}
+
+// SSHAuditor saves audit logs of ssh connections and sessions into Sink,
+// the logs carry the session metadata in AuditLog.SSH.
+type SSHAuditor struct {
+	Sink api.AuditSink
+	// EnvNames are the env recorded, empty records all, values may be sensitive.
+	EnvNames []string
+}
+
+func NewSSHAuditor(sink api.AuditSink, envNames ...string) *SSHAuditor {
+	return &SSHAuditor{Sink: sink, EnvNames: envNames}
+}
+
+// NewAuditLog returns an audit log of conn ended now.
+func (a *SSHAuditor) NewAuditLog(conn *Conn, action string, start time.Time) *api.AuditLog {
+	return &api.AuditLog{
+		AuditID:   api.NewAuditID(),
+		SSH:       AuditSSHFromConn(conn),
+		Subject:   conn.Info.User.Name,
+		Action:    action,
+		StartTime: start,
+		EndTime:   time.Now(),
+	}
+}
+
+// Session wraps a SessionHandler to save an audit log per session when it ends,
+// the action is "shell", "exec" or "subsystem", with the command, env and exit status recorded.
+// Sessions without those requests are not audited.
+func (a *SSHAuditor) Session(next SessionHandler) SessionHandler {
+	return func(ctx context.Context, conn *Conn, ch ssh.Channel, reqs <-chan *ssh.Request) {
+		start := time.Now()
+		session := &auditedSession{Channel: ch}
+		forwarded := make(chan *ssh.Request, cap(reqs))
+		go func() {
+			defer close(forwarded)
+			for req := range reqs {
+				session.onRequest(req, a.EnvNames)
+				forwarded <- req
+			}
+		}()
+		defer func() {
+			action, command, env, status := session.snapshot()
+			if action == "" {
+				return
+			}
+			auditlog := a.NewAuditLog(conn, action, start)
+			auditlog.SSH.Command, auditlog.SSH.Env = command, env
+			if status != nil {
+				auditlog.Metadata = api.AuditExtraMetadata{"exitStatus": strconv.FormatUint(uint64(*status), 10)}
+			}
+			_ = a.Sink.Save(auditlog)
+		}()
+		next(ctx, conn, session, forwarded)
+	}
+}
+
+// auditedSession captures the requests from client and the exit status sent by the handler.
+type auditedSession struct {
+	ssh.Channel
+	mu         sync.Mutex
+	action     string
+	command    string
+	env        []string
+	exitStatus *uint32
+}
+
+func (s *auditedSession) onRequest(req *ssh.Request, envNames []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch req.Type {
+	case "env":
+		payload := envPayload{}
+		if ssh.Unmarshal(req.Payload, &payload) == nil && (len(envNames) == 0 || slices.Contains(envNames, payload.Name)) {
+			s.env = append(s.env, payload.Name+"="+payload.Value)
+		}
+	case "shell":
+		if s.action == "" {
+			s.action = "shell"
+		}
+	case "exec":
+		payload := execPayload{}
+		if s.action == "" && ssh.Unmarshal(req.Payload, &payload) == nil {
+			s.action, s.command = "exec", payload.Command
+		}
+	case "subsystem":
+		payload := subsystemPayload{}
+		if s.action == "" && ssh.Unmarshal(req.Payload, &payload) == nil {
+			s.action, s.command = "subsystem", payload.Name
+		}
+	}
+}
+
+func (s *auditedSession) SendRequest(name string, wantReply bool, payload []byte) (bool, error) {
+	if name == "exit-status" {
+		status := exitStatusPayload{}
+		if ssh.Unmarshal(payload, &status) == nil {
+			s.mu.Lock()
+			s.exitStatus = &status.Status
+			s.mu.Unlock()
+		}
+	}
+	return s.Channel.SendRequest(name, wantReply, payload)
+}
+
+func (s *auditedSession) snapshot() (string, string, []string, *uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.action, s.command, slices.Clone(s.env), s.exitStatus
+}
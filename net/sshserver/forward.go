@@ -0,0 +1,199 @@
+// Copyright 2023 The Kubegems Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sshserver
+
+import (
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// https://datatracker.ietf.org/doc/html/rfc4254#section-7.2
+type directTCPIPPayload struct {
+	DestAddr   string
+	DestPort   uint32
+	OriginAddr string
+	OriginPort uint32
+}
+
+// https://datatracker.ietf.org/doc/html/rfc4254#section-7.1
+type tcpipForwardPayload struct {
+	BindAddr string
+	BindPort uint32
+}
+
+type tcpipForwardReply struct {
+	BindPort uint32
+}
+
+type forwardedTCPIPPayload struct {
+	DestAddr   string
+	DestPort   uint32
+	OriginAddr string
+	OriginPort uint32
+}
+
+func (s *Server) handleDirectTCPIP(ctx context.Context, conn *Conn, newch ssh.NewChannel) {
+	payload := directTCPIPPayload{}
+	if err := ssh.Unmarshal(newch.ExtraData(), &payload); err != nil {
+		_ = newch.Reject(ssh.ConnectionFailed, "invalid payload")
+		return
+	}
+	resources, err := s.authorizeForward(ctx, conn, "direct-tcpip", payload.DestAddr, payload.DestPort)
+	if err != nil {
+		_ = newch.Reject(ssh.Prohibited, err.Error())
+		return
+	}
+	dest := net.JoinHostPort(payload.DestAddr, strconv.FormatUint(uint64(payload.DestPort), 10))
+	dialer := net.Dialer{Timeout: 10 * time.Second}
+	target, err := dialer.DialContext(ctx, "tcp", dest)
+	if err != nil {
+		_ = newch.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	ch, reqs, err := newch.Accept()
+	if err != nil {
+		target.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	start := time.Now()
+	in, out := pipe(ch, target)
+	conn.BytesIn.Add(in)
+	conn.BytesOut.Add(out)
+	s.audit(conn, "port-forward", resources, start, in, out)
+}
+
+func (s *Server) handleGlobalRequests(ctx context.Context, conn *Conn, reqs <-chan *ssh.Request) {
+	for req := range reqs {
+		switch req.Type {
+		case "tcpip-forward":
+			s.handleTCPIPForward(ctx, conn, req)
+		case "cancel-tcpip-forward":
+			payload := tcpipForwardPayload{}
+			if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+				_ = req.Reply(false, nil)
+				continue
+			}
+			addr := net.JoinHostPort(payload.BindAddr, strconv.FormatUint(uint64(payload.BindPort), 10))
+			conn.mu.Lock()
+			l, ok := conn.listeners[addr]
+			delete(conn.listeners, addr)
+			conn.mu.Unlock()
+			if ok {
+				l.Close()
+			}
+			_ = req.Reply(ok, nil)
+		default:
+			if req.WantReply {
+				_ = req.Reply(false, nil)
+			}
+		}
+	}
+}
+
+func (s *Server) handleTCPIPForward(ctx context.Context, conn *Conn, req *ssh.Request) {
+	payload := tcpipForwardPayload{}
+	if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+		_ = req.Reply(false, nil)
+		return
+	}
+	resources, err := s.authorizeForward(ctx, conn, "tcpip-forward", payload.BindAddr, payload.BindPort)
+	if err != nil {
+		_ = req.Reply(false, nil)
+		return
+	}
+	l, err := net.Listen("tcp", net.JoinHostPort(payload.BindAddr, strconv.FormatUint(uint64(payload.BindPort), 10)))
+	if err != nil {
+		_ = req.Reply(false, nil)
+		return
+	}
+	_, portstr, _ := net.SplitHostPort(l.Addr().String())
+	port, _ := strconv.ParseUint(portstr, 10, 32)
+	// the key must be the address requested by client which is used to cancel
+	key := net.JoinHostPort(payload.BindAddr, strconv.FormatUint(uint64(payload.BindPort), 10))
+	if payload.BindPort == 0 {
+		key = net.JoinHostPort(payload.BindAddr, portstr)
+	}
+	conn.mu.Lock()
+	conn.listeners[key] = l
+	conn.mu.Unlock()
+	_ = req.Reply(true, ssh.Marshal(tcpipForwardReply{BindPort: uint32(port)}))
+
+	go func() {
+		start := time.Now()
+		var in, out atomic.Int64
+		defer func() {
+			s.audit(conn, "reverse-port-forward", resources, start, in.Load(), out.Load())
+		}()
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				originhost, originport, _ := net.SplitHostPort(c.RemoteAddr().String())
+				oport, _ := strconv.ParseUint(originport, 10, 32)
+				ch, reqs, err := conn.OpenChannel("forwarded-tcpip", ssh.Marshal(forwardedTCPIPPayload{
+					DestAddr:   payload.BindAddr,
+					DestPort:   uint32(port),
+					OriginAddr: originhost,
+					OriginPort: uint32(oport),
+				}))
+				if err != nil {
+					c.Close()
+					return
+				}
+				go ssh.DiscardRequests(reqs)
+				cin, cout := pipe(ch, c)
+				in.Add(cin)
+				out.Add(cout)
+				conn.BytesIn.Add(cin)
+				conn.BytesOut.Add(cout)
+			}()
+		}
+	}()
+}
+
+// pipe copies data between ssh channel and conn until both directions finish,
+// returns bytes received from and sent to the ssh client.
+func pipe(ch ssh.Channel, conn net.Conn) (int64, int64) {
+	var in, out int64
+	wg := sync.WaitGroup{}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		in, _ = io.Copy(conn, ch)
+		if tcpconn, ok := conn.(*net.TCPConn); ok {
+			_ = tcpconn.CloseWrite()
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		out, _ = io.Copy(ch, conn)
+		_ = ch.CloseWrite()
+	}()
+	wg.Wait()
+	ch.Close()
+	conn.Close()
+	return in, out
+}
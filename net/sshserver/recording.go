@@ -0,0 +1,162 @@
+// Copyright 2023 The Kubegems Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sshserver
+
+import (
+	"context"
+	"encoding/hex"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"golang.org/x/crypto/ssh"
+	"kubegems.io/library/net/recorder"
+)
+
+// https://datatracker.ietf.org/doc/html/rfc4254#section-6.2
+type ptyRequestPayload struct {
+	Term     string
+	Columns  uint32
+	Rows     uint32
+	Width    uint32
+	Height   uint32
+	Modelist string
+}
+
+// https://datatracker.ietf.org/doc/html/rfc4254#section-6.7
+type windowChangePayload struct {
+	Columns uint32
+	Rows    uint32
+	Width   uint32
+	Height  uint32
+}
+
+// https://datatracker.ietf.org/doc/html/rfc4254#section-6.5
+type execPayload struct {
+	Command string
+}
+
+// RecordingSession wraps a SessionHandler to record the session into sink.
+// Recording starts at the first "shell" or "exec" request, the terminal size is taken from "pty-req".
+func RecordingSession(sink recorder.Sink, options *recorder.Options, next SessionHandler) SessionHandler {
+	return func(ctx context.Context, conn *Conn, ch ssh.Channel, reqs <-chan *ssh.Request) {
+		log := logr.FromContextOrDiscard(ctx)
+		rch := &recordingChannel{Channel: ch}
+		forwarded := make(chan *ssh.Request, cap(reqs))
+		go func() {
+			defer close(forwarded)
+			meta := recorder.Metadata{
+				User:      conn.Info.User.Name,
+				SessionID: hex.EncodeToString(conn.SessionID()),
+				Header:    recorder.Header{Env: map[string]string{}},
+			}
+			for req := range reqs {
+				switch req.Type {
+				case "pty-req":
+					payload := ptyRequestPayload{}
+					if ssh.Unmarshal(req.Payload, &payload) == nil {
+						meta.Header.Width, meta.Header.Height = int(payload.Columns), int(payload.Rows)
+						meta.Header.Env["TERM"] = payload.Term
+					}
+				case "window-change":
+					payload := windowChangePayload{}
+					if ssh.Unmarshal(req.Payload, &payload) == nil {
+						if rec := rch.recorder(); rec != nil {
+							rec.Resize(int(payload.Columns), int(payload.Rows))
+						}
+					}
+				case "shell", "exec":
+					if rch.recorder() == nil {
+						if req.Type == "exec" {
+							payload := execPayload{}
+							_ = ssh.Unmarshal(req.Payload, &payload)
+							meta.Header.Command = payload.Command
+						}
+						meta.StartTime = time.Now()
+						meta.ID = meta.SessionID + "-" + strconv.FormatInt(meta.StartTime.UnixNano(), 10)
+						rec, err := recorder.New(ctx, sink, meta, options)
+						if err != nil {
+							log.Error(err, "start session recording", "user", meta.User)
+						} else {
+							rch.setRecorder(rec)
+						}
+					}
+				}
+				forwarded <- req
+			}
+		}()
+		defer func() {
+			if rec := rch.recorder(); rec != nil {
+				_ = rec.Close()
+			}
+		}()
+		next(ctx, conn, rch, forwarded)
+	}
+}
+
+type recordingChannel struct {
+	ssh.Channel
+	mu  sync.Mutex
+	rec *recorder.Recorder
+}
+
+func (c *recordingChannel) recorder() *recorder.Recorder {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rec
+}
+
+func (c *recordingChannel) setRecorder(rec *recorder.Recorder) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rec = rec
+}
+
+func (c *recordingChannel) Read(p []byte) (int, error) {
+	n, err := c.Channel.Read(p)
+	if rec := c.recorder(); rec != nil && n > 0 {
+		rec.Input(p[:n])
+	}
+	return n, err
+}
+
+func (c *recordingChannel) Write(p []byte) (int, error) {
+	n, err := c.Channel.Write(p)
+	if rec := c.recorder(); rec != nil && n > 0 {
+		rec.Output(p[:n])
+	}
+	return n, err
+}
+
+// Stderr records data written as output, the recorder is resolved at write time
+// as stderr may be taken before the recording starts.
+func (c *recordingChannel) Stderr() io.ReadWriter {
+	return &recordingStderr{ReadWriter: c.Channel.Stderr(), channel: c}
+}
+
+type recordingStderr struct {
+	io.ReadWriter
+	channel *recordingChannel
+}
+
+func (s *recordingStderr) Write(p []byte) (int, error) {
+	n, err := s.ReadWriter.Write(p)
+	if rec := s.channel.recorder(); rec != nil && n > 0 {
+		rec.Output(p[:n])
+	}
+	return n, err
+}
@@ -0,0 +1,120 @@
+package sshserver
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"io"
+	"net"
+	"sync"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"kubegems.io/library/net/recorder"
+)
+
+type bufferSink struct {
+	mu     sync.Mutex
+	buf    bytes.Buffer
+	meta   recorder.Metadata
+	closed chan struct{}
+}
+
+func (s *bufferSink) Create(ctx context.Context, meta recorder.Metadata, compressed bool) (io.WriteCloser, error) {
+	s.meta = meta
+	return s, nil
+}
+
+func (s *bufferSink) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.buf.Write(p)
+}
+
+func (s *bufferSink) Close() error {
+	close(s.closed)
+	return nil
+}
+
+func TestRecordingSession(t *testing.T) {
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	signer, _ := ssh.NewSignerFromKey(priv)
+	sink := &bufferSink{closed: make(chan struct{})}
+	handler := func(ctx context.Context, conn *Conn, ch ssh.Channel, reqs <-chan *ssh.Request) {
+		// taken before the recording starts
+		stderr := ch.Stderr()
+		for req := range reqs {
+			_ = req.Reply(true, nil)
+			if req.Type == "exec" {
+				out := []byte("héllo\n")
+				ch.Write(out[:2])
+				ch.Write(out[2:])
+				stderr.Write([]byte("oops\n"))
+				_, _ = ch.SendRequest("exit-status", false, ssh.Marshal(exitStatusPayload{}))
+				ch.Close()
+				return
+			}
+		}
+	}
+	server := &Server{
+		HostKeys:      []ssh.Signer{signer},
+		Authenticator: testAuthenticator{},
+		Session:       RecordingSession(sink, &recorder.Options{DefaultWidth: 80, DefaultHeight: 24}, handler),
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.Serve(ctx, l)
+
+	client, err := ssh.Dial("tcp", l.Addr().String(), &ssh.ClientConfig{
+		User:            "alice",
+		Auth:            []ssh.AuthMethod{ssh.Password("secret")},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	session.Stdout, session.Stderr = stdout, stderr
+	if err := session.Run("uptime"); err != nil {
+		t.Fatal(err)
+	}
+	if stdout.String() != "héllo\n" || stderr.String() != "oops\n" {
+		t.Errorf("stdout = %q, stderr = %q", stdout.String(), stderr.String())
+	}
+
+	<-sink.closed
+	if sink.meta.User != "alice" || sink.meta.Header.Command != "uptime" {
+		t.Errorf("metadata = %+v", sink.meta)
+	}
+	scanner := bufio.NewScanner(&sink.buf)
+	scanner.Scan() // header
+	outputs := []string{}
+	for scanner.Scan() {
+		event := []any{}
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatal(err)
+		}
+		outputs = append(outputs, event[2].(string))
+	}
+	want := []string{"h", "éllo\n", "oops\n"}
+	if len(outputs) != len(want) {
+		t.Fatalf("recorded = %q, want %q", outputs, want)
+	}
+	for i := range want {
+		if outputs[i] != want[i] {
+			t.Errorf("recorded %d = %q, want %q", i, outputs[i], want[i])
+		}
+	}
+}
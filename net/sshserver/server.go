@@ -0,0 +1,311 @@
+// Copyright 2023 The Kubegems Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sshserver
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-logr/logr"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/exp/maps"
+	"kubegems.io/library/rest/api"
+)
+
+// SessionHandler handles a "session" channel, e.g. shell or exec.
+type SessionHandler func(ctx context.Context, conn *Conn, ch ssh.Channel, reqs <-chan *ssh.Request)
+
+type Server struct {
+	Addr          string
+	HostKeys      []ssh.Signer
+	ServerVersion string
+	Authenticator api.SSHAuthenticator
+	Authorizer    api.Authorizer       // authorize port forwarding, nil denies all forwarding
+	AuditSink     api.AuditSink        // optional, audits connections and forwarding, wrap Session with SSHAuditor to audit sessions
+	Session       SessionHandler       // nil rejects session channels
+	Sessions      *api.SessionRegistry // optional, limits concurrent connections per user
+}
+
+// Conn is an authenticated ssh connection.
+type Conn struct {
+	*ssh.ServerConn
+	Info      api.AuthenticateInfo
+	PublicKey ssh.PublicKey // public key used to authenticate, nil if password used
+	StartTime time.Time
+
+	BytesIn  atomic.Int64 // bytes received from client on forwarded channels
+	BytesOut atomic.Int64 // bytes sent to client on forwarded channels
+
+	mu        sync.Mutex
+	listeners map[string]net.Listener // reverse forwarding listeners
+}
+
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	l, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ctx, l)
+}
+
+func (s *Server) Serve(ctx context.Context, l net.Listener) error {
+	log := logr.FromContextOrDiscard(ctx)
+	go func() {
+		<-ctx.Done()
+		log.Info("closing ssh server", "listen", l.Addr().String())
+		l.Close()
+	}()
+	log.Info("starting ssh server", "listen", l.Addr().String())
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go s.handleConn(ctx, conn)
+	}
+}
+
+// The extensions carrying the identity of a login from the auth callbacks to the connection,
+// only the permissions of the accepted login are kept in ssh.ServerConn, not the ones of rejected attempts.
+const (
+	extensionAuthenticateInfo = "authenticate-info@kubegems.io"
+	extensionPublicKey        = "public-key@kubegems.io"
+)
+
+func (s *Server) serverConfig() *ssh.ServerConfig {
+	config := &ssh.ServerConfig{ServerVersion: s.ServerVersion}
+	if config.ServerVersion == "" {
+		config.ServerVersion = "SSH-2.0-kubegems"
+	}
+	for _, key := range s.HostKeys {
+		config.AddHostKey(key)
+	}
+	config.PasswordCallback = func(meta ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+		ctx := api.WithClientIP(context.Background(), remoteIP(meta.RemoteAddr()))
+		info, err := s.Authenticator.Authenticate(ctx, meta.User(), string(password))
+		if err != nil {
+			return nil, err
+		}
+		return identityPermissions(&ssh.Permissions{}, info, nil)
+	}
+	config.PublicKeyCallback = func(meta ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+		ctx := api.WithClientIP(context.Background(), remoteIP(meta.RemoteAddr()))
+		ctx = api.WithSSHUser(ctx, meta.User())
+		// the key is only offered here, the login may still fail, e.g. without a valid signature
+		info, err := s.Authenticator.AuthenticatePublibcKey(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		return identityPermissions(keyPermissions(key), info, key)
+	}
+	return config
+}
+
+// keyPermissions returns the permissions of a certificate for certificate logins,
+// ssh.ServerConn enforces the source-address critical option only through the returned permissions.
+func keyPermissions(key ssh.PublicKey) *ssh.Permissions {
+	cert, ok := key.(*ssh.Certificate)
+	if !ok {
+		return &ssh.Permissions{}
+	}
+	return &ssh.Permissions{
+		CriticalOptions: maps.Clone(cert.CriticalOptions),
+		Extensions:      maps.Clone(cert.Extensions),
+	}
+}
+
+// identityPermissions adds the identity of a login to permissions, see connIdentity.
+func identityPermissions(permissions *ssh.Permissions, info *api.AuthenticateInfo, key ssh.PublicKey) (*ssh.Permissions, error) {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return nil, err
+	}
+	if permissions.Extensions == nil {
+		permissions.Extensions = map[string]string{}
+	}
+	permissions.Extensions[extensionAuthenticateInfo] = string(data)
+	if key != nil {
+		permissions.Extensions[extensionPublicKey] = string(key.Marshal())
+	}
+	return permissions, nil
+}
+
+// connIdentity reads the identity of the accepted login and removes it from permissions,
+// so the extensions left are the ones of the certificate.
+func connIdentity(permissions *ssh.Permissions) (api.AuthenticateInfo, ssh.PublicKey, error) {
+	info := api.AuthenticateInfo{}
+	if permissions == nil {
+		return info, nil, fmt.Errorf("no permissions of the login")
+	}
+	data, ok := permissions.Extensions[extensionAuthenticateInfo]
+	if !ok {
+		return info, nil, fmt.Errorf("no identity of the login")
+	}
+	if err := json.Unmarshal([]byte(data), &info); err != nil {
+		return info, nil, err
+	}
+	var key ssh.PublicKey
+	if data, ok := permissions.Extensions[extensionPublicKey]; ok {
+		parsed, err := ssh.ParsePublicKey([]byte(data))
+		if err != nil {
+			return info, nil, err
+		}
+		key = parsed
+	}
+	delete(permissions.Extensions, extensionAuthenticateInfo)
+	delete(permissions.Extensions, extensionPublicKey)
+	return info, key, nil
+}
+
+func remoteIP(addr net.Addr) string {
+	if tcp, ok := addr.(*net.TCPAddr); ok {
+		return tcp.IP.String()
+	}
+	return addr.String()
+}
+
+func (s *Server) handleConn(ctx context.Context, nconn net.Conn) {
+	log := logr.FromContextOrDiscard(ctx).WithValues("remote", nconn.RemoteAddr().String())
+	conn := &Conn{StartTime: time.Now(), listeners: map[string]net.Listener{}}
+	sconn, chans, reqs, err := ssh.NewServerConn(nconn, s.serverConfig())
+	if err != nil {
+		log.V(1).Info("ssh handshake failed", "error", err.Error())
+		nconn.Close()
+		return
+	}
+	conn.ServerConn = sconn
+	if conn.Info, conn.PublicKey, err = connIdentity(sconn.Permissions); err != nil {
+		log.Info("ssh login without identity", "error", err.Error())
+		sconn.Close()
+		return
+	}
+
+	if s.Sessions != nil {
+		id := api.SessionTypeSSH + "-" + hex.EncodeToString(sconn.SessionID())[:16]
+		session := api.Session{
+			ID:         id,
+			Type:       api.SessionTypeSSH,
+			User:       conn.Info.User.Name,
+			RemoteAddr: nconn.RemoteAddr().String(),
+			StartTime:  conn.StartTime,
+		}
+		if err := s.Sessions.Open(session, func() { sconn.Close() }); err != nil {
+			log.Info("ssh session rejected", "user", session.User, "reason", err.Error())
+			sconn.Close()
+			return
+		}
+		defer s.Sessions.Close(id)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		sconn.Close()
+	}()
+	go s.handleGlobalRequests(ctx, conn, reqs)
+
+	for newch := range chans {
+		switch newch.ChannelType() {
+		case "session":
+			if s.Session == nil {
+				_ = newch.Reject(ssh.UnknownChannelType, "session not supported")
+				continue
+			}
+			ch, chreqs, err := newch.Accept()
+			if err != nil {
+				continue
+			}
+			go s.Session(ctx, conn, ch, chreqs)
+		case "direct-tcpip":
+			go s.handleDirectTCPIP(ctx, conn, newch)
+		default:
+			_ = newch.Reject(ssh.UnknownChannelType, "unsupported channel type")
+		}
+	}
+	conn.closeListeners()
+	s.audit(conn, "connect", nil, conn.StartTime, conn.BytesIn.Load(), conn.BytesOut.Load())
+}
+
+func (s *Server) audit(conn *Conn, action string, resources []api.AttributeResource, start time.Time, in, out int64) {
+	if s.AuditSink == nil {
+		return
+	}
+	auditlog := NewSSHAuditor(s.AuditSink).NewAuditLog(conn, action, start)
+	auditlog.Metadata = api.AuditExtraMetadata{
+		"bytesIn":  strconv.FormatInt(in, 10),
+		"bytesOut": strconv.FormatInt(out, 10),
+	}
+	if size := len(resources); size > 0 {
+		auditlog.Parents, auditlog.Resource, auditlog.ResourceName = resources[:size-1], resources[size-1].Resource, resources[size-1].Name
+	}
+	_ = s.AuditSink.Save(auditlog)
+}
+
+func AuditSSHFromConn(conn *Conn) *api.AuditSSH {
+	auditssh := &api.AuditSSH{
+		User:          conn.User(),
+		RemoteAddr:    conn.RemoteAddr().String(),
+		LocalAddr:     conn.LocalAddr().String(),
+		SessionID:     hex.EncodeToString(conn.SessionID()),
+		ClientVersion: string(conn.ClientVersion()),
+		ServerVersion: string(conn.ServerVersion()),
+	}
+	if conn.PublicKey != nil {
+		auditssh.PublicKey = ssh.FingerprintSHA256(conn.PublicKey)
+	}
+	return auditssh
+}
+
+func (c *Conn) closeListeners() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for addr, l := range c.listeners {
+		l.Close()
+		delete(c.listeners, addr)
+	}
+}
+
+func (s *Server) authorizeForward(ctx context.Context, conn *Conn, path string, host string, port uint32) ([]api.AttributeResource, error) {
+	resources := []api.AttributeResource{
+		{Resource: "hosts", Name: host},
+		{Resource: "ports", Name: strconv.FormatUint(uint64(port), 10)},
+	}
+	if s.Authorizer == nil {
+		return resources, fmt.Errorf("port forwarding disabled")
+	}
+	attrs := api.Attributes{Action: "port-forward", Resources: resources, Path: path}
+	decision, reason, err := s.Authorizer.Authorize(ctx, conn.Info.User, attrs)
+	if err != nil {
+		return resources, err
+	}
+	if decision != api.DecisionAllow {
+		if reason == "" {
+			reason = "access denied"
+		}
+		return resources, fmt.Errorf("%s", reason)
+	}
+	return resources, nil
+}
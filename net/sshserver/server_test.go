@@ -0,0 +1,348 @@
+package sshserver
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"kubegems.io/library/rest/api"
+)
+
+type testAuthenticator struct{}
+
+func (testAuthenticator) Authenticate(ctx context.Context, username, password string) (*api.AuthenticateInfo, error) {
+	if password != "secret" {
+		return nil, fmt.Errorf("invalid password")
+	}
+	return &api.AuthenticateInfo{User: api.UserInfo{Name: username}}, nil
+}
+
+func (testAuthenticator) AuthenticatePublibcKey(ctx context.Context, pubkey ssh.PublicKey) (*api.AuthenticateInfo, error) {
+	return nil, fmt.Errorf("not supported")
+}
+
+type chanAuditSink chan *api.AuditLog
+
+func (c chanAuditSink) Save(log *api.AuditLog) error {
+	c <- log
+	return nil
+}
+
+func TestServerDirectTCPIP(t *testing.T) {
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		for {
+			c, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(c, c)
+				c.Close()
+			}()
+		}
+	}()
+	_, echoport, _ := net.SplitHostPort(echo.Addr().String())
+
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	signer, _ := ssh.NewSignerFromKey(priv)
+	sink := make(chanAuditSink, 4)
+	server := &Server{
+		HostKeys:      []ssh.Signer{signer},
+		Authenticator: testAuthenticator{},
+		AuditSink:     sink,
+		Authorizer: api.AuthorizerFunc(func(ctx context.Context, user api.UserInfo, a api.Attributes) (api.Decision, string, error) {
+			if a.Action == "port-forward" && a.Resources[1].Name == echoport {
+				return api.DecisionAllow, "", nil
+			}
+			return api.DecisionDeny, "denied", nil
+		}),
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.Serve(ctx, l)
+
+	client, err := ssh.Dial("tcp", l.Addr().String(), &ssh.ClientConfig{
+		User:            "alice",
+		Auth:            []ssh.AuthMethod{ssh.Password("secret")},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if _, err := client.Dial("tcp", "127.0.0.1:1"); err == nil {
+		t.Errorf("forward to unauthorized port expected error")
+	}
+	conn, err := client.Dial("tcp", echo.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "hello" {
+		t.Errorf("read = %q, err = %v", buf, err)
+	}
+	conn.Close()
+
+	auditlog := <-sink
+	if auditlog.Action != "port-forward" || auditlog.Subject != "alice" ||
+		auditlog.Metadata["bytesIn"] != "5" || auditlog.Metadata["bytesOut"] != "5" {
+		t.Errorf("unexpected audit log: %+v", auditlog)
+	}
+}
+
+func TestSSHAuditorSession(t *testing.T) {
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	signer, _ := ssh.NewSignerFromKey(priv)
+	sink := make(chanAuditSink, 4)
+	handler := func(ctx context.Context, conn *Conn, ch ssh.Channel, reqs <-chan *ssh.Request) {
+		for req := range reqs {
+			_ = req.Reply(true, nil)
+			if req.Type == "exec" {
+				_, _ = ch.SendRequest("exit-status", false, ssh.Marshal(exitStatusPayload{Status: 3}))
+				ch.Close()
+				return
+			}
+		}
+	}
+	server := &Server{
+		HostKeys:      []ssh.Signer{signer},
+		Authenticator: testAuthenticator{},
+		Session:       NewSSHAuditor(sink, "LANG").Session(handler),
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.Serve(ctx, l)
+
+	client, err := ssh.Dial("tcp", l.Addr().String(), &ssh.ClientConfig{
+		User:            "alice",
+		Auth:            []ssh.AuthMethod{ssh.Password("secret")},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = session.Setenv("LANG", "C")
+	_ = session.Setenv("TOKEN", "secret")
+	if err := session.Run("uptime"); err == nil {
+		t.Errorf("Run() expected exit error")
+	}
+
+	auditlog := <-sink
+	if auditlog.Action != "exec" || auditlog.Subject != "alice" || auditlog.SSH == nil ||
+		auditlog.SSH.Command != "uptime" || len(auditlog.SSH.Env) != 1 || auditlog.SSH.Env[0] != "LANG=C" ||
+		auditlog.SSH.ClientVersion == "" || auditlog.Metadata["exitStatus"] != "3" {
+		t.Errorf("unexpected audit log: %+v, ssh: %+v", auditlog, auditlog.SSH)
+	}
+}
+
+func TestServerCertSourceAddress(t *testing.T) {
+	newSigner := func() ssh.Signer {
+		_, priv, _ := ed25519.GenerateKey(rand.Reader)
+		signer, _ := ssh.NewSignerFromKey(priv)
+		return signer
+	}
+	hostkey, ca, user := newSigner(), newSigner(), newSigner()
+	server := &Server{
+		HostKeys:      []ssh.Signer{hostkey},
+		Authenticator: api.NewCertAuthenticator([]ssh.PublicKey{ca.PublicKey()}, nil),
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.Serve(ctx, l)
+
+	tests := []struct {
+		name          string
+		sourceAddress string
+		wantErr       bool
+	}{
+		{name: "no source address"},
+		{name: "allowed source address", sourceAddress: "127.0.0.1/32"},
+		{name: "denied source address", sourceAddress: "10.0.0.0/8", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cert := &ssh.Certificate{
+				Key:             user.PublicKey(),
+				KeyId:           "alice",
+				CertType:        ssh.UserCert,
+				ValidPrincipals: []string{"alice"},
+				ValidBefore:     ssh.CertTimeInfinity,
+			}
+			if tt.sourceAddress != "" {
+				cert.CriticalOptions = map[string]string{"source-address": tt.sourceAddress}
+			}
+			if err := cert.SignCert(rand.Reader, ca); err != nil {
+				t.Fatal(err)
+			}
+			certsigner, err := ssh.NewCertSigner(cert, user)
+			if err != nil {
+				t.Fatal(err)
+			}
+			client, err := ssh.Dial("tcp", l.Addr().String(), &ssh.ClientConfig{
+				User:            "alice",
+				Auth:            []ssh.AuthMethod{ssh.PublicKeys(certsigner)},
+				HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Dial() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if client != nil {
+				client.Close()
+			}
+		})
+	}
+}
+
+// keyAuthenticator accepts any public key as user "keyholder" and passwords as testAuthenticator.
+type keyAuthenticator struct {
+	testAuthenticator
+}
+
+func (keyAuthenticator) AuthenticatePublibcKey(ctx context.Context, pubkey ssh.PublicKey) (*api.AuthenticateInfo, error) {
+	return &api.AuthenticateInfo{User: api.UserInfo{Name: "keyholder"}}, nil
+}
+
+type testConnMetadata struct {
+	user string
+}
+
+func (m testConnMetadata) User() string          { return m.user }
+func (m testConnMetadata) SessionID() []byte     { return []byte("session") }
+func (m testConnMetadata) ClientVersion() []byte { return []byte("SSH-2.0-test") }
+func (m testConnMetadata) ServerVersion() []byte { return []byte("SSH-2.0-kubegems") }
+func (m testConnMetadata) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 22}
+}
+func (m testConnMetadata) LocalAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 2222}
+}
+
+// An offered public key is accepted by the callback before the client proves it holds the private key,
+// the identity of the login is the one of the method that finally succeeds.
+func TestServerKeyOfferedThenPassword(t *testing.T) {
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	userkey, _ := ssh.NewSignerFromKey(priv)
+	config := (&Server{Authenticator: keyAuthenticator{}}).serverConfig()
+	meta := testConnMetadata{user: "alice"}
+
+	if _, err := config.PublicKeyCallback(meta, userkey.PublicKey()); err != nil {
+		t.Fatalf("offered key rejected: %v", err)
+	}
+	// the client fails to sign and falls back to password
+	if _, err := config.PasswordCallback(meta, []byte("wrong")); err == nil {
+		t.Fatal("wrong password accepted")
+	}
+	permissions, err := config.PasswordCallback(meta, []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, key, err := connIdentity(permissions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.User.Name != "alice" || key != nil {
+		t.Errorf("identity = %s %v, want alice without public key", info.User.Name, key)
+	}
+}
+
+func TestServerConnIdentity(t *testing.T) {
+	newSigner := func() ssh.Signer {
+		_, priv, _ := ed25519.GenerateKey(rand.Reader)
+		signer, _ := ssh.NewSignerFromKey(priv)
+		return signer
+	}
+	hostkey, userkey := newSigner(), newSigner()
+	conns := make(chan *Conn, 1)
+	server := &Server{
+		HostKeys:      []ssh.Signer{hostkey},
+		Authenticator: keyAuthenticator{},
+		Session: func(ctx context.Context, conn *Conn, ch ssh.Channel, reqs <-chan *ssh.Request) {
+			conns <- conn
+			ch.Close()
+		},
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.Serve(ctx, l)
+
+	tests := []struct {
+		name    string
+		auth    []ssh.AuthMethod
+		wantErr bool
+		wantKey bool
+		want    string
+	}{
+		{name: "password", auth: []ssh.AuthMethod{ssh.Password("secret")}, want: "alice"},
+		{name: "public key", auth: []ssh.AuthMethod{ssh.PublicKeys(userkey)}, wantKey: true, want: "keyholder"},
+		{name: "wrong password", auth: []ssh.AuthMethod{ssh.Password("wrong")}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := ssh.Dial("tcp", l.Addr().String(), &ssh.ClientConfig{
+				User:            "alice",
+				Auth:            tt.auth,
+				HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Dial() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			defer client.Close()
+			session, err := client.NewSession()
+			if err != nil {
+				t.Fatal(err)
+			}
+			session.Close()
+			conn := <-conns
+			if conn.Info.User.Name != tt.want {
+				t.Errorf("user = %s, want %s", conn.Info.User.Name, tt.want)
+			}
+			if (conn.PublicKey != nil) != tt.wantKey {
+				t.Errorf("public key = %v, want set %v", conn.PublicKey, tt.wantKey)
+			}
+			if tt.wantKey && string(conn.PublicKey.Marshal()) != string(userkey.PublicKey().Marshal()) {
+				t.Errorf("public key is not the one of the login")
+			}
+			if len(conn.Permissions.Extensions) != 0 {
+				t.Errorf("extensions = %v, want the identity removed", conn.Permissions.Extensions)
+			}
+		})
+	}
+}
@@ -17,6 +17,8 @@ type DistributionOptions struct {
 	Username string
 	Password string
 	Insecure bool // skip tls verify
+
+	HTTPClient *http.Client // default http.DefaultClient, Insecure is ignored if set
 }
 
 type DistributionOption func(*DistributionOptions)
@@ -34,6 +36,13 @@ func WithInsecure() DistributionOption {
 	}
 }
 
+// WithHTTPClient sets the client of requests, e.g. from egress.Factory.
+func WithHTTPClient(cli *http.Client) DistributionOption {
+	return func(o *DistributionOptions) {
+		o.HTTPClient = cli
+	}
+}
+
 // end-8a	GET	/v2/<name>/tags/list
 func ListTags(ctx context.Context, image string, options ...DistributionOption) (*specsv1.TagList, error) {
 	named, err := reference.ParseNormalizedNamed(image)
@@ -96,7 +105,9 @@ func request(ctx context.Context, server, method, path string,
 		req.SetBasicAuth(opts.Username, opts.Password)
 	}
 	httpcli := http.DefaultClient
-	if opts.Insecure {
+	if opts.HTTPClient != nil {
+		httpcli = opts.HTTPClient
+	} else if opts.Insecure {
 		httpcli = &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
 	}
 	resp, err := httpcli.Do(req)
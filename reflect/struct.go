@@ -15,12 +15,16 @@
 package reflect
 
 import (
+	"errors"
 	"fmt"
 	"reflect"
 	"strconv"
 	"strings"
 )
 
+// ErrFieldNotFound is returned by SetFiledValue if a struct has no field of the path.
+var ErrFieldNotFound = errors.New("field not found")
+
 // StructFieldInfo returns the field name of the struct field
 func SetFiledValue(dest any, jsonpath string, value any) error {
 	return setFieldValue(reflect.ValueOf(dest), value, parseJsonPath(jsonpath)...)
@@ -116,6 +120,91 @@ func getFiledValue(v reflect.Value, path ...string) (any, error) {
 	}
 }
 
+// DeleteFieldValue removes the value at jsonpath, map keys are deleted and struct fields are set to zero.
+// "*" can be used to match all elements of a slice, missing paths are ignored.
+func DeleteFieldValue(dest any, jsonpath string) error {
+	return deleteFieldValue(reflect.ValueOf(dest), parseJsonPath(jsonpath)...)
+}
+
+func deleteFieldValue(v reflect.Value, path ...string) error {
+	if len(path) == 0 || !v.IsValid() {
+		return nil
+	}
+	switch t := v.Type(); t.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return deleteFieldValue(v.Elem(), path...)
+	case reflect.Slice, reflect.Array:
+		index := path[0]
+		if index == "*" {
+			for i := 0; i < v.Len(); i++ {
+				if err := deleteFieldValue(v.Index(i), path[1:]...); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		i, err := strconv.Atoi(index)
+		if err != nil {
+			return fmt.Errorf("invalid array index %s", index)
+		}
+		if i >= v.Len() {
+			return nil
+		}
+		return deleteFieldValue(v.Index(i), path[1:]...)
+	case reflect.Map:
+		if v.IsNil() || t.Key().Kind() != reflect.String {
+			return nil
+		}
+		key := reflect.ValueOf(path[0]).Convert(t.Key())
+		if len(path) == 1 {
+			v.SetMapIndex(key, reflect.Value{})
+			return nil
+		}
+		exists := v.MapIndex(key)
+		if !exists.IsValid() {
+			return nil
+		}
+		// map values are not addressable, modify a copy and set it back
+		val := reflect.New(t.Elem()).Elem()
+		val.Set(exists)
+		if err := deleteFieldValue(val, path[1:]...); err != nil {
+			return err
+		}
+		v.SetMapIndex(key, val)
+		return nil
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			isEmbedded, isIgnore, fieldName := StructFieldInfo(t.Field(i))
+			if isIgnore {
+				continue
+			}
+			if isEmbedded {
+				if err := deleteFieldValue(v.Field(i), path...); err != nil {
+					return err
+				}
+				continue
+			}
+			if path[0] != fieldName {
+				continue
+			}
+			if len(path) > 1 {
+				return deleteFieldValue(v.Field(i), path[1:]...)
+			}
+			if !v.Field(i).CanSet() {
+				return fmt.Errorf("field %s can not be set", fieldName)
+			}
+			v.Field(i).Set(reflect.Zero(t.Field(i).Type))
+			return nil
+		}
+		return nil
+	default:
+		return nil
+	}
+}
+
 func setFieldValue(v reflect.Value, value any, path ...string) error {
 	if len(path) == 0 {
 		return SetValueAutoConvert(v, value)
@@ -169,16 +258,17 @@ func setFieldValue(v reflect.Value, value any, path ...string) error {
 				continue
 			}
 			if isEmbedded {
-				if err := setFieldValue(v.Field(i), value, path...); err != nil {
+				err := setFieldValue(v.Field(i), value, path...)
+				if errors.Is(err, ErrFieldNotFound) {
 					continue
 				}
-				return nil
+				return err
 			}
 			if path[0] == fieldName {
 				return setFieldValue(v.Field(i), value, path[1:]...)
 			}
 		}
-		return fmt.Errorf("field %s not found", path[0])
+		return fmt.Errorf("%w: %s", ErrFieldNotFound, path[0])
 	default:
 		return fmt.Errorf("unsupported type %v", t)
 	}
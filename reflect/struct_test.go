@@ -93,6 +93,16 @@ func TestSetFiledValue(t *testing.T) {
 			},
 			want: &Embedded{Items: map[string]Bar{"hello": {Baz: "world"}}},
 		},
+		{
+			name: "field not found",
+			args: args{
+				dest:     &Embedded{},
+				jsonpath: ".missing",
+				value:    "world",
+			},
+			want:    &Embedded{},
+			wantErr: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -192,3 +202,60 @@ func Test_getFiledValue(t *testing.T) {
 		})
 	}
 }
+
+func TestDeleteFieldValue(t *testing.T) {
+	tests := []struct {
+		name     string
+		dest     any
+		jsonpath string
+		want     any
+	}{
+		{
+			name:     "delete struct field",
+			dest:     &Embedded{Foo: Foo{Name: "hello"}, KV: map[string]string{"a": "b"}},
+			jsonpath: ".name",
+			want:     &Embedded{KV: map[string]string{"a": "b"}},
+		},
+		{
+			name:     "delete all list items field",
+			dest:     &Embedded{List: []Bar{{Baz: "a"}, {Baz: "b"}}},
+			jsonpath: ".list[*].baz",
+			want:     &Embedded{List: []Bar{{}, {}}},
+		},
+		{
+			name:     "delete map key",
+			dest:     &Embedded{KV: map[string]string{"a": "b", "c": "d"}},
+			jsonpath: ".kv.a",
+			want:     &Embedded{KV: map[string]string{"c": "d"}},
+		},
+		{
+			name:     "delete map struct value field",
+			dest:     &Embedded{Items: map[string]Bar{"a": {Baz: "b"}}},
+			jsonpath: ".items.a.baz",
+			want:     &Embedded{Items: map[string]Bar{"a": {}}},
+		},
+		{
+			name:     "delete unstructured",
+			dest:     &map[string]any{"data": []any{map[string]any{"name": "a", "secret": "b"}}},
+			jsonpath: "data[*].secret",
+			want:     &map[string]any{"data": []any{map[string]any{"name": "a"}}},
+		},
+		{
+			name:     "missing path",
+			dest:     &Embedded{},
+			jsonpath: ".items.a.baz",
+			want:     &Embedded{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := DeleteFieldValue(tt.dest, tt.jsonpath); err != nil {
+				t.Errorf("DeleteFieldValue() error = %v", err)
+				return
+			}
+			if !reflect.DeepEqual(tt.dest, tt.want) {
+				t.Errorf("DeleteFieldValue() = %v, want %v", tt.dest, tt.want)
+			}
+		})
+	}
+}
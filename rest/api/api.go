@@ -16,15 +16,18 @@ package api
 
 import (
 	"context"
+	"fmt"
 	"net/http"
 
 	"kubegems.io/library/rest/listen"
 )
 
 type API struct {
-	tls     tlsfiles
-	plugins []Plugin
-	mux     Router
+	tls       tlsfiles
+	plugins   []Plugin
+	mux       Router
+	frozen    bool
+	hotreload bool
 }
 
 type tlsfiles struct {
@@ -38,28 +41,154 @@ func NewAPI() *API {
 	}
 }
 
+// Route registers the route, the route is served with its filters by the router.
+// Plugins see the route after registration, so the path params are completed,
+// the router keeps the route by pointer so that filters added by plugins take effect.
+// Mux runs the plugins before serving the route, so routes can be registered while serving with HotReload.
 func (m *API) Route(route Route) *API {
-	if err := m.mux.HandleRoute(&route); err != nil {
+	if m.frozen && !m.hotreload {
+		panic(fmt.Errorf("api is frozen, can't register %s %s", route.Method, route.Path))
+	}
+	onroute := func(route *Route) error {
+		for _, plugin := range m.plugins {
+			if err := plugin.OnRoute(route); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	var err error
+	if rm, ok := m.mux.(interface {
+		RegisterRoute(route *Route, prepare func(route *Route) error) error
+	}); ok {
+		err = rm.RegisterRoute(&route, onroute)
+	} else if err = m.mux.HandleRoute(&route); err == nil {
+		err = onroute(&route)
+	}
+	if err != nil {
 		panic(err)
 	}
+	if m.frozen {
+		m.freezeMux()
+	}
+	return m
+}
+
+// HotReload allows registering routes after Freeze, the frozen structures are rebuilt on each registration.
+// Registering while serving is safe with Mux, other routers must synchronize themselves.
+func (m *API) HotReload(enabled bool) *API {
+	m.hotreload = enabled
+	return m
+}
+
+// Freeze finalizes the routes at the end of startup, later registrations panic unless HotReload enabled.
+// The router builds its read-only lookup structures and plugins implementing FreezePlugin prebuild their content,
+// e.g. the openapi document, so the first requests are not slower than the rest.
+func (m *API) Freeze() *API {
+	if m.frozen {
+		return m
+	}
+	m.frozen = true
+	m.freezeMux()
 	for _, plugin := range m.plugins {
-		if err := plugin.OnRoute(&route); err != nil {
-			panic(err)
+		if fp, ok := plugin.(FreezePlugin); ok {
+			if err := fp.OnFreeze(m); err != nil {
+				panic(err)
+			}
 		}
 	}
 	return m
 }
 
+func (m *API) Frozen() bool {
+	return m.frozen
+}
+
+func (m *API) freezeMux() {
+	if fm, ok := m.mux.(interface{ Freeze() }); ok {
+		fm.Freeze()
+	}
+}
+
+// Routes returns the registered routes, e.g. for an admin endpoint listing routes,
+// it is nil if the router does not support listing.
+func (m *API) Routes() []RouteInfo {
+	if rm, ok := m.mux.(interface{ Routes() []RouteInfo }); ok {
+		return rm.Routes()
+	}
+	return nil
+}
+
+// Conflicts returns the routes shadowed by other routes, see Mux.Conflicts,
+// it is nil if the router does not support diagnostics.
+func (m *API) Conflicts() []RouteConflict {
+	if cm, ok := m.mux.(interface{ Conflicts() []RouteConflict }); ok {
+		return cm.Conflicts()
+	}
+	return nil
+}
+
 func (m *API) NotFound(handler http.Handler) *API {
 	m.mux.SetNotFound(handler)
 	return m
 }
 
+// PrefixNotFound sets the not found handler of the paths under prefix, see Mux.SetPrefixNotFound,
+// it has no effect on routers other than Mux.
+func (m *API) PrefixNotFound(prefix string, handler http.Handler) *API {
+	if mux, ok := m.mux.(*Mux); ok {
+		mux.SetPrefixNotFound(prefix, handler)
+	}
+	return m
+}
+
 func (m *API) MethodNotAllowed(handler http.Handler) *API {
 	m.mux.SetMethodNotAllowed(handler)
 	return m
 }
 
+// TrailingSlash sets how the mux handles paths differ from the routes only by the trailing slash,
+// it has no effect on routers other than Mux.
+func (m *API) TrailingSlash(mode TrailingSlashMode) *API {
+	if mux, ok := m.mux.(*Mux); ok {
+		mux.TrailingSlash = mode
+	}
+	return m
+}
+
+// UseEscapedPath matches the escaped path and unescapes variables, see Mux.UseEscapedPath,
+// it has no effect on routers other than Mux.
+func (m *API) UseEscapedPath(enabled bool) *API {
+	if mux, ok := m.mux.(*Mux); ok {
+		mux.UseEscapedPath = enabled
+	}
+	return m
+}
+
+// AutoHead makes GET routes answer HEAD requests without a HEAD route,
+// it has no effect on routers other than Mux.
+func (m *API) AutoHead(enabled bool) *API {
+	if mux, ok := m.mux.(*Mux); ok {
+		mux.AutoHead = enabled
+	}
+	return m
+}
+
+// Mount delegates the requests under prefix to handler, see Mux.Mount,
+// it panics if the router does not support mounting.
+func (m *API) Mount(prefix string, handler http.Handler) *API {
+	mux, ok := m.mux.(interface {
+		Mount(prefix string, handler http.Handler) error
+	})
+	if !ok {
+		panic(fmt.Errorf("router %T does not support mounting %s", m.mux, prefix))
+	}
+	if err := mux.Mount(prefix, handler); err != nil {
+		panic(err)
+	}
+	return m
+}
+
 func (m *API) Group(groups ...Group) *API {
 	for _, group := range groups {
 		for _, routes := range group.Build() {
@@ -2,23 +2,47 @@ package api
 
 import (
 	"context"
+	"fmt"
 	"net/http"
+	"net/url"
 	"strings"
+
+	"golang.org/x/exp/slices"
 )
 
-type AttrbuteResource struct {
+type AttributeResource struct {
 	Resource string `json:"resource,omitempty"`
 	Name     string `json:"name,omitempty"`
 }
 
+// Deprecated: use AttributeResource instead.
+type AttrbuteResource = AttributeResource
+
 type Attributes struct {
-	Action    string             `json:"action,omitempty"`
-	Resources []AttrbuteResource `json:"resources,omitempty"`
-	Path      string             `json:"path,omitempty"`
+	Action    string              `json:"action,omitempty"`
+	Resources []AttributeResource `json:"resources,omitempty"`
+	Path      string              `json:"path,omitempty"`
+}
+
+// Parents returns the attributes of the parent resources with the same action, from the most specific,
+// e.g. get, [zoos:z1 animals:a1] -> [get, [zoos:z1 animals:*]], [get, [zoos:z1]].
+// A named resource has its collection as the first parent.
+func (a Attributes) Parents() []Attributes {
+	parents := []Attributes{}
+	for i := len(a.Resources) - 1; i >= 0; i-- {
+		if i == len(a.Resources)-1 && a.Resources[i].Name != "" {
+			collection := append(slices.Clone(a.Resources[:i]), AttributeResource{Resource: a.Resources[i].Resource})
+			parents = append(parents, Attributes{Action: a.Action, Resources: collection})
+		}
+		if i > 0 {
+			parents = append(parents, Attributes{Action: a.Action, Resources: slices.Clone(a.Resources[:i])})
+		}
+	}
+	return parents
 }
 
 // return wildcards for action and expression
-// e.g. action: get, resources: [AttrbuteResource{Resource: "namespaces", Name: "default"}]
+// e.g. action: get, resources: [AttributeResource{Resource: "namespaces", Name: "default"}]
 // -> "get", "namespaces:default"
 func (a Attributes) ToWildcards() (string, string) {
 	wildcards := []string{}
@@ -41,17 +65,166 @@ func (a Attributes) ToWildcards() (string, string) {
 	return action, strings.Join(wildcards, ":")
 }
 
+// AttributesExtractor extracts the attributes of a request for authorization and audit,
+// nil attributes means the request is not a resource request.
+type AttributesExtractor interface {
+	ExtractAttributes(r *http.Request) (*Attributes, error)
+}
+
 type AttributeExtractor func(r *http.Request) (*Attributes, error)
 
+func (f AttributeExtractor) ExtractAttributes(r *http.Request) (*Attributes, error) {
+	return f(r)
+}
+
+var _ AttributesExtractor = &PathAttributesExtractor{}
+
+// PathAttributesExtractor is the configurable path based extractor, see ParseResourcePath.
+//
+// The domain, e.g. a tenant, is taken from DomainHeader, or the path segment at DomainPathIndex which is then
+// removed from the path, and prepended to the resources as {DomainResource}:{domain},
+// e.g. DomainPathIndex 0 and GET /acme/zoos/z1 -> get, [domains:acme zoos:z1].
+type PathAttributesExtractor struct {
+	Prefix           string            // removed from the path, requests without it have no attributes
+	ExcludedPrefixes []string          // requests with these prefixes have no attributes, e.g. /healthz
+	PluralActions    map[string]string // method -> action of collections, default MethodActionMapPlural
+	SingularActions  map[string]string // method -> action of single resources, default MethodActionMapSingular
+	DomainHeader     string            // e.g. X-Tenant
+	DomainPathIndex  int               // index of the domain segment after Prefix, negative disables
+	DomainResource   string            // default "domains"
+}
+
+func NewPathAttributesExtractor(prefix string) *PathAttributesExtractor {
+	return &PathAttributesExtractor{Prefix: prefix, DomainPathIndex: -1}
+}
+
+func (e *PathAttributesExtractor) ExtractAttributes(r *http.Request) (*Attributes, error) {
+	if !strings.HasPrefix(r.URL.Path, e.Prefix) {
+		return nil, nil
+	}
+	for _, excluded := range e.ExcludedPrefixes {
+		if strings.HasPrefix(r.URL.Path, excluded) {
+			return nil, nil
+		}
+	}
+	path := strings.TrimPrefix(r.URL.Path, e.Prefix)
+	domain := ""
+	if e.DomainHeader != "" {
+		domain = r.Header.Get(e.DomainHeader)
+	} else if e.DomainPathIndex >= 0 {
+		segments := strings.Split(strings.TrimPrefix(path, "/"), "/")
+		if e.DomainPathIndex >= len(segments) || segments[e.DomainPathIndex] == "" {
+			return nil, fmt.Errorf("no domain found in path %s", r.URL.Path)
+		}
+		domain = segments[e.DomainPathIndex]
+		path = "/" + strings.Join(append(segments[:e.DomainPathIndex:e.DomainPathIndex], segments[e.DomainPathIndex+1:]...), "/")
+	}
+	resource, action := splitResourceAction(path)
+	parts := removeEmpty(strings.Split(resource, "/"))
+	actions := orDefault(e.SingularActions, MethodActionMapSingular)
+	if len(parts)%2 != 0 {
+		parts = append(parts, "")
+		actions = orDefault(e.PluralActions, MethodActionMapPlural)
+	}
+	if action == "" {
+		action = actions[r.Method]
+	}
+	resources := []AttributeResource{}
+	if domain != "" {
+		resourceName := e.DomainResource
+		if resourceName == "" {
+			resourceName = "domains"
+		}
+		resources = append(resources, AttributeResource{Resource: resourceName, Name: domain})
+	}
+	for i := 0; i < len(parts); i += 2 {
+		resources = append(resources, AttributeResource{Resource: parts[i], Name: parts[i+1]})
+	}
+	return &Attributes{Action: action, Resources: resources, Path: path}, nil
+}
+
+func orDefault(m, defaults map[string]string) map[string]string {
+	if m == nil {
+		return defaults
+	}
+	return m
+}
+
 func PrefixedAttributesExtractor(prefix string) AttributeExtractor {
 	return func(r *http.Request) (*Attributes, error) {
 		if !strings.HasPrefix(r.URL.Path, prefix) {
 			return nil, nil
 		}
-		method, path := r.Method, strings.TrimPrefix(r.URL.Path, prefix)
-		action, resources := DefaultRestAttributeExtractor(method, path)
-		return &Attributes{Action: action, Resources: resources, Path: path}, nil
+		// parsed from the escaped path, names may contain "/" or ":"
+		attributes := ParseResourcePath(r.Method, strings.TrimPrefix(r.URL.EscapedPath(), prefix))
+		attributes.Path = strings.TrimPrefix(r.URL.Path, prefix)
+		return &attributes, nil
+	}
+}
+
+// ParseResourcePath parses an escaped resource path into attributes with the names unescaped,
+// it is the reverse of Attributes.ResourcePath.
+// e.g. POST /zoos/{zoo}/animals/{animal}:feed -> feed, [zoos:{zoo} animals:{animal}]
+func ParseResourcePath(method string, path string) Attributes {
+	action, resources := DefaultRestAttributeExtractor(method, path)
+	for i := range resources {
+		resources[i].Resource = pathUnescape(resources[i].Resource)
+		resources[i].Name = pathUnescape(resources[i].Name)
+	}
+	return Attributes{Action: pathUnescape(action), Resources: resources, Path: path}
+}
+
+// pathUnescape returns s as is if it is not a valid escaped segment.
+func pathUnescape(s string) string {
+	if unescaped, err := url.PathUnescape(s); err == nil {
+		return unescaped
+	}
+	return s
+}
+
+// ResourcePath builds the request method and path of attributes,
+// e.g. get, [zoos:{zoo} animals:{animal}] -> GET /zoos/{zoo}/animals/{animal}
+// actions not in MethodActionMapPlural/MethodActionMapSingular are appended as custom method,
+// e.g. feed, [zoos:{zoo} animals:{animal}] -> POST /zoos/{zoo}/animals/{animal}:feed
+func (a Attributes) ResourcePath() (string, string) {
+	return BuildResourcePath(a.Action, a.Resources)
+}
+
+// BuildResourcePath escapes the resources and names, see ResourcePath.
+func BuildResourcePath(action string, resources []AttributeResource) (string, string) {
+	sb := strings.Builder{}
+	for i, resource := range resources {
+		sb.WriteString("/")
+		sb.WriteString(pathEscape(resource.Resource))
+		// the name of last resource can be empty for plural actions
+		if resource.Name != "" || i != len(resources)-1 {
+			sb.WriteString("/")
+			sb.WriteString(pathEscape(resource.Name))
+		}
+	}
+	path := sb.String()
+	if path == "" {
+		path = "/"
+	}
+	if action == "" {
+		return http.MethodGet, path
+	}
+	isPlural := len(resources) > 0 && resources[len(resources)-1].Name == ""
+	actions := MethodActionMapSingular
+	if isPlural {
+		actions = MethodActionMapPlural
 	}
+	for method, act := range actions {
+		if act == action {
+			return method, path
+		}
+	}
+	return http.MethodPost, path + ":" + url.PathEscape(action)
+}
+
+// pathEscape escapes ":" too, which separates the custom action.
+func pathEscape(s string) string {
+	return strings.ReplaceAll(url.PathEscape(s), ":", "%3A")
 }
 
 // plural
@@ -70,7 +243,7 @@ var MethodActionMapSingular = map[string]string{
 	"PATCH":  "patch",
 }
 
-func DefaultRestAttributeExtractor(method string, path string) (string, []AttrbuteResource) {
+func DefaultRestAttributeExtractor(method string, path string) (string, []AttributeResource) {
 	// example:
 	// /api/v1/namespaces/default/pods/nginx-xxx -> ["namespaces", "default", "pods", "nginx-xxx"]
 	// /api/v1/namespaces/default/pods -> ["namespaces", "default", "pods"]
@@ -93,9 +266,9 @@ func DefaultRestAttributeExtractor(method string, path string) (string, []Attrbu
 			action = string(MethodActionMapSingular[method])
 		}
 	}
-	resources := []AttrbuteResource{}
+	resources := []AttributeResource{}
 	for i := 0; i < len(parts); i += 2 {
-		resources = append(resources, AttrbuteResource{Resource: parts[i], Name: parts[i+1]})
+		resources = append(resources, AttributeResource{Resource: parts[i], Name: parts[i+1]})
 	}
 	return action, resources
 }
@@ -127,11 +300,21 @@ func NewAttributeFilter(attributer AttributeExtractor) Filter {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		// the audit log is set before the attributes if audit filter placed before
+		if auditlog := AuditLogFromContext(r.Context()); auditlog != nil && attributes != nil {
+			auditlog.setAttributes(attributes)
+		}
 		ctx := WithAttributes(r.Context(), attributes)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// NewAttributesExtractorFilter sets the attributes extracted by extractor into the request context,
+// the authorization and audit filters after it read the same attributes.
+func NewAttributesExtractorFilter(extractor AttributesExtractor) Filter {
+	return NewAttributeFilter(extractor.ExtractAttributes)
+}
+
 var attributesContextKey = ContextKey("attributes")
 
 func WithAttributes(ctx context.Context, attributes *Attributes) context.Context {
@@ -0,0 +1,161 @@
+// Copyright 2023 The Kubegems Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"net/http"
+	"strings"
+)
+
+// KubernetesAttributesExtractor parses kubernetes style paths after prefix:
+//
+//	/api/{version}/namespaces/{namespace}/{resource}/{name}/{subresource}
+//	/apis/{group}/{version}/namespaces/{namespace}/{resource}/{name}/{subresource}
+//
+// Resources of non-core groups are qualified as "{resource}.{group}", subresources as "{resource}/{subresource}",
+// actions are kubernetes verbs: get, list, watch, create, update, patch, delete and deletecollection.
+// e.g. GET /apis/apps/v1/namespaces/default/deployments/nginx -> get, [namespaces:default deployments.apps:nginx]
+func KubernetesAttributesExtractor(prefix string) AttributeExtractor {
+	return func(r *http.Request) (*Attributes, error) {
+		if !strings.HasPrefix(r.URL.Path, prefix) {
+			return nil, nil
+		}
+		attributes := ParseKubernetesPath(r.Method, strings.TrimPrefix(r.URL.Path, prefix), r.URL.Query().Get("watch") == "true")
+		return &attributes, nil
+	}
+}
+
+func ParseKubernetesPath(method, path string, watch bool) Attributes {
+	attributes := Attributes{Path: path}
+	parts := removeEmpty(strings.Split(path, "/"))
+	group := ""
+	switch {
+	case len(parts) >= 2 && parts[0] == "api":
+		parts = parts[2:]
+	case len(parts) >= 3 && parts[0] == "apis":
+		group, parts = parts[1], parts[3:]
+	default:
+		return attributes
+	}
+	qualify := func(resource string) string {
+		if group == "" {
+			return resource
+		}
+		return resource + "." + group
+	}
+	// namespaces/{namespace}/{resource} is a namespaced resource unless it is a subresource of the namespace
+	if len(parts) >= 3 && parts[0] == "namespaces" && parts[2] != "status" && parts[2] != "finalize" {
+		attributes.Resources = append(attributes.Resources, AttributeResource{Resource: "namespaces", Name: parts[1]})
+		parts = parts[2:]
+	}
+	resource := AttributeResource{}
+	switch len(parts) {
+	case 0:
+		return attributes
+	case 1:
+		resource.Resource = qualify(parts[0])
+	case 2:
+		resource.Resource, resource.Name = qualify(parts[0]), parts[1]
+	default:
+		resource.Resource, resource.Name = qualify(parts[0])+"/"+parts[2], parts[1]
+	}
+	attributes.Resources = append(attributes.Resources, resource)
+	attributes.Action = kubernetesVerb(method, resource.Name != "", watch)
+	return attributes
+}
+
+func kubernetesVerb(method string, named bool, watch bool) string {
+	switch method {
+	case http.MethodGet, http.MethodHead:
+		switch {
+		case watch:
+			return "watch"
+		case named:
+			return "get"
+		default:
+			return "list"
+		}
+	case http.MethodPost:
+		return "create"
+	case http.MethodPut:
+		return "update"
+	case http.MethodPatch:
+		return "patch"
+	case http.MethodDelete:
+		if named {
+			return "delete"
+		}
+		return "deletecollection"
+	default:
+		return strings.ToLower(method)
+	}
+}
+
+// RegistryAttributesExtractor parses OCI distribution paths after prefix,
+// actions are the registry token scope actions: pull, push and delete.
+//
+//	/v2/{repository}/manifests/{reference} -> [repository:{repository} manifests:{reference}]
+//	/v2/{repository}/blobs/uploads/{uuid}  -> [repository:{repository} blobs:]
+//	/v2/{repository}/tags/list             -> [repository:{repository} tags:]
+//	/v2/_catalog                           -> list, [catalog:]
+func RegistryAttributesExtractor(prefix string) AttributeExtractor {
+	return func(r *http.Request) (*Attributes, error) {
+		if !strings.HasPrefix(r.URL.Path, prefix) {
+			return nil, nil
+		}
+		attributes := ParseRegistryPath(r.Method, strings.TrimPrefix(r.URL.Path, prefix))
+		return &attributes, nil
+	}
+}
+
+func ParseRegistryPath(method, path string) Attributes {
+	attributes := Attributes{Path: path}
+	path = strings.TrimPrefix(strings.Trim(path, "/"), "v2")
+	if path == "/_catalog" {
+		attributes.Action = "list"
+		attributes.Resources = []AttributeResource{{Resource: "catalog"}}
+		return attributes
+	}
+	// repository names can contain "/", so find the last known section
+	for _, section := range []string{"/manifests/", "/blobs/uploads", "/blobs/", "/tags/"} {
+		i := strings.LastIndex(path, section)
+		if i <= 0 {
+			continue
+		}
+		repository, rest := strings.TrimPrefix(path[:i], "/"), strings.Trim(path[i+len(section):], "/")
+		resource := AttributeResource{Resource: strings.Trim(section, "/")}
+		switch section {
+		case "/manifests/", "/blobs/":
+			resource.Name = rest
+		case "/blobs/uploads":
+			resource.Resource = "blobs"
+		}
+		attributes.Resources = []AttributeResource{{Resource: "repository", Name: repository}, resource}
+		attributes.Action = registryAction(method)
+		return attributes
+	}
+	return attributes
+}
+
+func registryAction(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead:
+		return "pull"
+	case http.MethodDelete:
+		return "delete"
+	default:
+		return "push"
+	}
+}
@@ -0,0 +1,219 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestResourcePath(t *testing.T) {
+	tests := []struct {
+		method     string
+		path       string
+		attributes Attributes
+	}{
+		{
+			method: "GET", path: "/zoos/z1/animals/a1",
+			attributes: Attributes{Action: "get", Resources: []AttributeResource{{Resource: "zoos", Name: "z1"}, {Resource: "animals", Name: "a1"}}},
+		},
+		{
+			method: "GET", path: "/zoos/z1/animals",
+			attributes: Attributes{Action: "list", Resources: []AttributeResource{{Resource: "zoos", Name: "z1"}, {Resource: "animals"}}},
+		},
+		{
+			method: "POST", path: "/zoos",
+			attributes: Attributes{Action: "create", Resources: []AttributeResource{{Resource: "zoos"}}},
+		},
+		{
+			method: "DELETE", path: "/zoos/z1",
+			attributes: Attributes{Action: "remove", Resources: []AttributeResource{{Resource: "zoos", Name: "z1"}}},
+		},
+		{
+			method: "POST", path: "/zoos/z1/animals/a1:feed",
+			attributes: Attributes{Action: "feed", Resources: []AttributeResource{{Resource: "zoos", Name: "z1"}, {Resource: "animals", Name: "a1"}}},
+		},
+		{
+			method: "GET", path: "/zoos/city%2Fz1/animals/a%201",
+			attributes: Attributes{Action: "get", Resources: []AttributeResource{{Resource: "zoos", Name: "city/z1"}, {Resource: "animals", Name: "a 1"}}},
+		},
+		{
+			method: "POST", path: "/zoos/z%3A1/animals/100%25:feed",
+			attributes: Attributes{Action: "feed", Resources: []AttributeResource{{Resource: "zoos", Name: "z:1"}, {Resource: "animals", Name: "100%"}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			method, path := tt.attributes.ResourcePath()
+			if method != tt.method || path != tt.path {
+				t.Errorf("ResourcePath() = %s %s, want %s %s", method, path, tt.method, tt.path)
+			}
+			parsed := ParseResourcePath(tt.method, tt.path)
+			if parsed.Action != tt.attributes.Action || !reflect.DeepEqual(parsed.Resources, tt.attributes.Resources) {
+				t.Errorf("ParseResourcePath() = %v, want %v", parsed, tt.attributes)
+			}
+		})
+	}
+}
+
+func TestPrefixedAttributesExtractor(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/v1/zoos/city%2Fz1/animals/a%3A1:feed", nil)
+	got, err := PrefixedAttributesExtractor("/v1")(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Attributes{
+		Action:    "feed",
+		Resources: []AttributeResource{{Resource: "zoos", Name: "city/z1"}, {Resource: "animals", Name: "a:1"}},
+		Path:      "/zoos/city/z1/animals/a:1:feed",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("PrefixedAttributesExtractor() = %v, want %v", got, want)
+	}
+}
+
+func TestParseKubernetesPath(t *testing.T) {
+	tests := []struct {
+		method string
+		path   string
+		watch  bool
+		want   Attributes
+	}{
+		{
+			method: "GET", path: "/apis/apps/v1/namespaces/default/deployments/nginx",
+			want: Attributes{Action: "get", Resources: []AttributeResource{{Resource: "namespaces", Name: "default"}, {Resource: "deployments.apps", Name: "nginx"}}},
+		},
+		{
+			method: "GET", path: "/api/v1/namespaces/default/pods", watch: true,
+			want: Attributes{Action: "watch", Resources: []AttributeResource{{Resource: "namespaces", Name: "default"}, {Resource: "pods"}}},
+		},
+		{
+			method: "GET", path: "/api/v1/namespaces/default/pods/nginx/log",
+			want: Attributes{Action: "get", Resources: []AttributeResource{{Resource: "namespaces", Name: "default"}, {Resource: "pods/log", Name: "nginx"}}},
+		},
+		{
+			method: "PUT", path: "/api/v1/namespaces/default/status",
+			want: Attributes{Action: "update", Resources: []AttributeResource{{Resource: "namespaces/status", Name: "default"}}},
+		},
+		{
+			method: "DELETE", path: "/api/v1/nodes",
+			want: Attributes{Action: "deletecollection", Resources: []AttributeResource{{Resource: "nodes"}}},
+		},
+		{
+			method: "GET", path: "/healthz",
+			want: Attributes{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			got := ParseKubernetesPath(tt.method, tt.path, tt.watch)
+			if got.Action != tt.want.Action || !reflect.DeepEqual(got.Resources, tt.want.Resources) {
+				t.Errorf("ParseKubernetesPath() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRegistryPath(t *testing.T) {
+	tests := []struct {
+		method string
+		path   string
+		want   Attributes
+	}{
+		{
+			method: "GET", path: "/v2/library/nginx/manifests/latest",
+			want: Attributes{Action: "pull", Resources: []AttributeResource{{Resource: "repository", Name: "library/nginx"}, {Resource: "manifests", Name: "latest"}}},
+		},
+		{
+			method: "POST", path: "/v2/library/nginx/blobs/uploads/",
+			want: Attributes{Action: "push", Resources: []AttributeResource{{Resource: "repository", Name: "library/nginx"}, {Resource: "blobs"}}},
+		},
+		{
+			method: "DELETE", path: "/v2/app/blobs/sha256:abc",
+			want: Attributes{Action: "delete", Resources: []AttributeResource{{Resource: "repository", Name: "app"}, {Resource: "blobs", Name: "sha256:abc"}}},
+		},
+		{
+			method: "GET", path: "/v2/app/tags/list",
+			want: Attributes{Action: "pull", Resources: []AttributeResource{{Resource: "repository", Name: "app"}, {Resource: "tags"}}},
+		},
+		{
+			method: "GET", path: "/v2/_catalog",
+			want: Attributes{Action: "list", Resources: []AttributeResource{{Resource: "catalog"}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			got := ParseRegistryPath(tt.method, tt.path)
+			if got.Action != tt.want.Action || !reflect.DeepEqual(got.Resources, tt.want.Resources) {
+				t.Errorf("ParseRegistryPath() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPathAttributesExtractor(t *testing.T) {
+	tests := []struct {
+		name      string
+		extractor *PathAttributesExtractor
+		method    string
+		path      string
+		header    http.Header
+		want      *Attributes
+		wantErr   bool
+	}{
+		{
+			name:      "default",
+			extractor: NewPathAttributesExtractor("/api"),
+			method:    "GET", path: "/api/zoos/z1/animals",
+			want: &Attributes{Action: "list", Resources: []AttributeResource{{Resource: "zoos", Name: "z1"}, {Resource: "animals"}}},
+		},
+		{
+			name:      "excluded",
+			extractor: &PathAttributesExtractor{ExcludedPrefixes: []string{"/healthz"}, DomainPathIndex: -1},
+			method:    "GET", path: "/healthz",
+		},
+		{
+			name:      "custom verbs",
+			extractor: &PathAttributesExtractor{SingularActions: map[string]string{"GET": "read", "PUT": "write"}, DomainPathIndex: -1},
+			method:    "PUT", path: "/zoos/z1",
+			want: &Attributes{Action: "write", Resources: []AttributeResource{{Resource: "zoos", Name: "z1"}}},
+		},
+		{
+			name:      "domain from header",
+			extractor: &PathAttributesExtractor{DomainHeader: "X-Tenant", DomainResource: "tenants", DomainPathIndex: -1},
+			method:    "DELETE", path: "/zoos/z1",
+			header: http.Header{"X-Tenant": {"acme"}},
+			want:   &Attributes{Action: "remove", Resources: []AttributeResource{{Resource: "tenants", Name: "acme"}, {Resource: "zoos", Name: "z1"}}},
+		},
+		{
+			name:      "domain from path",
+			extractor: &PathAttributesExtractor{Prefix: "/api", DomainPathIndex: 0},
+			method:    "POST", path: "/api/acme/zoos/z1:feed",
+			want: &Attributes{Action: "feed", Resources: []AttributeResource{{Resource: "domains", Name: "acme"}, {Resource: "zoos", Name: "z1"}}},
+		},
+		{
+			name:      "domain missing",
+			extractor: &PathAttributesExtractor{DomainPathIndex: 2},
+			method:    "GET", path: "/zoos",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			for k, v := range tt.header {
+				req.Header[k] = v
+			}
+			got, err := tt.extractor.ExtractAttributes(req)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ExtractAttributes() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if (got == nil) != (tt.want == nil) {
+				t.Fatalf("ExtractAttributes() = %v, want %v", got, tt.want)
+			}
+			if got != nil && (got.Action != tt.want.Action || !reflect.DeepEqual(got.Resources, tt.want.Resources)) {
+				t.Errorf("ExtractAttributes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
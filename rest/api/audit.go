@@ -16,14 +16,15 @@ package api
 
 import (
 	"context"
-	"fmt"
 	"io"
 	"net/http"
 	"strings"
 	"time"
 
 	"github.com/go-logr/logr"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/exp/slices"
+	"kubegems.io/library/events"
 )
 
 type Auditor interface {
@@ -35,6 +36,23 @@ type AuditSink interface {
 	Save(log *AuditLog) error
 }
 
+type AuditSinkFunc func(log *AuditLog) error
+
+func (f AuditSinkFunc) Save(log *AuditLog) error {
+	return f(log)
+}
+
+var _ AuditSink = EventAuditSink{}
+
+// EventAuditSink publishes audit logs to a topic, so subscribers save them without blocking requests.
+type EventAuditSink struct {
+	Topic *events.Topic[*AuditLog]
+}
+
+func (s EventAuditSink) Save(log *AuditLog) error {
+	return s.Topic.Publish(context.Background(), log)
+}
+
 func NewAuditFilter(auditor Auditor, sink AuditSink) Filter {
 	return FilterFunc(func(w http.ResponseWriter, r *http.Request, next http.Handler) {
 		ww, auditlog := auditor.OnRequest(w, r)
@@ -49,7 +67,9 @@ func NewAuditFilter(auditor Auditor, sink AuditSink) Filter {
 		r = r.WithContext(WithAuditLog(r.Context(), auditlog))
 		next.ServeHTTP(ww, r)
 		auditor.OnResponse(ww, r, auditlog)
-		_ = sink.Save(auditlog)
+		if !auditlog.sampledOut {
+			_ = sink.Save(auditlog)
+		}
 	})
 }
 
@@ -77,7 +97,9 @@ func NewAuditEndFilter(auditor Auditor, sink AuditSink) Filter {
 			return
 		}
 		auditor.OnResponse(w, r, auditlog) // audit response
-		_ = sink.Save(auditlog)            // save audit log
+		if !auditlog.sampledOut {
+			_ = sink.Save(auditlog) // save audit log
+		}
 	})
 }
 
@@ -109,54 +131,38 @@ func (l *LoggerAuditSink) Save(log *AuditLog) error {
 
 const DefaultAuditLogCacheSize = 256
 
+// NewCachedAuditSink saves logs to sink asynchronously, the queued logs are flushed when ctx done.
+//
+// Deprecated: use NewAsyncAuditSink, which batches and can be closed gracefully.
 func NewCachedAuditSink(ctx context.Context, sink AuditSink, maxCacheSize int) AuditSink {
 	if maxCacheSize <= 0 {
 		maxCacheSize = DefaultAuditLogCacheSize
 	}
-	logger := logr.FromContextOrDiscard(ctx).WithName("cached-audit-sink")
-	cachesink := &CachedAuditSink{
-		sink:   sink,
-		cache:  make(chan *AuditLog, maxCacheSize),
-		logger: logger,
-	}
+	cachesink := NewAsyncAuditSink(sink, &AsyncAuditSinkOptions{
+		QueueSize: maxCacheSize,
+		BatchSize: 1,
+		Logger:    logr.FromContextOrDiscard(ctx).WithName("cached-audit-sink"),
+	})
 	go func() {
-		for {
-			select {
-			case auditlog := <-cachesink.cache:
-				if err := sink.Save(auditlog); err != nil {
-					logger.Error(err, "save audit log")
-				}
-			case <-ctx.Done():
-				return
-			}
-		}
+		<-ctx.Done()
+		_ = cachesink.Close(context.Background())
 	}()
 	return cachesink
 }
 
-type CachedAuditSink struct {
-	sink   AuditSink
-	logger logr.Logger
-	cache  chan *AuditLog
-}
-
-func (c *CachedAuditSink) Save(log *AuditLog) error {
-	select {
-	case c.cache <- log:
-	default:
-		c.logger.Error(fmt.Errorf("cache channel full,drop audit log"), "save audit log")
-		return fmt.Errorf("cache is full")
-	}
-	return nil
-}
+// Deprecated: use AsyncAuditSink.
+type CachedAuditSink = AsyncAuditSink
 
 const MB = 1 << 20
 
 type SimpleAuditor struct {
 	RecordReadBody                bool     // Record read actions
 	RecordRequestBodyContentTypes []string // Record only for these content types
-	MaxBodySize                   int      // Max body size to record,0 means disable
-	WhiteList                     []string // White list
+	// Record response bodies only for these content types, empty records any, streaming responses are never recorded
+	RecordResponseBodyContentTypes []string
+	MaxBodySize                    int            // Max body size to record,0 means disable
+	WhiteList                      []string       // White list
+	Sampling                       *AuditSampling // nil records all requests
 }
 
 func NewSimpleAuditor() *SimpleAuditor {
@@ -167,6 +173,11 @@ func NewSimpleAuditor() *SimpleAuditor {
 			"application/xml",
 			"application/x-www-form-urlencoded",
 		},
+		RecordResponseBodyContentTypes: []string{
+			"application/json",
+			"application/xml",
+			"text/plain",
+		},
 		MaxBodySize: 1 * MB,
 	}
 }
@@ -184,20 +195,22 @@ type AuditSSH struct {
 }
 
 type AuditRequest struct {
-	HttpVersion string            `json:"httpVersion,omitempty"` // http version
-	Method      string            `json:"method,omitempty"`      // method
-	URL         string            `json:"url,omitempty"`         // full url
-	Header      map[string]string `json:"header,omitempty"`      // header
-	Body        []byte            `json:"body,omitempty"`        // ignore body if size > 1MB or stream.
-	ClientIP    string            `json:"clientIP,omitempty"`    // client ip
-	RemoteAddr  string            `json:"remoteAddr,omitempty"`
-	LocalAddr   string            `json:"localAddr,omitempty"`
+	HttpVersion   string            `json:"httpVersion,omitempty"`   // http version
+	Method        string            `json:"method,omitempty"`        // method
+	URL           string            `json:"url,omitempty"`           // full url
+	Header        map[string]string `json:"header,omitempty"`        // header
+	Body          []byte            `json:"body,omitempty"`          // ignore body if size > 1MB or stream.
+	BodyTruncated bool              `json:"bodyTruncated,omitempty"` // body is larger than the recorded
+	ClientIP      string            `json:"clientIP,omitempty"`      // client ip
+	RemoteAddr    string            `json:"remoteAddr,omitempty"`
+	LocalAddr     string            `json:"localAddr,omitempty"`
 }
 
 type AuditResponse struct {
-	StatusCode   int               `json:"statusCode,omitempty"`   // status code
-	Header       map[string]string `json:"header,omitempty"`       // header
-	ResponseBody []byte            `json:"responseBody,omitempty"` // ignore body if size > 1MB or stream.
+	StatusCode    int               `json:"statusCode,omitempty"`    // status code
+	Header        map[string]string `json:"header,omitempty"`        // header
+	ResponseBody  []byte            `json:"responseBody,omitempty"`  // ignore body if size > 1MB or stream.
+	BodyTruncated bool              `json:"bodyTruncated,omitempty"` // response body is larger than the recorded
 }
 
 type AuditExtraMetadata map[string]string
@@ -208,21 +221,39 @@ type AuditLog struct {
 	Request  AuditRequest  `json:"request,omitempty"`
 	Response AuditResponse `json:"response,omitempty"`
 	// authz
-	Subject string `json:"subject,omitempty"` // username
+	Subject       string               `json:"subject,omitempty"`       // username
+	Authorization *AuthorizationResult `json:"authorization,omitempty"` // why the request is allowed or denied
 	// Resource is the resource type, e.g. "pods", "namespaces/default/pods/nginx-xxx"
 	// we can detect the resource type and name from the request path.
 	// GET  /zoos/{zoo_id}/animals/{animal_id} 	-> get zoos,zoo_id,animals,animal_id
 	// GET  /zoos/{zoo_id}/animals 				-> list zoos,zoo_id,animals,animal_id
 	// POST /zoos/{zoo_id}/animals:set-free 	-> set-free zoos,zoo_id,animals
-	Action       string             `json:"action,omitempty"`       // create, update, delete, get, list, set-free, etc.
-	Domain       string             `json:"domain,omitempty"`       // for multi-tenant
-	Parents      []AttrbuteResource `json:"parents,omitempty"`      // parent resources, e.g. "zoos/{zoo_id}",
-	Resource     string             `json:"resource,omitempty"`     // resource type, e.g. "animals"
-	ResourceName string             `json:"resourceName,omitempty"` //  "{animal_id}", or "" if list
+	Action       string              `json:"action,omitempty"`       // create, update, delete, get, list, set-free, etc.
+	Domain       string              `json:"domain,omitempty"`       // for multi-tenant
+	Parents      []AttributeResource `json:"parents,omitempty"`      // parent resources, e.g. "zoos/{zoo_id}",
+	Resource     string              `json:"resource,omitempty"`     // resource type, e.g. "animals"
+	ResourceName string              `json:"resourceName,omitempty"` //  "{animal_id}", or "" if list
+	// AuditID is unique of each audit log, it is responded in the Audit-Id header for support cases
+	AuditID string `json:"auditID,omitempty"`
+	// ids of the request and its trace, to correlate audit logs with logs and traces
+	RequestID string `json:"requestID,omitempty"`
+	TraceID   string `json:"traceID,omitempty"`
+	SpanID    string `json:"spanID,omitempty"`
 	// metadata
 	StartTime time.Time          `json:"startTime,omitempty"` // request start time
 	EndTime   time.Time          `json:"endTime,omitempty"`   // request end time
 	Metadata  AuditExtraMetadata `json:"metadata,omitempty"`  // extra metadata
+
+	sampledOut bool // not sampled by the auditor, the filters skip saving it
+}
+
+// setAttributes records the action and resources of the request.
+func (l *AuditLog) setAttributes(attr *Attributes) {
+	l.Action = attr.Action
+	if size := len(attr.Resources); size > 0 {
+		parents, last := attr.Resources[:size-1], attr.Resources[size-1]
+		l.Parents, l.Resource, l.ResourceName = parents, last.Resource, last.Name
+	}
 }
 
 var auditmetadaContextKey = ContextKey("audit-metadata")
@@ -239,6 +270,23 @@ func AuditLogFromContext(ctx context.Context) *AuditLog {
 	return log
 }
 
+// HeaderAuditID is the response header of the audit id.
+const HeaderAuditID = "Audit-Id"
+
+// NewAuditID generates a unique audit id.
+func NewAuditID() string {
+	return randomString()
+}
+
+// AuditIDFromContext returns the audit id of the request, empty if not audited,
+// handlers can reference it in error messages.
+func AuditIDFromContext(ctx context.Context) string {
+	if log := AuditLogFromContext(ctx); log != nil {
+		return log.AuditID
+	}
+	return ""
+}
+
 func SetAuditExtra(req *http.Request, k, v string) {
 	log := AuditLogFromContext(req.Context())
 	if log == nil {
@@ -259,14 +307,26 @@ func (a *SimpleAuditor) OnRequest(w http.ResponseWriter, r *http.Request) (http.
 			Header:      HttpHeaderToMap(r.Header),
 			ClientIP:    ExtractClientIP(r),
 		},
+		AuditID:   NewAuditID(),
+		RequestID: RequestIDFromContext(r.Context()),
 		StartTime: time.Now(),
 	}
+	w.Header().Set(HeaderAuditID, auditlog.AuditID)
+	if sc := trace.SpanContextFromContext(r.Context()); sc.IsValid() {
+		auditlog.TraceID, auditlog.SpanID = sc.TraceID().String(), sc.SpanID().String()
+	}
+	if a.Sampling != nil && !a.Sampling.Sampled(r) {
+		// recorded without bodies in case the response is always recorded
+		auditlog.sampledOut = true
+		return &StatusResponseWriter{Inner: w}, auditlog
+	}
 	respcachesize := 0
 	if a.RecordReadBody || r.Method != http.MethodGet {
 		auditlog.Request.Body = ReadBodySafely(r, a.RecordRequestBodyContentTypes, a.MaxBodySize)
+		auditlog.Request.BodyTruncated = auditlog.Request.Body != nil && r.ContentLength > int64(len(auditlog.Request.Body))
 		respcachesize = a.MaxBodySize
 	}
-	return &StatusResponseWriter{Inner: w, MaxCacheSize: respcachesize}, auditlog
+	return &StatusResponseWriter{Inner: w, MaxCacheSize: respcachesize, CacheContentTypes: a.RecordResponseBodyContentTypes}, auditlog
 }
 
 func (a *SimpleAuditor) OnResponse(w http.ResponseWriter, r *http.Request, auditlog *AuditLog) {
@@ -274,13 +334,18 @@ func (a *SimpleAuditor) OnResponse(w http.ResponseWriter, r *http.Request, audit
 		return
 	}
 	if attr := AttributesFromContext(r.Context()); attr != nil {
-		auditlog.Action = attr.Action
-		if size := len(attr.Resources); size > 0 {
-			parents, last := attr.Resources[:size-1], attr.Resources[size-1]
-			auditlog.Parents, auditlog.Resource, auditlog.ResourceName = parents, last.Resource, last.Name
-		}
+		auditlog.setAttributes(attr)
 	}
 	auditlog.Subject = AuthenticateFromContext(r.Context()).User.Name
+	if result := AuthorizationResultFromContext(r.Context()); result != nil {
+		auditlog.Authorization = result
+	}
+	if impersonator, ok := ImpersonatorFromContext(r.Context()); ok {
+		if auditlog.Metadata == nil {
+			auditlog.Metadata = make(AuditExtraMetadata)
+		}
+		auditlog.Metadata[AuditMetadataImpersonator] = impersonator.Name
+	}
 	auditlog.EndTime = time.Now()
 	auditlog.Response = AuditResponse{
 		Header: HttpHeaderToMap(w.Header()),
@@ -288,6 +353,10 @@ func (a *SimpleAuditor) OnResponse(w http.ResponseWriter, r *http.Request, audit
 	if statusWriter, ok := w.(*StatusResponseWriter); ok {
 		auditlog.Response.StatusCode = statusWriter.Code
 		auditlog.Response.ResponseBody = statusWriter.Cache
+		auditlog.Response.BodyTruncated = statusWriter.Truncated
+	}
+	if auditlog.sampledOut && a.Sampling.AlwaysRecord(auditlog.Response.StatusCode) {
+		auditlog.sampledOut = false
 	}
 }
 
@@ -310,9 +379,11 @@ func HttpHeaderToMap(header http.Header) map[string]string {
 	return m
 }
 
+// ReadBodySafely reads at most maxReadSize bytes of body and puts them back to req.Body.
+// Streaming bodies are never read, see IsStreamingRequest.
 func ReadBodySafely(req *http.Request, allowsContentType []string, maxReadSize int) []byte {
 	contenttype, contentlen := req.Header.Get("Content-Type"), req.ContentLength
-	if contenttype == "" || contentlen == 0 {
+	if contenttype == "" || contentlen == 0 || IsStreamingRequest(req) {
 		return nil
 	}
 	allowed := slices.ContainsFunc(allowsContentType, func(s string) bool {
@@ -373,35 +444,3 @@ func (w *CachedBody) Read(p []byte) (n int, err error) {
 func (w *CachedBody) Close() error {
 	return w.body.Close()
 }
-
-var _ http.ResponseWriter = &StatusResponseWriter{}
-
-type StatusResponseWriter struct {
-	Inner        http.ResponseWriter
-	Code         int
-	Cache        []byte
-	MaxCacheSize int
-}
-
-func (w *StatusResponseWriter) Header() http.Header {
-	return w.Inner.Header()
-}
-
-func (w *StatusResponseWriter) Write(p []byte) (n int, err error) {
-	if w.Code == 0 {
-		w.WriteHeader(http.StatusOK)
-	}
-	if leftcachesize := w.MaxCacheSize - len(w.Cache); leftcachesize > 0 {
-		if len(p) > leftcachesize {
-			w.Cache = append(w.Cache, p[:leftcachesize]...)
-		} else {
-			w.Cache = append(w.Cache, p...)
-		}
-	}
-	return w.Inner.Write(p)
-}
-
-func (w *StatusResponseWriter) WriteHeader(statusCode int) {
-	w.Code = statusCode
-	w.Inner.WriteHeader(statusCode)
-}
@@ -0,0 +1,232 @@
+// Copyright 2023 The Kubegems Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+var (
+	// ErrAuditQueueFull is returned by AsyncAuditSink.Save when the sink can not keep up.
+	ErrAuditQueueFull = errors.New("audit queue is full")
+	// ErrAuditSinkClosed is returned by AsyncAuditSink.Save after Close.
+	ErrAuditSinkClosed = errors.New("audit sink is closed")
+)
+
+// AuditBatchSink saves logs in batches, AsyncAuditSink uses it if the wrapped sink implements it.
+type AuditBatchSink interface {
+	SaveBatch(ctx context.Context, logs []*AuditLog) error
+}
+
+type AsyncAuditSinkOptions struct {
+	Workers      int           // concurrent batches, default 1, logs may be saved out of order if more than 1
+	QueueSize    int           // logs waiting to be saved, default 1024
+	BatchSize    int           // logs saved at once, default 100
+	BatchTimeout time.Duration // max time a log waits for the batch, default 1s
+	WriteTimeout time.Duration // timeout of a batch, default 10s
+	// BlockTimeout is the max time Save waits for the queue space before dropping the log,
+	// 0 drops immediately so requests are never blocked by the sink.
+	BlockTimeout time.Duration
+	Logger       logr.Logger
+}
+
+func NewDefaultAsyncAuditSinkOptions() *AsyncAuditSinkOptions {
+	return &AsyncAuditSinkOptions{
+		Workers:      1,
+		QueueSize:    1024,
+		BatchSize:    100,
+		BatchTimeout: time.Second,
+		WriteTimeout: 10 * time.Second,
+	}
+}
+
+type AuditSinkStats struct {
+	Published uint64 `json:"published"` // logs saved
+	Failed    uint64 `json:"failed"`    // logs failed to save
+	Dropped   uint64 `json:"dropped"`   // logs dropped due to the queue is full
+	Blocked   uint64 `json:"blocked"`   // saves waited for the queue space, a sign of backpressure
+	Queued    int    `json:"queued"`    // logs waiting in the queue
+}
+
+var _ AuditSink = &AsyncAuditSink{}
+
+// AsyncAuditSink saves logs to the wrapped sink in batches by background workers without blocking requests,
+// Close must be called to flush the queued logs before exit.
+type AsyncAuditSink struct {
+	sink    AuditSink
+	options *AsyncAuditSinkOptions
+
+	mu     sync.RWMutex // guards closed and sending on queue
+	closed bool
+	queue  chan *AuditLog
+	wg     sync.WaitGroup
+	done   chan struct{}
+
+	published, failed, dropped, blocked atomic.Uint64
+}
+
+func NewAsyncAuditSink(sink AuditSink, options *AsyncAuditSinkOptions) *AsyncAuditSink {
+	defaults := NewDefaultAsyncAuditSinkOptions()
+	if options == nil {
+		options = defaults
+	}
+	if options.Workers <= 0 {
+		options.Workers = defaults.Workers
+	}
+	if options.QueueSize <= 0 {
+		options.QueueSize = defaults.QueueSize
+	}
+	if options.BatchSize <= 0 {
+		options.BatchSize = defaults.BatchSize
+	}
+	if options.BatchTimeout <= 0 {
+		options.BatchTimeout = defaults.BatchTimeout
+	}
+	if options.WriteTimeout <= 0 {
+		options.WriteTimeout = defaults.WriteTimeout
+	}
+	s := &AsyncAuditSink{
+		sink:    sink,
+		options: options,
+		queue:   make(chan *AuditLog, options.QueueSize),
+		done:    make(chan struct{}),
+	}
+	s.wg.Add(options.Workers)
+	for i := 0; i < options.Workers; i++ {
+		go s.work()
+	}
+	go func() {
+		s.wg.Wait()
+		close(s.done)
+	}()
+	return s
+}
+
+func (s *AsyncAuditSink) Save(log *AuditLog) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return ErrAuditSinkClosed
+	}
+	select {
+	case s.queue <- log:
+		return nil
+	default:
+	}
+	if s.options.BlockTimeout > 0 {
+		s.blocked.Add(1)
+		timer := time.NewTimer(s.options.BlockTimeout)
+		defer timer.Stop()
+		select {
+		case s.queue <- log:
+			return nil
+		case <-timer.C:
+		}
+	}
+	s.dropped.Add(1)
+	return ErrAuditQueueFull
+}
+
+// Close stops accepting logs and waits the queued ones saved, or returns ctx.Err() if ctx done before that.
+func (s *AsyncAuditSink) Close(ctx context.Context) error {
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.queue)
+	}
+	s.mu.Unlock()
+	select {
+	case <-s.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Done is closed after Close and the queued logs saved.
+func (s *AsyncAuditSink) Done() <-chan struct{} {
+	return s.done
+}
+
+func (s *AsyncAuditSink) Stats() AuditSinkStats {
+	return AuditSinkStats{
+		Published: s.published.Load(),
+		Failed:    s.failed.Load(),
+		Dropped:   s.dropped.Load(),
+		Blocked:   s.blocked.Load(),
+		Queued:    len(s.queue),
+	}
+}
+
+func (s *AsyncAuditSink) work() {
+	defer s.wg.Done()
+	batch := make([]*AuditLog, 0, s.options.BatchSize)
+	timer := time.NewTimer(s.options.BatchTimeout)
+	defer timer.Stop()
+	for {
+		select {
+		case log, ok := <-s.queue:
+			if !ok {
+				s.flush(batch)
+				return
+			}
+			if batch = append(batch, log); len(batch) < s.options.BatchSize {
+				continue
+			}
+		case <-timer.C:
+		}
+		batch = s.flush(batch)
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(s.options.BatchTimeout)
+	}
+}
+
+// flush saves the batch and returns it emptied for reuse.
+func (s *AsyncAuditSink) flush(batch []*AuditLog) []*AuditLog {
+	if len(batch) == 0 {
+		return batch
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.options.WriteTimeout)
+	defer cancel()
+	if batchsink, ok := s.sink.(AuditBatchSink); ok {
+		if err := batchsink.SaveBatch(ctx, batch); err != nil {
+			s.failed.Add(uint64(len(batch)))
+			s.options.Logger.Error(err, "save audit logs", "count", len(batch))
+		} else {
+			s.published.Add(uint64(len(batch)))
+		}
+		return batch[:0]
+	}
+	for _, log := range batch {
+		if err := s.sink.Save(log); err != nil {
+			s.failed.Add(1)
+			s.options.Logger.Error(err, "save audit log")
+		} else {
+			s.published.Add(1)
+		}
+	}
+	return batch[:0]
+}
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+type countingAuditSink struct {
+	mu      sync.Mutex
+	saved   int
+	batches int
+}
+
+func (s *countingAuditSink) Save(log *AuditLog) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.saved++
+	return nil
+}
+
+type countingBatchAuditSink struct {
+	countingAuditSink
+}
+
+func (s *countingBatchAuditSink) SaveBatch(ctx context.Context, logs []*AuditLog) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.saved += len(logs)
+	s.batches++
+	return nil
+}
+
+func TestAsyncAuditSinkClose(t *testing.T) {
+	tests := []struct {
+		name        string
+		sink        AuditSink
+		counter     func(AuditSink) *countingAuditSink
+		wantBatches int
+	}{
+		{name: "single", sink: &countingAuditSink{}, counter: func(s AuditSink) *countingAuditSink { return s.(*countingAuditSink) }},
+		{name: "batch", sink: &countingBatchAuditSink{}, counter: func(s AuditSink) *countingAuditSink { return &s.(*countingBatchAuditSink).countingAuditSink }, wantBatches: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// batches are never timed out, the queued logs are saved by Close
+			sink := NewAsyncAuditSink(tt.sink, &AsyncAuditSinkOptions{BatchSize: 3, BatchTimeout: time.Hour})
+			for i := 0; i < 10; i++ {
+				if err := sink.Save(&AuditLog{}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := sink.Close(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if err := sink.Save(&AuditLog{}); !errors.Is(err, ErrAuditSinkClosed) {
+				t.Errorf("Save() after Close = %v, want %v", err, ErrAuditSinkClosed)
+			}
+			counter := tt.counter(tt.sink)
+			if counter.saved != 10 || counter.batches != tt.wantBatches {
+				t.Errorf("saved %d in %d batches, want 10 in %d", counter.saved, counter.batches, tt.wantBatches)
+			}
+			if stats := sink.Stats(); stats.Published != 10 {
+				t.Errorf("Stats() = %+v", stats)
+			}
+		})
+	}
+}
+
+func TestAsyncAuditSinkBackpressure(t *testing.T) {
+	block := make(chan struct{})
+	blocking := AuditSinkFunc(func(log *AuditLog) error {
+		<-block
+		return nil
+	})
+	sink := NewAsyncAuditSink(blocking, &AsyncAuditSinkOptions{BatchSize: 1, QueueSize: 1, BlockTimeout: 10 * time.Millisecond})
+	var err error
+	for i := 0; i < 10 && err == nil; i++ {
+		err = sink.Save(&AuditLog{})
+	}
+	if stats := sink.Stats(); !errors.Is(err, ErrAuditQueueFull) || stats.Blocked == 0 || stats.Dropped == 0 {
+		t.Errorf("Save() = %v, Stats() = %+v, want blocked then dropped", err, stats)
+	}
+	close(block)
+	// the queued log is saved after the worker unblocked
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := sink.Close(ctx); err != nil {
+		t.Errorf("Close() = %v", err)
+	}
+}
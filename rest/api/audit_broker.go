@@ -0,0 +1,141 @@
+// Copyright 2023 The Kubegems Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+// AuditMessage is an encoded audit log, Key is the subject to keep the logs of a user in order on partitioned brokers.
+type AuditMessage struct {
+	Key   []byte
+	Value []byte
+	Log   *AuditLog // the log encoded, for writers using its fields, e.g. labels of loki streams
+}
+
+// AuditMessageWriter writes a batch of messages to a message broker, e.g. a kafka topic, see package kafkasink.
+type AuditMessageWriter interface {
+	WriteMessages(ctx context.Context, msgs ...AuditMessage) error
+}
+
+type AuditMessageWriterFunc func(ctx context.Context, msgs ...AuditMessage) error
+
+func (f AuditMessageWriterFunc) WriteMessages(ctx context.Context, msgs ...AuditMessage) error {
+	return f(ctx, msgs...)
+}
+
+type BrokerAuditSinkOptions struct {
+	BatchSize    int           // messages written at once, default 100
+	BatchTimeout time.Duration // max time a message waits for the batch, default 1s
+	QueueSize    int           // messages waiting to be written, new logs are dropped if full, default 1024
+	WriteTimeout time.Duration // timeout of a batch write, default 10s
+
+	// Encode encodes the logs to messages, default json, e.g. EncodeKubernetesAuditEvent for kubernetes audit pipelines.
+	Encode func(log *AuditLog) ([]byte, error)
+}
+
+func NewDefaultBrokerAuditSinkOptions() *BrokerAuditSinkOptions {
+	return &BrokerAuditSinkOptions{
+		BatchSize:    100,
+		BatchTimeout: time.Second,
+		QueueSize:    1024,
+		WriteTimeout: 10 * time.Second,
+		Encode:       func(log *AuditLog) ([]byte, error) { return json.Marshal(log) },
+	}
+}
+
+// BrokerAuditSink publishes audit logs as json to a message broker in batches without blocking requests,
+// the pending messages are flushed when ctx done.
+type BrokerAuditSink struct {
+	*AsyncAuditSink
+}
+
+func NewBrokerAuditSink(ctx context.Context, writer AuditMessageWriter, options *BrokerAuditSinkOptions) *BrokerAuditSink {
+	if options == nil {
+		options = NewDefaultBrokerAuditSinkOptions()
+	}
+	encode := options.Encode
+	if encode == nil {
+		encode = NewDefaultBrokerAuditSinkOptions().Encode
+	}
+	sink := &BrokerAuditSink{
+		AsyncAuditSink: NewAsyncAuditSink(&messageWriterSink{writer: writer, encode: encode}, &AsyncAuditSinkOptions{
+			Workers:      1, // keep the order of messages
+			QueueSize:    options.QueueSize,
+			BatchSize:    options.BatchSize,
+			BatchTimeout: options.BatchTimeout,
+			WriteTimeout: options.WriteTimeout,
+			Logger:       logr.FromContextOrDiscard(ctx).WithName("broker-audit-sink"),
+		}),
+	}
+	go func() {
+		<-ctx.Done()
+		_ = sink.Close(context.Background())
+	}()
+	return sink
+}
+
+var _ AuditBatchSink = &messageWriterSink{}
+
+// messageWriterSink encodes the logs to messages for the writer.
+type messageWriterSink struct {
+	writer AuditMessageWriter
+	encode func(log *AuditLog) ([]byte, error)
+}
+
+func (s *messageWriterSink) Save(log *AuditLog) error {
+	return s.SaveBatch(context.Background(), []*AuditLog{log})
+}
+
+func (s *messageWriterSink) SaveBatch(ctx context.Context, logs []*AuditLog) error {
+	msgs := make([]AuditMessage, 0, len(logs))
+	for _, log := range logs {
+		value, err := s.encode(log)
+		if err != nil {
+			return err
+		}
+		var key []byte
+		if log.Subject != "" {
+			key = []byte(log.Subject)
+		}
+		msgs = append(msgs, AuditMessage{Key: key, Value: value, Log: log})
+	}
+	return s.writer.WriteMessages(ctx, msgs...)
+}
+
+// sendAuditRequest sends req and returns the response body, non 2xx responses are errors.
+func sendAuditRequest(client *http.Client, req *http.Request) ([]byte, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, MB))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("%s %s: %s: %s", req.Method, req.URL.Redacted(), resp.Status, bytes.TrimSpace(body))
+	}
+	return body, nil
+}
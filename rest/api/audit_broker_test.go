@@ -0,0 +1,180 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBrokerAuditSink(t *testing.T) {
+	tests := []struct {
+		name        string
+		writeErr    error
+		logs        int
+		wantStats   AuditSinkStats
+		wantBatches int
+	}{
+		{name: "published in batches", logs: 5, wantStats: AuditSinkStats{Published: 5}, wantBatches: 3},
+		{name: "delivery failure", logs: 2, writeErr: errors.New("broker down"), wantStats: AuditSinkStats{Failed: 2}, wantBatches: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mu, batches := sync.Mutex{}, 0
+			writer := AuditMessageWriterFunc(func(ctx context.Context, msgs ...AuditMessage) error {
+				mu.Lock()
+				defer mu.Unlock()
+				batches++
+				return tt.writeErr
+			})
+			ctx, cancel := context.WithCancel(context.Background())
+			sink := NewBrokerAuditSink(ctx, writer, &BrokerAuditSinkOptions{BatchSize: 2, BatchTimeout: time.Hour})
+			for i := 0; i < tt.logs; i++ {
+				if err := sink.Save(&AuditLog{Subject: "bob"}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// the remaining logs are flushed on ctx done
+			cancel()
+			<-sink.Done()
+			if got := sink.Stats(); got != tt.wantStats {
+				t.Errorf("Stats() = %+v, want %+v", got, tt.wantStats)
+			}
+			if batches != tt.wantBatches {
+				t.Errorf("batches = %d, want %d", batches, tt.wantBatches)
+			}
+		})
+	}
+}
+
+func TestBrokerAuditSinkQueueFull(t *testing.T) {
+	block := make(chan struct{})
+	writer := AuditMessageWriterFunc(func(ctx context.Context, msgs ...AuditMessage) error {
+		<-block
+		return nil
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	defer close(block)
+	sink := NewBrokerAuditSink(ctx, writer, &BrokerAuditSinkOptions{BatchSize: 1, QueueSize: 1})
+	var err error
+	for i := 0; i < 10 && err == nil; i++ {
+		err = sink.Save(&AuditLog{})
+	}
+	if !errors.Is(err, ErrAuditQueueFull) || sink.Stats().Dropped == 0 {
+		t.Errorf("Save() = %v, want %v", err, ErrAuditQueueFull)
+	}
+}
+
+func TestLokiAuditWriter(t *testing.T) {
+	var got lokiPushRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/loki/api/v1/push" || r.Header.Get("X-Scope-OrgID") != "tenant" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	msgs := []AuditMessage{
+		{Value: []byte(`{"a":1}`), Log: &AuditLog{Subject: "bob", Action: "get", Resource: "zoos", ResourceName: "z1"}},
+		{Value: []byte(`{"a":2}`), Log: &AuditLog{Subject: "alice", Action: "get", Resource: "zoos", ResourceName: "z2"}},
+		{Value: []byte(`{"a":3}`), Log: &AuditLog{Subject: "bob", Action: "get", Resource: "zoos", ResourceName: "z3"}},
+	}
+	tests := []struct {
+		name        string
+		labelsFunc  func(log *AuditLog) map[string]string
+		wantStreams int
+		wantLabels  map[string]string // of the first stream
+		wantValues  int
+	}{
+		{
+			name:        "default",
+			wantStreams: 1,
+			wantLabels:  map[string]string{"job": "audit", "action": "get", "resource": "zoos"},
+			wantValues:  3,
+		},
+		{
+			name:        "subject",
+			labelsFunc:  LokiAuditSubjectLabels,
+			wantStreams: 2,
+			wantLabels:  map[string]string{"job": "audit", "subject": "bob", "action": "get", "resource": "zoos"},
+			wantValues:  2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got = lokiPushRequest{}
+			opts := NewDefaultLokiAuditOptions()
+			opts.URL, opts.TenantID, opts.LabelsFunc = server.URL, "tenant", tt.labelsFunc
+			writer, err := NewLokiAuditWriter(opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := writer.WriteMessages(context.Background(), msgs...); err != nil {
+				t.Fatal(err)
+			}
+			if len(got.Streams) != tt.wantStreams {
+				t.Fatalf("streams = %d, want %d", len(got.Streams), tt.wantStreams)
+			}
+			if first := got.Streams[0]; !reflect.DeepEqual(first.Stream, tt.wantLabels) || len(first.Values) != tt.wantValues {
+				t.Errorf("stream = %v, want labels %v with %d values", first, tt.wantLabels, tt.wantValues)
+			}
+		})
+	}
+}
+
+func TestElasticsearchAuditWriter(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		wantErr  bool
+	}{
+		{name: "indexed", response: `{"errors":false,"items":[{"index":{"status":201}},{"index":{"status":201}}]}`},
+		{name: "partial failure", response: `{"errors":true,"items":[{"index":{"status":201}},{"index":{"status":400,"error":{"type":"mapper_parsing_exception","reason":"failed"}}}]}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines := []string{}
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if user, _, _ := r.BasicAuth(); r.URL.Path != "/_bulk" || user != "elastic" {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				scanner := bufio.NewScanner(r.Body)
+				for scanner.Scan() {
+					lines = append(lines, scanner.Text())
+				}
+				_, _ = io.WriteString(w, tt.response)
+			}))
+			defer server.Close()
+
+			opts := NewDefaultElasticsearchAuditOptions()
+			opts.URL, opts.Username, opts.DateSuffix = server.URL, "elastic", "2006.01.02"
+			writer, err := NewElasticsearchAuditWriter(opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			start := time.Date(2023, 6, 5, 10, 0, 0, 0, time.UTC)
+			err = writer.WriteMessages(context.Background(),
+				AuditMessage{Value: []byte(`{"a":1}`), Log: &AuditLog{StartTime: start}},
+				AuditMessage{Value: []byte(`{"a":2}`), Log: &AuditLog{StartTime: start}},
+			)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("WriteMessages() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(lines) != 4 || lines[0] != `{"index":{"_index":"audit-2023.06.05"}}` || lines[1] != `{"a":1}` {
+				t.Errorf("bulk body = %v", lines)
+			}
+		})
+	}
+}
@@ -0,0 +1,157 @@
+// Copyright 2023 The Kubegems Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type ElasticsearchAuditOptions struct {
+	URL        string        `json:"url" description:"elasticsearch address, e.g. http://elasticsearch:9200"`
+	Index      string        `json:"index,omitempty" description:"index of audit logs"`
+	DateSuffix string        `json:"dateSuffix,omitempty" description:"go time layout appended to index by the log start time, e.g. 2006.01.02 for daily indices"`
+	Username   string        `json:"username,omitempty"`
+	Password   string        `json:"password,omitempty"`
+	APIKey     string        `json:"apiKey,omitempty" description:"base64 encoded api key, used instead of username and password"`
+	Timeout    time.Duration `json:"timeout,omitempty" description:"timeout of each bulk request"`
+
+	HTTPClient *http.Client `json:"-"`
+}
+
+func NewDefaultElasticsearchAuditOptions() *ElasticsearchAuditOptions {
+	return &ElasticsearchAuditOptions{
+		Index:   "audit",
+		Timeout: 10 * time.Second,
+	}
+}
+
+var _ AuditMessageWriter = &ElasticsearchAuditWriter{}
+
+// ElasticsearchAuditWriter indexes audit logs with the elasticsearch bulk api.
+type ElasticsearchAuditWriter struct {
+	Options *ElasticsearchAuditOptions
+	client  *http.Client
+	bulkURL string
+}
+
+func NewElasticsearchAuditWriter(opts *ElasticsearchAuditOptions) (*ElasticsearchAuditWriter, error) {
+	u, err := url.ParseRequestURI(opts.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid elasticsearch url %q: %w", opts.URL, err)
+	}
+	if opts.Index == "" {
+		return nil, fmt.Errorf("elasticsearch index is required")
+	}
+	client := opts.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: opts.Timeout}
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/_bulk"
+	return &ElasticsearchAuditWriter{Options: opts, client: client, bulkURL: u.String()}, nil
+}
+
+// NewElasticsearchAuditSink returns a sink indexing audit logs to elasticsearch in batches.
+func NewElasticsearchAuditSink(ctx context.Context, opts *ElasticsearchAuditOptions, sinkOptions *BrokerAuditSinkOptions) (*BrokerAuditSink, error) {
+	writer, err := NewElasticsearchAuditWriter(opts)
+	if err != nil {
+		return nil, err
+	}
+	return NewBrokerAuditSink(ctx, writer, sinkOptions), nil
+}
+
+type elasticsearchBulkAction struct {
+	Index struct {
+		Index string `json:"_index"`
+	} `json:"index"`
+}
+
+type elasticsearchBulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []struct {
+		Index struct {
+			Status int `json:"status"`
+			Error  *struct {
+				Type   string `json:"type"`
+				Reason string `json:"reason"`
+			} `json:"error,omitempty"`
+		} `json:"index"`
+	} `json:"items"`
+}
+
+func (w *ElasticsearchAuditWriter) index(log *AuditLog) string {
+	if w.Options.DateSuffix == "" {
+		return w.Options.Index
+	}
+	ts := time.Now()
+	if log != nil && !log.StartTime.IsZero() {
+		ts = log.StartTime
+	}
+	return w.Options.Index + "-" + ts.UTC().Format(w.Options.DateSuffix)
+}
+
+func (w *ElasticsearchAuditWriter) WriteMessages(ctx context.Context, msgs ...AuditMessage) error {
+	// ndjson of action and document pairs
+	buf := &bytes.Buffer{}
+	for _, msg := range msgs {
+		action := elasticsearchBulkAction{}
+		action.Index.Index = w.index(msg.Log)
+		line, err := json.Marshal(action)
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+		buf.Write(bytes.TrimSpace(msg.Value))
+		buf.WriteByte('\n')
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.bulkURL, buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	if w.Options.APIKey != "" {
+		req.Header.Set("Authorization", "ApiKey "+w.Options.APIKey)
+	} else if w.Options.Username != "" {
+		req.SetBasicAuth(w.Options.Username, w.Options.Password)
+	}
+	body, err := sendAuditRequest(w.client, req)
+	if err != nil {
+		return err
+	}
+	// the bulk api responds 200 even if some documents failed
+	resp := elasticsearchBulkResponse{}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return fmt.Errorf("decode bulk response: %w", err)
+	}
+	if !resp.Errors {
+		return nil
+	}
+	failed, reason := 0, ""
+	for _, item := range resp.Items {
+		if item.Index.Error != nil {
+			if failed++; reason == "" {
+				reason = item.Index.Error.Type + ": " + item.Index.Error.Reason
+			}
+		}
+	}
+	return fmt.Errorf("elasticsearch bulk: %d of %d documents failed, %s", failed, len(msgs), reason)
+}
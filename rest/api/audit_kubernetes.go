@@ -0,0 +1,167 @@
+// Copyright 2023 The Kubegems Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// KubernetesAuditEvent is the audit.k8s.io/v1 Event, see https://kubernetes.io/docs/reference/config-api/apiserver-audit.v1/
+type KubernetesAuditEvent struct {
+	Kind                     string                         `json:"kind"`
+	APIVersion               string                         `json:"apiVersion"`
+	Level                    string                         `json:"level"`
+	AuditID                  string                         `json:"auditID"`
+	Stage                    string                         `json:"stage"`
+	RequestURI               string                         `json:"requestURI"`
+	Verb                     string                         `json:"verb"`
+	User                     KubernetesAuditUser            `json:"user"`
+	ImpersonatedUser         *KubernetesAuditUser           `json:"impersonatedUser,omitempty"`
+	SourceIPs                []string                       `json:"sourceIPs,omitempty"`
+	UserAgent                string                         `json:"userAgent,omitempty"`
+	ObjectRef                *KubernetesAuditObjectRef      `json:"objectRef,omitempty"`
+	ResponseStatus           *KubernetesAuditResponseStatus `json:"responseStatus,omitempty"`
+	RequestObject            json.RawMessage                `json:"requestObject,omitempty"`
+	ResponseObject           json.RawMessage                `json:"responseObject,omitempty"`
+	RequestReceivedTimestamp string                         `json:"requestReceivedTimestamp"`
+	StageTimestamp           string                         `json:"stageTimestamp"`
+	Annotations              map[string]string              `json:"annotations,omitempty"`
+}
+
+type KubernetesAuditUser struct {
+	Username string `json:"username,omitempty"`
+}
+
+type KubernetesAuditObjectRef struct {
+	Resource    string `json:"resource,omitempty"`
+	Namespace   string `json:"namespace,omitempty"`
+	Name        string `json:"name,omitempty"`
+	APIGroup    string `json:"apiGroup,omitempty"`
+	Subresource string `json:"subresource,omitempty"`
+}
+
+type KubernetesAuditResponseStatus struct {
+	Metadata struct{} `json:"metadata"`
+	Status   string   `json:"status,omitempty"`
+	Code     int      `json:"code,omitempty"`
+}
+
+const kubernetesMicroTime = "2006-01-02T15:04:05.000000Z07:00"
+
+// kubernetesAuditVerbs maps the actions of MethodActionMapSingular and MethodActionMapPlural to kubernetes verbs.
+var kubernetesAuditVerbs = map[string]string{
+	"remove":      "delete",
+	"removeBatch": "deletecollection",
+	"updateBatch": "update",
+}
+
+var kubernetesAuditMethodVerbs = map[string]string{
+	http.MethodGet:    "get",
+	http.MethodHead:   "get",
+	http.MethodPost:   "create",
+	http.MethodPut:    "update",
+	http.MethodPatch:  "patch",
+	http.MethodDelete: "delete",
+}
+
+// NewKubernetesAuditEvent converts an audit log to a kubernetes audit event at stage ResponseComplete.
+// A "namespaces" parent is the namespace of the object, the resource can be group qualified with subresource,
+// e.g. deployments.apps/scale. Json bodies are recorded as the request and response objects.
+func NewKubernetesAuditEvent(log *AuditLog) *KubernetesAuditEvent {
+	event := &KubernetesAuditEvent{
+		Kind:                     "Event",
+		APIVersion:               "audit.k8s.io/v1",
+		Level:                    "Metadata",
+		AuditID:                  log.AuditID,
+		Stage:                    "ResponseComplete",
+		RequestURI:               log.Request.URL,
+		Verb:                     kubernetesAuditVerb(log),
+		User:                     KubernetesAuditUser{Username: log.Subject},
+		UserAgent:                log.Request.Header["User-Agent"],
+		RequestReceivedTimestamp: log.StartTime.UTC().Format(kubernetesMicroTime),
+		StageTimestamp:           log.EndTime.UTC().Format(kubernetesMicroTime),
+	}
+	if event.AuditID == "" {
+		event.AuditID = log.RequestID
+	}
+	if log.Request.ClientIP != "" {
+		event.SourceIPs = strings.Split(log.Request.ClientIP, ",")
+		for i := range event.SourceIPs {
+			event.SourceIPs[i] = strings.TrimSpace(event.SourceIPs[i])
+		}
+	}
+	// kubernetes records the authenticated user as user and the impersonated one as impersonatedUser
+	if impersonator := log.Metadata[AuditMetadataImpersonator]; impersonator != "" {
+		event.User, event.ImpersonatedUser = KubernetesAuditUser{Username: impersonator}, &KubernetesAuditUser{Username: log.Subject}
+	}
+	if log.Resource != "" {
+		ref := &KubernetesAuditObjectRef{Name: log.ResourceName}
+		ref.Resource, ref.Subresource, ref.APIGroup = splitKubernetesResource(log.Resource)
+		for _, parent := range log.Parents {
+			if parent.Resource == "namespaces" {
+				ref.Namespace = parent.Name
+			}
+		}
+		event.ObjectRef = ref
+	}
+	if code := log.Response.StatusCode; code != 0 {
+		status := &KubernetesAuditResponseStatus{Code: code}
+		if code >= http.StatusBadRequest {
+			status.Status = "Failure"
+		}
+		event.ResponseStatus = status
+	}
+	if json.Valid(log.Request.Body) {
+		event.Level, event.RequestObject = "Request", log.Request.Body
+	}
+	if json.Valid(log.Response.ResponseBody) {
+		event.Level, event.ResponseObject = "RequestResponse", log.Response.ResponseBody
+	}
+	annotations := map[string]string{}
+	for k, v := range log.Metadata {
+		annotations[k] = v
+	}
+	if log.Authorization != nil {
+		// the annotations of the kubernetes authorizer
+		decision := log.Authorization.Decision
+		if decision == DecisionDeny.String() {
+			decision = "forbid"
+		}
+		annotations["authorization.k8s.io/decision"] = decision
+		annotations["authorization.k8s.io/reason"] = log.Authorization.Reason
+	}
+	if len(annotations) > 0 {
+		event.Annotations = annotations
+	}
+	return event
+}
+
+func kubernetesAuditVerb(log *AuditLog) string {
+	if log.Action == "" {
+		return kubernetesAuditMethodVerbs[log.Request.Method]
+	}
+	if verb, ok := kubernetesAuditVerbs[log.Action]; ok {
+		return verb
+	}
+	return log.Action
+}
+
+// EncodeKubernetesAuditEvent encodes an audit log as a kubernetes audit event,
+// it can be used as BrokerAuditSinkOptions.Encode.
+func EncodeKubernetesAuditEvent(log *AuditLog) ([]byte, error) {
+	return json.Marshal(NewKubernetesAuditEvent(log))
+}
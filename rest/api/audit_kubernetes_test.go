@@ -0,0 +1,62 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestNewKubernetesAuditEvent(t *testing.T) {
+	start := time.Date(2023, 6, 5, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		log  *AuditLog
+		want string
+	}{
+		{
+			name: "namespaced resource",
+			log: &AuditLog{
+				RequestID: "req-1",
+				Request:   AuditRequest{Method: "DELETE", URL: "/namespaces/default/deployments.apps/nginx", ClientIP: "10.0.0.1"},
+				Response:  AuditResponse{StatusCode: 403},
+				Subject:   "bob",
+				Action:    "remove", Parents: []AttributeResource{{Resource: "namespaces", Name: "default"}},
+				Resource: "deployments.apps", ResourceName: "nginx",
+				Authorization: &AuthorizationResult{Decision: "deny", Reason: "not admin"},
+				StartTime:     start, EndTime: start.Add(time.Millisecond),
+			},
+			want: `{"kind":"Event","apiVersion":"audit.k8s.io/v1","level":"Metadata","auditID":"req-1","stage":"ResponseComplete",` +
+				`"requestURI":"/namespaces/default/deployments.apps/nginx","verb":"delete","user":{"username":"bob"},"sourceIPs":["10.0.0.1"],` +
+				`"objectRef":{"resource":"deployments","namespace":"default","name":"nginx","apiGroup":"apps"},` +
+				`"responseStatus":{"metadata":{},"status":"Failure","code":403},` +
+				`"requestReceivedTimestamp":"2023-06-05T10:00:00.000000Z","stageTimestamp":"2023-06-05T10:00:00.001000Z",` +
+				`"annotations":{"authorization.k8s.io/decision":"forbid","authorization.k8s.io/reason":"not admin"}}`,
+		},
+		{
+			name: "impersonated with body",
+			log: &AuditLog{
+				Request:  AuditRequest{Method: "POST", URL: "/zoos", Body: []byte(`{"name":"z1"}`)},
+				Response: AuditResponse{StatusCode: 201},
+				Subject:  "bob", Resource: "zoos",
+				Metadata:  AuditExtraMetadata{AuditMetadataImpersonator: "admin"},
+				StartTime: start, EndTime: start,
+			},
+			want: `{"kind":"Event","apiVersion":"audit.k8s.io/v1","level":"Request","auditID":"","stage":"ResponseComplete",` +
+				`"requestURI":"/zoos","verb":"create","user":{"username":"admin"},"impersonatedUser":{"username":"bob"},` +
+				`"objectRef":{"resource":"zoos"},"responseStatus":{"metadata":{},"code":201},"requestObject":{"name":"z1"},` +
+				`"requestReceivedTimestamp":"2023-06-05T10:00:00.000000Z","stageTimestamp":"2023-06-05T10:00:00.000000Z",` +
+				`"annotations":{"impersonator":"admin"}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := json.Marshal(NewKubernetesAuditEvent(tt.log))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("NewKubernetesAuditEvent() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright 2023 The Kubegems Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type LokiAuditOptions struct {
+	URL      string            `json:"url" description:"loki address, e.g. http://loki:3100"`
+	TenantID string            `json:"tenantID,omitempty" description:"X-Scope-OrgID of multi-tenant loki"`
+	Labels   map[string]string `json:"labels,omitempty" description:"static labels of the streams, e.g. job"`
+	Timeout  time.Duration     `json:"timeout,omitempty" description:"timeout of each push"`
+
+	// LabelsFunc derives the labels of a log, default LokiAuditLabels.
+	// Each set of labels is a stream, keep the values bounded, e.g. LokiAuditSubjectLabels only for a few users.
+	LabelsFunc func(log *AuditLog) map[string]string `json:"-"`
+	HTTPClient *http.Client                          `json:"-"`
+}
+
+func NewDefaultLokiAuditOptions() *LokiAuditOptions {
+	return &LokiAuditOptions{
+		Labels:  map[string]string{"job": "audit"},
+		Timeout: 10 * time.Second,
+	}
+}
+
+// LokiAuditLabels labels a log by its action and resource type, empty ones are omitted.
+// The subject and resource name are not labels as they are unbounded, query them from the log line.
+func LokiAuditLabels(log *AuditLog) map[string]string {
+	return lokiLabels(map[string]string{"action": log.Action, "resource": log.Resource})
+}
+
+// LokiAuditSubjectLabels is LokiAuditLabels with the subject, a stream per user,
+// opt in only if the users are few, e.g. service accounts.
+func LokiAuditSubjectLabels(log *AuditLog) map[string]string {
+	return lokiLabels(map[string]string{"action": log.Action, "resource": log.Resource, "subject": log.Subject})
+}
+
+func lokiLabels(values map[string]string) map[string]string {
+	labels := map[string]string{}
+	for name, value := range values {
+		if value != "" {
+			labels[name] = value
+		}
+	}
+	return labels
+}
+
+var _ AuditMessageWriter = &LokiAuditWriter{}
+
+// LokiAuditWriter pushes audit logs to loki, the logs of the same labels are in one stream.
+type LokiAuditWriter struct {
+	Options *LokiAuditOptions
+	client  *http.Client
+	pushURL string
+}
+
+func NewLokiAuditWriter(opts *LokiAuditOptions) (*LokiAuditWriter, error) {
+	u, err := url.ParseRequestURI(opts.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid loki url %q: %w", opts.URL, err)
+	}
+	if opts.LabelsFunc == nil {
+		opts.LabelsFunc = LokiAuditLabels
+	}
+	client := opts.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: opts.Timeout}
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/loki/api/v1/push"
+	return &LokiAuditWriter{Options: opts, client: client, pushURL: u.String()}, nil
+}
+
+// NewLokiAuditSink returns a sink pushing audit logs to loki in batches.
+func NewLokiAuditSink(ctx context.Context, opts *LokiAuditOptions, sinkOptions *BrokerAuditSinkOptions) (*BrokerAuditSink, error) {
+	writer, err := NewLokiAuditWriter(opts)
+	if err != nil {
+		return nil, err
+	}
+	return NewBrokerAuditSink(ctx, writer, sinkOptions), nil
+}
+
+type lokiPushRequest struct {
+	Streams []lokiStream `json:"streams"`
+}
+
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"` // [unix nano timestamp, line]
+}
+
+func (w *LokiAuditWriter) WriteMessages(ctx context.Context, msgs ...AuditMessage) error {
+	streams, index := []lokiStream{}, map[string]int{}
+	for _, msg := range msgs {
+		labels := map[string]string{}
+		for k, v := range w.Options.Labels {
+			labels[k] = v
+		}
+		ts := time.Now()
+		if msg.Log != nil {
+			for k, v := range w.Options.LabelsFunc(msg.Log) {
+				labels[k] = v
+			}
+			if !msg.Log.StartTime.IsZero() {
+				ts = msg.Log.StartTime
+			}
+		}
+		key := lokiLabelsKey(labels)
+		i, ok := index[key]
+		if !ok {
+			i, index[key] = len(streams), len(streams)
+			streams = append(streams, lokiStream{Stream: labels})
+		}
+		streams[i].Values = append(streams[i].Values, [2]string{strconv.FormatInt(ts.UnixNano(), 10), string(msg.Value)})
+	}
+	body, err := json.Marshal(lokiPushRequest{Streams: streams})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.pushURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if w.Options.TenantID != "" {
+		req.Header.Set("X-Scope-OrgID", w.Options.TenantID)
+	}
+	_, err = sendAuditRequest(w.client, req)
+	return err
+}
+
+func lokiLabelsKey(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		pairs = append(pairs, k+"="+strconv.Quote(v))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
@@ -0,0 +1,104 @@
+// Copyright 2023 The Kubegems Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var _ AuditSink = &OpenTelemetryAuditSink{}
+
+// OpenTelemetryAuditSink emits audit logs as "audit" spans with an "audit" event under the traces of the requests,
+// so they are exported with the traces, e.g. by an OTLP exporter, and correlated in the observability backend.
+// Logs without trace ids, e.g. the requests not traced, start new traces.
+type OpenTelemetryAuditSink struct {
+	Tracer trace.Tracer
+	Sink   AuditSink // next sink, optional
+}
+
+// NewOpenTelemetryAuditSink uses the global tracer provider if provider is nil.
+func NewOpenTelemetryAuditSink(provider trace.TracerProvider, next AuditSink) *OpenTelemetryAuditSink {
+	if provider == nil {
+		provider = otel.GetTracerProvider()
+	}
+	return &OpenTelemetryAuditSink{Tracer: provider.Tracer(tracerName), Sink: next}
+}
+
+func (s *OpenTelemetryAuditSink) Save(log *AuditLog) error {
+	ctx := context.Background()
+	if parent := auditSpanContext(log); parent.IsValid() {
+		ctx = trace.ContextWithRemoteSpanContext(ctx, parent)
+	}
+	attrs := AuditLogAttributes(log)
+	_, span := s.Tracer.Start(ctx, "audit",
+		trace.WithTimestamp(log.StartTime),
+		trace.WithSpanKind(trace.SpanKindInternal),
+		trace.WithAttributes(attrs...),
+	)
+	span.AddEvent("audit", trace.WithTimestamp(log.EndTime), trace.WithAttributes(attrs...))
+	span.End(trace.WithTimestamp(log.EndTime))
+	if s.Sink != nil {
+		return s.Sink.Save(log)
+	}
+	return nil
+}
+
+func auditSpanContext(log *AuditLog) trace.SpanContext {
+	traceID, err := trace.TraceIDFromHex(log.TraceID)
+	if err != nil {
+		return trace.SpanContext{}
+	}
+	spanID, err := trace.SpanIDFromHex(log.SpanID)
+	if err != nil {
+		return trace.SpanContext{}
+	}
+	return trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.FlagsSampled,
+		Remote:     true,
+	})
+}
+
+// AuditLogAttributes converts the audit log to span attributes, bodies and headers are omitted.
+func AuditLogAttributes(log *AuditLog) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		attribute.String("audit.subject", log.Subject),
+		attribute.String("audit.action", log.Action),
+		attribute.String("audit.resource", log.Resource),
+		attribute.String("audit.resource_name", log.ResourceName),
+		attribute.String("http.method", log.Request.Method),
+		attribute.String("http.url", log.Request.URL),
+		attribute.String("http.client_ip", log.Request.ClientIP),
+		attribute.Int("http.status_code", log.Response.StatusCode),
+	}
+	if log.Domain != "" {
+		attrs = append(attrs, attribute.String("audit.domain", log.Domain))
+	}
+	if log.Authorization != nil {
+		attrs = append(attrs,
+			attribute.String("audit.authorization.decision", log.Authorization.Decision),
+			attribute.String("audit.authorization.reason", log.Authorization.Reason),
+		)
+	}
+	for k, v := range log.Metadata {
+		attrs = append(attrs, attribute.String("audit.metadata."+k, v))
+	}
+	return attrs
+}
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// recordingTracer records the parent and events of started spans.
+type recordingTracer struct {
+	noop.Tracer
+	parent trace.SpanContext
+	events []string
+}
+
+func (t *recordingTracer) Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	t.parent = trace.SpanContextFromContext(ctx)
+	return ctx, &recordingSpan{tracer: t}
+}
+
+type recordingSpan struct {
+	noop.Span
+	tracer *recordingTracer
+}
+
+func (s *recordingSpan) AddEvent(name string, opts ...trace.EventOption) {
+	s.tracer.events = append(s.tracer.events, name)
+}
+
+func TestOpenTelemetryAuditSink(t *testing.T) {
+	traceID, _ := trace.TraceIDFromHex("0102030405060708090a0b0c0d0e0f10")
+	spanID, _ := trace.SpanIDFromHex("0102030405060708")
+	reqctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: traceID, SpanID: spanID, TraceFlags: trace.FlagsSampled,
+	}))
+
+	tracer, next := &recordingTracer{}, &recordingAuditSink{}
+	sink := &OpenTelemetryAuditSink{Tracer: tracer, Sink: next}
+	req := httptest.NewRequest(http.MethodPost, "/zoos", nil).WithContext(reqctx)
+	NewAuditFilter(NewSimpleAuditor(), sink).Process(httptest.NewRecorder(), req, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	if next.log == nil || next.log.TraceID != traceID.String() || next.log.SpanID != spanID.String() {
+		t.Fatalf("audit log trace not recorded: %+v", next.log)
+	}
+	if tracer.parent.TraceID() != traceID || tracer.parent.SpanID() != spanID {
+		t.Errorf("parent = %v, want the request span", tracer.parent)
+	}
+	if len(tracer.events) != 1 || tracer.events[0] != "audit" {
+		t.Errorf("events = %v, want [audit]", tracer.events)
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright 2023 The Kubegems Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"math/rand"
+	"net/http"
+	"path"
+)
+
+// AuditSampling samples audit logs to reduce the volume of high traffic endpoints,
+// rates are in [0, 1], 0 records none and 1 records all.
+type AuditSampling struct {
+	ReadRate  float64 `json:"readRate" description:"rate of GET/HEAD requests, e.g. 0.01"`
+	WriteRate float64 `json:"writeRate" description:"rate of the mutations"`
+	// responses with status code not less than it are always recorded, 0 disables
+	AlwaysRecordStatus int `json:"alwaysRecordStatus,omitempty" description:"always record responses with status code not less than it"`
+	// Paths override the rates of the matched paths, the first match wins.
+	Paths []AuditSamplingPath `json:"paths,omitempty"`
+}
+
+type AuditSamplingPath struct {
+	Pattern   string  `json:"pattern" description:"path pattern of path.Match, a trailing /** matches all sub paths"`
+	ReadRate  float64 `json:"readRate"`
+	WriteRate float64 `json:"writeRate"`
+}
+
+// NewDefaultAuditSampling records 1% of reads, all mutations and all errors.
+func NewDefaultAuditSampling() *AuditSampling {
+	return &AuditSampling{ReadRate: 0.01, WriteRate: 1, AlwaysRecordStatus: http.StatusBadRequest}
+}
+
+// Sampled reports whether the request is sampled by the rates, regardless of its response.
+func (s *AuditSampling) Sampled(r *http.Request) bool {
+	read, write := s.ReadRate, s.WriteRate
+	reqpath := path.Clean("/" + r.URL.Path)
+	for _, p := range s.Paths {
+		if matchPathPattern(p.Pattern, reqpath) {
+			read, write = p.ReadRate, p.WriteRate
+			break
+		}
+	}
+	rate := write
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		rate = read
+	}
+	return rate >= 1 || (rate > 0 && rand.Float64() < rate)
+}
+
+// AlwaysRecord reports whether a response is recorded even if the request is not sampled.
+func (s *AuditSampling) AlwaysRecord(code int) bool {
+	return s.AlwaysRecordStatus > 0 && code >= s.AlwaysRecordStatus
+}
@@ -0,0 +1,43 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAuditSampling(t *testing.T) {
+	sampling := &AuditSampling{
+		ReadRate:           0,
+		WriteRate:          1,
+		AlwaysRecordStatus: http.StatusBadRequest,
+		Paths:              []AuditSamplingPath{{Pattern: "/admin/**", ReadRate: 1, WriteRate: 1}},
+	}
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		code     int
+		wantSave bool
+	}{
+		{name: "read not sampled", method: http.MethodGet, path: "/zoos", code: http.StatusOK, wantSave: false},
+		{name: "read error", method: http.MethodGet, path: "/zoos/z1", code: http.StatusNotFound, wantSave: true},
+		{name: "mutation", method: http.MethodPost, path: "/zoos", code: http.StatusCreated, wantSave: true},
+		{name: "path override", method: http.MethodGet, path: "/admin/users", code: http.StatusOK, wantSave: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auditor := NewSimpleAuditor()
+			auditor.Sampling = sampling
+			sink := &recordingAuditSink{}
+			filter := NewAuditFilter(auditor, sink)
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			filter.Process(httptest.NewRecorder(), req, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.code)
+			}))
+			if saved := sink.log != nil; saved != tt.wantSave {
+				t.Errorf("saved = %v, want %v", saved, tt.wantSave)
+			}
+		})
+	}
+}
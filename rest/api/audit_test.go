@@ -0,0 +1,27 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAuditID(t *testing.T) {
+	sink := &recordingAuditSink{}
+	handlerID := ""
+	rec := httptest.NewRecorder()
+	NewAuditFilter(NewSimpleAuditor(), sink).Process(rec, httptest.NewRequest(http.MethodGet, "/zoos", nil),
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handlerID = AuditIDFromContext(r.Context())
+		}),
+	)
+	id := rec.Header().Get(HeaderAuditID)
+	if id == "" || handlerID != id || sink.log == nil || sink.log.AuditID != id {
+		t.Errorf("audit id: header %q, context %q, log %+v", id, handlerID, sink.log)
+	}
+	second := httptest.NewRecorder()
+	NewAuditFilter(NewSimpleAuditor(), sink).Process(second, httptest.NewRequest(http.MethodGet, "/zoos", nil), http.NotFoundHandler())
+	if second.Header().Get(HeaderAuditID) == id {
+		t.Errorf("audit id %q is not unique", id)
+	}
+}
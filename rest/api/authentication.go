@@ -3,20 +3,28 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"path"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/coreos/go-oidc/v3/oidc"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/exp/slices"
 	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	"kubegems.io/library/rest/response"
 )
 
-const AnonymousUser = "anonymous" // anonymous username
+const (
+	AnonymousUser  = "anonymous"              // anonymous username
+	AnonymousGroup = "system:unauthenticated" // group of anonymous users
+)
 
 type OIDCOptions struct {
 	Issuer   string `json:"issuer" description:"oidc issuer url"`
@@ -35,6 +43,9 @@ type OIDCOptions struct {
 
 	// UsernameClaims is the list of claims to check for a username.
 	UsernameClaims []string `json:"usernameClaims,omitempty" description:"username claims, default is 'name'"`
+
+	// HTTPClient is used for discovery, jwks and token requests, e.g. from egress.Factory.
+	HTTPClient *http.Client `json:"-"`
 }
 
 func NewDefaultOIDCOptions() *OIDCOptions {
@@ -64,15 +75,28 @@ type AuthenticateInfo struct {
 
 type TokenAuthenticator interface {
 	// Authenticate authenticates the token and returns the authentication info.
+	// The token of DefaultTokenExtractor has the "Bearer " prefix trimmed already.
 	// if can't authenticate, return nil, "reason message", nil
 	// if unexpected error, return nil, "", err
 	Authenticate(ctx context.Context, token string) (*AuthenticateInfo, error)
 }
 
+type TokenAuthenticateFunc func(ctx context.Context, token string) (*AuthenticateInfo, error)
+
+func (f TokenAuthenticateFunc) Authenticate(ctx context.Context, token string) (*AuthenticateInfo, error) {
+	return f(ctx, token)
+}
+
 type UsernamePasswordAuthenticator interface {
 	Authenticate(ctx context.Context, username, password string) (*AuthenticateInfo, error)
 }
 
+type UsernamePasswordAuthenticateFunc func(ctx context.Context, username, password string) (*AuthenticateInfo, error)
+
+func (f UsernamePasswordAuthenticateFunc) Authenticate(ctx context.Context, username, password string) (*AuthenticateInfo, error) {
+	return f(ctx, username, password)
+}
+
 type HTTPAuthenticator interface {
 	Authenticate(ctx context.Context, r *http.Request) (*AuthenticateInfo, error)
 }
@@ -97,21 +121,91 @@ func ResponseHeaderFromContext(ctx context.Context) http.Header {
 	return nil
 }
 
+var clientIPContextKey = ContextKey("clientIP")
+
+// WithClientIP sets the client ip for authenticators, e.g. to count failures by ip.
+func WithClientIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, clientIPContextKey, ip)
+}
+
+func ClientIPFromContext(ctx context.Context) string {
+	if ip, ok := ctx.Value(clientIPContextKey).(string); ok {
+		return ip
+	}
+	return ""
+}
+
+var sshUserContextKey = ContextKey("sshUser")
+
+// WithSSHUser sets the login user of ssh connections, it is the principal checked by CertAuthenticator.
+func WithSSHUser(ctx context.Context, user string) context.Context {
+	return context.WithValue(ctx, sshUserContextKey, user)
+}
+
+func SSHUserFromContext(ctx context.Context) string {
+	if user, ok := ctx.Value(sshUserContextKey).(string); ok {
+		return user
+	}
+	return ""
+}
+
+var requestContextKey = ContextKey("request")
+
+// RequestFromContext returns the request being authenticated, set by the authentication filters,
+// for authenticators depending on the request, e.g. AnonymousAuthenticator scoped by path.
+func RequestFromContext(ctx context.Context) *http.Request {
+	if r, ok := ctx.Value(requestContextKey).(*http.Request); ok {
+		return r
+	}
+	return nil
+}
+
+// remoteIP returns the host of addr, or addr itself if it has no port.
+func remoteIP(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// NewTokenAuthenticationFilter authenticates the token of ExtractTokenFromRequest.
+// The "Bearer " prefix of the Authorization header is trimmed before authenticator sees the token,
+// which was passed as is before, use NewTokenAuthenticationFilterWithExtractor for the raw header.
 func NewTokenAuthenticationFilter(authenticator TokenAuthenticator) Filter {
 	return NewTokenAuthenticationFilterWithErrHandle(authenticator, nil)
 }
 
 func NewTokenAuthenticationFilterWithErrHandle(authenticator TokenAuthenticator, errhandle AuthenticateErrorHandleFunc) Filter {
+	return NewTokenAuthenticationFilterWithExtractor(authenticator, ExtractTokenFromRequest, errhandle)
+}
+
+// NewTokenAuthenticationFilterWithExtractor authenticates the token extracted by extract,
+// e.g. ChainTokenExtractors(TokenFromCookie("session"), TokenFromHeader("Authorization")),
+// or RawTokenFromHeader("Authorization") for authenticators parsing the scheme themselves.
+func NewTokenAuthenticationFilterWithExtractor(authenticator TokenAuthenticator, extract TokenExtractor, errhandle AuthenticateErrorHandleFunc) Filter {
 	authfunc := func(w http.ResponseWriter, r *http.Request) (*AuthenticateInfo, error) {
-		token := ExtracTokenFromRequest(r)
+		token := extract(r)
 		ctx := r.Context()
 		// allow authenticator to set response header
 		ctx = context.WithValue(ctx, responseHeaderContextKey, w.Header())
+		ctx = WithClientIP(ctx, remoteIP(r.RemoteAddr))
+		ctx = context.WithValue(ctx, requestContextKey, r)
 		return authenticator.Authenticate(ctx, token)
 	}
 	return NewAuthenticateFilter(authfunc, errhandle)
 }
 
+// NewHTTPAuthenticationFilter authenticates requests with authenticator, e.g. ClientCertAuthenticator.
+func NewHTTPAuthenticationFilter(authenticator HTTPAuthenticator, errhandle AuthenticateErrorHandleFunc) Filter {
+	authfunc := func(w http.ResponseWriter, r *http.Request) (*AuthenticateInfo, error) {
+		ctx := context.WithValue(r.Context(), responseHeaderContextKey, w.Header())
+		ctx = WithClientIP(ctx, remoteIP(r.RemoteAddr))
+		ctx = context.WithValue(ctx, requestContextKey, r)
+		return authenticator.Authenticate(ctx, r)
+	}
+	return NewAuthenticateFilter(authfunc, errhandle)
+}
+
 type (
 	AuthenticateErrorHandleFunc func(w http.ResponseWriter, r *http.Request, err error)
 	AuthenticateFunc            func(w http.ResponseWriter, r *http.Request) (*AuthenticateInfo, error)
@@ -137,14 +231,70 @@ func NewAuthenticateFilter(onauth AuthenticateFunc, onerr AuthenticateErrorHandl
 	})
 }
 
-func ExtracTokenFromRequest(r *http.Request) string {
-	token := r.Header.Get("Authorization")
-	if token == "" {
-		token = r.URL.Query().Get("token")
+// TokenExtractor returns the token in request, or empty if not found.
+type TokenExtractor func(r *http.Request) string
+
+// ChainTokenExtractors returns the first non-empty token of extractors.
+func ChainTokenExtractors(extractors ...TokenExtractor) TokenExtractor {
+	return func(r *http.Request) string {
+		for _, extract := range extractors {
+			if token := extract(r); token != "" {
+				return token
+			}
+		}
+		return ""
+	}
+}
+
+// TokenFromHeader extracts token from header, the "Bearer " prefix is trimmed case-insensitively.
+func TokenFromHeader(name string) TokenExtractor {
+	return func(r *http.Request) string {
+		return TrimBearer(r.Header.Get(name))
+	}
+}
+
+// RawTokenFromHeader extracts the header value as is, e.g. for authenticators of other schemes than "Bearer".
+func RawTokenFromHeader(name string) TokenExtractor {
+	return func(r *http.Request) string {
+		return r.Header.Get(name)
+	}
+}
+
+func TokenFromQuery(name string) TokenExtractor {
+	return func(r *http.Request) string {
+		return r.URL.Query().Get(name)
+	}
+}
+
+func TokenFromCookie(name string) TokenExtractor {
+	return func(r *http.Request) string {
+		if cookie, err := r.Cookie(name); err == nil {
+			return cookie.Value
+		}
+		return ""
+	}
+}
+
+// TrimBearer trims the "Bearer " prefix case-insensitively, other tokens are returned as is.
+func TrimBearer(token string) string {
+	if len(token) >= len("Bearer ") && strings.EqualFold(token[:len("Bearer ")], "Bearer ") {
+		return strings.TrimSpace(token[len("Bearer "):])
 	}
 	return token
 }
 
+// DefaultTokenExtractor extracts token from "Authorization" header with the "Bearer " prefix trimmed, or "token" query.
+var DefaultTokenExtractor = ChainTokenExtractors(TokenFromHeader("Authorization"), TokenFromQuery("token"))
+
+func ExtractTokenFromRequest(r *http.Request) string {
+	return DefaultTokenExtractor(r)
+}
+
+// Deprecated: use ExtractTokenFromRequest instead, both trim the "Bearer " prefix now.
+func ExtracTokenFromRequest(r *http.Request) string {
+	return ExtractTokenFromRequest(r)
+}
+
 var authenticateContextKey = ContextKey("authenticate")
 
 func WithAuthenticate(ctx context.Context, info AuthenticateInfo) context.Context {
@@ -175,48 +325,121 @@ func (c AuthenticatorChain) Authenticate(ctx context.Context, token string) (*Au
 	return nil, utilerrors.NewAggregate(errlist)
 }
 
+var _ TokenAuthenticator = &AudienceAuthenticator{}
+
+// NewAudienceAuthenticator rejects tokens whose validated audiences do not intersect requiredAudiences,
+// the inner authenticator must be audience aware, e.g. JWTAuthenticator or OIDCAuthenticator.
+// Empty requiredAudiences rejects all tokens.
+func NewAudienceAuthenticator(inner TokenAuthenticator, requiredAudiences []string) *AudienceAuthenticator {
+	return &AudienceAuthenticator{Authenticator: inner, Audiences: requiredAudiences}
+}
+
+type AudienceAuthenticator struct {
+	Authenticator TokenAuthenticator
+	Audiences     []string
+}
+
+func (a *AudienceAuthenticator) Authenticate(ctx context.Context, token string) (*AuthenticateInfo, error) {
+	info, err := a.Authenticator.Authenticate(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	for _, audience := range info.Audiences {
+		if slices.Contains(a.Audiences, audience) {
+			return info, nil
+		}
+	}
+	return nil, fmt.Errorf("token audiences %v do not match %v", info.Audiences, a.Audiences)
+}
+
 type OIDCAuthenticator struct {
 	Verifier               *oidc.IDTokenVerifier
 	UsernameClaimCandidate []string
 	EmailClaimCandidate    []string
 	GroupsClaimCandidate   []string
 	EmailToUsername        func(email string) string
+
+	mu      sync.RWMutex // guards Verifier on reload
+	options *OIDCOptions
 }
 
 var _ TokenAuthenticator = &OIDCAuthenticator{}
 
-func NewOIDCAuthenticator(ctx context.Context, opts *OIDCOptions) (*OIDCAuthenticator, error) {
-	// no oidc
-	if opts.Issuer == "" {
-		return nil, fmt.Errorf("oidc issuer is required")
+// clientContext sets HTTPClient into ctx for go-oidc and oauth2.
+func (o *OIDCOptions) clientContext(ctx context.Context) context.Context {
+	if o.HTTPClient == nil {
+		return ctx
 	}
-	ctx = oidc.InsecureIssuerURLContext(ctx, opts.Issuer)
-	provider, err := oidc.NewProvider(ctx, opts.Issuer)
+	return oidc.ClientContext(ctx, o.HTTPClient)
+}
+
+func newOIDCProvider(ctx context.Context, opts *OIDCOptions) (*oidc.Provider, error) {
+	provider, err := oidc.NewProvider(oidc.InsecureIssuerURLContext(opts.clientContext(ctx), opts.Issuer), opts.Issuer)
 	if err != nil {
 		return nil, fmt.Errorf("init oidc provider: %v", err)
 	}
-	verifier := provider.Verifier(&oidc.Config{
+	return provider, nil
+}
+
+func newOIDCVerifier(provider *oidc.Provider, opts *OIDCOptions) *oidc.IDTokenVerifier {
+	return provider.Verifier(&oidc.Config{
 		SkipClientIDCheck: opts.ClientID == "",
 		SkipIssuerCheck:   true,
 		ClientID:          opts.ClientID,
 	})
+}
+
+func NewOIDCAuthenticator(ctx context.Context, opts *OIDCOptions) (*OIDCAuthenticator, error) {
+	// no oidc
+	if opts.Issuer == "" {
+		return nil, fmt.Errorf("oidc issuer is required")
+	}
+	provider, err := newOIDCProvider(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
 	return &OIDCAuthenticator{
-		Verifier:               verifier,
+		Verifier:               newOIDCVerifier(provider, opts),
 		UsernameClaimCandidate: []string{"name", "email"},
 		EmailClaimCandidate:    []string{"email"},
 		GroupsClaimCandidate:   []string{"groups", "roles"},
 		EmailToUsername: func(email string) string {
 			return strings.Split(email, "@")[0]
 		},
+		options: opts,
 	}, nil
 }
 
+// PrepareReload fetches the provider metadata again, e.g. after the signing keys or endpoints changed,
+// it implements config.Reloadable.
+func (o *OIDCAuthenticator) PrepareReload(ctx context.Context) (func(), error) {
+	if o.options == nil {
+		return nil, fmt.Errorf("oidc authenticator is not created by NewOIDCAuthenticator")
+	}
+	provider, err := newOIDCProvider(ctx, o.options)
+	if err != nil {
+		return nil, err
+	}
+	verifier := newOIDCVerifier(provider, o.options)
+	return func() {
+		o.mu.Lock()
+		defer o.mu.Unlock()
+		o.Verifier = verifier
+	}, nil
+}
+
+func (o *OIDCAuthenticator) verifier() *oidc.IDTokenVerifier {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return o.Verifier
+}
+
 func (o *OIDCAuthenticator) Authenticate(ctx context.Context, token string) (*AuthenticateInfo, error) {
 	if token == "" {
 		return nil, fmt.Errorf("no token found")
 	}
-	token = strings.TrimPrefix(token, "Bearer ")
-	idToken, err := o.Verifier.Verify(ctx, token)
+	token = TrimBearer(token)
+	idToken, err := o.verifier().Verify(ctx, token)
 	if err != nil {
 		return nil, fmt.Errorf("oidc: verify token: %v", err)
 	}
@@ -320,12 +543,63 @@ func NewAnonymousAuthenticator() *AnonymousAuthenticator {
 	return &AnonymousAuthenticator{}
 }
 
-type AnonymousAuthenticator struct{}
+// NewScopedAnonymousAuthenticator allows anonymous access to the paths with the methods only,
+// e.g. NewScopedAnonymousAuthenticator([]string{"/healthz", "/public/**"}, []string{"GET"}, []string{AnonymousGroup}).
+func NewScopedAnonymousAuthenticator(paths, methods, groups []string) *AnonymousAuthenticator {
+	return &AnonymousAuthenticator{Paths: paths, Methods: methods, Groups: groups}
+}
+
+// AnonymousAuthenticator accepts any request as AnonymousUser.
+// If Paths or Methods set, requests out of them are refused, so an AuthenticatorChain falls through to the next,
+// a scoped one must not be cached by token, e.g. by LRUCacheAuthenticator.
+type AnonymousAuthenticator struct {
+	Paths   []string // path patterns of path.Match, a trailing "/**" matches all sub paths, empty matches all
+	Methods []string // empty matches all
+	Groups  []string // groups of the anonymous user, e.g. AnonymousGroup
+}
 
 var _ TokenAuthenticator = &AnonymousAuthenticator{}
 
 func (a *AnonymousAuthenticator) Authenticate(ctx context.Context, token string) (*AuthenticateInfo, error) {
-	return &AuthenticateInfo{User: UserInfo{Name: AnonymousUser, Groups: []string{}}}, nil
+	if len(a.Paths) > 0 || len(a.Methods) > 0 {
+		r := RequestFromContext(ctx)
+		if r == nil {
+			return nil, fmt.Errorf("anonymous access not allowed")
+		}
+		if !a.allowed(r.Method, r.URL.Path) {
+			return nil, fmt.Errorf("anonymous access not allowed for %s %s", r.Method, r.URL.Path)
+		}
+	}
+	groups := a.Groups
+	if groups == nil {
+		groups = []string{}
+	}
+	return &AuthenticateInfo{User: UserInfo{Name: AnonymousUser, Groups: groups}}, nil
+}
+
+func (a *AnonymousAuthenticator) allowed(method, reqpath string) bool {
+	if len(a.Methods) > 0 && !slices.ContainsFunc(a.Methods, func(m string) bool { return strings.EqualFold(m, method) }) {
+		return false
+	}
+	if len(a.Paths) == 0 {
+		return true
+	}
+	reqpath = path.Clean("/" + reqpath)
+	for _, pattern := range a.Paths {
+		if matchPathPattern(pattern, reqpath) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPathPattern matches a cleaned path with a pattern of path.Match, a trailing "/**" matches all sub paths.
+func matchPathPattern(pattern, reqpath string) bool {
+	if prefix, ok := strings.CutSuffix(pattern, "/**"); ok {
+		return reqpath == prefix || strings.HasPrefix(reqpath, prefix+"/")
+	}
+	matched, _ := path.Match(pattern, reqpath)
+	return matched
 }
 
 var _ TokenAuthenticator = &LRUCacheAuthenticator{}
@@ -333,13 +607,31 @@ var _ TokenAuthenticator = &LRUCacheAuthenticator{}
 func NewCacheAuthenticator(authenticator TokenAuthenticator, size int, ttl time.Duration) *LRUCacheAuthenticator {
 	return &LRUCacheAuthenticator{
 		Authenticator: authenticator,
-		Cache:         NewLRUCache[*AuthenticateInfo](size, ttl),
+		Cache:         NewLRUCacheWithOptions[*AuthenticateInfo](size, ttl, NewDefaultLRUCacheOptions()),
 	}
 }
 
 type LRUCacheAuthenticator struct {
 	Authenticator TokenAuthenticator
-	Cache         LRUCache[*AuthenticateInfo]
+	Cache         Cache[*AuthenticateInfo] // e.g. LRUCache or a shared cache
+	// IsRevoked is checked on each authentication if set, e.g. against a shared revocation list,
+	// revoked results are removed from cache and the authentication fails.
+	IsRevoked func(ctx context.Context, token string, info *AuthenticateInfo) bool
+	// NegativeCache caches failures to protect the upstream from storms of invalid tokens, nil disables.
+	// It is separate from Cache so invalid tokens never evict valid ones, see WithNegativeCache.
+	NegativeCache Cache[error]
+
+	mu     sync.Mutex
+	tokens map[string]map[string]struct{} // username -> cached tokens, for RevokeUser
+}
+
+var ErrTokenRevoked = errors.New("token revoked")
+
+// WithNegativeCache caches up to size failures for a short ttl, jittered in [ttl/2, ttl],
+// so a token valid later, e.g. after the IdP recovered, is accepted soon.
+func (a *LRUCacheAuthenticator) WithNegativeCache(size int, ttl time.Duration) *LRUCacheAuthenticator {
+	a.NegativeCache = NewLRUCacheWithOptions[error](size, ttl, LRUCacheOptions{Jitter: 0.5})
+	return a
 }
 
 // Authenticate implements TokenAuthenticator.
@@ -348,33 +640,98 @@ func (a *LRUCacheAuthenticator) Authenticate(ctx context.Context, token string)
 	if token == "" {
 		return a.Authenticator.Authenticate(ctx, token)
 	}
-	return a.Cache.GetOrAdd(token, func() (*AuthenticateInfo, error) {
-		return a.Authenticator.Authenticate(ctx, token)
+	if a.NegativeCache != nil {
+		if err, ok := a.NegativeCache.Get(ctx, token); ok {
+			return nil, err
+		}
+	}
+	info, err := GetOrRefresh(ctx, a.Cache, token, func(ctx context.Context) (*AuthenticateInfo, error) {
+		info, err := a.Authenticator.Authenticate(ctx, token)
+		if err == nil && info != nil {
+			a.track(ctx, info.User.Name, token)
+		}
+		return info, err
 	})
+	if err != nil {
+		// failures caused by the caller are not the result of the token
+		if a.NegativeCache != nil && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) {
+			a.NegativeCache.Add(ctx, token, err)
+		}
+		return nil, err
+	}
+	if a.IsRevoked != nil && a.IsRevoked(ctx, token, info) {
+		a.Revoke(ctx, token)
+		return nil, ErrTokenRevoked
+	}
+	return info, nil
+}
+
+// Revoke removes the cached result of token, e.g. on logout.
+// With an LRUCache, authentications and refreshes in flight are not cached after it, see addSince.
+func (a *LRUCacheAuthenticator) Revoke(ctx context.Context, token string) {
+	if a.Cache != nil {
+		a.Cache.Remove(ctx, token)
+	}
+}
+
+// RevokeUser removes the cached results of all tokens of user, e.g. on credential compromise.
+// Only the tokens cached by this authenticator are known, shared caches should use IsRevoked instead.
+func (a *LRUCacheAuthenticator) RevokeUser(ctx context.Context, username string) {
+	a.mu.Lock()
+	tokens := a.tokens[username]
+	delete(a.tokens, username)
+	a.mu.Unlock()
+	for token := range tokens {
+		a.Revoke(ctx, token)
+	}
+}
+
+// track indexes token by username, tokens no longer cached are dropped.
+func (a *LRUCacheAuthenticator) track(ctx context.Context, username, token string) {
+	if a.Cache == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.tokens == nil {
+		a.tokens = map[string]map[string]struct{}{}
+	}
+	tokens, ok := a.tokens[username]
+	if !ok {
+		tokens = map[string]struct{}{}
+		a.tokens[username] = tokens
+	}
+	for exist := range tokens {
+		if _, cached := a.Cache.Get(ctx, exist); !cached {
+			delete(tokens, exist)
+		}
+	}
+	tokens[token] = struct{}{}
 }
 
 func NewCachedSSHAuthenticator(authenticator SSHAuthenticator, size int, ttl time.Duration) *LRUCacheSSHAuthenticator {
-	return &LRUCacheSSHAuthenticator{Authenticator: authenticator, Cache: NewLRUCache[*AuthenticateInfo](size, ttl)}
+	return &LRUCacheSSHAuthenticator{Authenticator: authenticator, Cache: NewLRUCacheWithOptions[*AuthenticateInfo](size, ttl, NewDefaultLRUCacheOptions())}
 }
 
 var _ SSHAuthenticator = &LRUCacheSSHAuthenticator{}
 
 type LRUCacheSSHAuthenticator struct {
 	Authenticator SSHAuthenticator
-	Cache         LRUCache[*AuthenticateInfo]
+	Cache         Cache[*AuthenticateInfo] // e.g. LRUCache or a shared cache
 }
 
 // AuthenticatePublibcKey implements SSHAuthenticator.
 func (a *LRUCacheSSHAuthenticator) AuthenticatePublibcKey(ctx context.Context, pubkey ssh.PublicKey) (*AuthenticateInfo, error) {
-	return a.Cache.GetOrAdd(ssh.FingerprintSHA256(pubkey), func() (*AuthenticateInfo, error) {
+	// certificates are valid for some login users only
+	key := SSHUserFromContext(ctx) + ":" + ssh.FingerprintSHA256(pubkey)
+	return GetOrRefresh(ctx, a.Cache, key, func(ctx context.Context) (*AuthenticateInfo, error) {
 		return a.Authenticator.AuthenticatePublibcKey(ctx, pubkey)
-	},
-	)
+	})
 }
 
 // AuthenticatePassword implements SSHAuthenticator.
 func (a *LRUCacheSSHAuthenticator) Authenticate(ctx context.Context, username, password string) (*AuthenticateInfo, error) {
-	return a.Cache.GetOrAdd(fmt.Sprintf("%s:%s", username, password), func() (*AuthenticateInfo, error) {
+	return GetOrRefresh(ctx, a.Cache, fmt.Sprintf("%s:%s", username, password), func(ctx context.Context) (*AuthenticateInfo, error) {
 		return a.Authenticator.Authenticate(ctx, username, password)
 	})
 }
@@ -0,0 +1,173 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestScopedAnonymousAuthenticator(t *testing.T) {
+	token := AuthenticatorChain{
+		NewScopedAnonymousAuthenticator([]string{"/healthz", "/public/**", "/docs/*.json"}, []string{"GET"}, []string{AnonymousGroup}),
+		TokenAuthenticateFunc(func(ctx context.Context, token string) (*AuthenticateInfo, error) {
+			if TrimBearer(token) != "valid" {
+				return nil, errors.New("invalid token")
+			}
+			return &AuthenticateInfo{User: UserInfo{Name: "bob"}}, nil
+		}),
+	}
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		token    string
+		wantCode int
+		wantUser string
+	}{
+		{name: "exact path", method: http.MethodGet, path: "/healthz", wantCode: http.StatusOK, wantUser: AnonymousUser},
+		{name: "sub path", method: http.MethodGet, path: "/public/a/b", wantCode: http.StatusOK, wantUser: AnonymousUser},
+		{name: "glob", method: http.MethodGet, path: "/docs/api.json", wantCode: http.StatusOK, wantUser: AnonymousUser},
+		{name: "dot dot escapes", method: http.MethodGet, path: "/public/../admin", wantCode: http.StatusUnauthorized},
+		{name: "method not allowed", method: http.MethodPost, path: "/public/a", wantCode: http.StatusUnauthorized},
+		{name: "out of scope", method: http.MethodGet, path: "/admin", wantCode: http.StatusUnauthorized},
+		{name: "falls through", method: http.MethodGet, path: "/admin", token: "Bearer valid", wantCode: http.StatusOK, wantUser: "bob"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/", nil)
+			req.URL.Path = tt.path
+			if tt.token != "" {
+				req.Header.Set("Authorization", tt.token)
+			}
+			rec := httptest.NewRecorder()
+			user := ""
+			NewTokenAuthenticationFilter(token).Process(rec, req, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				user = AuthenticateFromContext(r.Context()).User.Name
+			}))
+			if rec.Code != tt.wantCode {
+				t.Fatalf("code = %d, want %d", rec.Code, tt.wantCode)
+			}
+			if user != tt.wantUser {
+				t.Errorf("user = %s, want %s", user, tt.wantUser)
+			}
+		})
+	}
+}
+
+func TestTrimBearer(t *testing.T) {
+	tests := []struct {
+		token string
+		want  string
+	}{
+		{token: "Bearer abc", want: "abc"},
+		{token: "bearer abc", want: "abc"},
+		{token: "BEARER  abc ", want: "abc"},
+		{token: "Bearer ", want: ""},
+		{token: "Basic YWxpY2U6c2VjcmV0", want: "Basic YWxpY2U6c2VjcmV0"},
+		{token: "Bearerabc", want: "Bearerabc"},
+		{token: "abc", want: "abc"},
+		{token: "", want: ""},
+	}
+	for _, tt := range tests {
+		if got := TrimBearer(tt.token); got != tt.want {
+			t.Errorf("TrimBearer(%q) = %q, want %q", tt.token, got, tt.want)
+		}
+	}
+}
+
+func TestTokenExtractors(t *testing.T) {
+	newRequest := func(target string, header map[string]string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		return req
+	}
+	chain := ChainTokenExtractors(TokenFromCookie("session"), TokenFromHeader("X-Token"))
+	tests := []struct {
+		name    string
+		extract TokenExtractor
+		req     *http.Request
+		want    string
+	}{
+		{name: "default header", extract: DefaultTokenExtractor, req: newRequest("/?token=q", map[string]string{"Authorization": "Bearer h"}), want: "h"},
+		{name: "default query", extract: DefaultTokenExtractor, req: newRequest("/?token=q", nil), want: "q"},
+		{name: "default none", extract: DefaultTokenExtractor, req: newRequest("/", nil), want: ""},
+		{name: "raw header", extract: RawTokenFromHeader("Authorization"), req: newRequest("/", map[string]string{"Authorization": "Bearer h"}), want: "Bearer h"},
+		{name: "chain cookie", extract: chain, req: newRequest("/", map[string]string{"Cookie": "session=c", "X-Token": "h"}), want: "c"},
+		{name: "chain header", extract: chain, req: newRequest("/", map[string]string{"X-Token": "bearer h"}), want: "h"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.extract(tt.req); got != tt.want {
+				t.Errorf("extract() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenAuthenticationFilterToken(t *testing.T) {
+	var got string
+	authenticator := TokenAuthenticateFunc(func(ctx context.Context, token string) (*AuthenticateInfo, error) {
+		got = token
+		return &AuthenticateInfo{User: UserInfo{Name: "bob"}}, nil
+	})
+	tests := []struct {
+		name   string
+		filter Filter
+		want   string
+	}{
+		{name: "bearer trimmed", filter: NewTokenAuthenticationFilter(authenticator), want: "abc"},
+		{name: "raw header", filter: NewTokenAuthenticationFilterWithExtractor(authenticator, RawTokenFromHeader("Authorization"), nil), want: "Bearer abc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Authorization", "Bearer abc")
+			Filters{tt.filter}.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(httptest.NewRecorder(), req)
+			if got != tt.want {
+				t.Errorf("authenticated token = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAudienceAuthenticator(t *testing.T) {
+	inner := TokenAuthenticateFunc(func(ctx context.Context, token string) (*AuthenticateInfo, error) {
+		if token == "invalid" {
+			return nil, errors.New("invalid token")
+		}
+		audiences := []string{}
+		if token != "" {
+			audiences = strings.Split(token, ",")
+		}
+		return &AuthenticateInfo{User: UserInfo{Name: "bob"}, Audiences: audiences}, nil
+	})
+	tests := []struct {
+		name      string
+		audiences []string
+		token     string
+		wantErr   bool
+	}{
+		{name: "matched", audiences: []string{"api"}, token: "api"},
+		{name: "any matched", audiences: []string{"api", "web"}, token: "cli,web"},
+		{name: "not matched", audiences: []string{"api"}, token: "web", wantErr: true},
+		{name: "no audiences in token", audiences: []string{"api"}, token: "", wantErr: true},
+		{name: "no audiences required", token: "api", wantErr: true},
+		{name: "inner error", audiences: []string{"api"}, token: "invalid", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := NewAudienceAuthenticator(inner, tt.audiences).Authenticate(context.Background(), tt.token)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Authenticate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && info.User.Name != "bob" {
+				t.Errorf("Authenticate() user = %s", info.User.Name)
+			}
+		})
+	}
+}
@@ -2,13 +2,19 @@ package api
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"net"
 	"net/http"
+	"strings"
+	"sync/atomic"
 	"time"
 
-	"github.com/hashicorp/golang-lru/v2/expirable"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/exp/slices"
 	"kubegems.io/library/rest/response"
 )
 
@@ -22,6 +28,58 @@ const (
 
 var DecisionDenyStatusNotFoundMessage = "not found"
 
+func (d Decision) String() string {
+	switch d {
+	case DecisionDeny:
+		return "deny"
+	case DecisionAllow:
+		return "allow"
+	case DecisionNoOpinion:
+		return "noOpinion"
+	default:
+		return fmt.Sprintf("Decision(%d)", int(d))
+	}
+}
+
+var authorizationNoCacheContextKey = ContextKey("authorization-no-cache")
+
+// DisableAuthorizationCache is called by authorizers whose decisions depend on more than the user and the attributes,
+// e.g. the time or the client ip, so LRUCacheAuthorizer does not cache the decision being made.
+func DisableAuthorizationCache(ctx context.Context) {
+	if nocache, ok := ctx.Value(authorizationNoCacheContextKey).(*atomic.Bool); ok {
+		nocache.Store(true)
+	}
+}
+
+// AuthorizationResult is the decision made by NewAuthorizationFilter, recorded in audit logs.
+type AuthorizationResult struct {
+	Decision string `json:"decision"`
+	Reason   string `json:"reason,omitempty"`
+	Policy   string `json:"policy,omitempty"` // the policy made the decision, set by authorizers with SetAuthorizationPolicy
+}
+
+var authorizationResultContextKey = ContextKey("authorization-result")
+
+func withAuthorizationResult(ctx context.Context, result *AuthorizationResult) context.Context {
+	return context.WithValue(ctx, authorizationResultContextKey, result)
+}
+
+// AuthorizationResultFromContext returns the result of NewAuthorizationFilter, nil if not authorized by it.
+func AuthorizationResultFromContext(ctx context.Context) *AuthorizationResult {
+	if result, ok := ctx.Value(authorizationResultContextKey).(*AuthorizationResult); ok && result.Decision != "" {
+		return result
+	}
+	return nil
+}
+
+// SetAuthorizationPolicy is called by authorizers to record the policy that made the decision,
+// e.g. a role binding or a rule name. It does nothing out of NewAuthorizationFilter.
+func SetAuthorizationPolicy(ctx context.Context, policy string) {
+	if result, ok := ctx.Value(authorizationResultContextKey).(*AuthorizationResult); ok {
+		result.Policy = policy
+	}
+}
+
 type RequestAuthorizer interface {
 	AuthorizeRequest(r *http.Request) (Decision, string, error)
 }
@@ -54,22 +112,199 @@ func NewAlwaysDenyAuthorizer() Authorizer {
 	})
 }
 
+// NewGroupAllowAuthorizer allows users in any of groups and has no opinion on others,
+// e.g. AuthorizerChain{NewGroupAllowAuthorizer("admins"), NewAlwaysDenyAuthorizer()} allows admins only.
+func NewGroupAllowAuthorizer(groups ...string) Authorizer {
+	return AuthorizerFunc(func(ctx context.Context, user UserInfo, a Attributes) (Decision, string, error) {
+		if group, ok := inGroups(user, groups); ok {
+			return DecisionAllow, "allowed group " + group, nil
+		}
+		return DecisionNoOpinion, "", nil
+	})
+}
+
+// NewGroupDenyAuthorizer denies users in any of groups and has no opinion on others,
+// e.g. place NewGroupDenyAuthorizer("system:serviceaccounts") first in an AuthorizerChain to block service accounts.
+func NewGroupDenyAuthorizer(groups ...string) Authorizer {
+	return AuthorizerFunc(func(ctx context.Context, user UserInfo, a Attributes) (Decision, string, error) {
+		if group, ok := inGroups(user, groups); ok {
+			return DecisionDeny, "denied group " + group, nil
+		}
+		return DecisionNoOpinion, "", nil
+	})
+}
+
+func inGroups(user UserInfo, groups []string) (string, bool) {
+	for _, group := range user.Groups {
+		if slices.Contains(groups, group) {
+			return group, true
+		}
+	}
+	return "", false
+}
+
+// NewHierarchicalAuthorizer returns an authorizer inheriting permissions of the parent resources.
+func NewHierarchicalAuthorizer(authorizer Authorizer, noInherit ...string) *HierarchicalAuthorizer {
+	return &HierarchicalAuthorizer{Authorizer: authorizer, NoInherit: noInherit}
+}
+
+// HierarchicalAuthorizer inherits the decision of the nearest parent if it has no opinion on a resource,
+// e.g. access granted on zoos:z1 implies access to zoos:z1:animals:a1.
+// The parents are checked from the most specific one, see Attributes.Parents,
+// an explicit deny is final, so a resource can be denied under an allowed parent.
+type HierarchicalAuthorizer struct {
+	Authorizer Authorizer
+	// NoInherit lists the resources not inheriting permissions, e.g. "secrets", only their own decisions apply.
+	NoInherit []string
+}
+
+func (h *HierarchicalAuthorizer) Authorize(ctx context.Context, user UserInfo, a Attributes) (Decision, string, error) {
+	decision, reason, err := h.Authorizer.Authorize(ctx, user, a)
+	if err != nil || decision != DecisionNoOpinion || len(a.Resources) == 0 {
+		return decision, reason, err
+	}
+	if slices.Contains(h.NoInherit, a.Resources[len(a.Resources)-1].Resource) {
+		return decision, reason, nil
+	}
+	for _, parent := range a.Parents() {
+		parentDecision, parentReason, err := h.Authorizer.Authorize(ctx, user, parent)
+		if err != nil {
+			return DecisionDeny, parentReason, err
+		}
+		if parentDecision != DecisionNoOpinion {
+			_, expr := parent.ToWildcards()
+			return parentDecision, strings.TrimPrefix(parentReason+"; inherited from "+expr, "; "), nil
+		}
+	}
+	return decision, reason, nil
+}
+
+// AuthorizerChain stops at the first authorizer allows or denies, see ChainFirstApplicable.
 type AuthorizerChain []Authorizer
 
 func (c AuthorizerChain) Authorize(ctx context.Context, user UserInfo, a Attributes) (Decision, string, error) {
-	for _, authorizer := range c {
+	return ChainFirstApplicable.authorize(ctx, c, user, a)
+}
+
+// ChainStrategy resolves the conflicts between the decisions of authorizers in a chain,
+// errors are taken as deny.
+type ChainStrategy string
+
+const (
+	// ChainFirstApplicable takes the first allow or deny, it is the strategy of AuthorizerChain.
+	ChainFirstApplicable ChainStrategy = "firstApplicable"
+	// ChainFirstAllowWins allows if any authorizer allows, even if an earlier one denies.
+	ChainFirstAllowWins ChainStrategy = "firstAllowWins"
+	// ChainUnanimousAllow allows only if all authorizers allow, no opinion is taken as deny.
+	ChainUnanimousAllow ChainStrategy = "unanimousAllow"
+	// ChainDenyOverrides denies if any authorizer denies, even if an earlier one allows.
+	ChainDenyOverrides ChainStrategy = "denyOverrides"
+)
+
+// NewAuthorizerChain returns a chain resolving decisions with strategy, an empty strategy is ChainFirstApplicable.
+func NewAuthorizerChain(strategy ChainStrategy, authorizers ...Authorizer) (*StrategyAuthorizerChain, error) {
+	switch strategy {
+	case "":
+		strategy = ChainFirstApplicable
+	case ChainFirstApplicable, ChainFirstAllowWins, ChainUnanimousAllow, ChainDenyOverrides:
+	default:
+		return nil, fmt.Errorf("unknown authorizer chain strategy %q", strategy)
+	}
+	return &StrategyAuthorizerChain{Strategy: strategy, Authorizers: authorizers}, nil
+}
+
+type StrategyAuthorizerChain struct {
+	Strategy    ChainStrategy
+	Authorizers []Authorizer
+}
+
+func (c *StrategyAuthorizerChain) Authorize(ctx context.Context, user UserInfo, a Attributes) (Decision, string, error) {
+	return c.Strategy.authorize(ctx, c.Authorizers, user, a)
+}
+
+type authorizerResult struct {
+	decision Decision
+	reason   string
+	err      error
+}
+
+func (r authorizerResult) allowed() bool {
+	return r.err == nil && r.decision == DecisionAllow
+}
+
+func (r authorizerResult) denied() bool {
+	return r.err != nil || r.decision == DecisionDeny
+}
+
+func (s ChainStrategy) authorize(ctx context.Context, authorizers []Authorizer, user UserInfo, a Attributes) (Decision, string, error) {
+	results := make([]authorizerResult, 0, len(authorizers))
+	for _, authorizer := range authorizers {
 		decision, reason, err := authorizer.Authorize(ctx, user, a)
-		if err != nil {
-			return DecisionDeny, reason, err
+		results = append(results, authorizerResult{decision: decision, reason: reason, err: err})
+		if _, done := s.resolve(results); done {
+			break
 		}
-		if decision == DecisionAllow {
-			return DecisionAllow, reason, nil
+	}
+	return s.result(results)
+}
+
+// result returns the final decision of results.
+func (s ChainStrategy) result(results []authorizerResult) (Decision, string, error) {
+	effective, _ := s.resolve(results)
+	if effective < 0 {
+		return DecisionDeny, "no decision", nil
+	}
+	r := results[effective]
+	switch {
+	case r.err != nil:
+		return DecisionDeny, r.reason, r.err
+	case r.decision == DecisionNoOpinion:
+		return DecisionDeny, "no decision", nil
+	default:
+		return r.decision, r.reason, nil
+	}
+}
+
+// resolve returns the index of the result making the final decision, -1 if none,
+// done is true if the decision can not be changed by the results later.
+func (s ChainStrategy) resolve(results []authorizerResult) (effective int, done bool) {
+	effective = -1
+	switch s {
+	case ChainFirstAllowWins:
+		for i, r := range results {
+			if r.allowed() {
+				return i, true
+			}
+			if effective < 0 && r.denied() {
+				effective = i
+			}
 		}
-		if decision == DecisionDeny {
-			return DecisionDeny, reason, nil
+		return effective, false
+	case ChainDenyOverrides:
+		for i, r := range results {
+			if r.denied() {
+				return i, true
+			}
+			if effective < 0 && r.allowed() {
+				effective = i
+			}
+		}
+		return effective, false
+	case ChainUnanimousAllow:
+		for i, r := range results {
+			if !r.allowed() {
+				return i, true
+			}
+		}
+		return len(results) - 1, false
+	default:
+		for i, r := range results {
+			if r.err != nil || r.decision != DecisionNoOpinion {
+				return i, true
+			}
 		}
+		return -1, false
 	}
-	return DecisionDeny, "no decision", nil
 }
 
 type ContextKey string
@@ -133,8 +368,7 @@ func NewAllowCIDRAuthorizer(cidrs []string, defaultDec Decision) RequestAuthoriz
 }
 
 func RequestSourceIPInCIDR(cidrs []string, r *http.Request) bool {
-	ip, _, _ := net.SplitHostPort(r.RemoteAddr)
-	return InCIDR(ip, cidrs)
+	return InCIDR(remoteIP(r.RemoteAddr), cidrs)
 }
 
 func InCIDR(ip string, cidrs []string) bool {
@@ -154,8 +388,10 @@ func InCIDR(ip string, cidrs []string) bool {
 	return false
 }
 
+// NewAuthorizationFilter authorizes the attributes set by NewAttributeFilter,
+// the decision and reason are recorded in the audit log, see AuthorizationResultFromContext.
 func NewAuthorizationFilter(authorizer Authorizer) Filter {
-	return NewRequestAuthorizationFilter(func(r *http.Request) (Decision, string, error) {
+	filter := NewRequestAuthorizationFilter(func(r *http.Request) (Decision, string, error) {
 		attributes := AttributesFromContext(r.Context())
 		if attributes == nil {
 			return DecisionDeny, "no attributes", nil
@@ -172,38 +408,174 @@ func NewAuthorizationFilter(authorizer Authorizer) Filter {
 			}()),
 		)
 		user := AuthenticateFromContext(r.Context()).User
-		return authorizer.Authorize(r.Context(), user, *attributes)
+		// the client ip for authorizers depending on the request source, e.g. NewSourceCIDRCondition
+		ctx := WithClientIP(r.Context(), remoteIP(r.RemoteAddr))
+		decision, reason, err := authorizer.Authorize(ctx, user, *attributes)
+		if result, ok := r.Context().Value(authorizationResultContextKey).(*AuthorizationResult); ok {
+			result.Decision, result.Reason = decision.String(), reason
+			if err != nil {
+				result.Reason = err.Error()
+			}
+			// the audit log is set before authorization if audit filter placed before
+			if auditlog := AuditLogFromContext(r.Context()); auditlog != nil {
+				auditlog.Authorization = result
+			}
+		}
+		return decision, reason, err
+	})
+	return FilterFunc(func(w http.ResponseWriter, r *http.Request, next http.Handler) {
+		ctx := withAuthorizationResult(r.Context(), &AuthorizationResult{})
+		filter.Process(w, r.WithContext(ctx), next)
 	})
 }
 
-func NewCacheAuthorizer(authorizer Authorizer, size int, ttl time.Duration) Authorizer {
+func NewCacheAuthorizer(authorizer Authorizer, size int, ttl time.Duration) *LRUCacheAuthorizer {
 	return &LRUCacheAuthorizer{
 		Authorizer: authorizer,
-		cache:      expirable.NewLRU[string, Decision](size, nil, ttl),
+		Cache:      NewLRUCacheWithOptions[AuthorizationResult](size, ttl, NewDefaultLRUCacheOptions()),
 	}
 }
 
+// LRUCacheAuthorizer caches the decisions by user name and groups, resource and action,
+// decisions of authorizers calling DisableAuthorizationCache, e.g. ConditionalAuthorizer, are not cached.
 type LRUCacheAuthorizer struct {
 	Authorizer Authorizer
-	cache      *expirable.LRU[string, Decision]
+	Cache      Cache[AuthorizationResult] // only allowed decisions are cached, with the reasons and policies
+	// DenyCache caches the denied decisions with the reasons and policies, nil disables, see WithDenyCache.
+	DenyCache Cache[AuthorizationResult]
+
+	hits, misses, denyHits atomic.Int64
+}
+
+// AuthorizerCacheStats are the counters of LRUCacheAuthorizer, e.g. for metrics.
+type AuthorizerCacheStats struct {
+	Hits     int64 // allowed decisions served from cache
+	DenyHits int64 // denied decisions served from cache
+	Misses   int64 // decisions made by the authorizer
+}
+
+// WithDenyCache caches up to size denied decisions for ttl, keep ttl short as a deny is often fixed by granting permissions.
+func (c *LRUCacheAuthorizer) WithDenyCache(size int, ttl time.Duration) *LRUCacheAuthorizer {
+	c.DenyCache = NewLRUCacheWithOptions[AuthorizationResult](size, ttl, LRUCacheOptions{Jitter: 0.5})
+	return c
+}
+
+func (c *LRUCacheAuthorizer) Stats() AuthorizerCacheStats {
+	return AuthorizerCacheStats{Hits: c.hits.Load(), DenyHits: c.denyHits.Load(), Misses: c.misses.Load()}
 }
 
-// Authorize implements Authorizer.
+// Purge removes all cached decisions if the cache supports it, e.g. LRUCache.
+func (c *LRUCacheAuthorizer) Purge() {
+	if purger, ok := c.Cache.(interface{ Purge() }); ok {
+		purger.Purge()
+	}
+	if purger, ok := c.DenyCache.(interface{ Purge() }); ok {
+		purger.Purge()
+	}
+}
+
+// InvalidateUser removes the cached decisions of user, e.g. after the roles of user changed.
+// Only caches supporting RemoveFunc are invalidated, e.g. LRUCache.
+func (c *LRUCacheAuthorizer) InvalidateUser(username string) int {
+	return c.removeFunc(func(key string) bool {
+		user, _, _ := splitAuthorizationCacheKey(key)
+		return user == username
+	})
+}
+
+// InvalidateResource removes the cached decisions of resources with prefix,
+// prefix is in the form of Attributes.ToWildcards, e.g. "namespaces:default".
+func (c *LRUCacheAuthorizer) InvalidateResource(prefix string) int {
+	return c.removeFunc(func(key string) bool {
+		_, expr, _ := splitAuthorizationCacheKey(key)
+		return expr == prefix || strings.HasPrefix(expr, prefix+":")
+	})
+}
+
+func (c *LRUCacheAuthorizer) removeFunc(match func(key string) bool) int {
+	removed := 0
+	for _, cache := range []any{c.Cache, c.DenyCache} {
+		if remover, ok := cache.(interface{ RemoveFunc(func(string) bool) int }); ok {
+			removed += remover.RemoveFunc(match)
+		}
+	}
+	return removed
+}
+
+// authorizationCacheKey joins with NUL which is not allowed in user names and paths,
+// the groups are hashed in, so users of the same name but other groups do not share decisions.
+func authorizationCacheKey(user UserInfo, expr, action string) string {
+	return user.Name + "\x00" + groupsHash(user.Groups) + "\x00" + expr + "\x00" + action
+}
+
+func splitAuthorizationCacheKey(key string) (string, string, string) {
+	user, rest, _ := strings.Cut(key, "\x00")
+	_, rest, _ = strings.Cut(rest, "\x00")
+	expr, action, _ := strings.Cut(rest, "\x00")
+	return user, expr, action
+}
+
+// groupsHash is independent of the order of groups.
+func groupsHash(groups []string) string {
+	sorted := slices.Clone(groups)
+	slices.Sort(sorted)
+	sum := sha256.Sum256([]byte(strings.Join(sorted, "\x00")))
+	return hex.EncodeToString(sum[:16])
+}
+
+// Authorize implements Authorizer, the policy of a cached decision is set again, see SetAuthorizationPolicy.
 func (c *LRUCacheAuthorizer) Authorize(ctx context.Context, user UserInfo, a Attributes) (authorized Decision, reason string, err error) {
-	if c.cache == nil {
+	if c.Cache == nil && c.DenyCache == nil {
 		return c.Authorizer.Authorize(ctx, user, a)
 	}
 	act, expr := a.ToWildcards()
-	key := user.Name + "@" + expr + ":" + act
-	if decision, ok := c.cache.Get(key); ok {
-		return decision, "", nil
+	key := authorizationCacheKey(user, expr, act)
+	// decisions made before an invalidation, e.g. InvalidateUser, are not cached
+	allowGeneration, denyGeneration := cacheGeneration(c.Cache), cacheGeneration(c.DenyCache)
+	if c.Cache != nil {
+		if result, ok := c.Cache.Get(ctx, key); ok {
+			c.hits.Add(1)
+			refreshAhead(ctx, c.Cache, allowGeneration, key, func(ctx context.Context) (AuthorizationResult, error) {
+				decision, result, cacheable, err := c.authorize(ctx, user, a)
+				if err == nil && (decision != DecisionAllow || !cacheable) {
+					err = errors.New("decision is no longer allowed")
+				}
+				return result, err
+			})
+			SetAuthorizationPolicy(ctx, result.Policy)
+			return DecisionAllow, result.Reason, nil
+		}
 	}
-	decision, reason, err := c.Authorizer.Authorize(ctx, user, a)
-	if err != nil {
-		return decision, reason, err
+	if c.DenyCache != nil {
+		if result, ok := c.DenyCache.Get(ctx, key); ok {
+			c.denyHits.Add(1)
+			SetAuthorizationPolicy(ctx, result.Policy)
+			return DecisionDeny, result.Reason, nil
+		}
 	}
-	if decision == DecisionAllow {
-		c.cache.Add(key, decision)
+	c.misses.Add(1)
+	decision, result, cacheable, err := c.authorize(ctx, user, a)
+	if result.Policy != "" {
+		SetAuthorizationPolicy(ctx, result.Policy)
 	}
-	return decision, reason, nil
+	if err != nil || !cacheable {
+		return decision, result.Reason, err
+	}
+	switch {
+	case decision == DecisionAllow && c.Cache != nil:
+		addSince(ctx, c.Cache, allowGeneration, key, result)
+	case decision == DecisionDeny && c.DenyCache != nil:
+		addSince(ctx, c.DenyCache, denyGeneration, key, result)
+	}
+	return decision, result.Reason, nil
+}
+
+// authorize returns the decision with the reason and the policy set by the authorizer,
+// and whether it can be cached, see DisableAuthorizationCache.
+func (c *LRUCacheAuthorizer) authorize(ctx context.Context, user UserInfo, a Attributes) (Decision, AuthorizationResult, bool, error) {
+	nocache, result := &atomic.Bool{}, &AuthorizationResult{}
+	ctx = withAuthorizationResult(context.WithValue(ctx, authorizationNoCacheContextKey, nocache), result)
+	decision, reason, err := c.Authorizer.Authorize(ctx, user, a)
+	result.Decision, result.Reason = decision.String(), reason
+	return decision, *result, !nocache.Load(), err
 }
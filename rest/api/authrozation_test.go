@@ -0,0 +1,267 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGroupAuthorizers(t *testing.T) {
+	chain := AuthorizerChain{
+		NewGroupDenyAuthorizer("system:serviceaccounts"),
+		NewGroupAllowAuthorizer("admins"),
+	}
+	tests := []struct {
+		name   string
+		groups []string
+		want   Decision
+	}{
+		{name: "admin", groups: []string{"dev", "admins"}, want: DecisionAllow},
+		{name: "service account admin", groups: []string{"admins", "system:serviceaccounts"}, want: DecisionDeny},
+		{name: "others", groups: []string{"dev"}, want: DecisionDeny},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, err := chain.Authorize(context.Background(), UserInfo{Name: "bob", Groups: tt.groups}, Attributes{})
+			if err != nil || got != tt.want {
+				t.Errorf("Authorize() = %v, %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLRUCacheAuthorizer(t *testing.T) {
+	ctx := context.Background()
+	calls := 0
+	inner := AuthorizerFunc(func(ctx context.Context, user UserInfo, a Attributes) (Decision, string, error) {
+		calls++
+		if user.Name == "admin" {
+			return DecisionAllow, "", nil
+		}
+		return DecisionDeny, DecisionDenyStatusNotFoundMessage, nil
+	})
+	authorizer := NewCacheAuthorizer(inner, 10, time.Minute).WithDenyCache(10, time.Minute)
+	attrs := func(ns string) Attributes {
+		return Attributes{Action: "get", Resources: []AttributeResource{{Resource: "namespaces", Name: ns}, {Resource: "pods", Name: "p1"}}}
+	}
+	for i := 0; i < 2; i++ {
+		for _, user := range []string{"admin", "admin@example.com"} {
+			for _, ns := range []string{"default", "kube-system"} {
+				_, _, _ = authorizer.Authorize(ctx, UserInfo{Name: user}, attrs(ns))
+			}
+		}
+	}
+	if calls != 4 {
+		t.Fatalf("calls = %d, want 4", calls)
+	}
+	// the reason of cached denies is kept
+	if _, reason, _ := authorizer.Authorize(ctx, UserInfo{Name: "bob"}, attrs("default")); reason != DecisionDenyStatusNotFoundMessage {
+		t.Errorf("reason = %q", reason)
+	}
+	if _, reason, _ := authorizer.Authorize(ctx, UserInfo{Name: "bob"}, attrs("default")); reason != DecisionDenyStatusNotFoundMessage {
+		t.Errorf("cached reason = %q", reason)
+	}
+	if stats := authorizer.Stats(); stats.Hits != 2 || stats.DenyHits != 3 || stats.Misses != 5 {
+		t.Errorf("stats = %+v", stats)
+	}
+	if removed := authorizer.InvalidateUser("admin"); removed != 2 {
+		t.Errorf("InvalidateUser() = %d, want 2", removed)
+	}
+	if removed := authorizer.InvalidateResource("namespaces:kube-system"); removed != 1 {
+		t.Errorf("InvalidateResource() = %d, want 1", removed)
+	}
+	if removed := authorizer.InvalidateResource("namespaces:default"); removed != 2 {
+		t.Errorf("InvalidateResource() = %d, want 2", removed)
+	}
+}
+
+func TestLRUCacheAuthorizerGroups(t *testing.T) {
+	ctx := context.Background()
+	authorizer := NewCacheAuthorizer(AuthorizerChain{NewGroupAllowAuthorizer("admins"), NewAlwaysDenyAuthorizer()}, 10, time.Minute)
+	attrs := Attributes{Action: "get", Resources: []AttributeResource{{Resource: "zoos", Name: "z1"}}}
+	tests := []struct {
+		name   string
+		groups []string
+		want   Decision
+	}{
+		{name: "admin", groups: []string{"dev", "admins"}, want: DecisionAllow},
+		{name: "groups reordered", groups: []string{"admins", "dev"}, want: DecisionAllow},
+		{name: "removed from admins", groups: []string{"dev"}, want: DecisionDeny},
+		{name: "no groups", want: DecisionDeny},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, _, err := authorizer.Authorize(ctx, UserInfo{Name: "bob", Groups: tt.groups}, attrs); err != nil || got != tt.want {
+				t.Errorf("Authorize() = %v, %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+	if stats := authorizer.Stats(); stats.Hits != 1 {
+		t.Errorf("stats = %+v, want the reordered groups served from cache", stats)
+	}
+	if removed := authorizer.InvalidateUser("bob"); removed != 1 {
+		t.Errorf("InvalidateUser() = %d, want 1", removed)
+	}
+}
+
+func TestLRUCacheAuthorizerRefreshRevoked(t *testing.T) {
+	ctx := context.Background()
+	revoked := atomic.Bool{}
+	refreshed := make(chan struct{}, 1)
+	inner := AuthorizerFunc(func(ctx context.Context, user UserInfo, a Attributes) (Decision, string, error) {
+		if revoked.Load() {
+			defer func() { refreshed <- struct{}{} }()
+			return DecisionDeny, "revoked", nil
+		}
+		return DecisionAllow, "", nil
+	})
+	authorizer := &LRUCacheAuthorizer{
+		Authorizer: inner,
+		Cache:      NewLRUCacheWithOptions[AuthorizationResult](10, 200*time.Millisecond, LRUCacheOptions{RefreshAhead: 0.5}),
+	}
+	user, attrs := UserInfo{Name: "bob"}, Attributes{Action: "get", Resources: []AttributeResource{{Resource: "zoos", Name: "z1"}}}
+	if got, _, _ := authorizer.Authorize(ctx, user, attrs); got != DecisionAllow {
+		t.Fatalf("Authorize() = %v, want allow", got)
+	}
+	revoked.Store(true)
+	time.Sleep(120 * time.Millisecond)
+	// served from cache, the refresh in background finds the permission revoked
+	if got, _, _ := authorizer.Authorize(ctx, user, attrs); got != DecisionAllow {
+		t.Fatalf("Authorize() = %v, want cached allow", got)
+	}
+	select {
+	case <-refreshed:
+	case <-time.After(time.Second):
+		t.Fatal("not refreshed")
+	}
+	time.Sleep(10 * time.Millisecond)
+	if got, reason, _ := authorizer.Authorize(ctx, user, attrs); got != DecisionDeny || reason != "revoked" {
+		t.Errorf("Authorize() after revoke = %v %q, want deny", got, reason)
+	}
+}
+
+func TestHierarchicalAuthorizer(t *testing.T) {
+	// bob is granted zoos:z1 and the collection of animals in zoo z2, but denied animal a2 in zoo z1 and zoo z3
+	inner := AuthorizerFunc(func(ctx context.Context, user UserInfo, a Attributes) (Decision, string, error) {
+		switch _, expr := a.ToWildcards(); expr {
+		case "zoos:z1", "zoos:z2:animals:*":
+			return DecisionAllow, "", nil
+		case "zoos:z1:animals:a2", "zoos:z3":
+			return DecisionDeny, "", nil
+		}
+		return DecisionNoOpinion, "", nil
+	})
+	authorizer := NewHierarchicalAuthorizer(inner, "keepers")
+	tests := []struct {
+		name      string
+		resources []AttributeResource
+		want      Decision
+	}{
+		{name: "granted", resources: []AttributeResource{{Resource: "zoos", Name: "z1"}}, want: DecisionAllow},
+		{name: "inherited from zoo", resources: []AttributeResource{{Resource: "zoos", Name: "z1"}, {Resource: "animals", Name: "a1"}}, want: DecisionAllow},
+		{name: "inherited from collection", resources: []AttributeResource{{Resource: "zoos", Name: "z2"}, {Resource: "animals", Name: "a1"}}, want: DecisionAllow},
+		{name: "child denied under allowed parent", resources: []AttributeResource{{Resource: "zoos", Name: "z1"}, {Resource: "animals", Name: "a2"}}, want: DecisionDeny},
+		{name: "denied by parent", resources: []AttributeResource{{Resource: "zoos", Name: "z3"}, {Resource: "animals", Name: "a1"}}, want: DecisionDeny},
+		{name: "sibling not granted", resources: []AttributeResource{{Resource: "zoos", Name: "z4"}, {Resource: "animals", Name: "a1"}}, want: DecisionNoOpinion},
+		{name: "opted out", resources: []AttributeResource{{Resource: "zoos", Name: "z1"}, {Resource: "keepers", Name: "k1"}}, want: DecisionNoOpinion},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, err := authorizer.Authorize(context.Background(), UserInfo{Name: "bob"}, Attributes{Action: "get", Resources: tt.resources})
+			if err != nil || got != tt.want {
+				t.Errorf("Authorize() = %v, %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthorizationFilterAudit(t *testing.T) {
+	authorizer := AuthorizerFunc(func(ctx context.Context, user UserInfo, a Attributes) (Decision, string, error) {
+		SetAuthorizationPolicy(ctx, "admins-only")
+		if user.Name == "admin" {
+			return DecisionAllow, "is admin", nil
+		}
+		return DecisionDeny, "not admin", nil
+	})
+	tests := []struct {
+		name        string
+		user        string
+		auditBefore bool
+		want        AuthorizationResult
+	}{
+		{name: "allowed", user: "admin", want: AuthorizationResult{Decision: "allow", Reason: "is admin", Policy: "admins-only"}},
+		{name: "denied", user: "bob", auditBefore: true, want: AuthorizationResult{Decision: "deny", Reason: "not admin", Policy: "admins-only"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := &recordingAuditSink{}
+			audit := NewAuditFilter(NewSimpleAuditor(), sink)
+			filters := Filters{
+				NewAuthenticateFilter(func(w http.ResponseWriter, r *http.Request) (*AuthenticateInfo, error) {
+					return &AuthenticateInfo{User: UserInfo{Name: tt.user}}, nil
+				}, nil),
+				NewAttributeFilter(PrefixedAttributesExtractor("")),
+				NewAuthorizationFilter(authorizer),
+			}
+			if tt.auditBefore {
+				filters = append(Filters{audit}, filters...)
+			} else {
+				filters = append(filters, audit)
+			}
+			req := httptest.NewRequest(http.MethodGet, "/zoos/1", nil)
+			filters.Process(httptest.NewRecorder(), req, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			if sink.log == nil || sink.log.Authorization == nil {
+				t.Fatalf("authorization not recorded")
+			}
+			if got := *sink.log.Authorization; got != tt.want {
+				t.Errorf("Authorization = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthorizerChainStrategies(t *testing.T) {
+	decide := func(d Decision) Authorizer {
+		return AuthorizerFunc(func(ctx context.Context, user UserInfo, a Attributes) (Decision, string, error) {
+			return d, d.String(), nil
+		})
+	}
+	allow, deny, noOpinion := decide(DecisionAllow), decide(DecisionDeny), decide(DecisionNoOpinion)
+	tests := []struct {
+		strategy    ChainStrategy
+		authorizers []Authorizer
+		want        Decision
+	}{
+		{strategy: "", authorizers: []Authorizer{noOpinion, deny, allow}, want: DecisionDeny},
+		{strategy: ChainFirstApplicable, authorizers: []Authorizer{noOpinion, allow, deny}, want: DecisionAllow},
+		{strategy: ChainFirstAllowWins, authorizers: []Authorizer{deny, noOpinion, allow}, want: DecisionAllow},
+		{strategy: ChainFirstAllowWins, authorizers: []Authorizer{noOpinion, deny}, want: DecisionDeny},
+		{strategy: ChainUnanimousAllow, authorizers: []Authorizer{allow, allow}, want: DecisionAllow},
+		{strategy: ChainUnanimousAllow, authorizers: []Authorizer{allow, noOpinion}, want: DecisionDeny},
+		{strategy: ChainDenyOverrides, authorizers: []Authorizer{allow, noOpinion, deny}, want: DecisionDeny},
+		{strategy: ChainDenyOverrides, authorizers: []Authorizer{noOpinion, allow}, want: DecisionAllow},
+		{strategy: ChainDenyOverrides, authorizers: []Authorizer{noOpinion}, want: DecisionDeny},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.strategy), func(t *testing.T) {
+			chain, err := NewAuthorizerChain(tt.strategy, tt.authorizers...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, _, err := chain.Authorize(context.Background(), UserInfo{Name: "bob"}, Attributes{})
+			if err != nil || got != tt.want {
+				t.Errorf("Authorize() = %v, %v, want %v", got, err, tt.want)
+			}
+			explained := ExplainAuthorization(context.Background(), chain, UserInfo{Name: "bob"}, Attributes{})
+			if explained.Decision != tt.want.String() {
+				t.Errorf("ExplainAuthorization() = %s, want %s", explained.Decision, tt.want)
+			}
+		})
+	}
+	if _, err := NewAuthorizerChain("unknown"); err == nil {
+		t.Errorf("NewAuthorizerChain() expected error on unknown strategy")
+	}
+}
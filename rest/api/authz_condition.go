@@ -0,0 +1,165 @@
+// Copyright 2023 The Kubegems Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+// ReadOnlyActions are the actions allowed by NewReadOnlyCondition.
+var ReadOnlyActions = []string{"get", "list", "watch"}
+
+// Condition checks a request with the current time, returns false and the reason if not satisfied.
+type Condition func(ctx context.Context, user UserInfo, a Attributes, now time.Time) (bool, string)
+
+// TimeWindow is a daily period from Start to End, e.g. "09:00" to "18:00".
+// End before Start wraps across midnight, e.g. "22:00" to "06:00".
+type TimeWindow struct {
+	Start    time.Duration  // offset from midnight
+	End      time.Duration  // offset from midnight
+	Weekdays []time.Weekday // empty means every day, the weekday of Start for windows across midnight
+	Location *time.Location // default time.Local
+}
+
+// ParseTimeWindow parses "15:04-15:04", e.g. "09:00-18:00".
+func ParseTimeWindow(s string, loc *time.Location, weekdays ...time.Weekday) (TimeWindow, error) {
+	start, end, ok := strings.Cut(s, "-")
+	if !ok {
+		return TimeWindow{}, fmt.Errorf("invalid time window %q, expected 15:04-15:04", s)
+	}
+	startoffset, err := parseClock(start)
+	if err != nil {
+		return TimeWindow{}, fmt.Errorf("invalid time window %q: %w", s, err)
+	}
+	endoffset, err := parseClock(end)
+	if err != nil {
+		return TimeWindow{}, fmt.Errorf("invalid time window %q: %w", s, err)
+	}
+	return TimeWindow{Start: startoffset, End: endoffset, Weekdays: weekdays, Location: loc}, nil
+}
+
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Contains reports whether t is in the window.
+func (w TimeWindow) Contains(t time.Time) bool {
+	loc := w.Location
+	if loc == nil {
+		loc = time.Local
+	}
+	t = t.In(loc)
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+	offset := t.Sub(midnight)
+	switch {
+	case w.Start <= w.End:
+		return offset >= w.Start && offset < w.End && w.onWeekday(t.Weekday())
+	case offset >= w.Start:
+		return w.onWeekday(t.Weekday())
+	case offset < w.End:
+		// the early part of a window started yesterday
+		return w.onWeekday((t.Weekday() + 6) % 7)
+	default:
+		return false
+	}
+}
+
+func (w TimeWindow) onWeekday(day time.Weekday) bool {
+	return len(w.Weekdays) == 0 || slices.Contains(w.Weekdays, day)
+}
+
+func inTimeWindows(windows []TimeWindow, t time.Time) bool {
+	for _, w := range windows {
+		if w.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// NewTimeWindowCondition is satisfied in any of windows, e.g. working hours.
+func NewTimeWindowCondition(windows ...TimeWindow) Condition {
+	return func(ctx context.Context, user UserInfo, a Attributes, now time.Time) (bool, string) {
+		if inTimeWindows(windows, now) {
+			return true, ""
+		}
+		return false, "outside of allowed time windows"
+	}
+}
+
+// NewSourceCIDRCondition is satisfied if the client ip is in any of cidrs, see ClientIPFromContext.
+func NewSourceCIDRCondition(cidrs ...string) Condition {
+	return func(ctx context.Context, user UserInfo, a Attributes, now time.Time) (bool, string) {
+		ip := ClientIPFromContext(ctx)
+		if ip != "" && InCIDR(ip, cidrs) {
+			return true, ""
+		}
+		return false, fmt.Sprintf("source ip %q not allowed", ip)
+	}
+}
+
+// NewReadOnlyCondition allows ReadOnlyActions only in any of windows, e.g. a change freeze or maintenance period.
+// It is always satisfied out of the windows.
+func NewReadOnlyCondition(windows ...TimeWindow) Condition {
+	return func(ctx context.Context, user UserInfo, a Attributes, now time.Time) (bool, string) {
+		if slices.Contains(ReadOnlyActions, a.Action) || !inTimeWindows(windows, now) {
+			return true, ""
+		}
+		return false, fmt.Sprintf("action %q is not allowed in read-only period", a.Action)
+	}
+}
+
+// NewConditionalAuthorizer returns an authorizer denying requests unless all conditions are satisfied.
+func NewConditionalAuthorizer(authorizer Authorizer, conditions ...Condition) *ConditionalAuthorizer {
+	return &ConditionalAuthorizer{Authorizer: authorizer, Conditions: conditions}
+}
+
+// ConditionalAuthorizer denies a request if any condition is not satisfied,
+// otherwise the decision is made by Authorizer, or no opinion if Authorizer is nil,
+// e.g. place NewConditionalAuthorizer(nil, NewReadOnlyCondition(freeze)) first in an AuthorizerChain to enforce a change freeze.
+// Its decisions are not cached by LRUCacheAuthorizer as conditions change between requests, see DisableAuthorizationCache.
+type ConditionalAuthorizer struct {
+	Authorizer Authorizer
+	Conditions []Condition
+	Clock      func() time.Time // default time.Now
+}
+
+func (c *ConditionalAuthorizer) Authorize(ctx context.Context, user UserInfo, a Attributes) (Decision, string, error) {
+	if len(c.Conditions) > 0 {
+		DisableAuthorizationCache(ctx)
+	}
+	now := time.Now()
+	if c.Clock != nil {
+		now = c.Clock()
+	}
+	for _, condition := range c.Conditions {
+		if ok, reason := condition(ctx, user, a, now); !ok {
+			return DecisionDeny, reason, nil
+		}
+	}
+	if c.Authorizer == nil {
+		return DecisionNoOpinion, "", nil
+	}
+	return c.Authorizer.Authorize(ctx, user, a)
+}
@@ -0,0 +1,91 @@
+package api
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTimeWindowContains(t *testing.T) {
+	workhours, _ := ParseTimeWindow("09:00-18:00", time.UTC, time.Monday, time.Friday)
+	overnight, _ := ParseTimeWindow("22:00-06:00", time.UTC, time.Friday)
+	tests := []struct {
+		name   string
+		window TimeWindow
+		at     string
+		want   bool
+	}{
+		{name: "in work hours", window: workhours, at: "2023-06-05T10:00:00Z", want: true}, // monday
+		{name: "after work hours", window: workhours, at: "2023-06-05T18:00:00Z", want: false},
+		{name: "not a work day", window: workhours, at: "2023-06-06T10:00:00Z", want: false},
+		{name: "overnight start", window: overnight, at: "2023-06-09T23:00:00Z", want: true}, // friday
+		{name: "overnight next day", window: overnight, at: "2023-06-10T05:59:00Z", want: true},
+		{name: "overnight wrong day", window: overnight, at: "2023-06-09T05:00:00Z", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			at, _ := time.Parse(time.RFC3339, tt.at)
+			if got := tt.window.Contains(at); got != tt.want {
+				t.Errorf("Contains(%s) = %v, want %v", tt.at, got, tt.want)
+			}
+		})
+	}
+	if _, err := ParseTimeWindow("9am", time.UTC); err == nil {
+		t.Errorf("ParseTimeWindow() expected error")
+	}
+}
+
+func TestConditionalAuthorizer(t *testing.T) {
+	freeze, _ := ParseTimeWindow("00:00-12:00", time.UTC)
+	authorizer := NewConditionalAuthorizer(NewAlwaysAllowAuthorizer(),
+		NewSourceCIDRCondition("10.0.0.0/8"),
+		NewReadOnlyCondition(freeze),
+	)
+	tests := []struct {
+		name   string
+		ip     string
+		action string
+		at     string
+		want   Decision
+	}{
+		{name: "read in freeze", ip: "10.0.0.1", action: "get", at: "2023-06-05T10:00:00Z", want: DecisionAllow},
+		{name: "write in freeze", ip: "10.0.0.1", action: "update", at: "2023-06-05T10:00:00Z", want: DecisionDeny},
+		{name: "write out of freeze", ip: "10.0.0.1", action: "update", at: "2023-06-05T13:00:00Z", want: DecisionAllow},
+		{name: "untrusted source", ip: "192.168.0.1", action: "get", at: "2023-06-05T13:00:00Z", want: DecisionDeny},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			at, _ := time.Parse(time.RFC3339, tt.at)
+			authorizer.Clock = func() time.Time { return at }
+			ctx := WithClientIP(context.Background(), tt.ip)
+			got, reason, err := authorizer.Authorize(ctx, UserInfo{Name: "bob"}, Attributes{Action: tt.action})
+			if err != nil || got != tt.want {
+				t.Errorf("Authorize() = %v, %q, %v, want %v", got, reason, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestConditionalAuthorizerNotCached(t *testing.T) {
+	authorizer := NewCacheAuthorizer(NewConditionalAuthorizer(NewAlwaysAllowAuthorizer(), NewSourceCIDRCondition("10.0.0.0/8")), 10, time.Minute).
+		WithDenyCache(10, time.Minute)
+	tests := []struct {
+		ip   string
+		want Decision
+	}{
+		{ip: "10.0.0.1", want: DecisionAllow},
+		{ip: "192.168.0.1", want: DecisionDeny},
+		{ip: "10.0.0.1", want: DecisionAllow},
+		{ip: "192.168.0.1", want: DecisionDeny},
+	}
+	for i, tt := range tests {
+		ctx := WithClientIP(context.Background(), tt.ip)
+		got, reason, err := authorizer.Authorize(ctx, UserInfo{Name: "bob"}, Attributes{Action: "get", Resources: []AttributeResource{{Resource: "zoos"}}})
+		if err != nil || got != tt.want {
+			t.Errorf("%d: Authorize(%s) = %v, %q, %v, want %v", i, tt.ip, got, reason, err, tt.want)
+		}
+	}
+	if stats := authorizer.Stats(); stats.Hits != 0 || stats.DenyHits != 0 || stats.Misses != int64(len(tests)) {
+		t.Errorf("Stats() = %+v, want only misses", stats)
+	}
+}
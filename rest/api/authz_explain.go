@@ -0,0 +1,174 @@
+// Copyright 2023 The Kubegems Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"kubegems.io/library/rest/request"
+	"kubegems.io/library/rest/response"
+)
+
+// NamedAuthorizer names an authorizer in the explanations, see ExplainAuthorization.
+type NamedAuthorizer struct {
+	Name string
+	Authorizer
+}
+
+// AuthorizerName returns the name of a NamedAuthorizer, the function name of an AuthorizerFunc, or the type name.
+func AuthorizerName(authorizer Authorizer) string {
+	switch a := authorizer.(type) {
+	case NamedAuthorizer:
+		return a.Name
+	case AuthorizerFunc:
+		if name := funcName(a); name != "" {
+			return name
+		}
+		return "AuthorizerFunc"
+	default:
+		return fmt.Sprintf("%T", authorizer)
+	}
+}
+
+type AuthorizerDecision struct {
+	Authorizer string `json:"authorizer"`
+	Decision   string `json:"decision"`
+	Reason     string `json:"reason,omitempty"`
+	Error      string `json:"error,omitempty"`
+	Effective  bool   `json:"effective,omitempty"` // made the final decision
+}
+
+type AuthorizationExplanation struct {
+	User       UserInfo             `json:"user"`
+	Attributes Attributes           `json:"attributes"`
+	Decision   string               `json:"decision"`
+	Reason     string               `json:"reason,omitempty"`
+	Decisions  []AuthorizerDecision `json:"decisions"`
+}
+
+// ExplainAuthorization evaluates every authorizer in an AuthorizerChain or StrategyAuthorizerChain,
+// not only the ones before the decision is made, the final decision is the same as the chain's.
+func ExplainAuthorization(ctx context.Context, authorizer Authorizer, user UserInfo, a Attributes) AuthorizationExplanation {
+	strategy, authorizers := ChainFirstApplicable, []Authorizer{authorizer}
+	switch chain := authorizer.(type) {
+	case AuthorizerChain:
+		authorizers = chain
+	case *StrategyAuthorizerChain:
+		strategy, authorizers = chain.Strategy, chain.Authorizers
+	}
+	explanation := AuthorizationExplanation{User: user, Attributes: a, Decisions: []AuthorizerDecision{}}
+	results := make([]authorizerResult, 0, len(authorizers))
+	for _, authorizer := range authorizers {
+		decision, reason, err := authorizer.Authorize(ctx, user, a)
+		results = append(results, authorizerResult{decision: decision, reason: reason, err: err})
+		item := AuthorizerDecision{Authorizer: AuthorizerName(authorizer), Decision: decision.String(), Reason: reason}
+		if err != nil {
+			item.Decision, item.Error = DecisionDeny.String(), err.Error()
+		}
+		explanation.Decisions = append(explanation.Decisions, item)
+	}
+	if effective, _ := strategy.resolve(results); effective >= 0 {
+		explanation.Decisions[effective].Effective = true
+	}
+	decision, reason, _ := strategy.result(results)
+	explanation.Decision, explanation.Reason = decision.String(), reason
+	return explanation
+}
+
+var _ Plugin = &AuthorizationExplainPlugin{}
+
+// AuthorizationExplainPlugin exposes an admin endpoint explaining why a request is allowed or denied,
+// e.g. GET /admin/authorization/explain?user=bob&method=DELETE&path=/zoos/z1
+//
+// The attributes are extracted from the described request by Attributes, which should be the extractor
+// of the authorization filter, so the explanation matches the real decision.
+type AuthorizationExplainPlugin struct {
+	NoopPlugin
+	Prefix     string // default "/admin/authorization"
+	Authorizer Authorizer
+	Attributes AttributesExtractor // default PrefixedAttributesExtractor(""), the same as StandardFilterOptions
+	Filters    Filters             // filters of the admin endpoint, e.g. authentication and authorization
+}
+
+// Install refuses to expose the endpoint without Filters, it reveals the decisions of any user and the policy.
+func (p *AuthorizationExplainPlugin) Install(m *API) error {
+	if p.Prefix == "" {
+		p.Prefix = "/admin/authorization"
+	}
+	if p.Authorizer == nil {
+		return fmt.Errorf("authorization explain plugin %s: authorizer required", p.Prefix)
+	}
+	if len(p.Filters) == 0 {
+		return fmt.Errorf("authorization explain plugin %s: %w", p.Prefix, ErrAdminFiltersRequired)
+	}
+	if p.Attributes == nil {
+		p.Attributes = PrefixedAttributesExtractor("")
+	}
+	m.Group(NewGroup(p.Prefix).Tag("authorization").Filter(p.Filters...).Route(
+		GET("/explain").Doc("explain the authorization decision of a request").
+			Param(QueryParam("user", "user name")).
+			Param(QueryParam("groups", "comma separated groups of the user").Optional()).
+			Param(QueryParam("path", "request path, e.g. /zoos/z1/animals")).
+			Param(QueryParam("method", "request method").Optional().Def(http.MethodGet)).
+			Param(QueryParam("headers", "comma separated request headers, e.g. X-Tenant:acme").Optional()).
+			Param(QueryParam("action", "action overrides the one derived from the request, e.g. get, feed").Optional()).
+			Response(AuthorizationExplanation{}).
+			To(func(w http.ResponseWriter, r *http.Request) {
+				user := UserInfo{
+					Name:   request.Query(r, "user", ""),
+					Groups: request.Query(r, "groups", []string{}),
+				}
+				path := request.Query(r, "path", "")
+				if user.Name == "" || path == "" {
+					response.BadRequest(w, "user and path are required")
+					return
+				}
+				method := strings.ToUpper(request.Query(r, "method", http.MethodGet))
+				req, err := http.NewRequestWithContext(r.Context(), method, path, nil)
+				if err != nil || !strings.HasPrefix(req.URL.Path, "/") {
+					response.BadRequest(w, fmt.Sprintf("invalid request %s %s", method, path))
+					return
+				}
+				for _, header := range request.Query(r, "headers", []string{}) {
+					key, value, ok := strings.Cut(header, ":")
+					if !ok {
+						response.BadRequest(w, fmt.Sprintf("invalid header %s", header))
+						return
+					}
+					req.Header.Add(strings.TrimSpace(key), strings.TrimSpace(value))
+				}
+				attributes, err := p.Attributes.ExtractAttributes(req)
+				if err != nil {
+					response.BadRequest(w, err.Error())
+					return
+				}
+				if attributes == nil {
+					// denied the same way by NewAuthorizationFilter
+					response.OK(w, AuthorizationExplanation{
+						User: user, Decision: DecisionDeny.String(), Reason: "no attributes", Decisions: []AuthorizerDecision{},
+					})
+					return
+				}
+				if action := request.Query(r, "action", ""); action != "" {
+					attributes.Action = action
+				}
+				response.OK(w, ExplainAuthorization(r.Context(), p.Authorizer, user, *attributes))
+			}),
+	))
+	return nil
+}
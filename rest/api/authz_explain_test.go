@@ -0,0 +1,140 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestExplainAuthorization(t *testing.T) {
+	chain := AuthorizerChain{
+		NewGroupDenyAuthorizer("blocked"),
+		NamedAuthorizer{Name: "admins", Authorizer: NewGroupAllowAuthorizer("admins")},
+		NewAlwaysDenyAuthorizer(),
+	}
+	tests := []struct {
+		name          string
+		groups        []string
+		wantDecision  string
+		wantEffective string
+	}{
+		{name: "allowed by second", groups: []string{"admins"}, wantDecision: "allow", wantEffective: "admins"},
+		{name: "denied by first", groups: []string{"blocked", "admins"}, wantDecision: "deny", wantEffective: "api.NewGroupDenyAuthorizer"},
+		{name: "denied by last", groups: []string{"dev"}, wantDecision: "deny", wantEffective: "api.NewAlwaysDenyAuthorizer"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ExplainAuthorization(context.Background(), chain, UserInfo{Name: "bob", Groups: tt.groups}, ParseResourcePath("GET", "/zoos/z1"))
+			if got.Decision != tt.wantDecision {
+				t.Errorf("Decision = %s, want %s", got.Decision, tt.wantDecision)
+			}
+			if len(got.Decisions) != len(chain) {
+				t.Fatalf("Decisions = %d, want every authorizer evaluated", len(got.Decisions))
+			}
+			effective := []string{}
+			for _, d := range got.Decisions {
+				if d.Effective {
+					effective = append(effective, d.Authorizer)
+				}
+			}
+			if len(effective) != 1 || effective[0] != tt.wantEffective {
+				t.Errorf("effective = %v, want %s", effective, tt.wantEffective)
+			}
+		})
+	}
+}
+
+func TestAuthorizationExplainPluginRequiresFilters(t *testing.T) {
+	plugin := &AuthorizationExplainPlugin{Authorizer: NewAlwaysAllowAuthorizer()}
+	if err := plugin.Install(NewAPI()); !errors.Is(err, ErrAdminFiltersRequired) {
+		t.Errorf("Install() without filters error = %v, want %v", err, ErrAdminFiltersRequired)
+	}
+}
+
+func TestAuthorizationExplainPluginAttributes(t *testing.T) {
+	// allows tenant acme to get the zoos only
+	authorizer := AuthorizerFunc(func(ctx context.Context, user UserInfo, a Attributes) (Decision, string, error) {
+		if len(a.Resources) == 2 && a.Resources[0] == (AttributeResource{Resource: "domains", Name: "acme"}) &&
+			a.Resources[1].Resource == "zoos" && a.Action == "get" {
+			return DecisionAllow, "acme zoos", nil
+		}
+		return DecisionDeny, "", nil
+	})
+	extractor := NewPathAttributesExtractor("/v1")
+	extractor.DomainHeader = "X-Tenant"
+	allow := FilterFunc(func(w http.ResponseWriter, r *http.Request, next http.Handler) { next.ServeHTTP(w, r) })
+	handler := NewAPI().Plugin(&AuthorizationExplainPlugin{
+		Authorizer: authorizer, Attributes: extractor, Filters: Filters{allow},
+	}).Build()
+
+	tests := []struct {
+		name         string
+		query        url.Values
+		wantCode     int
+		wantDecision string
+		wantReason   string
+	}{
+		{
+			name:         "extracted with prefix and domain header",
+			query:        url.Values{"user": {"bob"}, "path": {"/v1/zoos/z1"}, "headers": {"X-Tenant:acme"}},
+			wantCode:     http.StatusOK,
+			wantDecision: "allow",
+			wantReason:   "acme zoos",
+		},
+		{
+			name:         "other tenant",
+			query:        url.Values{"user": {"bob"}, "path": {"/v1/zoos/z1"}, "headers": {"X-Tenant:other"}},
+			wantCode:     http.StatusOK,
+			wantDecision: "deny",
+		},
+		{
+			name:         "action override",
+			query:        url.Values{"user": {"bob"}, "path": {"/v1/zoos/z1"}, "headers": {"X-Tenant:acme"}, "action": {"feed"}},
+			wantCode:     http.StatusOK,
+			wantDecision: "deny",
+		},
+		{
+			name:         "not a resource request",
+			query:        url.Values{"user": {"bob"}, "path": {"/zoos/z1"}},
+			wantCode:     http.StatusOK,
+			wantDecision: "deny",
+			wantReason:   "no attributes",
+		},
+		{
+			name:     "invalid header",
+			query:    url.Values{"user": {"bob"}, "path": {"/v1/zoos/z1"}, "headers": {"X-Tenant"}},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "missing user",
+			query:    url.Values{"path": {"/v1/zoos/z1"}},
+			wantCode: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/authorization/explain?"+tt.query.Encode(), nil))
+			if rec.Code != tt.wantCode {
+				t.Fatalf("code = %d, want %d: %s", rec.Code, tt.wantCode, rec.Body.String())
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+			body := struct {
+				Data AuthorizationExplanation `json:"data"`
+			}{}
+			if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			got := body.Data
+			if got.Decision != tt.wantDecision || got.Reason != tt.wantReason {
+				t.Errorf("decision = %s %q, want %s %q", got.Decision, got.Reason, tt.wantDecision, tt.wantReason)
+			}
+		})
+	}
+}
@@ -0,0 +1,235 @@
+// Copyright 2023 The Kubegems Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/go-logr/logr"
+	"golang.org/x/exp/slices"
+	"kubegems.io/library/rest/wildcard"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	PolicyEffectAllow = "allow"
+	PolicyEffectDeny  = "deny"
+)
+
+// PolicyRule matches a request if all the non-empty fields match, "*" matches any.
+type PolicyRule struct {
+	Name      string   `json:"name,omitempty"`      // recorded as the authorization policy in audit logs, default "rule {index}"
+	Effect    string   `json:"effect"`              // allow or deny
+	Users     []string `json:"users,omitempty"`     // user names
+	Groups    []string `json:"groups,omitempty"`    // any group of the user
+	Actions   []string `json:"actions,omitempty"`   // e.g. get, list
+	Resources []string `json:"resources,omitempty"` // wildcard expressions, e.g. "namespaces:default:**", see Attributes.ToWildcards
+}
+
+type Policy struct {
+	Rules []PolicyRule `json:"rules"`
+}
+
+// Authorize denies if any deny rule matches, allows if any allow rule matches, or has no opinion.
+func (p *Policy) Authorize(user UserInfo, a Attributes) (Decision, string) {
+	decision, matched := p.authorize(user, a)
+	return decision, p.reason(decision, matched)
+}
+
+// authorize returns the decision and the index of the rule made it, -1 if no rule matched.
+func (p *Policy) authorize(user UserInfo, a Attributes) (Decision, int) {
+	action, expr := a.ToWildcards()
+	allowed := -1
+	for i, rule := range p.Rules {
+		if !rule.matches(user, action, expr) {
+			continue
+		}
+		if rule.Effect == PolicyEffectDeny {
+			return DecisionDeny, i
+		}
+		if allowed < 0 {
+			allowed = i
+		}
+	}
+	if allowed >= 0 {
+		return DecisionAllow, allowed
+	}
+	return DecisionNoOpinion, -1
+}
+
+func (p *Policy) reason(decision Decision, matched int) string {
+	switch decision {
+	case DecisionDeny:
+		return "denied by policy " + p.ruleName(matched)
+	case DecisionAllow:
+		return "allowed by policy " + p.ruleName(matched)
+	default:
+		return ""
+	}
+}
+
+func (p *Policy) ruleName(i int) string {
+	if name := p.Rules[i].Name; name != "" {
+		return name
+	}
+	return fmt.Sprintf("rule %d", i)
+}
+
+func (r PolicyRule) matches(user UserInfo, action, expr string) bool {
+	if len(r.Users) > 0 && !matchAny(r.Users, user.Name) {
+		return false
+	}
+	if len(r.Groups) > 0 && !slices.ContainsFunc(user.Groups, func(group string) bool { return matchAny(r.Groups, group) }) {
+		return false
+	}
+	if len(r.Actions) > 0 && !matchAny(r.Actions, action) {
+		return false
+	}
+	if len(r.Resources) > 0 && !slices.ContainsFunc(r.Resources, func(pattern string) bool {
+		return wildcard.WildcardMatchSections(pattern, expr)
+	}) {
+		return false
+	}
+	return true
+}
+
+func matchAny(candidates []string, val string) bool {
+	return slices.Contains(candidates, "*") || slices.Contains(candidates, val)
+}
+
+// LoadPolicyFile reads a yaml or json policy file.
+func LoadPolicyFile(file string) (*Policy, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	policy := &Policy{}
+	if err := yaml.UnmarshalStrict(data, policy); err != nil {
+		return nil, fmt.Errorf("parse policy %s: %w", file, err)
+	}
+	for i, rule := range policy.Rules {
+		if rule.Effect != PolicyEffectAllow && rule.Effect != PolicyEffectDeny {
+			return nil, fmt.Errorf("policy %s rule %d: invalid effect %q", file, i, rule.Effect)
+		}
+	}
+	return policy, nil
+}
+
+var _ Authorizer = &FileBackedAuthorizer{}
+
+// FileBackedAuthorizer authorizes by the rules in a policy file, the file is reloaded by Watch on change,
+// an invalid file is logged and the previous rules are kept.
+type FileBackedAuthorizer struct {
+	File string
+
+	mu     sync.RWMutex
+	policy *Policy
+	caches []*LRUCacheAuthorizer // purged on reload
+}
+
+func NewFileBackedAuthorizer(file string) (*FileBackedAuthorizer, error) {
+	a := &FileBackedAuthorizer{File: file}
+	if err := a.Reload(context.Background()); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Cached returns the authorizer with an LRU cache, the cache is purged on reload.
+func (a *FileBackedAuthorizer) Cached(size int, ttl time.Duration) *LRUCacheAuthorizer {
+	cached := &LRUCacheAuthorizer{
+		Authorizer: a,
+		Cache:      NewLRUCacheWithOptions[AuthorizationResult](size, ttl, NewDefaultLRUCacheOptions()),
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.caches = append(a.caches, cached)
+	return cached
+}
+
+func (a *FileBackedAuthorizer) Authorize(ctx context.Context, user UserInfo, attrs Attributes) (Decision, string, error) {
+	a.mu.RLock()
+	policy := a.policy
+	a.mu.RUnlock()
+	decision, matched := policy.authorize(user, attrs)
+	if matched >= 0 {
+		SetAuthorizationPolicy(ctx, a.File+"#"+policy.ruleName(matched))
+	}
+	return decision, policy.reason(decision, matched), nil
+}
+
+func (a *FileBackedAuthorizer) Reload(ctx context.Context) error {
+	apply, err := a.PrepareReload(ctx)
+	if err != nil {
+		return err
+	}
+	apply()
+	return nil
+}
+
+// PrepareReload loads the policy file, it implements config.Reloadable.
+func (a *FileBackedAuthorizer) PrepareReload(ctx context.Context) (func(), error) {
+	policy, err := LoadPolicyFile(a.File)
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		a.mu.Lock()
+		a.policy = policy
+		caches := a.caches
+		a.mu.Unlock()
+		for _, cache := range caches {
+			cache.Purge()
+		}
+		logr.FromContextOrDiscard(ctx).Info("policy reloaded", "file", a.File, "rules", len(policy.Rules))
+	}, nil
+}
+
+// Watch reloads the policy file on change until ctx done.
+func (a *FileBackedAuthorizer) Watch(ctx context.Context) error {
+	log := logr.FromContextOrDiscard(ctx)
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("error creating fsnotify watcher: %v", err)
+	}
+	defer w.Close()
+	if err := w.Add(a.File); err != nil {
+		return fmt.Errorf("error adding watch for file %s: %v", a.File, err)
+	}
+	for {
+		select {
+		case e := <-w.Events:
+			// files replaced by rename, e.g. by editors or configmap updates, must be watched again
+			if e.Has(fsnotify.Remove) || e.Has(fsnotify.Rename) {
+				_ = w.Remove(e.Name)
+				if err := w.Add(e.Name); err != nil {
+					return fmt.Errorf("error adding watch for file %s: %v", e.Name, err)
+				}
+			}
+			if err := a.Reload(ctx); err != nil {
+				log.Error(err, "failed to reload policy, the previous one is kept", "file", a.File)
+			}
+		case err := <-w.Errors:
+			return fmt.Errorf("received fsnotify error: %v", err)
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package api
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileBackedAuthorizer(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "policy.yaml")
+	write := func(content string) {
+		if err := os.WriteFile(file, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`
+rules:
+- effect: deny
+  resources: ["namespaces:kube-system:**"]
+- effect: allow
+  groups: ["admins"]
+- effect: allow
+  users: ["bob"]
+  actions: ["get", "list"]
+  resources: ["namespaces:*:deployments:*"]
+`)
+	authorizer, err := NewFileBackedAuthorizer(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached := authorizer.Cached(10, 0)
+	ctx := context.Background()
+	deployment := func(ns, action string) Attributes {
+		return Attributes{Action: action, Resources: []AttributeResource{{Resource: "namespaces", Name: ns}, {Resource: "deployments", Name: "nginx"}}}
+	}
+	tests := []struct {
+		name  string
+		user  UserInfo
+		attrs Attributes
+		want  Decision
+	}{
+		{name: "allowed by user rule", user: UserInfo{Name: "bob"}, attrs: deployment("default", "get"), want: DecisionAllow},
+		{name: "action not allowed", user: UserInfo{Name: "bob"}, attrs: deployment("default", "delete"), want: DecisionNoOpinion},
+		{name: "allowed by group", user: UserInfo{Name: "alice", Groups: []string{"admins"}}, attrs: deployment("default", "delete"), want: DecisionAllow},
+		{name: "deny wins", user: UserInfo{Name: "alice", Groups: []string{"admins"}}, attrs: deployment("kube-system", "get"), want: DecisionDeny},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, _, _ := cached.Authorize(ctx, tt.user, tt.attrs); got != tt.want {
+				t.Errorf("Authorize() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	write("rules: [{effect: allow, users: [carol]}]")
+	if err := authorizer.Reload(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got, _, _ := cached.Authorize(ctx, UserInfo{Name: "bob"}, deployment("default", "get")); got != DecisionNoOpinion {
+		t.Errorf("cached decision not purged on reload, got %v", got)
+	}
+	write("rules: [{effect: maybe}]")
+	if err := authorizer.Reload(ctx); err == nil {
+		t.Errorf("invalid policy accepted")
+	}
+	if got, _, _ := cached.Authorize(ctx, UserInfo{Name: "carol"}, deployment("default", "get")); got != DecisionAllow {
+		t.Errorf("previous policy not kept, got %v", got)
+	}
+}
@@ -0,0 +1,209 @@
+// Copyright 2023 The Kubegems Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type WebhookAuthorizerOptions struct {
+	URL            string        `json:"url" description:"url of the webhook receiving SubjectAccessReview"`
+	BearerToken    string        `json:"bearerToken,omitempty" description:"token sent in the Authorization header"`
+	Timeout        time.Duration `json:"timeout,omitempty" description:"timeout of each request"`
+	MaxRetries     int           `json:"maxRetries,omitempty" description:"retries on network errors, 429 and 5xx"`
+	InitialBackoff time.Duration `json:"initialBackoff,omitempty"`
+	MaxBackoff     time.Duration `json:"maxBackoff,omitempty"`
+
+	// HTTPClient sends the reviews, e.g. from egress.Factory, default uses Timeout.
+	HTTPClient *http.Client `json:"-"`
+}
+
+func NewDefaultWebhookAuthorizerOptions() *WebhookAuthorizerOptions {
+	return &WebhookAuthorizerOptions{
+		Timeout:        5 * time.Second,
+		MaxRetries:     3,
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     2 * time.Second,
+	}
+}
+
+var _ Authorizer = &WebhookAuthorizer{}
+
+// WebhookAuthorizer delegates authorization to a webhook accepting kubernetes SubjectAccessReview,
+// so the decisions can be centralized. Wrap it with NewCacheAuthorizer to avoid a review per request.
+//
+// Attributes in kubernetes shape, see KubernetesAttributesExtractor, are sent as resourceAttributes,
+// attributes without resources as nonResourceAttributes, the original attributes are sent in spec.attributes too.
+type WebhookAuthorizer struct {
+	Options *WebhookAuthorizerOptions
+	client  *http.Client
+}
+
+func NewWebhookAuthorizer(opts *WebhookAuthorizerOptions) (*WebhookAuthorizer, error) {
+	if _, err := url.ParseRequestURI(opts.URL); err != nil {
+		return nil, fmt.Errorf("invalid webhook url %q: %w", opts.URL, err)
+	}
+	client := opts.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: opts.Timeout}
+	}
+	return &WebhookAuthorizer{Options: opts, client: client}, nil
+}
+
+type SubjectAccessReview struct {
+	APIVersion string                    `json:"apiVersion"`
+	Kind       string                    `json:"kind"`
+	Spec       SubjectAccessReviewSpec   `json:"spec"`
+	Status     SubjectAccessReviewStatus `json:"status,omitempty"`
+}
+
+type SubjectAccessReviewSpec struct {
+	ResourceAttributes    *ResourceAttributes    `json:"resourceAttributes,omitempty"`
+	NonResourceAttributes *NonResourceAttributes `json:"nonResourceAttributes,omitempty"`
+	User                  string                 `json:"user,omitempty"`
+	Groups                []string               `json:"groups,omitempty"`
+	Extra                 map[string][]string    `json:"extra,omitempty"`
+	UID                   string                 `json:"uid,omitempty"`
+	Attributes            *Attributes            `json:"attributes,omitempty"` // not in kubernetes, ignored by its decoders
+}
+
+type ResourceAttributes struct {
+	Namespace   string `json:"namespace,omitempty"`
+	Verb        string `json:"verb,omitempty"`
+	Group       string `json:"group,omitempty"`
+	Resource    string `json:"resource,omitempty"`
+	Subresource string `json:"subresource,omitempty"`
+	Name        string `json:"name,omitempty"`
+}
+
+type NonResourceAttributes struct {
+	Path string `json:"path,omitempty"`
+	Verb string `json:"verb,omitempty"`
+}
+
+type SubjectAccessReviewStatus struct {
+	Allowed         bool   `json:"allowed"`
+	Denied          bool   `json:"denied,omitempty"`
+	Reason          string `json:"reason,omitempty"`
+	EvaluationError string `json:"evaluationError,omitempty"`
+}
+
+// NewSubjectAccessReview converts the user and attributes to a review.
+func NewSubjectAccessReview(user UserInfo, a Attributes) *SubjectAccessReview {
+	spec := SubjectAccessReviewSpec{
+		User:       user.Name,
+		Groups:     user.Groups,
+		Extra:      user.Extra,
+		UID:        user.ID,
+		Attributes: &a,
+	}
+	if len(a.Resources) == 0 {
+		spec.NonResourceAttributes = &NonResourceAttributes{Path: a.Path, Verb: a.Action}
+	} else {
+		attrs := &ResourceAttributes{Verb: a.Action}
+		resources := a.Resources
+		if len(resources) > 1 && resources[0].Resource == "namespaces" {
+			attrs.Namespace, resources = resources[0].Name, resources[1:]
+		}
+		last := resources[len(resources)-1]
+		attrs.Resource, attrs.Subresource, attrs.Group = splitKubernetesResource(last.Resource)
+		attrs.Name = last.Name
+		spec.ResourceAttributes = attrs
+	}
+	return &SubjectAccessReview{APIVersion: "authorization.k8s.io/v1", Kind: "SubjectAccessReview", Spec: spec}
+}
+
+// splitKubernetesResource splits a group qualified resource with subresource,
+// e.g. deployments.apps/scale -> deployments, scale, apps.
+func splitKubernetesResource(qualified string) (resource, subresource, group string) {
+	resource, subresource, _ = strings.Cut(qualified, "/")
+	resource, group, _ = strings.Cut(resource, ".")
+	return resource, subresource, group
+}
+
+func (w *WebhookAuthorizer) Authorize(ctx context.Context, user UserInfo, a Attributes) (Decision, string, error) {
+	body, err := json.Marshal(NewSubjectAccessReview(user, a))
+	if err != nil {
+		return DecisionNoOpinion, "", err
+	}
+	backoff := w.Options.InitialBackoff
+	for attempt := 0; ; attempt++ {
+		status, retry, err := w.review(ctx, body)
+		if err == nil {
+			switch {
+			case status.Allowed:
+				return DecisionAllow, status.Reason, nil
+			case status.Denied:
+				return DecisionDeny, status.Reason, nil
+			default:
+				reason := status.Reason
+				if status.EvaluationError != "" {
+					reason = strings.TrimPrefix(reason+"; "+status.EvaluationError, "; ")
+				}
+				return DecisionNoOpinion, reason, nil
+			}
+		}
+		if !retry || attempt >= w.Options.MaxRetries {
+			return DecisionNoOpinion, "", fmt.Errorf("authorization webhook: %w", err)
+		}
+		select {
+		case <-ctx.Done():
+			return DecisionNoOpinion, "", ctx.Err()
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; w.Options.MaxBackoff > 0 && backoff > w.Options.MaxBackoff {
+			backoff = w.Options.MaxBackoff
+		}
+	}
+}
+
+// review sends the review once, retry reports whether the failure is temporary.
+func (w *WebhookAuthorizer) review(ctx context.Context, body []byte) (*SubjectAccessReviewStatus, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.Options.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	if w.Options.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+w.Options.BearerToken)
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return nil, ctx.Err() == nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, true, err
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		return nil, retry, fmt.Errorf("unexpected status %d: %s", resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	review := &SubjectAccessReview{}
+	if err := json.Unmarshal(data, review); err != nil {
+		return nil, false, fmt.Errorf("decode review: %w", err)
+	}
+	return &review.Status, false, nil
+}
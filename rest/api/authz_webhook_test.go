@@ -0,0 +1,87 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWebhookAuthorizer(t *testing.T) {
+	failures := atomic.Int64{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer t0ken" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if failures.Add(-1) >= 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		review := SubjectAccessReview{}
+		if err := json.NewDecoder(r.Body).Decode(&review); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		switch attrs := review.Spec.ResourceAttributes; {
+		case attrs == nil:
+			review.Status.Allowed = review.Spec.NonResourceAttributes.Path == "/healthz"
+		case review.Spec.User == "admin":
+			review.Status.Allowed = true
+		case attrs.Namespace == "default" && attrs.Group == "apps" && attrs.Resource == "deployments" && attrs.Verb == "get":
+			review.Status.Allowed, review.Status.Reason = true, "viewer"
+		case attrs.Subresource == "exec":
+			review.Status.Denied, review.Status.Reason = true, "exec is forbidden"
+		}
+		_ = json.NewEncoder(w).Encode(review)
+	}))
+	defer server.Close()
+
+	opts := NewDefaultWebhookAuthorizerOptions()
+	opts.URL, opts.BearerToken, opts.InitialBackoff = server.URL, "t0ken", time.Millisecond
+	authorizer, err := NewWebhookAuthorizer(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deployment := Attributes{Action: "get", Resources: []AttributeResource{
+		{Resource: "namespaces", Name: "default"},
+		{Resource: "deployments.apps", Name: "nginx"},
+	}}
+	tests := []struct {
+		name       string
+		user       string
+		attrs      Attributes
+		failures   int64
+		want       Decision
+		wantReason string
+		wantErr    bool
+	}{
+		{name: "allowed", user: "bob", attrs: deployment, want: DecisionAllow, wantReason: "viewer"},
+		{name: "retried", user: "bob", attrs: deployment, failures: 2, want: DecisionAllow, wantReason: "viewer"},
+		{name: "retries exhausted", user: "bob", attrs: deployment, failures: 10, want: DecisionNoOpinion, wantErr: true},
+		{
+			name:       "denied",
+			user:       "bob",
+			attrs:      Attributes{Action: "create", Resources: []AttributeResource{{Resource: "namespaces", Name: "default"}, {Resource: "pods/exec", Name: "nginx"}}},
+			want:       DecisionDeny,
+			wantReason: "exec is forbidden",
+		},
+		{name: "no opinion", user: "bob", attrs: Attributes{Action: "delete", Resources: []AttributeResource{{Resource: "nodes", Name: "n1"}}}, want: DecisionNoOpinion},
+		{name: "non resource", user: "bob", attrs: Attributes{Action: "get", Path: "/healthz"}, want: DecisionAllow},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			failures.Store(tt.failures)
+			decision, reason, err := authorizer.Authorize(context.Background(), UserInfo{Name: tt.user}, tt.attrs)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Authorize() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if decision != tt.want || reason != tt.wantReason {
+				t.Errorf("Authorize() = %v, %q, want %v, %q", decision, reason, tt.want, tt.wantReason)
+			}
+		})
+	}
+}
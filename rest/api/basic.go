@@ -0,0 +1,190 @@
+// Copyright 2023 The Kubegems Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/go-logr/logr"
+	"golang.org/x/crypto/bcrypt"
+	"kubegems.io/library/rest/response"
+)
+
+var _ UsernamePasswordAuthenticator = &BasicAuthenticator{}
+
+// BasicAuthenticator verifies username and password against an htpasswd file.
+// Supported hashes are bcrypt ($2y$, $2a$, $2b$) and {SHA}, other lines are ignored.
+type BasicAuthenticator struct {
+	File string
+
+	mu    sync.RWMutex
+	users map[string]string // username -> hash
+	dummy string            // verified for unknown users, see dummyHash
+}
+
+func NewBasicAuthenticator(file string) (*BasicAuthenticator, error) {
+	a := &BasicAuthenticator{File: file}
+	if err := a.Reload(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Reload reads the htpasswd file, the previous users are kept on error.
+func (a *BasicAuthenticator) Reload() error {
+	data, err := os.ReadFile(a.File)
+	if err != nil {
+		return err
+	}
+	users, err := ParseHtpasswd(data)
+	if err != nil {
+		return fmt.Errorf("parse htpasswd %s: %w", a.File, err)
+	}
+	dummy, err := dummyHash(users)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.users, a.dummy = users, dummy
+	return nil
+}
+
+// dummyHash returns a hash of a random password as costly as the most costly hash of users,
+// unknown users are verified against it, so that usernames can not be enumerated by timing.
+func dummyHash(users map[string]string) (string, error) {
+	cost := 0
+	for _, hash := range users {
+		if c, err := bcrypt.Cost([]byte(hash)); err == nil && c > cost {
+			cost = c
+		}
+	}
+	if cost == 0 {
+		sum := sha1.Sum([]byte(randomString()))
+		return "{SHA}" + base64.StdEncoding.EncodeToString(sum[:]), nil
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(randomString()), cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// Watch reloads the file on change until ctx done.
+// The parent directory is watched so that atomic replacements, e.g. kubernetes secret updates, are noticed.
+func (a *BasicAuthenticator) Watch(ctx context.Context) error {
+	log := logr.FromContextOrDiscard(ctx)
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("error creating fsnotify watcher: %v", err)
+	}
+	defer w.Close()
+
+	if err := w.Add(filepath.Dir(a.File)); err != nil {
+		return fmt.Errorf("error adding watch for file %s: %v", a.File, err)
+	}
+	for {
+		select {
+		case <-w.Events:
+			if err := a.Reload(); err != nil {
+				log.Error(err, "failed to reload htpasswd", "file", a.File)
+			}
+		case err := <-w.Errors:
+			return fmt.Errorf("received fsnotify error: %v", err)
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// Authenticate implements UsernamePasswordAuthenticator.
+func (a *BasicAuthenticator) Authenticate(ctx context.Context, username, password string) (*AuthenticateInfo, error) {
+	a.mu.RLock()
+	hash, ok := a.users[username]
+	if !ok {
+		hash = a.dummy
+	}
+	a.mu.RUnlock()
+	if !VerifyHtpasswd(hash, password) || !ok {
+		return nil, fmt.Errorf("invalid username or password")
+	}
+	return &AuthenticateInfo{User: UserInfo{Name: username, Groups: []string{}}}, nil
+}
+
+// ParseHtpasswd parses "username:hash" lines, empty lines and comments are skipped.
+func ParseHtpasswd(data []byte) (map[string]string, error) {
+	users := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		username, hash, ok := strings.Cut(line, ":")
+		if !ok || username == "" || hash == "" {
+			return nil, fmt.Errorf("line %d: invalid entry", lineno)
+		}
+		users[username] = hash
+	}
+	return users, scanner.Err()
+}
+
+// VerifyHtpasswd checks password against an htpasswd hash.
+func VerifyHtpasswd(hash, password string) bool {
+	switch {
+	case strings.HasPrefix(hash, "$2y$"), strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"):
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	case strings.HasPrefix(hash, "{SHA}"):
+		sum := sha1.Sum([]byte(password))
+		expected := "{SHA}" + base64.StdEncoding.EncodeToString(sum[:])
+		return subtle.ConstantTimeCompare([]byte(hash), []byte(expected)) == 1
+	default:
+		return false
+	}
+}
+
+// BasicHTTPAuthenticator authenticates the "Authorization: Basic" header of request with authenticator.
+func BasicHTTPAuthenticator(authenticator UsernamePasswordAuthenticator) HTTPAuthenticator {
+	return HTTPAuthenticateFunc(func(ctx context.Context, r *http.Request) (*AuthenticateInfo, error) {
+		username, password, ok := r.BasicAuth()
+		if !ok {
+			return nil, fmt.Errorf("no basic auth credentials found")
+		}
+		return authenticator.Authenticate(ctx, username, password)
+	})
+}
+
+// NewBasicAuthenticationFilter authenticates requests with basic auth,
+// a "WWW-Authenticate" challenge of realm is responded on failure.
+func NewBasicAuthenticationFilter(authenticator UsernamePasswordAuthenticator, realm string) Filter {
+	onerr := func(w http.ResponseWriter, r *http.Request, err error) {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q, charset=\"UTF-8\"", realm))
+		response.Unauthorized(w, fmt.Sprintf("Unauthorized: %v", err))
+	}
+	// the client ip is set for authenticators counting failures by it, e.g. LockoutPasswordAuthenticator
+	return NewHTTPAuthenticationFilter(BasicHTTPAuthenticator(authenticator), onerr)
+}
@@ -0,0 +1,255 @@
+package api
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func newHtpasswdLine(t *testing.T, username, password string, cost int) string {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), cost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// htpasswd writes $2y$, which is the same algorithm as $2a$
+	return username + ":$2y$" + string(hash[4:]) + "\n"
+}
+
+func shaHtpasswd(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return "{SHA}" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func TestParseHtpasswd(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name: "comments and empty lines",
+			data: "# users\n\nalice:{SHA}abc\n  bob:$2y$05$xyz  \n",
+			want: map[string]string{"alice": "{SHA}abc", "bob": "$2y$05$xyz"},
+		},
+		{name: "no hash", data: "alice\n", wantErr: true},
+		{name: "empty hash", data: "alice:\n", wantErr: true},
+		{name: "empty username", data: ":{SHA}abc\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseHtpasswd([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseHtpasswd() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("ParseHtpasswd() = %v, want %v", got, tt.want)
+			}
+			for k, v := range tt.want {
+				if got[k] != v {
+					t.Errorf("ParseHtpasswd()[%s] = %s, want %s", k, got[k], v)
+				}
+			}
+		})
+	}
+}
+
+func TestVerifyHtpasswd(t *testing.T) {
+	bcrypted, _ := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	tests := []struct {
+		name     string
+		hash     string
+		password string
+		want     bool
+	}{
+		{name: "bcrypt $2a$", hash: string(bcrypted), password: "secret", want: true},
+		{name: "bcrypt $2y$", hash: "$2y$" + string(bcrypted[4:]), password: "secret", want: true},
+		{name: "bcrypt wrong password", hash: string(bcrypted), password: "wrong"},
+		{name: "sha", hash: shaHtpasswd("secret"), password: "secret", want: true},
+		{name: "sha wrong password", hash: shaHtpasswd("secret"), password: "wrong"},
+		{name: "plain text not supported", hash: "secret", password: "secret"},
+		{name: "md5 not supported", hash: "$apr1$salt$hash", password: "secret"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := VerifyHtpasswd(tt.hash, tt.password); got != tt.want {
+				t.Errorf("VerifyHtpasswd() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBasicAuthenticator(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "htpasswd")
+	data := newHtpasswdLine(t, "alice", "secret", bcrypt.MinCost+1) + "bob:" + shaHtpasswd("secret") + "\n"
+	if err := os.WriteFile(file, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	authenticator, err := NewBasicAuthenticator(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// unknown users are verified against a hash as costly as the users' ones
+	if cost, err := bcrypt.Cost([]byte(authenticator.dummy)); err != nil || cost != bcrypt.MinCost+1 {
+		t.Errorf("dummy hash cost = %d, %v, want %d", cost, err, bcrypt.MinCost+1)
+	}
+	tests := []struct {
+		username, password string
+		wantErr            bool
+	}{
+		{username: "alice", password: "secret"},
+		{username: "bob", password: "secret"},
+		{username: "alice", password: "wrong", wantErr: true},
+		{username: "carol", password: "secret", wantErr: true},
+		{username: "carol", password: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		info, err := authenticator.Authenticate(context.Background(), tt.username, tt.password)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Authenticate(%s, %s) error = %v, wantErr %v", tt.username, tt.password, err, tt.wantErr)
+		}
+		if err == nil && info.User.Name != tt.username {
+			t.Errorf("Authenticate(%s) user = %s", tt.username, info.User.Name)
+		}
+	}
+
+	// an invalid file keeps the previous users
+	if err := os.WriteFile(file, []byte("invalid\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := authenticator.Reload(); err == nil {
+		t.Error("Reload() of invalid file expected error")
+	}
+	if _, err := authenticator.Authenticate(context.Background(), "alice", "secret"); err != nil {
+		t.Errorf("users lost after failed reload: %v", err)
+	}
+}
+
+func TestBasicAuthenticatorWatch(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "htpasswd")
+	if err := os.WriteFile(file, []byte("alice:"+shaHtpasswd("secret")+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	authenticator, err := NewBasicAuthenticator(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- authenticator.Watch(ctx) }()
+	time.Sleep(50 * time.Millisecond) // the watch is added
+
+	// replaced atomically, as kubernetes updates a mounted secret
+	tmp := filepath.Join(dir, ".htpasswd.tmp")
+	if err := os.WriteFile(tmp, []byte("bob:"+shaHtpasswd("secret")+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		_, errBob := authenticator.Authenticate(ctx, "bob", "secret")
+		_, errAlice := authenticator.Authenticate(ctx, "alice", "secret")
+		if errBob == nil && errAlice != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("file change not reloaded: bob %v, alice %v", errBob, errAlice)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Watch() = %v", err)
+	}
+}
+
+func TestBasicAuthenticationFilter(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "htpasswd")
+	if err := os.WriteFile(file, []byte("alice:"+shaHtpasswd("secret")+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	authenticator, err := NewBasicAuthenticator(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := Filters{NewBasicAuthenticationFilter(authenticator, "kubegems")}.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(AuthenticateFromContext(r.Context()).User.Name))
+	}))
+	tests := []struct {
+		name     string
+		username string
+		password string
+		wantCode int
+	}{
+		{name: "no credentials", wantCode: http.StatusUnauthorized},
+		{name: "wrong password", username: "alice", password: "wrong", wantCode: http.StatusUnauthorized},
+		{name: "ok", username: "alice", password: "secret", wantCode: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.username != "" {
+				req.SetBasicAuth(tt.username, tt.password)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.wantCode {
+				t.Fatalf("code = %d, want %d", rec.Code, tt.wantCode)
+			}
+			challenge := rec.Header().Get("WWW-Authenticate")
+			if tt.wantCode == http.StatusUnauthorized && challenge != `Basic realm="kubegems", charset="UTF-8"` {
+				t.Errorf("WWW-Authenticate = %q", challenge)
+			}
+			if tt.wantCode == http.StatusOK && rec.Body.String() != tt.username {
+				t.Errorf("user = %q, want %q", rec.Body.String(), tt.username)
+			}
+		})
+	}
+}
+
+func TestBasicAuthenticationFilterLockout(t *testing.T) {
+	inner := UsernamePasswordAuthenticateFunc(func(ctx context.Context, username, password string) (*AuthenticateInfo, error) {
+		if password != "secret" {
+			return nil, errors.New("invalid password")
+		}
+		return &AuthenticateInfo{User: UserInfo{Name: username}}, nil
+	})
+	authenticator := NewLockoutPasswordAuthenticator(inner, &LockoutOptions{MaxFailures: 2})
+	handler := Filters{NewBasicAuthenticationFilter(authenticator, "kubegems")}.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	// one client sprays a password across users
+	tests := []struct {
+		remoteAddr, username, password string
+		wantCode                       int
+	}{
+		{remoteAddr: "10.0.0.1:1234", username: "alice", password: "x", wantCode: http.StatusUnauthorized},
+		{remoteAddr: "10.0.0.1:1235", username: "bob", password: "x", wantCode: http.StatusUnauthorized},
+		{remoteAddr: "10.0.0.1:1236", username: "carol", password: "secret", wantCode: http.StatusUnauthorized},
+		{remoteAddr: "10.0.0.2:1234", username: "carol", password: "secret", wantCode: http.StatusOK},
+	}
+	for i, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = tt.remoteAddr
+		req.SetBasicAuth(tt.username, tt.password)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tt.wantCode {
+			t.Fatalf("attempt %d: code = %d, want %d: %s", i, rec.Code, tt.wantCode, rec.Body.String())
+		}
+	}
+}
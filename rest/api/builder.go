@@ -18,10 +18,13 @@ import (
 	"net/http"
 	"path"
 	"strings"
+
+	"golang.org/x/exp/slices"
 )
 
 type Route struct {
 	Summary    string
+	Host       string // host pattern, e.g. "{tenant}.example.com", empty matches any host
 	Path       string
 	Method     string
 	Deprecated bool
@@ -33,12 +36,50 @@ type Route struct {
 	Params     []Param
 	Responses  []ResponseInfo
 	Properties map[string]interface{}
+
+	// Constraints are matched besides method and path, routes with more constraints are tried first
+	Constraints []Constraint
+	// Priority pins the resolution order among ambiguous patterns, higher first, see matcher.Node.Prioritize
+	Priority int
+	// SecurityRequirements are the alternative security schemes of the route in api doc, any of them is sufficient
+	SecurityRequirements []SecurityRequirement
+}
+
+// SecurityRequirement maps the names of security schemes to the required scopes, all of the schemes are required.
+// The schemes are declared by APIDocPlugin.SecurityScheme.
+type SecurityRequirement map[string][]string
+
+// Constraint is a query or header condition of a route, an empty Value matches any value present.
+type Constraint struct {
+	Kind  ParamKind // ParamKindQuery or ParamKindHeader
+	Key   string
+	Value string
+}
+
+func (c Constraint) String() string {
+	return string(c.Kind) + ":" + c.Key + "=" + c.Value
+}
+
+func (c Constraint) Match(r *http.Request) bool {
+	var values []string
+	switch c.Kind {
+	case ParamKindQuery:
+		values = r.URL.Query()[c.Key]
+	case ParamKindHeader:
+		values = r.Header.Values(c.Key)
+	default:
+		return false
+	}
+	if c.Value == "" {
+		return len(values) > 0
+	}
+	return slices.Contains(values, c.Value)
 }
 
 func (route Route) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	fn := route.Handler
 	if len(route.Produces) != 0 || len(route.Consumes) != 0 {
-		fn = MediaTypeCheckFunc(route.Produces, route.Consumes, route.Handler)
+		fn = MediaTypeCheckFunc(route.Consumes, route.Produces, route.Handler)
 	}
 	route.Filters.Process(w, r, fn)
 }
@@ -54,8 +95,9 @@ func Do(method string, path string) Route {
 	return Route{Method: method, Path: path}
 }
 
+// Any serves the methods without their own route on path.
 func Any(path string) Route {
-	return Do("", path)
+	return Do(MethodAny, path)
 }
 
 func OPTIONS(path string) Route {
@@ -91,6 +133,41 @@ func (n Route) To(fun http.HandlerFunc) Route {
 	return n
 }
 
+// OnHost restricts the route to the requests whose host matches pattern,
+// host variables like "{tenant}" in "{tenant}.example.com" are read by request.Path as path variables.
+func (n Route) OnHost(pattern string) Route {
+	n.Host = pattern
+	return n
+}
+
+// WithPriority matches the route before the ambiguous patterns of lower priority, the default priority is 0,
+// e.g. GET("/files/{name}").WithPriority(1) is preferred over GET("/files/{path}*") for "/files/a".
+// The priority overrides the score, so it is preferred over the constant siblings like "/files/index" as well.
+func (n Route) WithPriority(priority int) Route {
+	n.Priority = priority
+	return n
+}
+
+// Security adds a requirement of the security scheme name with scopes, e.g. Security("jwt") or Security("oauth", "read"),
+// requirements added by multiple calls are alternatives.
+func (n Route) Security(name string, scopes ...string) Route {
+	n.SecurityRequirements = append(slices.Clip(n.SecurityRequirements), SecurityRequirement{name: scopes})
+	return n
+}
+
+// MatchQuery restricts the route to the requests with query key of value, any value if empty,
+// e.g. GET("/pods").MatchQuery("watch", "true") serves watches besides the list route GET("/pods").
+func (n Route) MatchQuery(key, value string) Route {
+	n.Constraints = append(slices.Clip(n.Constraints), Constraint{Kind: ParamKindQuery, Key: key, Value: value})
+	return n
+}
+
+// MatchHeader restricts the route to the requests with header key of value, any value if empty.
+func (n Route) MatchHeader(key, value string) Route {
+	n.Constraints = append(slices.Clip(n.Constraints), Constraint{Kind: ParamKindHeader, Key: key, Value: value})
+	return n
+}
+
 func (n Route) Tag(tags ...string) Route {
 	n.Tags = append(n.Tags, tags...)
 	return n
@@ -128,6 +205,13 @@ func (n Route) ResponseStatus(status int, body interface{}, desc ...string) Rout
 	return n
 }
 
+// Use appends filters to the route, they run after the filters of the groups containing the route
+// and before the handler, in the order added.
+func (n Route) Use(filters ...Filter) Route {
+	n.Filters = append(slices.Clip(n.Filters), filters...)
+	return n
+}
+
 func (n Route) Property(k string, v interface{}) Route {
 	if n.Properties == nil {
 		n.Properties = make(map[string]interface{})
@@ -200,6 +284,7 @@ func (p Param) Def(def string) Param {
 }
 
 type Group struct {
+	Host      string // host pattern of the routes which have no host set
 	Path      string
 	Filters   Filters
 	Tags      []string
@@ -208,12 +293,20 @@ type Group struct {
 	SubGroups []Group // sub groups
 	Consumes  []string
 	Produces  []string
+
+	SecurityRequirements []SecurityRequirement // alternatives added to all routes in the group
 }
 
 func NewGroup(path string) Group {
 	return Group{Path: path}
 }
 
+// OnHost sets the host pattern of the routes in the group, see Route.OnHost.
+func (g Group) OnHost(pattern string) Group {
+	g.Host = pattern
+	return g
+}
+
 func (g Group) Tag(name string) Group {
 	g.Tags = append(g.Tags, name)
 	return g
@@ -241,16 +334,42 @@ func (g Group) SubGroup(groups ...Group) Group {
 	return g
 }
 
+// Security adds a requirement of the security scheme name with scopes to the routes in the group, see Route.Security.
+func (g Group) Security(name string, scopes ...string) Group {
+	g.SecurityRequirements = append(slices.Clip(g.SecurityRequirements), SecurityRequirement{name: scopes})
+	return g
+}
+
 func (g Group) Param(params ...Param) Group {
 	g.Params = append(g.Params, params...)
 	return g
 }
 
-func (g Group) Filter(filters ...Filter) Group {
-	g.Filters = append(g.Filters, filters...)
+// Use appends filters to the group, they apply to all routes in the group and its sub groups.
+// Filters of an outer group run first, then the inner groups and the route, plugins may prepend filters to routes.
+func (g Group) Use(filters ...Filter) Group {
+	// groups derived from the same group must not share the backing array
+	g.Filters = append(slices.Clip(g.Filters), filters...)
 	return g
 }
 
+// Filter is an alias of Use.
+func (g Group) Filter(filters ...Filter) Group {
+	return g.Use(filters...)
+}
+
+// methodKey is the method followed by the constraints, e.g. "GET query:watch=true".
+func (route Route) methodKey() string {
+	key := route.Method
+	for _, c := range route.Constraints {
+		key += " " + c.String()
+	}
+	return key
+}
+
+// Build returns routes indexed by path and method, the path of a route with host is prefixed with the host,
+// e.g. "{tenant}.example.com/api", and the method of a route with constraints is followed by them,
+// e.g. "GET query:watch=true".
 func (t Group) Build() map[string]map[string]Route {
 	// path -> method -> route
 	items := map[string]map[string]Route{}
@@ -260,25 +379,35 @@ func (t Group) Build() map[string]map[string]Route {
 
 func buildRoutes(items map[string]map[string]Route, merged Group, group Group) {
 	merged.Path = path.Join(merged.Path, group.Path)
-	merged.Params = append(merged.Params, group.Params...)
-	merged.Tags = append(merged.Tags, group.Tags...)
-	merged.Consumes = append(merged.Consumes, group.Consumes...)
-	merged.Produces = append(merged.Produces, group.Produces...)
-	merged.Filters = append(merged.Filters, group.Filters...)
+	if group.Host != "" {
+		merged.Host = group.Host
+	}
+	// clip merged slices, sibling sub groups must not append to the same backing arrays
+	merged.Params = append(slices.Clip(merged.Params), group.Params...)
+	merged.Tags = append(slices.Clip(merged.Tags), group.Tags...)
+	merged.Consumes = append(slices.Clip(merged.Consumes), group.Consumes...)
+	merged.Produces = append(slices.Clip(merged.Produces), group.Produces...)
+	merged.Filters = append(slices.Clip(merged.Filters), group.Filters...)
+	merged.SecurityRequirements = append(slices.Clip(merged.SecurityRequirements), group.SecurityRequirements...)
 
 	for _, route := range group.Routes {
-		route.Tags = append(merged.Tags, route.Tags...)
-		route.Params = append(merged.Params, route.Params...)
+		// clip merged slices too, routes of the group must not append to the same backing arrays
+		route.Tags = append(slices.Clip(merged.Tags), route.Tags...)
+		route.Params = append(slices.Clip(merged.Params), route.Params...)
 		route.Path = merged.Path + route.Path
-		route.Consumes = append(group.Consumes, route.Consumes...)
-		route.Produces = append(group.Produces, route.Produces...)
-		route.Filters = append(merged.Filters, route.Filters...)
-		pathmethods, ok := items[route.Path]
+		route.Consumes = append(slices.Clip(merged.Consumes), route.Consumes...)
+		route.Produces = append(slices.Clip(merged.Produces), route.Produces...)
+		route.Filters = append(slices.Clip(merged.Filters), route.Filters...)
+		route.SecurityRequirements = append(slices.Clip(merged.SecurityRequirements), route.SecurityRequirements...)
+		if route.Host == "" {
+			route.Host = merged.Host
+		}
+		pathmethods, ok := items[route.Host+route.Path]
 		if !ok {
 			pathmethods = map[string]Route{}
-			items[route.Path] = pathmethods
+			items[route.Host+route.Path] = pathmethods
 		}
-		pathmethods[route.Method] = route
+		pathmethods[route.methodKey()] = route
 	}
 	for _, group := range group.SubGroups {
 		buildRoutes(items, merged, group)
@@ -0,0 +1,57 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBuildNestedGroups(t *testing.T) {
+	named := func(name string) Filter {
+		return FilterFunc(func(w http.ResponseWriter, r *http.Request, next http.Handler) {
+			w.Write([]byte(name + " "))
+			next.ServeHTTP(w, r)
+		})
+	}
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	// the filters and params of /r fill the capacity of the merged slices, siblings append to them
+	root := NewGroup("/r").Use(named("r1"), named("r2"), named("r3")).
+		Param(QueryParam("r1", ""), QueryParam("r2", ""), QueryParam("r3", "")).
+		SubGroup(
+			NewGroup("/c").Use(named("c")).Param(QueryParam("c", "")).SubGroup(
+				NewGroup("/a").Use(named("A")).Param(QueryParam("A", "")).Route(GET("/x").To(ok)),
+				NewGroup("/b").Use(named("B")).Param(QueryParam("B", "")).Route(GET("/y").To(ok)),
+			),
+		)
+	routes := root.Build()
+	tests := []struct {
+		path        string
+		wantFilters string
+		wantParams  []string
+	}{
+		{path: "/r/c/a/x", wantFilters: "r1 r2 r3 c A", wantParams: []string{"r1", "r2", "r3", "c", "A"}},
+		{path: "/r/c/b/y", wantFilters: "r1 r2 r3 c B", wantParams: []string{"r1", "r2", "r3", "c", "B"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			route, ok := routes[tt.path][http.MethodGet]
+			if !ok {
+				t.Fatalf("route %s not built: %v", tt.path, routes)
+			}
+			rec := httptest.NewRecorder()
+			route.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if got := strings.TrimSpace(rec.Body.String()); got != tt.wantFilters {
+				t.Errorf("filters = %s, want %s", got, tt.wantFilters)
+			}
+			params := []string{}
+			for _, p := range route.Params {
+				params = append(params, p.Name)
+			}
+			if !reflect.DeepEqual(params, tt.wantParams) {
+				t.Errorf("params = %v, want %v", params, tt.wantParams)
+			}
+		})
+	}
+}
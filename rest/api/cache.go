@@ -1,30 +1,306 @@
 package api
 
 import (
+	"context"
+	"math"
+	"math/rand"
+	"sync"
 	"time"
 
-	"github.com/hashicorp/golang-lru/v2/expirable"
+	lru "github.com/hashicorp/golang-lru/v2"
 )
 
+// Cache stores values with expiration, implementations can be shared between replicas, e.g. redis.
+type Cache[T any] interface {
+	Get(ctx context.Context, key string) (T, bool)
+	Add(ctx context.Context, key string, val T)
+	Remove(ctx context.Context, key string)
+}
+
+// GetOrAdd returns the cached value of key, or calls fn and caches the result if no error.
+func GetOrAdd[T any](ctx context.Context, cache Cache[T], key string, fn func() (T, error)) (T, error) {
+	if cache == nil {
+		return fn()
+	}
+	generation := cacheGeneration(cache)
+	if val, ok := cache.Get(ctx, key); ok {
+		return val, nil
+	}
+	val, err := fn()
+	if err != nil {
+		return val, err
+	}
+	addSince(ctx, cache, generation, key, val)
+	return val, nil
+}
+
+// GetOrRefresh is GetOrAdd, and refreshes the cached value in background before it expires
+// if the cache supports refresh-ahead, e.g. LRUCache with RefreshAhead.
+// The background fn is called with a context without cancellation, so it is not canceled with the request.
+func GetOrRefresh[T any](ctx context.Context, cache Cache[T], key string, fn func(ctx context.Context) (T, error)) (T, error) {
+	if cache == nil {
+		return fn(ctx)
+	}
+	generation := cacheGeneration(cache)
+	if val, ok := cache.Get(ctx, key); ok {
+		refreshAhead(ctx, cache, generation, key, fn)
+		return val, nil
+	}
+	val, err := fn(ctx)
+	if err != nil {
+		return val, err
+	}
+	addSince(ctx, cache, generation, key, val)
+	return val, nil
+}
+
+// generational is implemented by caches counting removals, e.g. LRUCache,
+// so a value loaded before a removal is not added after it, see addSince.
+type generational[T any] interface {
+	generation() uint64
+	addIfGeneration(ctx context.Context, key string, val T, generation uint64) bool
+}
+
+// cacheGeneration returns the generation of cache, take it before the value is read from the cache or its source.
+func cacheGeneration[T any](cache Cache[T]) uint64 {
+	if g, ok := cache.(generational[T]); ok {
+		return g.generation()
+	}
+	return 0
+}
+
+// addSince adds val unless an entry was removed from cache since generation,
+// e.g. a token revoked while it is authenticated or refreshed is not cached again.
+// Caches not counting removals, e.g. shared caches, add val as is.
+func addSince[T any](ctx context.Context, cache Cache[T], generation uint64, key string, val T) bool {
+	if g, ok := cache.(generational[T]); ok {
+		return g.addIfGeneration(ctx, key, val, generation)
+	}
+	cache.Add(ctx, key, val)
+	return true
+}
+
+// refresher is implemented by caches support refresh-ahead.
+type refresher interface {
+	// startRefresh returns true if key should be refreshed and no refresh of key is running.
+	startRefresh(key string) bool
+	// finishRefresh ends the refresh, a failed refresh is not retried until the entry is replaced.
+	finishRefresh(key string, ok bool)
+}
+
+// refreshAhead refreshes key in background, generation is taken before the cached value was read,
+// so a refresh is dropped if the entry was removed meanwhile, e.g. revoked.
+// A failed refresh removes the entry, e.g. the token is no longer valid, so the next lookup calls fn again.
+func refreshAhead[T any](ctx context.Context, cache Cache[T], generation uint64, key string, fn func(ctx context.Context) (T, error)) {
+	r, ok := cache.(refresher)
+	if !ok || !r.startRefresh(key) {
+		return
+	}
+	go func() {
+		ctx := withoutCancel(ctx)
+		val, err := fn(ctx)
+		if err == nil {
+			addSince(ctx, cache, generation, key, val)
+		} else {
+			cache.Remove(ctx, key)
+		}
+		r.finishRefresh(key, err == nil)
+	}()
+}
+
+// detachedContext keeps the values of parent without its cancellation and deadline.
+type detachedContext struct {
+	context.Context
+}
+
+func withoutCancel(ctx context.Context) context.Context {
+	return detachedContext{Context: ctx}
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+type LRUCacheOptions struct {
+	// Jitter randomizes the ttl of each entry in [ttl*(1-Jitter), ttl],
+	// so that entries added at the same time do not expire at the same time.
+	Jitter float64
+	// RefreshAhead is the fraction of ttl after which GetOrRefresh refreshes the entry in background, 0 disables.
+	RefreshAhead float64
+}
+
+// NewDefaultLRUCacheOptions returns the options used by the authenticator and authorizer caches.
+func NewDefaultLRUCacheOptions() LRUCacheOptions {
+	return LRUCacheOptions{Jitter: 0.1, RefreshAhead: 0.8}
+}
+
+var _ Cache[any] = LRUCache[any]{}
+
+// NewLRUCache returns a cache of size entries expire after ttl, size <= 0 means unlimited and ttl <= 0 means never expire.
 func NewLRUCache[T any](size int, ttl time.Duration) LRUCache[T] {
-	return LRUCache[T]{cache: expirable.NewLRU[string, T](size, nil, ttl)}
+	return NewLRUCacheWithOptions[T](size, ttl, LRUCacheOptions{})
+}
+
+func NewLRUCacheWithOptions[T any](size int, ttl time.Duration, opts LRUCacheOptions) LRUCache[T] {
+	if size <= 0 {
+		size = math.MaxInt32
+	}
+	c := &lruCache[T]{ttl: ttl, options: opts, refreshing: map[string]bool{}}
+	c.cache, _ = lru.NewWithEvict[string, lruEntry[T]](size, func(key string, _ lruEntry[T]) {
+		c.mu.Lock()
+		delete(c.refreshing, key)
+		c.mu.Unlock()
+	})
+	return LRUCache[T]{lru: c}
 }
 
 type LRUCache[T any] struct {
-	cache *expirable.LRU[string, T]
+	lru *lruCache[T]
+}
+
+type lruCache[T any] struct {
+	cache   *lru.Cache[string, lruEntry[T]]
+	ttl     time.Duration
+	options LRUCacheOptions
+
+	mu         sync.Mutex
+	refreshing map[string]bool // true if refreshing, false if the last refresh failed, removed on eviction
+
+	// generation counts removals, values loaded before a removal are not added, see addSince.
+	// Removals take the write lock, so no such add runs between counting and removing.
+	genmu      sync.RWMutex
+	generation uint64
+}
+
+type lruEntry[T any] struct {
+	val       T
+	refreshAt time.Time
+	expiresAt time.Time // zero means never expire
+}
+
+func (c LRUCache[T]) Get(ctx context.Context, key string) (T, bool) {
+	var zero T
+	if c.lru == nil {
+		return zero, false
+	}
+	entry, ok := c.lru.cache.Get(key)
+	if !ok {
+		return zero, false
+	}
+	if !entry.expiresAt.IsZero() && time.Now().After(entry.expiresAt) {
+		c.lru.cache.Remove(key)
+		return zero, false
+	}
+	return entry.val, true
+}
+
+func (c LRUCache[T]) Add(ctx context.Context, key string, val T) {
+	if c.lru == nil {
+		return
+	}
+	entry := lruEntry[T]{val: val}
+	if ttl := c.lru.ttl; ttl > 0 {
+		if jitter := c.lru.options.Jitter; jitter > 0 && jitter < 1 {
+			ttl -= time.Duration(rand.Float64() * jitter * float64(ttl))
+		}
+		now := time.Now()
+		entry.expiresAt = now.Add(ttl)
+		if ahead := c.lru.options.RefreshAhead; ahead > 0 && ahead < 1 {
+			entry.refreshAt = now.Add(time.Duration(ahead * float64(ttl)))
+		}
+	}
+	c.lru.cache.Add(key, entry)
+	c.lru.mu.Lock()
+	delete(c.lru.refreshing, key)
+	c.lru.mu.Unlock()
+}
+
+func (c LRUCache[T]) Remove(ctx context.Context, key string) {
+	if c.lru != nil {
+		defer c.lru.nextGeneration()()
+		c.lru.cache.Remove(key)
+	}
+}
+
+// Purge removes all entries, e.g. when the source of the cached values changed.
+func (c LRUCache[T]) Purge() {
+	if c.lru != nil {
+		defer c.lru.nextGeneration()()
+		c.lru.cache.Purge()
+	}
+}
+
+// RemoveFunc removes the entries whose key matches, returns the number removed.
+func (c LRUCache[T]) RemoveFunc(match func(key string) bool) int {
+	if c.lru == nil {
+		return 0
+	}
+	defer c.lru.nextGeneration()()
+	removed := 0
+	for _, key := range c.lru.cache.Keys() {
+		if match(key) && c.lru.cache.Remove(key) {
+			removed++
+		}
+	}
+	return removed
+}
+
+// nextGeneration counts a removal, the returned func is called after the removal.
+func (c *lruCache[T]) nextGeneration() func() {
+	c.genmu.Lock()
+	c.generation++
+	return c.genmu.Unlock
+}
+
+func (c LRUCache[T]) generation() uint64 {
+	if c.lru == nil {
+		return 0
+	}
+	c.lru.genmu.RLock()
+	defer c.lru.genmu.RUnlock()
+	return c.lru.generation
+}
+
+func (c LRUCache[T]) addIfGeneration(ctx context.Context, key string, val T, generation uint64) bool {
+	if c.lru == nil {
+		return false
+	}
+	c.lru.genmu.RLock()
+	defer c.lru.genmu.RUnlock()
+	if c.lru.generation != generation {
+		return false
+	}
+	c.Add(ctx, key, val)
+	return true
 }
 
 func (c LRUCache[T]) GetOrAdd(key string, fn func() (T, error)) (T, error) {
-	if c.cache == nil {
-		return fn()
+	return GetOrAdd[T](context.Background(), c, key, fn)
+}
+
+func (c LRUCache[T]) startRefresh(key string) bool {
+	if c.lru == nil {
+		return false
 	}
-	if info, ok := c.cache.Get(key); ok {
-		return info, nil
+	entry, ok := c.lru.cache.Peek(key)
+	if !ok || entry.refreshAt.IsZero() || time.Now().Before(entry.refreshAt) {
+		return false
 	}
-	info, err := fn()
-	if err != nil {
-		return info, err
+	c.lru.mu.Lock()
+	defer c.lru.mu.Unlock()
+	if _, ok := c.lru.refreshing[key]; ok {
+		return false
+	}
+	c.lru.refreshing[key] = true
+	return true
+}
+
+func (c LRUCache[T]) finishRefresh(key string, ok bool) {
+	c.lru.mu.Lock()
+	defer c.lru.mu.Unlock()
+	if ok {
+		delete(c.lru.refreshing, key)
+	} else if _, exists := c.lru.refreshing[key]; exists {
+		c.lru.refreshing[key] = false
 	}
-	c.cache.Add(key, info)
-	return info, nil
 }
@@ -0,0 +1,214 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLRUCacheJitter(t *testing.T) {
+	ttl := time.Minute
+	cache := NewLRUCacheWithOptions[int](0, ttl, LRUCacheOptions{Jitter: 0.5})
+	start := time.Now()
+	for i := 0; i < 100; i++ {
+		key := string(rune('a' + i))
+		cache.Add(context.Background(), key, i)
+		entry, _ := cache.lru.cache.Peek(key)
+		if expires := entry.expiresAt.Sub(start); expires < ttl/2 || expires > ttl+time.Second {
+			t.Fatalf("expiry %v out of [%v, %v]", expires, ttl/2, ttl)
+		}
+	}
+}
+
+func TestGetOrRefresh(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ttl := 100 * time.Millisecond
+	var cache Cache[int64] = NewLRUCacheWithOptions[int64](10, ttl, LRUCacheOptions{RefreshAhead: 0.5})
+	calls := atomic.Int64{}
+	fn := func(ctx context.Context) (int64, error) {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		return calls.Add(1), nil
+	}
+	if val, _ := GetOrRefresh(ctx, cache, "key", fn); val != 1 {
+		t.Fatalf("GetOrRefresh() = %v, want 1", val)
+	}
+	// cached before refresh-ahead
+	if val, _ := GetOrRefresh(ctx, cache, "key", fn); val != 1 {
+		t.Fatalf("GetOrRefresh() = %v, want 1", val)
+	}
+	time.Sleep(60 * time.Millisecond)
+	// the cached value is returned and refreshed in background, even if the request is canceled
+	val, _ := GetOrRefresh(ctx, cache, "key", fn)
+	cancel()
+	if val != 1 {
+		t.Fatalf("GetOrRefresh() = %v, want stale 1", val)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if val, ok := cache.Get(context.Background(), "key"); !ok || val != 2 {
+		t.Errorf("refreshed value = %v, %v, want 2", val, ok)
+	}
+}
+
+func TestGetOrRefreshFailed(t *testing.T) {
+	ctx := context.Background()
+	ttl := 200 * time.Millisecond
+	var cache Cache[int] = NewLRUCacheWithOptions[int](10, ttl, LRUCacheOptions{RefreshAhead: 0.5})
+	revoked := atomic.Bool{}
+	calls := atomic.Int64{}
+	fn := func(ctx context.Context) (int, error) {
+		calls.Add(1)
+		if revoked.Load() {
+			return 0, errors.New("revoked")
+		}
+		return 1, nil
+	}
+	if val, err := GetOrRefresh(ctx, cache, "key", fn); err != nil || val != 1 {
+		t.Fatalf("GetOrRefresh() = %v, %v, want 1", val, err)
+	}
+	revoked.Store(true)
+	time.Sleep(120 * time.Millisecond)
+	// the stale value is served while the refresh finds it revoked
+	if val, _ := GetOrRefresh(ctx, cache, "key", fn); val != 1 {
+		t.Fatalf("GetOrRefresh() = %v, want stale 1", val)
+	}
+	deadline := time.Now().Add(time.Second)
+	for calls.Load() < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if _, err := GetOrRefresh(ctx, cache, "key", fn); err == nil {
+		t.Error("GetOrRefresh() after a failed refresh served the cached value")
+	}
+	if got := calls.Load(); got != 3 {
+		t.Errorf("calls = %d, want 3", got)
+	}
+}
+
+func TestGetOrRefreshRemovedWhileLoading(t *testing.T) {
+	ctx := context.Background()
+	ttl := 100 * time.Millisecond
+	tests := []struct {
+		name    string
+		prepare func(cache LRUCache[int])
+		remove  func(cache LRUCache[int])
+	}{
+		{
+			name:    "removed during refresh",
+			prepare: func(cache LRUCache[int]) { cache.Add(ctx, "key", 1); time.Sleep(60 * time.Millisecond) },
+			remove:  func(cache LRUCache[int]) { cache.Remove(ctx, "key") },
+		},
+		{
+			name:   "removed during load",
+			remove: func(cache LRUCache[int]) { cache.Remove(ctx, "key") },
+		},
+		{
+			name:   "matched by RemoveFunc during load",
+			remove: func(cache LRUCache[int]) { cache.RemoveFunc(func(key string) bool { return key == "other" }) },
+		},
+		{
+			name:    "purged during refresh",
+			prepare: func(cache LRUCache[int]) { cache.Add(ctx, "key", 1); time.Sleep(60 * time.Millisecond) },
+			remove:  func(cache LRUCache[int]) { cache.Purge() },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := NewLRUCacheWithOptions[int](10, ttl, LRUCacheOptions{RefreshAhead: 0.5})
+			if tt.prepare != nil {
+				tt.prepare(cache)
+			}
+			loading, release, done := make(chan struct{}), make(chan struct{}), make(chan struct{})
+			go func() {
+				defer close(done)
+				_, _ = GetOrRefresh[int](ctx, cache, "key", func(ctx context.Context) (int, error) {
+					close(loading)
+					<-release
+					return 2, nil
+				})
+			}()
+			<-loading
+			tt.remove(cache)
+			close(release)
+			<-done
+			// the refresh adds in background
+			time.Sleep(20 * time.Millisecond)
+			if val, ok := cache.Get(ctx, "key"); ok {
+				t.Errorf("value loaded before removal cached: %v", val)
+			}
+		})
+	}
+}
+
+type countingAuthenticator struct {
+	calls atomic.Int64
+}
+
+func (a *countingAuthenticator) Authenticate(ctx context.Context, token string) (*AuthenticateInfo, error) {
+	a.calls.Add(1)
+	return &AuthenticateInfo{User: UserInfo{Name: token[:1]}}, nil
+}
+
+func TestLRUCacheAuthenticatorRevoke(t *testing.T) {
+	ctx := context.Background()
+	inner := &countingAuthenticator{}
+	authenticator := NewCacheAuthenticator(inner, 10, time.Minute)
+	for _, token := range []string{"a1", "a2", "b1", "a1"} {
+		if _, err := authenticator.Authenticate(ctx, token); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if calls := inner.calls.Load(); calls != 3 {
+		t.Fatalf("calls = %d, want 3", calls)
+	}
+	authenticator.Revoke(ctx, "b1")
+	authenticator.RevokeUser(ctx, "a")
+	for _, token := range []string{"a1", "a2", "b1"} {
+		_, _ = authenticator.Authenticate(ctx, token)
+	}
+	if calls := inner.calls.Load(); calls != 6 {
+		t.Errorf("calls after revoke = %d, want 6", calls)
+	}
+
+	authenticator.IsRevoked = func(ctx context.Context, token string, info *AuthenticateInfo) bool {
+		return info.User.Name == "b"
+	}
+	if _, err := authenticator.Authenticate(ctx, "b1"); err != ErrTokenRevoked {
+		t.Errorf("Authenticate() error = %v, want %v", err, ErrTokenRevoked)
+	}
+	if _, ok := authenticator.Cache.Get(ctx, "b1"); ok {
+		t.Errorf("revoked token still cached")
+	}
+}
+
+func TestLRUCacheAuthenticatorNegativeCache(t *testing.T) {
+	ctx := context.Background()
+	calls := atomic.Int64{}
+	inner := TokenAuthenticateFunc(func(ctx context.Context, token string) (*AuthenticateInfo, error) {
+		calls.Add(1)
+		if token == "invalid" {
+			return nil, errors.New("invalid token")
+		}
+		return &AuthenticateInfo{User: UserInfo{Name: token}}, nil
+	})
+	authenticator := NewCacheAuthenticator(inner, 10, time.Minute).WithNegativeCache(10, 50*time.Millisecond)
+	for i := 0; i < 3; i++ {
+		if _, err := authenticator.Authenticate(ctx, "invalid"); err == nil {
+			t.Fatal("invalid token accepted")
+		}
+	}
+	if got := calls.Load(); got != 1 {
+		t.Fatalf("calls = %d, want 1", got)
+	}
+	if _, ok := authenticator.Cache.Get(ctx, "invalid"); ok {
+		t.Errorf("failure cached as positive")
+	}
+	time.Sleep(60 * time.Millisecond)
+	_, _ = authenticator.Authenticate(ctx, "invalid")
+	if got := calls.Load(); got != 2 {
+		t.Errorf("calls after negative ttl = %d, want 2", got)
+	}
+}
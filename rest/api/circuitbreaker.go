@@ -0,0 +1,255 @@
+// Copyright 2023 The Kubegems Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"kubegems.io/library/rest/response"
+)
+
+type CircuitState int
+
+const (
+	CircuitClosed CircuitState = iota
+	CircuitOpen
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+type CircuitBreakerOptions struct {
+	Window        time.Duration // the period requests are counted in when closed, default 10s
+	MinRequests   int           // min requests in a window before the circuit opens, default 20
+	FailureRatio  float64       // the circuit opens when failures/requests reaches it, default 0.5
+	SlowThreshold time.Duration // requests slower than it are failures, 0 disables
+	OpenTimeout   time.Duration // how long the circuit stays open before probing, default 30s
+	// HalfOpenRequests is the number of probes allowed when half-open, the circuit closes after all succeeded
+	// and opens again on any failure, default 1.
+	HalfOpenRequests int
+	// IsFailure reports whether the response code is a failure, default code >= 500.
+	IsFailure func(code int) bool
+	// KeyFunc returns the circuit key of the request, e.g. the upstream host, empty key uses a single circuit.
+	KeyFunc func(r *http.Request) string
+	// OnStateChange is called on state transitions, e.g. to log or export metrics.
+	OnStateChange func(key string, from, to CircuitState)
+}
+
+func NewDefaultCircuitBreakerOptions() *CircuitBreakerOptions {
+	return &CircuitBreakerOptions{
+		Window:           10 * time.Second,
+		MinRequests:      20,
+		FailureRatio:     0.5,
+		OpenTimeout:      30 * time.Second,
+		HalfOpenRequests: 1,
+		IsFailure:        func(code int) bool { return code >= http.StatusInternalServerError },
+	}
+}
+
+func completeCircuitBreakerOptions(opts *CircuitBreakerOptions) {
+	defaults := NewDefaultCircuitBreakerOptions()
+	if opts.Window <= 0 {
+		opts.Window = defaults.Window
+	}
+	if opts.MinRequests <= 0 {
+		opts.MinRequests = defaults.MinRequests
+	}
+	if opts.FailureRatio <= 0 {
+		opts.FailureRatio = defaults.FailureRatio
+	}
+	if opts.OpenTimeout <= 0 {
+		opts.OpenTimeout = defaults.OpenTimeout
+	}
+	if opts.HalfOpenRequests <= 0 {
+		opts.HalfOpenRequests = defaults.HalfOpenRequests
+	}
+	if opts.IsFailure == nil {
+		opts.IsFailure = defaults.IsFailure
+	}
+}
+
+// CircuitBreaker fails fast when the failure ratio of the recent requests is too high,
+// to give a broken backend time to recover instead of piling up requests on it.
+//
+// It is closed at first and counts requests in windows, opens when the failure ratio reached,
+// becomes half-open after OpenTimeout to let a few probes through, and closes again if they succeed.
+type CircuitBreaker struct {
+	Key     string
+	Options *CircuitBreakerOptions
+
+	mu          sync.Mutex
+	state       CircuitState
+	generation  uint64 // increased on each transition, results of the previous generation are ignored
+	windowStart time.Time
+	requests    int
+	failures    int
+	openedAt    time.Time
+	probes      int // probes started when half-open
+}
+
+func NewCircuitBreaker(key string, opts *CircuitBreakerOptions) *CircuitBreaker {
+	completeCircuitBreakerOptions(opts)
+	return &CircuitBreaker{Key: key, Options: opts}
+}
+
+func (b *CircuitBreaker) State() CircuitState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.expire(time.Now())
+	return b.state
+}
+
+// Allow reports whether a request is allowed, done must be called with the result of an allowed request.
+// retryAt is the time the circuit may allow requests again if not allowed.
+func (b *CircuitBreaker) Allow() (done func(failed bool), retryAt time.Time, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	b.expire(now)
+	switch b.state {
+	case CircuitOpen:
+		return nil, b.openedAt.Add(b.Options.OpenTimeout), false
+	case CircuitHalfOpen:
+		if b.probes >= b.Options.HalfOpenRequests {
+			return nil, now.Add(time.Second), false
+		}
+		b.probes++
+	}
+	generation := b.generation
+	return func(failed bool) { b.done(generation, failed) }, time.Time{}, true
+}
+
+func (b *CircuitBreaker) done(generation uint64, failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	b.expire(now)
+	if generation != b.generation {
+		return
+	}
+	switch b.state {
+	case CircuitClosed:
+		b.requests++
+		if failed {
+			b.failures++
+		}
+		if b.requests >= b.Options.MinRequests && float64(b.failures) >= b.Options.FailureRatio*float64(b.requests) {
+			b.transit(CircuitOpen, now)
+		}
+	case CircuitHalfOpen:
+		if failed {
+			b.transit(CircuitOpen, now)
+			return
+		}
+		b.requests++
+		if b.requests >= b.Options.HalfOpenRequests {
+			b.transit(CircuitClosed, now)
+		}
+	}
+}
+
+// expire moves an open circuit to half-open after OpenTimeout and starts a new window of a closed circuit.
+func (b *CircuitBreaker) expire(now time.Time) {
+	switch b.state {
+	case CircuitOpen:
+		if !now.Before(b.openedAt.Add(b.Options.OpenTimeout)) {
+			b.transit(CircuitHalfOpen, now)
+		}
+	case CircuitClosed:
+		if now.Sub(b.windowStart) >= b.Options.Window {
+			b.windowStart, b.requests, b.failures = now, 0, 0
+		}
+	}
+}
+
+func (b *CircuitBreaker) transit(to CircuitState, now time.Time) {
+	from := b.state
+	b.state, b.generation = to, b.generation+1
+	b.windowStart, b.requests, b.failures, b.probes = now, 0, 0, 0
+	if to == CircuitOpen {
+		b.openedAt = now
+	}
+	if b.Options.OnStateChange != nil {
+		b.Options.OnStateChange(b.Key, from, to)
+	}
+}
+
+// NewCircuitBreakerFilter fails fast with 503 and Retry-After when the circuit of the request is open.
+// A circuit is kept for each key from KeyFunc, so keys must be bounded, e.g. upstream hosts.
+// Use CircuitBreakerPlugin for a circuit per route.
+func NewCircuitBreakerFilter(opts CircuitBreakerOptions) Filter {
+	completeCircuitBreakerOptions(&opts)
+	var mu sync.Mutex
+	breakers := map[string]*CircuitBreaker{}
+	return FilterFunc(func(w http.ResponseWriter, r *http.Request, next http.Handler) {
+		key := ""
+		if opts.KeyFunc != nil {
+			key = opts.KeyFunc(r)
+		}
+		mu.Lock()
+		breaker, ok := breakers[key]
+		if !ok {
+			breaker = &CircuitBreaker{Key: key, Options: &opts}
+			breakers[key] = breaker
+		}
+		mu.Unlock()
+
+		done, retryAt, allowed := breaker.Allow()
+		if !allowed {
+			SetRetryAfter(w.Header(), retryAt)
+			response.Error(w, response.NewStatusErrorMessage(http.StatusServiceUnavailable, "circuit breaker is open"))
+			return
+		}
+		start, failed := time.Now(), true
+		// a panic is a failure
+		defer func() { done(failed) }()
+		sw := &StatusResponseWriter{Inner: w}
+		next.ServeHTTP(sw, r)
+		code := sw.Code
+		if code == 0 {
+			code = http.StatusOK
+		}
+		failed = opts.IsFailure(code) || (opts.SlowThreshold > 0 && time.Since(start) > opts.SlowThreshold)
+	})
+}
+
+var _ Plugin = &CircuitBreakerPlugin{}
+
+// CircuitBreakerPlugin adds a circuit breaker to each route, keyed by "METHOD path" of the route.
+type CircuitBreakerPlugin struct {
+	NoopPlugin
+	Options CircuitBreakerOptions
+}
+
+func (p *CircuitBreakerPlugin) OnRoute(route *Route) error {
+	opts := p.Options
+	name := route.Method + " " + route.Path
+	opts.KeyFunc = func(r *http.Request) string { return name }
+	route.Filters = append([]Filter{NewCircuitBreakerFilter(opts)}, route.Filters...)
+	return nil
+}
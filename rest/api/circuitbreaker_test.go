@@ -0,0 +1,101 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerFilter(t *testing.T) {
+	transitions := []string{}
+	filter := NewCircuitBreakerFilter(CircuitBreakerOptions{
+		MinRequests: 4,
+		OpenTimeout: 50 * time.Millisecond,
+		KeyFunc:     func(r *http.Request) string { return r.Host },
+		OnStateChange: func(key string, from, to CircuitState) {
+			transitions = append(transitions, key+":"+to.String())
+		},
+	})
+	failing := true
+	handler := Filters{filter}.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	do := func(host string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Host = host
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// 2 of 4 failed reaches the ratio
+	for _, code := range []int{http.StatusBadGateway, http.StatusBadGateway} {
+		if rec := do("a"); rec.Code != code {
+			t.Fatalf("code = %d, want %d", rec.Code, code)
+		}
+	}
+	failing = false
+	do("a")
+	do("a")
+	rec := do("a")
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("open circuit responds %d with Retry-After %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if rec := do("b"); rec.Code != http.StatusOK {
+		t.Errorf("circuit of another key responds %d", rec.Code)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	failing = true
+	if rec := do("a"); rec.Code != http.StatusBadGateway {
+		t.Fatalf("half-open probe responds %d", rec.Code)
+	}
+	if rec := do("a"); rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("circuit reopened after failed probe responds %d", rec.Code)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	failing = false
+	for i := 0; i < 3; i++ {
+		if rec := do("a"); rec.Code != http.StatusOK {
+			t.Fatalf("closed circuit responds %d", rec.Code)
+		}
+	}
+
+	want := []string{"a:open", "a:half-open", "a:open", "a:half-open", "a:closed"}
+	if len(transitions) != len(want) {
+		t.Fatalf("transitions = %v, want %v", transitions, want)
+	}
+	for i := range want {
+		if transitions[i] != want[i] {
+			t.Fatalf("transitions = %v, want %v", transitions, want)
+		}
+	}
+}
+
+func TestCircuitBreakerHalfOpenProbes(t *testing.T) {
+	breaker := NewCircuitBreaker("", &CircuitBreakerOptions{MinRequests: 1, OpenTimeout: time.Millisecond, HalfOpenRequests: 2})
+	done, _, _ := breaker.Allow()
+	done(true)
+	if state := breaker.State(); state != CircuitOpen {
+		t.Fatalf("state = %s, want open", state)
+	}
+	time.Sleep(2 * time.Millisecond)
+	probe1, _, ok1 := breaker.Allow()
+	probe2, _, ok2 := breaker.Allow()
+	_, _, ok3 := breaker.Allow()
+	if !ok1 || !ok2 || ok3 {
+		t.Fatalf("half-open allowed %v %v %v, want 2 probes", ok1, ok2, ok3)
+	}
+	probe1(false)
+	if state := breaker.State(); state != CircuitHalfOpen {
+		t.Fatalf("state = %s, want half-open", state)
+	}
+	probe2(false)
+	if state := breaker.State(); state != CircuitClosed {
+		t.Fatalf("state = %s, want closed", state)
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright 2023 The Kubegems Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+var _ HTTPAuthenticator = &ClientCertAuthenticator{}
+
+// ClientCertAuthenticator authenticates the client certificate verified by the TLS server,
+// see listen.ClientCertTLSConfig for configuring the server.
+//
+// The user name is the subject CN, or the first email/DNS/URI SAN if CN is empty,
+// the email is the first email SAN and the groups are the subject OUs.
+type ClientCertAuthenticator struct {
+	// CRLFile is an optional PEM or DER encoded CRL, revoked certificates are refused.
+	// The CRL signature is verified against the issuer in the verified chain of the client certificate.
+	CRLFile string
+
+	mu      sync.RWMutex
+	crl     *x509.RevocationList
+	revoked map[string]bool // serial numbers
+	signer  []byte          // raw issuer certificate verified the crl signature
+}
+
+func NewClientCertAuthenticator(crlfile string) (*ClientCertAuthenticator, error) {
+	a := &ClientCertAuthenticator{CRLFile: crlfile}
+	if err := a.Reload(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Reload reads the CRL file, call it periodically if the CRL is updated.
+func (a *ClientCertAuthenticator) Reload() error {
+	if a.CRLFile == "" {
+		return nil
+	}
+	data, err := os.ReadFile(a.CRLFile)
+	if err != nil {
+		return err
+	}
+	if block, _ := pem.Decode(data); block != nil {
+		data = block.Bytes
+	}
+	crl, err := x509.ParseRevocationList(data)
+	if err != nil {
+		return fmt.Errorf("parse crl %s: %w", a.CRLFile, err)
+	}
+	revoked := make(map[string]bool, len(crl.RevokedCertificates))
+	for _, entry := range crl.RevokedCertificates {
+		revoked[entry.SerialNumber.String()] = true
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.crl, a.revoked, a.signer = crl, revoked, nil
+	return nil
+}
+
+// Authenticate implements HTTPAuthenticator.
+func (a *ClientCertAuthenticator) Authenticate(ctx context.Context, r *http.Request) (*AuthenticateInfo, error) {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return nil, fmt.Errorf("no client certificate found")
+	}
+	// the certificate is verified by tls server only if ClientCAs configured
+	if len(r.TLS.VerifiedChains) == 0 {
+		return nil, fmt.Errorf("client certificate is not verified")
+	}
+	cert := r.TLS.PeerCertificates[0]
+	if err := a.checkRevoked(r.TLS.VerifiedChains[0]); err != nil {
+		return nil, err
+	}
+	info := CertificateUserInfo(cert)
+	if info.Name == "" {
+		return nil, fmt.Errorf("no username found in client certificate")
+	}
+	return &AuthenticateInfo{User: info}, nil
+}
+
+// checkRevoked checks the leaf of a verified chain, the chain ends with the root which may be the leaf itself.
+func (a *ClientCertAuthenticator) checkRevoked(chain []*x509.Certificate) error {
+	a.mu.RLock()
+	crl, revoked, signer := a.crl, a.revoked, a.signer
+	a.mu.RUnlock()
+	if crl == nil {
+		return nil
+	}
+	cert, issuer := chain[0], chain[0]
+	if len(chain) > 1 {
+		issuer = chain[1]
+	}
+	if bytes.Equal(cert.RawIssuer, crl.RawIssuer) {
+		if !bytes.Equal(issuer.Raw, signer) {
+			if err := crl.CheckSignatureFrom(issuer); err != nil {
+				return fmt.Errorf("crl is not signed by the issuer of client certificate: %w", err)
+			}
+			a.mu.Lock()
+			if a.crl == crl {
+				a.signer = issuer.Raw
+			}
+			a.mu.Unlock()
+		}
+		if revoked[cert.SerialNumber.String()] {
+			return fmt.Errorf("client certificate %s is revoked", cert.SerialNumber)
+		}
+	}
+	if !crl.NextUpdate.IsZero() && time.Now().After(crl.NextUpdate) {
+		return fmt.Errorf("crl is outdated since %s", crl.NextUpdate.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// CertificateUserInfo maps the subject and SANs of a certificate to UserInfo.
+func CertificateUserInfo(cert *x509.Certificate) UserInfo {
+	info := UserInfo{
+		ID:     cert.SerialNumber.String(),
+		Name:   cert.Subject.CommonName,
+		Groups: append([]string{}, cert.Subject.OrganizationalUnit...),
+	}
+	if len(cert.EmailAddresses) > 0 {
+		info.Email = cert.EmailAddresses[0]
+	}
+	if info.Name == "" {
+		switch {
+		case len(cert.EmailAddresses) > 0:
+			info.Name = cert.EmailAddresses[0]
+		case len(cert.DNSNames) > 0:
+			info.Name = cert.DNSNames[0]
+		case len(cert.URIs) > 0:
+			info.Name = cert.URIs[0].String()
+		}
+	}
+	return info
+}
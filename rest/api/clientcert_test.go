@@ -0,0 +1,123 @@
+package api
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCertificate struct {
+	cert *x509.Certificate
+	key  crypto.Signer
+}
+
+func newTestCertificate(t *testing.T, serial int64, subject pkix.Name, parent *testCertificate) *testCertificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      subject,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	issuer, signer := template, crypto.Signer(key)
+	if parent == nil {
+		template.IsCA, template.BasicConstraintsValid = true, true
+		template.KeyUsage |= x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+	} else {
+		issuer, signer = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer, key.Public(), signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCertificate{cert: cert, key: key}
+}
+
+func writeTestCRL(t *testing.T, issuer *testCertificate, nextUpdate time.Time, revoked ...int64) string {
+	t.Helper()
+	template := &x509.RevocationList{Number: big.NewInt(1), ThisUpdate: time.Now().Add(-time.Hour), NextUpdate: nextUpdate}
+	for _, serial := range revoked {
+		template.RevokedCertificates = append(template.RevokedCertificates, pkix.RevokedCertificate{SerialNumber: big.NewInt(serial), RevocationTime: time.Now()})
+	}
+	der, err := x509.CreateRevocationList(rand.Reader, template, issuer.cert, issuer.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "crl.pem")
+	if err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestClientCertAuthenticator(t *testing.T) {
+	ca := newTestCertificate(t, 1, pkix.Name{CommonName: "kubegems-ca"}, nil)
+	// another ca with the same name, not trusted by the server
+	forged := newTestCertificate(t, 1, pkix.Name{CommonName: "kubegems-ca"}, nil)
+	otherca := newTestCertificate(t, 1, pkix.Name{CommonName: "other-ca"}, nil)
+	alice := newTestCertificate(t, 10, pkix.Name{CommonName: "alice", OrganizationalUnit: []string{"dev"}}, ca)
+	bob := newTestCertificate(t, 11, pkix.Name{CommonName: "bob"}, ca)
+	nextUpdate := time.Now().Add(time.Hour)
+
+	tests := []struct {
+		name     string
+		crl      string
+		client   *testCertificate
+		verified bool
+		wantUser string
+		wantErr  bool
+	}{
+		{name: "no crl", client: alice, verified: true, wantUser: "alice"},
+		{name: "not verified", client: alice, wantErr: true},
+		{name: "not revoked", crl: writeTestCRL(t, ca, nextUpdate, 11), client: alice, verified: true, wantUser: "alice"},
+		{name: "revoked", crl: writeTestCRL(t, ca, nextUpdate, 11), client: bob, verified: true, wantErr: true},
+		{name: "crl of another issuer", crl: writeTestCRL(t, otherca, nextUpdate, 11), client: bob, verified: true, wantUser: "bob"},
+		{name: "crl signed by forged issuer", crl: writeTestCRL(t, forged, nextUpdate), client: alice, verified: true, wantErr: true},
+		{name: "outdated crl", crl: writeTestCRL(t, ca, time.Now().Add(-time.Minute)), client: alice, verified: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authenticator, err := NewClientCertAuthenticator(tt.crl)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{tt.client.cert}}
+			if tt.verified {
+				req.TLS.VerifiedChains = [][]*x509.Certificate{{tt.client.cert, ca.cert}}
+			}
+			// twice, the verified crl signer is remembered
+			for i := 0; i < 2; i++ {
+				info, err := authenticator.Authenticate(req.Context(), req)
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("Authenticate() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				if err == nil && info.User.Name != tt.wantUser {
+					t.Errorf("Authenticate() user = %s, want %s", info.User.Name, tt.wantUser)
+				}
+			}
+		})
+	}
+}
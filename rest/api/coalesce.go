@@ -0,0 +1,141 @@
+// Copyright 2023 The Kubegems Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// CoalesceKeyFunc returns the key of identical requests, empty key disables coalescing for the request.
+type CoalesceKeyFunc func(r *http.Request) string
+
+// CoalesceKeyByUser merges requests with same method, path, query, user, Accept and Accept-Encoding,
+// so a response negotiated for one client, e.g. gzip encoded, is not sent to another.
+func CoalesceKeyByUser(r *http.Request) string {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return ""
+	}
+	return AuthenticateFromContext(r.Context()).User.Name + "@" + r.Method + " " + r.URL.RequestURI() +
+		"\x00" + strings.Join(r.Header.Values("Accept"), ",") +
+		"\x00" + strings.Join(r.Header.Values("Accept-Encoding"), ",")
+}
+
+// NewCoalesceFilter merges concurrent identical GET requests into one execution of next,
+// the response of the first request is copied to all the waiting requests.
+// It must be placed after authentication filter, so that responses are not shared across users.
+//
+// The shared execution runs with the context of the first request without its cancellation,
+// so the waiting requests still get the response when the first client disconnects.
+func NewCoalesceFilter(keyfunc CoalesceKeyFunc) Filter {
+	if keyfunc == nil {
+		keyfunc = CoalesceKeyByUser
+	}
+	group := &coalesceGroup{calls: map[string]*coalescedCall{}}
+	return FilterFunc(func(w http.ResponseWriter, r *http.Request, next http.Handler) {
+		key := keyfunc(r)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		call, leader := group.join(key)
+		if leader {
+			func() {
+				defer func() {
+					if err := recover(); err != nil {
+						// waiting requests get an error, the panic continues on the leader
+						call.resp = recordedResponse{header: http.Header{}, code: http.StatusInternalServerError}
+						group.done(key, call)
+						panic(err)
+					}
+					group.done(key, call)
+				}()
+				next.ServeHTTP(&call.resp, r.WithContext(withoutCancel(r.Context())))
+			}()
+		} else {
+			select {
+			case <-call.done:
+			case <-r.Context().Done():
+				return
+			}
+		}
+		call.resp.writeTo(w)
+	})
+}
+
+type coalesceGroup struct {
+	mu    sync.Mutex
+	calls map[string]*coalescedCall
+}
+
+type coalescedCall struct {
+	done chan struct{}
+	resp recordedResponse
+}
+
+func (g *coalesceGroup) join(key string) (*coalescedCall, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if call, ok := g.calls[key]; ok {
+		return call, false
+	}
+	call := &coalescedCall{done: make(chan struct{}), resp: recordedResponse{header: http.Header{}}}
+	g.calls[key] = call
+	return call, true
+}
+
+func (g *coalesceGroup) done(key string, call *coalescedCall) {
+	g.mu.Lock()
+	delete(g.calls, key)
+	g.mu.Unlock()
+	if call.resp.code == 0 {
+		call.resp.code = http.StatusOK
+	}
+	close(call.done)
+}
+
+// recordedResponse is a response detached from any connection, it can be written to many writers.
+type recordedResponse struct {
+	header http.Header
+	code   int
+	body   bytes.Buffer
+}
+
+func (w *recordedResponse) Header() http.Header {
+	return w.header
+}
+
+func (w *recordedResponse) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+}
+
+func (w *recordedResponse) Write(p []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	return w.body.Write(p)
+}
+
+func (w *recordedResponse) writeTo(dest http.ResponseWriter) {
+	for k, v := range w.header {
+		dest.Header()[k] = append([]string(nil), v...)
+	}
+	dest.WriteHeader(w.code)
+	_, _ = dest.Write(w.body.Bytes())
+}
@@ -0,0 +1,122 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCoalesceKeyByUser(t *testing.T) {
+	newRequest := func(method, target, user string, header map[string]string) *http.Request {
+		req := httptest.NewRequest(method, target, nil)
+		req = req.WithContext(WithAuthenticate(req.Context(), AuthenticateInfo{User: UserInfo{Name: user}}))
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		return req
+	}
+	base := newRequest(http.MethodGet, "/zoos?page=1", "alice", map[string]string{"Accept": "application/json"})
+	tests := []struct {
+		name     string
+		req      *http.Request
+		wantSame bool
+	}{
+		{name: "identical", req: newRequest(http.MethodGet, "/zoos?page=1", "alice", map[string]string{"Accept": "application/json"}), wantSame: true},
+		{name: "other user", req: newRequest(http.MethodGet, "/zoos?page=1", "bob", map[string]string{"Accept": "application/json"})},
+		{name: "other query", req: newRequest(http.MethodGet, "/zoos?page=2", "alice", map[string]string{"Accept": "application/json"})},
+		{name: "other accept", req: newRequest(http.MethodGet, "/zoos?page=1", "alice", map[string]string{"Accept": "text/yaml"})},
+		{name: "other encoding", req: newRequest(http.MethodGet, "/zoos?page=1", "alice", map[string]string{"Accept": "application/json", "Accept-Encoding": "gzip"})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if same := CoalesceKeyByUser(tt.req) == CoalesceKeyByUser(base); same != tt.wantSame {
+				t.Errorf("same key = %v, want %v", same, tt.wantSame)
+			}
+		})
+	}
+	if key := CoalesceKeyByUser(newRequest(http.MethodPost, "/zoos", "alice", nil)); key != "" {
+		t.Errorf("key of POST = %q, want empty", key)
+	}
+}
+
+func TestCoalesceFilter(t *testing.T) {
+	tests := []struct {
+		name         string
+		accepts      []string // of the leader and the waiting requests
+		cancelLeader bool
+		panics       bool
+		wantCalls    int64
+		wantCode     int
+		wantBody     string
+	}{
+		{name: "merged", accepts: []string{"a", "a", "a"}, wantCalls: 1, wantCode: http.StatusOK, wantBody: "ok"},
+		{name: "negotiated separately", accepts: []string{"a", "b", "b"}, wantCalls: 2, wantCode: http.StatusOK, wantBody: "ok"},
+		{name: "leader disconnected", accepts: []string{"a", "a", "a"}, cancelLeader: true, wantCalls: 1, wantCode: http.StatusOK, wantBody: "ok"},
+		{name: "leader panics", accepts: []string{"a", "a"}, panics: true, wantCalls: 1, wantCode: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := atomic.Int64{}
+			started, release := make(chan struct{}, len(tt.accepts)), make(chan struct{})
+			handler := Filters{NewCoalesceFilter(nil)}.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls.Add(1)
+				started <- struct{}{}
+				<-release
+				if tt.panics {
+					panic("handler failed")
+				}
+				if err := r.Context().Err(); err != nil {
+					http.Error(w, err.Error(), http.StatusServiceUnavailable)
+					return
+				}
+				w.Write([]byte("ok"))
+			}))
+			serve := func(ctx context.Context, accept string) (rec *httptest.ResponseRecorder) {
+				rec = httptest.NewRecorder()
+				defer func() { _ = recover() }()
+				req := httptest.NewRequest(http.MethodGet, "/zoos", nil).WithContext(ctx)
+				req.Header.Set("Accept", accept)
+				handler.ServeHTTP(rec, req)
+				return rec
+			}
+
+			leaderctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			wg := sync.WaitGroup{}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				serve(leaderctx, tt.accepts[0])
+			}()
+			<-started
+			recs := make([]*httptest.ResponseRecorder, len(tt.accepts)-1)
+			for i, accept := range tt.accepts[1:] {
+				wg.Add(1)
+				go func(i int, accept string) {
+					defer wg.Done()
+					recs[i] = serve(context.Background(), accept)
+				}(i, accept)
+			}
+			// the waiting requests join the leader, or start their own call
+			time.Sleep(20 * time.Millisecond)
+			if tt.cancelLeader {
+				cancel()
+			}
+			close(release)
+			wg.Wait()
+
+			if got := calls.Load(); got != tt.wantCalls {
+				t.Errorf("handler calls = %d, want %d", got, tt.wantCalls)
+			}
+			for i, rec := range recs {
+				if rec.Code != tt.wantCode || (tt.wantBody != "" && rec.Body.String() != tt.wantBody) {
+					t.Errorf("request %d: %d %q, want %d %q", i+1, rec.Code, rec.Body.String(), tt.wantCode, tt.wantBody)
+				}
+			}
+		})
+	}
+}
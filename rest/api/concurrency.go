@@ -0,0 +1,159 @@
+// Copyright 2023 The Kubegems Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"context"
+	"net/http"
+	"path"
+	"sync/atomic"
+	"time"
+
+	"kubegems.io/library/rest/response"
+)
+
+type ConcurrencyLimitRoute struct {
+	Pattern     string `json:"pattern" description:"path pattern of path.Match, a trailing /** matches all sub paths"`
+	MaxInflight int    `json:"maxInflight"`
+}
+
+type ConcurrencyLimitOptions struct {
+	MaxInflight int // max in-flight requests of all routes, 0 means unlimited
+	// Routes limits the requests matched each pattern, the first matched one applies,
+	// requests are limited by both the route limit and MaxInflight.
+	Routes []ConcurrencyLimitRoute
+	// MaxWait is how long a request waits in queue for a slot, 0 rejects immediately.
+	MaxWait time.Duration
+	// StatusCode responded to the rejected requests, http.StatusTooManyRequests or http.StatusServiceUnavailable,
+	// default http.StatusServiceUnavailable.
+	StatusCode int
+}
+
+// ConcurrencyLimiter bounds the concurrent in-flight requests to protect the service from overload,
+// requests over the limits wait up to MaxWait and are rejected with Retry-After.
+// Unlike NewRateLimitFilter it limits the work in progress, so slow requests consume the capacity.
+type ConcurrencyLimiter struct {
+	Options ConcurrencyLimitOptions
+
+	global   chan struct{} // nil if unlimited
+	routes   []chan struct{}
+	inflight atomic.Int64
+	rejected atomic.Uint64
+}
+
+func NewConcurrencyLimiter(opts ConcurrencyLimitOptions) *ConcurrencyLimiter {
+	if opts.StatusCode == 0 {
+		opts.StatusCode = http.StatusServiceUnavailable
+	}
+	l := &ConcurrencyLimiter{Options: opts, routes: make([]chan struct{}, len(opts.Routes))}
+	if opts.MaxInflight > 0 {
+		l.global = make(chan struct{}, opts.MaxInflight)
+	}
+	for i, route := range opts.Routes {
+		if route.MaxInflight > 0 {
+			l.routes[i] = make(chan struct{}, route.MaxInflight)
+		}
+	}
+	return l
+}
+
+// NewConcurrencyLimitFilter is NewConcurrencyLimiter(opts).Filter().
+func NewConcurrencyLimitFilter(opts ConcurrencyLimitOptions) Filter {
+	return NewConcurrencyLimiter(opts).Filter()
+}
+
+// Inflight returns the number of the requests being served.
+func (l *ConcurrencyLimiter) Inflight() int64 {
+	return l.inflight.Load()
+}
+
+// Rejected returns the number of the rejected requests.
+func (l *ConcurrencyLimiter) Rejected() uint64 {
+	return l.rejected.Load()
+}
+
+func (l *ConcurrencyLimiter) Filter() Filter {
+	return FilterFunc(func(w http.ResponseWriter, r *http.Request, next http.Handler) {
+		ctx, wait := r.Context(), l.Options.MaxWait > 0
+		if wait {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, l.Options.MaxWait)
+			defer cancel()
+		}
+		// route slot first, so requests queued for a busy route do not hold global slots
+		route := l.route(r)
+		if !acquire(ctx, route, wait) {
+			l.reject(w)
+			return
+		}
+		defer release(route)
+		if !acquire(ctx, l.global, wait) {
+			l.reject(w)
+			return
+		}
+		defer release(l.global)
+
+		l.inflight.Add(1)
+		defer l.inflight.Add(-1)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (l *ConcurrencyLimiter) route(r *http.Request) chan struct{} {
+	reqpath := path.Clean("/" + r.URL.Path)
+	for i, route := range l.Options.Routes {
+		if matchPathPattern(route.Pattern, reqpath) {
+			return l.routes[i]
+		}
+	}
+	return nil
+}
+
+func (l *ConcurrencyLimiter) reject(w http.ResponseWriter) {
+	l.rejected.Add(1)
+	after := l.Options.MaxWait
+	if after < time.Second {
+		after = time.Second
+	}
+	SetRetryAfter(w.Header(), time.Now().Add(after))
+	response.Error(w, response.NewStatusErrorMessage(l.Options.StatusCode, "too many concurrent requests"))
+}
+
+// acquire takes a slot of sem, waits until ctx done if full and wait, a nil sem is unlimited.
+func acquire(ctx context.Context, sem chan struct{}, wait bool) bool {
+	if sem == nil {
+		return true
+	}
+	select {
+	case sem <- struct{}{}:
+		return true
+	default:
+	}
+	if !wait {
+		return false
+	}
+	select {
+	case sem <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func release(sem chan struct{}) {
+	if sem != nil {
+		<-sem
+	}
+}
@@ -0,0 +1,94 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConcurrencyLimiter(t *testing.T) {
+	tests := []struct {
+		name       string
+		opts       ConcurrencyLimitOptions
+		path       string
+		wantCode   int
+		wantWaited bool
+	}{
+		{
+			name:     "global limit rejects",
+			opts:     ConcurrencyLimitOptions{MaxInflight: 1},
+			path:     "/other",
+			wantCode: http.StatusServiceUnavailable,
+		},
+		{
+			name:     "route limit rejects",
+			opts:     ConcurrencyLimitOptions{Routes: []ConcurrencyLimitRoute{{Pattern: "/slow/**", MaxInflight: 1}}, StatusCode: http.StatusTooManyRequests},
+			path:     "/slow/2",
+			wantCode: http.StatusTooManyRequests,
+		},
+		{
+			name:     "other route not limited",
+			opts:     ConcurrencyLimitOptions{Routes: []ConcurrencyLimitRoute{{Pattern: "/slow/**", MaxInflight: 1}}},
+			path:     "/other",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:       "queued until released",
+			opts:       ConcurrencyLimitOptions{MaxInflight: 1, MaxWait: time.Second},
+			path:       "/other",
+			wantCode:   http.StatusOK,
+			wantWaited: true,
+		},
+		{
+			name:       "queue timeout",
+			opts:       ConcurrencyLimitOptions{MaxInflight: 1, MaxWait: 20 * time.Millisecond},
+			path:       "/other",
+			wantCode:   http.StatusServiceUnavailable,
+			wantWaited: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := NewConcurrencyLimiter(tt.opts)
+			started, release := make(chan struct{}), make(chan struct{})
+			handler := limiter.Filter().Process
+			slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				close(started)
+				<-release
+			})
+			wg := sync.WaitGroup{}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/slow/1", nil), slow)
+			}()
+			<-started
+			if tt.wantCode == http.StatusOK && tt.wantWaited {
+				time.AfterFunc(50*time.Millisecond, func() { close(release) })
+			}
+
+			start := time.Now()
+			rec := httptest.NewRecorder()
+			handler(rec, httptest.NewRequest(http.MethodGet, tt.path, nil), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			waited := time.Since(start) >= 20*time.Millisecond
+			if rec.Code != tt.wantCode {
+				t.Errorf("code = %d, want %d", rec.Code, tt.wantCode)
+			}
+			if waited != tt.wantWaited {
+				t.Errorf("waited = %v, want %v", waited, tt.wantWaited)
+			}
+			if rec.Code != http.StatusOK && rec.Header().Get("Retry-After") == "" {
+				t.Errorf("Retry-After is not set on rejection")
+			}
+			if !(tt.wantCode == http.StatusOK && tt.wantWaited) {
+				close(release)
+			}
+			wg.Wait()
+			if inflight := limiter.Inflight(); inflight != 0 {
+				t.Errorf("Inflight() = %d after all done", inflight)
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright 2023 The Kubegems Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+type ETagOptions struct {
+	// MaxSize is the max buffered response size, larger responses are passed through without ETag, default 64KB.
+	MaxSize int
+	// ExcludedContentTypes are the content type prefixes passed through, e.g. "image/".
+	// Streaming responses, see IsStreamingResponse, are always passed through.
+	ExcludedContentTypes []string
+}
+
+// NewETagFilter buffers small 200 responses of GET and HEAD requests, sets a strong ETag computed from the body,
+// and responds 304 Not Modified if it matches If-None-Match, so polling clients do not download unchanged responses.
+// Responses with an ETag set by the handler are passed through.
+// It should be placed after the compression filter, so that the ETag is computed from the uncompressed body.
+func NewETagFilter(opts ETagOptions) Filter {
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = 64 << 10
+	}
+	return FilterFunc(func(w http.ResponseWriter, r *http.Request, next http.Handler) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ew := &etagResponseWriter{ResponseWriter: w, options: &opts}
+		next.ServeHTTP(ew, r)
+		ew.finish(r)
+	})
+}
+
+type etagResponseWriter struct {
+	http.ResponseWriter
+	options     *ETagOptions
+	code        int
+	buf         bytes.Buffer
+	passthrough bool
+}
+
+func (w *etagResponseWriter) WriteHeader(code int) {
+	if w.code != 0 {
+		return
+	}
+	w.code = code
+	if code != http.StatusOK || w.Header().Get("ETag") != "" || IsStreamingResponse(w.Header()) || w.excluded() {
+		w.passthrough = true
+		w.ResponseWriter.WriteHeader(code)
+	}
+}
+
+func (w *etagResponseWriter) excluded() bool {
+	contenttype := w.Header().Get("Content-Type")
+	return slices.ContainsFunc(w.options.ExcludedContentTypes, func(s string) bool {
+		return strings.HasPrefix(contenttype, s)
+	})
+}
+
+func (w *etagResponseWriter) Write(p []byte) (int, error) {
+	if w.code == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.passthrough {
+		return w.ResponseWriter.Write(p)
+	}
+	if w.buf.Len()+len(p) > w.options.MaxSize {
+		if err := w.pass(); err != nil {
+			return 0, err
+		}
+		return w.ResponseWriter.Write(p)
+	}
+	return w.buf.Write(p)
+}
+
+// pass gives up buffering and writes the buffered body.
+func (w *etagResponseWriter) pass() error {
+	w.passthrough = true
+	w.ResponseWriter.WriteHeader(w.code)
+	_, err := w.ResponseWriter.Write(w.buf.Bytes())
+	w.buf.Reset()
+	return err
+}
+
+// Flush passes through the response, a flushed response is streaming.
+func (w *etagResponseWriter) Flush() {
+	if w.code == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.passthrough {
+		_ = w.pass()
+	}
+	_ = http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap is used by http.ResponseController.
+func (w *etagResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *etagResponseWriter) finish(r *http.Request) {
+	if w.passthrough {
+		return
+	}
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	sum := sha256.Sum256(w.buf.Bytes())
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	w.Header().Set("ETag", etag)
+	if ETagMatch(r.Header.Get("If-None-Match"), etag) {
+		w.Header().Del("Content-Length")
+		w.ResponseWriter.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(w.buf.Len()))
+	w.ResponseWriter.WriteHeader(w.code)
+	_, _ = w.ResponseWriter.Write(w.buf.Bytes())
+}
+
+// ETagMatch reports whether the If-None-Match header matches etag, using the weak comparison.
+func ETagMatch(ifNoneMatch string, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
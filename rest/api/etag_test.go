@@ -0,0 +1,67 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestETagFilter(t *testing.T) {
+	filter := NewETagFilter(ETagOptions{MaxSize: 16, ExcludedContentTypes: []string{"image/"}})
+	handler := Filters{filter}.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/large":
+			w.Write([]byte(strings.Repeat("x", 10)))
+			w.Write([]byte(strings.Repeat("x", 10)))
+			return
+		case "/image":
+			w.Header().Set("Content-Type", "image/png")
+		case "/notfound":
+			w.WriteHeader(http.StatusNotFound)
+		}
+		w.Write([]byte("hello"))
+	}))
+	first := httptest.NewRecorder()
+	handler.ServeHTTP(first, httptest.NewRequest(http.MethodGet, "/", nil))
+	etag := first.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("no ETag set")
+	}
+
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		ifNoneMatch string
+		wantCode    int
+		wantETag    bool
+		wantBody    string
+	}{
+		{name: "no condition", method: http.MethodGet, path: "/", wantCode: http.StatusOK, wantETag: true, wantBody: "hello"},
+		{name: "matched", method: http.MethodGet, path: "/", ifNoneMatch: etag, wantCode: http.StatusNotModified, wantETag: true},
+		{name: "weak matched", method: http.MethodGet, path: "/", ifNoneMatch: `"other", W/` + etag, wantCode: http.StatusNotModified, wantETag: true},
+		{name: "not matched", method: http.MethodGet, path: "/", ifNoneMatch: `"other"`, wantCode: http.StatusOK, wantETag: true, wantBody: "hello"},
+		{name: "large", method: http.MethodGet, path: "/large", ifNoneMatch: "*", wantCode: http.StatusOK, wantBody: strings.Repeat("x", 20)},
+		{name: "excluded", method: http.MethodGet, path: "/image", ifNoneMatch: "*", wantCode: http.StatusOK, wantBody: "hello"},
+		{name: "not ok", method: http.MethodGet, path: "/notfound", ifNoneMatch: "*", wantCode: http.StatusNotFound, wantBody: "hello"},
+		{name: "not get", method: http.MethodPost, path: "/", ifNoneMatch: "*", wantCode: http.StatusOK, wantBody: "hello"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		if tt.ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", tt.ifNoneMatch)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tt.wantCode {
+			t.Errorf("%s: code = %d, want %d", tt.name, rec.Code, tt.wantCode)
+		}
+		if got := rec.Header().Get("ETag"); (got != "") != tt.wantETag || (tt.wantETag && got != etag) {
+			t.Errorf("%s: ETag = %q, want %q", tt.name, got, etag)
+		}
+		if rec.Body.String() != tt.wantBody {
+			t.Errorf("%s: body = %q, want %q", tt.name, rec.Body.String(), tt.wantBody)
+		}
+	}
+}
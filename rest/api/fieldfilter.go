@@ -0,0 +1,223 @@
+// Copyright 2023 The Kubegems Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"golang.org/x/exp/slices"
+	libreflect "kubegems.io/library/reflect"
+	"kubegems.io/library/rest/response"
+)
+
+// FieldRule restricts a response field to some roles(user groups).
+// e.g. FieldRule{Path: "data.list[*].password", Roles: []string{"admin"}}
+type FieldRule struct {
+	Path  string   `json:"path"`  // json path of the field, "*" matches all elements of a list
+	Roles []string `json:"roles"` // roles allowed to see the field
+}
+
+type FieldRules []FieldRule
+
+// Hidden returns the rules the user is not permitted to see.
+func (rules FieldRules) Hidden(user UserInfo) FieldRules {
+	hidden := FieldRules{}
+	for _, rule := range rules {
+		permitted := slices.ContainsFunc(rule.Roles, func(role string) bool {
+			return slices.Contains(user.Groups, role)
+		})
+		if !permitted {
+			hidden = append(hidden, rule)
+		}
+	}
+	return hidden
+}
+
+// PruneFields removes fields of obj the user is not permitted to see,
+// obj must be a pointer or an unstructured value (map/slice).
+func PruneFields(obj any, user UserInfo, rules FieldRules) error {
+	for _, rule := range rules.Hidden(user) {
+		if err := libreflect.DeleteFieldValue(obj, rule.Path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NewFieldFilter prunes fields from json responses by the rules and current user's groups,
+// so handlers can be shared across roles. Paths are relative to the response body,
+// e.g. "data.password" for a response written by response.OK.
+//
+// Responses which can not be pruned, e.g. not json or encoded by the handler, are refused with 500
+// instead of sent with the hidden fields, empty bodies are sent as is.
+// Flushed responses are pruned line by line, e.g. a stream of json watch events.
+func NewFieldFilter(rules FieldRules) Filter {
+	return FilterFunc(func(w http.ResponseWriter, r *http.Request, next http.Handler) {
+		hidden := rules.Hidden(AuthenticateFromContext(r.Context()).User)
+		if len(hidden) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		fw := &fieldFilterResponseWriter{ResponseWriter: w, hidden: hidden}
+		next.ServeHTTP(fw, r)
+		fw.finish()
+	})
+}
+
+var _ http.Flusher = &fieldFilterResponseWriter{}
+
+// fieldFilterResponseWriter holds the response until it is pruned.
+type fieldFilterResponseWriter struct {
+	http.ResponseWriter
+	hidden    FieldRules
+	code      int
+	buf       bytes.Buffer
+	streaming bool  // flushed, complete lines are pruned and written on each flush
+	err       error // the response can not be pruned, the rest is dropped
+}
+
+func (w *fieldFilterResponseWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+}
+
+func (w *fieldFilterResponseWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	return w.buf.Write(p)
+}
+
+// Flush writes the complete lines in buffer pruned and flushes the underlying writer.
+func (w *fieldFilterResponseWriter) Flush() {
+	if w.err != nil {
+		return
+	}
+	if !w.streaming {
+		if err := w.prunable(); err != nil {
+			w.fail(err)
+			return
+		}
+		w.streaming = true
+		w.Header().Del("Content-Length")
+		w.ResponseWriter.WriteHeader(w.statusCode())
+	}
+	data := w.buf.Bytes()
+	if i := bytes.LastIndexByte(data, '\n'); i >= 0 {
+		if err := w.writeLines(data[:i+1]); err != nil {
+			return
+		}
+		w.buf.Next(i + 1)
+	}
+	_ = http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *fieldFilterResponseWriter) finish() {
+	if w.err != nil {
+		return
+	}
+	body := w.buf.Bytes()
+	if w.streaming {
+		_ = w.writeLines(body)
+		return
+	}
+	if len(bytes.TrimSpace(body)) > 0 {
+		if err := w.prunable(); err != nil {
+			w.fail(err)
+			return
+		}
+		pruned, err := w.prune(body)
+		if err != nil {
+			w.fail(err)
+			return
+		}
+		body = pruned
+	}
+	if w.Header().Get("Content-Length") != "" {
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	w.ResponseWriter.WriteHeader(w.statusCode())
+	_, _ = w.ResponseWriter.Write(body)
+}
+
+// writeLines prunes each line of data, a line can not be pruned drops the rest of the stream.
+func (w *fieldFilterResponseWriter) writeLines(data []byte) error {
+	for _, line := range bytes.SplitAfter(data, []byte("\n")) {
+		content := bytes.TrimSpace(line)
+		if len(content) == 0 {
+			_, _ = w.ResponseWriter.Write(line)
+			continue
+		}
+		pruned, err := w.prune(content)
+		if err != nil {
+			w.err = err
+			return err
+		}
+		if bytes.HasSuffix(line, []byte("\n")) {
+			pruned = append(pruned, '\n')
+		}
+		_, _ = w.ResponseWriter.Write(pruned)
+	}
+	return nil
+}
+
+// prunable returns an error if the response is not plain json.
+func (w *fieldFilterResponseWriter) prunable() error {
+	if encoding := w.Header().Get("Content-Encoding"); encoding != "" && encoding != "identity" {
+		return fmt.Errorf("field filter: can not prune response encoded with %s", encoding)
+	}
+	mediatype, _, _ := mime.ParseMediaType(w.Header().Get("Content-Type"))
+	if mediatype != "application/json" && mediatype != "application/x-ndjson" && !strings.HasSuffix(mediatype, "+json") {
+		return fmt.Errorf("field filter: can not prune response of content type %q", mediatype)
+	}
+	return nil
+}
+
+func (w *fieldFilterResponseWriter) prune(data []byte) ([]byte, error) {
+	var obj any
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber() // keep large integers
+	if err := decoder.Decode(&obj); err != nil {
+		return nil, fmt.Errorf("field filter: %w", err)
+	}
+	for _, rule := range w.hidden {
+		if err := libreflect.DeleteFieldValue(&obj, rule.Path); err != nil {
+			return nil, fmt.Errorf("field filter: %s: %w", rule.Path, err)
+		}
+	}
+	return json.Marshal(obj)
+}
+
+func (w *fieldFilterResponseWriter) fail(err error) {
+	w.err = err
+	for _, k := range []string{"Content-Encoding", "Content-Length", "Content-Type", "Etag"} {
+		w.Header().Del(k)
+	}
+	response.Error(w.ResponseWriter, response.NewStatusError(http.StatusInternalServerError, err))
+}
+
+func (w *fieldFilterResponseWriter) statusCode() int {
+	if w.code == 0 {
+		return http.StatusOK
+	}
+	return w.code
+}
@@ -0,0 +1,124 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFieldFilter(t *testing.T) {
+	rules := FieldRules{{Path: "items[*].password", Roles: []string{"admin"}}}
+	tests := []struct {
+		name        string
+		groups      []string
+		contenttype string
+		encoding    string
+		code        int
+		body        []string // written with a flush between
+		wantCode    int
+		wantBody    string
+		wantFlushed bool
+	}{
+		{
+			name:        "pruned",
+			contenttype: "application/json",
+			body:        []string{`{"items":[{"name":"a","password":"p","id":9007199254740993}]}`},
+			wantCode:    http.StatusOK,
+			wantBody:    `{"items":[{"id":9007199254740993,"name":"a"}]}`,
+		},
+		{
+			name:        "permitted",
+			groups:      []string{"admin"},
+			contenttype: "text/plain",
+			body:        []string{"password=p"},
+			wantCode:    http.StatusOK,
+			wantBody:    "password=p",
+		},
+		{
+			name:        "status kept",
+			contenttype: "application/problem+json",
+			code:        http.StatusConflict,
+			body:        []string{`{"items":[{"password":"p"}]}`},
+			wantCode:    http.StatusConflict,
+			wantBody:    `{"items":[{}]}`,
+		},
+		{
+			name:     "empty body",
+			code:     http.StatusNoContent,
+			wantCode: http.StatusNoContent,
+		},
+		{
+			name:        "not json",
+			contenttype: "text/csv",
+			body:        []string{"name,password\na,p\n"},
+			wantCode:    http.StatusInternalServerError,
+		},
+		{
+			name:        "encoded",
+			contenttype: "application/json",
+			encoding:    "gzip",
+			body:        []string{"\x1f\x8b"},
+			wantCode:    http.StatusInternalServerError,
+		},
+		{
+			name:        "invalid json",
+			contenttype: "application/json",
+			body:        []string{`{"items":[{"password":"p"`},
+			wantCode:    http.StatusInternalServerError,
+		},
+		{
+			name:        "stream",
+			contenttype: "application/json",
+			body:        []string{`{"items":[{"password":"p1"}]}` + "\n", `{"items":[{"password":"p2"}]}` + "\n"},
+			wantCode:    http.StatusOK,
+			wantBody:    `{"items":[{}]}` + "\n" + `{"items":[{}]}` + "\n",
+			wantFlushed: true,
+		},
+		{
+			name:        "stream not json",
+			contenttype: "text/event-stream",
+			body:        []string{"data: {\"password\":\"p\"}\n\n", "data: {}\n\n"},
+			wantCode:    http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := Filters{NewFieldFilter(rules)}.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.contenttype != "" {
+					w.Header().Set("Content-Type", tt.contenttype)
+				}
+				if tt.encoding != "" {
+					w.Header().Set("Content-Encoding", tt.encoding)
+				}
+				if tt.code != 0 {
+					w.WriteHeader(tt.code)
+				}
+				for i, chunk := range tt.body {
+					if i > 0 {
+						w.(http.Flusher).Flush()
+					}
+					w.Write([]byte(chunk))
+				}
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req = req.WithContext(WithAuthenticate(req.Context(), AuthenticateInfo{User: UserInfo{Name: "bob", Groups: tt.groups}}))
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.wantCode {
+				t.Fatalf("code = %d, want %d: %s", rec.Code, tt.wantCode, rec.Body.String())
+			}
+			if tt.wantCode == http.StatusInternalServerError {
+				if rec.Header().Get("Content-Encoding") != "" || rec.Body.Len() == 0 {
+					t.Errorf("error response = %v %q", rec.Header(), rec.Body.String())
+				}
+				return
+			}
+			if got := rec.Body.String(); got != tt.wantBody {
+				t.Errorf("body = %s, want %s", got, tt.wantBody)
+			}
+			if rec.Flushed != tt.wantFlushed {
+				t.Errorf("flushed = %v, want %v", rec.Flushed, tt.wantFlushed)
+			}
+		})
+	}
+}
@@ -18,12 +18,14 @@ import (
 	"compress/flate"
 	"compress/gzip"
 	"io"
+	"mime"
 	"net/http"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/go-logr/logr"
+	"golang.org/x/exp/slices"
 )
 
 type Filter interface {
@@ -61,6 +63,51 @@ func (fs Filters) Process(w http.ResponseWriter, r *http.Request, next http.Hand
 	}))
 }
 
+// FilterPhase orders the filters registered to PhasedFilters, filters of an earlier phase run first.
+type FilterPhase int
+
+const (
+	FilterPhasePreAuth    FilterPhase = 100 // e.g. recovery, request id, logging, tracing
+	FilterPhaseAuth       FilterPhase = 200 // authentication and authorization
+	FilterPhasePostAuth   FilterPhase = 300 // filters depend on the user, e.g. audit, quota
+	FilterPhasePreHandler FilterPhase = 400 // e.g. caching, idempotency
+)
+
+type phasedFilter struct {
+	phase    FilterPhase
+	priority int
+	filter   Filter
+}
+
+// PhasedFilters composes filters registered from different places, e.g. plugins, in a deterministic order:
+// by phase, then by priority (lower first), then by registration order.
+type PhasedFilters struct {
+	filters []phasedFilter
+}
+
+func (p *PhasedFilters) Register(phase FilterPhase, priority int, filters ...Filter) *PhasedFilters {
+	for _, filter := range filters {
+		p.filters = append(p.filters, phasedFilter{phase: phase, priority: priority, filter: filter})
+	}
+	return p
+}
+
+// Filters returns the registered filters in order.
+func (p *PhasedFilters) Filters() Filters {
+	sorted := slices.Clone(p.filters)
+	slices.SortStableFunc(sorted, func(a, b phasedFilter) int {
+		if a.phase != b.phase {
+			return int(a.phase) - int(b.phase)
+		}
+		return a.priority - b.priority
+	})
+	filters := make(Filters, len(sorted))
+	for i, f := range sorted {
+		filters[i] = f.filter
+	}
+	return filters
+}
+
 func CORSFilter() Filter {
 	return FilterFunc(func(w http.ResponseWriter, r *http.Request, next http.Handler) {
 		orgin := r.Header.Get("Origin")
@@ -84,13 +131,22 @@ func NoopFilter() Filter {
 func LoggingFilter(log logr.Logger) Filter {
 	return FilterFunc(func(w http.ResponseWriter, r *http.Request, next http.Handler) {
 		start := time.Now()
-		next.ServeHTTP(w, r)
+		sw := &StatusResponseWriter{Inner: w}
+		next.ServeHTTP(sw, r)
 		reqpath := r.URL.Path
 		i := strings.Index(reqpath, "?")
 		if i != -1 {
 			reqpath = reqpath[:i]
 		}
-		log.Info(reqpath, "method", r.Method, "remote", r.RemoteAddr, "duration", time.Since(start).String())
+		code := sw.Code
+		if code == 0 {
+			code = http.StatusOK
+		}
+		kvs := []any{"method", r.Method, "remote", r.RemoteAddr, "code", code, "size", sw.Written, "duration", time.Since(start).String()}
+		if id := RequestIDFromContext(r.Context()); id != "" {
+			kvs = append(kvs, "requestID", id)
+		}
+		log.Info(reqpath, kvs...)
 	})
 }
 
@@ -115,7 +171,15 @@ func NewCompressionFilter() Filter {
 		},
 	}
 	return FilterFunc(func(w http.ResponseWriter, r *http.Request, next http.Handler) {
-		var wrappedWriter io.Writer
+		if IsStreamingRequest(r) {
+			// usually proxied, keep the response as is
+			next.ServeHTTP(w, r)
+			return
+		}
+		var compressor interface {
+			io.Writer
+			Close() error
+		}
 		encoding := r.Header.Get("Accept-Encoding")
 		accept := ""
 		for len(encoding) > 0 {
@@ -132,32 +196,46 @@ func NewCompressionFilter() Filter {
 				break
 			}
 		}
+		sw := &StatusResponseWriter{Inner: w}
 		switch accept {
 		case "gzip":
-			w.Header().Set("Content-Encoding", "gzip")
-			w.Header().Add("Vary", "Accept-Encoding")
-
 			gw := gzipPool.Get().(*gzip.Writer)
-			gw.Reset(w)
+			gw.Reset(writerOnly{w})
 			defer gzipPool.Put(gw)
-
-			wrappedWriter = gw
+			compressor = gw
 		case "deflate":
-			w.Header().Set("Content-Encoding", "deflate")
-			w.Header().Add("Vary", "Accept-Encoding")
 			fw := flatePool.Get().(*flate.Writer)
-			fw.Reset(w)
+			fw.Reset(writerOnly{w})
 			defer flatePool.Put(fw)
-
-			wrappedWriter = fw
+			compressor = fw
 		}
-		if wrappedWriter != nil {
-			w = &CompresseWriter{ResponseWriter: w, w: wrappedWriter}
+		if compressor == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		sw.Body = compressor
+		sw.OnWriteHeader = func(code int) {
+			// passes through the response encoded by the handler, e.g. pre-compressed static content
+			if !bodyAllowedForStatus(code) || w.Header().Get("Content-Encoding") != "" {
+				sw.Body = nil
+				return
+			}
+			w.Header().Set("Content-Encoding", accept)
+			w.Header().Add("Vary", "Accept-Encoding")
+			// the length changes after compression
+			w.Header().Del("Content-Length")
+		}
+		next.ServeHTTP(sw, r)
+		if sw.Body != nil {
+			if sw.Code == 0 {
+				sw.WriteHeader(http.StatusOK)
+			}
+			_ = compressor.Close()
 		}
-		next.ServeHTTP(w, r)
 	})
 }
 
+// Deprecated: use StatusResponseWriter with Body set to the compressor.
 type CompresseWriter struct {
 	http.ResponseWriter
 	w io.Writer
@@ -172,6 +250,16 @@ func (cw *CompresseWriter) Flush() {
 	}
 }
 
+func bodyAllowedForStatus(code int) bool {
+	switch {
+	case code >= 100 && code <= 199:
+		return false
+	case code == http.StatusNoContent, code == http.StatusNotModified:
+		return false
+	}
+	return true
+}
+
 func NewConditionFilter(cond func(r *http.Request) bool, filter Filter) Filter {
 	return FilterFunc(func(w http.ResponseWriter, r *http.Request, next http.Handler) {
 		if cond(r) {
@@ -181,3 +269,34 @@ func NewConditionFilter(cond func(r *http.Request) bool, filter Filter) Filter {
 		}
 	})
 }
+
+// StreamingBodyThreshold is the content length above which a request body is considered streaming.
+var StreamingBodyThreshold int64 = 8 << 20
+
+// IsStreamingRequest reports whether the request body should be passed through without buffering,
+// e.g. large multipart uploads, bodies with unknown length, or "Expect: 100-continue" requests
+// which must not be read before the handler decides to accept it.
+func IsStreamingRequest(r *http.Request) bool {
+	if r.Body == nil || r.Body == http.NoBody {
+		return false
+	}
+	if r.ContentLength < 0 || r.ContentLength > StreamingBodyThreshold {
+		return true
+	}
+	if strings.EqualFold(r.Header.Get("Expect"), "100-continue") {
+		return true
+	}
+	mediatype, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return strings.HasPrefix(mediatype, "multipart/") || mediatype == "application/octet-stream"
+}
+
+// IsStreamingResponse reports whether the response is streamed by its content type,
+// e.g. server-sent events, which should not be cached.
+func IsStreamingResponse(header http.Header) bool {
+	mediatype, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	switch mediatype {
+	case "text/event-stream", "application/x-ndjson", "application/octet-stream":
+		return true
+	}
+	return strings.HasPrefix(mediatype, "multipart/")
+}
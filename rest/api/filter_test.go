@@ -0,0 +1,83 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/exp/slices"
+)
+
+func TestPhasedFilters(t *testing.T) {
+	trace := func(name string) Filter {
+		return FilterFunc(func(w http.ResponseWriter, r *http.Request, next http.Handler) {
+			w.Header().Add("X-Trace", name)
+			next.ServeHTTP(w, r)
+		})
+	}
+	filters := (&PhasedFilters{}).
+		Register(FilterPhasePostAuth, 0, trace("audit")).
+		Register(FilterPhaseAuth, 10, trace("authorization")).
+		Register(FilterPhaseAuth, 0, trace("authentication")).
+		Register(FilterPhasePreHandler, 0, trace("cache")).
+		Register(FilterPhasePreAuth, 0, trace("recovery"), trace("request id")).
+		Register(FilterPhasePostAuth, 0, trace("quota")).
+		Filters()
+
+	rec := httptest.NewRecorder()
+	filters.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	want := []string{"recovery", "request id", "authentication", "authorization", "audit", "quota", "cache"}
+	if got := rec.Header().Values("X-Trace"); !slices.Equal(got, want) {
+		t.Errorf("filters run %v, want %v", got, want)
+	}
+}
+
+func TestIsStreamingRequest(t *testing.T) {
+	tests := []struct {
+		name          string
+		body          io.Reader
+		contentlength int64
+		header        map[string]string
+		want          bool
+	}{
+		{name: "no body"},
+		{name: "small json", body: strings.NewReader(`{}`), contentlength: 2, header: map[string]string{"Content-Type": "application/json"}},
+		{name: "large body", body: strings.NewReader(""), contentlength: StreamingBodyThreshold + 1, want: true},
+		{name: "unknown length", body: strings.NewReader("chunked"), contentlength: -1, want: true},
+		{name: "expect continue", body: strings.NewReader(`{}`), contentlength: 2, header: map[string]string{"Expect": "100-Continue"}, want: true},
+		{name: "multipart", body: strings.NewReader("--b--"), contentlength: 5, header: map[string]string{"Content-Type": "multipart/form-data; boundary=b"}, want: true},
+		{name: "octet stream", body: strings.NewReader("bin"), contentlength: 3, header: map[string]string{"Content-Type": "application/octet-stream"}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/upload", tt.body)
+			req.ContentLength = tt.contentlength
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			if got := IsStreamingRequest(req); got != tt.want {
+				t.Errorf("IsStreamingRequest() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsStreamingResponse(t *testing.T) {
+	tests := []struct {
+		contenttype string
+		want        bool
+	}{
+		{contenttype: "text/event-stream", want: true},
+		{contenttype: "application/x-ndjson; charset=utf-8", want: true},
+		{contenttype: "multipart/x-mixed-replace; boundary=frame", want: true},
+		{contenttype: "application/json"},
+		{contenttype: ""},
+	}
+	for _, tt := range tests {
+		if got := IsStreamingResponse(http.Header{"Content-Type": []string{tt.contenttype}}); got != tt.want {
+			t.Errorf("IsStreamingResponse(%q) = %v, want %v", tt.contenttype, got, tt.want)
+		}
+	}
+}
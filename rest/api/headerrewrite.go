@@ -0,0 +1,129 @@
+// Copyright 2023 The Kubegems Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"golang.org/x/exp/slices"
+	"kubegems.io/library/rest/matcher"
+)
+
+// HeaderActions modifies headers in the order of Remove, Set and Add.
+// Values can refer to the path variables of the rule pattern, e.g. "{tenant}".
+type HeaderActions struct {
+	Remove []string          `json:"remove,omitempty"`
+	Set    map[string]string `json:"set,omitempty"`
+	Add    map[string]string `json:"add,omitempty"`
+}
+
+type HeaderRule struct {
+	Pattern  string        `json:"pattern,omitempty" description:"route pattern of package matcher, e.g. /tenants/{tenant}/*, empty matches all"`
+	Methods  []string      `json:"methods,omitempty" description:"empty matches all"`
+	Request  HeaderActions `json:"request,omitempty"`
+	Response HeaderActions `json:"response,omitempty"`
+}
+
+type headerRule struct {
+	HeaderRule
+	tree *matcher.Node[bool] // nil matches all
+}
+
+// NewHeaderRewriteFilter applies the header rules to the matched requests and their responses,
+// e.g. strips internal headers or injects tenant headers from the path.
+// Rules are applied in order, a later rule sees the changes of the former ones.
+func NewHeaderRewriteFilter(rules []HeaderRule) (Filter, error) {
+	compiled := make([]headerRule, len(rules))
+	for i, rule := range rules {
+		compiled[i].HeaderRule = rule
+		if rule.Pattern == "" {
+			continue
+		}
+		tree := &matcher.Node[bool]{}
+		if _, err := tree.Register(rule.Pattern, true); err != nil {
+			return nil, fmt.Errorf("header rule %d pattern %s: %w", i, rule.Pattern, err)
+		}
+		compiled[i].tree = tree
+	}
+	return FilterFunc(func(w http.ResponseWriter, r *http.Request, next http.Handler) {
+		matchpath := r.URL.Path
+		if r.URL.RawPath != "" {
+			matchpath = r.URL.RawPath
+		}
+		type matchedRule struct {
+			rule *headerRule
+			vars []matcher.MatchVar
+		}
+		matched := []matchedRule{}
+		for i := range compiled {
+			rule := &compiled[i]
+			if len(rule.Methods) > 0 && !slices.Contains(rule.Methods, r.Method) {
+				continue
+			}
+			if rule.tree == nil {
+				matched = append(matched, matchedRule{rule: rule})
+				continue
+			}
+			if node, vars := rule.tree.Match(matchpath, func(val bool) bool { return val }); node != nil {
+				matched = append(matched, matchedRule{rule: rule, vars: vars})
+			}
+		}
+		if len(matched) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		for _, m := range matched {
+			m.rule.Request.apply(r.Header, m.vars)
+		}
+		applied := false
+		apply := func(code int) {
+			if applied || (code >= 100 && code < 200) {
+				return
+			}
+			applied = true
+			for _, m := range matched {
+				m.rule.Response.apply(w.Header(), m.vars)
+			}
+		}
+		next.ServeHTTP(&StatusResponseWriter{Inner: w, OnWriteHeader: apply}, r)
+		// nothing written, the header is written after return
+		apply(http.StatusOK)
+	}), nil
+}
+
+func (a HeaderActions) apply(header http.Header, vars []matcher.MatchVar) {
+	for _, k := range a.Remove {
+		header.Del(k)
+	}
+	for k, v := range a.Set {
+		header.Set(k, expandPathVars(v, vars))
+	}
+	for k, v := range a.Add {
+		header.Add(k, expandPathVars(v, vars))
+	}
+}
+
+// expandPathVars replaces "{name}" in s with the value of path variable name.
+func expandPathVars(s string, vars []matcher.MatchVar) string {
+	if !strings.Contains(s, "{") {
+		return s
+	}
+	for _, v := range vars {
+		s = strings.ReplaceAll(s, "{"+v.Name+"}", v.Value)
+	}
+	return s
+}
@@ -0,0 +1,87 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/exp/slices"
+)
+
+func TestHeaderRewriteFilter(t *testing.T) {
+	filter, err := NewHeaderRewriteFilter([]HeaderRule{
+		{
+			Request:  HeaderActions{Remove: []string{"X-Internal"}},
+			Response: HeaderActions{Remove: []string{"Server"}},
+		},
+		{
+			Pattern:  "/tenants/{tenant}/*",
+			Request:  HeaderActions{Set: map[string]string{"X-Tenant": "{tenant}"}},
+			Response: HeaderActions{Add: map[string]string{"X-Served-Tenant": "{tenant}"}},
+		},
+		{
+			Pattern: "/tenants/{tenant}/*",
+			Methods: []string{http.MethodDelete},
+			Request: HeaderActions{Set: map[string]string{"X-Danger": "true"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name         string
+		method       string
+		path         string
+		wantRequest  http.Header
+		wantResponse http.Header
+	}{
+		{
+			name:         "global rule",
+			method:       http.MethodGet,
+			path:         "/users",
+			wantRequest:  http.Header{"X-Internal": nil, "X-Tenant": {"spoofed"}},
+			wantResponse: http.Header{"Server": nil, "X-Served-Tenant": nil},
+		},
+		{
+			name:         "path variables",
+			method:       http.MethodGet,
+			path:         "/tenants/t1/projects",
+			wantRequest:  http.Header{"X-Tenant": {"t1"}, "X-Danger": nil},
+			wantResponse: http.Header{"X-Served-Tenant": {"t1"}},
+		},
+		{
+			name:        "method",
+			method:      http.MethodDelete,
+			path:        "/tenants/t1/projects/p1",
+			wantRequest: http.Header{"X-Tenant": {"t1"}, "X-Danger": {"true"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var reqheader http.Header
+			handler := Filters{filter}.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				reqheader = r.Header.Clone()
+				w.Header().Set("Server", "internal")
+				w.Write([]byte("ok"))
+			}))
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.Header.Set("X-Internal", "secret")
+			req.Header.Set("X-Tenant", "spoofed")
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			for k, want := range tt.wantRequest {
+				if got := reqheader.Values(k); !slices.Equal(got, want) {
+					t.Errorf("request header %s = %v, want %v", k, got, want)
+				}
+			}
+			for k, want := range tt.wantResponse {
+				if got := rec.Header().Values(k); !slices.Equal(got, want) {
+					t.Errorf("response header %s = %v, want %v", k, got, want)
+				}
+			}
+		})
+	}
+	if _, err := NewHeaderRewriteFilter([]HeaderRule{{Pattern: "/{invalid"}}); err == nil {
+		t.Errorf("invalid pattern is accepted")
+	}
+}
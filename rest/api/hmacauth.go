@@ -0,0 +1,278 @@
+// Copyright 2023 The Kubegems Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	HMACAlgorithm           = "HMAC-SHA256"
+	HeaderHMACDate          = "X-Date"
+	HeaderHMACContentSHA256 = "X-Content-Sha256"
+	HMACDateFormat          = "20060102T150405Z"
+)
+
+type HMACCredential struct {
+	Secret string
+	User   UserInfo
+}
+
+type HMACCredentialStore interface {
+	// Credential returns the credential of the key id, or an error if not found.
+	Credential(ctx context.Context, keyID string) (*HMACCredential, error)
+}
+
+// StaticHMACCredentials are credentials indexed by key id.
+type StaticHMACCredentials map[string]HMACCredential
+
+func (s StaticHMACCredentials) Credential(ctx context.Context, keyID string) (*HMACCredential, error) {
+	credential, ok := s[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %s", keyID)
+	}
+	return &credential, nil
+}
+
+// CanonicalRequestFunc builds the canonical form of r to sign, bodyHash is the hex encoded sha256 of the body.
+type CanonicalRequestFunc func(r *http.Request, signedHeaders []string, bodyHash string) string
+
+// CanonicalRequest is the default canonicalization:
+//
+//	METHOD\nPATH\nSORTED QUERY\nheader:value\n...\n\nheader;...\nBODY HASH
+func CanonicalRequest(r *http.Request, signedHeaders []string, bodyHash string) string {
+	sb := &strings.Builder{}
+	sb.WriteString(r.Method + "\n")
+	sb.WriteString(r.URL.EscapedPath() + "\n")
+	sb.WriteString(canonicalQuery(r.URL.Query()) + "\n")
+	for _, name := range signedHeaders {
+		value := r.Header.Get(name)
+		if name == "host" {
+			value = r.Host
+		}
+		sb.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+	sb.WriteString("\n" + strings.Join(signedHeaders, ";") + "\n")
+	sb.WriteString(bodyHash)
+	return sb.String()
+}
+
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := []string{}
+	for _, k := range keys {
+		values := append([]string{}, query[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, url.QueryEscape(k)+"="+url.QueryEscape(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func hmacSignature(secret, date, canonical string) string {
+	sum := sha256.Sum256([]byte(canonical))
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(HMACAlgorithm + "\n" + date + "\n" + hex.EncodeToString(sum[:])))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignHMACRequest signs r for HMACAuthenticator, the body is read and restored.
+// canonical can be nil to use CanonicalRequest, it must match the one of the server.
+func SignHMACRequest(r *http.Request, keyID, secret string, canonical CanonicalRequestFunc) error {
+	if canonical == nil {
+		canonical = CanonicalRequest
+	}
+	body := []byte{}
+	if r.Body != nil {
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+		r.Body.Close()
+		r.Body, body = io.NopCloser(bytes.NewReader(data)), data
+	}
+	sum := sha256.Sum256(body)
+	bodyhash := hex.EncodeToString(sum[:])
+	date := time.Now().UTC().Format(HMACDateFormat)
+	r.Header.Set(HeaderHMACDate, date)
+	r.Header.Set(HeaderHMACContentSHA256, bodyhash)
+	signed := []string{"host", strings.ToLower(HeaderHMACContentSHA256), strings.ToLower(HeaderHMACDate)}
+	signature := hmacSignature(secret, date, canonical(r, signed, bodyhash))
+	r.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s, SignedHeaders=%s, Signature=%s",
+		HMACAlgorithm, keyID, strings.Join(signed, ";"), signature))
+	return nil
+}
+
+var _ HTTPAuthenticator = &HMACAuthenticator{}
+
+// HMACAuthenticator authenticates requests signed by SignHMACRequest with a shared secret,
+// for machine-to-machine callers that can't use OIDC. The Authorization header is:
+//
+//	HMAC-SHA256 Credential=<key id>, SignedHeaders=host;x-content-sha256;x-date, Signature=<hex>
+//
+// Requests older than MaxSkew are refused and a signature is accepted only once within MaxSkew.
+type HMACAuthenticator struct {
+	Credentials HMACCredentialStore
+	Canonical   CanonicalRequestFunc // default CanonicalRequest
+	MaxSkew     time.Duration        // default 5m
+	MaxBodySize int64                // default 10MB
+	Seen        Cache[bool]          // accepted signatures, nil disables replay protection
+
+	mu sync.Mutex
+}
+
+func NewHMACAuthenticator(credentials HMACCredentialStore) *HMACAuthenticator {
+	return &HMACAuthenticator{
+		Credentials: credentials,
+		Canonical:   CanonicalRequest,
+		MaxSkew:     5 * time.Minute,
+		MaxBodySize: 10 << 20,
+		Seen:        NewLRUCache[bool](100000, 10*time.Minute), // twice of MaxSkew, a signature is valid both before and after now
+	}
+}
+
+type hmacAuthorization struct {
+	keyID         string
+	signedHeaders []string
+	signature     string
+}
+
+func parseHMACAuthorization(header string) (*hmacAuthorization, error) {
+	algorithm, params, ok := strings.Cut(header, " ")
+	if !ok || algorithm != HMACAlgorithm {
+		return nil, fmt.Errorf("hmac: unsupported authorization")
+	}
+	auth := &hmacAuthorization{}
+	for _, param := range strings.Split(params, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(param), "=")
+		switch k {
+		case "Credential":
+			auth.keyID = v
+		case "SignedHeaders":
+			auth.signedHeaders = strings.Split(strings.ToLower(v), ";")
+		case "Signature":
+			auth.signature = v
+		}
+	}
+	if auth.keyID == "" || auth.signature == "" || len(auth.signedHeaders) == 0 {
+		return nil, fmt.Errorf("hmac: incomplete authorization")
+	}
+	// the date and body must be signed, or the signature can be replayed with another body
+	for _, required := range []string{strings.ToLower(HeaderHMACDate), strings.ToLower(HeaderHMACContentSHA256)} {
+		found := false
+		for _, name := range auth.signedHeaders {
+			found = found || name == required
+		}
+		if !found {
+			return nil, fmt.Errorf("hmac: header %s must be signed", required)
+		}
+	}
+	return auth, nil
+}
+
+func (a *HMACAuthenticator) Authenticate(ctx context.Context, r *http.Request) (*AuthenticateInfo, error) {
+	auth, err := parseHMACAuthorization(r.Header.Get("Authorization"))
+	if err != nil {
+		return nil, err
+	}
+	date := r.Header.Get(HeaderHMACDate)
+	signedAt, err := time.Parse(HMACDateFormat, date)
+	if err != nil {
+		return nil, fmt.Errorf("hmac: invalid date %q", date)
+	}
+	maxskew := a.MaxSkew
+	if maxskew <= 0 {
+		maxskew = 5 * time.Minute
+	}
+	if skew := time.Since(signedAt); skew > maxskew || skew < -maxskew {
+		return nil, fmt.Errorf("hmac: request date %s out of range", date)
+	}
+	bodyhash, err := a.bodyHash(r)
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal([]byte(bodyhash), []byte(r.Header.Get(HeaderHMACContentSHA256))) {
+		return nil, fmt.Errorf("hmac: body hash mismatch")
+	}
+	credential, err := a.Credentials.Credential(ctx, auth.keyID)
+	if err != nil {
+		return nil, fmt.Errorf("hmac: %w", err)
+	}
+	canonical := a.Canonical
+	if canonical == nil {
+		canonical = CanonicalRequest
+	}
+	expected := hmacSignature(credential.Secret, date, canonical(r, auth.signedHeaders, bodyhash))
+	if !hmac.Equal([]byte(expected), []byte(auth.signature)) {
+		return nil, fmt.Errorf("hmac: signature mismatch")
+	}
+	if err := a.checkReplay(ctx, auth.signature); err != nil {
+		return nil, err
+	}
+	return &AuthenticateInfo{User: credential.User}, nil
+}
+
+func (a *HMACAuthenticator) checkReplay(ctx context.Context, signature string) error {
+	if a.Seen == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, seen := a.Seen.Get(ctx, signature); seen {
+		return fmt.Errorf("hmac: replayed request")
+	}
+	a.Seen.Add(ctx, signature, true)
+	return nil
+}
+
+// bodyHash reads the body to hash and restores it for the handler.
+func (a *HMACAuthenticator) bodyHash(r *http.Request) (string, error) {
+	hash := sha256.New()
+	if r.Body == nil || r.Body == http.NoBody {
+		return hex.EncodeToString(hash.Sum(nil)), nil
+	}
+	maxsize := a.MaxBodySize
+	if maxsize <= 0 {
+		maxsize = 10 << 20
+	}
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxsize+1))
+	if err != nil {
+		return "", err
+	}
+	if int64(len(data)) > maxsize {
+		return "", fmt.Errorf("hmac: body too large")
+	}
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(data))
+	hash.Write(data)
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,90 @@
+package api
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHMACAuthenticator(t *testing.T) {
+	credentials := StaticHMACCredentials{"ci": {Secret: "s3cret", User: UserInfo{Name: "ci-bot"}}}
+	newSigned := func(keyID, secret string) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/apis/v1/builds?b=2&a=1", strings.NewReader(`{"name":"foo"}`))
+		if err := SignHMACRequest(req, keyID, secret, nil); err != nil {
+			t.Fatal(err)
+		}
+		return req
+	}
+	tests := []struct {
+		name    string
+		req     func() *http.Request
+		wantErr bool
+	}{
+		{name: "valid", req: func() *http.Request { return newSigned("ci", "s3cret") }},
+		{name: "wrong secret", req: func() *http.Request { return newSigned("ci", "wrong") }, wantErr: true},
+		{name: "unknown key", req: func() *http.Request { return newSigned("other", "s3cret") }, wantErr: true},
+		{
+			name: "body tampered",
+			req: func() *http.Request {
+				req := newSigned("ci", "s3cret")
+				req.Body = io.NopCloser(strings.NewReader(`{"name":"bar"}`))
+				return req
+			},
+			wantErr: true,
+		},
+		{
+			name: "path tampered",
+			req: func() *http.Request {
+				req := newSigned("ci", "s3cret")
+				req.URL.Path = "/apis/v1/admin"
+				return req
+			},
+			wantErr: true,
+		},
+		{
+			name: "expired",
+			req: func() *http.Request {
+				req := newSigned("ci", "s3cret")
+				req.Header.Set(HeaderHMACDate, time.Now().Add(-time.Hour).UTC().Format(HMACDateFormat))
+				return req
+			},
+			wantErr: true,
+		},
+		{
+			name: "date not signed",
+			req: func() *http.Request {
+				req := newSigned("ci", "s3cret")
+				req.Header.Set("Authorization", strings.Replace(req.Header.Get("Authorization"), ";x-date", "", 1))
+				return req
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authenticator := NewHMACAuthenticator(credentials)
+			req := tt.req()
+			info, err := authenticator.Authenticate(context.Background(), req)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Authenticate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if info.User.Name != "ci-bot" {
+				t.Errorf("user = %s, want ci-bot", info.User.Name)
+			}
+			if body, _ := io.ReadAll(req.Body); string(body) != `{"name":"foo"}` {
+				t.Errorf("body not restored: %s", body)
+			}
+			req.Body = io.NopCloser(strings.NewReader(`{"name":"foo"}`))
+			if _, err := authenticator.Authenticate(context.Background(), req); err == nil || !strings.Contains(err.Error(), "replayed") {
+				t.Errorf("replayed request error = %v, want replayed", err)
+			}
+		})
+	}
+}
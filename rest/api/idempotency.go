@@ -0,0 +1,260 @@
+// Copyright 2023 The Kubegems Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/slices"
+	"kubegems.io/library/rest/response"
+)
+
+const (
+	HeaderIdempotencyKey     = "Idempotency-Key"
+	HeaderIdempotentReplayed = "Idempotent-Replayed"
+)
+
+// ErrIdempotencyKeyInUse is returned by IdempotencyStore.Lock when the key is held by a request in progress.
+var ErrIdempotencyKeyInUse = errors.New("idempotency key in use")
+
+// IdempotentResponse is the stored response of an idempotency key, Header contains only the headers set by the handler.
+type IdempotentResponse struct {
+	Method   string      `json:"method"`
+	URI      string      `json:"uri"`
+	BodyHash string      `json:"bodyHash"` // hex sha256 of the request body
+	Code     int         `json:"code"`
+	Header   http.Header `json:"header,omitempty"`
+	Body     []byte      `json:"body,omitempty"`
+}
+
+// IdempotencyStore keeps the responses of idempotency keys, implementations can be shared between replicas, e.g. redis.
+type IdempotencyStore interface {
+	// Lock reserves key for ttl, returns the stored response if the key has completed,
+	// or ErrIdempotencyKeyInUse if the key is held by another request.
+	Lock(ctx context.Context, key string, ttl time.Duration) (*IdempotentResponse, error)
+	// Save stores the response of a locked key for ttl and releases the lock.
+	Save(ctx context.Context, key string, resp *IdempotentResponse, ttl time.Duration) error
+	// Unlock releases a locked key without response, so the request can be retried.
+	Unlock(ctx context.Context, key string) error
+}
+
+type IdempotencyOptions struct {
+	Store   IdempotencyStore // default is a MemoryIdempotencyStore
+	TTL     time.Duration    // how long the responses are replayed, default 24h
+	LockTTL time.Duration    // max time a key is held by a request in progress, default 1m
+	Methods []string         // default POST, PUT, PATCH and DELETE
+	MaxSize int              // larger responses are not stored, default 1MB
+}
+
+// NewIdempotencyFilter honors the Idempotency-Key header of mutating requests,
+// the first response of a key is stored and replayed to the retries with the Idempotent-Replayed header,
+// concurrent duplicates get 409 and a key reused by another request, e.g. with another body, gets 422.
+// 5xx responses are not stored, so the request can be retried.
+//
+// Keys are scoped by user, it must be placed after authentication filter.
+// The filter fails open when the store is unavailable.
+func NewIdempotencyFilter(opts IdempotencyOptions) Filter {
+	if opts.Store == nil {
+		opts.Store = NewMemoryIdempotencyStore()
+	}
+	if opts.TTL <= 0 {
+		opts.TTL = 24 * time.Hour
+	}
+	if opts.LockTTL <= 0 {
+		opts.LockTTL = time.Minute
+	}
+	if opts.Methods == nil {
+		opts.Methods = []string{http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+	}
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = MB
+	}
+	return FilterFunc(func(w http.ResponseWriter, r *http.Request, next http.Handler) {
+		idempotencykey := r.Header.Get(HeaderIdempotencyKey)
+		if idempotencykey == "" || !slices.Contains(opts.Methods, r.Method) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(idempotencykey) > 255 {
+			response.BadRequest(w, "idempotency key too long")
+			return
+		}
+		ctx := r.Context()
+		key := "idempotency:" + AuthenticateFromContext(ctx).User.Name + ":" + idempotencykey
+		body := newBodyHasher(r.Body)
+		r.Body = body
+		stored, err := opts.Store.Lock(ctx, key, opts.LockTTL)
+		switch {
+		case errors.Is(err, ErrIdempotencyKeyInUse):
+			response.Error(w, response.NewStatusErrorMessage(http.StatusConflict, "a request with the same idempotency key is in progress"))
+			return
+		case err != nil:
+			next.ServeHTTP(w, r)
+			return
+		case stored != nil:
+			sum, err := body.Sum()
+			if err != nil {
+				response.BadRequest(w, err.Error())
+				return
+			}
+			if stored.Method != r.Method || stored.URI != r.URL.RequestURI() || stored.BodyHash != sum {
+				response.Error(w, response.NewStatusErrorMessage(http.StatusUnprocessableEntity, "idempotency key is used by another request"))
+				return
+			}
+			for k, v := range stored.Header {
+				w.Header()[k] = slices.Clone(v)
+			}
+			w.Header().Set(HeaderIdempotentReplayed, "true")
+			w.WriteHeader(stored.Code)
+			_, _ = w.Write(stored.Body)
+			return
+		}
+
+		ctx = withoutCancel(ctx)
+		before := w.Header().Clone()
+		sw := &StatusResponseWriter{Inner: w, MaxCacheSize: opts.MaxSize}
+		defer func() {
+			code := sw.Code
+			if code == 0 {
+				code = http.StatusOK
+			}
+			err := recover()
+			// the body not read by the handler is hashed, a body failed to read is not known to match a retry
+			sum, sumerr := body.Sum()
+			if err != nil || sumerr != nil || code >= 500 || sw.Truncated || sw.skipped || sw.flushed {
+				_ = opts.Store.Unlock(ctx, key)
+				if err != nil {
+					panic(err)
+				}
+				return
+			}
+			resp := &IdempotentResponse{
+				Method:   r.Method,
+				URI:      r.URL.RequestURI(),
+				BodyHash: sum,
+				Code:     code,
+				Header:   changedHeader(before, w.Header()),
+				Body:     sw.Cache,
+			}
+			_ = opts.Store.Save(ctx, key, resp, opts.TTL)
+		}()
+		next.ServeHTTP(sw, r)
+	})
+}
+
+// bodyHasher hashes the request body as the handler reads it, so the body is not buffered.
+type bodyHasher struct {
+	io.ReadCloser
+	hash hash.Hash
+	err  error
+}
+
+func newBodyHasher(body io.ReadCloser) *bodyHasher {
+	if body == nil {
+		body = http.NoBody
+	}
+	return &bodyHasher{ReadCloser: body, hash: sha256.New()}
+}
+
+func (b *bodyHasher) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.hash.Write(p[:n])
+	if err != nil && err != io.EOF && b.err == nil {
+		b.err = err
+	}
+	return n, err
+}
+
+// Sum reads the rest of the body and returns the hex sha256 of the whole body.
+func (b *bodyHasher) Sum() (string, error) {
+	if b.err == nil {
+		_, _ = io.Copy(io.Discard, b)
+	}
+	if b.err != nil {
+		return "", fmt.Errorf("read request body: %w", b.err)
+	}
+	return hex.EncodeToString(b.hash.Sum(nil)), nil
+}
+
+var _ IdempotencyStore = &MemoryIdempotencyStore{}
+
+// MemoryIdempotencyStore is an IdempotencyStore for single replica deployments.
+type MemoryIdempotencyStore struct {
+	mu      sync.Mutex
+	entries map[string]*idempotencyEntry
+	lastgc  time.Time
+}
+
+type idempotencyEntry struct {
+	resp      *IdempotentResponse // nil if locked
+	expiresAt time.Time
+}
+
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{entries: map[string]*idempotencyEntry{}}
+}
+
+func (s *MemoryIdempotencyStore) Lock(ctx context.Context, key string, ttl time.Duration) (*IdempotentResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.gc(now)
+	if entry, ok := s.entries[key]; ok && now.Before(entry.expiresAt) {
+		if entry.resp == nil {
+			return nil, ErrIdempotencyKeyInUse
+		}
+		return entry.resp, nil
+	}
+	s.entries[key] = &idempotencyEntry{expiresAt: now.Add(ttl)}
+	return nil, nil
+}
+
+func (s *MemoryIdempotencyStore) Save(ctx context.Context, key string, resp *IdempotentResponse, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[key] = &idempotencyEntry{resp: resp, expiresAt: time.Now().Add(ttl)}
+	return nil
+}
+
+func (s *MemoryIdempotencyStore) Unlock(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if entry, ok := s.entries[key]; ok && entry.resp == nil {
+		delete(s.entries, key)
+	}
+	return nil
+}
+
+// gc removes expired entries at most once a minute, must be called with lock held.
+func (s *MemoryIdempotencyStore) gc(now time.Time) {
+	if now.Sub(s.lastgc) < time.Minute {
+		return
+	}
+	s.lastgc = now
+	for k, v := range s.entries {
+		if !now.Before(v.expiresAt) {
+			delete(s.entries, k)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestIdempotencyFilter(t *testing.T) {
+	calls := 0
+	block, blocked := make(chan struct{}), make(chan struct{})
+	handler := Filters{NewIdempotencyFilter(IdempotencyOptions{})}.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		switch r.URL.Path {
+		case "/blocking":
+			close(blocked)
+			<-block
+		case "/failing":
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Location", "/items/"+strconv.Itoa(calls))
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("created " + strconv.Itoa(calls)))
+	}))
+	do := func(method, target, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if key != "" {
+			req.Header.Set(HeaderIdempotencyKey, key)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	tests := []struct {
+		name         string
+		method       string
+		target       string
+		key          string
+		body         string
+		wantCode     int
+		wantBody     string
+		wantReplayed bool
+		wantCalls    int
+	}{
+		{name: "first", method: http.MethodPost, target: "/items", key: "a", wantCode: http.StatusCreated, wantBody: "created 1", wantCalls: 1},
+		{name: "retry", method: http.MethodPost, target: "/items", key: "a", wantCode: http.StatusCreated, wantBody: "created 1", wantReplayed: true, wantCalls: 1},
+		{name: "reused with another body", method: http.MethodPost, target: "/items", key: "a", body: `{"name":"b"}`, wantCode: http.StatusUnprocessableEntity, wantCalls: 1},
+		{name: "another key", method: http.MethodPost, target: "/items", key: "b", wantCode: http.StatusCreated, wantBody: "created 2", wantCalls: 2},
+		{name: "no key", method: http.MethodPost, target: "/items", wantCode: http.StatusCreated, wantBody: "created 3", wantCalls: 3},
+		{name: "reused by another request", method: http.MethodPost, target: "/others", key: "a", wantCode: http.StatusUnprocessableEntity, wantCalls: 3},
+		{name: "not mutating", method: http.MethodGet, target: "/items", key: "a", wantCode: http.StatusCreated, wantBody: "created 4", wantCalls: 4},
+		{name: "server error", method: http.MethodPost, target: "/failing", key: "c", wantCode: http.StatusBadGateway, wantCalls: 5},
+		{name: "server error retried", method: http.MethodPost, target: "/failing", key: "c", wantCode: http.StatusBadGateway, wantCalls: 6},
+	}
+	for _, tt := range tests {
+		rec := do(tt.method, tt.target, tt.key, tt.body)
+		if rec.Code != tt.wantCode {
+			t.Errorf("%s: code = %d, want %d", tt.name, rec.Code, tt.wantCode)
+		}
+		if tt.wantBody != "" && rec.Body.String() != tt.wantBody {
+			t.Errorf("%s: body = %q, want %q", tt.name, rec.Body.String(), tt.wantBody)
+		}
+		if replayed := rec.Header().Get(HeaderIdempotentReplayed) == "true"; replayed != tt.wantReplayed {
+			t.Errorf("%s: replayed = %v, want %v", tt.name, replayed, tt.wantReplayed)
+		}
+		if tt.wantReplayed && rec.Header().Get("Location") != "/items/1" {
+			t.Errorf("%s: replayed Location = %q", tt.name, rec.Header().Get("Location"))
+		}
+		if calls != tt.wantCalls {
+			t.Errorf("%s: calls = %d, want %d", tt.name, calls, tt.wantCalls)
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		do(http.MethodPost, "/blocking", "d", "")
+	}()
+	<-blocked
+	if rec := do(http.MethodPost, "/blocking", "d", ""); rec.Code != http.StatusConflict {
+		t.Errorf("concurrent duplicate code = %d, want %d", rec.Code, http.StatusConflict)
+	}
+	close(block)
+	<-done
+}
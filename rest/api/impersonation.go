@@ -0,0 +1,89 @@
+// Copyright 2023 The Kubegems Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"kubegems.io/library/rest/response"
+)
+
+const (
+	HeaderImpersonateUser  = "Impersonate-User"
+	HeaderImpersonateGroup = "Impersonate-Group"
+
+	// AuditMetadataImpersonator is the audit metadata key of the original user of an impersonated request.
+	AuditMetadataImpersonator = "impersonator"
+)
+
+var impersonatorContextKey = ContextKey("impersonator")
+
+func WithImpersonator(ctx context.Context, user UserInfo) context.Context {
+	return context.WithValue(ctx, impersonatorContextKey, user)
+}
+
+// ImpersonatorFromContext returns the original user if the request is impersonated.
+func ImpersonatorFromContext(ctx context.Context) (UserInfo, bool) {
+	user, ok := ctx.Value(impersonatorContextKey).(UserInfo)
+	return user, ok
+}
+
+// NewImpersonationFilter acts as the user in Impersonate-User header and the groups in Impersonate-Group headers,
+// the caller must be allowed to "impersonate" resource "users:{name}" and each "groups:{name}" by authorizer.
+// It must be placed after authentication filter and before authorization and audit filters,
+// so that the impersonated user is authorized and audited, the original user is recorded as AuditMetadataImpersonator.
+func NewImpersonationFilter(authorizer Authorizer) Filter {
+	return FilterFunc(func(w http.ResponseWriter, r *http.Request, next http.Handler) {
+		username, groups := r.Header.Get(HeaderImpersonateUser), r.Header.Values(HeaderImpersonateGroup)
+		if username == "" && len(groups) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if username == "" {
+			response.BadRequest(w, HeaderImpersonateUser+" is required to impersonate groups")
+			return
+		}
+		info := AuthenticateFromContext(r.Context())
+		checks := []AttributeResource{{Resource: "users", Name: username}}
+		for _, group := range groups {
+			checks = append(checks, AttributeResource{Resource: "groups", Name: group})
+		}
+		for _, check := range checks {
+			decision, reason, err := authorizer.Authorize(r.Context(), info.User, Attributes{Action: "impersonate", Resources: []AttributeResource{check}})
+			if err != nil {
+				response.InternalServerError(w, err)
+				return
+			}
+			if decision != DecisionAllow {
+				msg := fmt.Sprintf("user %s can not impersonate %s %s", info.User.Name, check.Resource, check.Name)
+				if reason != "" {
+					msg += ": " + reason
+				}
+				response.Forbidden(w, msg)
+				return
+			}
+		}
+		r.Header.Del(HeaderImpersonateUser)
+		r.Header.Del(HeaderImpersonateGroup)
+		// the audit log exists if placed after the audit filter
+		SetAuditExtra(r, AuditMetadataImpersonator, info.User.Name)
+
+		impersonated := AuthenticateInfo{Audiences: info.Audiences, User: UserInfo{Name: username, Groups: groups}}
+		ctx := WithImpersonator(WithAuthenticate(r.Context(), impersonated), info.User)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
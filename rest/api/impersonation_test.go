@@ -0,0 +1,69 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestImpersonationFilter(t *testing.T) {
+	// admin can impersonate anyone, others can impersonate group "viewers" only
+	authorizer := AuthorizerFunc(func(ctx context.Context, user UserInfo, a Attributes) (Decision, string, error) {
+		if user.Name == "admin" || (a.Resources[0].Resource == "groups" && a.Resources[0].Name == "viewers") {
+			return DecisionAllow, "", nil
+		}
+		return DecisionDeny, "", nil
+	})
+	tests := []struct {
+		name     string
+		user     string
+		header   http.Header
+		wantCode int
+		wantUser string
+	}{
+		{name: "no impersonation", user: "bob", header: http.Header{}, wantCode: http.StatusOK, wantUser: "bob"},
+		{name: "allowed", user: "admin", header: http.Header{HeaderImpersonateUser: {"bob"}, HeaderImpersonateGroup: {"dev"}}, wantCode: http.StatusOK, wantUser: "bob"},
+		{name: "user denied", user: "bob", header: http.Header{HeaderImpersonateUser: {"alice"}, HeaderImpersonateGroup: {"viewers"}}, wantCode: http.StatusForbidden},
+		{name: "group without user", user: "admin", header: http.Header{HeaderImpersonateGroup: {"dev"}}, wantCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auditor := NewSimpleAuditor()
+			sink := &recordingAuditSink{}
+			filters := Filters{
+				NewAuthenticateFilter(func(w http.ResponseWriter, r *http.Request) (*AuthenticateInfo, error) {
+					return &AuthenticateInfo{User: UserInfo{Name: tt.user}}, nil
+				}, nil),
+				NewImpersonationFilter(authorizer),
+				NewAuditFilter(auditor, sink),
+			}
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header = tt.header
+			rec := httptest.NewRecorder()
+			filters.Process(rec, req, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			if rec.Code != tt.wantCode {
+				t.Fatalf("code = %d, want %d", rec.Code, tt.wantCode)
+			}
+			if tt.wantUser == "" {
+				return
+			}
+			auditlog := sink.log
+			if auditlog.Subject != tt.wantUser {
+				t.Errorf("audit subject = %s, want %s", auditlog.Subject, tt.wantUser)
+			}
+			if impersonator := auditlog.Metadata[AuditMetadataImpersonator]; tt.user != tt.wantUser && impersonator != tt.user {
+				t.Errorf("audit impersonator = %s, want %s", impersonator, tt.user)
+			}
+		})
+	}
+}
+
+type recordingAuditSink struct {
+	log *AuditLog
+}
+
+func (s *recordingAuditSink) Save(log *AuditLog) error {
+	s.log = log
+	return nil
+}
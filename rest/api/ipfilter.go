@@ -0,0 +1,51 @@
+// Copyright 2023 The Kubegems Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"net/http"
+	"path"
+
+	"golang.org/x/exp/slices"
+	"kubegems.io/library/rest/response"
+)
+
+type IPFilterOptions struct {
+	Allow          []string `json:"allow,omitempty" description:"allowed CIDRs or ips, empty allows all not denied"`
+	Deny           []string `json:"deny,omitempty" description:"denied CIDRs or ips, checked before allow"`
+	TrustedProxies []string `json:"trustedProxies,omitempty" description:"CIDRs of proxies whose X-Forwarded-For is honored, empty trusts none"`
+	ForwardedDepth int      `json:"forwardedDepth,omitempty" description:"max X-Forwarded-For addresses walked from the right, 0 means unlimited"`
+	Paths          []string `json:"paths,omitempty" description:"path patterns of path.Match the filter applies to, a trailing /** matches all sub paths, empty applies to all"`
+}
+
+// NewIPFilter responds 403 to the requests from denied or not allowed client ips.
+// Unlike NewAllowCIDRAuthorizer, which checks RemoteAddr, the client ip is resolved through the trusted proxies.
+func NewIPFilter(opts IPFilterOptions) Filter {
+	return FilterFunc(func(w http.ResponseWriter, r *http.Request, next http.Handler) {
+		if len(opts.Paths) > 0 {
+			reqpath := path.Clean("/" + r.URL.Path)
+			if !slices.ContainsFunc(opts.Paths, func(pattern string) bool { return matchPathPattern(pattern, reqpath) }) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		ip := ClientIP(r, opts.TrustedProxies, opts.ForwardedDepth)
+		if InCIDR(ip, opts.Deny) || (len(opts.Allow) > 0 && !InCIDR(ip, opts.Allow)) {
+			response.Forbidden(w, "access denied for ip "+ip)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIPFilter(t *testing.T) {
+	opts := IPFilterOptions{
+		Allow:          []string{"10.0.0.0/8"},
+		Deny:           []string{"10.0.0.66"},
+		TrustedProxies: []string{"192.168.0.0/16"},
+		Paths:          []string{"/admin/**"},
+	}
+	tests := []struct {
+		name       string
+		opts       IPFilterOptions
+		path       string
+		remoteAddr string
+		xff        string
+		wantCode   int
+	}{
+		{name: "allowed", opts: opts, path: "/admin/users", remoteAddr: "10.0.0.1:1234", wantCode: http.StatusOK},
+		{name: "not allowed", opts: opts, path: "/admin/users", remoteAddr: "172.16.0.1:1234", wantCode: http.StatusForbidden},
+		{name: "denied", opts: opts, path: "/admin", remoteAddr: "10.0.0.66:1234", wantCode: http.StatusForbidden},
+		{name: "other path", opts: opts, path: "/users", remoteAddr: "172.16.0.1:1234", wantCode: http.StatusOK},
+		{name: "via trusted proxy", opts: opts, path: "/admin", remoteAddr: "192.168.0.1:1234", xff: "10.0.0.1, 192.168.0.2", wantCode: http.StatusOK},
+		{name: "denied via trusted proxy", opts: opts, path: "/admin", remoteAddr: "192.168.0.1:1234", xff: "10.0.0.66", wantCode: http.StatusForbidden},
+		{name: "spoofed by untrusted peer", opts: opts, path: "/admin", remoteAddr: "172.16.0.1:1234", xff: "10.0.0.1", wantCode: http.StatusForbidden},
+		{name: "spoofed before the proxy", opts: opts, path: "/admin", remoteAddr: "192.168.0.1:1234", xff: "10.0.0.1, 172.16.0.1", wantCode: http.StatusForbidden},
+		{
+			name:       "depth limited",
+			opts:       IPFilterOptions{Allow: opts.Allow, TrustedProxies: opts.TrustedProxies, ForwardedDepth: 1},
+			path:       "/",
+			remoteAddr: "192.168.0.1:1234",
+			xff:        "10.0.0.1, 192.168.0.2",
+			wantCode:   http.StatusForbidden,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.xff != "" {
+				req.Header.Set("X-Forwarded-For", tt.xff)
+			}
+			rec := httptest.NewRecorder()
+			Filters{NewIPFilter(tt.opts)}.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(rec, req)
+			if rec.Code != tt.wantCode {
+				t.Errorf("code = %d, want %d", rec.Code, tt.wantCode)
+			}
+		})
+	}
+}
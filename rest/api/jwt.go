@@ -0,0 +1,205 @@
+// Copyright 2023 The Kubegems Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-jose/go-jose/v3"
+	"github.com/go-jose/go-jose/v3/jwt"
+	"golang.org/x/exp/slices"
+)
+
+type JWTOptions struct {
+	Issuer         string        `json:"issuer,omitempty" description:"expected issuer, empty skips the check"`
+	Audiences      []string      `json:"audiences,omitempty" description:"accepted audiences, empty skips the check"`
+	HMACSecret     string        `json:"hmacSecret,omitempty" description:"secret of HS256/HS384/HS512 tokens"`
+	PublicKeyFiles []string      `json:"publicKeyFiles,omitempty" description:"PEM encoded RSA/ECDSA public keys"`
+	JWKSFile       string        `json:"jwksFile,omitempty" description:"JSON Web Key Set file"`
+	Leeway         time.Duration `json:"leeway,omitempty" description:"allowed clock skew"`
+
+	UsernameClaims []string `json:"usernameClaims,omitempty" description:"username claims, default is 'name' then 'sub'"`
+	EmailClaims    []string `json:"emailClaims,omitempty" description:"email claims, default is 'email'"`
+	GroupsClaims   []string `json:"groupsClaims,omitempty" description:"groups claims, default is 'groups' then 'roles'"`
+}
+
+func NewDefaultJWTOptions() *JWTOptions {
+	return &JWTOptions{
+		Leeway:         jwt.DefaultLeeway,
+		UsernameClaims: []string{"name", "sub"},
+		EmailClaims:    []string{"email"},
+		GroupsClaims:   []string{"groups", "roles"},
+	}
+}
+
+var _ TokenAuthenticator = &JWTAuthenticator{}
+
+// JWTAuthenticator validates locally signed JWTs without an external IdP.
+type JWTAuthenticator struct {
+	Options *JWTOptions
+	keys    []jose.JSONWebKey
+}
+
+func NewJWTAuthenticator(opts *JWTOptions) (*JWTAuthenticator, error) {
+	defaults := NewDefaultJWTOptions()
+	if opts.UsernameClaims == nil {
+		opts.UsernameClaims = defaults.UsernameClaims
+	}
+	if opts.EmailClaims == nil {
+		opts.EmailClaims = defaults.EmailClaims
+	}
+	if opts.GroupsClaims == nil {
+		opts.GroupsClaims = defaults.GroupsClaims
+	}
+	keys := []jose.JSONWebKey{}
+	if opts.HMACSecret != "" {
+		keys = append(keys, jose.JSONWebKey{Key: []byte(opts.HMACSecret)})
+	}
+	for _, file := range opts.PublicKeyFiles {
+		key, err := loadPublicKeyFile(file)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, jose.JSONWebKey{Key: key})
+	}
+	if opts.JWKSFile != "" {
+		data, err := os.ReadFile(opts.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		jwks := jose.JSONWebKeySet{}
+		if err := json.Unmarshal(data, &jwks); err != nil {
+			return nil, fmt.Errorf("parse jwks %s: %w", opts.JWKSFile, err)
+		}
+		for _, key := range jwks.Keys {
+			if !key.IsPublic() && !isSymmetric(key) {
+				key = key.Public()
+			}
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("jwt: no signing keys configured")
+	}
+	return &JWTAuthenticator{Options: opts, keys: keys}, nil
+}
+
+func loadPublicKeyFile(file string) (any, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("jwt: no PEM data in %s", file)
+	}
+	switch block.Type {
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("jwt: parse certificate %s: %w", file, err)
+		}
+		return cert.PublicKey, nil
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("jwt: parse public key %s: %w", file, err)
+		}
+		return key, nil
+	}
+}
+
+func isSymmetric(key jose.JSONWebKey) bool {
+	_, ok := key.Key.([]byte)
+	return ok
+}
+
+func (a *JWTAuthenticator) Authenticate(ctx context.Context, token string) (*AuthenticateInfo, error) {
+	token = TrimBearer(token)
+	if token == "" {
+		return nil, fmt.Errorf("no token found")
+	}
+	tok, err := jwt.ParseSigned(token)
+	if err != nil {
+		return nil, fmt.Errorf("jwt: parse token: %v", err)
+	}
+	if len(tok.Headers) != 1 {
+		return nil, fmt.Errorf("jwt: exactly one signature required")
+	}
+	kid := tok.Headers[0].KeyID
+
+	var (
+		std jwt.Claims
+		c   claims
+	)
+	verified := false
+	for _, key := range a.keys {
+		if kid != "" && key.KeyID != "" && key.KeyID != kid {
+			continue
+		}
+		if err := tok.Claims(key.Key, &std, &c); err == nil {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, fmt.Errorf("jwt: invalid signature")
+	}
+	if err := std.ValidateWithLeeway(jwt.Expected{Issuer: a.Options.Issuer, Time: time.Now()}, a.Options.Leeway); err != nil {
+		return nil, fmt.Errorf("jwt: %v", err)
+	}
+	if len(a.Options.Audiences) > 0 && !slices.ContainsFunc(a.Options.Audiences, std.Audience.Contains) {
+		return nil, fmt.Errorf("jwt: %v", jwt.ErrInvalidAudience)
+	}
+	info := a.userInfo(std, c)
+	if info.Name == "" {
+		return nil, fmt.Errorf("jwt: no username/email claim found")
+	}
+	return &AuthenticateInfo{Audiences: std.Audience, User: info}, nil
+}
+
+func (a *JWTAuthenticator) userInfo(std jwt.Claims, c claims) UserInfo {
+	info := UserInfo{ID: std.Subject}
+	for _, candidate := range a.Options.UsernameClaims {
+		if c.unmarshalClaim(candidate, &info.Name) == nil && info.Name != "" {
+			break
+		}
+	}
+	for _, candidate := range a.Options.EmailClaims {
+		if c.unmarshalClaim(candidate, &info.Email) == nil && info.Email != "" {
+			break
+		}
+	}
+	if info.Name == "" && info.Email != "" {
+		info.Name, _, _ = strings.Cut(info.Email, "@")
+	}
+	for _, candidate := range a.Options.GroupsClaims {
+		var groups stringOrArray
+		if c.unmarshalClaim(candidate, &groups) == nil {
+			info.Groups = groups
+			break
+		}
+	}
+	return info
+}
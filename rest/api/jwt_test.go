@@ -0,0 +1,102 @@
+package api
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v3"
+	"github.com/go-jose/go-jose/v3/jwt"
+)
+
+func TestJWTAuthenticator(t *testing.T) {
+	eckey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, _ := x509.MarshalPKIXPublicKey(&eckey.PublicKey)
+	pubfile := filepath.Join(t.TempDir(), "pub.pem")
+	if err := os.WriteFile(pubfile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	opts := NewDefaultJWTOptions()
+	opts.Issuer, opts.Audiences = "kubegems", []string{"api"}
+	opts.HMACSecret, opts.PublicKeyFiles = "secret", []string{pubfile}
+	authenticator, err := NewJWTAuthenticator(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sign := func(alg jose.SignatureAlgorithm, key any, claims jwt.Claims, extra map[string]any) string {
+		signer, err := jose.NewSigner(jose.SigningKey{Algorithm: alg, Key: key}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		token, err := jwt.Signed(signer).Claims(claims).Claims(extra).CompactSerialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	valid := jwt.Claims{Issuer: "kubegems", Subject: "u1", Audience: jwt.Audience{"api"}, Expiry: jwt.NewNumericDate(time.Now().Add(time.Hour))}
+	otherkey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	tests := []struct {
+		name       string
+		token      string
+		wantUser   string
+		wantGroups int
+		wantErr    bool
+	}{
+		{
+			name:       "hmac",
+			token:      "Bearer " + sign(jose.HS256, []byte("secret"), valid, map[string]any{"name": "alice", "groups": []string{"a", "b"}}),
+			wantUser:   "alice",
+			wantGroups: 2,
+		},
+		{
+			name:       "ecdsa with email",
+			token:      sign(jose.ES256, eckey, valid, map[string]any{"email": "bob@example.com", "roles": "admin"}),
+			wantUser:   "u1",
+			wantGroups: 1,
+		},
+		{
+			name:    "unknown key",
+			token:   sign(jose.ES256, otherkey, valid, nil),
+			wantErr: true,
+		},
+		{
+			name:    "wrong issuer",
+			token:   sign(jose.HS256, []byte("secret"), jwt.Claims{Issuer: "other", Subject: "u1", Audience: jwt.Audience{"api"}}, nil),
+			wantErr: true,
+		},
+		{
+			name:    "wrong audience",
+			token:   sign(jose.HS256, []byte("secret"), jwt.Claims{Issuer: "kubegems", Subject: "u1", Audience: jwt.Audience{"web"}}, nil),
+			wantErr: true,
+		},
+		{
+			name:    "expired",
+			token:   sign(jose.HS256, []byte("secret"), jwt.Claims{Issuer: "kubegems", Subject: "u1", Audience: jwt.Audience{"api"}, Expiry: jwt.NewNumericDate(time.Now().Add(-time.Hour))}, nil),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := authenticator.Authenticate(context.Background(), tt.token)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Authenticate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if info.User.Name != tt.wantUser || len(info.User.Groups) != tt.wantGroups {
+				t.Errorf("Authenticate() user = %+v", info.User)
+			}
+		})
+	}
+}
@@ -0,0 +1,211 @@
+// Copyright 2023 The Kubegems Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+var ErrLockedOut = errors.New("too many failed attempts")
+
+// FailureCounterStore counts authentication failures, e.g. MemoryCounterStore or redisstore.CounterStore.
+type FailureCounterStore interface {
+	CounterStore
+	// Count returns the counter of key in current window without increasing it.
+	Count(ctx context.Context, key string, window time.Duration) (int64, time.Time, error)
+	// Decr decreases the counter of key in current window by one, a missing counter is not created.
+	Decr(ctx context.Context, key string, window time.Duration) error
+	// Reset removes the counter of key in current window.
+	Reset(ctx context.Context, key string, window time.Duration) error
+}
+
+var _ FailureCounterStore = &MemoryCounterStore{}
+
+type LockoutOptions struct {
+	MaxFailures int64         // failures allowed per client ip or username in a window, default 5
+	Window      time.Duration // failures are counted in fixed windows, a lockout lasts until the window resets, default 15m
+	Delay       time.Duration // delay of failed attempts, multiplied by the failures in current window, 0 disables
+	MaxDelay    time.Duration // default 5s
+	Store       FailureCounterStore
+}
+
+func NewDefaultLockoutOptions() *LockoutOptions {
+	return &LockoutOptions{
+		MaxFailures: 5,
+		Window:      15 * time.Minute,
+		Delay:       100 * time.Millisecond,
+		MaxDelay:    5 * time.Second,
+		Store:       NewMemoryCounterStore(),
+	}
+}
+
+// lockout tracks failures by keys, it fails open when the store is unavailable.
+//
+// An attempt is counted before it is made, so concurrent attempts can not pass a check together,
+// and released when it succeeds, see attempt, release and reset.
+type lockout struct {
+	options *LockoutOptions
+}
+
+func newLockout(opts *LockoutOptions) lockout {
+	defaults := NewDefaultLockoutOptions()
+	if opts.MaxFailures <= 0 {
+		opts.MaxFailures = defaults.MaxFailures
+	}
+	if opts.Window <= 0 {
+		opts.Window = defaults.Window
+	}
+	if opts.MaxDelay <= 0 {
+		opts.MaxDelay = defaults.MaxDelay
+	}
+	if opts.Store == nil {
+		opts.Store = defaults.Store
+	}
+	return lockout{options: opts}
+}
+
+// attempt counts an attempt of keys, it is locked out when any count exceeds MaxFailures.
+// The highest count is returned to delay the attempt if it fails, see fail.
+func (l lockout) attempt(ctx context.Context, keys ...string) (int64, error) {
+	var max int64
+	var lockedUntil time.Time
+	for _, key := range keys {
+		count, reset, err := l.options.Store.Incr(ctx, key, l.options.Window)
+		if err != nil {
+			continue
+		}
+		if count > max {
+			max = count
+		}
+		if count > l.options.MaxFailures && reset.After(lockedUntil) {
+			lockedUntil = reset
+		}
+	}
+	if lockedUntil.IsZero() {
+		return max, nil
+	}
+	if header := ResponseHeaderFromContext(ctx); header != nil {
+		header.Set("Retry-After", strconv.Itoa(int(time.Until(lockedUntil).Seconds())+1))
+	}
+	return max, fmt.Errorf("%w, retry after %s", ErrLockedOut, lockedUntil.Format(time.RFC3339))
+}
+
+// release uncounts a successful attempt of keys, e.g. of a client ip shared by many users.
+func (l lockout) release(ctx context.Context, keys ...string) {
+	for _, key := range keys {
+		_ = l.options.Store.Decr(ctx, key, l.options.Window)
+	}
+}
+
+// reset clears the failures of keys after a successful attempt, e.g. of the username.
+func (l lockout) reset(ctx context.Context, keys ...string) {
+	for _, key := range keys {
+		_ = l.options.Store.Reset(ctx, key, l.options.Window)
+	}
+}
+
+// fail delays the caller of a failed attempt, count is the highest count returned by attempt.
+func (l lockout) fail(ctx context.Context, count int64) {
+	if l.options.Delay <= 0 || count == 0 {
+		return
+	}
+	delay := time.Duration(count) * l.options.Delay
+	if delay > l.options.MaxDelay {
+		delay = l.options.MaxDelay
+	}
+	_ = sleepContext(ctx, delay)
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+var _ TokenAuthenticator = &LockoutTokenAuthenticator{}
+
+// LockoutTokenAuthenticator rejects clients with too many failed authentications,
+// failures are counted by the client ip from ClientIPFromContext.
+type LockoutTokenAuthenticator struct {
+	Authenticator TokenAuthenticator
+	lockout       lockout
+}
+
+func NewLockoutTokenAuthenticator(authenticator TokenAuthenticator, opts *LockoutOptions) *LockoutTokenAuthenticator {
+	return &LockoutTokenAuthenticator{Authenticator: authenticator, lockout: newLockout(opts)}
+}
+
+func (a *LockoutTokenAuthenticator) Authenticate(ctx context.Context, token string) (*AuthenticateInfo, error) {
+	ip := ClientIPFromContext(ctx)
+	// requests without token or client ip are not counted
+	if token == "" || ip == "" {
+		return a.Authenticator.Authenticate(ctx, token)
+	}
+	key := "authfail:ip:" + ip
+	count, err := a.lockout.attempt(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	info, err := a.Authenticator.Authenticate(ctx, token)
+	if err != nil {
+		a.lockout.fail(ctx, count)
+		return nil, err
+	}
+	a.lockout.release(ctx, key)
+	return info, nil
+}
+
+var _ UsernamePasswordAuthenticator = &LockoutPasswordAuthenticator{}
+
+// LockoutPasswordAuthenticator rejects usernames and clients with too many failed authentications,
+// so that neither one password against many users nor many passwords against one user can be brute forced.
+type LockoutPasswordAuthenticator struct {
+	Authenticator UsernamePasswordAuthenticator
+	lockout       lockout
+}
+
+func NewLockoutPasswordAuthenticator(authenticator UsernamePasswordAuthenticator, opts *LockoutOptions) *LockoutPasswordAuthenticator {
+	return &LockoutPasswordAuthenticator{Authenticator: authenticator, lockout: newLockout(opts)}
+}
+
+func (a *LockoutPasswordAuthenticator) Authenticate(ctx context.Context, username, password string) (*AuthenticateInfo, error) {
+	userkey, ipkeys := "authfail:user:"+username, []string{}
+	if ip := ClientIPFromContext(ctx); ip != "" {
+		ipkeys = append(ipkeys, "authfail:ip:"+ip)
+	}
+	count, err := a.lockout.attempt(ctx, append([]string{userkey}, ipkeys...)...)
+	if err != nil {
+		return nil, err
+	}
+	info, err := a.Authenticator.Authenticate(ctx, username, password)
+	if err != nil {
+		a.lockout.fail(ctx, count)
+		return nil, err
+	}
+	// the user proved the password, the failures of the client ip are kept
+	// so a valid account can not be used to reset the lockout of a password spraying ip
+	a.lockout.reset(ctx, userkey)
+	a.lockout.release(ctx, ipkeys...)
+	return info, nil
+}
@@ -0,0 +1,119 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLockoutPasswordAuthenticator(t *testing.T) {
+	inner := UsernamePasswordAuthenticateFunc(func(ctx context.Context, username, password string) (*AuthenticateInfo, error) {
+		if password != "secret" {
+			return nil, errors.New("invalid password")
+		}
+		return &AuthenticateInfo{User: UserInfo{Name: username}}, nil
+	})
+	type attempt struct {
+		ip, user, password string
+		wantLocked         bool
+		wantErr            bool
+	}
+	tests := []struct {
+		name     string
+		attempts []attempt
+	}{
+		{
+			name: "locked by username",
+			attempts: []attempt{
+				{ip: "10.0.0.1", user: "bob", password: "x", wantErr: true},
+				{ip: "10.0.0.2", user: "bob", password: "x", wantErr: true},
+				{ip: "10.0.0.3", user: "bob", password: "secret", wantErr: true, wantLocked: true},
+				{ip: "10.0.0.3", user: "alice", password: "secret"},
+			},
+		},
+		{
+			name: "locked by ip",
+			attempts: []attempt{
+				{ip: "10.0.0.1", user: "bob", password: "x", wantErr: true},
+				{ip: "10.0.0.1", user: "alice", password: "x", wantErr: true},
+				{ip: "10.0.0.1", user: "carol", password: "secret", wantErr: true, wantLocked: true},
+				{ip: "10.0.0.2", user: "carol", password: "secret"},
+			},
+		},
+		{
+			name: "success resets the username failures",
+			attempts: []attempt{
+				{ip: "10.0.0.1", user: "bob", password: "x", wantErr: true},
+				{ip: "10.0.0.2", user: "bob", password: "secret"},
+				{ip: "10.0.0.3", user: "bob", password: "x", wantErr: true},
+				{ip: "10.0.0.4", user: "bob", password: "x", wantErr: true},
+				{ip: "10.0.0.5", user: "bob", password: "secret", wantErr: true, wantLocked: true},
+			},
+		},
+		{
+			name: "success keeps the ip failures",
+			attempts: []attempt{
+				{ip: "10.0.0.1", user: "bob", password: "x", wantErr: true},
+				{ip: "10.0.0.1", user: "alice", password: "secret"},
+				{ip: "10.0.0.1", user: "carol", password: "x", wantErr: true},
+				{ip: "10.0.0.1", user: "dave", password: "secret", wantErr: true, wantLocked: true},
+			},
+		},
+		{
+			name: "success not counted",
+			attempts: []attempt{
+				{ip: "10.0.0.1", user: "bob", password: "x", wantErr: true},
+				{ip: "10.0.0.1", user: "bob", password: "secret"},
+				{ip: "10.0.0.1", user: "bob", password: "secret"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authenticator := NewLockoutPasswordAuthenticator(inner, &LockoutOptions{MaxFailures: 2})
+			for i, a := range tt.attempts {
+				ctx := WithClientIP(context.Background(), a.ip)
+				_, err := authenticator.Authenticate(ctx, a.user, a.password)
+				if (err != nil) != a.wantErr {
+					t.Fatalf("attempt %d: error = %v, wantErr %v", i, err, a.wantErr)
+				}
+				if errors.Is(err, ErrLockedOut) != a.wantLocked {
+					t.Fatalf("attempt %d: error = %v, wantLocked %v", i, err, a.wantLocked)
+				}
+			}
+		})
+	}
+}
+
+func TestLockoutConcurrentAttempts(t *testing.T) {
+	calls := atomic.Int64{}
+	inner := TokenAuthenticateFunc(func(ctx context.Context, token string) (*AuthenticateInfo, error) {
+		calls.Add(1)
+		time.Sleep(10 * time.Millisecond)
+		return nil, errors.New("invalid token")
+	})
+	authenticator := NewLockoutTokenAuthenticator(inner, &LockoutOptions{MaxFailures: 3})
+	ctx := WithClientIP(context.Background(), "10.0.0.1")
+	wg := sync.WaitGroup{}
+	locked := atomic.Int64{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := authenticator.Authenticate(ctx, "token"); errors.Is(err, ErrLockedOut) {
+				locked.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	// concurrent attempts are counted before they are made, so no more than MaxFailures reach the authenticator
+	if got := calls.Load(); got != 3 {
+		t.Errorf("authenticator calls = %d, want 3", got)
+	}
+	if got := locked.Load(); got != 17 {
+		t.Errorf("locked out = %d, want 17", got)
+	}
+}
@@ -2,17 +2,26 @@ package api
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"net/url"
 	"reflect"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode"
 
 	"github.com/go-playground/validator/v10"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/exp/maps"
 	"golang.org/x/exp/slices"
 	"kubegems.io/library/rest/matcher"
 	"kubegems.io/library/rest/request"
+	"kubegems.io/library/rest/response"
 )
 
 type Router interface {
@@ -44,7 +53,7 @@ func MediaTypeCheckFunc(accepts, produces []string, handler http.Handler) http.H
 			UnsupportedMediaType(w, r)
 			return
 		}
-		if len(produces) > 0 && !MatchMIME(r.Header.Get("Accept"), produces) {
+		if len(produces) > 0 && !matchAccept(r.Header.Get("Accept"), produces) {
 			NotAcceptable(w, r)
 			return
 		}
@@ -68,22 +77,161 @@ func MatchMIME(accept string, supported []string) bool {
 	return false
 }
 
+// matchAccept reports whether any of the media ranges in Accept header is supported.
+func matchAccept(accept string, supported []string) bool {
+	for _, item := range strings.Split(accept, ",") {
+		if MatchMIME(item, supported) {
+			return true
+		}
+	}
+	return false
+}
+
+// MethodAny is the method of the handler serving the methods without their own handler.
+const MethodAny = ""
+
+// MethodsHandler holds the handlers of a path by method.
+// A request is served by, in order of precedence:
+// the handler of its method, the GET handler for HEAD if Mux.AutoHead,
+// the automatic OPTIONS answer for CORS preflight or if MethodAny is absent, the MethodAny handler.
+// Otherwise it gets 405 with the Allow header.
 type MethodsHandler map[string]http.Handler
 
+// NotAllowed responds 405 with the Allow header, an OPTIONS request is answered by Options.
 func (h MethodsHandler) NotAllowed(w http.ResponseWriter, r *http.Request) {
-	w.Header().Add("Allow", strings.Join(maps.Keys(h), ","))
 	if r.Method == http.MethodOptions {
-		w.WriteHeader(http.StatusOK)
-	} else {
-		MethodNotAllowed(w, r)
+		h.Options(w, r)
+		return
+	}
+	w.Header().Set("Allow", strings.Join(h.Allow(), ", "))
+	MethodNotAllowed(w, r)
+}
+
+// Options responds an OPTIONS request with the Allow header,
+// a CORS preflight request gets 204 with Access-Control-Allow-Methods as well.
+// The other CORS headers, e.g. Access-Control-Allow-Origin, are left to the CORS filter wrapping the Mux,
+// so the preflight is refused by browsers unless CORS is enabled.
+func (h MethodsHandler) Options(w http.ResponseWriter, r *http.Request) {
+	allow := strings.Join(h.Allow(), ", ")
+	w.Header().Set("Allow", allow)
+	if IsPreflight(r) {
+		w.Header().Set("Access-Control-Allow-Methods", allow)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+var anyMethods = []string{
+	http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+	http.MethodPatch, http.MethodDelete, http.MethodOptions,
+}
+
+// Allow returns the sorted methods served, OPTIONS is always included as it is answered automatically.
+func (h MethodsHandler) Allow() []string {
+	methods := []string{http.MethodOptions}
+	for method := range h {
+		if method == MethodAny {
+			methods = append(methods, anyMethods...)
+		} else {
+			methods = append(methods, method)
+		}
+	}
+	slices.Sort(methods)
+	return slices.Compact(methods)
+}
+
+// withHead returns handlers serving HEAD by the GET handler if HEAD is not registered.
+func (h MethodsHandler) withHead() MethodsHandler {
+	get, ok := h[http.MethodGet]
+	if _, exists := h[http.MethodHead]; !ok || exists {
+		return h
+	}
+	withhead := maps.Clone(h)
+	withhead[http.MethodHead] = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hw := &headResponseWriter{ResponseWriter: w}
+		get.ServeHTTP(hw, r)
+		hw.finish()
+	})
+	return withhead
+}
+
+// headResponseWriter discards the body, headers and status code are written as is.
+// The status code is held until the handler returns, so the Content-Length of the discarded body is set
+// as the server does for GET, unless the handler set one or flushed.
+type headResponseWriter struct {
+	http.ResponseWriter
+	code    int
+	written int64
+	flushed bool
+}
+
+func (w *headResponseWriter) WriteHeader(code int) {
+	if w.flushed {
+		return
+	}
+	// informational responses are sent as is
+	if code >= 100 && code < 200 {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if w.code == 0 {
+		w.code = code
+	}
+}
+
+func (w *headResponseWriter) Write(p []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	w.written += int64(len(p))
+	return len(p), nil
+}
+
+func (w *headResponseWriter) Flush() {
+	w.commit(false)
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
 	}
 }
 
+func (w *headResponseWriter) finish() {
+	w.commit(true)
+}
+
+// commit writes the held status code, with the Content-Length if the body is complete.
+func (w *headResponseWriter) commit(complete bool) {
+	if w.flushed {
+		return
+	}
+	w.flushed = true
+	code := w.code
+	if code == 0 {
+		code = http.StatusOK
+	}
+	header := w.Header()
+	bodyAllowed := code >= 200 && code != http.StatusNoContent && code != http.StatusNotModified
+	if complete && bodyAllowed && header.Get("Content-Length") == "" && header.Get("Transfer-Encoding") == "" {
+		header.Set("Content-Length", strconv.FormatInt(w.written, 10))
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Unwrap is used by http.ResponseController.
+func (w *headResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// IsPreflight reports whether r is a CORS preflight request.
+func IsPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions && r.Header.Get("Origin") != "" && r.Header.Get("Access-Control-Request-Method") != ""
+}
+
 func (h MethodsHandler) selectHandler(r *http.Request) http.Handler {
 	if h == nil || len(h) == 0 {
 		return nil
 	}
-	for _, candidate := range []string{r.Method, ""} {
+	for _, candidate := range []string{r.Method, MethodAny} {
 		if handler, ok := h[candidate]; ok {
 			return handler
 		}
@@ -91,57 +239,420 @@ func (h MethodsHandler) selectHandler(r *http.Request) http.Handler {
 	return nil
 }
 
+// TrailingSlashMode is how Mux handles a path which matches no route but matches one with the trailing slash toggled,
+// e.g. "/zoos/" when only "/zoos" is registered.
+type TrailingSlashMode int
+
+const (
+	TrailingSlashStrict   TrailingSlashMode = iota // responds not found
+	TrailingSlashMatch                             // serves the other route transparently
+	TrailingSlashRedirect                          // redirects to the other path, 301 for GET and HEAD, 308 for other methods
+)
+
 type Mux struct {
 	NotFound         http.Handler
 	MethodNotAllowed http.Handler
 	Tree             matcher.Node[MethodsHandler]
+	Tracer           trace.Tracer // records a span of route matching if set
+	TrailingSlash    TrailingSlashMode
+	AutoHead         bool // GET routes answer HEAD with the same headers and no body, unless HEAD is registered
+	// UseEscapedPath matches the escaped path and unescapes each variable, so "%2F" stays in the variable
+	// instead of splitting segments, e.g. "/v2/{repository}*/manifests/{reference}" of OCI registries.
+	// Constants of patterns are matched as escaped. By default the raw path is matched if set and variables are not unescaped.
+	UseEscapedPath bool
+
+	// mu guards the trees and registrations, routes can be registered while serving if API.HotReload.
+	// MethodsHandler of the nodes are copied on write, so they are used after the lookup without the lock.
+	mu sync.RWMutex
+	// static routes index built by Freeze, dropped on new registrations
+	static atomic.Pointer[map[string]*matcher.Node[MethodsHandler]]
+
+	// routes with a host pattern, the host is matched as a path of its labels, e.g. "/{tenant}/example/com"
+	hosts     matcher.Node[*Mux]
+	hostmuxes []*Mux
+
+	routes []*registration // registered by HandleRoute, in order
+
+	notfounds []prefixHandler // longest prefix first
+}
+
+type prefixHandler struct {
+	prefix  string
+	handler http.Handler
 }
 
 func NewMux() *Mux {
 	return &Mux{}
 }
 
+// Handle registers handler for method on pattern, MethodAny registers it for the methods without their own handler,
+// see MethodsHandler for the precedence.
 func (m *Mux) Handle(method, pattern string, handler http.Handler) error {
+	if err := validatePattern(pattern); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.static.Store(nil)
 	_, node, err := m.Tree.Get(pattern)
 	if err != nil {
 		return err
 	}
-	if node.Value == nil {
-		node.Value = MethodsHandler{}
-	}
 	if _, ok := node.Value[method]; ok {
-		return fmt.Errorf("already registered: %s %s", method, pattern)
+		return fmt.Errorf("already registered: %s %s", methodName(method), pattern)
 	}
-	node.Value[method] = handler
+	node.Value = node.Value.with(method, handler)
 	return nil
 }
 
+// with returns a copy of h with the handler of method set, the served MethodsHandler is never modified.
+func (h MethodsHandler) with(method string, handler http.Handler) MethodsHandler {
+	withmethod := make(MethodsHandler, len(h)+1)
+	maps.Copy(withmethod, h)
+	withmethod[method] = handler
+	return withmethod
+}
+
+// HandleAny registers handler for all the methods on pattern, handlers registered for a method take precedence.
+func (m *Mux) HandleAny(pattern string, handler http.Handler) error {
+	return m.Handle(MethodAny, pattern, handler)
+}
+
+// Mount delegates the requests under prefix to handler, e.g. pprof, debug UIs or another Mux.
+// The prefix is stripped from the request path, "/" is left for the prefix itself,
+// and the stripped part, variables of prefix resolved, is available by MountPrefix.
+func (m *Mux) Mount(prefix string, handler http.Handler) error {
+	prefix = strings.TrimSuffix(prefix, "/")
+	segments := strings.Count(prefix, "/")
+	mounted := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u := *r.URL
+		consumed, rest := cutSegments(u.Path, segments)
+		u.Path = rest
+		if u.RawPath != "" {
+			_, u.RawPath = cutSegments(u.RawPath, segments)
+		}
+		r2 := r.WithContext(context.WithValue(r.Context(), mountPrefixContextKey{}, MountPrefix(r)+consumed))
+		r2.URL = &u
+		handler.ServeHTTP(w, r2)
+	})
+	if prefix != "" {
+		if err := m.Handle(MethodAny, prefix, mounted); err != nil {
+			return err
+		}
+	}
+	return m.Handle(MethodAny, prefix+"/{}*", mounted)
+}
+
+// cutSegments splits p after n segments, the rest starts with "/".
+func cutSegments(p string, n int) (string, string) {
+	end := 0
+	for i := 0; i < n; i++ {
+		next := strings.IndexByte(p[end+1:], '/')
+		if next == -1 {
+			return p, "/"
+		}
+		end += next + 1
+	}
+	return p[:end], p[end:]
+}
+
+type mountPrefixContextKey struct{}
+
+// MountPrefix returns the path prefix stripped by Mount, prefixes of nested mounts are joined.
+func MountPrefix(r *http.Request) string {
+	prefix, _ := r.Context().Value(mountPrefixContextKey{}).(string)
+	return prefix
+}
+
+func methodName(method string) string {
+	if method == MethodAny {
+		return "ANY"
+	}
+	return method
+}
+
 func (m *Mux) SetNotFound(handler http.Handler) {
 	m.NotFound = handler
 }
 
+// SetPrefixNotFound sets the handler of the unmatched paths under prefix, it takes precedence over NotFound,
+// e.g. JSON 404 under "/api/" and index.html under "/ui/". The longest prefix wins,
+// a prefix matches whole segments, "/api/v1" matches "/api/v1" and "/api/v1/zoos" but not "/api/v10".
+func (m *Mux) SetPrefixNotFound(prefix string, handler http.Handler) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.notfounds = slices.DeleteFunc(m.notfounds, func(h prefixHandler) bool { return h.prefix == prefix })
+	m.notfounds = append(m.notfounds, prefixHandler{prefix: prefix, handler: handler})
+	slices.SortStableFunc(m.notfounds, func(a, b prefixHandler) int { return len(b.prefix) - len(a.prefix) })
+}
+
+func (m *Mux) notFound(path string) http.Handler {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, h := range m.notfounds {
+		if rest, ok := strings.CutPrefix(path, h.prefix); ok && (rest == "" || rest[0] == '/' || strings.HasSuffix(h.prefix, "/")) {
+			return h.handler
+		}
+	}
+	if m.NotFound != nil {
+		return m.NotFound
+	}
+	return http.NotFoundHandler()
+}
+
 func (m *Mux) SetMethodNotAllowed(handler http.Handler) {
 	m.MethodNotAllowed = handler
 }
 
+// HandleRoute registers route, a route with Host is served only for the requests to the matched host,
+// and the host variables come before the path variables in PathVars.
+// Routes without Host serve the requests which match no host route.
 func (m *Mux) HandleRoute(route *Route) error {
-	method, pattern := route.Method, route.Path
-	sections, node, err := m.Tree.Get(pattern)
+	return m.RegisterRoute(route, nil)
+}
+
+// RegisterRoute registers route as HandleRoute and calls prepare with the mux locked before the route is served,
+// so prepare can still modify route, e.g. plugins adding filters, while requests are served concurrently.
+func (m *Mux) RegisterRoute(route *Route, prepare func(route *Route) error) error {
+	reg := &registration{route: route, site: callerSite(), mux: m}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if route.Host != "" {
+		hostmux, err := m.hostMux(route.Host)
+		if err != nil {
+			return fmt.Errorf("host %s: %w", route.Host, err)
+		}
+		reg.mux = hostmux
+	}
+	if err := m.register(reg); err != nil {
+		return err
+	}
+	m.routes = append(m.routes, reg)
+	if prepare != nil {
+		return prepare(route)
+	}
+	return nil
+}
+
+// RouteInfo describes a registered route.
+type RouteInfo struct {
+	Method  string   `json:"method,omitempty"` // empty means any method
+	Host    string   `json:"host,omitempty"`
+	Pattern string   `json:"pattern"`
+	Summary string   `json:"summary,omitempty"`
+	Tags    []string `json:"tags,omitempty"`
+	Filters []string `json:"filters,omitempty"` // names by FilterName in processing order, including those added by plugins
+
+	Constraints []string `json:"constraints,omitempty"` // e.g. "query:watch=true"
+}
+
+// Routes returns the routes registered by HandleRoute sorted by pattern, host and method.
+func (m *Mux) Routes() []RouteInfo {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	infos := make([]RouteInfo, 0, len(m.routes))
+	for _, reg := range m.routes {
+		route := reg.route
+		info := RouteInfo{
+			Method:  route.Method,
+			Host:    route.Host,
+			Pattern: route.Path,
+			Summary: route.Summary,
+			Tags:    slices.Clone(route.Tags),
+		}
+		for _, filter := range route.Filters {
+			info.Filters = append(info.Filters, FilterName(filter))
+		}
+		for _, constraint := range route.Constraints {
+			info.Constraints = append(info.Constraints, constraint.String())
+		}
+		infos = append(infos, info)
+	}
+	slices.SortStableFunc(infos, func(a, b RouteInfo) int {
+		if a.Pattern != b.Pattern {
+			return strings.Compare(a.Pattern, b.Pattern)
+		}
+		if a.Host != b.Host {
+			return strings.Compare(a.Host, b.Host)
+		}
+		return strings.Compare(a.Method, b.Method)
+	})
+	return infos
+}
+
+func (m *Mux) register(reg *registration) error {
+	method, pattern := reg.route.Method, reg.route.Path
+	if err := validatePattern(pattern); err != nil {
+		return err
+	}
+	reg.mux.static.Store(nil)
+	sections, node, err := reg.mux.Tree.Get(pattern)
 	if err != nil {
 		return err
 	}
-	if node.Value == nil {
-		node.Value = MethodsHandler{}
+	if reg.route.Priority != 0 {
+		if err := reg.mux.Tree.Prioritize(pattern, reg.route.Priority); err != nil {
+			return err
+		}
 	}
-	if _, ok := node.Value[method]; ok {
-		return fmt.Errorf("already registered: %s %s", method, pattern)
+	existing, ok := node.Value[method]
+	switch {
+	case !ok && len(reg.route.Constraints) == 0:
+		node.Value = node.Value.with(method, reg.route)
+	case !ok:
+		node.Value = node.Value.with(method, &constrainedRoutes{mux: m, routes: []*Route{reg.route}})
+	default:
+		var routes []*Route
+		switch existing := existing.(type) {
+		case *Route:
+			routes = []*Route{existing}
+		case *constrainedRoutes:
+			routes = existing.routes
+		}
+		key := reg.route.methodKey()
+		display := methodName(method) + strings.TrimPrefix(key, method)
+		if routes == nil || slices.ContainsFunc(routes, func(route *Route) bool { return route.methodKey() == key }) {
+			if exists := m.registrationOf(reg.mux, node, method); exists != nil {
+				return fmt.Errorf("already registered: %s %s%s at %s, previously at %s", display, reg.route.Host, pattern, reg.site, exists.site)
+			}
+			return fmt.Errorf("already registered: %s %s%s", display, reg.route.Host, pattern)
+		}
+		routes = append(slices.Clip(routes), reg.route)
+		// more constraints are more specific, registration order otherwise
+		slices.SortStableFunc(routes, func(a, b *Route) int { return len(b.Constraints) - len(a.Constraints) })
+		node.Value = node.Value.with(method, &constrainedRoutes{mux: m, routes: routes})
 	}
-	node.Value[method] = route
+	reg.node, reg.sections = node, sections
 	// complete pathparam from sections if not exists
-	completePathParam(route, sections)
+	completePathParam(reg.route, sections)
 	return nil
 }
 
+// constrainedRoutes serves the first route whose constraints match the request,
+// a request matching none of them is not found.
+type constrainedRoutes struct {
+	mux    *Mux
+	routes []*Route
+}
+
+func (c *constrainedRoutes) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	for _, route := range c.routes {
+		matched := true
+		for _, constraint := range route.Constraints {
+			if !constraint.Match(r) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			route.ServeHTTP(w, r)
+			return
+		}
+	}
+	c.mux.notFound(r.URL.Path).ServeHTTP(w, r)
+}
+
+// Freeze indexes static routes so they are served without walking the tree.
+// Routes can still be registered later, the index is dropped until Freeze is called again.
+func (m *Mux) Freeze() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.freeze()
+}
+
+func (m *Mux) freeze() {
+	static := m.Tree.StaticIndex(func(val MethodsHandler) bool { return len(val) > 0 })
+	m.static.Store(&static)
+	for _, hostmux := range m.hostmuxes {
+		hostmux.freeze() // host muxes are guarded by the lock of m
+	}
+}
+
+func (m *Mux) hostMux(pattern string) (*Mux, error) {
+	hostpattern := hostPatternPath(pattern)
+	if err := validatePattern(hostpattern); err != nil {
+		return nil, err
+	}
+	_, node, err := m.hosts.Get(hostpattern)
+	if err != nil {
+		return nil, err
+	}
+	if node.Value == nil {
+		node.Value = &Mux{}
+		m.hostmuxes = append(m.hostmuxes, node.Value)
+	}
+	return node.Value, nil
+}
+
+// hostPatternPath converts a host pattern to a path pattern, e.g. "{tenant}.example.com" to "/{tenant}/example/com",
+// dots in the variable regexps are kept.
+func hostPatternPath(pattern string) string {
+	sb, depth := strings.Builder{}, 0
+	sb.WriteByte('/')
+	for _, char := range pattern {
+		switch {
+		case char == '{':
+			depth++
+		case char == '}':
+			depth--
+		case char == '.' && depth == 0:
+			char = '/'
+		case depth == 0:
+			char = unicode.ToLower(char)
+		}
+		sb.WriteRune(char)
+	}
+	return strings.TrimSuffix(sb.String(), "/")
+}
+
+// hostPath converts the request host to the path matched by host patterns, the port and the trailing dot are removed.
+func hostPath(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	return "/" + strings.ReplaceAll(host, ".", "/")
+}
+
+// lookup matches path and the path with trailing slash toggled according to mode,
+// node is nil if no route matched, redirect is true if the toggled path should be redirected to.
+func (m *Mux) lookup(path string, mode TrailingSlashMode) (node *matcher.Node[MethodsHandler], vars []matcher.MatchVar, redirect bool) {
+	if node, vars = m.match(path); node != nil && node.Value != nil {
+		return node, vars, false
+	}
+	if mode == TrailingSlashStrict || path == "/" {
+		return nil, nil, false
+	}
+	if node, vars = m.match(toggleTrailingSlash(path)); node != nil && node.Value != nil {
+		if mode == TrailingSlashRedirect {
+			return nil, nil, true
+		}
+		return node, vars, false
+	}
+	return nil, nil, false
+}
+
+func (m *Mux) match(path string) (*matcher.Node[MethodsHandler], []matcher.MatchVar) {
+	if static := m.static.Load(); static != nil {
+		if node, ok := (*static)[path]; ok {
+			return node, nil
+		}
+	}
+	return m.Tree.Match(path, nil)
+}
+
+func validatePattern(pattern string) error {
+	diagnostics := matcher.Validate(pattern)
+	if len(diagnostics) == 0 {
+		return nil
+	}
+	errs := make([]error, len(diagnostics))
+	for i, d := range diagnostics {
+		errs[i] = d
+	}
+	return errors.Join(errs...)
+}
+
 func completePathParam(route *Route, sections []matcher.Section) {
 	vars := []Param{}
 	for _, section := range sections {
@@ -162,6 +673,9 @@ func completePathParam(route *Route, sections []matcher.Section) {
 				if elem.Validate != nil {
 					param.Pattern = elem.Validate.String()
 				}
+				if elem.Type == "int" || elem.Type == "uint" {
+					param.Type = "integer"
+				}
 				vars = append(vars, param)
 			}
 		}
@@ -172,25 +686,73 @@ func completePathParam(route *Route, sections []matcher.Section) {
 
 func (m *Mux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	matchpath := r.URL.Path
-	if r.URL.RawPath != "" {
+	if m.UseEscapedPath {
+		matchpath = r.URL.EscapedPath()
+	} else if r.URL.RawPath != "" {
 		matchpath = r.URL.RawPath
 	}
-	node, vars := m.Tree.Match(matchpath, nil)
-	if node == nil || node.Value == nil {
-		if m.NotFound == nil {
-			http.NotFound(w, r)
-		} else {
-			m.NotFound.ServeHTTP(w, r)
+	var span trace.Span
+	if m.Tracer != nil {
+		_, span = m.Tracer.Start(r.Context(), "route match")
+	}
+	var (
+		node     *matcher.Node[MethodsHandler]
+		vars     []matcher.MatchVar
+		redirect bool
+		handlers MethodsHandler
+	)
+	m.mu.RLock()
+	if len(m.hostmuxes) > 0 {
+		if hostnode, hostvars := m.hosts.Match(hostPath(r.Host), nil); hostnode != nil && hostnode.Value != nil {
+			if node, vars, redirect = hostnode.Value.lookup(matchpath, m.TrailingSlash); node != nil {
+				vars = append(hostvars, vars...)
+			}
 		}
+	}
+	if node == nil && !redirect {
+		node, vars, redirect = m.lookup(matchpath, m.TrailingSlash)
+	}
+	if node != nil {
+		handlers = node.Value
+	}
+	m.mu.RUnlock()
+	if span != nil {
+		span.End()
+	}
+	if redirect {
+		redirectTrailingSlash(w, r)
 		return
 	}
-	reqvars := make([]request.PathVar, len(vars))
-	for i, v := range vars {
-		reqvars[i] = request.PathVar{Key: v.Name, Value: v.Value}
+	if handlers == nil {
+		m.notFound(r.URL.Path).ServeHTTP(w, r)
+		return
+	}
+	// routes without variables are served without copying the request
+	if len(vars) > 0 {
+		reqvars := make([]request.PathVar, len(vars))
+		for i, v := range vars {
+			reqvars[i] = request.PathVar{Key: v.Name, Value: v.Value}
+			if m.UseEscapedPath {
+				if unescaped, err := url.PathUnescape(v.Value); err == nil {
+					reqvars[i].Value = unescaped
+				}
+			}
+		}
+		r = r.WithContext(context.WithValue(r.Context(), httpVarsContextKey{}, reqvars))
 	}
-	r = r.WithContext(context.WithValue(r.Context(), httpVarsContextKey{}, reqvars))
 
-	if handler := node.Value.selectHandler(r); handler != nil {
+	if m.AutoHead && r.Method != http.MethodGet {
+		handlers = handlers.withHead()
+	}
+	// OPTIONS is answered automatically unless registered explicitly,
+	// a preflight never reaches the handler of any method, whose filters like authentication would refuse it.
+	if _, ok := handlers[http.MethodOptions]; r.Method == http.MethodOptions && !ok {
+		if _, isany := handlers[MethodAny]; !isany || IsPreflight(r) {
+			handlers.Options(w, r)
+			return
+		}
+	}
+	if handler := handlers.selectHandler(r); handler != nil {
 		handler.ServeHTTP(w, r)
 		return
 	}
@@ -198,7 +760,27 @@ func (m *Mux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		m.MethodNotAllowed.ServeHTTP(w, r)
 		return
 	}
-	node.Value.NotAllowed(w, r)
+	handlers.NotAllowed(w, r)
+}
+
+func toggleTrailingSlash(p string) string {
+	if trimmed, ok := strings.CutSuffix(p, "/"); ok {
+		return trimmed
+	}
+	return p + "/"
+}
+
+func redirectTrailingSlash(w http.ResponseWriter, r *http.Request) {
+	u := *r.URL
+	u.Path = toggleTrailingSlash(u.Path)
+	if u.RawPath != "" {
+		u.RawPath = toggleTrailingSlash(u.RawPath)
+	}
+	code := http.StatusPermanentRedirect
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		code = http.StatusMovedPermanently
+	}
+	http.Redirect(w, r, u.RequestURI(), code)
 }
 
 type httpVarsContextKey struct{}
@@ -251,7 +833,46 @@ func NewDefauBodyltValidation() func(r *http.Request, data any) error {
 		return NameWithSlashRegexp.MatchString(fl.Field().String())
 	})
 	return func(r *http.Request, data any) error {
-		return v.StructCtx(r.Context(), data)
+		// only structs can be validated, e.g. map or any bodies are skipped
+		rv := reflect.ValueOf(data)
+		for rv.Kind() == reflect.Pointer || rv.Kind() == reflect.Interface {
+			if rv.IsNil() {
+				return nil
+			}
+			rv = rv.Elem()
+		}
+		if rv.Kind() != reflect.Struct {
+			return nil
+		}
+		err := v.StructCtx(r.Context(), rv.Interface())
+		validationErrs := validator.ValidationErrors{}
+		if !errors.As(err, &validationErrs) {
+			return err
+		}
+		fielderrs := make(request.FieldErrors, len(validationErrs))
+		for i, fe := range validationErrs {
+			fielderrs[i] = request.FieldError{Field: fieldPath(fe.Namespace()), Message: fieldMessage(fe)}
+		}
+		return response.NewStatusError(http.StatusBadRequest, fielderrs)
+	}
+}
+
+// fieldPath removes the struct name from namespace, e.g. "Request.spec.name" to "spec.name".
+func fieldPath(namespace string) string {
+	if _, path, ok := strings.Cut(namespace, "."); ok {
+		return path
+	}
+	return namespace
+}
+
+func fieldMessage(fe validator.FieldError) string {
+	switch {
+	case fe.Tag() == "required":
+		return "is required"
+	case fe.Param() != "":
+		return fmt.Sprintf("must satisfy %s=%s", fe.Tag(), fe.Param())
+	default:
+		return "must satisfy " + fe.Tag()
 	}
 }
 
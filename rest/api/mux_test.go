@@ -2,10 +2,17 @@ package api
 
 import (
 	"net/http"
+	"net/http/httptest"
 	"reflect"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 
+	"golang.org/x/exp/slices"
 	"kubegems.io/library/rest/matcher"
+	"kubegems.io/library/rest/request"
+	"kubegems.io/library/rest/response"
 )
 
 type MatchVar = matcher.MatchVar
@@ -167,6 +174,645 @@ func Test_matcher_Match(t *testing.T) {
 			if !reflect.DeepEqual(vars, tt.vars) {
 				t.Errorf("matcher.Match() vars = %v, want %v", vars, tt.vars)
 			}
+			m.Freeze()
+			if frozen, _ := m.match(tt.req); frozen != node {
+				t.Errorf("frozen Mux.match() = %v, want %v", frozen, node)
+			}
+		})
+	}
+}
+
+func TestGroupMediaTypes(t *testing.T) {
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	handler := NewAPI().Group(
+		NewGroup("/api").ContentType("application/json").Accept("application/json").SubGroup(
+			NewGroup("/v1").Route(POST("/items").To(ok)),
+		),
+	).Build()
+	tests := []struct {
+		contentType string
+		accept      string
+		want        int
+	}{
+		{contentType: "application/json", accept: "application/json", want: http.StatusOK},
+		{contentType: "application/json; charset=utf-8", accept: "text/html, application/json;q=0.9", want: http.StatusOK},
+		{contentType: "application/xml", accept: "application/json", want: http.StatusUnsupportedMediaType},
+		{contentType: "application/json", accept: "text/html", want: http.StatusNotAcceptable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.contentType+" "+tt.accept, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/items", nil)
+			req.Header.Set("Content-Type", tt.contentType)
+			req.Header.Set("Accept", tt.accept)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("got status %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestAPIFreeze(t *testing.T) {
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	m := NewAPI().Route(GET("/api/v1").To(ok)).Route(GET("/api/{version}").To(ok)).Freeze()
+
+	rec := httptest.NewRecorder()
+	m.Build().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("got status %d, want %d", rec.Code, http.StatusOK)
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("Route() after Freeze() should panic")
+			}
+		}()
+		m.Route(GET("/api/v2").To(ok))
+	}()
+
+	m.HotReload(true).Route(GET("/api/v2").To(ok))
+	rec = httptest.NewRecorder()
+	m.Build().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v2", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("got status %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+// filterPlugin adds a filter to every route, run with -race to check the route is not served before modified.
+type filterPlugin struct {
+	NoopPlugin
+}
+
+func (filterPlugin) OnRoute(route *Route) error {
+	route.Filters = append(route.Filters, FilterFunc(func(w http.ResponseWriter, r *http.Request, next http.Handler) {
+		w.Header().Set("X-Plugin", "true")
+		next.ServeHTTP(w, r)
+	}))
+	return nil
+}
+
+func TestAPIHotReloadConcurrent(t *testing.T) {
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	m := NewAPI().Plugin(filterPlugin{}).HotReload(true).
+		Route(GET("/api/v1").To(ok)).
+		Route(GET("/api/{version}/zoos").To(ok)).
+		Freeze()
+	handler := m.Build()
+
+	const n = 50
+	done := make(chan struct{})
+	wg := sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			paths := []string{"/api/v1", "/api/v1/zoos", "/api/v2", "/api/v3/zoos/v3", "/api/v10", "/api/v10/zoos"}
+			for {
+				for _, path := range paths {
+					rec := httptest.NewRecorder()
+					handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://a.example.com"+path, nil))
+					if rec.Code == http.StatusOK && rec.Header().Get("X-Plugin") != "true" {
+						t.Errorf("%s served before the plugins modified the route", path)
+					}
+				}
+				select {
+				case <-done:
+					return
+				default:
+				}
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		version := "v" + strconv.Itoa(i+2)
+		m.Route(GET("/api/" + version).To(ok))
+		m.Route(POST("/api/{version}/zoos/" + version).To(ok))
+		m.Route(GET("/api/" + version).OnHost("{tenant}.example.com").To(ok))
+	}
+	close(done)
+	wg.Wait()
+
+	for _, path := range []string{"/api/v1", "/api/v51", "/api/v1/zoos"} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: got status %d, want %d", path, rec.Code, http.StatusOK)
+		}
+	}
+	if got := len(m.Routes()); got != 2+3*n {
+		t.Errorf("got %d routes, want %d", got, 2+3*n)
+	}
+}
+
+func TestRouteFilters(t *testing.T) {
+	trace := func(name string) Filter {
+		return FilterFunc(func(w http.ResponseWriter, r *http.Request, next http.Handler) {
+			w.Header().Add("X-Trace", name)
+			next.ServeHTTP(w, r)
+		})
+	}
+	ok := func(w http.ResponseWriter, r *http.Request) { w.Header().Add("X-Trace", "handler") }
+	base := NewGroup("/api").Use(trace("api")).Use(trace("auth")).Use(trace("audit"))
+	handler := NewAPI().Group(
+		base.Use(trace("v1")).SubGroup(
+			NewGroup("/v1").Use(trace("v1-inner")).Route(
+				GET("/items").Use(trace("route")).To(ok),
+				GET("/others").To(ok),
+			),
+		),
+		// derived from the same group, must not share filters with the above
+		base.Use(trace("v2")).SubGroup(NewGroup("/v2").Route(GET("/items").To(ok))),
+	).Route(GET("/plain").Use(trace("plain")).To(ok)).Build()
+
+	tests := []struct {
+		path string
+		want []string
+	}{
+		{path: "/api/v1/items", want: []string{"api", "auth", "audit", "v1", "v1-inner", "route", "handler"}},
+		{path: "/api/v1/others", want: []string{"api", "auth", "audit", "v1", "v1-inner", "handler"}},
+		{path: "/api/v2/items", want: []string{"api", "auth", "audit", "v2", "handler"}},
+		{path: "/plain", want: []string{"plain", "handler"}},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if got := rec.Header().Values("X-Trace"); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: filters run %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestMuxTrailingSlash(t *testing.T) {
+	ok := func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(r.URL.Path)) }
+	tests := []struct {
+		mode         TrailingSlashMode
+		method       string
+		target       string
+		wantCode     int
+		wantLocation string
+	}{
+		{mode: TrailingSlashStrict, method: http.MethodGet, target: "/zoos/", wantCode: http.StatusNotFound},
+		{mode: TrailingSlashMatch, method: http.MethodGet, target: "/zoos/", wantCode: http.StatusOK},
+		{mode: TrailingSlashMatch, method: http.MethodGet, target: "/animals", wantCode: http.StatusOK},
+		{mode: TrailingSlashMatch, method: http.MethodGet, target: "/zoos", wantCode: http.StatusOK},
+		{mode: TrailingSlashMatch, method: http.MethodGet, target: "/zoos/1/cages/", wantCode: http.StatusOK},
+		{mode: TrailingSlashMatch, method: http.MethodGet, target: "/missing/", wantCode: http.StatusNotFound},
+		{mode: TrailingSlashRedirect, method: http.MethodGet, target: "/zoos/?page=2", wantCode: http.StatusMovedPermanently, wantLocation: "/zoos?page=2"},
+		{mode: TrailingSlashRedirect, method: http.MethodPost, target: "/animals", wantCode: http.StatusPermanentRedirect, wantLocation: "/animals/"},
+	}
+	for _, tt := range tests {
+		handler := NewAPI().TrailingSlash(tt.mode).Route(GET("/zoos").To(ok)).Route(GET("/zoos/{id}/cages").To(ok)).Route(Any("/animals/").To(ok)).Build()
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.target, nil))
+		if rec.Code != tt.wantCode {
+			t.Errorf("%d %s %s: code = %d, want %d", tt.mode, tt.method, tt.target, rec.Code, tt.wantCode)
+		}
+		if location := rec.Header().Get("Location"); location != tt.wantLocation {
+			t.Errorf("%d %s %s: Location = %q, want %q", tt.mode, tt.method, tt.target, location, tt.wantLocation)
+		}
+	}
+}
+
+func TestMuxHost(t *testing.T) {
+	echo := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name + ":" + request.Path(r, "tenant", "") + ":" + request.Path(r, "id", "")))
+		}
+	}
+	handler := NewAPI().
+		Route(GET("/zoos/{id}").To(echo("any"))).
+		Route(GET("/healthz").To(echo("health"))).
+		Route(GET("/zoos/{id}").OnHost("{tenant}.example.com").To(echo("tenant"))).
+		Group(NewGroup("/zoos").OnHost("admin.example.com").Route(GET("/{id}").To(echo("admin")))).
+		Build()
+	tests := []struct {
+		host     string
+		target   string
+		wantCode int
+		wantBody string
+	}{
+		{host: "acme.example.com", target: "/zoos/1", wantCode: http.StatusOK, wantBody: "tenant:acme:1"},
+		{host: "Acme.Example.com:8080", target: "/zoos/1", wantCode: http.StatusOK, wantBody: "tenant:acme:1"},
+		{host: "admin.example.com", target: "/zoos/2", wantCode: http.StatusOK, wantBody: "admin::2"},
+		{host: "acme.example.com", target: "/healthz", wantCode: http.StatusOK, wantBody: "health::"},
+		{host: "a.b.example.com", target: "/zoos/1", wantCode: http.StatusOK, wantBody: "any::1"},
+		{host: "localhost", target: "/zoos/3", wantCode: http.StatusOK, wantBody: "any::3"},
+		{host: "acme.example.com", target: "/missing", wantCode: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+		req.Host = tt.host
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tt.wantCode {
+			t.Errorf("%s%s: code = %d, want %d", tt.host, tt.target, rec.Code, tt.wantCode)
+		}
+		if tt.wantBody != "" && rec.Body.String() != tt.wantBody {
+			t.Errorf("%s%s: body = %q, want %q", tt.host, tt.target, rec.Body.String(), tt.wantBody)
+		}
+	}
+}
+
+func TestMuxRoutes(t *testing.T) {
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	logging := NamedFilter{Name: "logging", Filter: FilterFunc(func(w http.ResponseWriter, r *http.Request, next http.Handler) { next.ServeHTTP(w, r) })}
+	api := NewAPI().
+		Route(POST("/zoos").Doc("create zoo").Tag("zoo").To(ok)).
+		Route(GET("/zoos/{id:[0-9]+}").Use(logging).To(ok)).
+		Route(GET("/zoos").OnHost("{tenant}.example.com").To(ok)).
+		Route(GET("/zoos").To(ok))
+	want := []RouteInfo{
+		{Method: http.MethodGet, Pattern: "/zoos"},
+		{Method: http.MethodPost, Pattern: "/zoos", Summary: "create zoo", Tags: []string{"zoo"}},
+		{Method: http.MethodGet, Host: "{tenant}.example.com", Pattern: "/zoos"},
+		{Method: http.MethodGet, Pattern: "/zoos/{id}", Filters: []string{"logging"}},
+	}
+	if got := api.Routes(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Routes() = %v, want %v", got, want)
+	}
+}
+
+func TestMuxOptions(t *testing.T) {
+	ok := func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(r.Method)) }
+	handler := NewAPI().
+		Route(GET("/zoos").To(ok)).
+		Route(POST("/zoos").To(ok)).
+		Route(Any("/animals").To(ok)).
+		Route(OPTIONS("/cages").To(ok)).
+		Build()
+	tests := []struct {
+		method      string
+		target      string
+		preflight   bool
+		wantCode    int
+		wantAllow   string
+		wantMethods string
+		wantBody    string
+	}{
+		{method: http.MethodOptions, target: "/zoos", wantCode: http.StatusOK, wantAllow: "GET, OPTIONS, POST"},
+		{method: http.MethodDelete, target: "/zoos", wantCode: http.StatusMethodNotAllowed, wantAllow: "GET, OPTIONS, POST"},
+		{method: http.MethodOptions, target: "/zoos", preflight: true, wantCode: http.StatusNoContent, wantAllow: "GET, OPTIONS, POST", wantMethods: "GET, OPTIONS, POST"},
+		{method: http.MethodOptions, target: "/animals", wantCode: http.StatusOK, wantBody: "OPTIONS"},
+		{method: http.MethodOptions, target: "/animals", preflight: true, wantCode: http.StatusNoContent, wantAllow: "DELETE, GET, HEAD, OPTIONS, PATCH, POST, PUT", wantMethods: "DELETE, GET, HEAD, OPTIONS, PATCH, POST, PUT"},
+		{method: http.MethodOptions, target: "/cages", preflight: true, wantCode: http.StatusOK, wantBody: "OPTIONS"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.target, nil)
+		if tt.preflight {
+			req.Header.Set("Origin", "https://example.com")
+			req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tt.wantCode {
+			t.Errorf("%s %s: code = %d, want %d", tt.method, tt.target, rec.Code, tt.wantCode)
+		}
+		if allow := rec.Header().Get("Allow"); allow != tt.wantAllow {
+			t.Errorf("%s %s: Allow = %q, want %q", tt.method, tt.target, allow, tt.wantAllow)
+		}
+		if methods := rec.Header().Get("Access-Control-Allow-Methods"); methods != tt.wantMethods {
+			t.Errorf("%s %s: Access-Control-Allow-Methods = %q, want %q", tt.method, tt.target, methods, tt.wantMethods)
+		}
+		if tt.wantBody != "" && rec.Body.String() != tt.wantBody {
+			t.Errorf("%s %s: body = %q, want %q", tt.method, tt.target, rec.Body.String(), tt.wantBody)
+		}
+	}
+}
+
+func TestMuxAutoHead(t *testing.T) {
+	get := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Method", r.Method)
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("body"))
+	}
+	head := func(w http.ResponseWriter, r *http.Request) { w.Header().Set("X-Head", "true") }
+	tests := []struct {
+		autohead   bool
+		target     string
+		wantCode   int
+		wantHeader string
+	}{
+		{autohead: false, target: "/zoos", wantCode: http.StatusMethodNotAllowed},
+		{autohead: true, target: "/zoos", wantCode: http.StatusAccepted, wantHeader: "HEAD"},
+		{autohead: true, target: "/animals", wantCode: http.StatusOK},
+		{autohead: true, target: "/cages", wantCode: http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		handler := NewAPI().AutoHead(tt.autohead).
+			Route(GET("/zoos").To(get)).
+			Route(GET("/animals").To(get)).Route(HEAD("/animals").To(head)).
+			Route(POST("/cages").To(get)).
+			Build()
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodHead, tt.target, nil))
+		if rec.Code != tt.wantCode {
+			t.Errorf("%v %s: code = %d, want %d", tt.autohead, tt.target, rec.Code, tt.wantCode)
+		}
+		if got := rec.Header().Get("X-Method"); got != tt.wantHeader {
+			t.Errorf("%v %s: X-Method = %q, want %q", tt.autohead, tt.target, got, tt.wantHeader)
+		}
+		if rec.Code != http.StatusMethodNotAllowed && rec.Body.Len() != 0 {
+			t.Errorf("%v %s: body = %q, want empty", tt.autohead, tt.target, rec.Body.String())
+		}
+	}
+	rec := httptest.NewRecorder()
+	NewAPI().AutoHead(true).Route(GET("/zoos").To(get)).Build().ServeHTTP(rec, httptest.NewRequest(http.MethodOptions, "/zoos", nil))
+	if allow := rec.Header().Get("Allow"); allow != "GET, HEAD, OPTIONS" {
+		t.Errorf("Allow = %q, want %q", allow, "GET, HEAD, OPTIONS")
+	}
+}
+
+func TestMuxAutoHeadContentLength(t *testing.T) {
+	handlers := map[string]http.HandlerFunc{
+		"/implicit": func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("hello")) },
+		"/status": func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusAccepted)
+			w.Write([]byte("hello"))
+		},
+		"/explicit": func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Length", "5")
+			w.Write([]byte("hello"))
+		},
+		"/empty":     func(w http.ResponseWriter, r *http.Request) {},
+		"/nocontent": func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) },
+		"/flushed": func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("hello"))
+			w.(http.Flusher).Flush()
+			w.Write([]byte("world"))
+		},
+	}
+	api := NewAPI().AutoHead(true)
+	for path, handler := range handlers {
+		api = api.Route(GET(path).To(handler))
+	}
+	server := httptest.NewServer(api.Build())
+	defer server.Close()
+	for path := range handlers {
+		t.Run(path, func(t *testing.T) {
+			get, err := http.Get(server.URL + path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			get.Body.Close()
+			head, err := http.Head(server.URL + path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			head.Body.Close()
+			if head.StatusCode != get.StatusCode {
+				t.Errorf("HEAD code = %d, GET %d", head.StatusCode, get.StatusCode)
+			}
+			if got, want := head.Header.Get("Content-Length"), get.Header.Get("Content-Length"); got != want {
+				t.Errorf("HEAD Content-Length = %q, GET %q", got, want)
+			}
 		})
 	}
 }
+
+func TestMuxHandleAny(t *testing.T) {
+	named := func(name string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(name)) })
+	}
+	m := NewMux()
+	m.AutoHead = true
+	if err := m.HandleAny("/zoos", named("any")); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Handle(http.MethodGet, "/zoos", named("get")); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.HandleAny("/zoos", named("any")); err == nil || !strings.Contains(err.Error(), "ANY /zoos") {
+		t.Errorf("HandleAny() duplicate error = %v", err)
+	}
+	tests := []struct {
+		method    string
+		preflight bool
+		wantCode  int
+		wantBody  string
+	}{
+		{method: http.MethodGet, wantCode: http.StatusOK, wantBody: "get"},
+		{method: http.MethodPost, wantCode: http.StatusOK, wantBody: "any"},
+		{method: http.MethodHead, wantCode: http.StatusOK},
+		{method: http.MethodOptions, wantCode: http.StatusOK, wantBody: "any"},
+		{method: http.MethodOptions, preflight: true, wantCode: http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, "/zoos", nil)
+		if tt.preflight {
+			req.Header.Set("Origin", "https://example.com")
+			req.Header.Set("Access-Control-Request-Method", http.MethodPut)
+		}
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, req)
+		if rec.Code != tt.wantCode || rec.Body.String() != tt.wantBody {
+			t.Errorf("%s preflight=%v: got %d %q, want %d %q", tt.method, tt.preflight, rec.Code, rec.Body.String(), tt.wantCode, tt.wantBody)
+		}
+	}
+}
+
+func TestMuxPrefixNotFound(t *testing.T) {
+	named := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(name))
+		}
+	}
+	handler := NewAPI().
+		Route(GET("/api/v1/zoos").To(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("zoos")) })).
+		NotFound(named("global")).
+		PrefixNotFound("/api", named("api")).
+		PrefixNotFound("/api/v1", named("v1")).
+		PrefixNotFound("/ui/", named("ui")).
+		Build()
+	tests := []struct {
+		path     string
+		wantBody string
+	}{
+		{path: "/api/v1/zoos", wantBody: "zoos"},
+		{path: "/api/v1/animals", wantBody: "v1"},
+		{path: "/api/v1", wantBody: "v1"},
+		{path: "/api/v10", wantBody: "api"},
+		{path: "/apis", wantBody: "global"},
+		{path: "/ui/assets/app.js", wantBody: "ui"},
+		{path: "/ui", wantBody: "global"},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if rec.Body.String() != tt.wantBody {
+			t.Errorf("%s: body = %q, want %q", tt.path, rec.Body.String(), tt.wantBody)
+		}
+	}
+}
+
+func TestMuxMount(t *testing.T) {
+	echo := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(MountPrefix(r) + " " + r.URL.Path + " " + request.Path(r, "tenant", "")))
+	})
+	inner := NewAPI().Route(GET("/zoos/{id}").To(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(MountPrefix(r) + " " + r.URL.Path + " " + request.Path(r, "id", "")))
+	}))
+	handler := NewAPI().
+		Mount("/plugins/foo", echo).
+		Mount("/tenants/{tenant}/ui/", echo).
+		Mount("/inner", inner.Build()).
+		Route(GET("/plugins/foo/health").To(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("health")) })).
+		Build()
+	tests := []struct {
+		path     string
+		wantBody string
+	}{
+		{path: "/plugins/foo", wantBody: "/plugins/foo / "},
+		{path: "/plugins/foo/", wantBody: "/plugins/foo / "},
+		{path: "/plugins/foo/debug/vars", wantBody: "/plugins/foo /debug/vars "},
+		{path: "/plugins/foo/health", wantBody: "health"},
+		{path: "/tenants/acme/ui/index.html", wantBody: "/tenants/acme/ui /index.html acme"},
+		{path: "/inner/zoos/1", wantBody: "/inner /zoos/1 1"},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if rec.Body.String() != tt.wantBody {
+			t.Errorf("%s: body = %q, want %q", tt.path, rec.Body.String(), tt.wantBody)
+		}
+	}
+}
+
+func TestMuxUseEscapedPath(t *testing.T) {
+	echo := func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(request.Path(r, "repository", "") + " " + request.Path(r, "reference", "")))
+	}
+	tests := []struct {
+		escaped  bool
+		path     string
+		wantBody string
+	}{
+		{escaped: true, path: "/v2/library/nginx/manifests/latest", wantBody: "library/nginx latest"},
+		{escaped: true, path: "/v2/library%2Fnginx/manifests/latest", wantBody: "library/nginx latest"},
+		{escaped: true, path: "/v2/my%20repo/manifests/v%2B1", wantBody: "my repo v+1"},
+		{escaped: false, path: "/v2/library%2Fnginx/manifests/latest", wantBody: "library%2Fnginx latest"},
+	}
+	for _, tt := range tests {
+		handler := NewAPI().UseEscapedPath(tt.escaped).Route(GET("/v2/{repository}*/manifests/{reference}").To(echo)).Build()
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if rec.Body.String() != tt.wantBody {
+			t.Errorf("%v %s: body = %q, want %q", tt.escaped, tt.path, rec.Body.String(), tt.wantBody)
+		}
+	}
+}
+
+func TestMuxConstraints(t *testing.T) {
+	named := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(name)) }
+	}
+	api := NewAPI().
+		Group(NewGroup("/pods").Route(
+			GET("").To(named("list")),
+			GET("").MatchQuery("watch", "true").To(named("watch")),
+		)).
+		Route(GET("/pods").MatchQuery("watch", "true").MatchHeader("Accept", "application/json;stream=watch").To(named("stream"))).
+		Route(POST("/pods").MatchHeader("X-Dry-Run", "").To(named("dryrun")))
+	tests := []struct {
+		method   string
+		target   string
+		header   http.Header
+		wantCode int
+		wantBody string
+	}{
+		{method: http.MethodGet, target: "/pods", wantCode: http.StatusOK, wantBody: "list"},
+		{method: http.MethodGet, target: "/pods?watch=false", wantCode: http.StatusOK, wantBody: "list"},
+		{method: http.MethodGet, target: "/pods?watch=true", wantCode: http.StatusOK, wantBody: "watch"},
+		{method: http.MethodGet, target: "/pods?watch=true", header: http.Header{"Accept": {"application/json;stream=watch"}}, wantCode: http.StatusOK, wantBody: "stream"},
+		{method: http.MethodPost, target: "/pods", header: http.Header{"X-Dry-Run": {"All"}}, wantCode: http.StatusOK, wantBody: "dryrun"},
+		{method: http.MethodPost, target: "/pods", wantCode: http.StatusNotFound},
+	}
+	handler := api.Build()
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.target, nil)
+		for k, v := range tt.header {
+			req.Header[k] = v
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tt.wantCode || (tt.wantBody != "" && rec.Body.String() != tt.wantBody) {
+			t.Errorf("%s %s: got %d %q, want %d %q", tt.method, tt.target, rec.Code, rec.Body.String(), tt.wantCode, tt.wantBody)
+		}
+	}
+	m := NewMux()
+	for i := 0; i < 2; i++ {
+		route := GET("/pods").MatchQuery("watch", "true").To(named("watch"))
+		if err := m.HandleRoute(&route); (err != nil) != (i == 1) {
+			t.Errorf("HandleRoute() #%d error = %v", i, err)
+		}
+	}
+}
+
+func TestMuxPriority(t *testing.T) {
+	named := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(name)) }
+	}
+	tests := []struct {
+		priority int
+		path     string
+		wantBody string
+	}{
+		{priority: 0, path: "/files/a", wantBody: "path"},
+		{priority: 1, path: "/files/a", wantBody: "name"},
+		{priority: 1, path: "/files/a/b", wantBody: "path"},
+		{priority: 1, path: "/files/index", wantBody: "name"}, // priority overrides the score of constants too
+	}
+	for _, tt := range tests {
+		handler := NewAPI().
+			Route(GET("/files/{path}*").To(named("path"))).
+			Route(GET("/files/{name}").WithPriority(tt.priority).To(named("name"))).
+			Route(GET("/files/index").To(named("index"))).
+			Build()
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if rec.Body.String() != tt.wantBody {
+			t.Errorf("priority %d %s: body = %q, want %q", tt.priority, tt.path, rec.Body.String(), tt.wantBody)
+		}
+	}
+}
+
+func TestMuxTypedVars(t *testing.T) {
+	handler := NewAPI().
+		Route(GET("/zoos/{id:int}").To(func(w http.ResponseWriter, r *http.Request) {
+			id, err := request.PathInt(r, "id")
+			if err != nil {
+				response.BadRequest(w, err.Error())
+				return
+			}
+			w.Write([]byte(strconv.FormatInt(id+1, 10)))
+		})).
+		Route(GET("/objects/{uid:uuid}").To(func(w http.ResponseWriter, r *http.Request) {
+			uid, err := request.PathUUID(r, "uid")
+			if err != nil {
+				response.BadRequest(w, err.Error())
+				return
+			}
+			w.Write([]byte(uid))
+		})).
+		Build()
+	tests := []struct {
+		path     string
+		wantCode int
+		wantBody string
+	}{
+		{path: "/zoos/41", wantCode: http.StatusOK, wantBody: "42"},
+		{path: "/zoos/-1", wantCode: http.StatusOK, wantBody: "0"},
+		{path: "/zoos/abc", wantCode: http.StatusNotFound},
+		{path: "/zoos/99999999999999999999", wantCode: http.StatusBadRequest},
+		{path: "/objects/6BA7B810-9DAD-11D1-80B4-00C04FD430C8", wantCode: http.StatusOK, wantBody: "6ba7b810-9dad-11d1-80b4-00c04fd430c8"},
+		{path: "/objects/6ba7b810", wantCode: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if rec.Code != tt.wantCode || (tt.wantBody != "" && rec.Body.String() != tt.wantBody) {
+			t.Errorf("%s: got %d %q, want %d %q", tt.path, rec.Code, rec.Body.String(), tt.wantCode, tt.wantBody)
+		}
+	}
+}
@@ -0,0 +1,279 @@
+// Copyright 2023 The Kubegems Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+	"kubegems.io/library/rest/response"
+)
+
+var _ Plugin = &OIDCLoginPlugin{}
+
+// OIDCLoginPlugin adds browser login with the OAuth2 authorization code flow and PKCE:
+//
+//	GET {prefix}/login?redirect=/path	redirects to the IdP
+//	GET {prefix}/callback			exchanges the code and issues the session
+//	GET {prefix}/logout?redirect=/path	clears the session
+//
+// The session is created by Sessions, so it can be revoked in its store,
+// authenticate requests with NewSessionAuthenticator(plugin.Sessions).
+type OIDCLoginPlugin struct {
+	NoopPlugin
+	Prefix      string          // default "/auth"
+	RedirectURL string          // external url of the callback, e.g. https://example.com/auth/callback
+	Sessions    *SessionManager // issues the session after login, its Secret also signs the login state
+
+	mu            sync.RWMutex // guards oauth2 on reload
+	oauth2        oauth2.Config
+	options       *OIDCOptions
+	authenticator *OIDCAuthenticator
+}
+
+func NewOIDCLoginPlugin(ctx context.Context, opts *OIDCOptions, redirectURL string, sessions *SessionManager) (*OIDCLoginPlugin, error) {
+	if sessions == nil || len(sessions.Secret) < 32 {
+		return nil, fmt.Errorf("session manager with a secret of at least 32 bytes required")
+	}
+	authenticator, err := NewOIDCAuthenticator(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	provider, err := newOIDCProvider(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	scopes := opts.Scope
+	if len(scopes) == 0 {
+		scopes = NewDefaultOIDCOptions().Scope
+	}
+	return &OIDCLoginPlugin{
+		Prefix:      "/auth",
+		RedirectURL: redirectURL,
+		Sessions:    sessions,
+		oauth2: oauth2.Config{
+			ClientID:     opts.ClientID,
+			ClientSecret: opts.ClientSecret,
+			Endpoint:     provider.Endpoint(),
+			RedirectURL:  redirectURL,
+			Scopes:       scopes,
+		},
+		options:       opts,
+		authenticator: authenticator,
+	}, nil
+}
+
+// PrepareReload fetches the provider metadata again, it implements config.Reloadable.
+func (p *OIDCLoginPlugin) PrepareReload(ctx context.Context) (func(), error) {
+	provider, err := newOIDCProvider(ctx, p.options)
+	if err != nil {
+		return nil, err
+	}
+	applyAuthenticator, err := p.authenticator.PrepareReload(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		applyAuthenticator()
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		p.oauth2.Endpoint = provider.Endpoint()
+	}, nil
+}
+
+func (p *OIDCLoginPlugin) oauth2Config() *oauth2.Config {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	config := p.oauth2
+	return &config
+}
+
+func (p *OIDCLoginPlugin) Install(m *API) error {
+	m.Group(NewGroup(p.Prefix).Tag("login").Route(
+		GET("/login").Doc("redirect to the identity provider").
+			Param(QueryParam("redirect", "path to return after login").Optional()).
+			To(p.login),
+		GET("/callback").Doc("login callback of the identity provider").To(p.callback),
+		GET("/logout").Doc("destroy the session").
+			Param(QueryParam("redirect", "path to return after logout").Optional()).
+			To(p.Sessions.LogoutHandler),
+	))
+	return nil
+}
+
+// loginState is kept in a signed cookie during the login.
+type loginState struct {
+	State    string    `json:"state"`
+	Nonce    string    `json:"nonce"`
+	Verifier string    `json:"verifier"`
+	Redirect string    `json:"redirect"`
+	Expiry   time.Time `json:"expiry"`
+}
+
+func (p *OIDCLoginPlugin) login(w http.ResponseWriter, r *http.Request) {
+	state := loginState{
+		State:    randomString(),
+		Nonce:    randomString(),
+		Verifier: oauth2.GenerateVerifier(),
+		Redirect: safeRedirect(r.URL.Query().Get("redirect")),
+		Expiry:   time.Now().Add(10 * time.Minute),
+	}
+	value, err := p.sign(state)
+	if err != nil {
+		response.Error(w, response.NewStatusError(http.StatusInternalServerError, err))
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     p.stateCookieName(),
+		Value:    value,
+		Path:     path.Join(p.Prefix, "/callback"),
+		Expires:  state.Expiry,
+		HttpOnly: true,
+		Secure:   !p.Sessions.Insecure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	authurl := p.oauth2Config().AuthCodeURL(state.State, oidc.Nonce(state.Nonce), oauth2.S256ChallengeOption(state.Verifier))
+	http.Redirect(w, r, authurl, http.StatusFound)
+}
+
+func (p *OIDCLoginPlugin) callback(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if errcode := query.Get("error"); errcode != "" {
+		response.Unauthorized(w, fmt.Sprintf("login failed: %s %s", errcode, query.Get("error_description")))
+		return
+	}
+	cookie, err := r.Cookie(p.stateCookieName())
+	if err != nil {
+		response.BadRequest(w, "login state not found, please login again")
+		return
+	}
+	state := loginState{}
+	if err := p.verify(cookie.Value, &state); err != nil || time.Now().After(state.Expiry) {
+		response.BadRequest(w, "invalid login state, please login again")
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(state.State), []byte(query.Get("state"))) != 1 {
+		response.BadRequest(w, "login state mismatch")
+		return
+	}
+	ctx := p.options.clientContext(r.Context())
+	token, err := p.oauth2Config().Exchange(ctx, query.Get("code"), oauth2.VerifierOption(state.Verifier))
+	if err != nil {
+		response.Unauthorized(w, fmt.Sprintf("exchange code: %v", err))
+		return
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		response.Unauthorized(w, "no id_token in token response")
+		return
+	}
+	idtoken, err := p.authenticator.verifier().Verify(ctx, rawIDToken)
+	if err != nil {
+		response.Unauthorized(w, fmt.Sprintf("verify id_token: %v", err))
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(idtoken.Nonce), []byte(state.Nonce)) != 1 {
+		response.Unauthorized(w, "id_token nonce mismatch")
+		return
+	}
+	info, err := p.authenticator.Authenticate(ctx, rawIDToken)
+	if err != nil {
+		response.Unauthorized(w, err.Error())
+		return
+	}
+	if _, err := p.Sessions.Create(r.Context(), w, info.User); err != nil {
+		response.Error(w, response.NewStatusError(http.StatusInternalServerError, err))
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: p.stateCookieName(), Path: cookie.Path, MaxAge: -1})
+	http.Redirect(w, r, state.Redirect, http.StatusFound)
+}
+
+func (p *OIDCLoginPlugin) stateCookieName() string {
+	return p.Sessions.cookieName() + "_login"
+}
+
+// sign encodes v as "base64(json).base64(hmac)".
+func (p *OIDCLoginPlugin) sign(v any) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(p.mac(payload)), nil
+}
+
+func (p *OIDCLoginPlugin) verify(value string, into any) error {
+	payload, sig, ok := strings.Cut(value, ".")
+	if !ok {
+		return fmt.Errorf("malformed value")
+	}
+	expected, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(expected, p.mac(payload)) {
+		return fmt.Errorf("invalid signature")
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, into)
+}
+
+func (p *OIDCLoginPlugin) mac(payload string) []byte {
+	h := hmac.New(sha256.New, []byte(p.Sessions.Secret))
+	h.Write([]byte(payload))
+	return h.Sum(nil)
+}
+
+func randomString() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// safeRedirect allows local paths only to avoid open redirects.
+// Browsers drop tabs and newlines and read backslashes as slashes, e.g. "/\t/evil.com" is "//evil.com",
+// so control characters and backslashes are refused before the url is parsed.
+func safeRedirect(redirect string) string {
+	if !strings.HasPrefix(redirect, "/") || strings.HasPrefix(redirect, "//") || strings.ContainsRune(redirect, '\\') {
+		return "/"
+	}
+	for _, c := range redirect {
+		if unicode.IsControl(c) {
+			return "/"
+		}
+	}
+	u, err := url.Parse(redirect)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.User != nil {
+		return "/"
+	}
+	return redirect
+}
@@ -0,0 +1,179 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v3"
+	"github.com/go-jose/go-jose/v3/jwt"
+)
+
+// newTestIdP serves the discovery, jwks and token endpoints, the id token has the nonce of the last authorize request.
+func newTestIdP(t *testing.T) *httptest.Server {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: jose.JSONWebKey{Key: key, KeyID: "test"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var server *httptest.Server
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                 server.URL,
+			"authorization_endpoint": server.URL + "/authorize",
+			"token_endpoint":         server.URL + "/token",
+			"jwks_uri":               server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Key: &key.PublicKey, KeyID: "test", Algorithm: "RS256", Use: "sig"}}})
+	})
+	nonces := map[string]string{} // code -> nonce
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("code_challenge_method") != "S256" {
+			http.Error(w, "pkce required", http.StatusBadRequest)
+			return
+		}
+		nonces["code"] = r.URL.Query().Get("nonce")
+		http.Redirect(w, r, r.URL.Query().Get("redirect_uri")+"?code=code&state="+r.URL.Query().Get("state"), http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("code_verifier") == "" {
+			http.Error(w, "no code_verifier", http.StatusBadRequest)
+			return
+		}
+		idtoken, _ := jwt.Signed(signer).Claims(jwt.Claims{
+			Issuer:   server.URL,
+			Subject:  "1001",
+			Audience: jwt.Audience{"client"},
+			Expiry:   jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		}).Claims(map[string]any{"name": "alice", "groups": []string{"dev"}, "nonce": nonces[r.Form.Get("code")]}).CompactSerialize()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"access_token": "at", "token_type": "Bearer", "id_token": idtoken})
+	})
+	server = httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestOIDCLoginPlugin(t *testing.T) {
+	idp := newTestIdP(t)
+	ctx := context.Background()
+	opts := &OIDCOptions{Issuer: idp.URL, ClientID: "client"}
+	sessions, err := NewSessionManager(NewMemorySessionStore(), "0123456789abcdef0123456789abcdef")
+	if err != nil {
+		t.Fatal(err)
+	}
+	plugin, err := NewOIDCLoginPlugin(ctx, opts, "http://app.local/auth/callback", sessions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := NewAPI().Plugin(plugin).Build()
+
+	// login redirects to the idp with the state cookie
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/auth/login?redirect=/home", nil))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("login status = %d", rec.Code)
+	}
+	statecookie := rec.Result().Cookies()[0]
+
+	// the idp redirects back with code
+	resp, err := (&http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}).Get(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	callback, _ := url.Parse(resp.Header.Get("Location"))
+
+	tests := []struct {
+		name       string
+		query      string
+		cookie     *http.Cookie
+		wantStatus int
+	}{
+		{name: "no state cookie", query: callback.RawQuery, wantStatus: http.StatusBadRequest},
+		{name: "state mismatch", query: "code=code&state=other", cookie: statecookie, wantStatus: http.StatusBadRequest},
+		{name: "ok", query: callback.RawQuery, cookie: statecookie, wantStatus: http.StatusFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/auth/callback?"+tt.query, nil)
+			if tt.cookie != nil {
+				req.AddCookie(tt.cookie)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("callback status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantStatus != http.StatusFound {
+				return
+			}
+			if location := rec.Header().Get("Location"); location != "/home" {
+				t.Errorf("redirect = %s, want /home", location)
+			}
+			authed := httptest.NewRequest(http.MethodGet, "/", nil)
+			for _, c := range rec.Result().Cookies() {
+				if c.Name == sessions.CookieName {
+					authed.AddCookie(c)
+				}
+			}
+			authenticator := NewSessionAuthenticator(plugin.Sessions)
+			info, err := authenticator.Authenticate(ctx, authed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.User.Name != "alice" || info.User.ID != "1001" || len(info.User.Groups) != 1 {
+				t.Errorf("session user = %+v", info.User)
+			}
+			// logout revokes the session in the store, a copy of the cookie is refused
+			logout := httptest.NewRequest(http.MethodGet, "/auth/logout?redirect=/bye", nil)
+			logout.AddCookie(authed.Cookies()[0])
+			rec = httptest.NewRecorder()
+			handler.ServeHTTP(rec, logout)
+			if location := rec.Header().Get("Location"); rec.Code != http.StatusFound || location != "/bye" {
+				t.Errorf("logout = %d %s, want redirect to /bye", rec.Code, location)
+			}
+			if _, err := authenticator.Authenticate(ctx, authed); err == nil {
+				t.Errorf("session authenticated after logout")
+			}
+		})
+	}
+}
+
+func TestSafeRedirect(t *testing.T) {
+	tests := map[string]string{
+		"":                   "/",
+		"/home":              "/home",
+		"//evil.com":         "/",
+		"/\\evil.com":        "/",
+		"https://evil.com/x": "/",
+		"/\t/evil.com":       "/",
+		"/\n/evil.com":       "/",
+		"/\r\n//evil.com":    "/",
+		"/a\\b":              "/",
+		"/home?q=1#top":      "/home?q=1#top",
+		"/%2F/evil.com":      "/%2F/evil.com",
+		"http:/evil.com":     "/",
+		"/\x7f/evil.com":     "/",
+	}
+	for redirect, want := range tests {
+		if got := safeRedirect(redirect); got != want {
+			t.Errorf("safeRedirect(%q) = %q, want %q", redirect, got, want)
+		}
+	}
+}
@@ -1,20 +1,32 @@
 package api
 
 import (
+	"errors"
 	"net/http"
 
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/trace"
 	"kubegems.io/library/rest/request"
 	"kubegems.io/library/rest/response"
 )
 
+// ErrAdminFiltersRequired is returned by plugins exposing admin endpoints when installed without filters,
+// the endpoints would be served to anyone otherwise.
+var ErrAdminFiltersRequired = errors.New("filters required to protect the admin endpoints, e.g. authentication and authorization")
+
 type Plugin interface {
 	Install(m *API) error
 	OnRoute(route *Route) error
 }
 
+// FreezePlugin is implemented by plugins which prepare content once routes are finalized by API.Freeze.
+type FreezePlugin interface {
+	Plugin
+	OnFreeze(m *API) error
+}
+
 type NoopPlugin struct{}
 
 func (n NoopPlugin) Install(m *API) error {
@@ -57,18 +69,35 @@ func (h HealthCheckPlugin) Install(m *API) error {
 
 type OpenTelemetryPlugin struct {
 	TraceProvider trace.TracerProvider
+	// Debug records spans of route matching and each route filter, to diagnose latency inside the middleware stack.
+	// Install it after the other plugins so that the filters they add are traced.
+	Debug bool
 }
 
 func (o OpenTelemetryPlugin) Install(m *API) error {
+	if mux, ok := m.mux.(*Mux); ok && o.Debug {
+		mux.Tracer = o.tracer()
+	}
 	return nil
 }
 
+func (o OpenTelemetryPlugin) tracer() trace.Tracer {
+	if o.TraceProvider == nil {
+		return otel.GetTracerProvider().Tracer(tracerName)
+	}
+	return o.TraceProvider.Tracer(tracerName)
+}
+
 func (o OpenTelemetryPlugin) OnRoute(route *Route) error {
+	if o.Debug {
+		route.Filters = TraceFilters(o.tracer(), route.Filters)
+	}
 	route.Handler = otelhttp.WithRouteTag(route.Path, route.Handler)
 	// inject filter
 	midware := otelhttp.NewMiddleware(route.Path, otelhttp.WithTracerProvider(o.TraceProvider))
 	filter := FilterFunc(func(w http.ResponseWriter, r *http.Request, next http.Handler) {
 		nn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			setRequestIDAttribute(r.Context())
 			next.ServeHTTP(w, r)
 
 			vars := request.PathVars(r)
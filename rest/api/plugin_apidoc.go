@@ -2,21 +2,34 @@ package api
 
 import (
 	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"html/template"
 	"net/http"
 	"path"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/go-openapi/spec"
 	"kubegems.io/library/rest/openapi"
 	"kubegems.io/library/rest/response"
+	"sigs.k8s.io/yaml"
 )
 
-var _ Plugin = (*APIDocPlugin)(nil)
+var _ FreezePlugin = (*APIDocPlugin)(nil)
 
 type APIDocPlugin struct {
 	Bbasepath string
 	Swagger   *spec.Swagger
 	Builder   *openapi.Builder
+	Version   string // version served without the version query, "2.0" (default), "3.0" or "3.1"
+
+	mu      sync.Mutex
+	specs   map[string]*staticContent // cached marshaled spec by version and format, emptied if routes changed
+	modtime time.Time                 // last time routes changed
 }
 
 func NewAPIDocPlugin(basepath string, fn func(swagger *spec.Swagger)) *APIDocPlugin {
@@ -40,32 +53,189 @@ func NewAPIDocPlugin(basepath string, fn func(swagger *spec.Swagger)) *APIDocPlu
 // Install implements Plugin.
 func (s *APIDocPlugin) Install(m *API) error {
 	specpath := path.Join(s.Bbasepath, "/openapi.json")
-	m.Route(GET(specpath).Doc("swagger api doc").To(func(w http.ResponseWriter, r *http.Request) {
-		response.Raw(w, http.StatusOK, s.Swagger, nil)
-	}))
+	m.Route(GET(specpath).Doc("swagger api doc, yaml if accepts application/yaml").
+		Param(QueryParam("version", "openapi version").In("2.0", "3.0", "3.1").Optional()).
+		To(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept")
+			s.serveSpec(w, r, acceptsYAML(r))
+		}))
+	m.Route(GET(path.Join(s.Bbasepath, "/api.yaml")).Doc("swagger api doc in yaml").
+		Param(QueryParam("version", "openapi version").In("2.0", "3.0", "3.1").Optional()).
+		To(func(w http.ResponseWriter, r *http.Request) {
+			s.serveSpec(w, r, true)
+		}))
 	// UI
-	swaggerui, redocui := NewSwaggerUI(specpath), NewRedocUI(specpath)
+	now := time.Now()
+	swaggerui := newStaticContent("text/html", NewSwaggerUI(specpath), now)
+	redocui := newStaticContent("text/html", NewRedocUI(specpath), now)
 	m.Route(GET(s.Bbasepath).
 		Doc("swagger api html").
 		Param(QueryParam("provider", "UI provider").In("swagger", "redoc")).
 		To(func(w http.ResponseWriter, r *http.Request) {
 			switch r.URL.Query().Get("provider") {
 			case "swagger", "":
-				renderHTML(w, swaggerui)
+				swaggerui.ServeHTTP(w, r)
 			case "redoc":
-				renderHTML(w, redocui)
+				redocui.ServeHTTP(w, r)
 			}
 		}),
 	)
 	return nil
 }
 
+func (s *APIDocPlugin) serveSpec(w http.ResponseWriter, r *http.Request, isyaml bool) {
+	content, err := s.specContent(r.URL.Query().Get("version"), isyaml)
+	if err != nil {
+		response.Error(w, err)
+		return
+	}
+	content.ServeHTTP(w, r)
+}
+
+// acceptsYAML reports whether the Accept header of r prefers yaml over json.
+func acceptsYAML(r *http.Request) bool {
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediatype, _, _ := strings.Cut(accept, ";")
+		switch strings.TrimSpace(mediatype) {
+		case "application/yaml", "application/x-yaml", "text/yaml", "text/x-yaml":
+			return true
+		case "application/json":
+			return false
+		}
+	}
+	return false
+}
+
 // OnRoute implements Plugin.
 func (s *APIDocPlugin) OnRoute(route *Route) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	addSwaggerOperation(s.Swagger, *route, s.Builder)
+	s.specs, s.modtime = nil, time.Now()
 	return nil
 }
 
+// OnFreeze implements FreezePlugin, the spec is built ahead of the first request.
+func (s *APIDocPlugin) OnFreeze(m *API) error {
+	_, err := s.specContent("", false)
+	return err
+}
+
+// SecurityScheme declares the security scheme required by routes with Route.Security(name),
+// e.g. openapi.BearerAuth("JWT"), spec.APIKeyAuth("X-Api-Key", "header") or spec.OAuth2AccessToken(authURL, tokenURL).
+func (s *APIDocPlugin) SecurityScheme(name string, scheme *spec.SecurityScheme) *APIDocPlugin {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Swagger.SecurityDefinitions == nil {
+		s.Swagger.SecurityDefinitions = spec.SecurityDefinitions{}
+	}
+	s.Swagger.SecurityDefinitions[name] = scheme
+	s.specs, s.modtime = nil, time.Now()
+	return s
+}
+
+// Invalidate drops the cached spec, call it after modifying Swagger directly.
+func (s *APIDocPlugin) Invalidate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.specs, s.modtime = nil, time.Now()
+}
+
+// Document returns the document of version, "2.0" returns Swagger and "3.0" or "3.1" an openapi.Document converted from it.
+func (s *APIDocPlugin) Document(version string) (any, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.document(version)
+}
+
+func (s *APIDocPlugin) document(version string) (any, error) {
+	if version == "" {
+		version = s.Version
+	}
+	switch version {
+	case "", "2", "2.0":
+		return s.Swagger, nil
+	case "3", "3.0":
+		return openapi.ConvertV3(s.Swagger, openapi.VersionV30)
+	case "3.1":
+		return openapi.ConvertV3(s.Swagger, openapi.VersionV31)
+	default:
+		return nil, response.NewStatusErrorMessage(http.StatusBadRequest, "unsupported openapi version "+version)
+	}
+}
+
+// specContent returns the cached spec of version in json or yaml, it is rebuilt lazily after routes changed.
+func (s *APIDocPlugin) specContent(version string, isyaml bool) (*staticContent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, contenttype := version, "application/json"
+	if isyaml {
+		key, contenttype = version+"+yaml", "application/yaml"
+	}
+	if content, ok := s.specs[key]; ok {
+		return content, nil
+	}
+	doc, err := s.document(version)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	if isyaml {
+		if data, err = yaml.JSONToYAML(data); err != nil {
+			return nil, err
+		}
+	}
+	if s.modtime.IsZero() {
+		s.modtime = time.Now()
+	}
+	if s.specs == nil {
+		s.specs = map[string]*staticContent{}
+	}
+	s.specs[key] = newStaticContent(contenttype, data, s.modtime)
+	return s.specs[key], nil
+}
+
+// staticContent is a precomputed response with a gzip variant,
+// served with ETag/Last-Modified so clients can make conditional requests.
+type staticContent struct {
+	contentType string
+	modtime     time.Time
+	etag        string
+	data        []byte
+	gzipped     []byte
+}
+
+func newStaticContent(contenttype string, data []byte, modtime time.Time) *staticContent {
+	sum := sha256.Sum256(data)
+	content := &staticContent{
+		contentType: contenttype,
+		modtime:     modtime,
+		etag:        `"` + hex.EncodeToString(sum[:16]) + `"`,
+		data:        data,
+	}
+	buf := bytes.NewBuffer(nil)
+	gw := gzip.NewWriter(buf)
+	if _, err := gw.Write(data); err == nil && gw.Close() == nil {
+		content.gzipped = buf.Bytes()
+	}
+	return content
+}
+
+func (c *staticContent) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	data, etag := c.data, c.etag
+	w.Header().Add("Vary", "Accept-Encoding")
+	if c.gzipped != nil && strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+		data, etag = c.gzipped, strings.TrimSuffix(c.etag, `"`)+`-gzip"`
+		w.Header().Set("Content-Encoding", "gzip")
+	}
+	w.Header().Set("Content-Type", c.contentType)
+	w.Header().Set("ETag", etag)
+	http.ServeContent(w, r, "", c.modtime, bytes.NewReader(data))
+}
+
 func addSwaggerOperation(swagger *spec.Swagger, route Route, builder *openapi.Builder) {
 	operation := buildRouteOperation(route, builder)
 	if swagger.Paths == nil {
@@ -110,6 +280,20 @@ func buildRouteOperation(route Route, builder *openapi.Builder) *spec.Operation
 			Consumes:    route.Consumes,
 			Produces:    route.Produces,
 			Deprecated:  route.Deprecated,
+			Security: func() []map[string][]string {
+				var requirements []map[string][]string
+				for _, requirement := range route.SecurityRequirements {
+					scopes := map[string][]string{}
+					for name, s := range requirement {
+						if s == nil {
+							s = []string{}
+						}
+						scopes[name] = s
+					}
+					requirements = append(requirements, scopes)
+				}
+				return requirements
+			}(),
 			Parameters: func() []spec.Parameter {
 				var parameters []spec.Parameter
 				for _, param := range route.Params {
@@ -160,6 +344,7 @@ func buildRouteOperation(route Route, builder *openapi.Builder) *spec.Operation
 				},
 			},
 		},
+		VendorExtensible: spec.VendorExtensible{Extensions: propertyExtensions(route.Properties)},
 	}
 }
 
@@ -266,8 +451,3 @@ func render(specPath string, htmltemplate string) []byte {
 	})
 	return buf.Bytes()
 }
-
-func renderHTML(w http.ResponseWriter, html []byte) {
-	w.Header().Set("Content-Type", "text/html")
-	w.Write(html)
-}
@@ -0,0 +1,192 @@
+package api
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/go-openapi/spec"
+	"kubegems.io/library/rest/openapi"
+	"sigs.k8s.io/yaml"
+)
+
+func TestAPIDocPluginConditionalGet(t *testing.T) {
+	doc := NewAPIDocPlugin("/docs", nil)
+	handler := NewAPI().Plugin(doc).Route(GET("/foo").To(func(w http.ResponseWriter, r *http.Request) {})).Build()
+
+	get := func(header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/docs/openapi.json", nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	first := get(nil)
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || etag == "" {
+		t.Fatalf("unexpected response: %d %v", first.Code, first.Header())
+	}
+	if got := get(http.Header{"If-None-Match": {etag}}); got.Code != http.StatusNotModified {
+		t.Errorf("If-None-Match: got %d, want %d", got.Code, http.StatusNotModified)
+	}
+	gz := get(http.Header{"Accept-Encoding": {"gzip"}})
+	if gz.Header().Get("Content-Encoding") != "gzip" || gz.Header().Get("ETag") == etag {
+		t.Errorf("gzip variant: unexpected header %v", gz.Header())
+	}
+
+	// new route invalidates the cached spec
+	doc.OnRoute(&Route{Method: http.MethodGet, Path: "/bar"})
+	if got := get(http.Header{"If-None-Match": {etag}}); got.Code != http.StatusOK || got.Header().Get("ETag") == etag {
+		t.Errorf("after route change: got %d etag %s", got.Code, got.Header().Get("ETag"))
+	}
+}
+
+func TestAPIDocPluginCompressionFilter(t *testing.T) {
+	doc := NewAPIDocPlugin("/docs", nil)
+	mux := NewAPI().Plugin(doc).Route(GET("/foo").To(func(w http.ResponseWriter, r *http.Request) {})).Build()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		NewCompressionFilter().Process(w, r, mux)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/docs/openapi.json", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if got := rec.Header().Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("Content-Encoding = %q, want gzip", got)
+	}
+	gr, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(gr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// compressed once only
+	got := map[string]any{}
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("decompressed body is not json: %v", err)
+	}
+	if _, ok := got["swagger"]; !ok {
+		t.Errorf("swagger not found in %s", data)
+	}
+}
+
+func TestAPIDocPluginVersion(t *testing.T) {
+	doc := NewAPIDocPlugin("/docs", nil)
+	handler := NewAPI().Plugin(doc).Route(POST("/zoos").Param(BodyParam("zoo", map[string]string{})).To(func(w http.ResponseWriter, r *http.Request) {})).Build()
+	tests := []struct {
+		query    string
+		wantCode int
+		wantKey  string
+	}{
+		{query: "", wantCode: http.StatusOK, wantKey: "swagger"},
+		{query: "?version=3.0", wantCode: http.StatusOK, wantKey: "openapi"},
+		{query: "?version=3.1", wantCode: http.StatusOK, wantKey: "openapi"},
+		{query: "?version=4", wantCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/docs/openapi.json"+tt.query, nil))
+		if rec.Code != tt.wantCode {
+			t.Errorf("%s: code = %d, want %d", tt.query, rec.Code, tt.wantCode)
+			continue
+		}
+		if tt.wantKey == "" {
+			continue
+		}
+		got := map[string]any{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := got[tt.wantKey]; !ok {
+			t.Errorf("%s: %q not found in %s", tt.query, tt.wantKey, rec.Body.String())
+		}
+	}
+}
+
+func TestAPIDocPluginYAML(t *testing.T) {
+	doc := NewAPIDocPlugin("/docs", nil)
+	handler := NewAPI().Plugin(doc).Route(GET("/foo").To(func(w http.ResponseWriter, r *http.Request) {})).Build()
+	tests := []struct {
+		path            string
+		accept          string
+		wantContentType string
+		wantKey         string
+	}{
+		{path: "/docs/openapi.json", wantContentType: "application/json", wantKey: "swagger"},
+		{path: "/docs/openapi.json", accept: "application/json, application/yaml", wantContentType: "application/json", wantKey: "swagger"},
+		{path: "/docs/openapi.json", accept: "application/yaml", wantContentType: "application/yaml", wantKey: "swagger"},
+		{path: "/docs/openapi.json", accept: "text/yaml;q=0.9, */*", wantContentType: "application/yaml", wantKey: "swagger"},
+		{path: "/docs/api.yaml", wantContentType: "application/yaml", wantKey: "swagger"},
+		{path: "/docs/api.yaml?version=3.1", wantContentType: "application/yaml", wantKey: "openapi"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		if tt.accept != "" {
+			req.Header.Set("Accept", tt.accept)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s %s: code = %d, want %d", tt.path, tt.accept, rec.Code, http.StatusOK)
+			continue
+		}
+		if got := rec.Header().Get("Content-Type"); got != tt.wantContentType {
+			t.Errorf("%s %s: content type = %s, want %s", tt.path, tt.accept, got, tt.wantContentType)
+		}
+		got := map[string]any{}
+		if err := yaml.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := got[tt.wantKey]; !ok {
+			t.Errorf("%s %s: %q not found in %s", tt.path, tt.accept, tt.wantKey, rec.Body.String())
+		}
+	}
+}
+
+func TestAPIDocPluginSecurity(t *testing.T) {
+	doc := NewAPIDocPlugin("/docs", nil).
+		SecurityScheme("jwt", openapi.BearerAuth("JWT")).
+		SecurityScheme("oauth", spec.OAuth2AccessToken("https://example.com/auth", "https://example.com/token"))
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	NewAPI().Plugin(doc).Group(
+		NewGroup("/zoos").Security("jwt").Route(
+			GET("").To(ok),
+			POST("").Security("oauth", "write").To(ok),
+		),
+	)
+	v2, err := doc.Document("2.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	item := v2.(*spec.Swagger).Paths.Paths["/zoos"]
+	if got, want := item.Get.Security, []map[string][]string{{"jwt": {}}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("GET security = %v, want %v", got, want)
+	}
+	if got, want := item.Post.Security, []map[string][]string{{"jwt": {}}, {"oauth": {"write"}}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("POST security = %v, want %v", got, want)
+	}
+	v3, err := doc.Document("3.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	schemes := v3.(*openapi.Document).Components.SecuritySchemes
+	if jwt := schemes["jwt"]; jwt.Type != "http" || jwt.Scheme != "bearer" || jwt.BearerFormat != "JWT" {
+		t.Errorf("jwt scheme = %+v", jwt)
+	}
+	if oauth := schemes["oauth"]; oauth.Flows == nil || oauth.Flows.AuthorizationCode == nil {
+		t.Errorf("oauth scheme = %+v", oauth)
+	}
+	if got := v3.(*openapi.Document).Paths["/zoos"]["post"].Security; len(got) != 2 {
+		t.Errorf("v3 POST security = %v", got)
+	}
+}
@@ -0,0 +1,273 @@
+// Copyright 2023 The Kubegems Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+	"kubegems.io/library/rest/response"
+)
+
+const redactedValue = "******"
+
+var (
+	DefaultRedactHeaders   = []string{"Authorization", "X-Authorization", "Cookie", "Set-Cookie", "Proxy-Authorization"}
+	DefaultRedactFieldKeys = []string{
+		"password", "secret", "token",
+		"accessToken", "refreshToken", "clientSecret", "idToken", "apiKey",
+		"access_token", "refresh_token", "client_secret", "id_token", "api_key",
+	}
+)
+
+type FlightRecord struct {
+	Time           time.Time         `json:"time"`
+	Duration       string            `json:"duration"`
+	User           string            `json:"user,omitempty"`
+	Method         string            `json:"method"`
+	URL            string            `json:"url"`
+	RequestHeader  map[string]string `json:"requestHeader,omitempty"`
+	RequestBody    string            `json:"requestBody,omitempty"`
+	StatusCode     int               `json:"statusCode"`
+	ResponseHeader map[string]string `json:"responseHeader,omitempty"`
+	ResponseBody   string            `json:"responseBody,omitempty"`
+}
+
+var _ Plugin = &FlightRecorderPlugin{}
+
+// FlightRecorderPlugin keeps the last N requests/responses of each route in memory for debugging,
+// bodies are truncated and secrets are redacted.
+// Install it before registering routes, records are exposed at "{Prefix}/records?route=GET /path".
+type FlightRecorderPlugin struct {
+	Prefix          string   // admin endpoint prefix, default "/debug/flight-recorder"
+	Size            int      // records kept per route, default 20
+	MaxBodySize     int      // max recorded body size, default 4KB
+	RedactHeaders   []string // default DefaultRedactHeaders
+	RedactFieldKeys []string // json field and form field names to redact, case insensitive, default DefaultRedactFieldKeys
+	Filters         Filters  // filters of the admin endpoint, e.g. authentication
+
+	mu          sync.Mutex
+	records     map[string]*recordRing
+	redactTexts []*regexp.Regexp
+	redactForms []*regexp.Regexp
+}
+
+type recordRing struct {
+	items []FlightRecord
+	next  int
+}
+
+func (ring *recordRing) add(size int, record FlightRecord) {
+	if len(ring.items) < size {
+		ring.items = append(ring.items, record)
+		return
+	}
+	ring.items[ring.next] = record
+	ring.next = (ring.next + 1) % size
+}
+
+// list returns records newest first.
+func (ring *recordRing) list() []FlightRecord {
+	ret := make([]FlightRecord, 0, len(ring.items))
+	for i := 0; i < len(ring.items); i++ {
+		idx := (ring.next - 1 - i + 2*len(ring.items)) % len(ring.items)
+		ret = append(ret, ring.items[idx])
+	}
+	return ret
+}
+
+func (p *FlightRecorderPlugin) Install(m *API) error {
+	if p.Prefix == "" {
+		p.Prefix = "/debug/flight-recorder"
+	}
+	if p.Size <= 0 {
+		p.Size = 20
+	}
+	if p.MaxBodySize <= 0 {
+		p.MaxBodySize = 4 << 10
+	}
+	if p.RedactHeaders == nil {
+		p.RedactHeaders = DefaultRedactHeaders
+	}
+	if p.RedactFieldKeys == nil {
+		p.RedactFieldKeys = DefaultRedactFieldKeys
+	}
+	p.records = map[string]*recordRing{}
+	for _, key := range p.RedactFieldKeys {
+		p.redactTexts = append(p.redactTexts,
+			regexp.MustCompile(`(?i)("`+regexp.QuoteMeta(key)+`"\s*:\s*)"(?:[^"\\]|\\.)*"?`))
+		p.redactForms = append(p.redactForms,
+			regexp.MustCompile(`(?i)((?:^|&)`+regexp.QuoteMeta(url.QueryEscape(key))+`=)[^&]*`))
+	}
+
+	m.Group(NewGroup(p.Prefix).Tag("debug").Filter(p.Filters...).Route(
+		GET("/routes").Doc("list recorded routes").To(func(w http.ResponseWriter, r *http.Request) {
+			p.mu.Lock()
+			routes := maps.Keys(p.records)
+			p.mu.Unlock()
+			slices.Sort(routes)
+			response.OK(w, routes)
+		}),
+		GET("/records").Doc("list records of a route").
+			Param(QueryParam("route", "route key, e.g. 'GET /zoos/{zoo}'")).
+			To(func(w http.ResponseWriter, r *http.Request) {
+				response.OK(w, p.Records(r.URL.Query().Get("route")))
+			}),
+	))
+	return nil
+}
+
+// Records returns records of route, newest first.
+func (p *FlightRecorderPlugin) Records(route string) []FlightRecord {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if ring, ok := p.records[route]; ok {
+		return ring.list()
+	}
+	return []FlightRecord{}
+}
+
+func (p *FlightRecorderPlugin) OnRoute(route *Route) error {
+	if strings.HasPrefix(route.Path, p.Prefix) {
+		return nil // do not record itself
+	}
+	key := strings.TrimSpace(route.Method + " " + route.Path)
+	filter := FilterFunc(func(w http.ResponseWriter, r *http.Request, next http.Handler) {
+		start := time.Now()
+		reqbody := ReadBodySafely(r, []string{"application/json", "application/xml", "application/x-www-form-urlencoded", "text/"}, p.MaxBodySize)
+		ww := &StatusResponseWriter{Inner: w, MaxCacheSize: p.MaxBodySize}
+		next.ServeHTTP(ww, r)
+		if ww.Code == 0 {
+			ww.Code = http.StatusOK
+		}
+		p.record(key, FlightRecord{
+			Time:           start,
+			Duration:       time.Since(start).String(),
+			User:           AuthenticateFromContext(r.Context()).User.Name,
+			Method:         r.Method,
+			URL:            p.redactURL(r.URL),
+			RequestHeader:  p.redactHeader(r.Header),
+			RequestBody:    p.redactBody(reqbody, r.Header.Get("Content-Type")),
+			StatusCode:     ww.Code,
+			ResponseHeader: p.redactHeader(w.Header()),
+			ResponseBody:   p.redactBody(ww.Cache, w.Header().Get("Content-Type")),
+		})
+	})
+	route.Filters = append([]Filter{filter}, route.Filters...)
+	return nil
+}
+
+func (p *FlightRecorderPlugin) record(key string, record FlightRecord) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ring, ok := p.records[key]
+	if !ok {
+		ring = &recordRing{}
+		p.records[key] = ring
+	}
+	ring.add(p.Size, record)
+}
+
+func (p *FlightRecorderPlugin) redactHeader(header http.Header) map[string]string {
+	m := HttpHeaderToMap(header)
+	for _, k := range p.RedactHeaders {
+		if _, ok := m[http.CanonicalHeaderKey(k)]; ok {
+			m[http.CanonicalHeaderKey(k)] = redactedValue
+		}
+	}
+	return m
+}
+
+func (p *FlightRecorderPlugin) redactURL(u *url.URL) string {
+	query := u.Query()
+	for k := range query {
+		if p.isSecretKey(k) {
+			query.Set(k, redactedValue)
+		}
+	}
+	cp := *u
+	cp.RawQuery = query.Encode()
+	return cp.String()
+}
+
+func (p *FlightRecorderPlugin) redactBody(body []byte, contenttype string) string {
+	if len(body) == 0 {
+		return ""
+	}
+	if mediatype, _, _ := mime.ParseMediaType(contenttype); mediatype == "application/x-www-form-urlencoded" {
+		return p.redactForm(string(body))
+	}
+	var data any
+	if err := json.Unmarshal(body, &data); err != nil {
+		// not json or truncated, redact "key":"value" pairs as plain text
+		str := string(body)
+		for _, re := range p.redactTexts {
+			str = re.ReplaceAllString(str, `${1}"`+redactedValue+`"`)
+		}
+		return str
+	}
+	p.redactValue(data)
+	redacted, _ := json.Marshal(data)
+	return string(redacted)
+}
+
+// redactForm redacts the secret fields of a form body, e.g. password=... or client_secret=...
+func (p *FlightRecorderPlugin) redactForm(body string) string {
+	form, err := url.ParseQuery(body)
+	if err != nil {
+		// truncated, redact key=value pairs as plain text
+		for _, re := range p.redactForms {
+			body = re.ReplaceAllString(body, `${1}`+redactedValue)
+		}
+		return body
+	}
+	for k := range form {
+		if p.isSecretKey(k) {
+			form.Set(k, redactedValue)
+		}
+	}
+	return form.Encode()
+}
+
+func (p *FlightRecorderPlugin) redactValue(data any) {
+	switch val := data.(type) {
+	case map[string]any:
+		for k, v := range val {
+			if p.isSecretKey(k) {
+				val[k] = redactedValue
+				continue
+			}
+			p.redactValue(v)
+		}
+	case []any:
+		for _, v := range val {
+			p.redactValue(v)
+		}
+	}
+}
+
+func (p *FlightRecorderPlugin) isSecretKey(key string) bool {
+	return slices.ContainsFunc(p.RedactFieldKeys, func(s string) bool {
+		return strings.EqualFold(s, key)
+	})
+}
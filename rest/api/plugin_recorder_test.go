@@ -0,0 +1,108 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFlightRecorderRedactBody(t *testing.T) {
+	p := &FlightRecorderPlugin{}
+	if err := p.Install(NewAPI()); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name        string
+		body        string
+		contenttype string
+		want        string
+	}{
+		{
+			name:        "json",
+			body:        `{"username":"bob","password":"p@ss"}`,
+			contenttype: "application/json",
+			want:        `{"password":"******","username":"bob"}`,
+		},
+		{
+			name:        "json snake case",
+			body:        `{"access_token":"at","token_type":"Bearer","refresh_token":"rt","nested":[{"client_secret":"cs"}]}`,
+			contenttype: "application/json",
+			want:        `{"access_token":"******","nested":[{"client_secret":"******"}],"refresh_token":"******","token_type":"Bearer"}`,
+		},
+		{
+			name:        "truncated json",
+			body:        `{"user":"bob","clientSecret":"abc`,
+			contenttype: "application/json",
+			want:        `{"user":"bob","clientSecret":"******"`,
+		},
+		{
+			name:        "form",
+			body:        "grant_type=client_credentials&client_id=app&client_secret=s3cret",
+			contenttype: "application/x-www-form-urlencoded; charset=utf-8",
+			want:        "client_id=app&client_secret=%2A%2A%2A%2A%2A%2A&grant_type=client_credentials",
+		},
+		{
+			name:        "form password",
+			body:        "username=bob&Password=p%40ss",
+			contenttype: "application/x-www-form-urlencoded",
+			want:        "Password=%2A%2A%2A%2A%2A%2A&username=bob",
+		},
+		{
+			name:        "truncated form",
+			body:        "username=bob&password=p%4",
+			contenttype: "application/x-www-form-urlencoded",
+			want:        "username=bob&password=******",
+		},
+		{
+			name:        "plain text",
+			body:        "password=not a form",
+			contenttype: "text/plain",
+			want:        "password=not a form",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := p.redactBody([]byte(tt.body), tt.contenttype); got != tt.want {
+				t.Errorf("redactBody() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFlightRecorderPlugin(t *testing.T) {
+	p := &FlightRecorderPlugin{}
+	handler := NewAPI().Plugin(p).Route(POST("/token").To(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"at","expires_in":60}`))
+	})).Build()
+
+	req := httptest.NewRequest(http.MethodPost, "/token?api_key=k1&scope=read", strings.NewReader("grant_type=password&username=bob&password=p%40ss"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "Basic Ym9iOnBAc3M=")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	records := p.Records("POST /token")
+	if len(records) != 1 {
+		t.Fatalf("records = %d, want 1", len(records))
+	}
+	record := records[0]
+	for name, value := range map[string]string{
+		"url":             record.URL,
+		"request header":  record.RequestHeader["Authorization"],
+		"request body":    record.RequestBody,
+		"response body":   record.ResponseBody,
+		"response header": record.ResponseHeader["Content-Type"],
+	} {
+		for _, secret := range []string{"k1", "Ym9i", "p%40ss", `"at"`} {
+			if strings.Contains(value, secret) {
+				t.Errorf("%s %q contains secret %q", name, value, secret)
+			}
+		}
+	}
+	if !strings.Contains(record.RequestBody, "username=bob") || !strings.Contains(record.URL, "scope=read") {
+		t.Errorf("fields other than secrets are redacted: %s %s", record.URL, record.RequestBody)
+	}
+}
@@ -0,0 +1,227 @@
+// Copyright 2023 The Kubegems Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/go-logr/logr"
+	libreflect "kubegems.io/library/reflect"
+)
+
+var _ Plugin = &ResponseCheckPlugin{}
+
+// ResponseCheckPlugin compares json responses with the bodies declared by Route.Response,
+// and logs the mismatches like missing fields or wrong types.
+// It is for development and testing only, responses are cached and decoded once more.
+type ResponseCheckPlugin struct {
+	NoopPlugin
+	Logger      logr.Logger
+	MaxBodySize int // larger responses are not checked, default 1MB
+}
+
+func (p *ResponseCheckPlugin) OnRoute(route *Route) error {
+	declared := map[int]reflect.Type{}
+	for _, resp := range route.Responses {
+		if resp.Body != nil {
+			declared[resp.Code] = reflect.TypeOf(resp.Body)
+		}
+	}
+	if len(declared) == 0 {
+		return nil
+	}
+	maxsize := p.MaxBodySize
+	if maxsize <= 0 {
+		maxsize = 1 << 20
+	}
+	name := route.Method + " " + route.Path
+	filter := FilterFunc(func(w http.ResponseWriter, r *http.Request, next http.Handler) {
+		sw := &StatusResponseWriter{Inner: w, MaxCacheSize: maxsize}
+		next.ServeHTTP(sw, r)
+		code := sw.Code
+		if code == 0 {
+			code = http.StatusOK
+		}
+		typ, ok := declared[code]
+		if !ok || len(sw.Cache) == 0 || len(sw.Cache) >= maxsize {
+			return
+		}
+		if mediatype, _, _ := mime.ParseMediaType(w.Header().Get("Content-Type")); mediatype != "application/json" {
+			return
+		}
+		var actual any
+		if err := json.Unmarshal(sw.Cache, &actual); err != nil {
+			p.Logger.Info("response is not valid json", "route", name, "code", code, "error", err.Error())
+			return
+		}
+		// unwrap response.OK
+		if obj, ok := actual.(map[string]any); ok && len(obj) == 1 && typ.Kind() != reflect.Map {
+			if data, ok := obj["data"]; ok {
+				actual = data
+			}
+		}
+		if mismatches := ResponseMismatches(typ, actual); len(mismatches) > 0 {
+			p.Logger.Info("response mismatches declared body", "route", name, "code", code, "mismatches", mismatches)
+		}
+	})
+	route.Filters = append([]Filter{filter}, route.Filters...)
+	return nil
+}
+
+var (
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// ResponseMismatches compares a decoded json value with the type, returns mismatches as "path: message".
+// Missing fields without omitempty and fields not declared are reported.
+func ResponseMismatches(typ reflect.Type, actual any) []string {
+	return responseMismatches(typ, actual, "$", nil)
+}
+
+func responseMismatches(typ reflect.Type, actual any, path string, mismatches []string) []string {
+	for typ.Kind() == reflect.Pointer {
+		if actual == nil {
+			return mismatches
+		}
+		typ = typ.Elem()
+	}
+	// custom marshaled types can be anything
+	if typ.Implements(jsonMarshalerType) || reflect.PointerTo(typ).Implements(jsonMarshalerType) {
+		return mismatches
+	}
+	if typ.Implements(textMarshalerType) || reflect.PointerTo(typ).Implements(textMarshalerType) {
+		return expectJSONType(actual, "string", path, mismatches)
+	}
+	switch typ.Kind() {
+	case reflect.Interface:
+		return mismatches
+	case reflect.String:
+		return expectJSONType(actual, "string", path, mismatches)
+	case reflect.Bool:
+		return expectJSONType(actual, "boolean", path, mismatches)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return expectJSONType(actual, "number", path, mismatches)
+	case reflect.Slice, reflect.Array:
+		if actual == nil {
+			return mismatches
+		}
+		if typ.Elem().Kind() == reflect.Uint8 {
+			return expectJSONType(actual, "string", path, mismatches) // base64
+		}
+		items, ok := actual.([]any)
+		if !ok {
+			return append(mismatches, fmt.Sprintf("%s: expected array, got %s", path, jsonTypeOf(actual)))
+		}
+		for i, item := range items {
+			mismatches = responseMismatches(typ.Elem(), item, fmt.Sprintf("%s[%d]", path, i), mismatches)
+		}
+		return mismatches
+	case reflect.Map:
+		if actual == nil {
+			return mismatches
+		}
+		obj, ok := actual.(map[string]any)
+		if !ok {
+			return append(mismatches, fmt.Sprintf("%s: expected object, got %s", path, jsonTypeOf(actual)))
+		}
+		for k, v := range obj {
+			mismatches = responseMismatches(typ.Elem(), v, path+"."+k, mismatches)
+		}
+		return mismatches
+	case reflect.Struct:
+		obj, ok := actual.(map[string]any)
+		if !ok {
+			return append(mismatches, fmt.Sprintf("%s: expected object, got %s", path, jsonTypeOf(actual)))
+		}
+		seen := map[string]bool{}
+		mismatches = structMismatches(typ, obj, path, seen, mismatches)
+		for k := range obj {
+			if !seen[k] {
+				mismatches = append(mismatches, fmt.Sprintf("%s.%s: field not declared", path, k))
+			}
+		}
+		return mismatches
+	default:
+		return mismatches
+	}
+}
+
+func structMismatches(typ reflect.Type, obj map[string]any, path string, seen map[string]bool, mismatches []string) []string {
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		isEmbedded, isIgnored, name := libreflect.StructFieldInfo(field)
+		if isIgnored || (!field.IsExported() && !isEmbedded) {
+			continue
+		}
+		if isEmbedded {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				mismatches = structMismatches(embedded, obj, path, seen, mismatches)
+				continue
+			}
+		}
+		seen[name] = true
+		val, ok := obj[name]
+		if !ok {
+			if !strings.Contains(field.Tag.Get("json"), "omitempty") {
+				mismatches = append(mismatches, fmt.Sprintf("%s.%s: missing field", path, name))
+			}
+			continue
+		}
+		mismatches = responseMismatches(field.Type, val, path+"."+name, mismatches)
+	}
+	return mismatches
+}
+
+func expectJSONType(actual any, expected string, path string, mismatches []string) []string {
+	if actual == nil {
+		return mismatches
+	}
+	if got := jsonTypeOf(actual); got != expected {
+		return append(mismatches, fmt.Sprintf("%s: expected %s, got %s", path, expected, got))
+	}
+	return mismatches
+}
+
+func jsonTypeOf(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
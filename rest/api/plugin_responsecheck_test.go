@@ -0,0 +1,50 @@
+package api
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestResponseMismatches(t *testing.T) {
+	type Animal struct {
+		Name     string            `json:"name"`
+		Age      int               `json:"age,omitempty"`
+		Tags     []string          `json:"tags"`
+		Born     time.Time         `json:"born,omitempty"`
+		Labels   map[string]string `json:"labels,omitempty"`
+		Internal string            `json:"-"`
+	}
+	tests := []struct {
+		name   string
+		body   any
+		actual any
+		want   []string
+	}{
+		{
+			name:   "match",
+			body:   Animal{},
+			actual: map[string]any{"name": "tom", "tags": nil, "born": "2023-01-01T00:00:00Z"},
+		},
+		{
+			name:   "missing and undeclared",
+			body:   Animal{},
+			actual: map[string]any{"tags": []any{}, "color": "black"},
+			want:   []string{"$.name: missing field", "$.color: field not declared"},
+		},
+		{
+			name:   "wrong types",
+			body:   []Animal{},
+			actual: []any{map[string]any{"name": 1.0, "tags": []any{"a", true}, "labels": map[string]any{"k": 1.0}}},
+			want:   []string{"$[0].name: expected string, got number", "$[0].tags[1]: expected string, got boolean", "$[0].labels.k: expected string, got number"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ResponseMismatches(reflect.TypeOf(tt.body), tt.actual)
+			if len(got) != len(tt.want) || (len(got) > 0 && !reflect.DeepEqual(got, tt.want)) {
+				t.Errorf("ResponseMismatches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2023 The Kubegems Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-openapi/spec"
+	"golang.org/x/exp/slices"
+	"kubegems.io/library/rest/response"
+)
+
+// Route properties honored by RoutePolicyPlugin, e.g. GET("/slow").Property(PropertyTimeout, "5s").
+const (
+	// PropertyTimeout sets a deadline on the request context, 503 is responded if the handler
+	// returns after the deadline without starting a response. Handlers must respect the context.
+	PropertyTimeout = "timeout"
+	// PropertyRetryAfterOn503 sets the Retry-After header on 503 responses which have none.
+	PropertyRetryAfterOn503 = "retryAfterOn503"
+)
+
+// DocumentedRouteProperties are the route properties exported as vendor extensions by APIDocPlugin,
+// besides the properties prefixed with "x-". Other properties may be internal and are not exported.
+var DocumentedRouteProperties = []string{PropertyTimeout, PropertyRetryAfterOn503}
+
+var _ Plugin = &RoutePolicyPlugin{}
+
+// RoutePolicyPlugin applies the operational policies declared by route properties,
+// so they live with the route definitions. The properties are also exported as
+// vendor extensions by APIDocPlugin, see DocumentedRouteProperties.
+type RoutePolicyPlugin struct {
+	NoopPlugin
+}
+
+func (p *RoutePolicyPlugin) OnRoute(route *Route) error {
+	filters, err := RoutePolicyFilters(route)
+	if err != nil {
+		return err
+	}
+	route.Filters = append(filters, route.Filters...)
+	return nil
+}
+
+// RoutePolicyFilters returns the filters of the policy properties of route.
+func RoutePolicyFilters(route *Route) (Filters, error) {
+	filters := Filters{}
+	if val, ok := route.Properties[PropertyRetryAfterOn503]; ok {
+		after, err := propertyDuration(val)
+		if err != nil {
+			return nil, fmt.Errorf("route %s %s property %s: %w", route.Method, route.Path, PropertyRetryAfterOn503, err)
+		}
+		filters = append(filters, NewRetryAfterFilter(after))
+	}
+	if val, ok := route.Properties[PropertyTimeout]; ok {
+		timeout, err := propertyDuration(val)
+		if err != nil {
+			return nil, fmt.Errorf("route %s %s property %s: %w", route.Method, route.Path, PropertyTimeout, err)
+		}
+		filters = append(filters, NewTimeoutFilter(timeout))
+	}
+	return filters, nil
+}
+
+// propertyDuration accepts a time.Duration, a duration string like "5s", or seconds as number.
+func propertyDuration(val any) (time.Duration, error) {
+	var d time.Duration
+	switch v := val.(type) {
+	case time.Duration:
+		d = v
+	case int:
+		d = time.Duration(v) * time.Second
+	case float64:
+		d = time.Duration(v * float64(time.Second))
+	case string:
+		parsed, err := time.ParseDuration(v)
+		if err != nil {
+			return 0, err
+		}
+		d = parsed
+	default:
+		return 0, fmt.Errorf("unsupported duration %v", val)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("duration must be positive, got %s", d)
+	}
+	return d, nil
+}
+
+// NewTimeoutFilter sets a deadline on the request context.
+// Unlike http.TimeoutHandler the response is not buffered, the handler must return on context done.
+func NewTimeoutFilter(timeout time.Duration) Filter {
+	return FilterFunc(func(w http.ResponseWriter, r *http.Request, next http.Handler) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		sw := &StatusResponseWriter{Inner: w}
+		next.ServeHTTP(sw, r.WithContext(ctx))
+		if !sw.started() && ctx.Err() == context.DeadlineExceeded {
+			response.Error(w, response.NewStatusErrorMessage(http.StatusServiceUnavailable, "request timeout"))
+		}
+	})
+}
+
+// NewRetryAfterFilter sets the Retry-After header on 503 responses which have none.
+func NewRetryAfterFilter(after time.Duration) Filter {
+	seconds := strconv.FormatInt(int64(after.Round(time.Second)/time.Second), 10)
+	return FilterFunc(func(w http.ResponseWriter, r *http.Request, next http.Handler) {
+		sw := &StatusResponseWriter{Inner: w}
+		sw.OnWriteHeader = func(code int) {
+			if code == http.StatusServiceUnavailable && w.Header().Get("Retry-After") == "" {
+				w.Header().Set("Retry-After", seconds)
+			}
+		}
+		next.ServeHTTP(sw, r)
+	})
+}
+
+// propertyExtensions converts the documented route properties to openapi vendor extensions,
+// values which can't be represented in the spec are skipped.
+func propertyExtensions(properties map[string]any) spec.Extensions {
+	extensions := spec.Extensions{}
+	for k, v := range properties {
+		if !strings.HasPrefix(k, "x-") {
+			if !slices.Contains(DocumentedRouteProperties, k) {
+				continue
+			}
+			k = "x-" + k
+		}
+		switch val := v.(type) {
+		case string, bool, int, int32, int64, float32, float64, []string:
+			extensions.Add(k, val)
+		case time.Duration:
+			extensions.Add(k, val.String())
+		case fmt.Stringer:
+			extensions.Add(k, val.String())
+		}
+	}
+	if len(extensions) == 0 {
+		return nil
+	}
+	return extensions
+}
@@ -0,0 +1,160 @@
+package api
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// hijackRecorder is a ResponseRecorder supporting hijack.
+type hijackRecorder struct {
+	*httptest.ResponseRecorder
+}
+
+func (w hijackRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return nil, nil, nil
+}
+
+func TestRoutePolicyFilters(t *testing.T) {
+	tests := []struct {
+		name       string
+		properties map[string]any
+		wantLen    int
+		wantErr    bool
+	}{
+		{name: "none", properties: map[string]any{"owner": "team-a"}},
+		{name: "policies", properties: map[string]any{PropertyTimeout: "5s", PropertyRetryAfterOn503: 30}, wantLen: 2},
+		{name: "duration", properties: map[string]any{PropertyTimeout: 5 * time.Second}, wantLen: 1},
+		{name: "invalid timeout", properties: map[string]any{PropertyTimeout: "soon"}, wantErr: true},
+		{name: "negative timeout", properties: map[string]any{PropertyTimeout: -1}, wantErr: true},
+		{name: "unsupported type", properties: map[string]any{PropertyRetryAfterOn503: true}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			route := GET("/zoos")
+			for k, v := range tt.properties {
+				route = route.Property(k, v)
+			}
+			filters, err := RoutePolicyFilters(&route)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("RoutePolicyFilters() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(filters) != tt.wantLen {
+				t.Errorf("RoutePolicyFilters() = %d filters, want %d", len(filters), tt.wantLen)
+			}
+		})
+	}
+}
+
+func TestTimeoutFilter(t *testing.T) {
+	tests := []struct {
+		name     string
+		handler  func(w http.ResponseWriter, r *http.Request)
+		wantCode int
+		wantBody bool // a 503 body written by the filter
+	}{
+		{
+			name:     "in time",
+			handler:  func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) },
+			wantCode: http.StatusNoContent,
+		},
+		{
+			name:     "timeout",
+			handler:  func(w http.ResponseWriter, r *http.Request) { <-r.Context().Done() },
+			wantCode: http.StatusServiceUnavailable,
+			wantBody: true,
+		},
+		{
+			name: "timeout after written",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusAccepted)
+				<-r.Context().Done()
+			},
+			wantCode: http.StatusAccepted,
+		},
+		{
+			name: "timeout after flushed",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.NewResponseController(w).Flush()
+				<-r.Context().Done()
+			},
+			wantCode: http.StatusOK,
+		},
+		{
+			name: "timeout after hijacked",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				if _, _, err := http.NewResponseController(w).Hijack(); err != nil {
+					t.Error(err)
+				}
+				<-r.Context().Done()
+			},
+			wantCode: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := Filters{NewTimeoutFilter(10 * time.Millisecond)}.Handler(http.HandlerFunc(tt.handler))
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(hijackRecorder{rec}, httptest.NewRequest(http.MethodGet, "/", nil))
+			if rec.Code != tt.wantCode {
+				t.Errorf("code = %d, want %d", rec.Code, tt.wantCode)
+			}
+			if wrote := rec.Body.Len() > 0; wrote != tt.wantBody {
+				t.Errorf("body = %q, want written %v", rec.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestRetryAfterFilter(t *testing.T) {
+	tests := []struct {
+		name string
+		code int
+		set  string
+		want string
+	}{
+		{name: "unavailable", code: http.StatusServiceUnavailable, want: "30"},
+		{name: "kept", code: http.StatusServiceUnavailable, set: "5", want: "5"},
+		{name: "other status", code: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := Filters{NewRetryAfterFilter(30 * time.Second)}.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.set != "" {
+					w.Header().Set("Retry-After", tt.set)
+				}
+				w.WriteHeader(tt.code)
+			}))
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+			if got := rec.Header().Get("Retry-After"); got != tt.want {
+				t.Errorf("Retry-After = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPropertyExtensions(t *testing.T) {
+	extensions := propertyExtensions(map[string]any{
+		PropertyTimeout:         5 * time.Second,
+		PropertyRetryAfterOn503: 30,
+		"x-owner":               "team-a",
+		"internalToken":         "secret",
+		"x-handler":             func() {},
+	})
+	want := map[string]any{"x-timeout": "5s", "x-retryafteron503": 30, "x-owner": "team-a"}
+	if len(extensions) != len(want) {
+		t.Fatalf("extensions = %v, want %v", extensions, want)
+	}
+	for k, v := range want {
+		if extensions[k] != v {
+			t.Errorf("extensions[%s] = %v, want %v", k, extensions[k], v)
+		}
+	}
+	if extensions := propertyExtensions(map[string]any{"internalToken": "secret"}); extensions != nil {
+		t.Errorf("extensions of undocumented properties = %v, want nil", extensions)
+	}
+}
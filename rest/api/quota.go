@@ -0,0 +1,174 @@
+// Copyright 2023 The Kubegems Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"kubegems.io/library/rest/response"
+)
+
+// CounterStore counts requests in fixed time windows.
+type CounterStore interface {
+	// Incr increases the counter of key in current window by one,
+	// returns the count after increase and the time current window resets.
+	Incr(ctx context.Context, key string, window time.Duration) (int64, time.Time, error)
+}
+
+type QuotaOptions struct {
+	Limit  int64         // max requests per window for each key
+	Window time.Duration // e.g. 24h
+	// KeyFunc returns the quota key of the request, empty key means no quota applied.
+	// default is QuotaKeyByUser.
+	KeyFunc func(r *http.Request) string
+	// LimitFunc overrides Limit for a key, return <= 0 to use Limit.
+	LimitFunc func(key string) int64
+}
+
+func QuotaKeyByUser(r *http.Request) string {
+	return AuthenticateFromContext(r.Context()).User.Name
+}
+
+// QuotaKeyByHeader use a header value as quota key, e.g. "X-Tenant".
+func QuotaKeyByHeader(header string) func(r *http.Request) string {
+	return func(r *http.Request) string {
+		return r.Header.Get(header)
+	}
+}
+
+// NewQuotaFilter limits total requests of a user or tenant in a long window, e.g. 100k requests per day.
+// It is different from rate limiting which smooths short bursts.
+// The filter fails open when the store is unavailable.
+// It returns an error if store is nil, or Limit or Window is not positive.
+func NewQuotaFilter(store CounterStore, opts QuotaOptions) (Filter, error) {
+	if store == nil {
+		return nil, fmt.Errorf("quota counter store is required")
+	}
+	if opts.Limit <= 0 {
+		return nil, fmt.Errorf("quota limit must be positive, got %d", opts.Limit)
+	}
+	if opts.Window <= 0 {
+		return nil, fmt.Errorf("quota window must be positive, got %s", opts.Window)
+	}
+	if opts.KeyFunc == nil {
+		opts.KeyFunc = QuotaKeyByUser
+	}
+	return FilterFunc(func(w http.ResponseWriter, r *http.Request, next http.Handler) {
+		key := opts.KeyFunc(r)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		limit := opts.Limit
+		if opts.LimitFunc != nil {
+			if l := opts.LimitFunc(key); l > 0 {
+				limit = l
+			}
+		}
+		count, reset, err := store.Incr(r.Context(), "quota:"+key, opts.Window)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		remaining := limit - count
+		if remaining < 0 {
+			remaining = 0
+		}
+		SetRateLimitHeaders(w.Header(), limit, remaining, reset)
+		if count > limit {
+			SetRetryAfter(w.Header(), reset)
+			response.Error(w, response.NewStatusErrorMessage(http.StatusTooManyRequests,
+				fmt.Sprintf("quota exceeded, resets at %s", reset.UTC().Format(time.RFC3339))))
+			return
+		}
+		next.ServeHTTP(w, r)
+	}), nil
+}
+
+var _ CounterStore = &MemoryCounterStore{}
+
+// MemoryCounterStore is a CounterStore for single replica deployments.
+type MemoryCounterStore struct {
+	mu       sync.Mutex
+	counters map[string]*windowCounter
+	lastgc   time.Time
+}
+
+type windowCounter struct {
+	count int64
+	reset time.Time
+}
+
+func NewMemoryCounterStore() *MemoryCounterStore {
+	return &MemoryCounterStore{counters: map[string]*windowCounter{}}
+}
+
+func (s *MemoryCounterStore) Incr(ctx context.Context, key string, window time.Duration) (int64, time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	counter, ok := s.counters[key]
+	if !ok || !now.Before(counter.reset) {
+		s.gc(now)
+		counter = &windowCounter{reset: now.Truncate(window).Add(window)}
+		s.counters[key] = counter
+	}
+	counter.count++
+	return counter.count, counter.reset, nil
+}
+
+// gc removes expired counters at most once a minute, must be called with lock held.
+func (s *MemoryCounterStore) gc(now time.Time) {
+	if now.Sub(s.lastgc) < time.Minute {
+		return
+	}
+	s.lastgc = now
+	for k, v := range s.counters {
+		if !now.Before(v.reset) {
+			delete(s.counters, k)
+		}
+	}
+}
+
+func (s *MemoryCounterStore) Decr(ctx context.Context, key string, window time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if counter, ok := s.counters[key]; ok && time.Now().Before(counter.reset) && counter.count > 0 {
+		counter.count--
+	}
+	return nil
+}
+
+func (s *MemoryCounterStore) Reset(ctx context.Context, key string, window time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.counters, key)
+	return nil
+}
+
+func (s *MemoryCounterStore) Count(ctx context.Context, key string, window time.Duration) (int64, time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	counter, ok := s.counters[key]
+	if !ok || !now.Before(counter.reset) {
+		return 0, now.Truncate(window).Add(window), nil
+	}
+	return counter.count, counter.reset, nil
+}
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNewQuotaFilterOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		store   CounterStore
+		opts    QuotaOptions
+		wantErr bool
+	}{
+		{name: "valid", store: NewMemoryCounterStore(), opts: QuotaOptions{Limit: 10, Window: time.Hour}},
+		{name: "nil store", opts: QuotaOptions{Limit: 10, Window: time.Hour}, wantErr: true},
+		{name: "zero limit", store: NewMemoryCounterStore(), opts: QuotaOptions{Window: time.Hour}, wantErr: true},
+		{name: "negative limit", store: NewMemoryCounterStore(), opts: QuotaOptions{Limit: -1, Window: time.Hour}, wantErr: true},
+		{name: "zero window", store: NewMemoryCounterStore(), opts: QuotaOptions{Limit: 10}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewQuotaFilter(tt.store, tt.opts); (err != nil) != tt.wantErr {
+				t.Errorf("NewQuotaFilter() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestQuotaFilter(t *testing.T) {
+	filter, err := NewQuotaFilter(NewMemoryCounterStore(), QuotaOptions{
+		Limit:  2,
+		Window: time.Hour,
+		LimitFunc: func(key string) int64 {
+			if key == "vip" {
+				return 3
+			}
+			return 0
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := Filters{filter}.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	do := func(user string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req = req.WithContext(WithAuthenticate(context.Background(), AuthenticateInfo{User: UserInfo{Name: user}}))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	tests := []struct {
+		user     string
+		wantCode int
+	}{
+		{user: "alice", wantCode: http.StatusOK},
+		{user: "alice", wantCode: http.StatusOK},
+		{user: "alice", wantCode: http.StatusTooManyRequests},
+		{user: "vip", wantCode: http.StatusOK},
+		{user: "vip", wantCode: http.StatusOK},
+		{user: "vip", wantCode: http.StatusOK},
+		{user: "vip", wantCode: http.StatusTooManyRequests},
+		{user: "", wantCode: http.StatusOK},
+		{user: "", wantCode: http.StatusOK},
+		{user: "", wantCode: http.StatusOK},
+	}
+	for i, tt := range tests {
+		rec := do(tt.user)
+		if rec.Code != tt.wantCode {
+			t.Errorf("%d: %q code = %d, want %d", i, tt.user, rec.Code, tt.wantCode)
+		}
+		if tt.wantCode == http.StatusTooManyRequests && rec.Header().Get("Retry-After") == "" {
+			t.Errorf("%d: %q without Retry-After", i, tt.user)
+		}
+	}
+}
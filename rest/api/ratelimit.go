@@ -0,0 +1,178 @@
+// Copyright 2023 The Kubegems Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"kubegems.io/library/rest/response"
+)
+
+// https://datatracker.ietf.org/doc/draft-ietf-httpapi-ratelimit-headers/
+const (
+	HeaderRateLimitLimit     = "RateLimit-Limit"
+	HeaderRateLimitRemaining = "RateLimit-Remaining"
+	HeaderRateLimitReset     = "RateLimit-Reset" // delta seconds
+)
+
+// SetRateLimitHeaders sets the standard RateLimit-* headers,
+// and the X-RateLimit-* headers (reset as unix timestamp) for older clients.
+func SetRateLimitHeaders(header http.Header, limit, remaining int64, reset time.Time) {
+	if remaining < 0 {
+		remaining = 0
+	}
+	header.Set(HeaderRateLimitLimit, strconv.FormatInt(limit, 10))
+	header.Set(HeaderRateLimitRemaining, strconv.FormatInt(remaining, 10))
+	header.Set(HeaderRateLimitReset, strconv.FormatInt(deltaSeconds(reset), 10))
+	header.Set("X-RateLimit-Limit", strconv.FormatInt(limit, 10))
+	header.Set("X-RateLimit-Remaining", strconv.FormatInt(remaining, 10))
+	header.Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+}
+
+func SetRetryAfter(header http.Header, at time.Time) {
+	header.Set("Retry-After", strconv.FormatInt(deltaSeconds(at), 10))
+}
+
+func deltaSeconds(t time.Time) int64 {
+	d := int64(math.Ceil(time.Until(t).Seconds()))
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
+// RateLimitStore keeps token buckets, implementations can be shared between replicas, e.g. redis.
+type RateLimitStore interface {
+	// Take takes a token from the bucket of key, returns whether allowed, remaining tokens,
+	// and the time the next token is available if no tokens remaining, or the bucket is full.
+	Take(ctx context.Context, key string, rate float64, burst int) (bool, int64, time.Time, error)
+}
+
+type RateLimitOptions struct {
+	Rate  float64 // requests per second
+	Burst int     // max requests at once
+	// KeyFunc returns the rate limit key of the request, empty key means no limit applied.
+	// default is QuotaKeyByUser.
+	KeyFunc func(r *http.Request) string
+	// Store keeps the buckets, default is a MemoryRateLimitStore.
+	Store RateLimitStore
+}
+
+// NewRateLimitFilter limits request rate of each key with a token bucket.
+// Unlike NewQuotaFilter, it smooths short bursts.
+// The filter fails open when the store is unavailable.
+func NewRateLimitFilter(opts RateLimitOptions) Filter {
+	if opts.KeyFunc == nil {
+		opts.KeyFunc = QuotaKeyByUser
+	}
+	if opts.Burst <= 0 {
+		opts.Burst = 1
+	}
+	if opts.Store == nil {
+		opts.Store = NewMemoryRateLimitStore()
+	}
+	return FilterFunc(func(w http.ResponseWriter, r *http.Request, next http.Handler) {
+		key := opts.KeyFunc(r)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		allowed, remaining, reset, err := opts.Store.Take(r.Context(), "ratelimit:"+key, opts.Rate, opts.Burst)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		SetRateLimitHeaders(w.Header(), int64(opts.Burst), remaining, reset)
+		if !allowed {
+			SetRetryAfter(w.Header(), reset)
+			response.Error(w, response.NewStatusErrorMessage(http.StatusTooManyRequests, "rate limit exceeded"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+var _ RateLimitStore = &MemoryRateLimitStore{}
+
+// MemoryRateLimitStore is a RateLimitStore for single replica deployments.
+type MemoryRateLimitStore struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	lastgc  time.Time
+}
+
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{buckets: map[string]*tokenBucket{}}
+}
+
+func (s *MemoryRateLimitStore) Take(ctx context.Context, key string, rate float64, burst int) (bool, int64, time.Time, error) {
+	allowed, remaining, reset := s.take(key, rate, float64(burst), time.Now())
+	return allowed, remaining, reset, nil
+}
+
+func (s *MemoryRateLimitStore) take(key string, rate, burst float64, now time.Time) (bool, int64, time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.gc(now)
+	bucket, ok := s.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: burst, last: now}
+		s.buckets[key] = bucket
+	}
+	bucket.rate, bucket.burst = rate, burst
+	bucket.tokens = math.Min(burst, bucket.tokens+now.Sub(bucket.last).Seconds()*rate)
+	bucket.last = now
+
+	allowed := bucket.tokens >= 1
+	if allowed {
+		bucket.tokens--
+	}
+	remaining := int64(bucket.tokens)
+	if rate <= 0 {
+		return allowed, remaining, now
+	}
+	var wait float64
+	if remaining == 0 {
+		wait = (1 - bucket.tokens) / rate
+	} else {
+		wait = (burst - bucket.tokens) / rate
+	}
+	return allowed, remaining, now.Add(time.Duration(wait * float64(time.Second)))
+}
+
+func (s *MemoryRateLimitStore) gc(now time.Time) {
+	if now.Sub(s.lastgc) < time.Minute {
+		return
+	}
+	s.lastgc = now
+	for key, bucket := range s.buckets {
+		// full buckets are same as new ones
+		if bucket.rate > 0 && bucket.tokens+now.Sub(bucket.last).Seconds()*bucket.rate >= bucket.burst {
+			delete(s.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+// Copyright 2023 The Kubegems Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+const HeaderXCache = "X-Cache"
+
+type ResponseCacheOptions struct {
+	TTL          time.Duration // default 1m
+	Size         int           // max entries, default 1024
+	MaxEntrySize int           // larger responses are not cached, default 1MB
+	Methods      []string      // cached methods, default GET
+	// Vary are the request headers in the cache key besides method, path, the authenticated user and Accept-Encoding,
+	// default Accept, Authorization and Cookie, so that responses of different users are not mixed.
+	Vary []string
+	// InvalidateOnWrite purges the cached responses under the parent path of a succeeded request of other methods,
+	// e.g. "PUT /users/1" purges "/users", "/users/1" and "/users/2".
+	InvalidateOnWrite bool
+}
+
+func NewDefaultResponseCacheOptions() *ResponseCacheOptions {
+	return &ResponseCacheOptions{
+		TTL:          time.Minute,
+		Size:         1024,
+		MaxEntrySize: MB,
+		Methods:      []string{http.MethodGet},
+		Vary:         []string{"Accept", "Authorization", "Cookie"},
+	}
+}
+
+// CachedResponse is a response stored in ResponseCache, Header contains only the headers set by the handler.
+type CachedResponse struct {
+	Code      int
+	Header    http.Header
+	Body      []byte
+	CreatedAt time.Time
+}
+
+// ResponseCacheStats are the counters of ResponseCache, e.g. for metrics.
+type ResponseCacheStats struct {
+	Hits   uint64
+	Misses uint64
+	Purged uint64
+}
+
+// ResponseCache caches responses of expensive endpoints in memory, without an external caching proxy.
+// Only 200 responses are cached, streaming responses, responses with Set-Cookie
+// and responses with "Cache-Control: no-store" or "private" are not.
+//
+// Place the filter after authentication and authorization, the cached responses skip the filters after it.
+type ResponseCache struct {
+	Options *ResponseCacheOptions
+	Cache   Cache[*CachedResponse] // Purge and PurgePrefix require LRUCache like caches
+
+	hits, misses, purged atomic.Uint64
+}
+
+func NewResponseCache(opts *ResponseCacheOptions) *ResponseCache {
+	defaults := NewDefaultResponseCacheOptions()
+	if opts.TTL <= 0 {
+		opts.TTL = defaults.TTL
+	}
+	if opts.Size <= 0 {
+		opts.Size = defaults.Size
+	}
+	if opts.MaxEntrySize <= 0 {
+		opts.MaxEntrySize = defaults.MaxEntrySize
+	}
+	if opts.Methods == nil {
+		opts.Methods = defaults.Methods
+	}
+	if opts.Vary == nil {
+		opts.Vary = defaults.Vary
+	}
+	return &ResponseCache{
+		Options: opts,
+		Cache:   NewLRUCache[*CachedResponse](opts.Size, opts.TTL),
+	}
+}
+
+func (c *ResponseCache) Stats() ResponseCacheStats {
+	return ResponseCacheStats{Hits: c.hits.Load(), Misses: c.misses.Load(), Purged: c.purged.Load()}
+}
+
+// Purge removes all cached responses if the cache supports it, e.g. LRUCache.
+func (c *ResponseCache) Purge() {
+	if purger, ok := c.Cache.(interface{ Purge() }); ok {
+		purger.Purge()
+	}
+}
+
+// PurgePrefix removes the cached responses of prefix and the paths under it, returns the number removed,
+// e.g. "/users" purges "/users" and "/users/1" but not "/usersettings".
+func (c *ResponseCache) PurgePrefix(prefix string) int {
+	remover, ok := c.Cache.(interface{ RemoveFunc(func(string) bool) int })
+	if !ok {
+		return 0
+	}
+	prefix = strings.TrimSuffix(prefix, "/")
+	removed := remover.RemoveFunc(func(key string) bool {
+		_, p, _ := splitResponseCacheKey(key)
+		return prefix == "" || p == prefix || strings.HasPrefix(p, prefix+"/")
+	})
+	c.purged.Add(uint64(removed))
+	return removed
+}
+
+// key is method, path, query, the authenticated user, Accept-Encoding and the Vary headers joined with NUL.
+// The user is always in the key, so users authenticated by other means than the Vary headers, e.g. a client
+// certificate, are not mixed, and so is Accept-Encoding, as a compression filter before the cache may encode the response.
+func (c *ResponseCache) key(r *http.Request) string {
+	sb := strings.Builder{}
+	sb.WriteString(r.Method)
+	sb.WriteByte(0)
+	sb.WriteString(r.URL.Path)
+	sb.WriteByte(0)
+	sb.WriteString(r.URL.RawQuery)
+	sb.WriteByte(0)
+	sb.WriteString(AuthenticateFromContext(r.Context()).User.Name)
+	sb.WriteByte(0)
+	sb.WriteString(strings.Join(r.Header.Values("Accept-Encoding"), ","))
+	for _, h := range c.Options.Vary {
+		sb.WriteByte(0)
+		sb.WriteString(strings.Join(r.Header.Values(h), ","))
+	}
+	return sb.String()
+}
+
+func splitResponseCacheKey(key string) (string, string, string) {
+	method, rest, _ := strings.Cut(key, "\x00")
+	p, rest, _ := strings.Cut(rest, "\x00")
+	return method, p, rest
+}
+
+// Filter returns the filter serves the cached responses, the X-Cache response header is set to HIT or MISS.
+func (c *ResponseCache) Filter() Filter {
+	return FilterFunc(func(w http.ResponseWriter, r *http.Request, next http.Handler) {
+		if !slices.Contains(c.Options.Methods, r.Method) {
+			sw := &StatusResponseWriter{Inner: w}
+			next.ServeHTTP(sw, r)
+			if c.Options.InvalidateOnWrite && !isSafeMethod(r.Method) && sw.Code < 300 {
+				c.PurgePrefix(path.Dir(r.URL.Path))
+			}
+			return
+		}
+		key := c.key(r)
+		// a response rendered before a purge, e.g. by PurgePrefix of a concurrent write, is not cached
+		generation := cacheGeneration(c.Cache)
+		if cached, ok := c.Cache.Get(r.Context(), key); ok {
+			c.hits.Add(1)
+			c.serve(w, cached)
+			return
+		}
+		c.misses.Add(1)
+		before := w.Header().Clone()
+		w.Header().Set(HeaderXCache, "MISS")
+		sw := &StatusResponseWriter{Inner: w, MaxCacheSize: c.Options.MaxEntrySize}
+		next.ServeHTTP(sw, r)
+		if cached := c.response(sw, before); cached != nil {
+			addSince(withoutCancel(r.Context()), c.Cache, generation, key, cached)
+		}
+	})
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+func (c *ResponseCache) serve(w http.ResponseWriter, cached *CachedResponse) {
+	header := w.Header()
+	for k, v := range cached.Header {
+		header[k] = slices.Clone(v)
+	}
+	header.Set(HeaderXCache, "HIT")
+	header.Set("Age", strconv.Itoa(int(time.Since(cached.CreatedAt).Seconds())))
+	w.WriteHeader(cached.Code)
+	w.Write(cached.Body)
+}
+
+// response returns the response to cache, nil if not cacheable.
+// Only headers changed by the handler are kept, so that per request headers like X-Request-Id are not cached.
+func (c *ResponseCache) response(sw *StatusResponseWriter, before http.Header) *CachedResponse {
+	code := sw.Code
+	if code == 0 {
+		code = http.StatusOK
+	}
+	if code != http.StatusOK || sw.skipped || sw.flushed || sw.Truncated {
+		return nil
+	}
+	header := changedHeader(before, sw.Header())
+	delete(header, HeaderXCache)
+	if _, ok := header["Set-Cookie"]; ok {
+		return nil
+	}
+	for _, directive := range strings.Split(header.Get("Cache-Control"), ",") {
+		switch strings.ToLower(strings.TrimSpace(directive)) {
+		case "no-store", "private", "no-cache":
+			return nil
+		}
+	}
+	return &CachedResponse{Code: code, Header: header, Body: sw.Cache, CreatedAt: time.Now()}
+}
+
+// changedHeader returns the headers of after which are added or changed since before.
+func changedHeader(before, after http.Header) http.Header {
+	changed := http.Header{}
+	for k, v := range after {
+		if !slices.Equal(before[k], v) {
+			changed[k] = slices.Clone(v)
+		}
+	}
+	return changed
+}
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestResponseCache(t *testing.T) {
+	cache := NewResponseCache(&ResponseCacheOptions{InvalidateOnWrite: true, MaxEntrySize: 8})
+	calls := 0
+	handler := Filters{NewRequestIDFilter(), cache.Filter()}.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		switch r.URL.Path {
+		case "/private":
+			w.Header().Set("Cache-Control", "private")
+		case "/large":
+			w.Write([]byte(strings.Repeat("x", 9)))
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("ok"))
+	}))
+	do := func(method, target, auth string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	tests := []struct {
+		name      string
+		method    string
+		target    string
+		auth      string
+		wantCache string
+		wantCalls int
+	}{
+		{name: "miss", method: http.MethodGet, target: "/users", wantCache: "MISS", wantCalls: 1},
+		{name: "hit", method: http.MethodGet, target: "/users", wantCache: "HIT", wantCalls: 1},
+		{name: "query", method: http.MethodGet, target: "/users?page=2", wantCache: "MISS", wantCalls: 2},
+		{name: "vary", method: http.MethodGet, target: "/users", auth: "Bearer other", wantCache: "MISS", wantCalls: 3},
+		{name: "sub path", method: http.MethodGet, target: "/users/1", wantCache: "MISS", wantCalls: 4},
+		{name: "sub path hit", method: http.MethodGet, target: "/users/1", wantCache: "HIT", wantCalls: 4},
+		{name: "write", method: http.MethodPut, target: "/users/1", wantCalls: 5},
+		{name: "purged on write", method: http.MethodGet, target: "/users/1", wantCache: "MISS", wantCalls: 6},
+		{name: "private", method: http.MethodGet, target: "/private", wantCache: "MISS", wantCalls: 7},
+		{name: "private not cached", method: http.MethodGet, target: "/private", wantCache: "MISS", wantCalls: 8},
+		{name: "large", method: http.MethodGet, target: "/large", wantCache: "MISS", wantCalls: 9},
+		{name: "large not cached", method: http.MethodGet, target: "/large", wantCache: "MISS", wantCalls: 10},
+	}
+	for _, tt := range tests {
+		rec := do(tt.method, tt.target, tt.auth)
+		if got := rec.Header().Get(HeaderXCache); got != tt.wantCache {
+			t.Errorf("%s: X-Cache = %q, want %q", tt.name, got, tt.wantCache)
+		}
+		if calls != tt.wantCalls {
+			t.Errorf("%s: handler calls = %d, want %d", tt.name, calls, tt.wantCalls)
+		}
+		if tt.wantCache == "HIT" {
+			if rec.Body.String() != "ok" || rec.Header().Get("Content-Type") != "text/plain" {
+				t.Errorf("%s: cached response = %q %v", tt.name, rec.Body.String(), rec.Header())
+			}
+			if rec.Header().Get(HeaderRequestID) == "" {
+				t.Errorf("%s: request id of the cached response is missing", tt.name)
+			}
+		}
+	}
+
+	if removed := cache.PurgePrefix("/users"); removed != 1 {
+		t.Errorf("PurgePrefix() = %d, want 1", removed)
+	}
+	if stats := cache.Stats(); stats.Hits != 2 || stats.Misses != 9 {
+		t.Errorf("Stats() = %+v", stats)
+	}
+}
+
+func TestResponseCacheKey(t *testing.T) {
+	cache := NewResponseCache(&ResponseCacheOptions{})
+	handler := Filters{cache.Filter()}.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(AuthenticateFromContext(r.Context()).User.Name))
+	}))
+	do := func(user, encoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/profile", nil)
+		if user != "" {
+			req = req.WithContext(WithAuthenticate(req.Context(), AuthenticateInfo{User: UserInfo{Name: user}}))
+		}
+		if encoding != "" {
+			req.Header.Set("Accept-Encoding", encoding)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	tests := []struct {
+		name      string
+		user      string
+		encoding  string
+		wantCache string
+	}{
+		{name: "first user", user: "alice", wantCache: "MISS"},
+		{name: "same user", user: "alice", wantCache: "HIT"},
+		{name: "other user without authorization header", user: "bob", wantCache: "MISS"},
+		{name: "anonymous", wantCache: "MISS"},
+		{name: "other encoding", user: "alice", encoding: "gzip", wantCache: "MISS"},
+		{name: "same encoding", user: "alice", encoding: "gzip", wantCache: "HIT"},
+	}
+	for _, tt := range tests {
+		rec := do(tt.user, tt.encoding)
+		if got := rec.Header().Get(HeaderXCache); got != tt.wantCache {
+			t.Errorf("%s: X-Cache = %q, want %q", tt.name, got, tt.wantCache)
+		}
+		if rec.Body.String() != tt.user {
+			t.Errorf("%s: body = %q, want the response of %q", tt.name, rec.Body.String(), tt.user)
+		}
+	}
+}
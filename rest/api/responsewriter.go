@@ -0,0 +1,172 @@
+// Copyright 2023 The Kubegems Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+var (
+	_ http.ResponseWriter = &StatusResponseWriter{}
+	_ http.Flusher        = &StatusResponseWriter{}
+	_ http.Hijacker       = &StatusResponseWriter{}
+	_ http.Pusher         = &StatusResponseWriter{}
+	_ io.ReaderFrom       = &StatusResponseWriter{}
+)
+
+// StatusResponseWriter is the ResponseWriter wrapper shared by filters.
+// It captures the status code and the body size, optionally caches the leading bytes of the body,
+// and redirects the body to Body, e.g. a compressor.
+// Flush, Hijack and Push are passed to Inner, so wrapping does not hide the optional interfaces.
+//
+// Streaming responses, see IsStreamingResponse, are not cached, and caching stops once flushed.
+type StatusResponseWriter struct {
+	Inner             http.ResponseWriter
+	Code              int
+	Written           int64 // body bytes written by the handler
+	Cache             []byte
+	MaxCacheSize      int
+	CacheContentTypes []string       // content type prefixes of the cached responses, empty caches any
+	Truncated         bool           // the body is not fully cached due to MaxCacheSize or flushed
+	Body              io.Writer      // body is written to it instead of Inner if set
+	OnWriteHeader     func(code int) // called before the header written, headers can still be modified
+
+	skipped  bool // not cacheable by the content type
+	flushed  bool
+	hijacked bool
+}
+
+func (w *StatusResponseWriter) Header() http.Header {
+	return w.Inner.Header()
+}
+
+func (w *StatusResponseWriter) Write(p []byte) (n int, err error) {
+	if w.Code == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	w.cache(p)
+	if w.Body != nil {
+		n, err = w.Body.Write(p)
+	} else {
+		n, err = w.Inner.Write(p)
+	}
+	w.Written += int64(n)
+	return n, err
+}
+
+func (w *StatusResponseWriter) cache(p []byte) {
+	if w.skipped || w.MaxCacheSize <= 0 || len(p) == 0 {
+		return
+	}
+	if w.flushed {
+		w.Truncated = true
+		return
+	}
+	leftcachesize := w.MaxCacheSize - len(w.Cache)
+	if len(p) > leftcachesize {
+		w.Cache, w.Truncated = append(w.Cache, p[:leftcachesize]...), true
+	} else {
+		w.Cache = append(w.Cache, p...)
+	}
+}
+
+func (w *StatusResponseWriter) caching() bool {
+	return !w.skipped && !w.flushed && len(w.Cache) < w.MaxCacheSize
+}
+
+func (w *StatusResponseWriter) cacheable() bool {
+	if IsStreamingResponse(w.Header()) {
+		return false
+	}
+	if len(w.CacheContentTypes) == 0 {
+		return true
+	}
+	contenttype := w.Header().Get("Content-Type")
+	return slices.ContainsFunc(w.CacheContentTypes, func(s string) bool {
+		return strings.HasPrefix(contenttype, s)
+	})
+}
+
+func (w *StatusResponseWriter) WriteHeader(statusCode int) {
+	w.Code = statusCode
+	w.skipped = w.MaxCacheSize > 0 && !w.cacheable()
+	if w.OnWriteHeader != nil {
+		w.OnWriteHeader(statusCode)
+	}
+	w.Inner.WriteHeader(statusCode)
+}
+
+// ReadFrom uses the io.ReaderFrom of Inner when the body is neither cached nor redirected,
+// e.g. to sendfile.
+func (w *StatusResponseWriter) ReadFrom(src io.Reader) (int64, error) {
+	rf, ok := w.Inner.(io.ReaderFrom)
+	if !ok || w.Body != nil || w.caching() {
+		return io.Copy(writerOnly{w}, src)
+	}
+	if w.Code == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	n, err := rf.ReadFrom(src)
+	w.Written += n
+	if n > 0 && w.MaxCacheSize > 0 && !w.skipped {
+		w.Truncated = true
+	}
+	return n, err
+}
+
+func (w *StatusResponseWriter) Flush() {
+	// a flushed response is streaming, the later writes are not cached
+	w.flushed = true
+	if flusher, ok := w.Body.(interface{ Flush() error }); ok {
+		_ = flusher.Flush()
+	}
+	_ = http.NewResponseController(w.Inner).Flush()
+}
+
+func (w *StatusResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(w.Inner).Hijack()
+	if err == nil {
+		w.hijacked = true
+	}
+	return conn, rw, err
+}
+
+// started reports whether the response is started, by a header or body written, a flush or a hijack.
+func (w *StatusResponseWriter) started() bool {
+	return w.Code != 0 || w.flushed || w.hijacked
+}
+
+func (w *StatusResponseWriter) Push(target string, opts *http.PushOptions) error {
+	if pusher, ok := w.Inner.(http.Pusher); ok {
+		return pusher.Push(target, opts)
+	}
+	return http.ErrNotSupported
+}
+
+// Unwrap is used by http.ResponseController.
+func (w *StatusResponseWriter) Unwrap() http.ResponseWriter {
+	return w.Inner
+}
+
+// writerOnly hides ReadFrom to avoid recursion in io.Copy.
+type writerOnly struct {
+	io.Writer
+}
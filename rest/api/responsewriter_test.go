@@ -0,0 +1,102 @@
+package api
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCompressionFilter(t *testing.T) {
+	tests := []struct {
+		name         string
+		code         int
+		body         string
+		wantEncoding string
+	}{
+		{name: "ok", code: http.StatusOK, body: "hello world", wantEncoding: "gzip"},
+		{name: "implicit ok", code: 0, body: "hello world", wantEncoding: "gzip"},
+		{name: "no content", code: http.StatusNoContent, wantEncoding: ""},
+		{name: "not modified", code: http.StatusNotModified, wantEncoding: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Length", "11")
+				if tt.code != 0 {
+					w.WriteHeader(tt.code)
+				}
+				if tt.body != "" {
+					io.WriteString(w, tt.body)
+				}
+				if _, ok := w.(http.Flusher); !ok {
+					t.Error("ResponseWriter is not a http.Flusher")
+				}
+				if _, ok := w.(http.Hijacker); !ok {
+					t.Error("ResponseWriter is not a http.Hijacker")
+				}
+			})
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+			rec := httptest.NewRecorder()
+			NewCompressionFilter().Process(rec, req, handler)
+
+			if got := rec.Header().Get("Content-Encoding"); got != tt.wantEncoding {
+				t.Errorf("Content-Encoding = %q, want %q", got, tt.wantEncoding)
+			}
+			if tt.wantEncoding == "" {
+				if rec.Body.Len() != 0 {
+					t.Errorf("body = %q, want empty", rec.Body.String())
+				}
+				return
+			}
+			if got := rec.Header().Get("Content-Length"); got != "" {
+				t.Errorf("Content-Length = %q, want removed", got)
+			}
+			gr, err := gzip.NewReader(rec.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, err := io.ReadAll(gr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(body) != tt.body {
+				t.Errorf("body = %q, want %q", body, tt.body)
+			}
+		})
+	}
+}
+
+func TestStatusResponseWriterCache(t *testing.T) {
+	tests := []struct {
+		name          string
+		contentType   string
+		writes        []string
+		flush         bool
+		wantCache     string
+		wantTruncated bool
+	}{
+		{name: "cached", contentType: "application/json", writes: []string{`{"a":1}`}, wantCache: `{"a":1}`},
+		{name: "truncated", contentType: "application/json", writes: []string{`{"a":1}`, `{"b":2}`}, wantCache: `{"a":1}{"b"`, wantTruncated: true},
+		{name: "content type not allowed", contentType: "image/png", writes: []string{"png"}, wantCache: ""},
+		{name: "server-sent events", contentType: "text/event-stream", writes: []string{"data: 1\n\n"}, wantCache: ""},
+		{name: "flushed", contentType: "text/plain", writes: []string{"a", "b"}, flush: true, wantCache: "a", wantTruncated: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &StatusResponseWriter{Inner: httptest.NewRecorder(), MaxCacheSize: 11, CacheContentTypes: []string{"application/json", "text/"}}
+			w.Header().Set("Content-Type", tt.contentType)
+			for i, data := range tt.writes {
+				io.WriteString(w, data)
+				if tt.flush && i == 0 {
+					w.Flush()
+				}
+			}
+			if string(w.Cache) != tt.wantCache || w.Truncated != tt.wantTruncated {
+				t.Errorf("Cache = %q, Truncated = %v, want %q, %v", w.Cache, w.Truncated, tt.wantCache, tt.wantTruncated)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright 2023 The Kubegems Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+
+	"kubegems.io/library/rest/matcher"
+)
+
+// registration is a route registered by HandleRoute and where it was registered.
+type registration struct {
+	route    *Route
+	site     string // file:line of the registering call outside this package
+	mux      *Mux   // the mux holding the route in its tree, a host mux for routes with host
+	node     *matcher.Node[MethodsHandler]
+	sections []matcher.Section
+}
+
+// RouteConflict is a route which never serves some of the requests it matches,
+// because the router resolves them to another route, e.g. "/files/{name}" is shadowed by "/files/{path}*" as a greedy variable is tried first.
+type RouteConflict struct {
+	Method         string `json:"method,omitempty"`
+	Host           string `json:"host,omitempty"`
+	Pattern        string `json:"pattern"`
+	Site           string `json:"site,omitempty"` // file:line where the route was registered
+	Sample         string `json:"sample"`         // the path resolved to the other route
+	ShadowedBy     string `json:"shadowedBy,omitempty"`
+	ShadowedBySite string `json:"shadowedBySite,omitempty"`
+}
+
+func (c RouteConflict) String() string {
+	shadowed := fmt.Sprintf("%s %s%s (%s)", c.Method, c.Host, c.Pattern, c.Site)
+	if c.ShadowedBy == "" {
+		return fmt.Sprintf("%s: %s matches no route", shadowed, c.Sample)
+	}
+	return fmt.Sprintf("%s: %s matches %s%s (%s)", shadowed, c.Sample, c.Host, c.ShadowedBy, c.ShadowedBySite)
+}
+
+// Conflicts checks each registered route with a sample path built from its pattern,
+// and reports the routes whose sample is resolved to another route.
+// Variables with regexps are sampled from a few common values, routes without a sample matching the regexps are skipped.
+// Duplicate registrations are refused by HandleRoute, whose error reports both registration sites.
+func (m *Mux) Conflicts() []RouteConflict {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	conflicts := []RouteConflict{}
+	for _, reg := range m.routes {
+		sample, ok := samplePath(reg.sections)
+		if !ok {
+			continue
+		}
+		matched, _ := reg.mux.Tree.Match(sample, nil)
+		if matched == reg.node {
+			continue
+		}
+		conflict := RouteConflict{
+			Method:  reg.route.Method,
+			Host:    reg.route.Host,
+			Pattern: reg.route.Path,
+			Site:    reg.site,
+			Sample:  sample,
+		}
+		if other := m.registrationOf(reg.mux, matched, ""); other != nil {
+			conflict.ShadowedBy, conflict.ShadowedBySite = other.route.Path, other.site
+		}
+		conflicts = append(conflicts, conflict)
+	}
+	return conflicts
+}
+
+// registrationOf returns the registration of method on node, any method if method is empty.
+func (m *Mux) registrationOf(mux *Mux, node *matcher.Node[MethodsHandler], method string) *registration {
+	if node == nil {
+		return nil
+	}
+	for _, reg := range m.routes {
+		if reg.mux == mux && reg.node == node && (method == "" || reg.route.Method == method) {
+			return reg
+		}
+	}
+	return nil
+}
+
+var sampleValues = []string{"1", "a", "A", "v1", "a-1", "a.b", "a/b"}
+
+// samplePath returns a path matching sections, variables are sampled as "{name}" which is never a registered constant.
+func samplePath(sections []matcher.Section) (string, bool) {
+	sb := strings.Builder{}
+	for _, section := range sections {
+		for _, elem := range section {
+			if elem.VarName == "" {
+				sb.WriteString(elem.Pattern)
+				continue
+			}
+			value, ok := "{"+elem.VarName+"}", elem.Validate == nil
+			for i := 0; !ok && i < len(sampleValues); i++ {
+				value, ok = sampleValues[i], elem.Validate.MatchString(sampleValues[i])
+			}
+			if !ok {
+				return "", false
+			}
+			sb.WriteString(value)
+		}
+	}
+	return sb.String(), true
+}
+
+// callerSite returns file:line of the first caller outside this package, tests of this package count as callers.
+func callerSite() string {
+	pcs := make([]uintptr, 16)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(2, pcs)])
+	for {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, "kubegems.io/library/rest/api.") || strings.HasSuffix(frame.File, "_test.go") {
+			return fmt.Sprintf("%s:%d", frame.File, frame.Line)
+		}
+		if !more {
+			return ""
+		}
+	}
+}
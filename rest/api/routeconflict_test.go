@@ -0,0 +1,53 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestMuxConflicts(t *testing.T) {
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	m := NewMux()
+	for _, route := range []Route{
+		GET("/files/{path}*").To(ok),
+		GET("/files/{name}").To(ok),
+		GET("/zoos/{id}").To(ok),
+		GET("/zoos/{name}").To(ok),
+		GET("/zoos/{id}/cages").To(ok),
+		GET("/zoos/list").To(ok),
+		GET("/animals/{id:[0-9]+}").To(ok),
+		GET("/animals/{name:[a-z]+}").To(ok),
+		GET("/animals/{uuid:[0-9a-f]{32}}").To(ok),
+	} {
+		route := route
+		if err := m.HandleRoute(&route); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got := map[string]string{}
+	for _, conflict := range m.Conflicts() {
+		got[conflict.Pattern] = conflict.ShadowedBy
+		if !strings.Contains(conflict.Site, "routeconflict_test.go") {
+			t.Errorf("%s: Site = %q, want in routeconflict_test.go", conflict.Pattern, conflict.Site)
+		}
+	}
+	want := map[string]string{
+		"/files/{name}": "/files/{path}*",
+		"/zoos/{name}":  "/zoos/{id}",
+	}
+	if len(got) != len(want) {
+		t.Errorf("Conflicts() = %v, want %v", got, want)
+	}
+	for pattern, shadowedby := range want {
+		if got[pattern] != shadowedby {
+			t.Errorf("%s: ShadowedBy = %q, want %q", pattern, got[pattern], shadowedby)
+		}
+	}
+
+	route := GET("/zoos/list").To(ok)
+	err := m.HandleRoute(&route)
+	if err == nil || strings.Count(err.Error(), "routeconflict_test.go") != 2 {
+		t.Errorf("HandleRoute() duplicate error = %v, want both sites", err)
+	}
+}
@@ -0,0 +1,200 @@
+// Copyright 2023 The Kubegems Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"kubegems.io/library/net/httpproxy"
+	"sigs.k8s.io/yaml"
+)
+
+// RouteTable is a declarative route definition, usually loaded from a yaml file.
+//
+//	groups:
+//	  - path: /apis/v1
+//	    filters: [auth]
+//	    routes:
+//	      - path: /zoos/{zoo}/animals
+//	        methods: [GET]
+//	        upstream: http://animals.default:8080
+//	        permission:
+//	          action: list
+//	          resources: [{resource: zoos, name: "{zoo}"}, {resource: animals}]
+type RouteTable struct {
+	Groups []RouteTableGroup `json:"groups,omitempty"`
+}
+
+type RouteTableGroup struct {
+	Path    string            `json:"path,omitempty"`
+	Tags    []string          `json:"tags,omitempty"`
+	Filters []string          `json:"filters,omitempty"` // filter names, see RouteTableOptions.Filters
+	Routes  []RouteTableRoute `json:"routes,omitempty"`
+	Groups  []RouteTableGroup `json:"groups,omitempty"` // sub groups
+}
+
+type RouteTableRoute struct {
+	Path        string      `json:"path,omitempty"`
+	Methods     []string    `json:"methods,omitempty"` // empty means any method
+	Summary     string      `json:"summary,omitempty"`
+	Tags        []string    `json:"tags,omitempty"`
+	Filters     []string    `json:"filters,omitempty"`
+	Handler     string      `json:"handler,omitempty"`     // handler name, see RouteTableOptions.Handlers
+	Upstream    string      `json:"upstream,omitempty"`    // proxy target, e.g. http://svc.ns:8080
+	StripPrefix string      `json:"stripPrefix,omitempty"` // prefix removed before proxying to upstream
+	Permission  *Attributes `json:"permission,omitempty"`  // required permission, values like "{name}" are replaced by path vars
+}
+
+type RouteTableOptions struct {
+	Filters    map[string]Filter       // named filters can be referenced in the table
+	Handlers   map[string]http.Handler // named handlers can be referenced in the table
+	Authorizer Authorizer              // used to check route permission
+}
+
+func LoadRouteTableFile(filename string) (*RouteTable, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	table := &RouteTable{}
+	if err := yaml.Unmarshal(data, table); err != nil {
+		return nil, fmt.Errorf("parse route table %s: %w", filename, err)
+	}
+	return table, nil
+}
+
+// Build converts the table into groups which can be registered by API.Group.
+func (t RouteTable) Build(opts RouteTableOptions) ([]Group, error) {
+	groups := make([]Group, 0, len(t.Groups))
+	for _, g := range t.Groups {
+		group, err := buildRouteTableGroup(g, opts)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, group)
+	}
+	return groups, nil
+}
+
+func buildRouteTableGroup(g RouteTableGroup, opts RouteTableOptions) (Group, error) {
+	group := NewGroup(g.Path)
+	group.Tags = g.Tags
+	filters, err := lookupFilters(g.Filters, opts)
+	if err != nil {
+		return group, fmt.Errorf("group %s: %w", g.Path, err)
+	}
+	group.Filters = filters
+	for _, r := range g.Routes {
+		routes, err := buildRouteTableRoute(r, opts)
+		if err != nil {
+			return group, fmt.Errorf("group %s: route %s: %w", g.Path, r.Path, err)
+		}
+		group.Routes = append(group.Routes, routes...)
+	}
+	for _, sub := range g.Groups {
+		subgroup, err := buildRouteTableGroup(sub, opts)
+		if err != nil {
+			return group, fmt.Errorf("group %s: %w", g.Path, err)
+		}
+		group.SubGroups = append(group.SubGroups, subgroup)
+	}
+	return group, nil
+}
+
+func buildRouteTableRoute(r RouteTableRoute, opts RouteTableOptions) ([]Route, error) {
+	var handler http.Handler
+	switch {
+	case r.Handler != "" && r.Upstream != "":
+		return nil, fmt.Errorf("handler and upstream are mutually exclusive")
+	case r.Handler != "":
+		h, ok := opts.Handlers[r.Handler]
+		if !ok {
+			return nil, fmt.Errorf("handler %q not found", r.Handler)
+		}
+		handler = h
+	case r.Upstream != "":
+		upstream, err := httpproxy.NewUpstream(r.Upstream, r.StripPrefix)
+		if err != nil {
+			return nil, err
+		}
+		handler = upstream
+	default:
+		return nil, fmt.Errorf("one of handler or upstream is required")
+	}
+	filters, err := lookupFilters(r.Filters, opts)
+	if err != nil {
+		return nil, err
+	}
+	if r.Permission != nil {
+		if opts.Authorizer == nil {
+			return nil, fmt.Errorf("permission declared but no authorizer configured")
+		}
+		filters = append(filters,
+			NewAttributeFilter(StaticAttributesExtractor(*r.Permission)),
+			NewAuthorizationFilter(opts.Authorizer),
+		)
+	}
+	methods := r.Methods
+	if len(methods) == 0 {
+		methods = []string{""}
+	}
+	routes := make([]Route, 0, len(methods))
+	for _, method := range methods {
+		route := Do(strings.ToUpper(method), r.Path).Doc(r.Summary).Tag(r.Tags...)
+		route.Handler, route.Filters = handler, filters
+		routes = append(routes, route)
+	}
+	return routes, nil
+}
+
+func lookupFilters(names []string, opts RouteTableOptions) (Filters, error) {
+	filters := make(Filters, 0, len(names))
+	for _, name := range names {
+		filter, ok := opts.Filters[name]
+		if !ok {
+			return nil, fmt.Errorf("filter %q not found", name)
+		}
+		filters = append(filters, filter)
+	}
+	return filters, nil
+}
+
+// StaticAttributesExtractor returns the given attributes for every request,
+// resource names like "{name}" are replaced with the request path vars.
+func StaticAttributesExtractor(attributes Attributes) AttributeExtractor {
+	return func(r *http.Request) (*Attributes, error) {
+		vars := PathVars(r)
+		resources := make([]AttributeResource, len(attributes.Resources))
+		for i, res := range attributes.Resources {
+			if strings.HasPrefix(res.Name, "{") && strings.HasSuffix(res.Name, "}") {
+				res.Name = vars.Get(res.Name[1 : len(res.Name)-1])
+			}
+			resources[i] = res
+		}
+		return &Attributes{Action: attributes.Action, Resources: resources, Path: r.URL.Path}, nil
+	}
+}
+
+// RouteTable registers routes defined in table along with the code-registered routes.
+func (m *API) RouteTable(table *RouteTable, opts RouteTableOptions) *API {
+	groups, err := table.Build(opts)
+	if err != nil {
+		panic(err)
+	}
+	return m.Group(groups...)
+}
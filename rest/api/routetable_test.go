@@ -0,0 +1,105 @@
+package api
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"sigs.k8s.io/yaml"
+)
+
+func TestRouteTable(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "upstream "+r.URL.Path)
+	}))
+	defer upstream.Close()
+
+	tabledata := `
+groups:
+  - path: /apis
+    filters: [header]
+    routes:
+      - path: /zoos/{zoo}
+        methods: [get]
+        upstream: ` + upstream.URL + `
+        stripPrefix: /apis
+        permission:
+          action: get
+          resources: [{resource: zoos, name: "{zoo}"}]
+      - path: /version
+        handler: version
+`
+	table := &RouteTable{}
+	if err := yaml.Unmarshal([]byte(tabledata), table); err != nil {
+		t.Fatal(err)
+	}
+	opts := RouteTableOptions{
+		Filters: map[string]Filter{
+			"header": FilterFunc(func(w http.ResponseWriter, r *http.Request, next http.Handler) {
+				w.Header().Set("X-Filtered", "true")
+				next.ServeHTTP(w, r)
+			}),
+		},
+		Handlers: map[string]http.Handler{
+			"version": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				io.WriteString(w, "v1")
+			}),
+		},
+		Authorizer: AuthorizerFunc(func(ctx context.Context, user UserInfo, a Attributes) (Decision, string, error) {
+			if a.Action == "get" && len(a.Resources) == 1 && a.Resources[0].Name == "allowed" {
+				return DecisionAllow, "", nil
+			}
+			return DecisionDeny, "denied", nil
+		}),
+	}
+	handler := NewAPI().RouteTable(table, opts).Build()
+
+	tests := []struct {
+		method   string
+		path     string
+		wantCode int
+		wantBody string
+	}{
+		{method: http.MethodGet, path: "/apis/zoos/allowed", wantCode: http.StatusOK, wantBody: "upstream /zoos/allowed"},
+		{method: http.MethodGet, path: "/apis/zoos/other", wantCode: http.StatusForbidden},
+		{method: http.MethodPost, path: "/apis/zoos/allowed", wantCode: http.StatusMethodNotAllowed},
+		{method: http.MethodPost, path: "/apis/version", wantCode: http.StatusOK, wantBody: "v1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+			if rec.Code != tt.wantCode {
+				t.Errorf("code = %d, want %d", rec.Code, tt.wantCode)
+			}
+			if tt.wantBody != "" && rec.Body.String() != tt.wantBody {
+				t.Errorf("body = %q, want %q", rec.Body.String(), tt.wantBody)
+			}
+			if rec.Code != http.StatusMethodNotAllowed && rec.Header().Get("X-Filtered") != "true" {
+				t.Errorf("group filter not applied")
+			}
+		})
+	}
+}
+
+func TestRouteTableErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		table RouteTable
+	}{
+		{name: "no handler", table: RouteTable{Groups: []RouteTableGroup{{Routes: []RouteTableRoute{{Path: "/a"}}}}}},
+		{name: "unknown filter", table: RouteTable{Groups: []RouteTableGroup{{Filters: []string{"x"}}}}},
+		{name: "permission without authorizer", table: RouteTable{Groups: []RouteTableGroup{{
+			Routes: []RouteTableRoute{{Path: "/a", Upstream: "http://a", Permission: &Attributes{Action: "get"}}},
+		}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.table.Build(RouteTableOptions{}); err == nil {
+				t.Errorf("RouteTable.Build() expected error")
+			}
+		})
+	}
+}
@@ -0,0 +1,250 @@
+// Copyright 2023 The Kubegems Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/slices"
+	"kubegems.io/library/rest/request"
+	"kubegems.io/library/rest/response"
+)
+
+const (
+	SessionTypeHTTP = "http"
+	SessionTypeSSH  = "ssh"
+)
+
+var (
+	ErrTooManySessions   = errors.New("too many concurrent sessions")
+	ErrSessionTerminated = errors.New("session terminated")
+)
+
+type Session struct {
+	ID         string    `json:"id"`
+	Type       string    `json:"type"` // http or ssh
+	User       string    `json:"user"`
+	RemoteAddr string    `json:"remoteAddr,omitempty"`
+	StartTime  time.Time `json:"startTime"`
+	LastSeen   time.Time `json:"lastSeen"`
+
+	terminate func() // closes the underlying connection, nil for http sessions
+}
+
+// SessionRegistry tracks active sessions and limits concurrent sessions per user.
+// A registry can be shared by the ssh server and http filters, so the limit counts both.
+// It tracks live connections and cookies only, the http sessions themselves are issued and stored by SessionManager.
+type SessionRegistry struct {
+	MaxPerUser  int           // 0 means unlimited
+	Reject      bool          // reject new sessions when limit reached, default evicts the oldest one
+	IdleTimeout time.Duration // http sessions idle longer than this are forgotten, default 30m
+
+	mu       sync.Mutex
+	sessions map[string]*Session
+	revoked  map[string]time.Time // terminated http sessions, the cookie is refused until expired
+}
+
+func NewSessionRegistry(maxPerUser int) *SessionRegistry {
+	return &SessionRegistry{MaxPerUser: maxPerUser, IdleTimeout: 30 * time.Minute}
+}
+
+// Open registers a session, terminate is called when the session is evicted or terminated by admin.
+func (s *SessionRegistry) Open(session Session, terminate func()) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.gc(now)
+	if _, ok := s.revoked[session.ID]; ok {
+		return ErrSessionTerminated
+	}
+	if s.MaxPerUser > 0 {
+		owned := s.userSessions(session.User)
+		for len(owned) >= s.MaxPerUser {
+			if s.Reject {
+				return ErrTooManySessions
+			}
+			s.terminate(owned[0], now)
+			owned = owned[1:]
+		}
+	}
+	if session.StartTime.IsZero() {
+		session.StartTime = now
+	}
+	session.LastSeen, session.terminate = now, terminate
+	s.sessions[session.ID] = &session
+	return nil
+}
+
+// Touch updates the last seen time of session, returns false if the session is not active.
+func (s *SessionRegistry) Touch(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[id]
+	if ok {
+		session.LastSeen = time.Now()
+	}
+	return ok
+}
+
+// Close removes a session which ended normally.
+func (s *SessionRegistry) Close(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, id)
+}
+
+// Terminate forcibly ends a session, returns false if not found.
+func (s *SessionRegistry) Terminate(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[id]
+	if ok {
+		s.terminate(session, time.Now())
+	}
+	return ok
+}
+
+// List returns active sessions of user, or all sessions if user is empty, oldest first.
+func (s *SessionRegistry) List(user string) []Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.gc(time.Now())
+	ret := []Session{}
+	for _, session := range s.sessions {
+		if user == "" || session.User == user {
+			ret = append(ret, *session)
+		}
+	}
+	slices.SortFunc(ret, func(a, b Session) int { return a.StartTime.Compare(b.StartTime) })
+	return ret
+}
+
+func (s *SessionRegistry) userSessions(user string) []*Session {
+	owned := []*Session{}
+	for _, session := range s.sessions {
+		if session.User == user {
+			owned = append(owned, session)
+		}
+	}
+	slices.SortFunc(owned, func(a, b *Session) int { return a.StartTime.Compare(b.StartTime) })
+	return owned
+}
+
+func (s *SessionRegistry) terminate(session *Session, now time.Time) {
+	delete(s.sessions, session.ID)
+	if session.terminate != nil {
+		go session.terminate()
+	} else {
+		s.revoked[session.ID] = now
+	}
+}
+
+func (s *SessionRegistry) gc(now time.Time) {
+	if s.sessions == nil {
+		s.sessions, s.revoked = map[string]*Session{}, map[string]time.Time{}
+	}
+	idle := s.IdleTimeout
+	if idle <= 0 {
+		idle = 30 * time.Minute
+	}
+	for id, session := range s.sessions {
+		if session.terminate == nil && now.Sub(session.LastSeen) > idle {
+			delete(s.sessions, id)
+		}
+	}
+	for id, at := range s.revoked {
+		if now.Sub(at) > 24*time.Hour {
+			delete(s.revoked, id)
+		}
+	}
+}
+
+// NewSessionLimitFilter tracks http sessions identified by the cookie and applies the registry limit.
+// It must be placed after authentication filter, requests without the cookie are not tracked.
+func NewSessionLimitFilter(registry *SessionRegistry, cookiename string) Filter {
+	return FilterFunc(func(w http.ResponseWriter, r *http.Request, next http.Handler) {
+		cookie, err := r.Cookie(cookiename)
+		if err != nil || cookie.Value == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		sum := sha256.Sum256([]byte(cookie.Value))
+		id := SessionTypeHTTP + "-" + hex.EncodeToString(sum[:8])
+		if registry.Touch(id) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		session := Session{
+			ID:         id,
+			Type:       SessionTypeHTTP,
+			User:       AuthenticateFromContext(r.Context()).User.Name,
+			RemoteAddr: r.RemoteAddr,
+		}
+		switch err := registry.Open(session, nil); {
+		case errors.Is(err, ErrSessionTerminated):
+			response.Unauthorized(w, err.Error())
+		case err != nil:
+			response.Error(w, response.NewStatusErrorMessage(http.StatusTooManyRequests, err.Error()))
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+var _ Plugin = &SessionPlugin{}
+
+// SessionPlugin exposes admin endpoints to list and terminate active sessions.
+type SessionPlugin struct {
+	NoopPlugin
+	Prefix   string // default "/admin/sessions"
+	Registry *SessionRegistry
+	Filters  Filters // filters of the admin endpoint, e.g. authentication and authorization, required
+}
+
+// Install refuses to expose the endpoints without Filters, they list the users and terminate any session.
+func (p *SessionPlugin) Install(m *API) error {
+	if p.Prefix == "" {
+		p.Prefix = "/admin/sessions"
+	}
+	if p.Registry == nil {
+		return fmt.Errorf("session plugin %s: registry required", p.Prefix)
+	}
+	if len(p.Filters) == 0 {
+		return fmt.Errorf("session plugin %s: %w", p.Prefix, ErrAdminFiltersRequired)
+	}
+	m.Group(NewGroup(p.Prefix).Tag("sessions").Filter(p.Filters...).Route(
+		GET("").Doc("list active sessions").
+			Param(QueryParam("user", "filter by user").Optional()).
+			Response([]Session{}).
+			To(func(w http.ResponseWriter, r *http.Request) {
+				response.OK(w, p.Registry.List(request.Query(r, "user", "")))
+			}),
+		DELETE("/{id}").Doc("terminate a session").To(func(w http.ResponseWriter, r *http.Request) {
+			id := request.Path(r, "id", "")
+			if !p.Registry.Terminate(id) {
+				response.NotFound(w, "session "+id+" not found")
+				return
+			}
+			response.OK(w, nil)
+		}),
+	))
+	return nil
+}
@@ -0,0 +1,151 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSessionRegistry(t *testing.T) {
+	tests := []struct {
+		name           string
+		reject         bool
+		wantErr        error
+		wantTerminated bool
+		wantSessions   []string
+	}{
+		{name: "evict oldest", wantTerminated: true, wantSessions: []string{"b", "c"}},
+		{name: "reject", reject: true, wantErr: ErrTooManySessions, wantSessions: []string{"a", "b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry := NewSessionRegistry(2)
+			registry.Reject = tt.reject
+			terminated := make(chan string, 3)
+			now := time.Now()
+			for i, id := range []string{"a", "b", "c"} {
+				id := id
+				session := Session{ID: id, Type: SessionTypeSSH, User: "alice", StartTime: now.Add(time.Duration(i) * time.Second)}
+				err := registry.Open(session, func() { terminated <- id })
+				if id != "c" && err != nil {
+					t.Fatal(err)
+				}
+				if id == "c" && !errors.Is(err, tt.wantErr) {
+					t.Errorf("Open() error = %v, want %v", err, tt.wantErr)
+				}
+			}
+			// other users are not limited
+			if err := registry.Open(Session{ID: "d", User: "bob"}, nil); err != nil {
+				t.Errorf("Open() of another user error = %v", err)
+			}
+			if tt.wantTerminated {
+				select {
+				case id := <-terminated:
+					if id != "a" {
+						t.Errorf("terminated %s, want a", id)
+					}
+				case <-time.After(time.Second):
+					t.Error("oldest session not terminated")
+				}
+			}
+			ids := []string{}
+			for _, session := range registry.List("alice") {
+				ids = append(ids, session.ID)
+			}
+			if len(ids) != len(tt.wantSessions) || ids[0] != tt.wantSessions[0] || ids[1] != tt.wantSessions[1] {
+				t.Errorf("List() = %v, want %v", ids, tt.wantSessions)
+			}
+			if got := len(registry.List("")); got != len(tt.wantSessions)+1 {
+				t.Errorf("List() of all users got %d sessions, want %d", got, len(tt.wantSessions)+1)
+			}
+		})
+	}
+}
+
+func TestSessionRegistryTerminate(t *testing.T) {
+	registry := NewSessionRegistry(0)
+	if err := registry.Open(Session{ID: "http-1", Type: SessionTypeHTTP, User: "alice"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !registry.Touch("http-1") {
+		t.Error("Touch() of an active session = false")
+	}
+	if !registry.Terminate("http-1") || registry.Terminate("http-1") {
+		t.Error("Terminate() should succeed once")
+	}
+	if registry.Touch("http-1") {
+		t.Error("Touch() of a terminated session = true")
+	}
+	// a terminated http session can not be opened again by the same cookie
+	if err := registry.Open(Session{ID: "http-1", Type: SessionTypeHTTP, User: "alice"}, nil); !errors.Is(err, ErrSessionTerminated) {
+		t.Errorf("Open() of a terminated session error = %v, want %v", err, ErrSessionTerminated)
+	}
+	registry.Close("http-2") // closing an unknown session is a no-op
+}
+
+func TestSessionLimitFilter(t *testing.T) {
+	registry := NewSessionRegistry(1)
+	filter := NewSessionLimitFilter(registry, "session")
+	serve := func(user, cookie string) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if cookie != "" {
+			req.AddCookie(&http.Cookie{Name: "session", Value: cookie})
+		}
+		req = req.WithContext(WithAuthenticate(req.Context(), AuthenticateInfo{User: UserInfo{Name: user}}))
+		rec := httptest.NewRecorder()
+		filter.Process(rec, req, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		return rec.Code
+	}
+	tests := []struct {
+		name   string
+		user   string
+		cookie string
+		want   int
+	}{
+		{name: "no cookie not tracked", user: "alice", want: http.StatusOK},
+		{name: "first session", user: "alice", cookie: "c1", want: http.StatusOK},
+		{name: "same session", user: "alice", cookie: "c1", want: http.StatusOK},
+		{name: "second session evicts the first", user: "alice", cookie: "c2", want: http.StatusOK},
+		{name: "evicted session refused", user: "alice", cookie: "c1", want: http.StatusUnauthorized},
+		{name: "other user", user: "bob", cookie: "c3", want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		if got := serve(tt.user, tt.cookie); got != tt.want {
+			t.Errorf("%s: code = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+
+	registry = NewSessionRegistry(1)
+	registry.Reject = true
+	filter = NewSessionLimitFilter(registry, "session")
+	if got := serve("alice", "c1"); got != http.StatusOK {
+		t.Errorf("first session: code = %d, want %d", got, http.StatusOK)
+	}
+	if got := serve("alice", "c2"); got != http.StatusTooManyRequests {
+		t.Errorf("rejected session: code = %d, want %d", got, http.StatusTooManyRequests)
+	}
+}
+
+func TestSessionPluginRequiresFilters(t *testing.T) {
+	registry := NewSessionRegistry(0)
+	if err := (&SessionPlugin{Registry: registry}).Install(NewAPI()); !errors.Is(err, ErrAdminFiltersRequired) {
+		t.Errorf("Install() without filters error = %v, want %v", err, ErrAdminFiltersRequired)
+	}
+	deny := FilterFunc(func(w http.ResponseWriter, r *http.Request, next http.Handler) {
+		w.WriteHeader(http.StatusForbidden)
+	})
+	handler := NewAPI().Plugin(&SessionPlugin{Registry: registry, Filters: Filters{deny}}).Build()
+	for _, method := range []string{http.MethodGet, http.MethodDelete} {
+		target := "/admin/sessions"
+		if method == http.MethodDelete {
+			target += "/http-1"
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("%s %s: code = %d, want %d", method, target, rec.Code, http.StatusForbidden)
+		}
+	}
+}
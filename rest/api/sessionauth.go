@@ -0,0 +1,321 @@
+// Copyright 2023 The Kubegems Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrSessionNotFound = errors.New("session not found")
+
+type SessionData struct {
+	ID        string    `json:"id"`
+	User      UserInfo  `json:"user"`
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// SessionStore stores sessions of SessionManager, implement it on a shared storage when running multiple replicas,
+// e.g. redisstore.SessionStore.
+type SessionStore interface {
+	// Get returns ErrSessionNotFound if the session not exists or expired.
+	Get(ctx context.Context, id string) (*SessionData, error)
+	// Set stores the session until its ExpiresAt.
+	Set(ctx context.Context, session *SessionData) error
+	Delete(ctx context.Context, id string) error
+}
+
+var _ SessionStore = &MemorySessionStore{}
+
+type MemorySessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]SessionData
+}
+
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{sessions: map[string]SessionData{}}
+}
+
+func (s *MemorySessionStore) Get(ctx context.Context, id string) (*SessionData, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[id]
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+	if time.Now().After(session.ExpiresAt) {
+		delete(s.sessions, id)
+		return nil, ErrSessionNotFound
+	}
+	return &session, nil
+}
+
+func (s *MemorySessionStore) Set(ctx context.Context, session *SessionData) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for id, exist := range s.sessions {
+		if now.After(exist.ExpiresAt) {
+			delete(s.sessions, id)
+		}
+	}
+	s.sessions[session.ID] = *session
+	return nil
+}
+
+func (s *MemorySessionStore) Delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, id)
+	return nil
+}
+
+var _ SessionStore = &FileSessionStore{}
+
+// FileSessionStore stores each session as a json file in Dir, sessions survive restarts.
+// Expired files are removed on access, so a periodic cleanup is needed if sessions are rarely accessed.
+type FileSessionStore struct {
+	Dir string
+}
+
+func NewFileSessionStore(dir string) (*FileSessionStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &FileSessionStore{Dir: dir}, nil
+}
+
+func (s *FileSessionStore) Get(ctx context.Context, id string) (*SessionData, error) {
+	data, err := os.ReadFile(s.filename(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrSessionNotFound
+		}
+		return nil, err
+	}
+	session := &SessionData{}
+	if err := json.Unmarshal(data, session); err != nil {
+		return nil, fmt.Errorf("decode session: %w", err)
+	}
+	if time.Now().After(session.ExpiresAt) {
+		_ = os.Remove(s.filename(id))
+		return nil, ErrSessionNotFound
+	}
+	return session, nil
+}
+
+func (s *FileSessionStore) Set(ctx context.Context, session *SessionData) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	// write to a temp file then rename, so readers never see a partial file
+	tmp, err := os.CreateTemp(s.Dir, ".session-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.filename(session.ID))
+}
+
+func (s *FileSessionStore) Delete(ctx context.Context, id string) error {
+	if err := os.Remove(s.filename(id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *FileSessionStore) filename(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return filepath.Join(s.Dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// SessionManager issues sessions in a signed cookie "<id>.<hmac>", the session data is kept in Store.
+type SessionManager struct {
+	Store      SessionStore
+	Secret     string        // signs the cookie, at least 32 bytes
+	CookieName string        // default "sid"
+	TTL        time.Duration // idle timeout, default 30m
+	MaxAge     time.Duration // absolute lifetime regardless of activity, 0 means unlimited
+	Insecure   bool          // omits Secure on the cookie, only for serving plain http, e.g. in development
+}
+
+func NewSessionManager(store SessionStore, secret string) (*SessionManager, error) {
+	if len(secret) < 32 {
+		return nil, fmt.Errorf("session secret must be at least 32 bytes")
+	}
+	return &SessionManager{Store: store, Secret: secret, CookieName: "sid", TTL: 30 * time.Minute}, nil
+}
+
+// Create starts a new session of user and sets the cookie, e.g. after a successful login.
+func (m *SessionManager) Create(ctx context.Context, w http.ResponseWriter, user UserInfo) (*SessionData, error) {
+	now := time.Now()
+	session := &SessionData{
+		ID:        randomString(),
+		User:      user,
+		CreatedAt: now,
+		ExpiresAt: m.expiry(now, now),
+	}
+	if err := m.Store.Set(ctx, session); err != nil {
+		return nil, err
+	}
+	m.setCookie(w.Header(), session)
+	return session, nil
+}
+
+// Get returns the session of request, the expiration is extended when more than half of TTL elapsed.
+// header is where the refreshed cookie is set, it can be nil to skip the refresh.
+func (m *SessionManager) Get(ctx context.Context, r *http.Request, header http.Header) (*SessionData, error) {
+	id, err := m.sessionID(r)
+	if err != nil {
+		return nil, err
+	}
+	session, err := m.Store.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if !now.Before(session.ExpiresAt) {
+		return nil, ErrSessionNotFound
+	}
+	if header != nil && session.ExpiresAt.Sub(now) < m.ttl()/2 {
+		if expiry := m.expiry(session.CreatedAt, now); expiry.After(session.ExpiresAt) {
+			session.ExpiresAt = expiry
+			if err := m.Store.Set(ctx, session); err != nil {
+				return nil, err
+			}
+			m.setCookie(header, session)
+		}
+	}
+	return session, nil
+}
+
+// Destroy removes the session of request and clears the cookie.
+func (m *SessionManager) Destroy(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	http.SetCookie(w, &http.Cookie{Name: m.cookieName(), Path: "/", MaxAge: -1, HttpOnly: true, Secure: !m.Insecure})
+	id, err := m.sessionID(r)
+	if err != nil {
+		return nil // no valid session to remove
+	}
+	return m.Store.Delete(ctx, id)
+}
+
+// LogoutHandler destroys the session and redirects to the local path in query "redirect".
+func (m *SessionManager) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	if err := m.Destroy(r.Context(), w, r); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, safeRedirect(r.URL.Query().Get("redirect")), http.StatusFound)
+}
+
+func (m *SessionManager) sessionID(r *http.Request) (string, error) {
+	cookie, err := r.Cookie(m.cookieName())
+	if err != nil || cookie.Value == "" {
+		return "", ErrSessionNotFound
+	}
+	id, sig, ok := strings.Cut(cookie.Value, ".")
+	if !ok {
+		return "", fmt.Errorf("malformed session cookie")
+	}
+	expected, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(expected, m.mac(id)) {
+		return "", fmt.Errorf("invalid session cookie signature")
+	}
+	return id, nil
+}
+
+func (m *SessionManager) setCookie(header http.Header, session *SessionData) {
+	cookie := &http.Cookie{
+		Name:     m.cookieName(),
+		Value:    session.ID + "." + base64.RawURLEncoding.EncodeToString(m.mac(session.ID)),
+		Path:     "/",
+		Expires:  session.ExpiresAt,
+		HttpOnly: true,
+		Secure:   !m.Insecure,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if v := cookie.String(); v != "" {
+		header.Add("Set-Cookie", v)
+	}
+}
+
+func (m *SessionManager) mac(id string) []byte {
+	h := hmac.New(sha256.New, []byte(m.Secret))
+	h.Write([]byte(id))
+	return h.Sum(nil)
+}
+
+func (m *SessionManager) expiry(created, now time.Time) time.Time {
+	expiry := now.Add(m.ttl())
+	if m.MaxAge > 0 && expiry.After(created.Add(m.MaxAge)) {
+		expiry = created.Add(m.MaxAge)
+	}
+	return expiry
+}
+
+func (m *SessionManager) ttl() time.Duration {
+	if m.TTL <= 0 {
+		return 30 * time.Minute
+	}
+	return m.TTL
+}
+
+func (m *SessionManager) cookieName() string {
+	if m.CookieName == "" {
+		return "sid"
+	}
+	return m.CookieName
+}
+
+var _ HTTPAuthenticator = &SessionAuthenticator{}
+
+// SessionAuthenticator authenticates requests by the session cookie of Manager,
+// use it with NewHTTPAuthenticationFilter, the refreshed cookie is set to the response header in context.
+type SessionAuthenticator struct {
+	Manager *SessionManager
+}
+
+func NewSessionAuthenticator(manager *SessionManager) *SessionAuthenticator {
+	return &SessionAuthenticator{Manager: manager}
+}
+
+func (a *SessionAuthenticator) Authenticate(ctx context.Context, r *http.Request) (*AuthenticateInfo, error) {
+	session, err := a.Manager.Get(ctx, r, ResponseHeaderFromContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return &AuthenticateInfo{User: session.User}, nil
+}
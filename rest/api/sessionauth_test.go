@@ -0,0 +1,106 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSessionAuthenticator(t *testing.T) {
+	stores := map[string]SessionStore{
+		"memory": NewMemorySessionStore(),
+		"file":   &FileSessionStore{Dir: t.TempDir()},
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			manager, err := NewSessionManager(store, "0123456789abcdef0123456789abcdef")
+			if err != nil {
+				t.Fatal(err)
+			}
+			manager.TTL = time.Minute
+
+			rec := httptest.NewRecorder()
+			session, err := manager.Create(context.Background(), rec, UserInfo{Name: "alice"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			cookie := rec.Result().Cookies()[0]
+
+			handler := NewHTTPAuthenticationFilter(NewSessionAuthenticator(manager), nil).Process
+			serve := func(cookie *http.Cookie) *httptest.ResponseRecorder {
+				req := httptest.NewRequest(http.MethodGet, "/", nil)
+				if cookie != nil {
+					req.AddCookie(cookie)
+				}
+				rec := httptest.NewRecorder()
+				handler(rec, req, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.Write([]byte(AuthenticateFromContext(r.Context()).User.Name))
+				}))
+				return rec
+			}
+
+			if rec := serve(cookie); rec.Code != http.StatusOK || rec.Body.String() != "alice" {
+				t.Errorf("authenticate = %d %q, want 200 alice", rec.Code, rec.Body.String())
+			}
+			if rec := serve(nil); rec.Code != http.StatusUnauthorized {
+				t.Errorf("authenticate without cookie = %d, want 401", rec.Code)
+			}
+			tampered := *cookie
+			tampered.Value = "other" + tampered.Value[len(session.ID):]
+			if rec := serve(&tampered); rec.Code != http.StatusUnauthorized {
+				t.Errorf("authenticate tampered cookie = %d, want 401", rec.Code)
+			}
+
+			// sliding expiration refreshes the cookie when less than half of TTL left
+			session.ExpiresAt = time.Now().Add(10 * time.Second)
+			if err := store.Set(context.Background(), session); err != nil {
+				t.Fatal(err)
+			}
+			rec = serve(cookie)
+			if len(rec.Result().Cookies()) != 1 {
+				t.Fatalf("expected refreshed cookie")
+			}
+			refreshed, _ := store.Get(context.Background(), session.ID)
+			if time.Until(refreshed.ExpiresAt) < 50*time.Second {
+				t.Errorf("expiration not extended: %v", refreshed.ExpiresAt)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/logout", nil)
+			req.AddCookie(cookie)
+			manager.LogoutHandler(httptest.NewRecorder(), req)
+			if rec := serve(cookie); rec.Code != http.StatusUnauthorized {
+				t.Errorf("authenticate after logout = %d, want 401", rec.Code)
+			}
+		})
+	}
+}
+
+func TestSessionCookieSecure(t *testing.T) {
+	tests := []struct {
+		name     string
+		insecure bool
+		want     bool
+	}{
+		{name: "default", want: true},
+		{name: "insecure", insecure: true, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager, err := NewSessionManager(NewMemorySessionStore(), "0123456789abcdef0123456789abcdef")
+			if err != nil {
+				t.Fatal(err)
+			}
+			manager.Insecure = tt.insecure
+			rec := httptest.NewRecorder()
+			if _, err := manager.Create(context.Background(), rec, UserInfo{Name: "alice"}); err != nil {
+				t.Fatal(err)
+			}
+			cookies := rec.Result().Cookies()
+			if len(cookies) != 1 || cookies[0].Secure != tt.want {
+				t.Errorf("cookies = %v, want secure %v", cookies, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright 2023 The Kubegems Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-logr/logr"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// PropertySlowThreshold overrides the threshold of SlowRequestPlugin for a route,
+// e.g. GET("/export").Property(PropertySlowThreshold, "30s").
+const PropertySlowThreshold = "slowThreshold"
+
+type SlowRequestOptions struct {
+	Threshold  time.Duration // requests slower than it are logged, default 1s
+	Logger     logr.Logger
+	TraceEvent bool   // adds a "slow request" event to the span in the request context
+	Route      string // the route pattern logged, empty logs the request path
+}
+
+// NewSlowRequestFilter logs the requests exceeding the latency threshold with the route, user and status code,
+// separate from LoggingFilter which logs every request.
+// Use SlowRequestPlugin to log the route patterns, it must be placed after authentication filter to log the user.
+func NewSlowRequestFilter(opts SlowRequestOptions) Filter {
+	if opts.Threshold <= 0 {
+		opts.Threshold = time.Second
+	}
+	return FilterFunc(func(w http.ResponseWriter, r *http.Request, next http.Handler) {
+		start := time.Now()
+		sw := &StatusResponseWriter{Inner: w}
+		next.ServeHTTP(sw, r)
+		duration := time.Since(start)
+		if duration < opts.Threshold {
+			return
+		}
+		code := sw.Code
+		if code == 0 {
+			code = http.StatusOK
+		}
+		route := opts.Route
+		if route == "" {
+			route = r.URL.Path
+		}
+		user := AuthenticateFromContext(r.Context()).User.Name
+		kvs := []any{"route", route, "method", r.Method, "user", user, "code", code, "duration", duration.String(), "threshold", opts.Threshold.String()}
+		if id := RequestIDFromContext(r.Context()); id != "" {
+			kvs = append(kvs, "requestID", id)
+		}
+		opts.Logger.Info("slow request", kvs...)
+		if opts.TraceEvent {
+			trace.SpanFromContext(r.Context()).AddEvent("slow request", trace.WithAttributes(
+				attribute.String("route", route),
+				attribute.String("user", user),
+				attribute.Int("code", code),
+				attribute.String("duration", duration.String()),
+			))
+		}
+	})
+}
+
+var _ Plugin = &SlowRequestPlugin{}
+
+// SlowRequestPlugin logs the slow requests of each route with the route pattern,
+// PropertySlowThreshold of a route overrides the threshold.
+type SlowRequestPlugin struct {
+	NoopPlugin
+	Options SlowRequestOptions
+}
+
+func (p *SlowRequestPlugin) OnRoute(route *Route) error {
+	opts := p.Options
+	opts.Route = route.Path
+	if val, ok := route.Properties[PropertySlowThreshold]; ok {
+		threshold, err := propertyDuration(val)
+		if err != nil {
+			return fmt.Errorf("route %s %s property %s: %w", route.Method, route.Path, PropertySlowThreshold, err)
+		}
+		opts.Threshold = threshold
+	}
+	route.Filters = append([]Filter{NewSlowRequestFilter(opts)}, route.Filters...)
+	return nil
+}
@@ -0,0 +1,53 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr/funcr"
+)
+
+func TestSlowRequestPlugin(t *testing.T) {
+	tests := []struct {
+		name       string
+		properties map[string]any
+		sleep      time.Duration
+		wantLogged bool
+	}{
+		{name: "fast", sleep: 0},
+		{name: "slow", sleep: 30 * time.Millisecond, wantLogged: true},
+		{name: "threshold overridden", properties: map[string]any{PropertySlowThreshold: "1s"}, sleep: 30 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logged := ""
+			plugin := &SlowRequestPlugin{Options: SlowRequestOptions{
+				Threshold: 20 * time.Millisecond,
+				Logger:    funcr.New(func(prefix, args string) { logged = args }, funcr.Options{}),
+			}}
+			route := &Route{Method: http.MethodGet, Path: "/users/{name}", Properties: tt.properties}
+			if err := plugin.OnRoute(route); err != nil {
+				t.Fatal(err)
+			}
+			handler := Filters(route.Filters).Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				time.Sleep(tt.sleep)
+				w.WriteHeader(http.StatusAccepted)
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/users/alice", nil)
+			req = req.WithContext(WithAuthenticate(req.Context(), AuthenticateInfo{User: UserInfo{Name: "alice"}}))
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			if (logged != "") != tt.wantLogged {
+				t.Fatalf("logged %q, want logged %v", logged, tt.wantLogged)
+			}
+			for _, want := range []string{`"route"="/users/{name}"`, `"user"="alice"`, `"code"=202`} {
+				if tt.wantLogged && !strings.Contains(logged, want) {
+					t.Errorf("log %s does not contain %s", logged, want)
+				}
+			}
+		})
+	}
+}
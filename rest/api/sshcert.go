@@ -0,0 +1,117 @@
+// Copyright 2023 The Kubegems Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+var _ SSHAuthenticator = &CertAuthenticator{}
+
+// CertAuthenticator authenticates OpenSSH user certificates signed by the CA keys.
+//
+// The login user set by WithSSHUser must be one of the certificate principals,
+// the user name is the login user, or the first principal if not set, and the groups are the principals.
+// Certificates without principals are refused, as sshd does, they would be valid for any login user.
+// The critical options, e.g. source-address, are enforced by sshserver.Server with the certificate permissions.
+// Plain public keys and passwords are passed to Fallback.
+type CertAuthenticator struct {
+	CAKeys   []ssh.PublicKey
+	Fallback SSHAuthenticator // nil refuses plain public keys and passwords
+
+	// PrincipalsToGroups maps the principals to groups, default uses the principals as groups.
+	PrincipalsToGroups func(principals []string) []string
+	Clock              func() time.Time // default time.Now
+}
+
+func NewCertAuthenticator(cakeys []ssh.PublicKey, fallback SSHAuthenticator) *CertAuthenticator {
+	return &CertAuthenticator{CAKeys: cakeys, Fallback: fallback}
+}
+
+// LoadCAKeys reads CA public keys in authorized_keys format, e.g. the TrustedUserCAKeys file of sshd.
+func LoadCAKeys(file string) ([]ssh.PublicKey, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	keys := []ssh.PublicKey{}
+	for len(bytes.TrimSpace(data)) > 0 {
+		key, _, _, rest, err := ssh.ParseAuthorizedKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("parse ca keys %s: %w", file, err)
+		}
+		keys, data = append(keys, key), rest
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no ca keys found in %s", file)
+	}
+	return keys, nil
+}
+
+func (a *CertAuthenticator) AuthenticatePublibcKey(ctx context.Context, pubkey ssh.PublicKey) (*AuthenticateInfo, error) {
+	cert, ok := pubkey.(*ssh.Certificate)
+	if !ok {
+		if a.Fallback == nil {
+			return nil, fmt.Errorf("ssh: certificate required")
+		}
+		return a.Fallback.AuthenticatePublibcKey(ctx, pubkey)
+	}
+	if cert.CertType != ssh.UserCert {
+		return nil, fmt.Errorf("ssh: certificate is not a user certificate")
+	}
+	if len(cert.ValidPrincipals) == 0 {
+		return nil, fmt.Errorf("ssh: certificate has no principals")
+	}
+	principal := SSHUserFromContext(ctx)
+	if principal == "" {
+		principal = cert.ValidPrincipals[0]
+	}
+	checker := ssh.CertChecker{IsUserAuthority: a.isAuthority, Clock: a.Clock}
+	if !checker.IsUserAuthority(cert.SignatureKey) {
+		return nil, fmt.Errorf("ssh: certificate signed by unrecognized authority")
+	}
+	// validates the principal, the validity period, the critical options and the signature
+	if err := checker.CheckCert(principal, cert); err != nil {
+		return nil, err
+	}
+	groups := cert.ValidPrincipals
+	if a.PrincipalsToGroups != nil {
+		groups = a.PrincipalsToGroups(groups)
+	}
+	return &AuthenticateInfo{User: UserInfo{ID: cert.KeyId, Name: principal, Groups: groups}}, nil
+}
+
+func (a *CertAuthenticator) Authenticate(ctx context.Context, username, password string) (*AuthenticateInfo, error) {
+	if a.Fallback == nil {
+		return nil, fmt.Errorf("ssh: password authentication is disabled")
+	}
+	return a.Fallback.Authenticate(ctx, username, password)
+}
+
+func (a *CertAuthenticator) isAuthority(auth ssh.PublicKey) bool {
+	marshaled := auth.Marshal()
+	for _, key := range a.CAKeys {
+		if bytes.Equal(key.Marshal(), marshaled) {
+			return true
+		}
+	}
+	return false
+}
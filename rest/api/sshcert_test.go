@@ -0,0 +1,90 @@
+package api
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func newTestSigner(t *testing.T) ssh.Signer {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+func TestCertAuthenticator(t *testing.T) {
+	ca, otherca, user := newTestSigner(t), newTestSigner(t), newTestSigner(t)
+	now := time.Now()
+	newCert := func(signer ssh.Signer, certtype uint32, principals []string, validBefore time.Time) ssh.PublicKey {
+		cert := &ssh.Certificate{
+			Key:             user.PublicKey(),
+			KeyId:           "bob@example.com",
+			CertType:        certtype,
+			ValidPrincipals: principals,
+			ValidAfter:      uint64(now.Add(-time.Hour).Unix()),
+			ValidBefore:     uint64(validBefore.Unix()),
+		}
+		if err := cert.SignCert(rand.Reader, signer); err != nil {
+			t.Fatal(err)
+		}
+		return cert
+	}
+	authenticator := NewCertAuthenticator([]ssh.PublicKey{ca.PublicKey()}, nil)
+	tests := []struct {
+		name       string
+		loginUser  string
+		key        ssh.PublicKey
+		wantErr    bool
+		wantName   string
+		wantGroups []string
+	}{
+		{
+			name:       "valid",
+			loginUser:  "bob",
+			key:        newCert(ca, ssh.UserCert, []string{"bob", "admins"}, now.Add(time.Hour)),
+			wantName:   "bob",
+			wantGroups: []string{"bob", "admins"},
+		},
+		{
+			name:       "no login user uses first principal",
+			key:        newCert(ca, ssh.UserCert, []string{"bob", "admins"}, now.Add(time.Hour)),
+			wantName:   "bob",
+			wantGroups: []string{"bob", "admins"},
+		},
+		{name: "principal mismatch", loginUser: "root", key: newCert(ca, ssh.UserCert, []string{"bob"}, now.Add(time.Hour)), wantErr: true},
+		{name: "expired", loginUser: "bob", key: newCert(ca, ssh.UserCert, []string{"bob"}, now.Add(-time.Minute)), wantErr: true},
+		{name: "unknown ca", loginUser: "bob", key: newCert(otherca, ssh.UserCert, []string{"bob"}, now.Add(time.Hour)), wantErr: true},
+		{name: "host certificate", loginUser: "bob", key: newCert(ca, ssh.HostCert, []string{"bob"}, now.Add(time.Hour)), wantErr: true},
+		{name: "no principals", loginUser: "root", key: newCert(ca, ssh.UserCert, nil, now.Add(time.Hour)), wantErr: true},
+		{name: "no principals nor login user", key: newCert(ca, ssh.UserCert, nil, now.Add(time.Hour)), wantErr: true},
+		{name: "plain key without fallback", loginUser: "bob", key: user.PublicKey(), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := WithSSHUser(context.Background(), tt.loginUser)
+			info, err := authenticator.AuthenticatePublibcKey(ctx, tt.key)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("AuthenticatePublibcKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if info.User.Name != tt.wantName || info.User.ID != "bob@example.com" {
+				t.Errorf("user = %+v, want name %s", info.User, tt.wantName)
+			}
+			if len(info.User.Groups) != len(tt.wantGroups) {
+				t.Errorf("groups = %v, want %v", info.User.Groups, tt.wantGroups)
+			}
+		})
+	}
+}
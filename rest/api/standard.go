@@ -0,0 +1,281 @@
+// Copyright 2023 The Kubegems Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"kubegems.io/library/rest/response"
+)
+
+const HeaderRequestID = "X-Request-Id"
+
+type StandardFilterOptions struct {
+	Logger         logr.Logger
+	TrustedProxies []string // CIDRs allowed to set X-Forwarded-For/X-Real-Ip, empty trusts none
+
+	DisableRecovery  bool
+	DisableRequestID bool
+	DisableRealIP    bool
+	DisableLogging   bool
+	DisableTracing   bool
+	DisableWarning   bool
+	DebugTracing     bool // records a span for each filter after tracing, to diagnose latency of the filters
+
+	Metrics        MetricsRecorder     // nil disables metrics
+	Authenticator  TokenAuthenticator  // nil disables authentication
+	TokenExtractor TokenExtractor      // default ExtractTokenFromRequest
+	Attributes     AttributesExtractor // default PrefixedAttributesExtractor(""), e.g. PathAttributesExtractor
+	Authorizer     Authorizer          // nil disables authorization
+	Impersonation  bool                // allows Impersonate-User/Impersonate-Group headers, requires Authorizer
+	Auditor        Auditor             // nil disables audit
+	AuditSink      AuditSink
+	Traffic        TrafficRecorder // nil disables traffic accounting, recorded into audit metadata if audit enabled
+}
+
+// NewStandardFilterChain returns filters in the order:
+// recovery -> request id -> warning -> real ip -> logging -> metrics -> tracing -> authentication -> impersonation -> audit -> authorization -> traffic.
+//
+// Recovery comes first to catch panics from any filter, real ip must be resolved before logging and audit,
+// and authentication must be done before audit and authorization which depend on the user.
+// Audit comes before authorization so denied requests are audited with the decision and reason.
+func NewStandardFilterChain(opts StandardFilterOptions) Filters {
+	filters, traced := Filters{}, 0
+	if !opts.DisableRecovery {
+		filters = append(filters, NewRecoveryFilter(opts.Logger))
+	}
+	if !opts.DisableRequestID {
+		filters = append(filters, NewRequestIDFilter())
+	}
+	if !opts.DisableWarning {
+		filters = append(filters, NewWarningFilter(response.HeaderWarning))
+	}
+	if !opts.DisableRealIP {
+		filters = append(filters, NewRealIPFilter(opts.TrustedProxies))
+	}
+	if !opts.DisableLogging {
+		filters = append(filters, LoggingFilter(opts.Logger))
+	}
+	if opts.Metrics != nil {
+		filters = append(filters, NewMetricsFilter(opts.Metrics))
+	}
+	if !opts.DisableTracing {
+		filters = append(filters, NewOpenTelemetryFilter(nil))
+		traced = len(filters)
+	}
+	if opts.Authenticator != nil {
+		extractor := opts.TokenExtractor
+		if extractor == nil {
+			extractor = ExtractTokenFromRequest
+		}
+		filters = append(filters, NewTokenAuthenticationFilterWithExtractor(opts.Authenticator, extractor, nil))
+	}
+	if opts.Impersonation && opts.Authorizer != nil {
+		filters = append(filters, NewImpersonationFilter(opts.Authorizer))
+	}
+	if opts.Auditor != nil && opts.AuditSink != nil {
+		filters = append(filters, NewAuditFilter(opts.Auditor, opts.AuditSink))
+	}
+	if opts.Authorizer != nil {
+		var extractor AttributesExtractor = PrefixedAttributesExtractor("")
+		if opts.Attributes != nil {
+			extractor = opts.Attributes
+		}
+		filters = append(filters, NewAttributesExtractorFilter(extractor), NewAuthorizationFilter(opts.Authorizer))
+	}
+	if opts.Traffic != nil {
+		filters = append(filters, NewTrafficFilter(TrafficOptions{
+			Recorder:    opts.Traffic,
+			RecordAudit: opts.Auditor != nil && opts.AuditSink != nil,
+		}))
+	}
+	if opts.DebugTracing && traced > 0 {
+		filters = append(filters[:traced], TraceFilters(nil, filters[traced:])...)
+	}
+	return filters
+}
+
+// Handler wraps next with the filters.
+func (fs Filters) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fs.Process(w, r, next)
+	})
+}
+
+// NewRecoveryFilter recovers panics in the following filters and handler and responds 500.
+func NewRecoveryFilter(log logr.Logger) Filter {
+	return FilterFunc(func(w http.ResponseWriter, r *http.Request, next http.Handler) {
+		defer func() {
+			if err := recover(); err != nil {
+				if err == http.ErrAbortHandler {
+					panic(err)
+				}
+				log.Error(fmt.Errorf("%v", err), "panic recovered", "method", r.Method, "path", r.URL.Path, "stack", string(debug.Stack()))
+				response.InternalServerError(w, fmt.Errorf("internal server error"))
+			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+var requestIDContextKey = ContextKey("requestID")
+
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDContextKey, id)
+}
+
+func RequestIDFromContext(ctx context.Context) string {
+	if id, ok := ctx.Value(requestIDContextKey).(string); ok {
+		return id
+	}
+	return ""
+}
+
+// NewRequestIDFilter uses the X-Request-Id from request or generates a new one,
+// it is set to the response header and the request context.
+// The logger in context gets a "requestID" value and the current span, if any, gets the "http.request_id" attribute,
+// spans started by NewOpenTelemetryFilter after it and the audit log record it as well.
+func NewRequestIDFilter() Filter {
+	return FilterFunc(func(w http.ResponseWriter, r *http.Request, next http.Handler) {
+		id := r.Header.Get(HeaderRequestID)
+		if id == "" || len(id) > 128 {
+			id = newRequestID()
+		}
+		w.Header().Set(HeaderRequestID, id)
+		ctx := WithRequestID(r.Context(), id)
+		if log, err := logr.FromContext(ctx); err == nil {
+			ctx = logr.NewContext(ctx, log.WithValues("requestID", id))
+		}
+		setRequestIDAttribute(ctx)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// setRequestIDAttribute records the request id on the span in ctx.
+func setRequestIDAttribute(ctx context.Context) {
+	if id := RequestIDFromContext(ctx); id != "" {
+		trace.SpanFromContext(ctx).SetAttributes(attribute.String("http.request_id", id))
+	}
+}
+
+func newRequestID() string {
+	return randomString()
+}
+
+// NewRealIPFilter sets r.RemoteAddr to the client ip resolved by ClientIP, with port 0 as the client port is unknown,
+// so consumers splitting host and port, e.g. RequestSourceIPInCIDR and httputil.ReverseProxy, keep working.
+// X-Forwarded-For and X-Real-Ip are honored only when the direct peer is in trustedProxies,
+// otherwise they are removed to avoid spoofing.
+func NewRealIPFilter(trustedProxies []string) Filter {
+	return FilterFunc(func(w http.ResponseWriter, r *http.Request, next http.Handler) {
+		if !InCIDR(remoteIP(r.RemoteAddr), trustedProxies) {
+			r.Header.Del("X-Forwarded-For")
+			r.Header.Del("X-Real-Ip")
+			next.ServeHTTP(w, r)
+			return
+		}
+		clientip := ClientIP(r, trustedProxies, 0)
+		r.RemoteAddr = net.JoinHostPort(clientip, "0")
+		r.Header.Set("X-Real-Ip", clientip)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ClientIP returns the client ip of r, X-Forwarded-For and X-Real-Ip are honored only when the direct peer is in trustedProxies.
+// The right most untrusted address in X-Forwarded-For is the client, at most depth addresses are walked from the right,
+// e.g. depth 1 trusts the address appended by the only proxy, depth <= 0 means unlimited.
+// Walking stops at an address which is not an ip, the address right to it is the client then.
+func ClientIP(r *http.Request, trustedProxies []string, depth int) string {
+	peer := remoteIP(r.RemoteAddr)
+	if !InCIDR(peer, trustedProxies) {
+		return peer
+	}
+	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+		clientip := peer
+		// the right most untrusted address is the client
+		ips := strings.Split(xff, ",")
+		for i, hops := len(ips)-1, 1; i >= 0; i, hops = i-1, hops+1 {
+			ip := strings.TrimSpace(ips[i])
+			if net.ParseIP(ip) == nil {
+				break
+			}
+			clientip = ip
+			if !InCIDR(clientip, trustedProxies) || (depth > 0 && hops >= depth) {
+				break
+			}
+		}
+		return clientip
+	}
+	if realip := strings.TrimSpace(r.Header.Get("X-Real-Ip")); net.ParseIP(realip) != nil {
+		return realip
+	}
+	return peer
+}
+
+type MetricsRecorder interface {
+	ObserveRequest(r *http.Request, code int, duration time.Duration)
+}
+
+type MetricsRecorderFunc func(r *http.Request, code int, duration time.Duration)
+
+func (f MetricsRecorderFunc) ObserveRequest(r *http.Request, code int, duration time.Duration) {
+	f(r, code, duration)
+}
+
+func NewMetricsFilter(recorder MetricsRecorder) Filter {
+	return FilterFunc(func(w http.ResponseWriter, r *http.Request, next http.Handler) {
+		start := time.Now()
+		sw := &StatusResponseWriter{Inner: w}
+		next.ServeHTTP(sw, r)
+		code := sw.Code
+		if code == 0 {
+			code = http.StatusOK
+		}
+		recorder.ObserveRequest(r, code, time.Since(start))
+	})
+}
+
+// NewWarningFilter collects warnings added by response.Warn and emits them in header before the response written,
+// header is response.HeaderWarning or response.HeaderXWarning, default response.HeaderWarning.
+func NewWarningFilter(header string) Filter {
+	if header == "" {
+		header = response.HeaderWarning
+	}
+	return FilterFunc(func(w http.ResponseWriter, r *http.Request, next http.Handler) {
+		ctx := response.WithWarnings(r.Context())
+		emitted := false
+		emit := func(code int) {
+			if emitted || (code >= 100 && code < 200) {
+				return
+			}
+			emitted = true
+			for _, message := range response.Warnings(ctx) {
+				w.Header().Add(header, response.FormatWarning(message))
+			}
+		}
+		next.ServeHTTP(&StatusResponseWriter{Inner: w, OnWriteHeader: emit}, r.WithContext(ctx))
+		// nothing written, the header is written after return
+		emit(http.StatusOK)
+	})
+}
@@ -0,0 +1,193 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/funcr"
+)
+
+func TestRequestIDFilter(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   string
+	}{
+		{name: "from request", header: "req-1", want: "req-1"},
+		{name: "generated", header: ""},
+		{name: "too long", header: strings.Repeat("x", 129)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logged := ""
+			log := funcr.New(func(prefix, args string) { logged = args }, funcr.Options{})
+
+			var ctxid string
+			var auditlog *AuditLog
+			handler := Filters{NewRequestIDFilter()}.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ctxid = RequestIDFromContext(r.Context())
+				_, auditlog = (&SimpleAuditor{}).OnRequest(w, r)
+				logr.FromContextOrDiscard(r.Context()).Info("handled")
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				req.Header.Set(HeaderRequestID, tt.header)
+			}
+			req = req.WithContext(logr.NewContext(req.Context(), log))
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			id := rec.Header().Get(HeaderRequestID)
+			if id == "" || (tt.want != "" && id != tt.want) || len(id) > 128 {
+				t.Fatalf("response request id = %q, want %q", id, tt.want)
+			}
+			if ctxid != id {
+				t.Errorf("RequestIDFromContext() = %q, want %q", ctxid, id)
+			}
+			if auditlog.RequestID != id {
+				t.Errorf("AuditLog.RequestID = %q, want %q", auditlog.RequestID, id)
+			}
+			if !strings.Contains(logged, `"requestID"="`+id+`"`) {
+				t.Errorf("log entry %s does not contain request id %q", logged, id)
+			}
+		})
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	trusted := []string{"192.168.0.0/16"}
+	tests := []struct {
+		name       string
+		remoteaddr string
+		xff        string
+		realip     string
+		depth      int
+		want       string
+	}{
+		{name: "untrusted peer", remoteaddr: "1.2.3.4:1234", xff: "10.1.2.3", want: "1.2.3.4"},
+		{name: "trusted peer", remoteaddr: "192.168.1.1:1234", xff: "10.1.2.3", want: "10.1.2.3"},
+		{name: "right most untrusted", remoteaddr: "192.168.1.1:1234", xff: "6.6.6.6, 10.1.2.3, 192.168.1.2", want: "10.1.2.3"},
+		{name: "depth", remoteaddr: "192.168.1.1:1234", xff: "10.1.2.3, 192.168.1.2", depth: 1, want: "192.168.1.2"},
+		{name: "invalid entry", remoteaddr: "192.168.1.1:1234", xff: "10.1.2.3, evil, 192.168.1.2", want: "192.168.1.2"},
+		{name: "invalid only entry", remoteaddr: "192.168.1.1:1234", xff: "evil", want: "192.168.1.1"},
+		{name: "real ip", remoteaddr: "192.168.1.1:1234", realip: "10.1.2.3", want: "10.1.2.3"},
+		{name: "invalid real ip", remoteaddr: "192.168.1.1:1234", realip: "evil", want: "192.168.1.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteaddr
+			if tt.xff != "" {
+				r.Header.Set("X-Forwarded-For", tt.xff)
+			}
+			if tt.realip != "" {
+				r.Header.Set("X-Real-Ip", tt.realip)
+			}
+			if got := ClientIP(r, trusted, tt.depth); got != tt.want {
+				t.Errorf("ClientIP() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRealIPFilterAllowCIDR(t *testing.T) {
+	handler := Filters{
+		NewRealIPFilter([]string{"192.168.0.0/16"}),
+		NewRequestAuthorizationFilter(NewAllowCIDRAuthorizer([]string{"10.0.0.0/8"}, DecisionDeny).AuthorizeRequest),
+	}
+	tests := []struct {
+		name           string
+		remoteaddr     string
+		xff            string
+		wantRemoteAddr string
+		wantCode       int
+	}{
+		{name: "forwarded by trusted proxy", remoteaddr: "192.168.1.1:1234", xff: "10.1.2.3", wantRemoteAddr: "10.1.2.3:0", wantCode: http.StatusOK},
+		{name: "forwarded ipv6", remoteaddr: "192.168.1.1:1234", xff: "::1", wantRemoteAddr: "[::1]:0", wantCode: http.StatusForbidden},
+		{name: "spoofed by client", remoteaddr: "1.2.3.4:1234", xff: "10.1.2.3", wantRemoteAddr: "1.2.3.4:1234", wantCode: http.StatusForbidden},
+		{name: "direct", remoteaddr: "10.1.2.3:1234", wantRemoteAddr: "10.1.2.3:1234", wantCode: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteaddr
+			if tt.xff != "" {
+				r.Header.Set("X-Forwarded-For", tt.xff)
+			}
+			gotRemoteAddr := ""
+			rec := httptest.NewRecorder()
+			handler.Process(rec, r, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotRemoteAddr = r.RemoteAddr
+			}))
+			if rec.Code != tt.wantCode {
+				t.Errorf("code = %d, want %d", rec.Code, tt.wantCode)
+			}
+			if rec.Code == http.StatusOK && gotRemoteAddr != tt.wantRemoteAddr {
+				t.Errorf("RemoteAddr = %v, want %v", gotRemoteAddr, tt.wantRemoteAddr)
+			}
+		})
+	}
+}
+
+func TestStandardFilterChainAudit(t *testing.T) {
+	inner := AuthorizerFunc(func(ctx context.Context, user UserInfo, a Attributes) (Decision, string, error) {
+		SetAuthorizationPolicy(ctx, "admins-only")
+		if user.Name == "admin" {
+			return DecisionAllow, "is admin", nil
+		}
+		return DecisionDeny, "not admin", nil
+	})
+	sink := &recordingAuditSink{}
+	filters := NewStandardFilterChain(StandardFilterOptions{
+		Logger: logr.Discard(),
+		Authenticator: TokenAuthenticateFunc(func(ctx context.Context, token string) (*AuthenticateInfo, error) {
+			return &AuthenticateInfo{User: UserInfo{Name: token}}, nil
+		}),
+		Authorizer: NewCacheAuthorizer(inner, 10, time.Minute).WithDenyCache(10, time.Minute),
+		Auditor:    NewSimpleAuditor(),
+		AuditSink:  sink,
+	})
+	handler := filters.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	tests := []struct {
+		name     string
+		user     string
+		wantCode int
+		want     AuthorizationResult
+	}{
+		{name: "denied", user: "bob", wantCode: http.StatusForbidden, want: AuthorizationResult{Decision: "deny", Reason: "not admin", Policy: "admins-only"}},
+		{name: "denied from cache", user: "bob", wantCode: http.StatusForbidden, want: AuthorizationResult{Decision: "deny", Reason: "not admin", Policy: "admins-only"}},
+		{name: "allowed", user: "admin", wantCode: http.StatusOK, want: AuthorizationResult{Decision: "allow", Reason: "is admin", Policy: "admins-only"}},
+		{name: "allowed from cache", user: "admin", wantCode: http.StatusOK, want: AuthorizationResult{Decision: "allow", Reason: "is admin", Policy: "admins-only"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink.log = nil
+			req := httptest.NewRequest(http.MethodGet, "/zoos/z1", nil)
+			req.Header.Set("Authorization", "Bearer "+tt.user)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.wantCode {
+				t.Fatalf("code = %d, want %d", rec.Code, tt.wantCode)
+			}
+			if sink.log == nil || sink.log.Authorization == nil {
+				t.Fatalf("authorization not audited: %+v", sink.log)
+			}
+			if got := *sink.log.Authorization; got != tt.want {
+				t.Errorf("Authorization = %+v, want %+v", got, tt.want)
+			}
+			if sink.log.Subject != tt.user || sink.log.Action != "get" || sink.log.Resource != "zoos" || sink.log.ResourceName != "z1" {
+				t.Errorf("audit log = %s %s %s:%s", sink.log.Subject, sink.log.Action, sink.log.Resource, sink.log.ResourceName)
+			}
+			if sink.log.Response.StatusCode != tt.wantCode {
+				t.Errorf("audited code = %d, want %d", sink.log.Response.StatusCode, tt.wantCode)
+			}
+		})
+	}
+}
@@ -1,15 +1,95 @@
 package api
 
 import (
+	"fmt"
 	"net/http"
+	"reflect"
+	"runtime"
+	"strings"
 
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/trace"
 )
 
+const tracerName = "kubegems.io/library/rest/api"
+
 func NewOpenTelemetryFilter(tracer trace.Tracer) FilterFunc {
 	otelhandler := otelhttp.NewMiddleware("operation")
 	return func(w http.ResponseWriter, r *http.Request, next http.Handler) {
-		otelhandler(next).ServeHTTP(w, r)
+		otelhandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			setRequestIDAttribute(r.Context())
+			next.ServeHTTP(w, r)
+		})).ServeHTTP(w, r)
+	}
+}
+
+// NamedFilter names a filter in traces.
+type NamedFilter struct {
+	Name   string
+	Filter Filter
+}
+
+func (f NamedFilter) Process(w http.ResponseWriter, r *http.Request, next http.Handler) {
+	f.Filter.Process(w, r, next)
+}
+
+// TraceFilters wraps each filter with a span named "filter <name>", the span covers the filter and everything after it,
+// so the time spent in a filter itself is its span minus the child span.
+// It is for diagnosing latency inside the filter chain, a nil tracer uses the global tracer provider.
+func TraceFilters(tracer trace.Tracer, filters Filters) Filters {
+	if tracer == nil {
+		tracer = otel.GetTracerProvider().Tracer(tracerName)
+	}
+	traced := make(Filters, len(filters))
+	for i, filter := range filters {
+		traced[i] = traceFilter(tracer, filter)
+	}
+	return traced
+}
+
+func traceFilter(tracer trace.Tracer, filter Filter) Filter {
+	name := "filter " + FilterName(filter)
+	return FilterFunc(func(w http.ResponseWriter, r *http.Request, next http.Handler) {
+		ctx, span := tracer.Start(r.Context(), name)
+		defer span.End()
+		filter.Process(w, r.WithContext(ctx), next)
+	})
+}
+
+// FilterName returns the name of NamedFilter, the constructor name of FilterFunc closures
+// e.g. "api.NewAuditFilter", or the type name of other filters.
+func FilterName(filter Filter) string {
+	switch f := filter.(type) {
+	case NamedFilter:
+		return f.Name
+	case FilterFunc:
+		if name := funcName(f); name != "" {
+			return name
+		}
+		return "FilterFunc"
+	default:
+		return fmt.Sprintf("%T", filter)
+	}
+}
+
+// funcName returns the package qualified name of fn, closures are named by the enclosing function.
+func funcName(fn any) string {
+	f := runtime.FuncForPC(reflect.ValueOf(fn).Pointer())
+	if f == nil {
+		return ""
+	}
+	name := f.Name()
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	// trim closure suffixes, e.g. ".func1"
+	for {
+		i := strings.LastIndex(name, ".func")
+		if i < 0 {
+			break
+		}
+		name = name[:i]
 	}
+	return name
 }
@@ -0,0 +1,20 @@
+package api
+
+import "testing"
+
+func TestFilterName(t *testing.T) {
+	tests := []struct {
+		filter Filter
+		want   string
+	}{
+		{filter: NewAuditFilter(nil, nil), want: "api.NewAuditFilter"},
+		{filter: NewAuthenticateFilter(nil, nil), want: "api.NewAuthenticateFilter"},
+		{filter: NamedFilter{Name: "custom", Filter: NoopFilter()}, want: "custom"},
+		{filter: PredicatedFilter{}, want: "api.PredicatedFilter"},
+	}
+	for _, tt := range tests {
+		if got := FilterName(tt.filter); got != tt.want {
+			t.Errorf("FilterName() = %v, want %v", got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright 2023 The Kubegems Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// TrafficRecorder records the bytes transferred by a request of key, e.g. prommetrics.TrafficMetrics exports metrics.
+type TrafficRecorder interface {
+	RecordTraffic(key string, in, out int64)
+}
+
+type TrafficRecorderFunc func(key string, in, out int64)
+
+func (f TrafficRecorderFunc) RecordTraffic(key string, in, out int64) {
+	f(key, in, out)
+}
+
+type TrafficOptions struct {
+	// KeyFunc returns the accounting key of the request, empty key means not counted.
+	// default is QuotaKeyByUser.
+	KeyFunc  func(r *http.Request) string
+	Recorder TrafficRecorder
+	// RecordAudit adds "bytesIn" and "bytesOut" to the audit log metadata,
+	// the filter must be placed after the audit filter.
+	RecordAudit bool
+}
+
+// NewTrafficFilter counts request and response body bytes per user or tenant, for chargeback and abuse detection.
+// Request bytes are the bytes read by the handler, response bytes are the bytes written by the handler before compression.
+// It must be placed after authentication filter when keyed by user.
+func NewTrafficFilter(opts TrafficOptions) Filter {
+	if opts.KeyFunc == nil {
+		opts.KeyFunc = QuotaKeyByUser
+	}
+	return FilterFunc(func(w http.ResponseWriter, r *http.Request, next http.Handler) {
+		key := opts.KeyFunc(r)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		body := &countingReader{ReadCloser: r.Body}
+		if r.Body != nil {
+			r.Body = body
+		}
+		sw := &StatusResponseWriter{Inner: w}
+		next.ServeHTTP(sw, r)
+
+		in, out := body.n.Load(), sw.Written
+		if opts.Recorder != nil {
+			opts.Recorder.RecordTraffic(key, in, out)
+		}
+		if opts.RecordAudit {
+			SetAuditExtra(r, "bytesIn", strconv.FormatInt(in, 10))
+			SetAuditExtra(r, "bytesOut", strconv.FormatInt(out, 10))
+		}
+	})
+}
+
+type countingReader struct {
+	io.ReadCloser
+	n atomic.Int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+type Traffic struct {
+	Requests int64 `json:"requests"`
+	In       int64 `json:"in"`
+	Out      int64 `json:"out"`
+}
+
+var _ TrafficRecorder = &TrafficCounter{}
+
+// TrafficCounter sums traffic in memory, take snapshots periodically to export.
+type TrafficCounter struct {
+	mu       sync.Mutex
+	counters map[string]Traffic
+}
+
+func NewTrafficCounter() *TrafficCounter {
+	return &TrafficCounter{counters: map[string]Traffic{}}
+}
+
+func (c *TrafficCounter) RecordTraffic(key string, in, out int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := c.counters[key]
+	t.Requests++
+	t.In += in
+	t.Out += out
+	c.counters[key] = t
+}
+
+// Snapshot returns the counters, and resets them if reset is true.
+func (c *TrafficCounter) Snapshot(reset bool) map[string]Traffic {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	snapshot := make(map[string]Traffic, len(c.counters))
+	for k, v := range c.counters {
+		snapshot[k] = v
+	}
+	if reset {
+		c.counters = map[string]Traffic{}
+	}
+	return snapshot
+}
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTrafficFilter(t *testing.T) {
+	counter := NewTrafficCounter()
+	handler := Filters{NewTrafficFilter(TrafficOptions{Recorder: counter, RecordAudit: true})}.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Write([]byte("hello"))
+	}))
+	tests := []struct {
+		user         string
+		body         string
+		wantBytesIn  string
+		wantBytesOut string
+	}{
+		{user: "alice", body: "body", wantBytesIn: "4", wantBytesOut: "5"},
+		{user: "alice", body: "", wantBytesIn: "0", wantBytesOut: "5"},
+		{user: "bob", body: "longer body", wantBytesIn: "11", wantBytesOut: "5"},
+		{user: "", body: "anonymous"},
+	}
+	for _, tt := range tests {
+		auditlog := &AuditLog{}
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+		ctx := WithAuditLog(WithAuthenticate(context.Background(), AuthenticateInfo{User: UserInfo{Name: tt.user}}), auditlog)
+		handler.ServeHTTP(httptest.NewRecorder(), req.WithContext(ctx))
+		if auditlog.Metadata["bytesIn"] != tt.wantBytesIn || auditlog.Metadata["bytesOut"] != tt.wantBytesOut {
+			t.Errorf("%q: audit metadata = %v, want bytesIn %s bytesOut %s", tt.user, auditlog.Metadata, tt.wantBytesIn, tt.wantBytesOut)
+		}
+	}
+
+	want := map[string]Traffic{
+		"alice": {Requests: 2, In: 4, Out: 10},
+		"bob":   {Requests: 1, In: 11, Out: 5},
+	}
+	snapshot := counter.Snapshot(true)
+	if len(snapshot) != len(want) {
+		t.Fatalf("Snapshot() = %v, want %v", snapshot, want)
+	}
+	for k, v := range want {
+		if snapshot[k] != v {
+			t.Errorf("Snapshot()[%s] = %+v, want %+v", k, snapshot[k], v)
+		}
+	}
+	if snapshot := counter.Snapshot(false); len(snapshot) != 0 {
+		t.Errorf("Snapshot() after reset = %v, want empty", snapshot)
+	}
+}
@@ -0,0 +1,310 @@
+// Copyright 2023 The Kubegems Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package client is a http client for apis built with rest/api,
+// it backs off automatically following the RateLimit and Retry-After headers.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"kubegems.io/library/rest/response"
+)
+
+type Client struct {
+	Server     string // e.g. http://127.0.0.1:8080
+	HTTPClient *http.Client
+	Header     http.Header // default headers set on every request, e.g. Authorization
+	MaxRetries int         // retries on 429 and 503
+	MaxBackoff time.Duration
+	// WarningHandler is called with each warning in the Warning/X-Warning headers of responses, nil ignores warnings.
+	WarningHandler func(warning string)
+
+	mu    sync.Mutex
+	limit RateLimit // last rate limit seen from server
+}
+
+func New(server string) *Client {
+	return &Client{
+		Server:     strings.TrimSuffix(server, "/"),
+		HTTPClient: http.DefaultClient,
+		Header:     http.Header{},
+		MaxRetries: 3,
+		MaxBackoff: 30 * time.Second,
+	}
+}
+
+type RateLimit struct {
+	Limit     int64
+	Remaining int64
+	Reset     time.Time
+}
+
+// ParseRateLimit parses RateLimit-* headers, X-RateLimit-* headers are used as fallback.
+func ParseRateLimit(header http.Header, now time.Time) (RateLimit, bool) {
+	prefix := "Ratelimit-"
+	if header.Get(prefix+"Remaining") == "" {
+		prefix = "X-Ratelimit-"
+	}
+	limit, err1 := strconv.ParseInt(header.Get(prefix+"Limit"), 10, 64)
+	remaining, err2 := strconv.ParseInt(header.Get(prefix+"Remaining"), 10, 64)
+	reset, err3 := strconv.ParseInt(header.Get(prefix+"Reset"), 10, 64)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return RateLimit{}, false
+	}
+	rl := RateLimit{Limit: limit, Remaining: remaining}
+	if prefix == "X-Ratelimit-" {
+		rl.Reset = time.Unix(reset, 0) // unix timestamp
+	} else {
+		rl.Reset = now.Add(time.Duration(reset) * time.Second) // delta seconds
+	}
+	return rl, true
+}
+
+// ParseRetryAfter parses Retry-After in delta seconds or http date.
+func ParseRetryAfter(header http.Header, now time.Time) (time.Duration, bool) {
+	val := header.Get("Retry-After")
+	if val == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.ParseInt(val, 10, 64); err == nil {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if t, err := http.ParseTime(val); err == nil {
+		return t.Sub(now), true
+	}
+	return 0, false
+}
+
+// ParseWarnings returns the texts of Warning headers, X-Warning headers are also accepted.
+// Values not in the form of `code agent "text"` are returned as is.
+func ParseWarnings(header http.Header) []string {
+	warnings := []string{}
+	for _, name := range []string{response.HeaderWarning, response.HeaderXWarning} {
+		for _, value := range header.Values(name) {
+			for value = strings.TrimSpace(value); value != ""; value = strings.TrimSpace(value) {
+				var text string
+				text, value = parseWarning(value)
+				warnings = append(warnings, text)
+			}
+		}
+	}
+	return warnings
+}
+
+// parseWarning parses the first warning of value, returns its text and the remaining warnings.
+func parseWarning(value string) (string, string) {
+	fields := strings.SplitN(value, " ", 3)
+	if len(fields) != 3 || len(fields[0]) != 3 || !strings.HasPrefix(fields[2], `"`) {
+		return value, ""
+	}
+	quoted := fields[2]
+	text := strings.Builder{}
+	for i := 1; i < len(quoted); i++ {
+		switch c := quoted[i]; c {
+		case '\\':
+			if i+1 < len(quoted) {
+				i++
+				text.WriteByte(quoted[i])
+			}
+		case '"':
+			rest := quoted[i+1:]
+			// skip the optional warn-date and the separator of next warning
+			if strings.HasPrefix(rest, ` "`) {
+				if end := strings.Index(rest[2:], `"`); end >= 0 {
+					rest = rest[end+3:]
+				}
+			}
+			rest = strings.TrimPrefix(strings.TrimSpace(rest), ",")
+			return text.String(), rest
+		default:
+			text.WriteByte(c)
+		}
+	}
+	return text.String(), ""
+}
+
+// Do sends the request, it waits when the last response shows no remaining requests,
+// and retries on 429 and 503 after the server suggested time.
+// req.GetBody is required to retry requests with body.
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	if err := sleep(ctx, c.waitDuration(time.Now())); err != nil {
+		return nil, err
+	}
+	for attempt := 0; ; attempt++ {
+		for k, v := range c.Header {
+			if req.Header.Get(k) == "" {
+				req.Header[k] = v
+			}
+		}
+		resp, err := c.httpClient().Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if c.WarningHandler != nil {
+			for _, warning := range ParseWarnings(resp.Header) {
+				c.WarningHandler(warning)
+			}
+		}
+		now := time.Now()
+		if rl, ok := ParseRateLimit(resp.Header, now); ok {
+			c.mu.Lock()
+			c.limit = rl
+			c.mu.Unlock()
+		}
+		retryable := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable
+		if !retryable || attempt >= c.MaxRetries || (req.Body != nil && req.GetBody == nil) {
+			return resp, nil
+		}
+		backoff := c.backoff(resp.Header, attempt, now)
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if err := sleep(ctx, backoff); err != nil {
+			return nil, err
+		}
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+	}
+}
+
+// RateLimit returns the last rate limit seen from server.
+func (c *Client) RateLimit() RateLimit {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.limit
+}
+
+func (c *Client) waitDuration(now time.Time) time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.limit.Limit == 0 || c.limit.Remaining > 0 {
+		return 0
+	}
+	return c.capBackoff(c.limit.Reset.Sub(now))
+}
+
+func (c *Client) backoff(header http.Header, attempt int, now time.Time) time.Duration {
+	if d, ok := ParseRetryAfter(header, now); ok {
+		return c.capBackoff(d)
+	}
+	if rl, ok := ParseRateLimit(header, now); ok && rl.Remaining == 0 {
+		return c.capBackoff(rl.Reset.Sub(now))
+	}
+	return c.capBackoff(time.Duration(1<<attempt) * 100 * time.Millisecond)
+}
+
+func (c *Client) capBackoff(d time.Duration) time.Duration {
+	if c.MaxBackoff > 0 && d > c.MaxBackoff {
+		return c.MaxBackoff
+	}
+	return d
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Request sends a json request and decodes the "data" field of response into into,
+// the response format is the one written by response.OK.
+// Error responses are returned as *response.StatusError.
+func (c *Client) Request(ctx context.Context, method, path string, body, into any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.Server+path, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		data, _ := io.ReadAll(resp.Body)
+		errbody := struct {
+			Message string `json:"message"`
+		}{}
+		if json.Unmarshal(data, &errbody) != nil || errbody.Message == "" {
+			errbody.Message = strings.TrimSpace(string(data))
+		}
+		return response.NewStatusErrorMessage(resp.StatusCode, errbody.Message)
+	}
+	if into == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&struct {
+		Data any `json:"data"`
+	}{Data: into}); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+	return nil
+}
+
+func (c *Client) Get(ctx context.Context, path string, into any) error {
+	return c.Request(ctx, http.MethodGet, path, nil, into)
+}
+
+func (c *Client) Post(ctx context.Context, path string, body, into any) error {
+	return c.Request(ctx, http.MethodPost, path, body, into)
+}
+
+func (c *Client) Put(ctx context.Context, path string, body, into any) error {
+	return c.Request(ctx, http.MethodPut, path, body, into)
+}
+
+func (c *Client) Delete(ctx context.Context, path string) error {
+	return c.Request(ctx, http.MethodDelete, path, nil, nil)
+}
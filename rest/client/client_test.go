@@ -0,0 +1,126 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"kubegems.io/library/rest/api"
+	"kubegems.io/library/rest/response"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	tests := []struct {
+		name   string
+		header http.Header
+		want   RateLimit
+		wantOk bool
+	}{
+		{
+			name:   "standard",
+			header: http.Header{"Ratelimit-Limit": {"10"}, "Ratelimit-Remaining": {"0"}, "Ratelimit-Reset": {"5"}},
+			want:   RateLimit{Limit: 10, Remaining: 0, Reset: now.Add(5 * time.Second)},
+			wantOk: true,
+		},
+		{
+			name:   "legacy",
+			header: http.Header{"X-Ratelimit-Limit": {"10"}, "X-Ratelimit-Remaining": {"3"}, "X-Ratelimit-Reset": {"1700000060"}},
+			want:   RateLimit{Limit: 10, Remaining: 3, Reset: time.Unix(1700000060, 0)},
+			wantOk: true,
+		},
+		{
+			name:   "missing",
+			header: http.Header{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ParseRateLimit(tt.header, now)
+			if ok != tt.wantOk || got != tt.want {
+				t.Errorf("ParseRateLimit() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestClientRetryOnRateLimit(t *testing.T) {
+	limiter := api.NewRateLimitFilter(api.RateLimitOptions{
+		Rate: 20, Burst: 1,
+		KeyFunc: func(r *http.Request) string { return "all" },
+	})
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limiter.Process(w, r, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			response.OK(w, "ok")
+		}))
+	}))
+	defer server.Close()
+
+	cli := New(server.URL)
+	cli.MaxBackoff = 100 * time.Millisecond
+	for i := 0; i < 3; i++ {
+		var data string
+		if err := cli.Get(context.Background(), "/", &data); err != nil || data != "ok" {
+			t.Fatalf("Get() = %q, %v", data, err)
+		}
+	}
+	if calls != 3 {
+		t.Errorf("handler called %d times, want 3", calls)
+	}
+}
+
+func TestParseWarnings(t *testing.T) {
+	tests := []struct {
+		name   string
+		header http.Header
+		want   []string
+	}{
+		{
+			name:   "escaped",
+			header: http.Header{"Warning": {response.FormatWarning(`field "spec.foo" is deprecated`)}},
+			want:   []string{`field "spec.foo" is deprecated`},
+		},
+		{
+			name:   "multiple with date",
+			header: http.Header{"Warning": {`299 - "a" "Sat, 25 Aug 2012 23:34:45 GMT", 299 - "b"`}},
+			want:   []string{"a", "b"},
+		},
+		{
+			name:   "x-warning plain",
+			header: http.Header{"X-Warning": {"plain text"}},
+			want:   []string{"plain text"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseWarnings(tt.header); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseWarnings() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientWarningHandler(t *testing.T) {
+	handler := api.Filters{api.NewWarningFilter("")}.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		response.Warn(r.Context(), "deprecated")
+		response.Warn(r.Context(), "deprecated")
+		response.OK(w, nil)
+	}))
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	warnings := []string{}
+	cli := New(server.URL)
+	cli.WarningHandler = func(warning string) { warnings = append(warnings, warning) }
+	if err := cli.Get(context.Background(), "/", nil); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(warnings, []string{"deprecated"}) {
+		t.Errorf("warnings = %v, want [deprecated]", warnings)
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2023 The Kubegems Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package kafkasink publishes audit logs of package api to kafka.
+package kafkasink
+
+import (
+	"context"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"kubegems.io/library/rest/api"
+)
+
+type Options struct {
+	Brokers []string `json:"brokers,omitempty" description:"kafka broker addresses"`
+	Topic   string   `json:"topic,omitempty" description:"topic of audit logs"`
+}
+
+func NewDefaultOptions() *Options {
+	return &Options{Brokers: []string{"localhost:9092"}, Topic: "audit"}
+}
+
+var _ api.AuditMessageWriter = &Writer{}
+
+// Writer writes audit messages to a kafka topic.
+type Writer struct {
+	Writer *kafka.Writer
+}
+
+func NewWriter(options *Options) *Writer {
+	return &Writer{Writer: &kafka.Writer{
+		Addr:         kafka.TCP(options.Brokers...),
+		Topic:        options.Topic,
+		Balancer:     &kafka.Hash{}, // same key to the same partition
+		RequiredAcks: kafka.RequireAll,
+		BatchTimeout: 10 * time.Millisecond, // batched by api.BrokerAuditSink already
+	}}
+}
+
+func (w *Writer) WriteMessages(ctx context.Context, msgs ...api.AuditMessage) error {
+	kmsgs := make([]kafka.Message, len(msgs))
+	for i, msg := range msgs {
+		kmsgs[i] = kafka.Message{Key: msg.Key, Value: msg.Value}
+	}
+	return w.Writer.WriteMessages(ctx, kmsgs...)
+}
+
+func (w *Writer) Close() error {
+	return w.Writer.Close()
+}
+
+// NewAuditSink returns an api.AuditSink publishing audit logs to the kafka topic in batches,
+// the writer is closed after the pending logs flushed on ctx done.
+func NewAuditSink(ctx context.Context, options *Options, sinkOptions *api.BrokerAuditSinkOptions) *api.BrokerAuditSink {
+	writer := NewWriter(options)
+	sink := api.NewBrokerAuditSink(ctx, writer, sinkOptions)
+	go func() {
+		<-sink.Done()
+		_ = writer.Close()
+	}()
+	return sink
+}
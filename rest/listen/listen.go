@@ -29,6 +29,7 @@ import (
 	"github.com/go-logr/logr"
 	"golang.org/x/net/http2"
 	"golang.org/x/net/http2/h2c"
+	"kubegems.io/library/config"
 )
 
 func ServeHTTPContext(ctx context.Context, listen string, handler http.Handler) error {
@@ -36,6 +37,19 @@ func ServeHTTPContext(ctx context.Context, listen string, handler http.Handler)
 }
 
 func ServeContext(ctx context.Context, listen string, handler http.Handler, cert, key string) error {
+	return serve(ctx, listen, handler, cert, key, "", false)
+}
+
+// ServeMutualTLSContext serves https and verifies client certificates signed by the CAs in clientca,
+// clients without certificates are allowed if optional, e.g. when other authentication methods are accepted.
+func ServeMutualTLSContext(ctx context.Context, listen string, handler http.Handler, cert, key, clientca string, optional bool) error {
+	if cert == "" || key == "" || clientca == "" {
+		return fmt.Errorf("cert, key and client ca are required for mutual tls")
+	}
+	return serve(ctx, listen, handler, cert, key, clientca, optional)
+}
+
+func serve(ctx context.Context, listen string, handler http.Handler, cert, key, clientca string, optional bool) error {
 	log := logr.FromContextOrDiscard(ctx)
 	s := http.Server{
 		Handler: handler,
@@ -48,6 +62,11 @@ func ServeContext(ctx context.Context, listen string, handler http.Handler, cert
 	if err != nil {
 		return err
 	}
+	if tlsconfig != nil && clientca != "" {
+		if err := ClientCertTLSConfig(tlsconfig, clientca, optional); err != nil {
+			return err
+		}
+	}
 	if tlsconfig != nil {
 		s.TLSConfig = tlsconfig
 	}
@@ -99,6 +118,8 @@ func TLSConfig(cafile, certfile, keyfile string) (*tls.Config, error) {
 	return config, nil
 }
 
+// NewDynamicTLSConfig reloads the cert/key pair on file changes,
+// and on reloads of the config.Reloader in ctx if any.
 func NewDynamicTLSConfig(ctx context.Context, certfile, keyfile string) (*tls.Config, error) {
 	if certfile == "" || keyfile == "" {
 		return nil, nil
@@ -108,9 +129,42 @@ func NewDynamicTLSConfig(ctx context.Context, certfile, keyfile string) (*tls.Co
 		return nil, err
 	}
 	go dyn.Watch(ctx)
+	if reloader := config.ReloaderFromContext(ctx); reloader != nil {
+		reloader.Register("certificate "+certfile, dyn)
+	}
 	return &tls.Config{GetConfigForClient: dyn.GetConfigForClient}, nil
 }
 
+// ClientCertTLSConfig sets config to verify client certificates signed by the CAs in cafile,
+// certificates are required unless optional.
+func ClientCertTLSConfig(config *tls.Config, cafile string, optional bool) error {
+	capem, err := os.ReadFile(cafile)
+	if err != nil {
+		return err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(capem) {
+		return fmt.Errorf("no certificates found in %s", cafile)
+	}
+	clientauth := tls.RequireAndVerifyClientCert
+	if optional {
+		clientauth = tls.VerifyClientCertIfGiven
+	}
+	config.ClientCAs, config.ClientAuth = pool, clientauth
+	// the config returned by GetConfigForClient is used instead, e.g. DynamicCertificate
+	if getconfig := config.GetConfigForClient; getconfig != nil {
+		config.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			c, err := getconfig(hello)
+			if err != nil || c == nil {
+				return c, err
+			}
+			c.ClientCAs, c.ClientAuth = pool, clientauth
+			return c, nil
+		}
+	}
+	return nil
+}
+
 type DynamicCertificate struct {
 	certificate       tls.Certificate
 	certFile, keyFile string
@@ -164,25 +218,36 @@ func (c *DynamicCertificate) Watch(ctx context.Context) error {
 }
 
 func (c *DynamicCertificate) Reload(ctx context.Context) error {
+	apply, err := c.PrepareReload(ctx)
+	if err != nil {
+		return err
+	}
+	apply()
+	return nil
+}
+
+// PrepareReload loads the cert/key pair, it is applied to new connections only, the established ones are kept.
+func (c *DynamicCertificate) PrepareReload(ctx context.Context) (func(), error) {
 	log := logr.FromContextOrDiscard(ctx)
-	c.mu.Lock()
-	defer c.mu.Unlock()
 	cert, err := os.ReadFile(c.certFile)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	key, err := os.ReadFile(c.keyFile)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if len(cert) == 0 || len(key) == 0 {
-		return fmt.Errorf("missing content for serving cert")
+		return nil, fmt.Errorf("missing content for serving cert")
 	}
 	certificate, err := tls.X509KeyPair(cert, key)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	c.certificate = certificate
-	log.Info("(re)loaded a new cert/key pair", "cert", c.certFile, "key", c.keyFile)
-	return nil
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.certificate = certificate
+		log.Info("(re)loaded a new cert/key pair", "cert", c.certFile, "key", c.keyFile)
+	}, nil
 }
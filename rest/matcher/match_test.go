@@ -141,3 +141,113 @@ func TestCompileError_Error(t *testing.T) {
 		})
 	}
 }
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		pattern   string
+		positions []int
+	}{
+		{pattern: "/api/v{version}/{group}"},
+		{pattern: "/{repository}*/manifests/{reference}"},
+		{pattern: "/prefix*"},
+		{pattern: "/api/{name}/{name}", positions: []int{12}},
+		{pattern: "/api/{group}{version}", positions: []int{12}},
+		{pattern: "/proxy*/foo", positions: []int{0}},
+		{pattern: "/api/{name:[a-z}", positions: []int{11}},
+		{pattern: "/api/{name:[a-z+}", positions: []int{11}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			diagnostics := Validate(tt.pattern)
+			positions := []int{}
+			for _, d := range diagnostics {
+				positions = append(positions, d.Position)
+			}
+			if len(positions) != len(tt.positions) || (len(positions) > 0 && !reflect.DeepEqual(positions, tt.positions)) {
+				t.Errorf("Validate() = %v, want positions %v", diagnostics, tt.positions)
+			}
+		})
+	}
+}
+
+func benchmarkTree() *Node[string] {
+	tree := &Node[string]{}
+	for _, pattern := range []string{
+		"/api/v1/namespaces",
+		"/api/v1/nodes",
+		"/api/v1/pods",
+		"/api/v1/services",
+		"/api/v1/configmaps",
+		"/api/v1/namespaces/{namespace}/pods/{name}",
+		"/apis/{group}/{version}/namespaces/{namespace}/{resource}/{name}",
+		"/static/{path}*",
+		"/healthz",
+	} {
+		tree.MustRegister(pattern, pattern)
+	}
+	return tree
+}
+
+func TestMatchIndexed(t *testing.T) {
+	tree := benchmarkTree()
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: "/api/v1/pods", want: "/api/v1/pods"},
+		{path: "/api/v1/configmaps", want: "/api/v1/configmaps"},
+		{path: "/api/v1/namespaces/default/pods/nginx", want: "/api/v1/namespaces/{namespace}/pods/{name}"},
+		{path: "/apis/apps/v1/namespaces/default/deployments/nginx", want: "/apis/{group}/{version}/namespaces/{namespace}/{resource}/{name}"},
+		{path: "/static/js/app.js", want: "/static/{path}*"},
+		{path: "/api/v1/secrets", want: ""},
+	}
+	for _, tt := range tests {
+		node, _ := tree.Match(tt.path, nil)
+		got := ""
+		if node != nil {
+			got = node.Value
+		}
+		if got != tt.want {
+			t.Errorf("Match(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+	if allocs := testing.AllocsPerRun(100, func() { tree.Match("/api/v1/services", nil) }); allocs != 0 {
+		t.Errorf("Match() of constant path allocates %v times, want 0", allocs)
+	}
+}
+
+func BenchmarkMatch(b *testing.B) {
+	tree := benchmarkTree()
+	for _, path := range []string{
+		"/healthz",
+		"/api/v1/services",
+		"/api/v1/namespaces/default/pods/nginx",
+		"/apis/apps/v1/namespaces/default/deployments/nginx",
+		"/static/js/vendor/app.js",
+	} {
+		b.Run(path, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				tree.Match(path, nil)
+			}
+		})
+	}
+}
+
+func TestPrioritize(t *testing.T) {
+	tree := &Node[string]{}
+	tree.MustRegister("/a/{x}/b", "x")
+	tree.MustRegister("/a/{y}*", "y")
+	if node, _ := tree.Match("/a/1/b", nil); node.Value != "y" {
+		t.Errorf("Match() = %q before Prioritize, want %q", node.Value, "y")
+	}
+	if err := tree.Prioritize("/a/{x}/b", 1); err != nil {
+		t.Fatal(err)
+	}
+	if node, _ := tree.Match("/a/1/b", nil); node.Value != "x" {
+		t.Errorf("Match() = %q after Prioritize, want %q", node.Value, "x")
+	}
+	if err := tree.Prioritize("/a/{z}", 1); err == nil {
+		t.Errorf("Prioritize() of unregistered pattern expected error")
+	}
+}
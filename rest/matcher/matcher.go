@@ -12,7 +12,7 @@ func ParseToken(path string) []string {
 	tokens := []string{}
 	pos := 0
 	for i, char := range path {
-		if char == '/' {
+		if char == Separator {
 			if pos != i {
 				tokens = append(tokens, path[pos:i])
 			}
@@ -30,6 +30,12 @@ type Node[T any] struct {
 	Value   T
 
 	Children []*Node[T]
+	Priority int // children of higher priority are matched first regardless of the score, see Prioritize
+
+	// constant children indexed by pattern, they are Children[constStart:constStart+len(constants)]
+	constants  map[string]*Node[T]
+	constStart int
+	indexed    []*Node[T] // Children when indexed, the index is ignored once Children changed
 }
 
 func (n *Node[T]) Get(pattern string) ([]Section, *Node[T], error) {
@@ -47,17 +53,7 @@ func (n *Node[T]) Get(pattern string) ([]Section, *Node[T], error) {
 		if child == nil {
 			child = &Node[T]{Section: section}
 			cur.Children = append(cur.Children, child)
-			// sort children by score, so that we can match the most likely child first
-			slices.SortFunc(cur.Children, func(a, b *Node[T]) int {
-				ascore, bscore := a.Section.score(), b.Section.score()
-				if ascore > bscore {
-					return -1
-				}
-				if ascore < bscore {
-					return 1
-				}
-				return 0
-			})
+			cur.sortChildren()
 		}
 		nodeapath = append(nodeapath, child)
 		cur = child
@@ -65,6 +61,64 @@ func (n *Node[T]) Get(pattern string) ([]Section, *Node[T], error) {
 	return sections, cur, nil
 }
 
+// Prioritize raises the priority of the nodes along pattern to at least priority,
+// so the pattern is matched before the siblings of lower priority at each level, the score orders the same priorities.
+// It pins the resolution order among ambiguous patterns, e.g. "/api/{name}" before "/api/{path}*".
+func (n *Node[T]) Prioritize(pattern string, priority int) error {
+	sections, err := compileSections(pattern)
+	if err != nil {
+		return err
+	}
+	cur := n
+	for _, section := range sections {
+		child := indexnode(cur, section)
+		if child == nil {
+			return fmt.Errorf("pattern %s not registered", pattern)
+		}
+		if child.Priority < priority {
+			child.Priority = priority
+			cur.sortChildren()
+		}
+		cur = child
+	}
+	return nil
+}
+
+// sortChildren sorts children by priority then score, so that we can match the most likely child first.
+func (n *Node[T]) sortChildren() {
+	slices.SortStableFunc(n.Children, func(a, b *Node[T]) int {
+		if a.Priority != b.Priority {
+			return b.Priority - a.Priority
+		}
+		return b.Section.score() - a.Section.score()
+	})
+	n.indexConstants()
+}
+
+// indexConstants indexes the first run of adjacent constant children, they have the same score so they are adjacent
+// after sorting unless reordered by priorities.
+// Only the patterns starting with the separator are indexed, which match a token only if equal.
+func (n *Node[T]) indexConstants() {
+	n.constants, n.constStart, n.indexed = nil, 0, nil
+	for i, child := range n.Children {
+		if !child.Section.isConstant() || len(child.Section) != 1 || !strings.HasPrefix(child.Section[0].Pattern, string(Separator)) {
+			if n.constants != nil {
+				break
+			}
+			continue
+		}
+		if n.constants == nil {
+			n.constants, n.constStart = map[string]*Node[T]{}, i
+		}
+		n.constants[child.Section[0].Pattern] = child
+	}
+	if len(n.constants) < 2 {
+		n.constants = nil
+		return
+	}
+	n.indexed = n.Children
+}
+
 func indexnode[T any](node *Node[T], section Section) *Node[T] {
 	for index, exists := range node.Children {
 		if exists.Section.String() == section.String() {
@@ -79,6 +133,15 @@ type Element struct {
 	VarName  string
 	Greedy   bool
 	Validate *regexp.Regexp
+	Type     string // name of VarTypes if the variable is typed, e.g. "int" of {id:int}
+}
+
+// VarTypes are the regexps of the typed variables, e.g. {id:int} or {uid:uuid},
+// a value not of the type does not match.
+var VarTypes = map[string]string{
+	"int":  `-?[0-9]+`,
+	"uint": `[0-9]+`,
+	"uuid": `[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`,
 }
 
 type Section []Element
@@ -129,54 +192,85 @@ func (s Section) score() int {
 	return score
 }
 
+// Match returns the node matching path and the variables, the first candidate in score order wins.
+// It does not allocate for paths without variables.
 func (n *Node[T]) Match(path string, oncandidate func(val T) bool) (*Node[T], []MatchVar) {
-	return n.match(ParseToken(path), oncandidate)
+	node, vars := n.match(path, nil, oncandidate)
+	if node != nil && vars == nil {
+		vars = []MatchVar{} // zero length, not allocated
+	}
+	return node, vars
 }
 
-func (n *Node[T]) match(tokens []string, oncandidate func(val T) bool) (*Node[T], []MatchVar) {
-	for _, child := range n.Children {
-		if ok, lefttokens, vars := child.Section.match(tokens); ok {
-			if len(lefttokens) == 0 && (oncandidate == nil || oncandidate(child.Value)) {
-				return child, vars
-			}
-			node, childvars := child.match(lefttokens, oncandidate)
-			if node != nil {
-				return node, append(vars, childvars...)
+// match walks the tree with the rest of path, vars are appended to the ones matched by the parents.
+func (n *Node[T]) match(path string, vars []MatchVar, oncandidate func(val T) bool) (*Node[T], []MatchVar) {
+	indexed := n.constants != nil && len(n.indexed) == len(n.Children) && &n.indexed[0] == &n.Children[0]
+	for i := 0; i < len(n.Children); i++ {
+		child := n.Children[i]
+		if indexed && i == n.constStart && path != "" && path[0] == Separator {
+			// the indexed constants match the token only if equal
+			token, _ := nextToken(path)
+			i += len(n.constants) - 1
+			if child = n.constants[token]; child == nil {
+				continue
 			}
 		}
+		if node, matched := child.matchSelf(path, vars, oncandidate); node != nil {
+			return node, matched
+		}
 	}
 	return nil, nil
 }
 
+func (n *Node[T]) matchSelf(path string, vars []MatchVar, oncandidate func(val T) bool) (*Node[T], []MatchVar) {
+	ok, left, vars := n.Section.match(path, vars)
+	if !ok {
+		return nil, nil
+	}
+	if left == "" && (oncandidate == nil || oncandidate(n.Value)) {
+		return n, vars
+	}
+	return n.match(left, vars, oncandidate)
+}
+
+// nextToken splits the first token off path, a token starts with the separator except the first one of a relative path.
+func nextToken(path string) (string, string) {
+	if i := strings.IndexByte(path[1:], Separator); i != -1 {
+		return path[:i+1], path[i+1:]
+	}
+	return path, ""
+}
+
 type MatchVar struct {
 	Name  string `json:"name,omitempty"`
 	Value string `json:"value,omitempty"`
 }
 
-func (section Section) match(tokens []string) (bool, []string, []MatchVar) {
+func (section Section) match(path string, vars []MatchVar) (bool, string, []MatchVar) {
 	if len(section) == 0 {
-		return true, tokens, nil
+		return true, path, vars
 	}
 	pre := Element{}
-	if len(tokens) == 0 {
-		return false, tokens, nil
+	if path == "" {
+		return false, path, nil
 	}
-	token, lefttokens, vars := tokens[0], tokens[1:], []MatchVar{}
+	token, left := nextToken(path)
 	for _, elem := range section {
 		if elem.Greedy {
-			token, lefttokens = strings.Join(append([]string{token}, lefttokens...), ""), []string{}
+			// the rest of the token and the following tokens
+			token, left = path[len(path)-len(token)-len(left):], ""
 		}
 		if elem.VarName == "" {
 			// lastIndex or Index?
 			index := strings.Index(token, elem.Pattern)
 			if index == -1 {
-				return false, nil, nil
+				return false, "", nil
 			}
 			// finish pre var match
 			if pre.VarName != "" {
 				varmatch := token[:index]
 				if (varmatch == "" && pre.VarName != "") || (pre.Validate != nil && !pre.Validate.MatchString(varmatch)) {
-					return false, nil, nil
+					return false, "", nil
 				}
 				vars = append(vars, MatchVar{Name: pre.VarName, Value: varmatch})
 			}
@@ -192,16 +286,16 @@ func (section Section) match(tokens []string) (bool, []string, []MatchVar) {
 	if pre.VarName != "" {
 		// regexp check
 		if pre.Validate != nil && !pre.Validate.MatchString(token) {
-			return false, nil, nil
+			return false, "", nil
 		}
 		vars = append(vars, MatchVar{Name: pre.VarName, Value: token})
 		token = ""
 	}
 	// still left some chars
 	if token != "" {
-		return false, nil, nil
+		return false, "", nil
 	}
-	return true, lefttokens, vars
+	return true, left, vars
 }
 
 type CompileError struct {
@@ -245,9 +339,9 @@ func compile(pattern string) (Section, error) {
 	pre, curly := -1, 0
 	for i := 0; i < len(pattern); i++ {
 		switch pattern[i] {
-		case '\\':
+		case Escape:
 			i++ // skip the next char
-		case '{':
+		case VarOpen:
 			// open a variable
 			if curly == 0 {
 				// close pre section
@@ -257,7 +351,7 @@ func compile(pattern string) (Section, error) {
 				pre = i
 			}
 			curly++
-		case '}':
+		case VarClose:
 			if curly == 1 {
 				varname := pattern[pre+1 : i]
 				if varname == "" {
@@ -268,9 +362,12 @@ func compile(pattern string) (Section, error) {
 					Pattern: pattern[pre : i+1],
 					VarName: varname,
 				}
-				if idx := strings.IndexRune(elem.VarName, ':'); idx != -1 {
+				if idx := strings.IndexRune(elem.VarName, VarRegexpSep); idx != -1 {
 					name, regstr := elem.VarName[:idx], elem.VarName[idx+1:]
 					elem.VarName = name
+					if typed, ok := VarTypes[regstr]; ok {
+						elem.Type, regstr = regstr, typed
+					}
 					if regstr != "" {
 						regexp, err := regexp.Compile("^" + regstr + "$")
 						if err != nil {
@@ -280,7 +377,7 @@ func compile(pattern string) (Section, error) {
 					}
 				}
 				// check greedy
-				if i < len(pattern)-1 && pattern[i+1] == '*' {
+				if i < len(pattern)-1 && pattern[i+1] == Greedy {
 					elem.Greedy = true
 					i++
 				}
@@ -288,7 +385,7 @@ func compile(pattern string) (Section, error) {
 				pre = -1
 			}
 			curly--
-		case '/':
+		case Separator:
 			if curly != 0 {
 				continue
 			}
@@ -296,7 +393,7 @@ func compile(pattern string) (Section, error) {
 				elems = append(elems, Element{Pattern: pattern[pre:i]})
 			}
 			pre = i
-		case '*':
+		case Greedy:
 			if curly != 0 {
 				continue
 			}
@@ -317,7 +414,7 @@ func compile(pattern string) (Section, error) {
 	}
 	if pre != -1 {
 		lastpattern := pattern[pre:]
-		if lastpattern[len(lastpattern)-1] == '*' {
+		if lastpattern[len(lastpattern)-1] == Greedy {
 			elems = append(elems, Element{Pattern: lastpattern[:len(lastpattern)-1], Greedy: true})
 		} else {
 			elems = append(elems, Element{Pattern: pattern[pre:]})
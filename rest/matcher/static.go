@@ -0,0 +1,37 @@
+package matcher
+
+// StaticIndex returns the nodes of constant patterns indexed by path, has reports whether a node holds a value.
+// It is intended for a frozen tree, looking up the index before Match avoids walking the tree for static routes.
+// A path is indexed only when Match resolves it to the same node, so the lookup never changes the result.
+func (n *Node[T]) StaticIndex(has func(val T) bool) map[string]*Node[T] {
+	index := map[string]*Node[T]{}
+	n.walkStatic("", has, index)
+	for path, node := range index {
+		if matched, _ := n.Match(path, nil); matched != node {
+			delete(index, path)
+		}
+	}
+	return index
+}
+
+func (n *Node[T]) walkStatic(prefix string, has func(val T) bool, index map[string]*Node[T]) {
+	for _, child := range n.Children {
+		if !child.Section.isConstant() {
+			continue
+		}
+		path := prefix + child.Section.String()
+		if has(child.Value) {
+			index[path] = child
+		}
+		child.walkStatic(path, has, index)
+	}
+}
+
+func (s Section) isConstant() bool {
+	for _, elem := range s {
+		if elem.VarName != "" || elem.Greedy || elem.Validate != nil {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,87 @@
+package matcher
+
+import (
+	"errors"
+	"fmt"
+)
+
+// pattern syntax, see README.md
+const (
+	Separator    = '/'  // separates path segments
+	VarOpen      = '{'  // starts a variable, e.g. {name}
+	VarClose     = '}'  // ends a variable
+	VarRegexpSep = ':'  // separates variable name and regexp, e.g. {name:[a-z]+}
+	Greedy       = '*'  // after a variable or a constant at the end, matches the rest of path, e.g. {path}*
+	Escape       = '\\' // the next char is treated as normal char
+)
+
+// Validate checks the pattern and returns all the problems found, nil if the pattern is valid.
+// Besides the compile errors, it reports patterns which compile but never match as expected,
+// e.g. duplicate variable names, adjacent variables and segments after a greedy constant.
+func Validate(pattern string) []CompileError {
+	elems, err := compile(pattern)
+	if err != nil {
+		var compileErr CompileError
+		if errors.As(err, &compileErr) {
+			return []CompileError{compileErr}
+		}
+		return []CompileError{{Pattern: pattern, Message: err.Error()}}
+	}
+	var diagnostics []CompileError
+	seen := map[string]bool{}
+	position := 0
+	for i, elem := range elems {
+		if elem.VarName != "" && elem.VarName != "_" {
+			if seen[elem.VarName] {
+				diagnostics = append(diagnostics, CompileError{
+					Pattern: pattern, Position: position, Str: elem.Pattern,
+					Message: fmt.Sprintf("duplicate variable name %q", elem.VarName),
+				})
+			}
+			seen[elem.VarName] = true
+		}
+		if i > 0 && elem.VarName != "" && elems[i-1].VarName != "" {
+			diagnostics = append(diagnostics, CompileError{
+				Pattern: pattern, Position: position, Str: elem.Pattern,
+				Message: fmt.Sprintf("variable %q follows variable %q without a separator, the former never matches", elem.VarName, elems[i-1].VarName),
+			})
+		}
+		if elem.VarName == "" && elem.Greedy && i != len(elems)-1 {
+			diagnostics = append(diagnostics, CompileError{
+				Pattern: pattern, Position: position, Str: elem.Pattern + string(Greedy),
+				Message: "greedy constant matches the rest of path, the following segments are unreachable",
+			})
+		}
+		position += len(elem.Pattern)
+		if elem.Greedy {
+			position++
+		}
+	}
+	return diagnostics
+}
+
+// Register validates the pattern and sets val on the node of pattern.
+func (n *Node[T]) Register(pattern string, val T) (*Node[T], error) {
+	if diagnostics := Validate(pattern); len(diagnostics) > 0 {
+		errs := make([]error, len(diagnostics))
+		for i, d := range diagnostics {
+			errs[i] = d
+		}
+		return nil, errors.Join(errs...)
+	}
+	_, node, err := n.Get(pattern)
+	if err != nil {
+		return nil, err
+	}
+	node.Value = val
+	return node, nil
+}
+
+// MustRegister is like Register but panics on invalid pattern, it is intended for routes registered at startup.
+func (n *Node[T]) MustRegister(pattern string, val T) *Node[T] {
+	node, err := n.Register(pattern, val)
+	if err != nil {
+		panic(err)
+	}
+	return node
+}
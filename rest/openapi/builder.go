@@ -254,6 +254,10 @@ func (b *Builder) buildStruct(v reflect.Value) *spec.Schema {
 		if isEmbedded {
 			embeddedProperties = append(embeddedProperties, *fieldSchema)
 		} else {
+			if applyValidateTag(fieldSchema, structField) {
+				orignalSchama.Required = append(orignalSchama.Required, fieldName)
+			}
+			fieldSchema = applyDocTags(fieldSchema, structField)
 			orignalSchama.Properties[fieldName] = *fieldSchema
 		}
 	}
@@ -303,6 +307,38 @@ func structFieldInfo(structField reflect.StructField) (bool, bool, string) {
 	return isEmbedded, isIgnored, fieldName
 }
 
+// applyDocTags sets description, example and enum of schema from the struct tags of field.
+// enum values are separated by comma, example is parsed as json unless schema is a string.
+// a $ref schema is wrapped into allOf to keep its description.
+func applyDocTags(schema *spec.Schema, field reflect.StructField) *spec.Schema {
+	description := field.Tag.Get("description")
+	if description != "" && schema.Ref.String() != "" {
+		schema = &spec.Schema{SchemaProps: spec.SchemaProps{AllOf: []spec.Schema{*schema}}}
+	}
+	if description != "" {
+		schema.Description = description
+	}
+	if schema.Ref.String() != "" || len(schema.AllOf) > 0 {
+		return schema
+	}
+	if example, ok := field.Tag.Lookup("example"); ok {
+		schema.Example = example
+		if !schema.Type.Contains("string") {
+			var val any
+			if err := json.Unmarshal([]byte(example), &val); err == nil {
+				schema.Example = val
+			}
+		}
+	}
+	if enum := field.Tag.Get("enum"); enum != "" {
+		schema.Enum = nil
+		for _, val := range strings.Split(enum, ",") {
+			schema.Enum = append(schema.Enum, enumValue(schema, strings.TrimSpace(val)))
+		}
+	}
+	return schema
+}
+
 func IsDynamicInterface(t reflect.Type) bool {
 	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Array || t.Kind() == reflect.Map {
 		t = t.Elem()
@@ -539,3 +539,68 @@ func TestBuilder_buildSlice(t *testing.T) {
 		})
 	}
 }
+
+func TestBuilder_docTags(t *testing.T) {
+	type Item struct {
+		Name string `json:"name"`
+	}
+	type Documented struct {
+		Name    string   `json:"name" description:"name of the object" example:"foo"`
+		Phase   string   `json:"phase" enum:"Pending, Running,Failed"`
+		Level   int      `json:"level" enum:"1,2,3" example:"2"`
+		Ports   []int    `json:"ports" example:"[80,443]"`
+		Enabled bool     `json:"enabled" enum:"true"`
+		Item    Item     `json:"item" description:"the item" example:"{}"`
+		Raw     Item     `json:"raw" enum:"a,b"`
+		Kind    string   `json:"kind" validate:"oneof=a b" enum:"c"`
+		Alias   []string `json:"alias" example:"not-json"`
+	}
+
+	tests := []struct {
+		name string
+		data any
+		want spec.Schema
+	}{
+		{
+			name: "doc tags",
+			data: Documented{},
+			want: *ObjectPropertyProperties(spec.SchemaProperties{
+				"name": {
+					SchemaProps:        spec.SchemaProps{Type: []string{"string"}, Description: "name of the object"},
+					SwaggerSchemaProps: spec.SwaggerSchemaProps{Example: "foo"},
+				},
+				"phase": {SchemaProps: spec.SchemaProps{Type: []string{"string"}, Enum: []any{"Pending", "Running", "Failed"}}},
+				"level": {
+					SchemaProps:        spec.SchemaProps{Type: []string{"integer"}, Format: "int64", Enum: []any{int64(1), int64(2), int64(3)}},
+					SwaggerSchemaProps: spec.SwaggerSchemaProps{Example: float64(2)},
+				},
+				"ports": {
+					SchemaProps:        spec.ArrayProperty(spec.Int64Property()).SchemaProps,
+					SwaggerSchemaProps: spec.SwaggerSchemaProps{Example: []any{float64(80), float64(443)}},
+				},
+				"enabled": {SchemaProps: spec.SchemaProps{Type: []string{"boolean"}, Enum: []any{true}}},
+				"item": {SchemaProps: spec.SchemaProps{
+					Description: "the item",
+					AllOf:       []spec.Schema{*spec.RefSchema(DefinitionsRoot + "openapi.Item")},
+				}},
+				"raw":  *spec.RefSchema(DefinitionsRoot + "openapi.Item"),
+				"kind": {SchemaProps: spec.SchemaProps{Type: []string{"string"}, Enum: []any{"c"}}},
+				"alias": {
+					SchemaProps:        spec.ArrayProperty(spec.StringProperty()).SchemaProps,
+					SwaggerSchemaProps: spec.SwaggerSchemaProps{Example: "not-json"},
+				},
+			}),
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewBuilder(InterfaceBuildOptionOverride, nil)
+			b.Build(tt.data)
+			got := b.Definitions[TypeName(reflect.TypeOf(tt.data))]
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Builder.Build() definition = %v, want %v", JsonStr(got), JsonStr(tt.want))
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+
+	"github.com/go-openapi/spec"
+)
+
+// MergeSource is a document merged by Merge.
+type MergeSource struct {
+	// Name namespaces the definitions conflicting with another source as "<Name>.<definition>",
+	// conflicts are errors if empty.
+	Name string
+	// Prefix is prepended to the paths of the document, e.g. the path a remote service is proxied at.
+	Prefix  string
+	Swagger *spec.Swagger
+}
+
+// Merge merges sources into a new document based on a copy of base, base may be nil.
+// Identical definitions are kept once, different definitions of the same name are renamed
+// with the namespace of the source and the refs to them rewritten.
+// The same method on the same path, or different security definitions of the same name, are errors.
+func Merge(base *spec.Swagger, sources ...MergeSource) (*spec.Swagger, error) {
+	merged := &spec.Swagger{SwaggerProps: spec.SwaggerProps{Swagger: "2.0"}}
+	if base != nil {
+		if err := copySwagger(base, merged, nil); err != nil {
+			return nil, err
+		}
+	}
+	if merged.Definitions == nil {
+		merged.Definitions = spec.Definitions{}
+	}
+	if merged.Paths == nil {
+		merged.Paths = &spec.Paths{}
+	}
+	if merged.Paths.Paths == nil {
+		merged.Paths.Paths = map[string]spec.PathItem{}
+	}
+	for _, source := range sources {
+		if source.Swagger == nil {
+			continue
+		}
+		if err := mergeSource(merged, source); err != nil {
+			return nil, err
+		}
+	}
+	return merged, nil
+}
+
+func mergeSource(merged *spec.Swagger, source MergeSource) error {
+	renames := map[string]string{}
+	for name, schema := range source.Swagger.Definitions {
+		if exists, ok := merged.Definitions[name]; ok && !sameJSON(exists, schema) {
+			if source.Name == "" {
+				return fmt.Errorf("definition %s conflicts, a name is required to namespace it", name)
+			}
+			renames[name] = source.Name + "." + name
+		}
+	}
+	doc := &spec.Swagger{}
+	if err := copySwagger(source.Swagger, doc, renames); err != nil {
+		return err
+	}
+	for name, schema := range doc.Definitions {
+		if renamed, ok := renames[name]; ok {
+			name = renamed
+		}
+		if exists, ok := merged.Definitions[name]; ok && !sameJSON(exists, schema) {
+			return fmt.Errorf("definition %s conflicts", name)
+		}
+		merged.Definitions[name] = schema
+	}
+	for name, scheme := range doc.SecurityDefinitions {
+		if exists, ok := merged.SecurityDefinitions[name]; ok && !sameJSON(exists, scheme) {
+			return fmt.Errorf("security definition %s conflicts", name)
+		}
+		if merged.SecurityDefinitions == nil {
+			merged.SecurityDefinitions = spec.SecurityDefinitions{}
+		}
+		merged.SecurityDefinitions[name] = scheme
+	}
+	for _, tag := range doc.Tags {
+		if !containsTag(merged.Tags, tag.Name) {
+			merged.Tags = append(merged.Tags, tag)
+		}
+	}
+	if doc.Paths == nil {
+		return nil
+	}
+	for p, item := range doc.Paths.Paths {
+		if source.Prefix != "" {
+			p = path.Join(source.Prefix, p)
+		}
+		mergedItem, err := mergePathItem(merged.Paths.Paths[p], item)
+		if err != nil {
+			return fmt.Errorf("path %s: %w", p, err)
+		}
+		merged.Paths.Paths[p] = mergedItem
+	}
+	return nil
+}
+
+// copySwagger deep copies src into dst with the definition refs renamed.
+func copySwagger(src, dst *spec.Swagger, renames map[string]string) error {
+	data, err := json.Marshal(src)
+	if err != nil {
+		return err
+	}
+	// sort to rename deterministically
+	names := make([]string, 0, len(renames))
+	for name := range renames {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		data = bytes.ReplaceAll(data, []byte(`"`+DefinitionsRoot+name+`"`), []byte(`"`+DefinitionsRoot+renames[name]+`"`))
+	}
+	return json.Unmarshal(data, dst)
+}
+
+func mergePathItem(into, item spec.PathItem) (spec.PathItem, error) {
+	operations := []struct {
+		method     string
+		into, from **spec.Operation
+	}{
+		{"GET", &into.Get, &item.Get},
+		{"PUT", &into.Put, &item.Put},
+		{"POST", &into.Post, &item.Post},
+		{"DELETE", &into.Delete, &item.Delete},
+		{"OPTIONS", &into.Options, &item.Options},
+		{"HEAD", &into.Head, &item.Head},
+		{"PATCH", &into.Patch, &item.Patch},
+	}
+	for _, op := range operations {
+		if *op.from == nil {
+			continue
+		}
+		if *op.into != nil {
+			return into, fmt.Errorf("duplicate operation %s", op.method)
+		}
+		*op.into = *op.from
+	}
+	into.Parameters = append(into.Parameters, item.Parameters...)
+	return into, nil
+}
+
+// sameJSON reports whether a and b marshal to the same json, map keys are sorted by json.Marshal.
+func sameJSON(a, b any) bool {
+	adata, aerr := json.Marshal(a)
+	bdata, berr := json.Marshal(b)
+	return aerr == nil && berr == nil && bytes.Equal(adata, bdata)
+}
+
+func containsTag(tags []spec.Tag, name string) bool {
+	for _, tag := range tags {
+		if tag.Name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,115 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openapi
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/go-openapi/spec"
+)
+
+func TestMerge(t *testing.T) {
+	swagger := func(path string, definitions spec.Definitions) *spec.Swagger {
+		return &spec.Swagger{SwaggerProps: spec.SwaggerProps{
+			Swagger:     "2.0",
+			Definitions: definitions,
+			Paths: &spec.Paths{Paths: map[string]spec.PathItem{
+				path: {PathItemProps: spec.PathItemProps{Get: &spec.Operation{OperationProps: spec.OperationProps{
+					ID: "GET " + path,
+					Responses: &spec.Responses{ResponsesProps: spec.ResponsesProps{StatusCodeResponses: map[int]spec.Response{
+						200: {ResponseProps: spec.ResponseProps{Description: "OK", Schema: spec.RefSchema(DefinitionsRoot + "Item")}},
+					}}},
+				}}}},
+			}},
+		}}
+	}
+	item := spec.Definitions{"Item": *ObjectPropertyProperties(spec.SchemaProperties{"name": *spec.StringProperty()})}
+	other := spec.Definitions{"Item": *ObjectPropertyProperties(spec.SchemaProperties{"id": *spec.Int64Property()})}
+
+	tests := []struct {
+		name            string
+		sources         []MergeSource
+		wantErr         bool
+		wantDefinitions []string
+		wantRefs        map[string]string // path to ref of the response
+	}{
+		{
+			name: "identical definitions kept once",
+			sources: []MergeSource{
+				{Name: "a", Swagger: swagger("/a", item)},
+				{Name: "b", Swagger: swagger("/b", item)},
+			},
+			wantDefinitions: []string{"Item"},
+			wantRefs:        map[string]string{"/a": DefinitionsRoot + "Item", "/b": DefinitionsRoot + "Item"},
+		},
+		{
+			name: "conflicting definitions namespaced",
+			sources: []MergeSource{
+				{Name: "a", Swagger: swagger("/items", item)},
+				{Name: "b", Prefix: "/b", Swagger: swagger("/items", other)},
+			},
+			wantDefinitions: []string{"Item", "b.Item"},
+			wantRefs:        map[string]string{"/items": DefinitionsRoot + "Item", "/b/items": DefinitionsRoot + "b.Item"},
+		},
+		{
+			name: "conflicting definitions without name",
+			sources: []MergeSource{
+				{Swagger: swagger("/a", item)},
+				{Swagger: swagger("/b", other)},
+			},
+			wantErr: true,
+		},
+		{
+			name: "duplicate operation",
+			sources: []MergeSource{
+				{Name: "a", Swagger: swagger("/items", item)},
+				{Name: "b", Swagger: swagger("/items", item)},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Merge(nil, tt.sources...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Merge() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			definitions := []string{}
+			for name := range got.Definitions {
+				definitions = append(definitions, name)
+			}
+			if len(definitions) != len(tt.wantDefinitions) {
+				t.Errorf("Merge() definitions = %v, want %v", definitions, tt.wantDefinitions)
+			}
+			for _, name := range tt.wantDefinitions {
+				if _, ok := got.Definitions[name]; !ok {
+					t.Errorf("Merge() definition %s not found in %v", name, definitions)
+				}
+			}
+			refs := map[string]string{}
+			for path, item := range got.Paths.Paths {
+				refs[path] = item.Get.Responses.StatusCodeResponses[200].Schema.Ref.String()
+			}
+			if !reflect.DeepEqual(refs, tt.wantRefs) {
+				t.Errorf("Merge() refs = %v, want %v", refs, tt.wantRefs)
+			}
+		})
+	}
+}
@@ -0,0 +1,382 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/go-openapi/spec"
+)
+
+const (
+	ComponentsSchemasRoot = "#/components/schemas/"
+
+	VersionV2  = "2.0"
+	VersionV30 = "3.0.3"
+	VersionV31 = "3.1.0"
+)
+
+const (
+	ExtensionScheme       = "x-scheme"        // "bearer" of an apiKey scheme converts to http bearer scheme in OpenAPI 3
+	ExtensionBearerFormat = "x-bearer-format" // bearerFormat of the http bearer scheme
+)
+
+// BearerAuth returns a scheme of bearer token in the Authorization header, e.g. format "JWT".
+// Swagger 2.0 has no bearer scheme, it is an apiKey scheme which becomes the http bearer scheme in OpenAPI 3.
+func BearerAuth(format string) *spec.SecurityScheme {
+	scheme := spec.APIKeyAuth("Authorization", "header")
+	scheme.Description = "Bearer token in the Authorization header, e.g. \"Bearer <token>\""
+	scheme.AddExtension(ExtensionScheme, "bearer")
+	if format != "" {
+		scheme.AddExtension(ExtensionBearerFormat, format)
+	}
+	return scheme
+}
+
+// Document is an OpenAPI 3.0/3.1 document, schemas are kept as json as they are converted from swagger.
+type Document struct {
+	OpenAPI    string                `json:"openapi"`
+	Info       *spec.Info            `json:"info,omitempty"`
+	Servers    []Server              `json:"servers,omitempty"`
+	Paths      map[string]PathItem   `json:"paths"`
+	Components Components            `json:"components,omitempty"`
+	Security   []map[string][]string `json:"security,omitempty"`
+	Tags       []spec.Tag            `json:"tags,omitempty"`
+}
+
+type Server struct {
+	URL         string `json:"url"`
+	Description string `json:"description,omitempty"`
+}
+
+type Components struct {
+	Schemas         map[string]json.RawMessage `json:"schemas,omitempty"`
+	SecuritySchemes map[string]SecurityScheme  `json:"securitySchemes,omitempty"`
+}
+
+type SecurityScheme struct {
+	Type         string      `json:"type"` // http, apiKey or oauth2
+	Description  string      `json:"description,omitempty"`
+	Scheme       string      `json:"scheme,omitempty"` // basic or bearer for http
+	BearerFormat string      `json:"bearerFormat,omitempty"`
+	Name         string      `json:"name,omitempty"`
+	In           string      `json:"in,omitempty"`
+	Flows        *OAuthFlows `json:"flows,omitempty"`
+}
+
+type OAuthFlows struct {
+	Implicit          *OAuthFlow `json:"implicit,omitempty"`
+	Password          *OAuthFlow `json:"password,omitempty"`
+	ClientCredentials *OAuthFlow `json:"clientCredentials,omitempty"`
+	AuthorizationCode *OAuthFlow `json:"authorizationCode,omitempty"`
+}
+
+type OAuthFlow struct {
+	AuthorizationURL string            `json:"authorizationUrl,omitempty"`
+	TokenURL         string            `json:"tokenUrl,omitempty"`
+	Scopes           map[string]string `json:"scopes"`
+}
+
+// PathItem holds operations by lower case method.
+type PathItem map[string]*Operation
+
+type Operation struct {
+	OperationID string                `json:"operationId,omitempty"`
+	Tags        []string              `json:"tags,omitempty"`
+	Summary     string                `json:"summary,omitempty"`
+	Description string                `json:"description,omitempty"`
+	Deprecated  bool                  `json:"deprecated,omitempty"`
+	Parameters  []Parameter           `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]Response   `json:"responses"`
+	Security    []map[string][]string `json:"security,omitempty"`
+	Extensions  spec.Extensions       `json:"-"` // x- properties inlined
+}
+
+func (o Operation) MarshalJSON() ([]byte, error) {
+	type operation Operation
+	data, err := json.Marshal(operation(o))
+	if err != nil || len(o.Extensions) == 0 {
+		return data, err
+	}
+	extensions, err := json.Marshal(o.Extensions)
+	if err != nil {
+		return nil, err
+	}
+	// merge the objects
+	return append(append(data[:len(data)-1], ','), extensions[1:]...), nil
+}
+
+type Parameter struct {
+	Name        string          `json:"name"`
+	In          string          `json:"in"` // path, query, header or cookie
+	Description string          `json:"description,omitempty"`
+	Required    bool            `json:"required,omitempty"`
+	Schema      json.RawMessage `json:"schema,omitempty"`
+}
+
+type RequestBody struct {
+	Description string               `json:"description,omitempty"`
+	Required    bool                 `json:"required,omitempty"`
+	Content     map[string]MediaType `json:"content"`
+}
+
+type MediaType struct {
+	Schema json.RawMessage `json:"schema,omitempty"`
+}
+
+type Response struct {
+	Description string               `json:"description"`
+	Headers     map[string]Header    `json:"headers,omitempty"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+type Header struct {
+	Description string          `json:"description,omitempty"`
+	Schema      json.RawMessage `json:"schema,omitempty"`
+}
+
+// ConvertV3 converts a swagger 2.0 document to OpenAPI version, VersionV30 or VersionV31.
+// Definitions become components/schemas, body and formData parameters become requestBody,
+// and schemas of bodies are declared for each media type of consumes/produces, application/json by default.
+func ConvertV3(swagger *spec.Swagger, version string) (*Document, error) {
+	if version != VersionV30 && version != VersionV31 {
+		return nil, fmt.Errorf("unsupported openapi version %s", version)
+	}
+	doc := &Document{
+		OpenAPI:    version,
+		Info:       swagger.Info,
+		Servers:    convertServers(swagger),
+		Paths:      map[string]PathItem{},
+		Components: Components{Schemas: map[string]json.RawMessage{}},
+		Security:   swagger.Security,
+		Tags:       swagger.Tags,
+	}
+	for name, schema := range swagger.Definitions {
+		schema := schema
+		doc.Components.Schemas[name] = convertSchema(&schema)
+	}
+	for name, scheme := range swagger.SecurityDefinitions {
+		if doc.Components.SecuritySchemes == nil {
+			doc.Components.SecuritySchemes = map[string]SecurityScheme{}
+		}
+		doc.Components.SecuritySchemes[name] = convertSecurityScheme(scheme)
+	}
+	if swagger.Paths == nil {
+		return doc, nil
+	}
+	for path, item := range swagger.Paths.Paths {
+		converted := PathItem{}
+		for method, op := range map[string]*spec.Operation{
+			"get": item.Get, "put": item.Put, "post": item.Post, "delete": item.Delete,
+			"options": item.Options, "head": item.Head, "patch": item.Patch,
+		} {
+			if op != nil {
+				converted[method] = convertOperation(swagger, op, item.Parameters)
+			}
+		}
+		doc.Paths[path] = converted
+	}
+	return doc, nil
+}
+
+func convertServers(swagger *spec.Swagger) []Server {
+	if swagger.Host == "" {
+		if swagger.BasePath == "" {
+			return nil
+		}
+		return []Server{{URL: swagger.BasePath}}
+	}
+	schemes := swagger.Schemes
+	if len(schemes) == 0 {
+		schemes = []string{"https"}
+	}
+	servers := make([]Server, 0, len(schemes))
+	for _, scheme := range schemes {
+		servers = append(servers, Server{URL: scheme + "://" + swagger.Host + swagger.BasePath})
+	}
+	return servers
+}
+
+func convertSecurityScheme(scheme *spec.SecurityScheme) SecurityScheme {
+	converted := SecurityScheme{Type: scheme.Type, Description: scheme.Description, Name: scheme.Name, In: scheme.In}
+	switch scheme.Type {
+	case "basic":
+		converted.Type, converted.Scheme = "http", "basic"
+	case "apiKey":
+		if bearer, _ := scheme.Extensions.GetString(ExtensionScheme); bearer == "bearer" {
+			format, _ := scheme.Extensions.GetString(ExtensionBearerFormat)
+			converted = SecurityScheme{Type: "http", Description: scheme.Description, Scheme: "bearer", BearerFormat: format}
+		}
+	case "oauth2":
+		flow := &OAuthFlow{AuthorizationURL: scheme.AuthorizationURL, TokenURL: scheme.TokenURL, Scopes: scheme.Scopes}
+		if flow.Scopes == nil {
+			flow.Scopes = map[string]string{}
+		}
+		converted.Flows = &OAuthFlows{}
+		switch scheme.Flow {
+		case "implicit":
+			converted.Flows.Implicit = flow
+		case "password":
+			converted.Flows.Password = flow
+		case "application":
+			converted.Flows.ClientCredentials = flow
+		case "accessCode":
+			converted.Flows.AuthorizationCode = flow
+		}
+	}
+	return converted
+}
+
+func convertOperation(swagger *spec.Swagger, op *spec.Operation, common []spec.Parameter) *Operation {
+	converted := &Operation{
+		OperationID: op.ID,
+		Tags:        op.Tags,
+		Summary:     op.Summary,
+		Description: op.Description,
+		Deprecated:  op.Deprecated,
+		Responses:   map[string]Response{},
+		Security:    op.Security,
+		Extensions:  op.Extensions,
+	}
+	consumes := mediaTypes(op.Consumes, swagger.Consumes)
+	form := &spec.Schema{SchemaProps: spec.SchemaProps{Type: spec.StringOrArray{"object"}, Properties: spec.SchemaProperties{}}}
+	multipart := false
+	for _, param := range append(append([]spec.Parameter{}, common...), op.Parameters...) {
+		switch param.In {
+		case "body":
+			body := &RequestBody{Description: param.Description, Required: param.Required, Content: map[string]MediaType{}}
+			for _, mediatype := range consumes {
+				body.Content[mediatype] = MediaType{Schema: convertSchema(param.Schema)}
+			}
+			converted.RequestBody = body
+		case "formData":
+			schema := parameterSchema(param)
+			if param.Type == "file" {
+				schema, multipart = &spec.Schema{SchemaProps: spec.SchemaProps{Type: spec.StringOrArray{"string"}, Format: "binary"}}, true
+			}
+			form.Properties[param.Name] = *schema
+			if param.Required {
+				form.Required = append(form.Required, param.Name)
+			}
+		default:
+			converted.Parameters = append(converted.Parameters, Parameter{
+				Name:        param.Name,
+				In:          param.In,
+				Description: param.Description,
+				Required:    param.Required || param.In == "path",
+				Schema:      convertSchema(parameterSchema(param)),
+			})
+		}
+	}
+	if len(form.Properties) > 0 && converted.RequestBody == nil {
+		mediatype := "application/x-www-form-urlencoded"
+		if multipart || containsMediaType(consumes, "multipart/form-data") {
+			mediatype = "multipart/form-data"
+		}
+		converted.RequestBody = &RequestBody{Content: map[string]MediaType{mediatype: {Schema: convertSchema(form)}}}
+	}
+	if op.Responses != nil {
+		produces := mediaTypes(op.Produces, swagger.Produces)
+		if op.Responses.Default != nil {
+			converted.Responses["default"] = convertResponse(op.Responses.Default, produces)
+		}
+		for code, resp := range op.Responses.StatusCodeResponses {
+			resp := resp
+			converted.Responses[fmt.Sprint(code)] = convertResponse(&resp, produces)
+		}
+	}
+	return converted
+}
+
+func convertResponse(resp *spec.Response, produces []string) Response {
+	converted := Response{Description: resp.Description}
+	for name, header := range resp.Headers {
+		if converted.Headers == nil {
+			converted.Headers = map[string]Header{}
+		}
+		schema := &spec.Schema{SchemaProps: spec.SchemaProps{Type: spec.StringOrArray{"string"}}}
+		if header.Type != "" {
+			schema.Type, schema.Format = spec.StringOrArray{header.Type}, header.Format
+		}
+		converted.Headers[name] = Header{Description: header.Description, Schema: convertSchema(schema)}
+	}
+	if resp.Schema != nil {
+		converted.Content = map[string]MediaType{}
+		for _, mediatype := range produces {
+			converted.Content[mediatype] = MediaType{Schema: convertSchema(resp.Schema)}
+		}
+	}
+	return converted
+}
+
+// parameterSchema returns the schema of a non body parameter, the simple schema is used if no schema declared.
+func parameterSchema(param spec.Parameter) *spec.Schema {
+	if param.Schema != nil {
+		return param.Schema
+	}
+	schema := &spec.Schema{SchemaProps: spec.SchemaProps{
+		Type:    spec.StringOrArray{"string"},
+		Format:  param.Format,
+		Enum:    param.Enum,
+		Pattern: param.Pattern,
+		Default: param.Default,
+	}}
+	if param.Type != "" {
+		schema.Type = spec.StringOrArray{param.Type}
+	}
+	if param.Items != nil {
+		schema.Items = &spec.SchemaOrArray{Schema: &spec.Schema{SchemaProps: spec.SchemaProps{
+			Type:   spec.StringOrArray{param.Items.Type},
+			Format: param.Items.Format,
+			Enum:   param.Items.Enum,
+		}}}
+	}
+	return schema
+}
+
+// convertSchema marshals schema with the references to definitions replaced by the ones to components.
+func convertSchema(schema *spec.Schema) json.RawMessage {
+	if schema == nil {
+		return nil
+	}
+	data, err := json.Marshal(schema)
+	if err != nil {
+		return nil
+	}
+	return bytes.ReplaceAll(data, []byte(`"`+DefinitionsRoot), []byte(`"`+ComponentsSchemasRoot))
+}
+
+func mediaTypes(declared, defaults []string) []string {
+	if len(declared) > 0 {
+		return declared
+	}
+	if len(defaults) > 0 {
+		return defaults
+	}
+	return []string{"application/json"}
+}
+
+func containsMediaType(mediatypes []string, mediatype string) bool {
+	for _, m := range mediatypes {
+		if base, _, _ := strings.Cut(m, ";"); strings.TrimSpace(base) == mediatype {
+			return true
+		}
+	}
+	return false
+}
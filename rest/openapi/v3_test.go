@@ -0,0 +1,138 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openapi
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/go-openapi/spec"
+)
+
+func TestConvertV3(t *testing.T) {
+	type Zoo struct {
+		Name string `json:"name"`
+	}
+	definitions := map[string]spec.Schema{}
+	builder := NewBuilder(InterfaceBuildOptionDefault, definitions)
+	swagger := &spec.Swagger{SwaggerProps: spec.SwaggerProps{
+		Swagger:     "2.0",
+		Host:        "api.example.com",
+		BasePath:    "/v1",
+		Schemes:     []string{"https"},
+		Definitions: definitions,
+		SecurityDefinitions: spec.SecurityDefinitions{
+			"basic": spec.BasicAuth(),
+			"oauth": spec.OAuth2AccessToken("https://example.com/auth", "https://example.com/token"),
+		},
+		Paths: &spec.Paths{Paths: map[string]spec.PathItem{
+			"/zoos/{id}": {PathItemProps: spec.PathItemProps{
+				Put: &spec.Operation{
+					OperationProps: spec.OperationProps{
+						ID:       "PUT /zoos/{id}",
+						Consumes: []string{"application/json", "application/yaml"},
+						Parameters: []spec.Parameter{
+							{ParamProps: spec.ParamProps{Name: "id", In: "path"}, SimpleSchema: spec.SimpleSchema{Type: "integer"}},
+							{ParamProps: spec.ParamProps{Name: "body", In: "body", Required: true, Schema: builder.Build(Zoo{})}},
+						},
+						Responses: &spec.Responses{ResponsesProps: spec.ResponsesProps{StatusCodeResponses: map[int]spec.Response{
+							200: {ResponseProps: spec.ResponseProps{Description: "OK", Schema: builder.Build([]Zoo{})}},
+						}}},
+					},
+					VendorExtensible: spec.VendorExtensible{Extensions: spec.Extensions{"x-audit": true}},
+				},
+			}},
+			"/upload": {PathItemProps: spec.PathItemProps{
+				Post: &spec.Operation{OperationProps: spec.OperationProps{
+					Parameters: []spec.Parameter{
+						{ParamProps: spec.ParamProps{Name: "file", In: "formData", Required: true}, SimpleSchema: spec.SimpleSchema{Type: "file"}},
+					},
+				}},
+			}},
+		}},
+	}}
+	if _, err := ConvertV3(swagger, VersionV2); err == nil {
+		t.Errorf("ConvertV3() of version 2.0 expected error")
+	}
+	doc, err := ConvertV3(swagger, VersionV31)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]any{}
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]any{
+		"openapi":       VersionV31,
+		"servers.0.url": "https://api.example.com/v1",
+		"components.securitySchemes.basic.scheme":                                                  "basic",
+		"components.securitySchemes.oauth.flows.authorizationCode.tokenUrl":                        "https://example.com/token",
+		"paths./zoos/{id}.put.x-audit":                                                             true,
+		"paths./zoos/{id}.put.parameters.0.required":                                               true,
+		"paths./zoos/{id}.put.parameters.0.schema.type":                                            "integer",
+		"paths./zoos/{id}.put.requestBody.content.application/yaml.schema.$ref":                    ComponentsSchemasRoot + "openapi.Zoo",
+		"paths./zoos/{id}.put.responses.200.content.application/json.schema.items.$ref":            ComponentsSchemasRoot + "openapi.Zoo",
+		"paths./upload.post.requestBody.content.multipart/form-data.schema.properties.file.format": "binary",
+		"components.schemas.openapi\\.Zoo.properties.name.type":                                    "string",
+	} {
+		if val := lookupJSON(got, path); val != want {
+			t.Errorf("%s = %v, want %v", path, val, want)
+		}
+	}
+}
+
+// lookupJSON walks the decoded json by dot separated keys, "\." escapes a dot in key.
+func lookupJSON(v any, path string) any {
+	for _, key := range splitPath(path) {
+		switch val := v.(type) {
+		case map[string]any:
+			v = val[key]
+		case []any:
+			i := 0
+			for _, c := range key {
+				i = i*10 + int(c-'0')
+			}
+			if i >= len(val) {
+				return nil
+			}
+			v = val[i]
+		default:
+			return nil
+		}
+	}
+	return v
+}
+
+func splitPath(path string) []string {
+	keys, cur := []string{}, []rune{}
+	escaped := false
+	for _, c := range path {
+		switch {
+		case escaped:
+			cur, escaped = append(cur, c), false
+		case c == '\\':
+			escaped = true
+		case c == '.':
+			keys, cur = append(keys, string(cur)), cur[:0]
+		default:
+			cur = append(cur, c)
+		}
+	}
+	return append(keys, string(cur))
+}
@@ -0,0 +1,152 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openapi
+
+import (
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-openapi/spec"
+)
+
+// ValidateTag is the struct tag read for validation rules,
+// the same tag used by github.com/go-playground/validator.
+const ValidateTag = "validate"
+
+var oneofValuesRegexp = regexp.MustCompile(`'[^']*'|\S+`)
+
+// applyValidateTag populates schema constraints from the validate tag of field.
+// it returns true if the field is required.
+//
+// supported rules: required, min, max, len, gt, gte, lt, lte, oneof and regexp.
+// rules after "dive" apply to the elements and are ignored,
+// as are rules combined with "|".
+func applyValidateTag(schema *spec.Schema, field reflect.StructField) bool {
+	tag := field.Tag.Get(ValidateTag)
+	if tag == "" || tag == "-" {
+		return false
+	}
+	required := false
+	for _, rule := range strings.Split(tag, ",") {
+		if rule == "dive" || rule == "keys" {
+			break
+		}
+		if strings.Contains(rule, "|") {
+			continue
+		}
+		name, param, _ := strings.Cut(rule, "=")
+		param = strings.ReplaceAll(param, "0x2C", ",")
+		switch name {
+		case "required":
+			required = true
+			continue
+		}
+		if schema.Ref.String() != "" {
+			continue // a $ref can not have sibling constraints
+		}
+		switch name {
+		case "min", "gte":
+			setMinimum(schema, param, false)
+		case "max", "lte":
+			setMaximum(schema, param, false)
+		case "gt":
+			setMinimum(schema, param, true)
+		case "lt":
+			setMaximum(schema, param, true)
+		case "len":
+			setMinimum(schema, param, false)
+			setMaximum(schema, param, false)
+		case "oneof":
+			schema.Enum = nil
+			for _, val := range oneofValuesRegexp.FindAllString(param, -1) {
+				schema.Enum = append(schema.Enum, enumValue(schema, strings.Trim(val, "'")))
+			}
+		case "regexp":
+			schema.Pattern = param
+		}
+	}
+	return required
+}
+
+func setMinimum(schema *spec.Schema, param string, exclusive bool) {
+	switch {
+	case schema.Type.Contains("integer"), schema.Type.Contains("number"):
+		if val, err := strconv.ParseFloat(param, 64); err == nil {
+			schema.Minimum, schema.ExclusiveMinimum = &val, exclusive
+		}
+	default:
+		val, err := strconv.ParseInt(param, 10, 64)
+		if err != nil {
+			return
+		}
+		if exclusive {
+			val++
+		}
+		switch {
+		case schema.Type.Contains("string"):
+			schema.MinLength = &val
+		case schema.Type.Contains("array"):
+			schema.MinItems = &val
+		case schema.Type.Contains("object"):
+			schema.MinProperties = &val
+		}
+	}
+}
+
+func setMaximum(schema *spec.Schema, param string, exclusive bool) {
+	switch {
+	case schema.Type.Contains("integer"), schema.Type.Contains("number"):
+		if val, err := strconv.ParseFloat(param, 64); err == nil {
+			schema.Maximum, schema.ExclusiveMaximum = &val, exclusive
+		}
+	default:
+		val, err := strconv.ParseInt(param, 10, 64)
+		if err != nil {
+			return
+		}
+		if exclusive {
+			val--
+		}
+		switch {
+		case schema.Type.Contains("string"):
+			schema.MaxLength = &val
+		case schema.Type.Contains("array"):
+			schema.MaxItems = &val
+		case schema.Type.Contains("object"):
+			schema.MaxProperties = &val
+		}
+	}
+}
+
+// enumValue converts val to the type of schema, keeps it as string if not convertible.
+func enumValue(schema *spec.Schema, val string) any {
+	switch {
+	case schema.Type.Contains("integer"):
+		if i, err := strconv.ParseInt(val, 10, 64); err == nil {
+			return i
+		}
+	case schema.Type.Contains("number"):
+		if f, err := strconv.ParseFloat(val, 64); err == nil {
+			return f
+		}
+	case schema.Type.Contains("boolean"):
+		if b, err := strconv.ParseBool(val); err == nil {
+			return b
+		}
+	}
+	return val
+}
@@ -0,0 +1,91 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openapi
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/go-openapi/spec"
+)
+
+func TestBuilder_validateTag(t *testing.T) {
+	type Item struct {
+		Name string `json:"name"`
+	}
+	type Validated struct {
+		Name     string            `json:"name" validate:"required,min=1,max=64"`
+		Kind     string            `json:"kind,omitempty" validate:"oneof=a b 'c d'"`
+		Code     string            `json:"code" validate:"regexp=^[a-z]+$"`
+		Replicas int32             `json:"replicas" validate:"gte=0,lt=10"`
+		Level    int               `json:"level" validate:"oneof=1 2 3"`
+		Tags     []string          `json:"tags" validate:"required,max=5,dive,min=1"`
+		Labels   map[string]string `json:"labels" validate:"len=2"`
+		Item     Item              `json:"item" validate:"required,min=1"`
+		Optional string            `json:"optional" validate:"omitempty,len=8|len=0"`
+		Ignored  string            `json:"-" validate:"required"`
+	}
+
+	int64p := func(i int64) *int64 { return &i }
+	float64p := func(f float64) *float64 { return &f }
+
+	tests := []struct {
+		name string
+		data any
+		want spec.Schema
+	}{
+		{
+			name: "validate tags",
+			data: Validated{},
+			want: spec.Schema{
+				SchemaProps: spec.SchemaProps{
+					Type:     []string{"object"},
+					Required: []string{"name", "tags", "item"},
+					Properties: map[string]spec.Schema{
+						"name": {SchemaProps: spec.SchemaProps{Type: []string{"string"}, MinLength: int64p(1), MaxLength: int64p(64)}},
+						"kind": {SchemaProps: spec.SchemaProps{Type: []string{"string"}, Enum: []any{"a", "b", "c d"}}},
+						"code": {SchemaProps: spec.SchemaProps{Type: []string{"string"}, Pattern: "^[a-z]+$"}},
+						"replicas": {SchemaProps: spec.SchemaProps{
+							Type: []string{"integer"}, Format: "int32",
+							Minimum: float64p(0), Maximum: float64p(10), ExclusiveMaximum: true,
+						}},
+						"level": {SchemaProps: spec.SchemaProps{Type: []string{"integer"}, Format: "int64", Enum: []any{int64(1), int64(2), int64(3)}}},
+						"tags": {SchemaProps: spec.SchemaProps{
+							Type: []string{"array"}, MaxItems: int64p(5),
+							Items: &spec.SchemaOrArray{Schema: spec.StringProperty()},
+						}},
+						"labels": {SchemaProps: spec.SchemaProps{
+							Type: []string{"object"}, MinProperties: int64p(2), MaxProperties: int64p(2),
+							AdditionalProperties: &spec.SchemaOrBool{Allows: true, Schema: spec.StringProperty()},
+						}},
+						"item":     *spec.RefSchema(DefinitionsRoot + "openapi.Item"),
+						"optional": *spec.StringProperty(),
+					},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewBuilder(InterfaceBuildOptionOverride, nil)
+			b.Build(tt.data)
+			got := b.Definitions[TypeName(reflect.TypeOf(tt.data))]
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Builder.Build() definition = %v, want %v", JsonStr(got), JsonStr(tt.want))
+			}
+		})
+	}
+}
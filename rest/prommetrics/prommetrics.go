@@ -0,0 +1,160 @@
+// Copyright 2023 The Kubegems Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package prommetrics records http metrics of package api in prometheus.
+package prommetrics
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"kubegems.io/library/rest/api"
+)
+
+type Options struct {
+	Namespace       string
+	Subsystem       string                // default "http"
+	Registerer      prometheus.Registerer // default prometheus.DefaultRegisterer
+	DurationBuckets []float64             // seconds, default prometheus.DefBuckets
+	SizeBuckets     []float64             // bytes, default 100B to 100MB
+}
+
+func NewDefaultOptions() *Options {
+	return &Options{
+		Subsystem:       "http",
+		Registerer:      prometheus.DefaultRegisterer,
+		DurationBuckets: prometheus.DefBuckets,
+		SizeBuckets:     prometheus.ExponentialBuckets(100, 10, 7),
+	}
+}
+
+// Metrics are the http server metrics labeled by route pattern, method and status code,
+// the route pattern rather than the raw path keeps the cardinality bounded.
+type Metrics struct {
+	requests     *prometheus.CounterVec
+	duration     *prometheus.HistogramVec
+	requestSize  *prometheus.HistogramVec
+	responseSize *prometheus.HistogramVec
+	inflight     *prometheus.GaugeVec
+}
+
+func (opts *Options) setDefaults() {
+	defaults := NewDefaultOptions()
+	if opts.Subsystem == "" {
+		opts.Subsystem = defaults.Subsystem
+	}
+	if opts.Registerer == nil {
+		opts.Registerer = defaults.Registerer
+	}
+	if opts.DurationBuckets == nil {
+		opts.DurationBuckets = defaults.DurationBuckets
+	}
+	if opts.SizeBuckets == nil {
+		opts.SizeBuckets = defaults.SizeBuckets
+	}
+}
+
+func NewMetrics(opts *Options) (*Metrics, error) {
+	opts.setDefaults()
+	labels := []string{"route", "method", "code"}
+	m := &Metrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: opts.Namespace, Subsystem: opts.Subsystem,
+			Name: "requests_total", Help: "Total number of http requests.",
+		}, labels),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: opts.Namespace, Subsystem: opts.Subsystem,
+			Name: "request_duration_seconds", Help: "Duration of http requests.",
+			Buckets: opts.DurationBuckets,
+		}, labels),
+		requestSize: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: opts.Namespace, Subsystem: opts.Subsystem,
+			Name: "request_size_bytes", Help: "Size of http request bodies.",
+			Buckets: opts.SizeBuckets,
+		}, labels),
+		responseSize: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: opts.Namespace, Subsystem: opts.Subsystem,
+			Name: "response_size_bytes", Help: "Size of http response bodies.",
+			Buckets: opts.SizeBuckets,
+		}, labels),
+		inflight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: opts.Namespace, Subsystem: opts.Subsystem,
+			Name: "requests_in_flight", Help: "Number of http requests being served.",
+		}, []string{"route", "method"}),
+	}
+	for _, collector := range []prometheus.Collector{m.requests, m.duration, m.requestSize, m.responseSize, m.inflight} {
+		if err := opts.Registerer.Register(collector); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// Filter records the requests with the route label, e.g. the pattern of a route or the name of an upstream.
+func (m *Metrics) Filter(route string) api.Filter {
+	return api.FilterFunc(func(w http.ResponseWriter, r *http.Request, next http.Handler) {
+		inflight := m.inflight.WithLabelValues(route, r.Method)
+		inflight.Inc()
+		defer inflight.Dec()
+
+		start := time.Now()
+		body := &countingReader{ReadCloser: r.Body}
+		if r.Body != nil {
+			r.Body = body
+		}
+		sw := &api.StatusResponseWriter{Inner: w}
+		next.ServeHTTP(sw, r)
+		code := sw.Code
+		if code == 0 {
+			code = http.StatusOK
+		}
+		requestSize := body.n
+		if r.ContentLength > requestSize {
+			requestSize = r.ContentLength
+		}
+		labels := []string{route, r.Method, strconv.Itoa(code)}
+		m.requests.WithLabelValues(labels...).Inc()
+		m.duration.WithLabelValues(labels...).Observe(time.Since(start).Seconds())
+		m.requestSize.WithLabelValues(labels...).Observe(float64(requestSize))
+		m.responseSize.WithLabelValues(labels...).Observe(float64(sw.Written))
+	})
+}
+
+// countingReader counts the request body bytes read by the handler, for bodies of unknown length.
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+var _ api.Plugin = &Plugin{}
+
+// Plugin records the metrics of each route with the route pattern, e.g. "/users/{name}".
+type Plugin struct {
+	api.NoopPlugin
+	Metrics *Metrics
+}
+
+func (p *Plugin) OnRoute(route *api.Route) error {
+	route.Filters = append([]api.Filter{p.Metrics.Filter(route.Path)}, route.Filters...)
+	return nil
+}
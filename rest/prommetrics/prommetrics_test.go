@@ -0,0 +1,54 @@
+package prommetrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"kubegems.io/library/rest/api"
+)
+
+func TestPlugin(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	metrics, err := NewMetrics(&Options{Registerer: registry})
+	if err != nil {
+		t.Fatal(err)
+	}
+	route := &api.Route{Method: http.MethodPost, Path: "/users/{name}"}
+	if err := (&Plugin{Metrics: metrics}).OnRoute(route); err != nil {
+		t.Fatal(err)
+	}
+	handler := api.Filters(route.Filters).Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		if r.URL.Path == "/users/missing" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+		w.Write([]byte("hello"))
+	}))
+	for _, path := range []string{"/users/a", "/users/b", "/users/missing"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, path, strings.NewReader("body")))
+	}
+
+	tests := []struct {
+		code string
+		want float64
+	}{
+		{code: "200", want: 2},
+		{code: "404", want: 1},
+	}
+	for _, tt := range tests {
+		if got := testutil.ToFloat64(metrics.requests.WithLabelValues("/users/{name}", http.MethodPost, tt.code)); got != tt.want {
+			t.Errorf("requests_total{code=%s} = %v, want %v", tt.code, got, tt.want)
+		}
+	}
+	if got := testutil.ToFloat64(metrics.inflight.WithLabelValues("/users/{name}", http.MethodPost)); got != 0 {
+		t.Errorf("requests_in_flight = %v, want 0", got)
+	}
+	if count := testutil.CollectAndCount(registry, "http_response_size_bytes"); count != 2 {
+		t.Errorf("response_size_bytes series = %d, want 2", count)
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright 2023 The Kubegems Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prommetrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"kubegems.io/library/rest/api"
+)
+
+var _ api.TrafficRecorder = &TrafficMetrics{}
+
+// TrafficMetrics exports the traffic accounted by api.NewTrafficFilter, labeled by the accounting key.
+// The key is a user or tenant, so the cardinality grows with them, use a KeyFunc of bounded values for many users.
+type TrafficMetrics struct {
+	requests *prometheus.CounterVec
+	in       *prometheus.CounterVec
+	out      *prometheus.CounterVec
+}
+
+func NewTrafficMetrics(opts *Options) (*TrafficMetrics, error) {
+	opts.setDefaults()
+	labels := []string{"key"}
+	m := &TrafficMetrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: opts.Namespace, Subsystem: opts.Subsystem,
+			Name: "traffic_requests_total", Help: "Total number of http requests accounted by key.",
+		}, labels),
+		in: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: opts.Namespace, Subsystem: opts.Subsystem,
+			Name: "traffic_received_bytes_total", Help: "Total bytes of http request bodies accounted by key.",
+		}, labels),
+		out: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: opts.Namespace, Subsystem: opts.Subsystem,
+			Name: "traffic_sent_bytes_total", Help: "Total bytes of http response bodies accounted by key.",
+		}, labels),
+	}
+	for _, collector := range []prometheus.Collector{m.requests, m.in, m.out} {
+		if err := opts.Registerer.Register(collector); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// RecordTraffic implements api.TrafficRecorder.
+func (m *TrafficMetrics) RecordTraffic(key string, in, out int64) {
+	m.requests.WithLabelValues(key).Inc()
+	m.in.WithLabelValues(key).Add(float64(in))
+	m.out.WithLabelValues(key).Add(float64(out))
+}
@@ -0,0 +1,58 @@
+package prommetrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"kubegems.io/library/rest/api"
+)
+
+func TestTrafficMetrics(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	metrics, err := NewTrafficMetrics(&Options{Registerer: registry})
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter := api.NewTrafficFilter(api.TrafficOptions{Recorder: metrics, KeyFunc: api.QuotaKeyByHeader("X-Tenant")})
+	handler := api.Filters{filter}.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Write([]byte("hello"))
+	}))
+	for _, tenant := range []string{"a", "a", "b", ""} {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("body"))
+		req.Header.Set("X-Tenant", tenant)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	tests := []struct {
+		key          string
+		wantRequests float64
+		wantIn       float64
+		wantOut      float64
+	}{
+		{key: "a", wantRequests: 2, wantIn: 8, wantOut: 10},
+		{key: "b", wantRequests: 1, wantIn: 4, wantOut: 5},
+	}
+	for _, tt := range tests {
+		if got := testutil.ToFloat64(metrics.requests.WithLabelValues(tt.key)); got != tt.wantRequests {
+			t.Errorf("traffic_requests_total{key=%s} = %v, want %v", tt.key, got, tt.wantRequests)
+		}
+		if got := testutil.ToFloat64(metrics.in.WithLabelValues(tt.key)); got != tt.wantIn {
+			t.Errorf("traffic_received_bytes_total{key=%s} = %v, want %v", tt.key, got, tt.wantIn)
+		}
+		if got := testutil.ToFloat64(metrics.out.WithLabelValues(tt.key)); got != tt.wantOut {
+			t.Errorf("traffic_sent_bytes_total{key=%s} = %v, want %v", tt.key, got, tt.wantOut)
+		}
+	}
+	if count := testutil.CollectAndCount(registry, "http_traffic_requests_total"); count != 2 {
+		t.Errorf("traffic_requests_total series = %d, want 2", count)
+	}
+	if _, err := NewTrafficMetrics(&Options{Registerer: registry}); err == nil {
+		t.Error("NewTrafficMetrics() registered twice expected error")
+	}
+}
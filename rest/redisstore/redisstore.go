@@ -0,0 +1,303 @@
+// Copyright 2023 The Kubegems Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package redisstore implements the state stores of package api on redis,
+// so that replicas of a deployment share rate limits, quotas and caches.
+package redisstore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"kubegems.io/library/rest/api"
+)
+
+const DefaultPrefix = "kubegems:"
+
+var _ api.FailureCounterStore = &CounterStore{}
+
+// CounterStore is an api.CounterStore, each window is a key expires at the window end.
+type CounterStore struct {
+	Client redis.UniversalClient
+	Prefix string
+}
+
+func NewCounterStore(client redis.UniversalClient) *CounterStore {
+	return &CounterStore{Client: client, Prefix: DefaultPrefix}
+}
+
+func (s *CounterStore) Incr(ctx context.Context, key string, window time.Duration) (int64, time.Time, error) {
+	start := time.Now().Truncate(window)
+	reset := start.Add(window)
+	rkey := s.windowKey(key, start)
+
+	var incr *redis.IntCmd
+	if _, err := s.Client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		incr = p.Incr(ctx, rkey)
+		p.PExpireAt(ctx, rkey, reset)
+		return nil
+	}); err != nil {
+		return 0, reset, err
+	}
+	return incr.Val(), reset, nil
+}
+
+func (s *CounterStore) Count(ctx context.Context, key string, window time.Duration) (int64, time.Time, error) {
+	start := time.Now().Truncate(window)
+	reset := start.Add(window)
+	count, err := s.Client.Get(ctx, s.windowKey(key, start)).Int64()
+	if err == redis.Nil {
+		return 0, reset, nil
+	}
+	return count, reset, err
+}
+
+// KEYS[1] counter, decreased only if it exists and is positive, so no counter without expiry is created
+var decrScript = redis.NewScript(`
+if tonumber(redis.call('GET', KEYS[1]) or '0') > 0 then
+	return redis.call('DECR', KEYS[1])
+end
+return 0
+`)
+
+func (s *CounterStore) Decr(ctx context.Context, key string, window time.Duration) error {
+	return decrScript.Run(ctx, s.Client, []string{s.windowKey(key, time.Now().Truncate(window))}).Err()
+}
+
+func (s *CounterStore) Reset(ctx context.Context, key string, window time.Duration) error {
+	return s.Client.Del(ctx, s.windowKey(key, time.Now().Truncate(window))).Err()
+}
+
+func (s *CounterStore) windowKey(key string, start time.Time) string {
+	return s.Prefix + key + ":" + strconv.FormatInt(start.Unix(), 10)
+}
+
+var _ api.RateLimitStore = &RateLimitStore{}
+
+// RateLimitStore is an api.RateLimitStore, buckets are hashes updated by a script atomically.
+type RateLimitStore struct {
+	Client redis.UniversalClient
+	Prefix string
+}
+
+func NewRateLimitStore(client redis.UniversalClient) *RateLimitStore {
+	return &RateLimitStore{Client: client, Prefix: DefaultPrefix}
+}
+
+// KEYS[1] bucket, ARGV rate(per second) burst now(ms)
+// returns allowed(0/1) and the tokens left
+var takeScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'last')
+local tokens = tonumber(bucket[1])
+local last = tonumber(bucket[2])
+if tokens == nil or last == nil then
+	tokens, last = burst, now
+end
+tokens = math.min(burst, tokens + math.max(0, now - last) / 1000 * rate)
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'last', tostring(now))
+local ttl = 86400000
+if rate > 0 then
+	ttl = math.ceil((burst - tokens) / rate * 1000) + 1000
+end
+redis.call('PEXPIRE', KEYS[1], ttl)
+return {allowed, tostring(tokens)}
+`)
+
+func (s *RateLimitStore) Take(ctx context.Context, key string, rate float64, burst int) (bool, int64, time.Time, error) {
+	now := time.Now()
+	ret, err := takeScript.Run(ctx, s.Client, []string{s.Prefix + key}, rate, burst, now.UnixMilli()).Slice()
+	if err != nil {
+		return false, 0, now, err
+	}
+	allowed, _ := ret[0].(int64)
+	tokenstr, _ := ret[1].(string)
+	tokens, err := strconv.ParseFloat(tokenstr, 64)
+	if err != nil {
+		return false, 0, now, err
+	}
+	remaining := int64(tokens)
+	if rate <= 0 {
+		return allowed == 1, remaining, now, nil
+	}
+	var wait float64
+	if remaining == 0 {
+		wait = (1 - tokens) / rate
+	} else {
+		wait = (float64(burst) - tokens) / rate
+	}
+	return allowed == 1, remaining, now.Add(time.Duration(math.Max(wait, 0) * float64(time.Second))), nil
+}
+
+var _ api.Cache[any] = &Cache[any]{}
+
+// Cache is an api.Cache stores json encoded values,
+// keys are hashed as they may contain credentials, e.g. tokens.
+// Errors are treated as cache misses.
+type Cache[T any] struct {
+	Client redis.UniversalClient
+	Prefix string
+	TTL    time.Duration
+}
+
+// NewCache returns a cache, prefix distinguishes caches of different types, e.g. "authn:".
+func NewCache[T any](client redis.UniversalClient, prefix string, ttl time.Duration) *Cache[T] {
+	return &Cache[T]{Client: client, Prefix: DefaultPrefix + prefix, TTL: ttl}
+}
+
+func (c *Cache[T]) Get(ctx context.Context, key string) (T, bool) {
+	var val T
+	data, err := c.Client.Get(ctx, c.key(key)).Bytes()
+	if err != nil {
+		return val, false
+	}
+	if err := json.Unmarshal(data, &val); err != nil {
+		return val, false
+	}
+	return val, true
+}
+
+func (c *Cache[T]) Add(ctx context.Context, key string, val T) {
+	data, err := json.Marshal(val)
+	if err != nil {
+		return
+	}
+	c.Client.Set(ctx, c.key(key), data, c.TTL)
+}
+
+func (c *Cache[T]) Remove(ctx context.Context, key string) {
+	c.Client.Del(ctx, c.key(key))
+}
+
+func (c *Cache[T]) key(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return c.Prefix + hex.EncodeToString(sum[:])
+}
+
+var _ api.SessionStore = &SessionStore{}
+
+// SessionStore is an api.SessionStore, sessions expire with the keys.
+type SessionStore struct {
+	Client redis.UniversalClient
+	Prefix string
+}
+
+func NewSessionStore(client redis.UniversalClient) *SessionStore {
+	return &SessionStore{Client: client, Prefix: DefaultPrefix + "session:"}
+}
+
+func (s *SessionStore) Get(ctx context.Context, id string) (*api.SessionData, error) {
+	data, err := s.Client.Get(ctx, s.Prefix+id).Bytes()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, api.ErrSessionNotFound
+		}
+		return nil, err
+	}
+	session := &api.SessionData{}
+	if err := json.Unmarshal(data, session); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+func (s *SessionStore) Set(ctx context.Context, session *api.SessionData) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	ttl := time.Until(session.ExpiresAt)
+	if ttl <= 0 {
+		return s.Client.Del(ctx, s.Prefix+session.ID).Err()
+	}
+	return s.Client.Set(ctx, s.Prefix+session.ID, data, ttl).Err()
+}
+
+func (s *SessionStore) Delete(ctx context.Context, id string) error {
+	return s.Client.Del(ctx, s.Prefix+id).Err()
+}
+
+var _ api.IdempotencyStore = &IdempotencyStore{}
+
+// IdempotencyStore is an api.IdempotencyStore, a locked key holds an empty value until the response saved.
+type IdempotencyStore struct {
+	Client redis.UniversalClient
+	Prefix string
+}
+
+func NewIdempotencyStore(client redis.UniversalClient) *IdempotencyStore {
+	return &IdempotencyStore{Client: client, Prefix: DefaultPrefix}
+}
+
+// KEYS[1] key, ARGV ttl(ms)
+// returns the stored value, or nil if locked
+var idempotencyLockScript = redis.NewScript(`
+local val = redis.call('GET', KEYS[1])
+if val then
+	return val
+end
+redis.call('SET', KEYS[1], '', 'PX', ARGV[1])
+return false
+`)
+
+// KEYS[1] key
+var idempotencyUnlockScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == '' then
+	redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+func (s *IdempotencyStore) Lock(ctx context.Context, key string, ttl time.Duration) (*api.IdempotentResponse, error) {
+	data, err := idempotencyLockScript.Run(ctx, s.Client, []string{s.Prefix + key}, ttl.Milliseconds()).Text()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if data == "" {
+		return nil, api.ErrIdempotencyKeyInUse
+	}
+	resp := &api.IdempotentResponse{}
+	if err := json.Unmarshal([]byte(data), resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (s *IdempotencyStore) Save(ctx context.Context, key string, resp *api.IdempotentResponse, ttl time.Duration) error {
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	return s.Client.Set(ctx, s.Prefix+key, data, ttl).Err()
+}
+
+func (s *IdempotencyStore) Unlock(ctx context.Context, key string) error {
+	return idempotencyUnlockScript.Run(ctx, s.Client, []string{s.Prefix + key}).Err()
+}
@@ -0,0 +1,156 @@
+package redisstore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"kubegems.io/library/rest/api"
+)
+
+func newClient(t *testing.T) redis.UniversalClient {
+	s := miniredis.RunT(t)
+	return redis.NewClient(&redis.Options{Addr: s.Addr()})
+}
+
+func TestCounterStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewCounterStore(newClient(t))
+	for i := int64(1); i <= 3; i++ {
+		count, reset, err := store.Incr(ctx, "user", time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != i {
+			t.Errorf("Incr() count = %v, want %v", count, i)
+		}
+		if !reset.After(time.Now()) {
+			t.Errorf("Incr() reset = %v, want in future", reset)
+		}
+	}
+}
+
+func TestCounterStoreDecrReset(t *testing.T) {
+	ctx := context.Background()
+	store := NewCounterStore(newClient(t))
+	// a missing counter is not created
+	if err := store.Decr(ctx, "ip", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if count, _, _ := store.Count(ctx, "ip", time.Hour); count != 0 {
+		t.Errorf("Count() after Decr of missing = %v, want 0", count)
+	}
+	for i := 0; i < 3; i++ {
+		_, _, _ = store.Incr(ctx, "ip", time.Hour)
+	}
+	if err := store.Decr(ctx, "ip", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if count, _, _ := store.Count(ctx, "ip", time.Hour); count != 2 {
+		t.Errorf("Count() after Decr = %v, want 2", count)
+	}
+	if err := store.Reset(ctx, "ip", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if count, _, _ := store.Count(ctx, "ip", time.Hour); count != 0 {
+		t.Errorf("Count() after Reset = %v, want 0", count)
+	}
+}
+
+func TestRateLimitStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewRateLimitStore(newClient(t))
+	tests := []struct {
+		allowed   bool
+		remaining int64
+	}{
+		{allowed: true, remaining: 1},
+		{allowed: true, remaining: 0},
+		{allowed: false, remaining: 0},
+	}
+	for i, tt := range tests {
+		allowed, remaining, _, err := store.Take(ctx, "user", 0.001, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if allowed != tt.allowed || remaining != tt.remaining {
+			t.Errorf("Take() #%d = %v, %v, want %v, %v", i, allowed, remaining, tt.allowed, tt.remaining)
+		}
+	}
+}
+
+func TestCache(t *testing.T) {
+	ctx := context.Background()
+	cache := NewCache[*api.AuthenticateInfo](newClient(t), "authn:", time.Minute)
+	calls := 0
+	fn := func() (*api.AuthenticateInfo, error) {
+		calls++
+		return &api.AuthenticateInfo{User: api.UserInfo{Name: "alice"}}, nil
+	}
+	for i := 0; i < 2; i++ {
+		info, err := api.GetOrAdd[*api.AuthenticateInfo](ctx, cache, "token", fn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.User.Name != "alice" {
+			t.Errorf("GetOrAdd() user = %v, want alice", info.User.Name)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("GetOrAdd() calls = %v, want 1", calls)
+	}
+}
+
+func TestSessionStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewSessionStore(newClient(t))
+	session := &api.SessionData{ID: "abc", User: api.UserInfo{Name: "alice"}, ExpiresAt: time.Now().Add(time.Minute)}
+	if err := store.Set(ctx, session); err != nil {
+		t.Fatal(err)
+	}
+	got, err := store.Get(ctx, "abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.User.Name != "alice" {
+		t.Errorf("Get() user = %v, want alice", got.User.Name)
+	}
+	if err := store.Delete(ctx, "abc"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(ctx, "abc"); err != api.ErrSessionNotFound {
+		t.Errorf("Get() after Delete err = %v, want %v", err, api.ErrSessionNotFound)
+	}
+}
+
+func TestIdempotencyStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewIdempotencyStore(newClient(t))
+	if resp, err := store.Lock(ctx, "key", time.Minute); resp != nil || err != nil {
+		t.Fatalf("Lock() = %v, %v, want locked", resp, err)
+	}
+	if _, err := store.Lock(ctx, "key", time.Minute); err != api.ErrIdempotencyKeyInUse {
+		t.Errorf("Lock() locked key err = %v, want %v", err, api.ErrIdempotencyKeyInUse)
+	}
+	if err := store.Unlock(ctx, "key"); err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := store.Lock(ctx, "key", time.Minute); resp != nil || err != nil {
+		t.Fatalf("Lock() after Unlock = %v, %v, want locked", resp, err)
+	}
+	if err := store.Save(ctx, "key", &api.IdempotentResponse{Code: 201, Body: []byte("created")}, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Unlock(ctx, "key"); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := store.Lock(ctx, "key", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || resp.Code != 201 || string(resp.Body) != "created" {
+		t.Errorf("Lock() saved key = %+v, want the saved response", resp)
+	}
+}
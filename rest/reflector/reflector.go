@@ -23,7 +23,6 @@ import (
 	"strconv"
 	"strings"
 
-	libreflect "kubegems.io/library/reflect"
 	"kubegems.io/library/rest/api"
 	"kubegems.io/library/rest/request"
 	"kubegems.io/library/rest/response"
@@ -257,11 +256,11 @@ func prepareCallArgs(r *http.Request, arg0 reflect.Value, args []Argv) ([]reflec
 			if err := decodeBody(r, body); err != nil {
 				return nil, err
 			}
-			callargs = append(callargs, body.Elem())
+			callargs = append(callargs, body)
 		case arglocQuery:
 			query := reflect.New(arg.Typ)
-			for k := range queries {
-				_ = libreflect.SetFiledValue(query.Interface(), k, queries.Get(k))
+			if err := request.DecodeValues(queries, query.Interface()); err != nil {
+				return nil, err
 			}
 			callargs = append(callargs, query.Elem())
 		}
@@ -271,7 +270,7 @@ func prepareCallArgs(r *http.Request, arg0 reflect.Value, args []Argv) ([]reflec
 
 func decodeBody(r *http.Request, v reflect.Value) error {
 	if r.Body == nil || r.ContentLength == 0 {
-		return nil
+		return request.ValidateBody(r, v.Interface())
 	}
 	switch v.Interface().(type) {
 	case io.Reader:
@@ -285,6 +284,10 @@ func decodeBody(r *http.Request, v reflect.Value) error {
 		v.SetBytes(b)
 		return nil
 	default:
-		return request.Body(r, v.Addr().Interface())
+		if err := request.DecodeBody(r, v.Addr().Interface()); err != nil {
+			return err
+		}
+		// validate the decoded value rather than its address, so pointer bodies are validated as well
+		return request.ValidateBody(r, v.Interface())
 	}
 }
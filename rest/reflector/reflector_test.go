@@ -16,9 +16,14 @@ package reflector
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+
+	"kubegems.io/library/rest/request"
 )
 
 type SampleRequest struct {
@@ -67,3 +72,57 @@ func TestRegisterController(t *testing.T) {
 
 	got[0].Handler.ServeHTTP(resp, req)
 }
+
+type CreateCageRequest struct {
+	Name string `json:"name" validate:"required"`
+	Size int    `json:"size" validate:"gte=1"`
+}
+
+type CageController struct{}
+
+func (c *CageController) CreateCage(ctx context.Context, req *CreateCageRequest) (any, error) {
+	return req.Name, nil
+}
+
+func TestDecodeBodyValidation(t *testing.T) {
+	handlers, err := RegisterController("v1", nil, &CageController{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		body       string
+		wantStatus int
+		wantFields []string
+	}{
+		{body: `{"name":"a","size":1}`, wantStatus: http.StatusOK},
+		{body: `{"size":0}`, wantStatus: http.StatusBadRequest, wantFields: []string{"name", "size"}},
+		{body: `{"name":"a"`, wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.body, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/v1/cages", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			resp := httptest.NewRecorder()
+			handlers[0].Handler.ServeHTTP(resp, req)
+			if resp.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", resp.Code, tt.wantStatus, resp.Body.String())
+			}
+			if len(tt.wantFields) == 0 {
+				return
+			}
+			got := struct {
+				Error request.FieldErrors `json:"error"`
+			}{}
+			if err := json.Unmarshal(resp.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			fields := []string{}
+			for _, fe := range got.Error {
+				fields = append(fields, fe.Field)
+			}
+			if strings.Join(fields, ",") != strings.Join(tt.wantFields, ",") {
+				t.Errorf("fields = %v, want %v", fields, tt.wantFields)
+			}
+		})
+	}
+}
@@ -0,0 +1,486 @@
+// Copyright 2023 The Kubegems Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package request
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const (
+	MediaTypeJSONPatch  = "application/json-patch+json"
+	MediaTypeMergePatch = "application/merge-patch+json"
+)
+
+// MaxPatchSize limits the decompressed patch body read by Patch, a larger body is an *http.MaxBytesError.
+var MaxPatchSize int64 = 1 << 20
+
+// PatchType returns the patch media type of request by Content-Type,
+// application/json and empty are treated as JSON Merge Patch.
+func PatchType(r *http.Request) (string, error) {
+	mediatype, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediatype {
+	case MediaTypeJSONPatch:
+		return MediaTypeJSONPatch, nil
+	case MediaTypeMergePatch, "application/json", "":
+		return MediaTypeMergePatch, nil
+	default:
+		return "", fmt.Errorf("unsupported patch type: %s", mediatype)
+	}
+}
+
+// Patch applies the request body onto into by PatchType and validates the result with ValidateBody,
+// into must be a pointer to the current object, e.g. loaded from database.
+func Patch(r *http.Request, into any) error {
+	patchtype, err := PatchType(r)
+	if err != nil {
+		return err
+	}
+	body, err := decompressedBody(r)
+	if err != nil {
+		return err
+	}
+	patch, err := io.ReadAll(io.LimitReader(body, MaxPatchSize+1))
+	if err != nil {
+		return err
+	}
+	if int64(len(patch)) > MaxPatchSize {
+		return &http.MaxBytesError{Limit: MaxPatchSize}
+	}
+	switch patchtype {
+	case MediaTypeJSONPatch:
+		err = ApplyJSONPatch(into, patch)
+	default:
+		err = ApplyMergePatch(into, patch)
+	}
+	if err != nil {
+		return err
+	}
+	return ValidateBody(r, into)
+}
+
+// ApplyMergePatch applies a JSON Merge Patch (RFC 7386) onto into.
+// Fields are matched by json tags, patching an unknown field is an error.
+func ApplyMergePatch(into any, patch []byte) error {
+	var p any
+	if err := decodeJSON(patch, &p); err != nil {
+		return fmt.Errorf("invalid merge patch: %w", err)
+	}
+	return patchObject(into, func(doc any) (any, error) {
+		return MergePatch(doc, p), nil
+	})
+}
+
+// MergePatch merges patch into the decoded json document doc, null values in patch remove the keys.
+func MergePatch(doc, patch any) any {
+	p, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	d, ok := doc.(map[string]any)
+	if !ok {
+		d = map[string]any{}
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(d, k)
+		} else {
+			d[k] = MergePatch(d[k], v)
+		}
+	}
+	return d
+}
+
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// ApplyJSONPatch applies a JSON Patch (RFC 6902) onto into, operations are applied atomically,
+// into is not modified if any operation fails.
+// Errors of operations are FieldErrors with field paths like "spec.items[0]".
+func ApplyJSONPatch(into any, patch []byte) error {
+	ops := []PatchOperation{}
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		return fmt.Errorf("invalid json patch: %w", err)
+	}
+	return patchObject(into, func(doc any) (any, error) {
+		for _, op := range ops {
+			var err error
+			if doc, err = applyOperation(doc, op); err != nil {
+				return nil, FieldErrors{{Field: fieldPath(op.Path), Message: op.Op + ": " + err.Error()}}
+			}
+		}
+		return doc, nil
+	})
+}
+
+// patchObject encodes into as a json document, patches it and decodes the result back into into,
+// the fields not encoded, e.g. unexported or tagged `json:"-"`, keep their values.
+func patchObject(into any, patch func(doc any) (any, error)) error {
+	v := reflect.ValueOf(into)
+	if v.Kind() != reflect.Pointer || v.IsNil() {
+		return fmt.Errorf("patch target must be a non-nil pointer, got %T", into)
+	}
+	data, err := json.Marshal(into)
+	if err != nil {
+		return err
+	}
+	var doc any
+	if err := decodeJSON(data, &doc); err != nil {
+		return err
+	}
+	patched, err := patch(doc)
+	if err != nil {
+		return err
+	}
+	if data, err = json.Marshal(patched); err != nil {
+		return err
+	}
+	// decode into a new value so that removed map keys and fields are not kept
+	result := reflect.New(v.Elem().Type())
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(result.Interface()); err != nil {
+		return fmt.Errorf("apply patch: %w", err)
+	}
+	setJSONFields(v.Elem(), result.Elem())
+	return nil
+}
+
+var (
+	jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// setJSONFields sets the values of dst decoded from json to those of src and keeps the others,
+// e.g. unexported fields or tagged `json:"-"`. Structs are set field by field,
+// and pointers to them are copied before, so the values shared with the original are not modified.
+func setJSONFields(dst, src reflect.Value) {
+	if decodedAsWhole(dst.Type()) {
+		dst.Set(src) // e.g. time.Time
+		return
+	}
+	switch dst.Kind() {
+	case reflect.Struct:
+		for i := 0; i < dst.NumField(); i++ {
+			if field := dst.Type().Field(i); field.Anonymous && !field.IsExported() {
+				// promoted fields of unexported embedded structs can not be set one by one
+				dst.Set(src)
+				return
+			}
+		}
+		for i := 0; i < dst.NumField(); i++ {
+			if field := dst.Type().Field(i); field.IsExported() && field.Tag.Get("json") != "-" {
+				setJSONFields(dst.Field(i), src.Field(i))
+			}
+		}
+	case reflect.Pointer:
+		if dst.IsNil() || src.IsNil() || dst.Elem().Kind() != reflect.Struct {
+			dst.Set(src)
+			return
+		}
+		copied := reflect.New(dst.Elem().Type())
+		copied.Elem().Set(dst.Elem())
+		setJSONFields(copied.Elem(), src.Elem())
+		dst.Set(copied)
+	default:
+		dst.Set(src)
+	}
+}
+
+func decodedAsWhole(t reflect.Type) bool {
+	pt := reflect.PointerTo(t)
+	return pt.Implements(jsonUnmarshalerType) || pt.Implements(textUnmarshalerType)
+}
+
+// decodeJSON keeps numbers as json.Number to avoid losing precision of large integers.
+func decodeJSON(data []byte, into any) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(into)
+}
+
+func applyOperation(doc any, op PatchOperation) (any, error) {
+	path, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+	var value any
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return nil, fmt.Errorf("missing value")
+		}
+		if err := decodeJSON(op.Value, &value); err != nil {
+			return nil, err
+		}
+	case "move", "copy":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+		if value, err = getPointer(doc, from); err != nil {
+			return nil, fmt.Errorf("from %s: %w", op.From, err)
+		}
+		if op.Op == "move" {
+			if op.From == op.Path {
+				return doc, nil
+			}
+			if strings.HasPrefix(op.Path, op.From+"/") {
+				return nil, fmt.Errorf("can not move into a child of itself")
+			}
+			if doc, err = removePointer(doc, from); err != nil {
+				return nil, err
+			}
+		} else {
+			value = deepCopy(value)
+		}
+	}
+	switch op.Op {
+	case "add", "move", "copy":
+		return addPointer(doc, path, value)
+	case "remove":
+		return removePointer(doc, path)
+	case "replace":
+		if _, err := getPointer(doc, path); err != nil {
+			return nil, err
+		}
+		if len(path) == 0 {
+			return value, nil
+		}
+		if doc, err = removePointer(doc, path); err != nil {
+			return nil, err
+		}
+		return addPointer(doc, path, value)
+	case "test":
+		actual, err := getPointer(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !reflect.DeepEqual(normalizeNumbers(actual), normalizeNumbers(value)) {
+			return nil, fmt.Errorf("value not equal")
+		}
+		return doc, nil
+	default:
+		return nil, fmt.Errorf("unsupported operation")
+	}
+}
+
+// parsePointer parses a JSON Pointer (RFC 6901), empty pointer is the whole document.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("invalid pointer %q", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+	}
+	return tokens, nil
+}
+
+// fieldPath converts a JSON Pointer to the field path of package reflect, e.g. "/spec/items/0" to "spec.items[0]".
+func fieldPath(pointer string) string {
+	tokens, err := parsePointer(pointer)
+	if err != nil {
+		return pointer
+	}
+	sb := strings.Builder{}
+	for _, token := range tokens {
+		if _, err := strconv.Atoi(token); err == nil || token == "-" {
+			sb.WriteString("[" + token + "]")
+			continue
+		}
+		if sb.Len() > 0 {
+			sb.WriteByte('.')
+		}
+		sb.WriteString(token)
+	}
+	return sb.String()
+}
+
+func getPointer(doc any, path []string) (any, error) {
+	for _, token := range path {
+		switch container := doc.(type) {
+		case map[string]any:
+			val, ok := container[token]
+			if !ok {
+				return nil, fmt.Errorf("path not found")
+			}
+			doc = val
+		case []any:
+			i, err := arrayIndex(token, len(container)-1)
+			if err != nil {
+				return nil, err
+			}
+			doc = container[i]
+		default:
+			return nil, fmt.Errorf("path not found")
+		}
+	}
+	return doc, nil
+}
+
+// updateParent replaces the parent container of path with update(parent, last token).
+func updateParent(doc any, path []string, update func(parent any, key string) (any, error)) (any, error) {
+	if len(path) == 1 {
+		return update(doc, path[0])
+	}
+	switch container := doc.(type) {
+	case map[string]any:
+		child, ok := container[path[0]]
+		if !ok {
+			return nil, fmt.Errorf("path not found")
+		}
+		child, err := updateParent(child, path[1:], update)
+		if err != nil {
+			return nil, err
+		}
+		container[path[0]] = child
+		return container, nil
+	case []any:
+		i, err := arrayIndex(path[0], len(container)-1)
+		if err != nil {
+			return nil, err
+		}
+		child, err := updateParent(container[i], path[1:], update)
+		if err != nil {
+			return nil, err
+		}
+		container[i] = child
+		return container, nil
+	default:
+		return nil, fmt.Errorf("path not found")
+	}
+}
+
+func addPointer(doc any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	return updateParent(doc, path, func(parent any, key string) (any, error) {
+		switch container := parent.(type) {
+		case map[string]any:
+			container[key] = value
+			return container, nil
+		case []any:
+			if key == "-" {
+				return append(container, value), nil
+			}
+			i, err := arrayIndex(key, len(container))
+			if err != nil {
+				return nil, err
+			}
+			container = append(container, nil)
+			copy(container[i+1:], container[i:])
+			container[i] = value
+			return container, nil
+		default:
+			return nil, fmt.Errorf("parent is not an object or array")
+		}
+	})
+}
+
+func removePointer(doc any, path []string) (any, error) {
+	if len(path) == 0 {
+		return nil, fmt.Errorf("can not remove the whole document")
+	}
+	return updateParent(doc, path, func(parent any, key string) (any, error) {
+		switch container := parent.(type) {
+		case map[string]any:
+			if _, ok := container[key]; !ok {
+				return nil, fmt.Errorf("path not found")
+			}
+			delete(container, key)
+			return container, nil
+		case []any:
+			i, err := arrayIndex(key, len(container)-1)
+			if err != nil {
+				return nil, err
+			}
+			return append(container[:i], container[i+1:]...), nil
+		default:
+			return nil, fmt.Errorf("path not found")
+		}
+	})
+}
+
+func arrayIndex(token string, last int) (int, error) {
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || (token != "0" && strings.HasPrefix(token, "0")) {
+		return 0, fmt.Errorf("invalid array index %s", token)
+	}
+	if i > last {
+		return 0, fmt.Errorf("array index %d out of range", i)
+	}
+	return i, nil
+}
+
+func deepCopy(v any) any {
+	switch val := v.(type) {
+	case map[string]any:
+		m := make(map[string]any, len(val))
+		for k, item := range val {
+			m[k] = deepCopy(item)
+		}
+		return m
+	case []any:
+		s := make([]any, len(val))
+		for i, item := range val {
+			s[i] = deepCopy(item)
+		}
+		return s
+	default:
+		return v
+	}
+}
+
+// normalizeNumbers converts json.Number to float64 so that 1 equals 1.0.
+func normalizeNumbers(v any) any {
+	switch val := v.(type) {
+	case json.Number:
+		if f, err := val.Float64(); err == nil {
+			return f
+		}
+		return val
+	case map[string]any:
+		m := make(map[string]any, len(val))
+		for k, item := range val {
+			m[k] = normalizeNumbers(item)
+		}
+		return m
+	case []any:
+		s := make([]any, len(val))
+		for i, item := range val {
+			s[i] = normalizeNumbers(item)
+		}
+		return s
+	default:
+		return v
+	}
+}
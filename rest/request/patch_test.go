@@ -0,0 +1,222 @@
+package request
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type patchItem struct {
+	Name  string `json:"name"`
+	Value int64  `json:"value,omitempty"`
+}
+
+type patchTarget struct {
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Items  []patchItem       `json:"items,omitempty"`
+}
+
+func TestApplyMergePatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		patch   string
+		want    patchTarget
+		wantErr bool
+	}{
+		{
+			name:  "set and remove",
+			patch: `{"labels":{"a":null,"c":"3"},"items":[{"name":"y","value":9007199254740993}]}`,
+			want:  patchTarget{Name: "obj", Labels: map[string]string{"b": "2", "c": "3"}, Items: []patchItem{{Name: "y", Value: 9007199254740993}}},
+		},
+		{
+			name:  "remove field",
+			patch: `{"labels":null}`,
+			want:  patchTarget{Name: "obj", Items: []patchItem{{Name: "x"}}},
+		},
+		{
+			name:    "unknown field",
+			patch:   `{"unknown":1}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj := patchTarget{Name: "obj", Labels: map[string]string{"a": "1", "b": "2"}, Items: []patchItem{{Name: "x"}}}
+			err := ApplyMergePatch(&obj, []byte(tt.patch))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ApplyMergePatch() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(obj, tt.want) {
+				t.Errorf("ApplyMergePatch() = %v, want %v", obj, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyJSONPatch(t *testing.T) {
+	tests := []struct {
+		name      string
+		patch     string
+		want      patchTarget
+		wantField string
+	}{
+		{
+			name:  "add replace remove",
+			patch: `[{"op":"add","path":"/items/-","value":{"name":"y"}},{"op":"replace","path":"/name","value":"new"},{"op":"remove","path":"/labels/a"}]`,
+			want:  patchTarget{Name: "new", Labels: map[string]string{}, Items: []patchItem{{Name: "x"}, {Name: "y"}}},
+		},
+		{
+			name:  "insert copy move test",
+			patch: `[{"op":"add","path":"/items/0","value":{"name":"w","value":1}},{"op":"copy","from":"/items/0","path":"/items/-"},{"op":"move","from":"/labels/a","path":"/labels/b"},{"op":"test","path":"/items/2/value","value":1.0}]`,
+			want:  patchTarget{Name: "obj", Labels: map[string]string{"b": "1"}, Items: []patchItem{{Name: "w", Value: 1}, {Name: "x"}, {Name: "w", Value: 1}}},
+		},
+		{
+			name:      "failed test keeps object",
+			patch:     `[{"op":"replace","path":"/name","value":"new"},{"op":"test","path":"/items/0/name","value":"y"}]`,
+			wantField: "items[0].name",
+		},
+		{
+			name:      "out of range",
+			patch:     `[{"op":"remove","path":"/items/1"}]`,
+			wantField: "items[1]",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj := patchTarget{Name: "obj", Labels: map[string]string{"a": "1"}, Items: []patchItem{{Name: "x"}}}
+			orig := obj
+			err := ApplyJSONPatch(&obj, []byte(tt.patch))
+			if tt.wantField != "" {
+				fes, ok := err.(FieldErrors)
+				if !ok || fes[0].Field != tt.wantField {
+					t.Fatalf("ApplyJSONPatch() error = %v, want field %s", err, tt.wantField)
+				}
+				if !reflect.DeepEqual(obj, orig) {
+					t.Errorf("object modified on error: %v", obj)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(obj, tt.want) {
+				t.Errorf("ApplyJSONPatch() = %v, want %v", obj, tt.want)
+			}
+		})
+	}
+}
+
+func TestPatchType(t *testing.T) {
+	tests := []struct {
+		contentType string
+		want        string
+		wantErr     bool
+	}{
+		{contentType: "application/json-patch+json", want: MediaTypeJSONPatch},
+		{contentType: "application/merge-patch+json; charset=utf-8", want: MediaTypeMergePatch},
+		{contentType: "", want: MediaTypeMergePatch},
+		{contentType: "application/strategic-merge-patch+json", wantErr: true},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPatch, "/", strings.NewReader("{}"))
+		r.Header.Set("Content-Type", tt.contentType)
+		got, err := PatchType(r)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("PatchType(%q) = %v, %v, want %v", tt.contentType, got, err, tt.want)
+		}
+	}
+}
+
+type patchSpec struct {
+	Replicas int    `json:"replicas"`
+	Secret   string `json:"-"`
+}
+
+type patchModel struct {
+	Name     string            `json:"name"`
+	Password string            `json:"-"`
+	Spec     *patchSpec        `json:"spec,omitempty"`
+	Created  time.Time         `json:"created"`
+	Deleted  *time.Time        `json:"deleted,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	internal int
+}
+
+func TestApplyMergePatchKeepsHiddenFields(t *testing.T) {
+	created, deleted := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2023, 2, 1, 0, 0, 0, 0, time.UTC)
+	newModel := func() patchModel {
+		return patchModel{
+			Name: "a", Password: "hash", internal: 7,
+			Spec:    &patchSpec{Replicas: 1, Secret: "s"},
+			Created: created, Deleted: &deleted,
+			Labels: map[string]string{"a": "1"},
+		}
+	}
+	tests := []struct {
+		name    string
+		patch   string
+		want    patchModel
+		wantErr bool
+	}{
+		{
+			name:  "hidden fields kept",
+			patch: `{"name":"b","spec":{"replicas":2}}`,
+			want: patchModel{
+				Name: "b", Password: "hash", internal: 7,
+				Spec:    &patchSpec{Replicas: 2, Secret: "s"},
+				Created: created, Deleted: &deleted,
+				Labels: map[string]string{"a": "1"},
+			},
+		},
+		{
+			name:  "fields removed",
+			patch: `{"deleted":null,"labels":null,"created":null}`,
+			want: patchModel{
+				Name: "a", Password: "hash", internal: 7,
+				Spec: &patchSpec{Replicas: 1, Secret: "s"},
+			},
+		},
+		{
+			name:    "invalid value",
+			patch:   `{"labels":{"b":"2"},"spec":{"replicas":"x"}}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj := newModel()
+			spec, labels := obj.Spec, obj.Labels
+			err := ApplyMergePatch(&obj, []byte(tt.patch))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ApplyMergePatch() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				tt.want = newModel()
+			}
+			if !reflect.DeepEqual(obj, tt.want) {
+				t.Errorf("ApplyMergePatch() = %+v, want %+v", obj, tt.want)
+			}
+			// the values referenced by the original are not modified
+			if !reflect.DeepEqual(*spec, patchSpec{Replicas: 1, Secret: "s"}) || !reflect.DeepEqual(labels, map[string]string{"a": "1"}) {
+				t.Errorf("original modified: %+v %v", *spec, labels)
+			}
+		})
+	}
+}
+
+func TestPatchMaxSize(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPatch, "/", strings.NewReader(`{"name":"`+strings.Repeat("a", int(MaxPatchSize))+`"}`))
+	obj := patchTarget{Name: "obj"}
+	maxerr := &http.MaxBytesError{}
+	if err := Patch(r, &obj); !errors.As(err, &maxerr) {
+		t.Errorf("Patch() error = %v, want *http.MaxBytesError", err)
+	}
+	if obj.Name != "obj" {
+		t.Errorf("Patch() modified the object: %v", obj)
+	}
+}
@@ -19,14 +19,19 @@ import (
 	"compress/zlib"
 	"encoding/json"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
 	"mime"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
 
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+	libreflect "kubegems.io/library/reflect"
 	"sigs.k8s.io/yaml"
 )
 
@@ -91,6 +96,44 @@ func Path[T any](r *http.Request, key string, defaultValue T) T {
 	return ValueOrDefault(PathVars(r).Get(key), defaultValue)
 }
 
+// PathInt returns the path variable key as an integer, the pattern should declare it as {key:int}.
+func PathInt(r *http.Request, key string) (int64, error) {
+	val := PathVars(r).Get(key)
+	i, err := strconv.ParseInt(val, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("path variable %s: invalid integer %q", key, val)
+	}
+	return i, nil
+}
+
+// PathUUID returns the path variable key as a lower case uuid, the pattern should declare it as {key:uuid}.
+func PathUUID(r *http.Request, key string) (string, error) {
+	val := strings.ToLower(PathVars(r).Get(key))
+	if !isUUID(val) {
+		return "", fmt.Errorf("path variable %s: invalid uuid %q", key, val)
+	}
+	return val, nil
+}
+
+func isUUID(s string) bool {
+	if len(s) != 36 {
+		return false
+	}
+	for i, c := range s {
+		switch i {
+		case 8, 13, 18, 23:
+			if c != '-' {
+				return false
+			}
+		default:
+			if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
 func Header[T any](r *http.Request, key string, defaultValue T) T {
 	val := r.Header.Get(key)
 	return ValueOrDefault(val, defaultValue)
@@ -132,24 +175,20 @@ func ValueOrDefault[T any](val string, defaultValue T) T {
 	}
 }
 
+// Body decodes the request body into the value and validates it with ValidateBody.
 func Body(r *http.Request, into any) error {
-	body := r.Body
-	// check if the request body needs decompression
-	switch contentEncoding := r.Header.Get("Content-Encoding"); contentEncoding {
-	case "gzip":
-		reader, err := gzip.NewReader(r.Body)
-		if err != nil {
-			return err
-		}
-		body = reader
-	case "deflate":
-		zlibReader, err := zlib.NewReader(r.Body)
-		if err != nil {
-			return err
-		}
-		body = zlibReader
+	if err := DecodeBody(r, into); err != nil {
+		return err
 	}
+	return ValidateBody(r, into)
+}
 
+// DecodeBody decodes the request body by Content-Encoding and Content-Type without validation.
+func DecodeBody(r *http.Request, into any) error {
+	body, err := decompressedBody(r)
+	if err != nil {
+		return err
+	}
 	mediatype, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
 	switch mediatype {
 	case "application/json", "":
@@ -165,9 +204,49 @@ func Body(r *http.Request, into any) error {
 		if err != nil {
 			return err
 		}
-		return yaml.Unmarshal(data, into)
+		if err := yaml.Unmarshal(data, into); err != nil {
+			return err
+		}
+	case "application/x-www-form-urlencoded":
+		data, err := io.ReadAll(body)
+		if err != nil {
+			return err
+		}
+		values, err := url.ParseQuery(string(data))
+		if err != nil {
+			return err
+		}
+		if err := DecodeValues(values, into); err != nil {
+			return err
+		}
 	default:
 		return fmt.Errorf("unsupported media type: %s", mediatype)
 	}
-	return ValidateBody(r, into)
+	return nil
+}
+
+// decompressedBody returns the request body decompressed by Content-Encoding.
+func decompressedBody(r *http.Request) (io.Reader, error) {
+	switch contentEncoding := r.Header.Get("Content-Encoding"); contentEncoding {
+	case "gzip":
+		return gzip.NewReader(r.Body)
+	case "deflate":
+		return zlib.NewReader(r.Body)
+	default:
+		return r.Body, nil
+	}
+}
+
+// DecodeValues sets query or form values into struct fields by json tag,
+// multiple values of a key are joined with ",", unknown keys are ignored and invalid values are errors.
+func DecodeValues(values url.Values, into any) error {
+	keys := maps.Keys(values)
+	slices.Sort(keys)
+	for _, k := range keys {
+		err := libreflect.SetFiledValue(into, k, strings.Join(values[k], ","))
+		if err != nil && !errors.Is(err, libreflect.ErrFieldNotFound) {
+			return fmt.Errorf("invalid value of %s: %w", k, err)
+		}
+	}
+	return nil
 }
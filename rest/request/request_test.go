@@ -0,0 +1,76 @@
+package request
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type Pagination struct {
+	Page int `json:"page"`
+}
+
+type listOptions struct {
+	Pagination `json:",inline"`
+	Search     string   `json:"search"`
+	Watch      bool     `json:"watch"`
+	Labels     []string `json:"labels"`
+}
+
+func TestDecodeValues(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		want    listOptions
+		wantErr bool
+	}{
+		{name: "values", query: "search=zoo&page=2&watch=true", want: listOptions{Search: "zoo", Pagination: Pagination{Page: 2}, Watch: true}},
+		{name: "multiple values", query: "labels=a&labels=b", want: listOptions{Labels: []string{"a", "b"}}},
+		{name: "unknown keys ignored", query: "page=1&limit=10", want: listOptions{Pagination: Pagination{Page: 1}}},
+		{name: "invalid number of embedded field", query: "page=first", wantErr: true},
+		{name: "invalid bool", query: "watch=maybe", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, _ := url.ParseQuery(tt.query)
+			got := listOptions{}
+			err := DecodeValues(values, &got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DecodeValues() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DecodeValues() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeBodyForm(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    listOptions
+		wantErr bool
+	}{
+		{name: "form", body: "search=zoo&page=3", want: listOptions{Search: "zoo", Pagination: Pagination{Page: 3}}},
+		{name: "invalid value", body: "page=-", wantErr: true},
+		{name: "invalid form", body: "search=%zz", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			got := listOptions{}
+			err := DecodeBody(req, &got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DecodeBody() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DecodeBody() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
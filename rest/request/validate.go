@@ -16,8 +16,27 @@ package request
 
 import (
 	"net/http"
+	"strings"
 )
 
+// ValidateBody validates a decoded request body,
+// it should return FieldErrors so that all invalid fields are reported at once.
 var ValidateBody = func(r *http.Request, data any) error {
 	return nil
 }
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// FieldErrors aggregates the invalid fields of a request body.
+type FieldErrors []FieldError
+
+func (e FieldErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, fe := range e {
+		msgs[i] = fe.Field + ": " + fe.Message
+	}
+	return "invalid fields: " + strings.Join(msgs, "; ")
+}
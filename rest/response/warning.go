@@ -0,0 +1,87 @@
+// Copyright 2022 The kubegems.io Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package response
+
+import (
+	"context"
+	"strings"
+	"sync"
+)
+
+const (
+	HeaderWarning  = "Warning"
+	HeaderXWarning = "X-Warning"
+)
+
+type warningsContextKey struct{}
+
+type warnings struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+// WithWarnings returns a context collects the warnings of Warn, usually set by a filter for each request.
+func WithWarnings(ctx context.Context) context.Context {
+	return context.WithValue(ctx, warningsContextKey{}, &warnings{})
+}
+
+// Warn adds a warning to the response, e.g. a deprecated field is used.
+// Warnings are emitted as headers, so they must be added before the response is written.
+// It does nothing if ctx is not from WithWarnings, duplicated messages are ignored.
+func Warn(ctx context.Context, message string) {
+	w, ok := ctx.Value(warningsContextKey{}).(*warnings)
+	if !ok || message == "" {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, exist := range w.messages {
+		if exist == message {
+			return
+		}
+	}
+	w.messages = append(w.messages, message)
+}
+
+// Warnings returns the warnings added to ctx.
+func Warnings(ctx context.Context) []string {
+	w, ok := ctx.Value(warningsContextKey{}).(*warnings)
+	if !ok {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]string(nil), w.messages...)
+}
+
+// FormatWarning formats message as a RFC 7234 warning value: 299 - "message",
+// 299 is the miscellaneous persistent warning code, the same as kubernetes server-side warnings.
+func FormatWarning(message string) string {
+	sb := strings.Builder{}
+	sb.WriteString(`299 - "`)
+	for _, r := range message {
+		switch {
+		case r == '"' || r == '\\':
+			sb.WriteByte('\\')
+			sb.WriteRune(r)
+		case r == '\t' || (r >= 0x20 && r != 0x7f):
+			sb.WriteRune(r)
+		default:
+			sb.WriteByte(' ') // control characters are not allowed in header values
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
@@ -0,0 +1,162 @@
+// Copyright 2023 The Kubegems Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhooks
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"kubegems.io/library/rest/api"
+	"kubegems.io/library/rest/request"
+	"kubegems.io/library/rest/response"
+)
+
+var _ api.Plugin = &Plugin{}
+
+// Plugin exposes subscription CRUD api under Prefix, default "/webhooks".
+type Plugin struct {
+	api.NoopPlugin
+	Prefix     string
+	Dispatcher *Dispatcher
+	Filters    api.Filters // e.g. authentication and authorization filters
+}
+
+func (p *Plugin) Install(m *api.API) error {
+	prefix := p.Prefix
+	if prefix == "" {
+		prefix = "/webhooks"
+	}
+	m.Group(api.NewGroup(prefix).Tag("webhooks").Filter(p.Filters...).
+		Route(
+			api.GET("/events").Doc("list event types").To(p.listEventTypes).Response(map[string]string{}),
+			api.GET("/subscriptions").Doc("list subscriptions").To(p.listSubscriptions).Response([]Subscription{}),
+			api.POST("/subscriptions").Doc("create subscription").To(p.createSubscription).Param(api.BodyParam("body", Subscription{})).Response(Subscription{}),
+			api.GET("/subscriptions/{id}").Doc("get subscription").To(p.getSubscription).Response(Subscription{}),
+			api.PUT("/subscriptions/{id}").Doc("update subscription").To(p.updateSubscription).Param(api.BodyParam("body", Subscription{})).Response(Subscription{}),
+			api.DELETE("/subscriptions/{id}").Doc("delete subscription").To(p.deleteSubscription),
+		),
+	)
+	return nil
+}
+
+func (p *Plugin) listEventTypes(w http.ResponseWriter, r *http.Request) {
+	response.OK(w, p.Dispatcher.EventTypes())
+}
+
+func (p *Plugin) listSubscriptions(w http.ResponseWriter, r *http.Request) {
+	list, err := p.Dispatcher.Store.List(r.Context())
+	if err != nil {
+		response.Error(w, err)
+		return
+	}
+	for i := range list {
+		list[i].Secret = ""
+	}
+	response.OK(w, list)
+}
+
+func (p *Plugin) getSubscription(w http.ResponseWriter, r *http.Request) {
+	sub, err := p.Dispatcher.Store.Get(r.Context(), request.Path(r, "id", ""))
+	if err != nil {
+		response.Error(w, err)
+		return
+	}
+	sub.Secret = ""
+	response.OK(w, sub)
+}
+
+func (p *Plugin) createSubscription(w http.ResponseWriter, r *http.Request) {
+	sub := &Subscription{}
+	if err := request.Body(r, sub); err != nil {
+		response.BadRequest(w, err.Error())
+		return
+	}
+	if err := p.validate(sub); err != nil {
+		response.Error(w, err)
+		return
+	}
+	sub.ID, sub.CreatedAt = NewID(), time.Now()
+	if err := p.Dispatcher.Store.Create(r.Context(), sub); err != nil {
+		response.Error(w, err)
+		return
+	}
+	sub.Secret = ""
+	response.OK(w, sub)
+}
+
+func (p *Plugin) updateSubscription(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	exists, err := p.Dispatcher.Store.Get(ctx, request.Path(r, "id", ""))
+	if err != nil {
+		response.Error(w, err)
+		return
+	}
+	sub := &Subscription{}
+	if err := request.Body(r, sub); err != nil {
+		response.BadRequest(w, err.Error())
+		return
+	}
+	if err := p.validate(sub); err != nil {
+		response.Error(w, err)
+		return
+	}
+	sub.ID, sub.CreatedAt = exists.ID, exists.CreatedAt
+	if sub.Secret == "" {
+		sub.Secret = exists.Secret // keep secret if not changed
+	}
+	if err := p.Dispatcher.Store.Update(ctx, sub); err != nil {
+		response.Error(w, err)
+		return
+	}
+	sub.Secret = ""
+	response.OK(w, sub)
+}
+
+func (p *Plugin) deleteSubscription(w http.ResponseWriter, r *http.Request) {
+	if err := p.Dispatcher.Store.Delete(r.Context(), request.Path(r, "id", "")); err != nil {
+		response.Error(w, err)
+		return
+	}
+	response.OK(w, "OK")
+}
+
+func (p *Plugin) validate(sub *Subscription) error {
+	for _, eventType := range sub.Events {
+		if eventType != "*" && !p.Dispatcher.registered(eventType) {
+			return response.NewStatusErrorf(http.StatusBadRequest, "unknown event type %q", eventType)
+		}
+	}
+	return nil
+}
+
+var _ api.AuditSink = &AuditSink{}
+
+// AuditSink dispatches audit logs as webhook events, event type is "audit.<action>".
+// Event types must be registered on the dispatcher to be delivered.
+type AuditSink struct {
+	Dispatcher *Dispatcher
+}
+
+func (s *AuditSink) Save(log *api.AuditLog) error {
+	if log.Action == "" {
+		return nil
+	}
+	eventType := "audit." + log.Action
+	if !s.Dispatcher.registered(eventType) {
+		return nil
+	}
+	return s.Dispatcher.Dispatch(context.Background(), eventType, log)
+}
@@ -0,0 +1,91 @@
+// Copyright 2023 The Kubegems Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhooks
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+
+	"kubegems.io/library/rest/response"
+)
+
+type SubscriptionStore interface {
+	List(ctx context.Context) ([]Subscription, error)
+	Get(ctx context.Context, id string) (*Subscription, error)
+	Create(ctx context.Context, sub *Subscription) error
+	Update(ctx context.Context, sub *Subscription) error
+	Delete(ctx context.Context, id string) error
+}
+
+var _ SubscriptionStore = &MemorySubscriptionStore{}
+
+type MemorySubscriptionStore struct {
+	mu    sync.RWMutex
+	items map[string]Subscription
+}
+
+func NewMemorySubscriptionStore() *MemorySubscriptionStore {
+	return &MemorySubscriptionStore{items: map[string]Subscription{}}
+}
+
+func (s *MemorySubscriptionStore) List(ctx context.Context) ([]Subscription, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := make([]Subscription, 0, len(s.items))
+	for _, item := range s.items {
+		list = append(list, item)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+	return list, nil
+}
+
+func (s *MemorySubscriptionStore) Get(ctx context.Context, id string) (*Subscription, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	item, ok := s.items[id]
+	if !ok {
+		return nil, response.NewStatusErrorMessage(http.StatusNotFound, "subscription "+id+" not found")
+	}
+	return &item, nil
+}
+
+func (s *MemorySubscriptionStore) Create(ctx context.Context, sub *Subscription) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.items[sub.ID]; ok {
+		return response.NewStatusErrorMessage(http.StatusConflict, "subscription "+sub.ID+" already exists")
+	}
+	s.items[sub.ID] = *sub
+	return nil
+}
+
+func (s *MemorySubscriptionStore) Update(ctx context.Context, sub *Subscription) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.items[sub.ID]; !ok {
+		return response.NewStatusErrorMessage(http.StatusNotFound, "subscription "+sub.ID+" not found")
+	}
+	s.items[sub.ID] = *sub
+	return nil
+}
+
+func (s *MemorySubscriptionStore) Delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.items, id)
+	return nil
+}
@@ -0,0 +1,286 @@
+// Copyright 2023 The Kubegems Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderID        = "X-Webhook-Id"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+type Event struct {
+	ID   string    `json:"id"`
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	Data any       `json:"data,omitempty"`
+}
+
+type Subscription struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url" validate:"required,url"`
+	Events    []string  `json:"events,omitempty"` // event types, "*" or empty means all
+	Secret    string    `json:"secret,omitempty"` // used to sign payloads
+	Disabled  bool      `json:"disabled,omitempty"`
+	CreatedAt time.Time `json:"createdAt,omitempty"`
+}
+
+func (s Subscription) Accepts(eventType string) bool {
+	if s.Disabled {
+		return false
+	}
+	return len(s.Events) == 0 || slices.Contains(s.Events, "*") || slices.Contains(s.Events, eventType)
+}
+
+// Delivery is a single attempt group to send an event to a subscription.
+type Delivery struct {
+	Event        Event        `json:"event"`
+	Subscription Subscription `json:"subscription"`
+	Attempts     int          `json:"attempts"`
+	LastError    string       `json:"lastError,omitempty"`
+}
+
+// DeadLetterSink receives deliveries which failed after all retries.
+type DeadLetterSink interface {
+	Save(ctx context.Context, delivery Delivery) error
+}
+
+type LoggerDeadLetterSink struct {
+	Logger logr.Logger
+}
+
+func (s LoggerDeadLetterSink) Save(ctx context.Context, delivery Delivery) error {
+	s.Logger.Info("webhook delivery dropped",
+		"event", delivery.Event.Type, "id", delivery.Event.ID,
+		"url", delivery.Subscription.URL, "attempts", delivery.Attempts, "error", delivery.LastError)
+	return nil
+}
+
+type Options struct {
+	Workers        int
+	QueueSize      int
+	MaxRetries     int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Timeout        time.Duration // per request timeout
+}
+
+func NewDefaultOptions() *Options {
+	return &Options{
+		Workers:        4,
+		QueueSize:      1024,
+		MaxRetries:     5,
+		InitialBackoff: time.Second,
+		MaxBackoff:     time.Minute,
+		Timeout:        10 * time.Second,
+	}
+}
+
+type Dispatcher struct {
+	Options    *Options
+	Store      SubscriptionStore
+	DeadLetter DeadLetterSink
+	Client     *http.Client // sends the deliveries, use egress.Factory.Client() to apply the egress policy
+
+	mu         sync.RWMutex
+	eventTypes map[string]string // type -> description
+	queue      chan Delivery
+}
+
+func NewDispatcher(store SubscriptionStore, options *Options) *Dispatcher {
+	if options == nil {
+		options = NewDefaultOptions()
+	}
+	return &Dispatcher{
+		Options:    options,
+		Store:      store,
+		Client:     &http.Client{Timeout: options.Timeout},
+		eventTypes: map[string]string{},
+		queue:      make(chan Delivery, options.QueueSize),
+	}
+}
+
+// Register registers an event type which can be dispatched and subscribed.
+func (d *Dispatcher) Register(eventType string, description string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.eventTypes[eventType] = description
+}
+
+func (d *Dispatcher) EventTypes() map[string]string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	types := make(map[string]string, len(d.eventTypes))
+	for k, v := range d.eventTypes {
+		types[k] = v
+	}
+	return types
+}
+
+func (d *Dispatcher) registered(eventType string) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	_, ok := d.eventTypes[eventType]
+	return ok
+}
+
+// Dispatch queues the event for all subscriptions of the event type.
+func (d *Dispatcher) Dispatch(ctx context.Context, eventType string, data any) error {
+	if !d.registered(eventType) {
+		return fmt.Errorf("unregistered event type %q", eventType)
+	}
+	subscriptions, err := d.Store.List(ctx)
+	if err != nil {
+		return err
+	}
+	event := Event{ID: NewID(), Type: eventType, Time: time.Now(), Data: data}
+	for _, sub := range subscriptions {
+		if !sub.Accepts(eventType) {
+			continue
+		}
+		select {
+		case d.queue <- Delivery{Event: event, Subscription: sub}:
+		default:
+			d.deadLetter(ctx, Delivery{Event: event, Subscription: sub, LastError: "queue full"})
+		}
+	}
+	return nil
+}
+
+// Run starts delivery workers and blocks until ctx done.
+func (d *Dispatcher) Run(ctx context.Context) error {
+	workers := d.Options.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+	wg := sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case delivery := <-d.queue:
+					d.deliver(ctx, delivery)
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, delivery Delivery) {
+	backoff := d.Options.InitialBackoff
+	for {
+		delivery.Attempts++
+		err := d.send(ctx, delivery)
+		if err == nil {
+			return
+		}
+		delivery.LastError = err.Error()
+		if delivery.Attempts > d.Options.MaxRetries {
+			d.deadLetter(ctx, delivery)
+			return
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			d.deadLetter(ctx, delivery)
+			return
+		}
+		if backoff *= 2; d.Options.MaxBackoff > 0 && backoff > d.Options.MaxBackoff {
+			backoff = d.Options.MaxBackoff
+		}
+	}
+}
+
+func (d *Dispatcher) deadLetter(ctx context.Context, delivery Delivery) {
+	sink := d.DeadLetter
+	if sink == nil {
+		sink = LoggerDeadLetterSink{Logger: logr.FromContextOrDiscard(ctx).WithName("webhooks")}
+	}
+	_ = sink.Save(ctx, delivery)
+}
+
+func (d *Dispatcher) send(ctx context.Context, delivery Delivery) error {
+	body, err := json.Marshal(delivery.Event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.Subscription.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, delivery.Event.Type)
+	req.Header.Set(HeaderID, delivery.Event.ID)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	if secret := delivery.Subscription.Secret; secret != "" {
+		req.Header.Set(HeaderSignature, Sign(secret, timestamp, body))
+	}
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<10))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Sign returns the signature of payload, format: "sha256=<hex(hmac-sha256(secret, timestamp + "." + body))>".
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the signature of a received webhook request, used by receivers.
+func Verify(secret string, timestamp string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
+
+func NewID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,70 @@
+package webhooks
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type chanDeadLetterSink chan Delivery
+
+func (c chanDeadLetterSink) Save(ctx context.Context, delivery Delivery) error {
+	c <- delivery
+	return nil
+}
+
+func TestDispatcher(t *testing.T) {
+	received := make(chan bool, 1)
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// fail the first attempt to test retry
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		received <- Verify("secret", r.Header.Get(HeaderTimestamp), body, r.Header.Get(HeaderSignature))
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := NewMemorySubscriptionStore()
+	_ = store.Create(ctx, &Subscription{ID: "ok", URL: server.URL, Events: []string{"zoo.created"}, Secret: "secret"})
+	_ = store.Create(ctx, &Subscription{ID: "bad", URL: "http://127.0.0.1:0", Events: []string{"*"}})
+	_ = store.Create(ctx, &Subscription{ID: "other", URL: server.URL, Events: []string{"zoo.deleted"}})
+
+	deadletters := make(chanDeadLetterSink, 1)
+	d := NewDispatcher(store, &Options{Workers: 2, QueueSize: 10, MaxRetries: 2, InitialBackoff: time.Millisecond})
+	d.DeadLetter = deadletters
+	d.Register("zoo.created", "a zoo created")
+	go d.Run(ctx)
+
+	if err := d.Dispatch(ctx, "zoo.unknown", nil); err == nil {
+		t.Errorf("Dispatch() of unregistered event type expected error")
+	}
+	if err := d.Dispatch(ctx, "zoo.created", map[string]string{"name": "zoo"}); err != nil {
+		t.Fatalf("Dispatch() error = %v", err)
+	}
+	select {
+	case verified := <-received:
+		if !verified {
+			t.Errorf("signature verify failed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for delivery")
+	}
+	select {
+	case delivery := <-deadletters:
+		if delivery.Subscription.ID != "bad" || delivery.Attempts != 3 {
+			t.Errorf("unexpected dead letter %s with %d attempts", delivery.Subscription.ID, delivery.Attempts)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for dead letter")
+	}
+}